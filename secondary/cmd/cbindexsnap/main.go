@@ -0,0 +1,148 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/couchbase/indexing/secondary/indexer"
+)
+
+var options struct {
+	indexer string
+	auth    string
+	instId  uint64
+	verbose bool
+	json    bool
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: cbindexsnap [options] [slice directory...]")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `Examples:
+
+- List the disk snapshots of slice directories, without a running indexer
+    cbindexsnap /opt/couchbase/var/lib/couchbase/data/@2i/default_idx_1234_0.index
+    cbindexsnap -json -verbose /opt/couchbase/var/lib/couchbase/data/@2i/*.index
+
+- List the snapshots of the indexes of a running indexer
+    cbindexsnap -indexer 127.0.0.1:9102 -auth user:pass
+    cbindexsnap -indexer 127.0.0.1:9102 -auth user:pass -instId 1234
+
+Offline, only the snapshots of memory optimized indexes can be listed.`)
+}
+
+func main() {
+	flag.StringVar(&options.indexer, "indexer", "",
+		"host:port of the indexer http endpoint to list the snapshots of")
+	flag.StringVar(&options.auth, "auth", "", "user:pass for the indexer")
+	flag.Uint64Var(&options.instId, "instId", 0,
+		"index instance to list the snapshots of, all if 0")
+	flag.BoolVar(&options.verbose, "verbose", false,
+		"include the full timestamps and stats of the snapshots")
+	flag.BoolVar(&options.json, "json", false, "print the snapshots as json")
+	flag.Usage = usage
+	flag.Parse()
+
+	var metas []*indexer.SnapshotMeta
+	var err error
+	if options.indexer != "" {
+		metas, err = fetchSnapshots()
+	} else if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	} else {
+		for _, path := range flag.Args() {
+			var sliceMetas []*indexer.SnapshotMeta
+			if sliceMetas, err = indexer.InspectSliceSnapshots(path, options.verbose); err != nil {
+				err = fmt.Errorf("%v: %v", path, err)
+				break
+			}
+			metas = append(metas, sliceMetas...)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	if options.json {
+		bs, err := json.MarshalIndent(metas, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(bs))
+		return
+	}
+	printSnapshots(metas)
+}
+
+// fetchSnapshots lists the snapshots through /internal/snapshotInfos.
+func fetchSnapshots() ([]*indexer.SnapshotMeta, error) {
+	url := fmt.Sprintf("http://%v/internal/snapshotInfos?instId=%v&verbose=%v",
+		options.indexer, options.instId, options.verbose)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if options.auth != "" {
+		creds := strings.SplitN(options.auth, ":", 2)
+		if len(creds) != 2 {
+			return nil, fmt.Errorf("invalid -auth, expected user:pass")
+		}
+		req.SetBasicAuth(creds[0], creds[1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var metas []*indexer.SnapshotMeta
+	if err := json.Unmarshal(body, &metas); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+func printSnapshots(metas []*indexer.SnapshotMeta) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INST\tPARTN\tINDEX\tSNAP TYPE\tOSO\tCOMMITTED\tVBUCKETS\tMAX SEQNO\tSEQNO SUM\tITEMS\tSIZE\tPATH")
+	for _, m := range metas {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", m.InstId, m.PartnId,
+			m.Index, m.SnapType, m.OSO, m.Committed, m.NumVbs, m.MaxSeqno, m.SeqnoSum,
+			m.Count, m.Size, m.Path)
+	}
+	w.Flush()
+
+	if options.verbose {
+		for _, m := range metas {
+			if m.Timestamp != nil {
+				fmt.Printf("\nInst %v Partn %v %v\n", m.InstId, m.PartnId, m.Timestamp)
+			}
+		}
+	}
+}
@@ -22,6 +22,7 @@ var (
 	ErrNodeNotBucketMember = errors.New("Node is not a member of bucket")
 	ErrValidationFailed    = errors.New("ClusterInfo Validation Failed")
 	ErrInvalidVersion      = errors.New("Invalid couchbase-server version")
+	ErrInvalidVBMap        = errors.New("Invalid vbucket map")
 )
 
 var ServiceAddrMap map[string]string
@@ -998,6 +999,20 @@ func (c *ClusterInfoCache) IsMagmaStorage(bucket string) (bool, error) {
 	return strings.EqualFold(b.StorageBackend, "magma"), nil
 }
 
+func (c *ClusterInfoCache) GetNumVBuckets(bucket string) (int, error) {
+	b, err := c.pool.GetBucket(bucket)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	vbmap := b.VBServerMap()
+	if vbmap == nil || len(vbmap.VBucketMap) == 0 {
+		return 0, errors.New(ErrInvalidVBMap.Error() + fmt.Sprintf(": %v", bucket))
+	}
+	return len(vbmap.VBucketMap), nil
+}
+
 func (c *ClusterInfoCache) GetCurrentNode() NodeId {
 	for i, node := range c.nodes {
 		if node.ThisNode {
@@ -1705,6 +1720,27 @@ func (cic *ClusterInfoClient) IsMagmaStorage(bucket string) (bool, error) {
 	return isMagma, nil
 }
 
+func (cic *ClusterInfoClient) GetNumVBuckets(bucket string) (int, error) {
+
+	cinfo := cic.GetClusterInfoCache()
+	cinfo.RLock()
+	defer cinfo.RUnlock()
+
+	numVbs, err := cinfo.GetNumVBuckets(bucket)
+	if err != nil {
+		// Force fetch cluster info cache to avoid staleness in cluster info cache
+		cinfo.RUnlock()
+		err := cinfo.FetchWithLock()
+		cinfo.RLock()
+		if err != nil {
+			return 0, err
+		} else {
+			return cinfo.GetNumVBuckets(bucket)
+		}
+	}
+	return numVbs, nil
+}
+
 func (cic *ClusterInfoClient) GetBucketUUID(bucket string) (string, error) {
 
 	cinfo := cic.GetClusterInfoCache()
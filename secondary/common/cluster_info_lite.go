@@ -2115,6 +2115,14 @@ func (bi *bucketInfo) IsMagmaStorage(bucket string) (bool, error) {
 	return strings.EqualFold(backend, "magma"), nil
 }
 
+func (bi *bucketInfo) GetNumVBuckets(bucket string) (int, error) {
+	vbmap := bi.bucket.VBServerMap()
+	if vbmap == nil || len(vbmap.VBucketMap) == 0 {
+		return 0, errors.New(ErrInvalidVBMap.Error() + fmt.Sprintf(": %v", bucket))
+	}
+	return len(vbmap.VBucketMap), nil
+}
+
 func (bi *bucketInfo) GetLocalVBuckets(bucketName string) (
 	vbs []uint16, err error) {
 
@@ -2247,6 +2255,23 @@ func (cicl *ClusterInfoCacheLiteClient) IsMagmaStorage(bucketName string) (bool,
 	return resp, err
 }
 
+func (cicl *ClusterInfoCacheLiteClient) GetNumVBuckets(bucketName string) (int, error) {
+	numVbs := func() (int, error) {
+		bi, err := cicl.GetBucketInfo(bucketName)
+		if err != nil {
+			return 0, err
+		}
+
+		return bi.GetNumVBuckets(bucketName)
+	}
+
+	resp, err := numVbs()
+	if err != nil {
+		resp, err = numVbs()
+	}
+	return resp, err
+}
+
 //
 // API using Collection Info
 //
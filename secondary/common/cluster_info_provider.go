@@ -41,6 +41,9 @@ type ClusterInfoProvider interface {
 
 	IsMagmaStorage(bucketName string) (bool, error)
 
+	// GetNumVBuckets returns the number of vbuckets of the bucket
+	GetNumVBuckets(bucketName string) (int, error)
+
 	ValidateBucket(bucketName string, uuids []string) (resp bool)
 
 	// Node Info Level Information accessors in client
@@ -289,6 +289,32 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"projector.dcp.multiplexStreams": ConfigValue{
+		false,
+		"multiplex streams of collections from the same bucket over " +
+			"shared DCP connections using stream-ids, " +
+			"changing this value does not affect existing feeds.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dcp.streamBufferSize": ConfigValue{
+		1000,
+		"number of DCP events buffered for each multiplexed stream, " +
+			"changing this value does not affect existing feeds.",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dcp.streamBacklogSize": ConfigValue{
+		16 * 1024 * 1024,
+		"bytes of DCP events a multiplexed stream can fall behind before " +
+			"holding up the other streams of the bucket, " +
+			"changing this value does not affect existing feeds.",
+		16 * 1024 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
 	// projector adminport parameters
 	"projector.adminport.name": ConfigValue{
 		"projector.adminport",
@@ -416,6 +442,33 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"projector.evalWallTimeLimit": ConfigValue{
+		0,
+		"Wall time limit, in milliseconds, for evaluating the expressions " +
+			"of an index for a document. Indexes exceeding the limit for " +
+			"projector.evalBreakerThreshold consecutive documents skip " +
+			"the mutations of projector.evalBreakerCooldown seconds, " +
+			"which are counted. 0 disables the limit",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.evalBreakerThreshold": ConfigValue{
+		10,
+		"Number of consecutive documents exceeding projector.evalWallTimeLimit " +
+			"after which evaluation is skipped for an index",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.evalBreakerCooldown": ConfigValue{
+		60,
+		"Time, in seconds, for which evaluation is skipped for an index " +
+			"exceeding projector.evalWallTimeLimit",
+		60,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"projector.systemStatsCollectionInterval": ConfigValue{
 		5, // 5 seconds
 		"The period with which projector updates the system level stats",
@@ -616,6 +669,76 @@ var SystemConfig = Config{
 		false, // immutable
 		false, // case-insensitive
 	},
+	"indexer.queryport.multiplex": ConfigValue{
+		true,
+		"accept connections on which clients multiplex concurrent requests",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.maxMuxWindow": ConfigValue{
+		1024 * 1024,
+		"maximum flow control window, in bytes, per stream of a multiplexed connection",
+		1024 * 1024,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.idleTimeout": ConfigValue{
+		0,
+		"timeout, in seconds, after which the indexer closes connections which " +
+			"served no scan, 0 to keep them open",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.maxConnAge": ConfigValue{
+		0,
+		"age, in seconds, past which the indexer closes connections once their " +
+			"scans are done, 0 to keep them open",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.maxConnsPerClient": ConfigValue{
+		0,
+		"maximum number of connections from a client, by IP, past which the " +
+			"indexer rejects new ones, 0 for no limit",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.compression": ConfigValue{
+		"snappy",
+		"comma separated compressions of scan responses that clients can negotiate, " +
+			"from none and snappy (zstd is not supported)",
+		"snappy",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.auth.jwt.secret_file": ConfigValue{
+		"",
+		"file with the secret signing, with HS256, the JWTs that connections can " +
+			"authenticate with instead of a user and password, empty to not accept JWTs",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
+	"indexer.queryport.auth.jwt.issuer": ConfigValue{
+		"",
+		"issuer of accepted JWTs, empty to accept any issuer",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
+	"indexer.queryport.auth.principals": ConfigValue{
+		"",
+		"JSON object from principals authenticated with a JWT, \"jwt:<subject>\", " +
+			"or a client certificate, \"cert:<common name>\", to their roles, " +
+			"\"admin\" or \"scan[bucket[:scope[:collection]]]\"",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
 	// queryport client configuration
 	"queryport.client.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -667,6 +790,29 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.settings.multiplex": ConfigValue{
+		false,
+		"multiplex the requests to an indexer over a single connection, each pooled " +
+			"connection being a stream of it",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.settings.muxWindow": ConfigValue{
+		256 * 1024,
+		"flow control window, in bytes, per stream of a multiplexed connection",
+		256 * 1024,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.settings.compression": ConfigValue{
+		"none",
+		"comma separated compressions of scan responses to offer indexers, in order " +
+			"of preference, from none and snappy (zstd is not supported)",
+		"none",
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"queryport.client.connPoolTimeout": ConfigValue{
 		1000,
 		"timeout, in milliseconds, is timeout for retrieving a connection " +
@@ -967,11 +1113,89 @@ var SystemConfig = Config{
 	},
 	"indexer.numSnapshotWorkers": ConfigValue{
 		runtime.GOMAXPROCS(0) * 10,
-		"Number of workers each keyspaceId in a stream will spawn to create snapshots",
+		"Number of workers each keyspaceId in a stream will spawn to create snapshots. " +
+			"With snapshotWorkers.autotune, the maximum number of workers",
 		runtime.GOMAXPROCS(0) * 10,
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.snapshotWorkers.autotune": ConfigValue{
+		true,
+		"Size the number of snapshot workers from the number of CPUs, index " +
+			"instances and snapshot generation latency",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.snapshotWorkers.autotuneInterval": ConfigValue{
+		60,
+		"Interval (sec) at which the number of snapshot workers is tuned",
+		60,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.snapshotWorkers.targetLatency": ConfigValue{
+		50,
+		"Snapshot generation latency (ms) above which a snapshot is considered slow " +
+			"when tuning the number of snapshot workers",
+		50,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.snapshotWorkers.slowPercent": ConfigValue{
+		10,
+		"Percentage of slow snapshots above which snapshot workers are added",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.snapshot.atomicKeyspace": ConfigValue{
+		false,
+		"Commit disk snapshots of all indexes of a keyspace at the same timestamp, " +
+			"recorded as a recovery point to which all of them rollback together",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.snapshot.maxRecoveryPoints": ConfigValue{
+		10,
+		"Maximum number of recovery points recorded for each keyspace with " +
+			"snapshot.atomicKeyspace",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.shutdown.forceCommit": ConfigValue{
+		false,
+		"Commit the latest snapshot of all indexes on shutdown, so that a restart " +
+			"recovers from it rather than from the last disk snapshot",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.shutdown.forceCommitTimeout": ConfigValue{
+		60,
+		"Time (sec) after which snapshots of remaining indexes are not committed on shutdown",
+		60,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.merge.autoHeal": ConfigValue{
+		true,
+		"When merging the snapshot of a partition of an index into another instance " +
+			"and the source snapshot is behind the target, force a snapshot of the source " +
+			"and retry the merge instead of failing it",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.merge.autoHealRetries": ConfigValue{
+		3,
+		"Number of times a partition snapshot merge is retried after forcing a snapshot of the source",
+		3,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.enableManager": ConfigValue{
 		false,
 		"Enable index manager",
@@ -986,6 +1210,22 @@ var SystemConfig = Config{
 		true, // immutable
 		true, // case-sensitive
 	},
+	"indexer.storage.tiers.coldPath": ConfigValue{
+		"",
+		"Directory of the storage tier for cold index partitions, e.g. on a HDD or a " +
+			"network volume. Partitions are moved between tiers when next opened. " +
+			"Storage tiers are disabled if empty.",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
+	"indexer.storage.tiers.coldAfter": ConfigValue{
+		uint64(7 * 24 * 3600),
+		"Time in seconds without scans after which an index partition is cold",
+		uint64(7 * 24 * 3600),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.diagnostics_dir": ConfigValue{
 		"./",
 		"Index diagnostics information directory",
@@ -1030,6 +1270,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.stats.storage.gather_budget": ConfigValue{
+		5000,
+		"Time in milliseconds a periodic round of stats spends gathering storage " +
+			"stats, the stalest first; the others are served from the previous " +
+			"rounds. 0 to gather all of them every round",
+		5000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.stats_cache_timeout": ConfigValue{
 		uint64(30000),
 		"Stats cache ttl in millis",
@@ -1780,6 +2029,16 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.plasma.writer.tuning.overrides": ConfigValue{
+		"",
+		"JSON object of writer tuning settings, enable, adjust_interval and " +
+			"sampling_interval (millis), overriding the node wide ones for " +
+			"indexes, by bucket.scope.collection.index, or for keyspaces, by " +
+			"bucket.scope.collection or bucket",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
 	"indexer.plasma.memtuner.maxFreeMemory": ConfigValue{
 		1024 * 1024 * 1024 * 8,
 		"Max free memory",
@@ -2136,6 +2395,40 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.pause_if_disk_full": ConfigValue{
+		false,
+		"Indexer goes to Paused when a storage volume is full, rather than " +
+			"failing to write to it. All indexes stop processing mutations, " +
+			"also when only the cold tier volume is full",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.high_disk_mark": ConfigValue{
+		0.95,
+		"Fraction of the space of a storage volume above which Indexer " +
+			"moves to paused state",
+		0.95,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.low_disk_mark": ConfigValue{
+		0.9,
+		"Once Indexer goes to Paused state as a storage volume is full, it " +
+			"becomes Active only after the usage of all storage volumes reaches " +
+			"below this fraction of their space",
+		0.9,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.disk_usage_check_interval": ConfigValue{
+		10,
+		"Time interval in seconds after which Indexer will check " +
+			"the usage of storage volumes and do Pause/Unpause if required",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.allow_scan_when_paused": ConfigValue{
 		true,
 		"stale=ok scans are allowed when Indexer is in Paused state",
@@ -2194,6 +2487,119 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.memory_governor.enabled": ConfigValue{
+		false,
+		"Arbitrate the memory quota between the storage cache, the mutation " +
+			"queues and the scan buffers, shrinking the budgets of the ones over " +
+			"their share when memory used is high",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.memory_governor.interval": ConfigValue{
+		5,
+		"Interval in seconds at which the memory governor arbitrates the memory quota",
+		5,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.memory_governor.high_frac": ConfigValue{
+		0.95,
+		"Fraction of memory quota used above which the memory governor shrinks budgets",
+		0.95,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.memory_governor.low_frac": ConfigValue{
+		0.85,
+		"Fraction of memory quota used targeted by the memory governor when shrinking " +
+			"budgets, below which shrunk budgets grow back",
+		0.85,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.memory_governor.min_scale": ConfigValue{
+		0.25,
+		"Minimum fraction of its share of the memory quota a budget is shrunk to",
+		0.25,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.memory_governor.grow_step": ConfigValue{
+		0.05,
+		"Fraction of its share of the memory quota a shrunk budget grows back by " +
+			"per interval",
+		0.05,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.memory_governor.scan_frac": ConfigValue{
+		0.05,
+		"Share of the memory quota for the buffers of running scans",
+		0.05,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.enabled": ConfigValue{
+		false,
+		"Capture heap and goroutine profiles when memory used, goroutines or " +
+			"pending scans exceed their thresholds",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.dir": ConfigValue{
+		"",
+		"Directory of the diagnostics captures. Empty for a diagnostics " +
+			"directory under log_dir, or storage_dir if not set",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
+	"indexer.diagnostics.interval": ConfigValue{
+		10,
+		"Interval in seconds at which the diagnostics thresholds are checked",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.cooldown": ConfigValue{
+		600,
+		"Minimum time in seconds between diagnostics captures",
+		600,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.max_captures": ConfigValue{
+		10,
+		"Number of most recent diagnostics captures kept",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.memory_frac": ConfigValue{
+		0.95,
+		"Fraction of memory quota used above which diagnostics are captured, " +
+			"0 to disable",
+		0.95,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.num_goroutines": ConfigValue{
+		50000,
+		"Number of goroutines above which diagnostics are captured, 0 to disable",
+		50000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.diagnostics.pending_scans": ConfigValue{
+		10000,
+		"Number of pending scan requests above which diagnostics are captured, " +
+			"0 to disable",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.mem_usage_check_interval": ConfigValue{
 		10,
 		"Time inteval in seconds after which Indexer will check " +
@@ -2213,6 +2619,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.timekeeper.maxConcurrentFlush": ConfigValue{
+		0,
+		"Maximum number of keyspaces flushing at a time. Free flush slots " +
+			"go to the keyspaces falling furthest behind their mutation rate. " +
+			"0 means no limit.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.enableAsyncOpenStream": ConfigValue{
 		true,
 		"Enable async stream open operation between indexer and projector",
@@ -2227,6 +2642,15 @@ var SystemConfig = Config{
 		true,  // mutable
 		false, // case-insensitive
 	},
+	"indexer.rollback.maxRebuildVbucketsPercent": ConfigValue{
+		0,
+		"When no snapshot of an index is old enough for rollback, rebuild only the " +
+			"vbuckets that need rollback instead of rolling back the whole index to zero, " +
+			"if they are at most this percentage of the vbuckets. 0 disables the rebuild",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.timekeeper.escalate.StreamBeginWaitTime": ConfigValue{
 		30 * 60, // 30 minutes
 		"Max wait time after the last received stream begin (in second) escalate to the next repair action during stream repair. ",
@@ -2342,8 +2766,47 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
-	"indexer.settings.persisted_snapshot.interval": ConfigValue{
-		uint64(5000), // keep in sync with index_settings_manager.erl
+	"indexer.settings.compaction.advisor.enabled": ConfigValue{
+		true,
+		"Track the space each index partition would reclaim on compaction and " +
+			"recommend compactions, listed by /compactionAdvice",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.compaction.advisor.autoCompact": ConfigValue{
+		false,
+		"Compact the index partitions recommended by the compaction advisor",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.compaction.advisor.minSavings": ConfigValue{
+		256,
+		"Space (MB) an index partition must reclaim on compaction to be " +
+			"recommended, regardless of its fragmentation",
+		256,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.compaction.advisor.horizon": ConfigValue{
+		3600,
+		"Time (sec) over which the compaction advisor projects the growth of " +
+			"reclaimable space to rank recommendations",
+		3600,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.compaction.advisor.maxCompactions": ConfigValue{
+		2,
+		"Maximum number of concurrent compactions when the compaction advisor " +
+			"compacts recommended index partitions",
+		2,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.persisted_snapshot.interval": ConfigValue{
+		uint64(5000), // keep in sync with index_settings_manager.erl
 		"Persisted snapshotting interval in milliseconds",
 		uint64(5000),
 		false, // mutable
@@ -2356,6 +2819,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.persisted_snapshot.compact_ts": ConfigValue{
+		false,
+		"Store the timestamp of persisted snapshots in the compact binary " +
+			"encoding. Snapshots stored so cannot be recovered by older indexers, " +
+			"enable only once all the indexers of the cluster are upgraded.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.inmemory_snapshot.interval": ConfigValue{
 		uint64(200), // keep in sync with index_settings_manager.erl
 		"InMemory snapshotting interval in milliseconds",
@@ -2363,6 +2835,43 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.inmemory_snapshot.adaptive.enable": ConfigValue{
+		true,
+		"Adapt the InMemory snapshotting interval of a keyspace to its mutation rate, " +
+			"taking snapshots more often during mutation bursts and less often when quiet. " +
+			"Applies to streams started after the change.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.inmemory_snapshot.adaptive.min_interval": ConfigValue{
+		uint64(2),
+		"InMemory snapshotting interval in milliseconds during mutation bursts",
+		uint64(2),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.inmemory_snapshot.adaptive.max_interval": ConfigValue{
+		uint64(200),
+		"InMemory snapshotting interval in milliseconds when there are few mutations",
+		uint64(200),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.inmemory_snapshot.adaptive.burst_factor": ConfigValue{
+		float64(4),
+		"Mutation rate over its moving average by which a mutation burst is detected",
+		float64(4),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.inmemory_snapshot.adaptive.quiet_rate": ConfigValue{
+		uint64(100),
+		"Mutations per second under which a keyspace is considered quiet",
+		uint64(100),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.moi.recovery.max_rollbacks": ConfigValue{
 		2,
 		"Maximum number of committed rollback points",
@@ -2416,6 +2925,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.cgroup.poll_interval": ConfigValue{
+		uint64(60),
+		"Interval in seconds to re-read the Linux cgroup memory and CPU limits," +
+			" so that resizing the container takes effect without a restart;" +
+			" 0 to read them at startup only",
+		uint64(60),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.log_level": ConfigValue{
 		"info", // keep in sync with index_settings_manager.erl
 		"Indexer logging level",
@@ -2423,6 +2941,94 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.log_level.storage_manager": ConfigValue{
+		"",
+		"Logging level of storage manager, overriding indexer.settings.log_level. " +
+			"Empty uses indexer.settings.log_level",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_level.scan": ConfigValue{
+		"",
+		"Logging level of scan coordinator and scan pipeline, overriding indexer.settings.log_level. " +
+			"Empty uses indexer.settings.log_level",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_level.rebalance": ConfigValue{
+		"",
+		"Logging level of rebalance, overriding indexer.settings.log_level. " +
+			"Empty uses indexer.settings.log_level",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_level.dcp": ConfigValue{
+		"",
+		"Logging level of DCP feeds, in the projector and the indexer, overriding " +
+			"projector.settings.log_level and indexer.settings.log_level. Empty uses them",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.metadata_encryption.enable": ConfigValue{
+		false,
+		"Encrypt the index instance map persisted by the indexer, when the " +
+			"index manager is not enabled, with the active key of " +
+			"metadata_encryption.keys_file",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.metadata_encryption.keys_file": ConfigValue{
+		"",
+		"JSON file of the metadata encryption keys of the node, " +
+			`{"active": id, "keys": {id: base64 of a 32 byte key}}. ` +
+			"Older keys are kept in the file to read what was written with them",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_rotation.enable": ConfigValue{
+		false,
+		"Write indexer logs to a file under log_dir rotated by the indexer " +
+			"itself, instead of relying only on external rotation of stdout",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_rotation.max_size": ConfigValue{
+		100 * 1024 * 1024,
+		"Rotate the indexer log file once it grows beyond this size, in bytes. " +
+			"0 disables size based rotation",
+		100 * 1024 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_rotation.interval": ConfigValue{
+		86400,
+		"Rotate the indexer log file once it is older than this interval, " +
+			"in seconds. 0 disables time based rotation",
+		86400,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_rotation.max_files": ConfigValue{
+		10,
+		"Number of rotated indexer log files to retain. 0 retains all",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.log_rotation.compress": ConfigValue{
+		true,
+		"Gzip rotated indexer log files",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.scan_timeout": ConfigValue{
 		120000,
 		"timeout, in milliseconds, timeout for index scan processing",
@@ -2430,6 +3036,50 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"indexer.settings.snapshot.openWarnThreshold": ConfigValue{
+		600,
+		"warn of index snapshots open for longer than this many seconds, which may " +
+			"have been leaked. 0 disables the warnings",
+		600,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_timeout.adaptive": ConfigValue{
+		false,
+		"Derive the timeout of the scans of each index from the latencies of its recent scans " +
+			"instead of using settings.scan_timeout",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_timeout.percentile": ConfigValue{
+		float64(99),
+		"Percentile of the recent scan latencies of an index used for its adaptive scan timeout",
+		float64(99),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_timeout.multiplier": ConfigValue{
+		float64(4),
+		"Adaptive scan timeout of an index, as a multiple of the percentile of its recent scan latencies",
+		float64(4),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_timeout.floor": ConfigValue{
+		5000,
+		"timeout, in milliseconds, minimum adaptive scan timeout",
+		5000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan_timeout.ceiling": ConfigValue{
+		300000,
+		"timeout, in milliseconds, maximum adaptive scan timeout, 0 for no maximum",
+		300000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.eTagPeriod": ConfigValue{
 		240,
 		"Average ETag expiration period in seconds",
@@ -2458,6 +3108,52 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.overflow_large_keys": ConfigValue{
+		true,
+		"When allow_large_keys is disabled, store secondary keys over max_seckey_size " +
+			"as overflow entries instead of skipping them. Supported for non-array MOI indexes.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.keyStats.enabled": ConfigValue{
+		false,
+		"Maintain sketches of the distinct values and quantiles of each key position " +
+			"of non-array indexes during flush, exposed at /stats/keyDistribution",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.keyStats.sampleRate": ConfigValue{
+		1,
+		"Add one in sampleRate indexed keys to the key distribution sketches",
+		1,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.hotKeys.enabled": ConfigValue{
+		false,
+		"Track the most updated docids of each index during flush, exposed at " +
+			"/stats/hotKeys",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.hotKeys.window": ConfigValue{
+		60,
+		"Window (sec) over which the updates of docids are counted",
+		60,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.hotKeys.threshold": ConfigValue{
+		1000,
+		"Updates of a docid in a window above which it is logged to the " +
+			"event log. 0 disables the events",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.send_buffer_size": ConfigValue{
 		1024,
 		"Buffer size for batching rows during scan result streaming",
@@ -2575,6 +3271,14 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.cbo_stats.refreshInterval": ConfigValue{
+		uint64(0),
+		"Interval in seconds to publish index statistics for the cost-based optimizer " +
+			"to metakv, 0 disables publishing",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.statsLogDumpInterval": ConfigValue{
 		uint64(60),
 		"Periodic stats dump logging interval in seconds",
@@ -2692,6 +3396,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.moi.persistence.queue_size": ConfigValue{
+		1,
+		"Number of snapshots of a MOI index queued for persistence while a snapshot " +
+			"is being persisted. When the queue is full, the oldest queued snapshot is " +
+			"not persisted, so that the latest one always is.",
+		1,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.moi.persistence.io_concurrency": ConfigValue{
 		float64(0.7),
 		"Number of concurrent disk operations during persistence. On linux, if it is smaller than 1, " +
@@ -2924,6 +3637,24 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.verify_slice_on_startup": ConfigValue{
+		false,
+		"Verify the files of the index slices on startup, before opening them. " +
+			"Slices failing verification are backed up to the corrupt data directory and " +
+			"cleaned up, and snapshots that fail to open are rolled back from instead of " +
+			"crashing the indexer.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.trash_retention": ConfigValue{
+		0,
+		"Seconds to keep the data of active indexes dropped by users in the trash, " +
+			"for them to be restored with /internal/trash/restore. 0 deletes the data on drop.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.build.background.disable": ConfigValue{
 		false,
 		"Disable background index build, except during upgrade",
@@ -2938,6 +3669,46 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.build.ingest.path": ConfigValue{
+		"",
+		"Directory of local KV exports to ingest for the initial build of indexes, " +
+			"instead of streaming the DCP history from 0. The build then catches up " +
+			"with DCP from the seqnos of the export. Disabled if empty.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.ingest.numWorkers": ConfigValue{
+		4,
+		"Number of export files ingested in parallel for an initial build",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.retry.maxAttempts": ConfigValue{
+		10,
+		"Number of times an index build failing with a transient error is retried " +
+			"in the background, before the index is left in error for a manual build. " +
+			"Use 0 for no limit.",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.retry.initialBackoff": ConfigValue{
+		5,
+		"Seconds to wait before the first retry of a failed index build, " +
+			"doubled on each following retry",
+		5,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.retry.maxBackoff": ConfigValue{
+		600,
+		"Max seconds to wait between retries of a failed index build",
+		600,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.scan.queue_size": ConfigValue{
 		20,
 		"When performing scan scattering in indexer, specify the queue size for the scatterer.",
@@ -2959,6 +3730,22 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.scan.expr_eval_batch_size": ConfigValue{
+		64,
+		"number of rows over which the N1QL expressions of group keys and aggregates " +
+			"are evaluated at once. 0 or 1 evaluates them row by row",
+		64,
+		true,  // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.arena_size": ConfigValue{
+		64 * 1024,
+		"Size in bytes of the arena of a scan worker, from which the temporaries of " +
+			"projected and aggregated rows are allocated. 0 allocates them on the heap.",
+		64 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.scan.enable_fast_count": ConfigValue{
 		true,
 		"enable fast count optimization for aggregate pushdown",
@@ -2966,6 +3753,200 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.scan.profile.max_entries": ConfigValue{
+		1000,
+		"Number of most recent scan profiles retained for scans requested with the profile flag",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.countCache.ttl": ConfigValue{
+		0,
+		"Time (ms) for which results of count scans are cached, while the index " +
+			"snapshot is unchanged. 0 disables the cache",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.countCache.size": ConfigValue{
+		1000,
+		"Maximum number of count scan results cached",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.seqnosCache.ttl": ConfigValue{
+		0,
+		"Time (ms) for which session consistent scans of a collection share a fetch of its " +
+			"seqnos from KV. Scans may miss mutations of the last ttl. 0 disables the cache",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.partition_retries": ConfigValue{
+		2,
+		"Number of times a partition scan failing with a transient error is " +
+			"retried within the scan request",
+		2,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.materialized_count": ConfigValue{
+		false,
+		"Maintain the exact items count of each index snapshot as it is " +
+			"created, to answer unqualified counts without a scan",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.session.ttl": ConfigValue{
+		300,
+		"Time (sec) after the last scan when a scan session expires and its " +
+			"snapshots are released",
+		300,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.session.maxTtl": ConfigValue{
+		3600,
+		"Maximum ttl (sec) of a scan session, longer ttls requested are " +
+			"reduced to it",
+		3600,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.session.maxSessions": ConfigValue{
+		64,
+		"Maximum number of open scan sessions",
+		64,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.session.openTimeout": ConfigValue{
+		30000,
+		"Time (ms) to wait for consistent snapshots of the indexes of a scan " +
+			"session being opened",
+		30000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.check.interval": ConfigValue{
+		uint64(0),
+		"Interval (sec) at which replicas of indexes are compared with replicas " +
+			"on other nodes. 0 disables the check",
+		uint64(0),
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.check.sampleRate": ConfigValue{
+		1024,
+		"One in sampleRate index entries, by hash, are sampled to find " +
+			"entries differing between replicas",
+		1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.check.waitTimeout": ConfigValue{
+		10000,
+		"Time (ms) a replica waits for a snapshot at the end of DCP snapshots, " +
+			"at the timestamp being compared, before the check is skipped",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.repair.enabled": ConfigValue{
+		false,
+		"Rebuild replicas of indexes lost after failover on other nodes, " +
+			"without waiting for a rebalance",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.repair.interval": ConfigValue{
+		300,
+		"Interval (sec) between checks for missing replicas",
+		300,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.repair.delay": ConfigValue{
+		600,
+		"Time (sec) a replica must be missing before it is rebuilt, leaving " +
+			"time for a failed over node to be recovered",
+		600,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.repair.window": ConfigValue{
+		"",
+		"Time of day replicas may be rebuilt, as HH:MM-HH:MM in local time. " +
+			"Empty for any time",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.replica.repair.maxConcurrent": ConfigValue{
+		1,
+		"Maximum number of indexes whose replicas are being rebuilt at a time",
+		1,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.limit.mode": ConfigValue{
+		"",
+		"Limit scans per \"user\" or per \"bucket\", rejecting scans over the " +
+			"limits with a retry-after error. Empty disables scan limits. The user " +
+			"is the one of the queryport connection, the query service for n1ql scans",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.limit.qps": ConfigValue{
+		0,
+		"Maximum scans per second per user or bucket, 0 for unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.limit.concurrency": ConfigValue{
+		0,
+		"Maximum concurrent scans per user or bucket, 0 for unlimited",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.pools.mode": ConfigValue{
+		"",
+		"Run the scans of each \"index\" or each \"bucket\" in their own pool " +
+			"of concurrent scans. Empty disables scan pools",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.pools.size": ConfigValue{
+		32,
+		"Concurrent scans per pool of normal priority; twice as many for high " +
+			"priority and half as many for low priority",
+		32,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.pools.priority": ConfigValue{
+		"",
+		"JSON object of the priority, high, normal or low, of the scan pools " +
+			"of indexes, by bucket.scope.collection.index, or of buckets",
+		"",
+		false, // mutable
+		true,  // case-sensitive
+	},
+	"indexer.scan.pools.wait_timeout": ConfigValue{
+		1000,
+		"Time in milliseconds a scan waits for a slot in its pool before it " +
+			"is rejected with a retry-after error",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.planner.timeout": ConfigValue{
 		300,
 		"timeout (sec) on planner",
@@ -3123,6 +4104,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.debug.snapshotLeakCheck": ConfigValue{
+		0,
+		"This flag is intended for use in test/debug setups. Records the stack " +
+			"of every clone of an index snapshot, and logs the clones not destroyed " +
+			"within this many minutes with their stack. 0 disables it",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.recovery.max_disksnaps": ConfigValue{
 		4,
 		"Maximum number of disk snapshots for recovery. If KV replica is behind active, " +
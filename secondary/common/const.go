@@ -50,6 +50,10 @@ var ErrIndexNotFoundRebal = errors.New("Index not found (rebalance)") // magic s
 // Index not ready
 var ErrIndexNotReady = errors.New("Index not ready for serving queries")
 
+// Mutations skipped by the evaluator of the projector, whose index needs
+// to be rebuilt to serve queries again
+var ErrIndexEvalSkipped = errors.New("Index missed mutations skipped by the projector evaluator")
+
 // ErrClientCancel when query client cancels an ongoing scan request.
 var ErrClientCancel = errors.New("Client requested cancel")
 
@@ -60,6 +64,12 @@ var ErrUnmarshalFailed = errors.New("json.Unmarshal failed")
 
 var ErrAuthMissing = errors.New("Unauthenticated access. Missing authentication information.")
 
+// Scan rejected by the per user or per bucket scan limits
+var ErrScanThrottled = errors.New("Scan request limit exceeded")
+
+// User lacks n1ql.select permission on the collection of the scanned index
+var ErrScanNotAuthorized = errors.New("Unauthorized access. User does not have permission to scan the index.")
+
 //
 // List of errors leading to failure of index creation
 //
@@ -57,6 +57,8 @@ const (
 	OSOSnapshotStart // control command
 	OSOSnapshotEnd   // control command
 
+	EvalSkip // control command
+
 	Filler // filler command for flusher(only used internally by indexer)
 )
 
@@ -313,6 +315,12 @@ func (kv *KeyVersions) AddDropData() {
 	kv.addKey(0, DropData, nil, nil, nil)
 }
 
+// AddEvalSkip add EvalSkip command for a mutation the evaluator of the
+// index instance uuid skipped, leaving the index stale.
+func (kv *KeyVersions) AddEvalSkip(uuid uint64) {
+	kv.addKey(uuid, EvalSkip, nil, nil, nil)
+}
+
 // AddStreamBegin add StreamBegin command for a new vbucket.
 func (kv *KeyVersions) AddStreamBegin(status byte, code byte) {
 	kv.addKey(0, StreamBegin, []byte{status, code}, nil, nil)
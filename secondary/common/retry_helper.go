@@ -1,6 +1,7 @@
 package common
 
 import (
+	"strings"
 	"time"
 )
 
@@ -44,3 +45,38 @@ func (r *RetryHelper) Run() error {
 
 	return err
 }
+
+// RetryHint tells clients whether a request failed with an error can be
+// retried as is, and how long to wait before retrying.
+type RetryHint struct {
+	Retriable  bool
+	RetryAfter time.Duration
+}
+
+// Suggested backoff for the retryable errors of DDL requests.
+var ddlRetryAfter = map[error]time.Duration{
+	ErrAnotherIndexCreation: 1 * time.Second,
+	ErrRebalanceRunning:     30 * time.Second,
+	ErrNetworkPartition:     5 * time.Second,
+	ErrIndexerNotAvailable:  5 * time.Second,
+	ErrNotEnoughIndexers:    5 * time.Second,
+	ErrIndexerConnection:    5 * time.Second,
+}
+
+// GetDDLRetryHint returns the retry hint of a DDL request failed with the
+// error msg. Errors are matched by text, as they are often wrapped in the
+// error of the request.
+func GetDDLRetryHint(msg string) RetryHint {
+	for _, err := range NonRetryableErrorsInCreate {
+		if strings.Contains(msg, err.Error()) {
+			return RetryHint{}
+		}
+	}
+
+	for _, err := range RetryableErrorsInCreate {
+		if strings.Contains(msg, err.Error()) {
+			return RetryHint{Retriable: true, RetryAfter: ddlRetryAfter[err]}
+		}
+	}
+	return RetryHint{}
+}
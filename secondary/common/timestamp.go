@@ -5,6 +5,7 @@ package common
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"sync"
 
@@ -205,6 +206,30 @@ func (ts *TsVbuuid) AsRecent(other *TsVbuuid) bool {
 	return true
 }
 
+// AsRecentVbnos is AsRecent restricted to the vbuckets vbnos, for an
+// `other` timestamp with a vbuuid of 0 for all other vbuckets.
+func (ts *TsVbuuid) AsRecentVbnos(other *TsVbuuid, vbnos []uint16) bool {
+	if ts == nil || other == nil {
+		return false
+	}
+	if ts.Bucket != other.Bucket {
+		return false
+	}
+
+	if len(ts.Vbuuids) > len(other.Vbuuids) {
+		return false
+	}
+	for _, vbno := range vbnos {
+		if int(vbno) >= len(ts.Vbuuids) || other.Vbuuids[vbno] == 0 {
+			continue
+		}
+		if ts.Vbuuids[vbno] != other.Vbuuids[vbno] || ts.Seqnos[vbno] < other.Seqnos[vbno] {
+			return false
+		}
+	}
+	return true
+}
+
 func (ts *TsVbuuid) Union(other *TsVbuuid) *TsVbuuid {
 
 	if ts == nil {
@@ -319,6 +344,30 @@ func (ts *TsVbuuid) GetCrc64() uint64 {
 	return ts.Crc64
 }
 
+// SnapshotId returns the identity of the data indexed up to this timestamp,
+// the crc64 of its vbuuids and seqnos. Snapshots with the same id have
+// indexed the same mutations, so results cached from a scan of one are
+// still valid for the other.
+func (ts *TsVbuuid) SnapshotId() uint64 {
+
+	if ts == nil {
+		return 0
+	}
+
+	var checksum uint64
+	var buf [16]byte
+	for i, seqno := range ts.Seqnos {
+		var vbuuid uint64
+		if i < len(ts.Vbuuids) {
+			vbuuid = ts.Vbuuids[i]
+		}
+		binary.BigEndian.PutUint64(buf[:8], vbuuid)
+		binary.BigEndian.PutUint64(buf[8:], seqno)
+		checksum = Crc64Update(checksum, buf[:])
+	}
+	return checksum
+}
+
 // Copy will return a clone of this timestamp.
 func (ts *TsVbuuid) Copy() *TsVbuuid {
 	newTs := NewTsVbuuid(ts.Bucket, len(ts.Seqnos))
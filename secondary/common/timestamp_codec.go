@@ -0,0 +1,348 @@
+// compact binary encoding of TsVbuuid, for timestamps persisted in snapshot
+// manifests or sent in bulk over the wire.
+
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidTsVbuuid is returned when an encoded timestamp cannot be decoded.
+var ErrInvalidTsVbuuid = errors.New("invalid encoded timestamp")
+
+// tsVbuuidMagic starts every compact encoding, it is neither a valid start of
+// a JSON document nor a single byte varint.
+const tsVbuuidMagic = 0xC7
+
+const tsVbuuidCodecVersion = 1
+
+// flags of the compact encoding
+const (
+	tsFlagManifestUIDs = 1 << iota // per vbucket manifest UIDs follow
+	tsFlagSnapshots                // per vbucket snapshot markers follow
+	tsFlagVbuuidIndex              // per vbucket index in the vbuuid table follows
+	tsFlagLargeSnap
+	tsFlagSnapAligned
+	tsFlagDisableAlign
+	tsFlagOpenOSOSnap
+)
+
+// EncodeTsVbuuid encodes ts in the compact binary format, version 1:
+//
+//	magic, version                         byte
+//	bucket, scope id, collection id        uvarint length, bytes
+//	flags, snap type, crc64                uvarint
+//	number of vbuckets                     uvarint
+//	vbuuid table                           uvarint count, 8 bytes each
+//	manifest UID table, if any             uvarint count, strings
+//	per vbucket
+//	  seqno                                uvarint
+//	  vbuuid index, if not in vb order     uvarint
+//	  manifest UID index, if any           uvarint
+//	  snapshot end-seqno, seqno-start      varint, if any
+//
+// Vbuuids and manifest UIDs repeated across vbuckets are stored once, and
+// snapshot markers, being close to the seqno, take a byte or two. Like the
+// JSON encoding, OSOCount is not encoded. A nil ts encodes to nil.
+func EncodeTsVbuuid(ts *TsVbuuid) []byte {
+	if ts == nil {
+		return nil
+	}
+
+	numVbs := len(ts.Seqnos)
+
+	vbuuids, vbuuidIdx := tsVbuuidTable(ts.Vbuuids, numVbs)
+	var flags uint64
+	if len(vbuuids) != numVbs {
+		flags |= tsFlagVbuuidIndex
+	}
+
+	var manifests []string
+	manifestIdx := make(map[string]uint64)
+	if len(ts.ManifestUIDs) != 0 {
+		flags |= tsFlagManifestUIDs
+		for vb := 0; vb < numVbs; vb++ {
+			uid := tsVbuuidManifestUID(ts, vb)
+			if _, ok := manifestIdx[uid]; !ok {
+				manifestIdx[uid] = uint64(len(manifests))
+				manifests = append(manifests, uid)
+			}
+		}
+	}
+	if len(ts.Snapshots) != 0 {
+		flags |= tsFlagSnapshots
+	}
+	for _, f := range []struct {
+		set  bool
+		flag uint64
+	}{
+		{ts.LargeSnap, tsFlagLargeSnap},
+		{ts.SnapAligned, tsFlagSnapAligned},
+		{ts.DisableAlign, tsFlagDisableAlign},
+		{ts.OpenOSOSnap, tsFlagOpenOSOSnap},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+
+	buf := make([]byte, 0, 64+len(vbuuids)*8+numVbs*4)
+	buf = append(buf, tsVbuuidMagic, tsVbuuidCodecVersion)
+	for _, s := range []string{ts.Bucket, ts.ScopeId, ts.CollectionId} {
+		buf = appendTsString(buf, s)
+	}
+	buf = appendTsUvarint(buf, flags)
+	buf = appendTsUvarint(buf, uint64(ts.SnapType))
+	buf = appendTsUvarint(buf, ts.Crc64)
+	buf = appendTsUvarint(buf, uint64(numVbs))
+
+	buf = appendTsUvarint(buf, uint64(len(vbuuids)))
+	for _, vbuuid := range vbuuids {
+		var tmp [8]byte
+		binary.BigEndian.PutUint64(tmp[:], vbuuid)
+		buf = append(buf, tmp[:]...)
+	}
+	if flags&tsFlagManifestUIDs != 0 {
+		buf = appendTsUvarint(buf, uint64(len(manifests)))
+		for _, uid := range manifests {
+			buf = appendTsString(buf, uid)
+		}
+	}
+
+	for vb := 0; vb < numVbs; vb++ {
+		seqno := ts.Seqnos[vb]
+		buf = appendTsUvarint(buf, seqno)
+		if flags&tsFlagVbuuidIndex != 0 {
+			buf = appendTsUvarint(buf, vbuuidIdx[vb])
+		}
+		if flags&tsFlagManifestUIDs != 0 {
+			buf = appendTsUvarint(buf, manifestIdx[tsVbuuidManifestUID(ts, vb)])
+		}
+		if flags&tsFlagSnapshots != 0 {
+			var snapshot [2]uint64
+			if vb < len(ts.Snapshots) {
+				snapshot = ts.Snapshots[vb]
+			}
+			buf = appendTsVarint(buf, int64(snapshot[1]-seqno))
+			buf = appendTsVarint(buf, int64(seqno-snapshot[0]))
+		}
+	}
+	return buf
+}
+
+// tsVbuuidTable returns the distinct vbuuids in vbucket order, and the index
+// of the vbuuid of each vbucket in them.
+func tsVbuuidTable(vbuuids []uint64, numVbs int) ([]uint64, []uint64) {
+	var table []uint64
+	tableIdx := make(map[uint64]uint64)
+	idx := make([]uint64, numVbs)
+	for vb := 0; vb < numVbs; vb++ {
+		var vbuuid uint64
+		if vb < len(vbuuids) {
+			vbuuid = vbuuids[vb]
+		}
+		i, ok := tableIdx[vbuuid]
+		if !ok {
+			i = uint64(len(table))
+			tableIdx[vbuuid] = i
+			table = append(table, vbuuid)
+		}
+		idx[vb] = i
+	}
+	return table, idx
+}
+
+func tsVbuuidManifestUID(ts *TsVbuuid, vb int) string {
+	if vb < len(ts.ManifestUIDs) {
+		return ts.ManifestUIDs[vb]
+	}
+	return ""
+}
+
+func appendTsUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendTsVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendTsString(buf []byte, s string) []byte {
+	buf = appendTsUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// IsCompactTsVbuuid returns whether data was encoded with EncodeTsVbuuid.
+func IsCompactTsVbuuid(data []byte) bool {
+	return len(data) >= 2 && data[0] == tsVbuuidMagic
+}
+
+// DecodeTsVbuuid decodes a timestamp encoded with EncodeTsVbuuid.
+func DecodeTsVbuuid(data []byte) (*TsVbuuid, error) {
+	if !IsCompactTsVbuuid(data) || data[1] != tsVbuuidCodecVersion {
+		return nil, ErrInvalidTsVbuuid
+	}
+	d := tsDecoder{buf: data[2:]}
+
+	ts := &TsVbuuid{}
+	ts.Bucket = d.string()
+	ts.ScopeId = d.string()
+	ts.CollectionId = d.string()
+	flags := d.uvarint()
+	ts.SnapType = IndexSnapType(d.uvarint())
+	ts.Crc64 = d.uvarint()
+	ts.LargeSnap = flags&tsFlagLargeSnap != 0
+	ts.SnapAligned = flags&tsFlagSnapAligned != 0
+	ts.DisableAlign = flags&tsFlagDisableAlign != 0
+	ts.OpenOSOSnap = flags&tsFlagOpenOSOSnap != 0
+
+	// Every vbucket takes at least a byte, every vbuuid 8 bytes
+	numVbs := d.count(1)
+	vbuuids := make([]uint64, d.count(8))
+	for i := range vbuuids {
+		vbuuids[i] = d.uint64()
+	}
+	var manifests []string
+	if flags&tsFlagManifestUIDs != 0 {
+		manifests = make([]string, d.count(1))
+		for i := range manifests {
+			manifests[i] = d.string()
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if flags&tsFlagVbuuidIndex == 0 && len(vbuuids) != numVbs {
+		return nil, ErrInvalidTsVbuuid
+	}
+
+	ts.Seqnos = make([]uint64, numVbs)
+	ts.Vbuuids = make([]uint64, numVbs)
+	if flags&tsFlagManifestUIDs != 0 {
+		ts.ManifestUIDs = make([]string, numVbs)
+	}
+	if flags&tsFlagSnapshots != 0 {
+		ts.Snapshots = make([][2]uint64, numVbs)
+	}
+
+	for vb := 0; vb < numVbs && d.err == nil; vb++ {
+		seqno := d.uvarint()
+		ts.Seqnos[vb] = seqno
+
+		i := uint64(vb)
+		if flags&tsFlagVbuuidIndex != 0 {
+			i = d.index(len(vbuuids))
+		}
+		if d.err == nil {
+			ts.Vbuuids[vb] = vbuuids[i]
+		}
+
+		if flags&tsFlagManifestUIDs != 0 {
+			if i := d.index(len(manifests)); d.err == nil {
+				ts.ManifestUIDs[vb] = manifests[i]
+			}
+		}
+		if flags&tsFlagSnapshots != 0 {
+			end := seqno + uint64(d.varint())
+			start := seqno - uint64(d.varint())
+			ts.Snapshots[vb] = [2]uint64{start, end}
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return ts, nil
+}
+
+// tsDecoder reads the fields of a compact timestamp, remembering the first
+// error so that callers check it once.
+type tsDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *tsDecoder) fail() {
+	d.err = ErrInvalidTsVbuuid
+	d.buf = nil
+}
+
+func (d *tsDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *tsDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *tsDecoder) uint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) < 8 {
+		d.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *tsDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.buf)) {
+		d.fail()
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+// count reads a number of items taking at least size bytes each, bounded by
+// the remaining bytes so that corrupt data cannot cause huge allocations.
+func (d *tsDecoder) count(size int) int {
+	n := d.uvarint()
+	if d.err != nil {
+		return 0
+	}
+	if n > uint64(len(d.buf)/size) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// index reads an index in a table of n entries.
+func (d *tsDecoder) index(n int) uint64 {
+	i := d.uvarint()
+	if d.err == nil && i >= uint64(n) {
+		d.fail()
+	}
+	return i
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTsVbuuidCodec(t *testing.T) {
+	ts := NewTsVbuuid("default", 1024)
+	ts.ScopeId = "8"
+	ts.CollectionId = "9"
+	for vb := range ts.Seqnos {
+		ts.Seqnos[vb] = uint64(100000 + vb)
+		ts.Vbuuids[vb] = uint64(vb%4) << 40
+		ts.Snapshots[vb] = [2]uint64{uint64(99000 + vb), uint64(100000 + vb)}
+		if vb%2 == 0 {
+			ts.ManifestUIDs[vb] = "1f"
+		}
+	}
+	ts.Snapshots[7] = [2]uint64{200000, 300000} // marker ahead of the seqno
+	ts.Crc64 = 0xdeadbeef
+	ts.SnapType = DISK_SNAP
+	ts.LargeSnap = true
+	ts.OpenOSOSnap = true
+
+	data := EncodeTsVbuuid(ts)
+	jsonData, _ := json.Marshal(ts)
+	if len(data)*4 > len(jsonData) {
+		t.Fatalf("expected compact encoding, got %v bytes, %v bytes as JSON", len(data), len(jsonData))
+	}
+
+	decoded, err := DecodeTsVbuuid(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, ts) {
+		t.Fatalf("expected %v, got %v", ts, decoded)
+	}
+
+	// Distinct vbuuids, and timestamps without manifests or snapshots
+	ts = NewTsVbuuid2("default", []uint64{0, 5, 10}, []uint64{1, 2, 3})
+	if decoded, err = DecodeTsVbuuid(EncodeTsVbuuid(ts)); err != nil || !reflect.DeepEqual(decoded, ts) {
+		t.Fatalf("expected %v, got %v error %v", ts, decoded, err)
+	}
+
+	if EncodeTsVbuuid(nil) != nil {
+		t.Fatalf("expected nil timestamp encoded to nil")
+	}
+}
+
+func TestTsVbuuidCodecInvalid(t *testing.T) {
+	ts := NewTsVbuuid("default", 64)
+	for vb := range ts.Seqnos {
+		ts.Seqnos[vb] = uint64(vb * 1000)
+		ts.Vbuuids[vb] = uint64(vb % 3)
+	}
+	data := EncodeTsVbuuid(ts)
+
+	for n := 0; n < len(data); n++ {
+		if _, err := DecodeTsVbuuid(data[:n]); err != ErrInvalidTsVbuuid {
+			t.Fatalf("expected error decoding %v of %v bytes, got %v", n, len(data), err)
+		}
+	}
+
+	jsonData, _ := json.Marshal(ts)
+	if IsCompactTsVbuuid(jsonData) {
+		t.Fatalf("expected JSON not taken for the compact encoding")
+	}
+
+	bad := append([]byte{}, data...)
+	bad[1] = tsVbuuidCodecVersion + 1
+	if _, err := DecodeTsVbuuid(bad); err != ErrInvalidTsVbuuid {
+		t.Fatalf("expected unknown version rejected, got %v", err)
+	}
+}
@@ -94,6 +94,35 @@ func TestAsRecent(t *testing.T) {
 	}
 }
 
+func TestAsRecentVbnos(t *testing.T) {
+	tsRef := NewTsVbuuid("default", 1024)
+	tsRef.Seqnos[3], tsRef.Vbuuids[3] = 5, 30
+	tsRef.Seqnos[700], tsRef.Vbuuids[700] = 9, 70
+	vbnos := []uint16{3, 700}
+
+	ts := NewTsVbuuid("default", 1024)
+	ts.Seqnos[3], ts.Vbuuids[3] = 5, 30
+	ts.Seqnos[700], ts.Vbuuids[700] = 10, 70
+	if ts.AsRecentVbnos(tsRef, vbnos) != ts.AsRecent(tsRef) || !ts.AsRecent(tsRef) {
+		t.Fatal("expected true")
+	}
+
+	// Other vbuckets lagging do not matter
+	ts.Vbuuids[1] = 10
+	if ts.AsRecentVbnos(tsRef, vbnos) == false {
+		t.Fatal("expected true")
+	}
+	ts.Seqnos[700] = 8
+	if ts.AsRecentVbnos(tsRef, vbnos) == true {
+		t.Fatal("expected false")
+	}
+	ts.Seqnos[700] = 9
+	ts.Vbuuids[3] = 31
+	if ts.AsRecentVbnos(tsRef, vbnos) == true {
+		t.Fatal("expected false")
+	}
+}
+
 func BenchmarkCompareVbuuuids(b *testing.B) {
 	ts1 := NewTsVbuuid("default", 1024)
 	for i := uint64(1); i < uint64(1024); i++ {
@@ -333,3 +362,36 @@ func BenchmarkUnionTimestamp(b *testing.B) {
 	}
 }
 */
+
+func TestSnapshotId(t *testing.T) {
+	ts := NewTsVbuuid("default", 1024)
+	for vb := range ts.Seqnos {
+		ts.Seqnos[vb] = uint64(vb * 10)
+		ts.Vbuuids[vb] = uint64(vb + 1)
+	}
+
+	id := ts.SnapshotId()
+	if ts.Copy().SnapshotId() != id {
+		t.Fatal("expected the same id for the same timestamp")
+	}
+
+	other := ts.Copy()
+	other.Snapshots[3] = [2]uint64{1, 100}
+	if other.SnapshotId() != id {
+		t.Fatal("expected snapshot markers not in the id")
+	}
+	other.Seqnos[3]++
+	if other.SnapshotId() == id {
+		t.Fatal("expected a different id for a different seqno")
+	}
+	other = ts.Copy()
+	other.Vbuuids[3]++
+	if other.SnapshotId() == id {
+		t.Fatal("expected a different id for a different vbuuid")
+	}
+
+	var nilTs *TsVbuuid
+	if nilTs.SnapshotId() != 0 {
+		t.Fatal("expected 0 for nil timestamp")
+	}
+}
@@ -1244,6 +1244,20 @@ func IsAllAllowed(creds cbauth.Creds, permissions []string, r *http.Request,
 	return true
 }
 
+// GetScanPermission returns the permission needed to scan an index of defn.
+func GetScanPermission(defn *IndexDefn) string {
+	scope, collection := defn.Scope, defn.Collection
+	if scope == "" {
+		scope = DEFAULT_SCOPE
+	}
+	if collection == "" {
+		collection = DEFAULT_COLLECTION
+	}
+
+	return fmt.Sprintf("cluster.collection[%s:%s:%s].n1ql.select!execute",
+		defn.Bucket, scope, collection)
+}
+
 func ComputePercent(a, b int64) int64 {
 	if a+b > 0 {
 		return a * 100 / (a + b)
@@ -30,6 +30,8 @@ var commandNames = map[byte]string{
 
 	c.UpdateSeqno:   "UpdateSeqno",
 	c.SeqnoAdvanced: "UpdateSeqnoAdvanced",
+
+	c.EvalSkip: "EvalSkip",
 }
 
 // Application starts a new dataport application to receive mutations from the
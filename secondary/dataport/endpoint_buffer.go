@@ -186,7 +186,7 @@ func (b *endpointBuffers) checkSeqOrder(kv *c.KeyVersions, endpoint *RouterEndpo
 
 	case c.Upsert, c.Deletion, c.UpsertDeletion, c.UpdateSeqno, c.SeqnoAdvanced,
 		c.CollectionCreate, c.CollectionDrop, c.CollectionChanged,
-		c.CollectionFlush, c.ScopeCreate, c.ScopeDrop:
+		c.CollectionFlush, c.ScopeCreate, c.ScopeDrop, c.EvalSkip:
 		if s, ok := endpoint.seqOrders[key]; ok && s != nil {
 			if !s.ProcessSeqno(kv.Seqno) {
 				logging.Fatalf("%v seq order violation for vb = %v, seq = %v, command = %v, "+
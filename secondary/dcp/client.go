@@ -50,7 +50,7 @@ func slowLog(startTime time.Time, format string, args ...interface{}) {
 	if elapsed := time.Now().Sub(startTime); elapsed > SlowServerCallWarningThreshold {
 		pc, _, _, _ := runtime.Caller(2)
 		caller := runtime.FuncForPC(pc).Name()
-		logging.Warnf("dcp-client: "+format+" in "+caller+" took "+elapsed.String(), args...)
+		dcpLog.Warnf("dcp-client: "+format+" in "+caller+" took "+elapsed.String(), args...)
 	}
 }
 
@@ -98,7 +98,7 @@ func (b *Bucket) Do(k string, f func(mc *memcached.Client, vb uint16) error) (er
 
 		if retry {
 			if err := b.Refresh(); err != nil {
-				logging.Errorf("Client::Do, error during bucket refersh for bucket: %v, err: %v", b.Name, err)
+				dcpLog.Errorf("Client::Do, error during bucket refersh for bucket: %v, err: %v", b.Name, err)
 				return err
 			}
 		} else {
@@ -217,7 +217,7 @@ func (b *Bucket) doBulkGet(vb uint16, keys []string,
 			conn, err := pool.Get()
 			if err != nil {
 				if isAuthError(err) {
-					logging.Fatalf(" Fatal Auth Error %v", err)
+					dcpLog.Fatalf(" Fatal Auth Error %v", err)
 					return err
 				}
 				// retry
@@ -231,7 +231,7 @@ func (b *Bucket) doBulkGet(vb uint16, keys []string,
 				st := err.(*transport.MCResponse).Status
 				if st == transport.NOT_MY_VBUCKET {
 					if err := b.Refresh(); err != nil {
-						logging.Errorf("Client::doBulkGet, error during bucket refersh for bucket: %v, err: %v", b.Name, err)
+						dcpLog.Errorf("Client::doBulkGet, error during bucket refersh for bucket: %v, err: %v", b.Name, err)
 						return err
 					}
 					// retry
@@ -244,9 +244,9 @@ func (b *Bucket) doBulkGet(vb uint16, keys []string,
 					ch <- rv
 					return err
 				}
-				logging.Warnf("Connection Error: %s. Refreshing bucket", err.Error())
+				dcpLog.Warnf("Connection Error: %s. Refreshing bucket", err.Error())
 				if err := b.Refresh(); err != nil {
-					logging.Errorf("Client::Do, error during bucket refersh for bucket: %v, err: %v", b.Name, err)
+					dcpLog.Errorf("Client::Do, error during bucket refersh for bucket: %v, err: %v", b.Name, err)
 					return err
 				}
 				// retry
@@ -8,6 +8,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/couchbase/indexing/secondary/common/collections"
+	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/couchbase/indexing/secondary/dcp/transport"
@@ -132,6 +134,10 @@ func (b *Bucket) GetsRawC(k, cid string) (data []byte, flags int,
 
 	encK := fmt.Sprintf("%s", encBytes)
 	err = b.Do(k, func(mc *memcached.Client, vb uint16) error {
+		if err := mc.EnableCollections("GetsRawC-Client"); err != nil {
+			return err
+		}
+
 		res, err := mc.Get(vb, encK)
 		if err != nil {
 			return err
@@ -146,6 +152,38 @@ func (b *Bucket) GetsRawC(k, cid string) (data []byte, flags int,
 	return
 }
 
+// GetRandomDocC gets a random document of this collection, from the node of
+// a random vbucket, including its key and CAS counter.
+func (b *Bucket) GetRandomDocC(cid string) (k string, data []byte,
+	cas uint64, err error) {
+
+	if ClientOpCallback != nil {
+		defer func(t time.Time) { ClientOpCallback("GetRandomDocC", k, t, err) }(time.Now())
+	}
+
+	id, err := strconv.ParseUint(cid, 16, 32)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	err = b.Do(strconv.Itoa(rand.Int()), func(mc *memcached.Client, vb uint16) error {
+		if err := mc.EnableCollections("GetRandomDocC-Client"); err != nil {
+			return err
+		}
+
+		res, err := mc.GetRandomDoc(uint32(id))
+		if err != nil {
+			return err
+		}
+		key, _ := collections.LEB128Dec(res.Key)
+		k = string(key)
+		cas = res.Cas
+		data = res.Body
+		return nil
+	})
+	return
+}
+
 // Gets gets a value from this bucket, including its CAS counter from
 // this collection for key `k`
 //  The value is expected to be a JSON stream and will be deserialized
@@ -250,8 +250,8 @@ func (b *Bucket) getConnPool(i int) *connectionPool {
 
 	defer func() {
 		if r := recover(); r != nil {
-			logging.Errorf("bucket(%v) getConnPool crashed: %v\n", b.Name, r)
-			logging.Errorf("%s", logging.StackTrace())
+			dcpLog.Errorf("bucket(%v) getConnPool crashed: %v\n", b.Name, r)
+			dcpLog.Errorf("%s", logging.StackTrace())
 		}
 	}()
 
@@ -270,8 +270,8 @@ func (b *Bucket) getMasterNode(i int) string {
 
 	defer func() {
 		if r := recover(); r != nil {
-			logging.Errorf("bucket(%v) getMasterNode crashed: %v\n", b.Name, r)
-			logging.Errorf("%s", logging.StackTrace())
+			dcpLog.Errorf("bucket(%v) getMasterNode crashed: %v\n", b.Name, r)
+			dcpLog.Errorf("%s", logging.StackTrace())
 		}
 	}()
 
@@ -371,7 +371,7 @@ func queryRestAPIOnLocalhost(
 	responseBody := ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
 	d := json.NewDecoder(responseBody)
 	if err = d.Decode(&out); err != nil {
-		logging.Errorf("queryRestAPI: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bodyBytes), err)
+		dcpLog.Errorf("queryRestAPI: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bodyBytes), err)
 		return err
 	}
 	return nil
@@ -385,7 +385,7 @@ func (c *Client) RunObservePool(pool string, callb func(interface{}) error, canc
 		var pool Pool
 		var err error
 		if err = json.Unmarshal(bs, &pool); err != nil {
-			logging.Errorf("RunObservePool: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bs), err)
+			dcpLog.Errorf("RunObservePool: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bs), err)
 		}
 		return &pool, err
 	}
@@ -401,7 +401,7 @@ func (c *Client) RunObserveNodeServices(pool string, callb func(interface{}) err
 		var ps PoolServices
 		var err error
 		if err = json.Unmarshal(bs, &ps); err != nil {
-			logging.Errorf("RunObserveNodeServices: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bs), err)
+			dcpLog.Errorf("RunObserveNodeServices: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bs), err)
 		}
 		return &ps, err
 	}
@@ -416,7 +416,7 @@ func (c *Client) RunObserveCollectionManifestChanges(pool, bucket string, callb
 		var b Bucket
 		var err error
 		if err = json.Unmarshal(bs, &b); err != nil {
-			logging.Errorf("RunObserveCollectionManifestChanges: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bs), err)
+			dcpLog.Errorf("RunObserveCollectionManifestChanges: Error while decoding the response from path: %s, response body: %s, err: %v", path, string(bs), err)
 		}
 		return &b, err
 	}
@@ -739,7 +739,7 @@ loop:
 	retry, manifest, err := c.GetCollectionManifest(bucketn)
 	if retry && retryCount <= 5 {
 		retryCount++
-		logging.Warnf("cluster_info: Out of sync for bucket %s. Retrying for GetIndexScopeLimit..", bucketn)
+		dcpLog.Warnf("cluster_info: Out of sync for bucket %s. Retrying for GetIndexScopeLimit..", bucketn)
 		time.Sleep(500 * time.Millisecond)
 		goto loop
 	}
@@ -762,7 +762,7 @@ loop:
 		if retryCount > 5 {
 			return err
 		}
-		logging.Warnf("cluster_info: Out of sync for bucket %s. Retrying to getTerseBucket. retry count %v", bucketn, retryCount)
+		dcpLog.Warnf("cluster_info: Out of sync for bucket %s. Retrying to getTerseBucket. retry count %v", bucketn, retryCount)
 		time.Sleep(5 * time.Millisecond)
 		goto loop
 	}
@@ -794,7 +794,7 @@ loop:
 	for _, b := range buckets {
 		retry, nb, err := p.getTerseBucket(b.Name)
 		if retry {
-			logging.Warnf("cluster_info: Out of sync for bucket %s. Retrying for getTerseBucket..", b.Name)
+			dcpLog.Warnf("cluster_info: Out of sync for bucket %s. Retrying for getTerseBucket..", b.Name)
 			time.Sleep(5 * time.Millisecond)
 			goto loop
 		}
@@ -808,7 +808,7 @@ loop:
 		if version >= 7 {
 			retry, manifest, err := p.getCollectionManifest(b.Name)
 			if retry {
-				logging.Warnf("cluster_info: Out of sync for bucket %s. Retrying for getBucketManifest..", b.Name)
+				dcpLog.Warnf("cluster_info: Out of sync for bucket %s. Retrying for getBucketManifest..", b.Name)
 				time.Sleep(5 * time.Millisecond)
 				goto loop
 			}
@@ -834,7 +834,7 @@ retry:
 		retry, manifest, err := p.getCollectionManifest(bucket)
 		if retry && retryCount <= 5 {
 			retryCount++
-			logging.Warnf("cluster_info: Retrying to getBucketManifest for bucket %s", bucket)
+			dcpLog.Warnf("cluster_info: Retrying to getBucketManifest for bucket %s", bucket)
 			time.Sleep(1 * time.Millisecond)
 			goto retry
 		}
@@ -993,7 +993,7 @@ func (b *Bucket) Close() {
 
 func bucketFinalizer(b *Bucket) {
 	if b.connPools != nil {
-		logging.Warnf("Warning: Finalizing a bucket with active connections.")
+		dcpLog.Warnf("Warning: Finalizing a bucket with active connections.")
 	}
 }
 
@@ -52,6 +52,9 @@ var ErrorInvalidFeed = errors.New("dcp.invalidFeed")
 var ErrorEnableCollections = errors.New("dcp.EnableCollections")
 var ErrorCollectionsNotEnabled = errors.New("dcp.ErrorCollectionsNotEnabled")
 
+// ErrorStreamIdsNotEnabled
+var ErrorStreamIdsNotEnabled = errors.New("dcp.ErrorStreamIdsNotEnabled")
+
 var DcpFeedNamePrefix = "secidx:"
 var DcpFeedNameCompPrefix = "proj-"
 var DcpFeedPrefix = DcpFeedNamePrefix + DcpFeedNameCompPrefix
@@ -63,7 +66,7 @@ type DcpFeed struct {
 	name      string
 	opaque    uint16
 	outch     chan<- *DcpEvent      // Exported channel for receiving DCP events
-	vbstreams map[uint16]*DcpStream // vb->stream mapping
+	vbstreams map[uint32]*DcpStream // streamKey(vb, stream-id)->stream mapping
 	// genserver
 	reqch     chan []interface{}
 	supvch    chan []interface{}
@@ -73,6 +76,9 @@ type DcpFeed struct {
 	collectionsAware bool
 	osoSnapshot      bool
 	isIncrBuild      bool // Set to true for Incremental builds (only from 7.0 cluster version)
+	// Stream-ids allow more than one stream per vbucket on this connection,
+	// one for each stream-id.
+	streamIds bool
 	// stats
 	toAckBytes         uint32    // bytes client has read
 	maxAckBytes        uint32    // Max buffer control ack bytes
@@ -83,7 +89,7 @@ type DcpFeed struct {
 
 	// Book-keeping for verifying sequence order.
 	// TODO: This introduces a map lookup in mutation path. Need to anlayse perf implication.
-	seqOrders map[uint16]transport.SeqOrderState // vb ==> state maintained for checking seq order
+	seqOrders map[uint32]transport.SeqOrderState // streamKey ==> state maintained for checking seq order
 
 	truncName string
 }
@@ -101,14 +107,14 @@ func NewDcpFeed(
 		name:      name,
 		outch:     outch,
 		opaque:    opaque,
-		vbstreams: make(map[uint16]*DcpStream),
+		vbstreams: make(map[uint32]*DcpStream),
 		reqch:     make(chan []interface{}, genChanSize),
 		supvch:    supvch,
 		finch:     make(chan bool),
 		// TODO: would be nice to add host-addr as part of prefix.
 		logPrefix: fmt.Sprintf("DCPT[%s]", name),
 		stats:     &DcpStats{},
-		seqOrders: make(map[uint16]transport.SeqOrderState),
+		seqOrders: make(map[uint32]transport.SeqOrderState),
 	}
 
 	feed.truncName = name
@@ -140,6 +146,10 @@ func NewDcpFeed(
 		feed.osoSnapshot = config["osoSnapshot"].(bool)
 	}
 
+	if _, ok := config["streamIds"]; ok {
+		feed.streamIds = feed.collectionsAware && config["streamIds"].(bool)
+	}
+
 	go feed.genServer(opaque, feed.reqch, feed.finch, rcvch, config)
 	go feed.doReceive(rcvch, feed.finch, mc)
 	logging.Infof("%v ##%x feed started ...", feed.logPrefix, opaque)
@@ -205,11 +215,24 @@ func (feed *DcpFeed) DcpRequestStream(vbno, opaqueMSB uint16, flags uint32,
 	vuuid, startSequence, endSequence, snapStart, snapEnd uint64,
 	manifestUID, scopeId string, collectionIds []string) error {
 
+	return feed.DcpRequestStreamWithId(0, vbno, opaqueMSB, flags, vuuid,
+		startSequence, endSequence, snapStart, snapEnd,
+		manifestUID, scopeId, collectionIds)
+}
+
+// DcpRequestStreamWithId for a single vbucket, identified by a non-zero
+// streamId on this connection. Needs the feed to be opened with
+// "streamIds" enabled. A streamId of zero is same as DcpRequestStream.
+func (feed *DcpFeed) DcpRequestStreamWithId(streamId, vbno, opaqueMSB uint16,
+	flags uint32,
+	vuuid, startSequence, endSequence, snapStart, snapEnd uint64,
+	manifestUID, scopeId string, collectionIds []string) error {
+
 	respch := make(chan []interface{}, 1)
 	cmd := []interface{}{
 		dfCmdRequestStream, vbno, opaqueMSB, flags, vuuid,
 		startSequence, endSequence, snapStart, snapEnd,
-		manifestUID, scopeId, collectionIds,
+		manifestUID, scopeId, collectionIds, streamId,
 		respch}
 	resp, err := failsafeOp(feed.reqch, respch, cmd, feed.finch)
 	return opError(err, resp, 0)
@@ -217,12 +240,23 @@ func (feed *DcpFeed) DcpRequestStream(vbno, opaqueMSB uint16, flags uint32,
 
 // CloseStream for specified vbucket.
 func (feed *DcpFeed) CloseStream(vbno, opaqueMSB uint16) error {
+	return feed.CloseStreamWithId(0, vbno, opaqueMSB)
+}
+
+// CloseStreamWithId for specified vbucket and streamId.
+func (feed *DcpFeed) CloseStreamWithId(streamId, vbno, opaqueMSB uint16) error {
 	respch := make(chan []interface{}, 1)
-	cmd := []interface{}{dfCmdCloseStream, vbno, opaqueMSB, respch}
+	cmd := []interface{}{dfCmdCloseStream, vbno, opaqueMSB, streamId, respch}
 	resp, err := failsafeOp(feed.reqch, respch, cmd, feed.finch)
 	return opError(err, resp, 0)
 }
 
+// StreamIdsEnabled returns true if streams on this feed can be multiplexed
+// by stream-id.
+func (feed *DcpFeed) StreamIdsEnabled() bool {
+	return feed.streamIds
+}
+
 // Close this DcpFeed.
 func (feed *DcpFeed) Close() error {
 	respch := make(chan []interface{}, 1)
@@ -317,19 +351,21 @@ loop:
 				manifestUID := msg[9].(string)
 				scopeId := msg[10].(string)
 				collectionIds := msg[11].([]string)
+				streamId := msg[12].(uint16)
 
 				err := feed.doDcpRequestStream(
-					vbno, opaqueMSB, flags, vuuid,
+					streamId, vbno, opaqueMSB, flags, vuuid,
 					startSequence, endSequence, snapStart, snapEnd,
 					manifestUID, scopeId, collectionIds)
 
-				respch := msg[12].(chan []interface{})
+				respch := msg[13].(chan []interface{})
 				respch <- []interface{}{err}
 
 			case dfCmdCloseStream:
 				vbno, opaqueMSB := msg[1].(uint16), msg[2].(uint16)
-				respch := msg[3].(chan []interface{})
-				err := feed.doDcpCloseStream(vbno, opaqueMSB)
+				streamId := msg[3].(uint16)
+				respch := msg[4].(chan []interface{})
+				err := feed.doDcpCloseStream(streamId, vbno, opaqueMSB)
 				respch <- []interface{}{err}
 
 			case dfCmdClose:
@@ -378,10 +414,11 @@ func (feed *DcpFeed) handlePacket(
 		Body:   pkt.Body,
 	}
 	vb := vbOpaque(pkt.Opaque)
+	key := streamKey(vb, feed.packetStreamId(pkt))
 
 	sendAck := false
 	prefix := feed.logPrefix
-	stream := feed.vbstreams[vb]
+	stream := feed.vbstreams[key]
 	if stream == nil {
 		feed.stats.TotalSpurious.Add(1)
 		// log first 10000 spurious messages
@@ -406,7 +443,7 @@ func (feed *DcpFeed) handlePacket(
 		feed.stats.TotalStreamReq.Add(1)
 
 		if !feed.osoSnapshot {
-			feed.seqOrders[vb] = transport.NewSeqOrderState()
+			feed.seqOrders[key] = transport.NewSeqOrderState()
 		}
 
 	case transport.DCP_MUTATION, transport.DCP_DELETION,
@@ -416,22 +453,22 @@ func (feed *DcpFeed) handlePacket(
 		feed.stats.TotalMutation.Add(1)
 		sendAck = true
 
-		feed.checkSeqOrder(event, vb, pkt.Opcode)
+		feed.checkSeqOrder(event, key, pkt.Opcode)
 
 	case transport.DCP_STREAMEND:
 		event = newDcpEvent(pkt, stream)
 		sendAck = true
-		delete(feed.vbstreams, vb)
-		feed.supvch <- []interface{}{transport.DCP_STREAMEND, feed, vb}
+		delete(feed.vbstreams, key)
+		feed.supvch <- []interface{}{transport.DCP_STREAMEND, feed, vb, stream.StreamId}
 		fmsg := "%v ##%x DCP_STREAMEND for vb %d\n"
 		logging.Debugf(fmsg, prefix, stream.AppOpaque, vb)
 		feed.stats.TotalStreamEnd.Add(1)
 
 		if !feed.osoSnapshot {
-			if s, ok := feed.seqOrders[vb]; ok && s != nil && s.GetErrCount() != 0 {
+			if s, ok := feed.seqOrders[key]; ok && s != nil && s.GetErrCount() != 0 {
 				logging.Fatalf("%v error count for sequence number ordering is %v", prefix, s.GetErrCount())
 			}
-			feed.seqOrders[vb] = nil
+			feed.seqOrders[key] = nil
 		}
 
 	case transport.DCP_SNAPSHOT:
@@ -450,26 +487,28 @@ func (feed *DcpFeed) handlePacket(
 		fmsg := "%v ##%x DCP_SNAPSHOT for vb %d\n"
 		logging.Debugf(fmsg, prefix, stream.AppOpaque, vb)
 
-		feed.checkSnapOrder(event, vb, pkt.Opcode)
+		feed.checkSnapOrder(event, key, pkt.Opcode)
 
 	case transport.DCP_FLUSH:
 		event = newDcpEvent(pkt, stream) // special processing ?
 
 	case transport.DCP_CLOSESTREAM:
 		event = newDcpEvent(pkt, stream)
-		if event.Opaque != stream.CloseOpaque {
+		// With stream-ids, opaque carries the stream-id instead of the
+		// application opaque.
+		if stream.StreamId == 0 && event.Opaque != stream.CloseOpaque {
 			fmsg := "%v ##%x DCP_CLOSESTREAM mismatch in opaque %v != %v\n"
 			logging.Fatalf(
 				fmsg, prefix, stream.AppOpaque, event.Opaque, stream.CloseOpaque)
 		}
 		event.Opcode = transport.DCP_STREAMEND // opcode re-write !!
 		event.Opaque = stream.AppOpaque        // opaque re-write !!
-		delete(feed.vbstreams, vb)
+		delete(feed.vbstreams, key)
 		fmsg := "%v ##%x DCP_CLOSESTREAM for vb %d\n"
 		logging.Debugf(fmsg, prefix, stream.AppOpaque, vb)
 		feed.stats.TotalCloseStream.Add(1)
 		if !feed.osoSnapshot {
-			feed.seqOrders[vb] = nil
+			feed.seqOrders[key] = nil
 		}
 
 	case transport.DCP_CONTROL, transport.DCP_BUFFERACK:
@@ -490,7 +529,7 @@ func (feed *DcpFeed) handlePacket(
 		fmsg := "%v ##%x DCP_SYSTEM_EVENT for vb %d, eventType: %v, manifestUID: %s, scopeId: %s, collectionId: %x\n"
 		logging.Debugf(fmsg, prefix, stream.AppOpaque, vb, event.EventType, event.ManifestUID, event.ScopeID, event.CollectionID)
 
-		feed.checkSeqOrder(event, vb, pkt.Opcode)
+		feed.checkSeqOrder(event, key, pkt.Opcode)
 
 	case transport.DCP_SEQNO_ADVANCED:
 		event = newDcpEvent(pkt, stream)
@@ -499,7 +538,7 @@ func (feed *DcpFeed) handlePacket(
 
 		if len(pkt.Extras) == dcpSeqnoAdvExtrasLen {
 			event.Seqno = binary.BigEndian.Uint64(pkt.Extras)
-			feed.checkSeqOrder(event, vb, pkt.Opcode)
+			feed.checkSeqOrder(event, key, pkt.Opcode)
 		} else {
 			fmsg := "%v ##%x DCP_SEQNO_ADVANCED for vb %d. Expected extras len: %v, received: %v\n"
 			logging.Fatalf(fmsg, prefix, stream.AppOpaque, vb, dcpSeqnoAdvExtrasLen, len(pkt.Extras))
@@ -541,24 +580,24 @@ func (feed *DcpFeed) handlePacket(
 	return "ok"
 }
 
-func (feed *DcpFeed) checkSeqOrder(event *DcpEvent, vb uint16, opcode transport.CommandCode) {
+func (feed *DcpFeed) checkSeqOrder(event *DcpEvent, key uint32, opcode transport.CommandCode) {
 	if !feed.osoSnapshot {
-		if s, ok := feed.seqOrders[vb]; ok && s != nil {
+		if s, ok := feed.seqOrders[key]; ok && s != nil {
 			if !s.ProcessSeqno(event.Seqno) {
 				logging.Fatalf("%v seq order violation for vb = %v, seq = %v, opcode = %v, "+
-					"orderState = %v, event = %v", feed.logPrefix, vb, event.Seqno, opcode,
+					"orderState = %v, event = %v", feed.logPrefix, event.VBucket, event.Seqno, opcode,
 					s.GetInfo(), event.GetDebugInfo())
 			}
 		}
 	}
 }
 
-func (feed *DcpFeed) checkSnapOrder(event *DcpEvent, vb uint16, opcode transport.CommandCode) {
+func (feed *DcpFeed) checkSnapOrder(event *DcpEvent, key uint32, opcode transport.CommandCode) {
 	if !feed.osoSnapshot {
-		if s, ok := feed.seqOrders[vb]; ok && s != nil {
+		if s, ok := feed.seqOrders[key]; ok && s != nil {
 			if snapInfo, correctSnapOrder := s.ProcessSnapshot(event.SnapstartSeq, event.SnapendSeq); !correctSnapOrder {
 				logging.Fatalf("%v ##%x seq order violation for snapshot message for vb = %v, opcode = %v, "+
-					"orderState = %v, event = %v", feed.logPrefix, feed.opaque, vb, opcode,
+					"orderState = %v, event = %v", feed.logPrefix, feed.opaque, event.VBucket, opcode,
 					snapInfo, event.GetDebugInfo())
 			}
 		}
@@ -947,18 +986,36 @@ func (feed *DcpFeed) doDcpOpen(
 		}
 	}
 
+	if feed.streamIds {
+		if err := feed.enableStreamIds(rcvch); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (feed *DcpFeed) doDcpRequestStream(
-	vbno, opaqueMSB uint16, flags uint32,
+	streamId, vbno, opaqueMSB uint16, flags uint32,
 	vuuid, startSequence, endSequence, snapStart, snapEnd uint64,
 	manifestUID, scopeId string, collectionIds []string) error {
 
+	prefix := feed.logPrefix
+	if streamId != 0 && !feed.streamIds {
+		fmsg := "%v ##%x doDcpRequestStream stream-id %v for vb %d: stream-ids not enabled"
+		logging.Errorf(fmsg, prefix, opaqueMSB, streamId, vbno)
+		return ErrorStreamIdsNotEnabled
+	} else if streamId == 0 && feed.streamIds {
+		// DCP rejects streams without stream-id once enabled
+		fmsg := "%v ##%x doDcpRequestStream missing stream-id for vb %d"
+		logging.Errorf(fmsg, prefix, opaqueMSB, vbno)
+		return ErrorInvalidFeed
+	}
+
 	rq := &transport.MCRequest{
 		Opcode:  transport.DCP_STREAMREQ,
 		VBucket: vbno,
-		Opaque:  composeOpaque(vbno, opaqueMSB),
+		Opaque:  feed.streamOpaque(streamId, vbno, opaqueMSB),
 	}
 	rq.Extras = make([]byte, 48) // #Extras
 	binary.BigEndian.PutUint32(rq.Extras[:4], flags)
@@ -969,9 +1026,7 @@ func (feed *DcpFeed) doDcpRequestStream(
 	binary.BigEndian.PutUint64(rq.Extras[32:40], snapStart)
 	binary.BigEndian.PutUint64(rq.Extras[40:48], snapEnd)
 
-	prefix := feed.logPrefix
-
-	requestValue := &StreamRequestValue{}
+	requestValue := &StreamRequestValue{StreamID: streamId}
 
 	if feed.collectionsAware {
 		if scopeId != "" || len(collectionIds) > 0 {
@@ -1006,6 +1061,7 @@ func (feed *DcpFeed) doDcpRequestStream(
 	feed.stats.LastMsgSend.Set(time.Now().UnixNano())
 	stream := &DcpStream{
 		AppOpaque:        opaqueMSB,
+		StreamId:         streamId,
 		Vbucket:          vbno,
 		Vbuuid:           vuuid,
 		StartSeq:         startSequence,
@@ -1013,23 +1069,26 @@ func (feed *DcpFeed) doDcpRequestStream(
 		CollectionsAware: feed.collectionsAware,
 		RequestValue:     requestValue,
 	}
-	feed.vbstreams[vbno] = stream
+	feed.vbstreams[streamKey(vbno, streamId)] = stream
 	return nil
 }
 
-func (feed *DcpFeed) doDcpCloseStream(vbno, opaqueMSB uint16) error {
+func (feed *DcpFeed) doDcpCloseStream(streamId, vbno, opaqueMSB uint16) error {
 	prefix := feed.logPrefix
-	stream, ok := feed.vbstreams[vbno]
+	stream, ok := feed.vbstreams[streamKey(vbno, streamId)]
 	if !ok || stream == nil {
-		fmsg := "%v ##%x stream for vb %d is not active"
-		logging.Warnf(fmsg, prefix, opaqueMSB, vbno)
+		fmsg := "%v ##%x stream for vb %d (stream-id %v) is not active"
+		logging.Warnf(fmsg, prefix, opaqueMSB, vbno, streamId)
 		return nil // TODO: should we return error here ?
 	}
 	stream.CloseOpaque = opaqueMSB
 	rq := &transport.MCRequest{
 		Opcode:  transport.DCP_CLOSESTREAM,
 		VBucket: vbno,
-		Opaque:  composeOpaque(vbno, opaqueMSB),
+		Opaque:  feed.streamOpaque(streamId, vbno, opaqueMSB),
+	}
+	if streamId != 0 {
+		rq.FramingExtras = transport.StreamIdFrame(streamId)
 	}
 
 	// In case of DCP_CLOSESTREAM, feed.conn.Transmit won't have any
@@ -1129,17 +1188,60 @@ func (feed *DcpFeed) enableOSOSnapshot(rcvch chan []interface{}) error {
 	return nil
 }
 
+func (feed *DcpFeed) enableStreamIds(rcvch chan []interface{}) error {
+	prefix := feed.logPrefix
+	opaque := feed.opaque
+
+	rq := &transport.MCRequest{
+		Opcode: transport.DCP_CONTROL,
+		Key:    []byte("enable_stream_id"),
+		Body:   []byte("true"),
+	}
+	if err := feed.conn.Transmit(rq); err != nil {
+		fmsg := "%v ##%x doDcpOpen.Transmit DCP_CONTROL (enable_stream_id): %v"
+		logging.Errorf(fmsg, prefix, opaque, err)
+		return err
+	}
+	feed.stats.LastMsgSend.Set(time.Now().UnixNano())
+	logging.Infof("%v ##%x sending DCP_CONTROL (enable_stream_id)", prefix, opaque)
+	msg, ok := <-rcvch
+	if !ok {
+		fmsg := "%v ##%x doDcpOpen.rcvch (enable_stream_id) closed"
+		logging.Errorf(fmsg, prefix, opaque)
+		return ErrorConnection
+	}
+	feed.stats.LastMsgRecv.Set(time.Now().UnixNano())
+
+	pkt := msg[0].(*transport.MCRequest)
+	opcode, status := pkt.Opcode, transport.Status(pkt.VBucket)
+	if opcode != transport.DCP_CONTROL {
+		fmsg := "%v ##%x DCP_CONTROL (enable_stream_id) != #%v"
+		logging.Errorf(fmsg, prefix, opaque, opcode)
+		return ErrorConnection
+	} else if status != transport.SUCCESS {
+		fmsg := "%v ##%x doDcpOpen (enable_stream_id) response status %v"
+		logging.Errorf(fmsg, prefix, opaque, status)
+		return ErrorStreamIdsNotEnabled
+	}
+
+	fmsg := "%v ##%x received response for DCP_CONTROL (enable_stream_id)"
+	logging.Infof(fmsg, prefix, opaque)
+	return nil
+}
+
 // generate stream end responses for all active vb streams
 func (feed *DcpFeed) sendStreamEnd(outch chan<- *DcpEvent) {
 	if feed.vbstreams != nil {
-		for vb, stream := range feed.vbstreams {
-			feed.supvch <- []interface{}{transport.DCP_STREAMEND, feed, vb}
+		for _, stream := range feed.vbstreams {
+			vb := stream.Vbucket
+			feed.supvch <- []interface{}{transport.DCP_STREAMEND, feed, vb, stream.StreamId}
 			dcpEvent := &DcpEvent{
-				VBucket: vb,
-				VBuuid:  stream.Vbuuid,
-				Opcode:  transport.DCP_STREAMEND,
-				Opaque:  stream.AppOpaque,
-				Ctime:   time.Now().UnixNano(),
+				VBucket:  vb,
+				VBuuid:   stream.Vbuuid,
+				Opcode:   transport.DCP_STREAMEND,
+				Opaque:   stream.AppOpaque,
+				StreamId: stream.StreamId,
+				Ctime:    time.Now().UnixNano(),
 			}
 			outch <- dcpEvent
 		}
@@ -1157,14 +1259,14 @@ func (feed *DcpFeed) handleStreamRequest(
 		fmsg := "%v ##%x STREAMREQ(%v) invalid rollback: %v\n"
 		arg1 := logging.TagUD(res.Body)
 		logging.Errorf(fmsg, prefix, stream.AppOpaque, vb, arg1)
-		delete(feed.vbstreams, vb)
+		delete(feed.vbstreams, streamKey(vb, stream.StreamId))
 
 	case res.Status == transport.ROLLBACK:
 		rollback := binary.BigEndian.Uint64(res.Body)
 		event.Status, event.Seqno = res.Status, rollback
 		fmsg := "%v ##%x STREAMREQ(%v) with rollback %d\n"
 		logging.Warnf(fmsg, prefix, stream.AppOpaque, vb, rollback)
-		delete(feed.vbstreams, vb)
+		delete(feed.vbstreams, streamKey(vb, stream.StreamId))
 
 	case res.Status == transport.SUCCESS:
 		event.Status, event.Seqno = res.Status, stream.StartSeq
@@ -1185,13 +1287,13 @@ func (feed *DcpFeed) handleStreamRequest(
 		event.VBucket = vb
 		fmsg := "%v ##%x STREAMREQ(%v) with status: %v, stream request value: %+v\n"
 		logging.Errorf(fmsg, prefix, stream.AppOpaque, vb, res.Status, stream.RequestValue)
-		delete(feed.vbstreams, vb)
+		delete(feed.vbstreams, streamKey(vb, stream.StreamId))
 	default:
 		event.Status = res.Status
 		event.VBucket = vb
 		fmsg := "%v ##%x STREAMREQ(%v) unexpected status: %v\n"
 		logging.Errorf(fmsg, prefix, stream.AppOpaque, vb, res.Status)
-		delete(feed.vbstreams, vb)
+		delete(feed.vbstreams, streamKey(vb, stream.StreamId))
 	}
 	return
 }
@@ -1241,6 +1343,34 @@ func composeOpaque(vbno, opaqueMSB uint16) uint32 {
 	return (uint32(opaqueMSB) << 16) | uint32(vbno)
 }
 
+// streamOpaque is the opaque for DCP requests of a stream. Streams with a
+// stream-id carry the stream-id in place of the application opaque, so
+// that responses from DCP can be told apart for the same vbucket. The
+// application opaque is restored from DcpStream.
+func (feed *DcpFeed) streamOpaque(streamId, vbno, opaqueMSB uint16) uint32 {
+	if streamId != 0 {
+		return composeOpaque(vbno, streamId)
+	}
+	return composeOpaque(vbno, opaqueMSB)
+}
+
+// packetStreamId returns the stream-id of a packet received from DCP, zero
+// when stream-ids are not enabled.
+func (feed *DcpFeed) packetStreamId(pkt *transport.MCRequest) uint16 {
+	if !feed.streamIds {
+		return 0
+	}
+	if streamId, ok := pkt.StreamId(); ok {
+		return streamId
+	}
+	// Responses do not carry framing extras.
+	return appOpaque(pkt.Opaque)
+}
+
+func streamKey(vbno, streamId uint16) uint32 {
+	return (uint32(streamId) << 16) | uint32(vbno)
+}
+
 func appOpaque(opq32 uint32) uint16 {
 	return uint16((opq32 & 0xFFFF0000) >> 16)
 }
@@ -1253,13 +1383,14 @@ type StreamRequestValue struct {
 	ManifestUID   string   `json:"uid,omitempty"`
 	CollectionIDs []string `json:"collections,omitempty"`
 	ScopeID       string   `json:"scope,omitempty"`
-	StreamID      string   `json:"sid,omitempty"`
+	StreamID      uint16   `json:"sid,omitempty"`
 }
 
 // DcpStream is per stream data structure over an DCP Connection.
 type DcpStream struct {
 	AppOpaque        uint16
 	CloseOpaque      uint16
+	StreamId         uint16 // Non-zero when multiplexed by stream-id
 	Vbucket          uint16 // Vbucket id
 	Vbuuid           uint64 // vbucket uuid
 	Seqno            uint64
@@ -1280,6 +1411,7 @@ type DcpEvent struct {
 	Datatype   uint8                 // Datatype per binary protocol
 	VBucket    uint16                // VBucket this event applies to
 	Opaque     uint16                // 16 MSB of opaque
+	StreamId   uint16                // Stream-id, if multiplexed by stream-id
 	VBuuid     uint64                // This field is set by downstream
 	Key, Value []byte                // Item key/value
 	OldValue   []byte                // TODO: TBD: old document value
@@ -1351,6 +1483,9 @@ func newDcpEvent(rq *transport.MCRequest, stream *DcpStream) (event *DcpEvent) {
 	// 16 LSBits are used by client library to encode vbucket number.
 	// 16 MSBits are left for application to multiplex on opaque value.
 	event.Opaque = appOpaque(rq.Opaque)
+	if stream.StreamId != 0 {
+		event.Opaque, event.StreamId = stream.AppOpaque, stream.StreamId
+	}
 
 	if len(rq.Extras) >= tapMutationExtraLen {
 		event.Seqno = binary.BigEndian.Uint64(rq.Extras[:8])
@@ -221,6 +221,20 @@ func (c *Client) Get(vb uint16, key string) (*transport.MCResponse, error) {
 	})
 }
 
+// GetRandomDoc gets a random document of collection cid from the server,
+// with the key of the document in the response. The collection is ignored
+// unless collections are enabled.
+func (c *Client) GetRandomDoc(cid uint32) (*transport.MCResponse, error) {
+	req := &transport.MCRequest{
+		Opcode: transport.GET_RANDOM_KEY,
+	}
+	if c.IsCollectionsEnabled() {
+		req.Extras = make([]byte, 4)
+		binary.BigEndian.PutUint32(req.Extras, cid)
+	}
+	return c.Send(req)
+}
+
 func IsUnknownScopeOrCollection(e error) bool {
 	return transport.IsUnknownScopeOrCollection(e)
 }
@@ -8,6 +8,14 @@ import (
 const (
 	REQ_MAGIC = 0x80
 	RES_MAGIC = 0x81
+	// Alternative request encoding, carrying framing extras.
+	ALT_REQ_MAGIC = 0x08
+)
+
+// Framing extras identifiers
+const (
+	// DCP stream-id, sent with DCP messages when stream-ids are enabled.
+	FRAME_DCP_STREAM_ID = 0x02
 )
 
 // CommandCode for memcached packets.
@@ -91,6 +99,8 @@ const (
 	SELECT_BUCKET = CommandCode(0x89) // Select bucket
 
 	OBSERVE = CommandCode(0x92)
+
+	GET_RANDOM_KEY = CommandCode(0xb6) // Get a random document
 )
 
 const FEATURE_COLLECTIONS byte = 0x12
@@ -224,6 +234,7 @@ func init() {
 	CommandNames[DCP_SYSTEM_EVENT] = "DCP_SYSTEM_EVENT"
 	CommandNames[DCP_SEQNO_ADVANCED] = "DCP_SEQNO_ADVANCED"
 	CommandNames[DCP_OSO_SNAPSHOT] = "DCP_OSO_SNAPSHOT"
+	CommandNames[GET_RANDOM_KEY] = "GET_RANDOM_KEY"
 
 	StatusNames = make(map[Status]string)
 	StatusNames[SUCCESS] = "SUCCESS"
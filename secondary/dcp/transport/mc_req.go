@@ -20,13 +20,42 @@ type MCRequest struct {
 	Opaque uint32
 	// The vbucket to which this command belongs
 	VBucket uint16
+	// Framing extras, when set the request is encoded with ALT_REQ_MAGIC
+	FramingExtras []byte
 	// Command extras, key, and body
 	Extras, Key, Body []byte
 }
 
 // Size gives the number of bytes this request requires.
 func (req *MCRequest) Size() int {
-	return HDR_LEN + len(req.Extras) + len(req.Key) + len(req.Body)
+	return HDR_LEN + len(req.FramingExtras) + len(req.Extras) + len(req.Key) +
+		len(req.Body)
+}
+
+// StreamIdFrame encodes the framing extras for a DCP stream-id.
+func StreamIdFrame(streamId uint16) []byte {
+	frame := make([]byte, 3)
+	frame[0] = FRAME_DCP_STREAM_ID<<4 | 2 // id | length
+	binary.BigEndian.PutUint16(frame[1:], streamId)
+	return frame
+}
+
+// StreamId returns the DCP stream-id in the framing extras of this request,
+// and false if there is none.
+func (req *MCRequest) StreamId() (uint16, bool) {
+	frames := req.FramingExtras
+	for len(frames) > 0 {
+		id, length := frames[0]>>4, int(frames[0]&0x0F)
+		frames = frames[1:]
+		if len(frames) < length {
+			return 0, false
+		}
+		if id == FRAME_DCP_STREAM_ID && length == 2 {
+			return binary.BigEndian.Uint16(frames), true
+		}
+		frames = frames[length:]
+	}
+	return 0, false
 }
 
 // A debugging string representation of this request
@@ -38,13 +67,24 @@ func (req MCRequest) String() string {
 func (req *MCRequest) fillHeaderBytes(data []byte) int {
 
 	pos := 0
-	data[pos] = REQ_MAGIC
-	pos++
-	data[pos] = byte(req.Opcode)
-	pos++
-	binary.BigEndian.PutUint16(data[pos:pos+2],
-		uint16(len(req.Key)))
-	pos += 2
+	if len(req.FramingExtras) > 0 {
+		data[pos] = ALT_REQ_MAGIC
+		pos++
+		data[pos] = byte(req.Opcode)
+		pos++
+		data[pos] = byte(len(req.FramingExtras))
+		pos++
+		data[pos] = byte(len(req.Key))
+		pos++
+	} else {
+		data[pos] = REQ_MAGIC
+		pos++
+		data[pos] = byte(req.Opcode)
+		pos++
+		binary.BigEndian.PutUint16(data[pos:pos+2],
+			uint16(len(req.Key)))
+		pos += 2
+	}
 
 	// 4
 	data[pos] = byte(len(req.Extras))
@@ -56,7 +96,7 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 
 	// 8
 	binary.BigEndian.PutUint32(data[pos:pos+4],
-		uint32(len(req.Body)+len(req.Key)+len(req.Extras)))
+		uint32(len(req.Body)+len(req.Key)+len(req.Extras)+len(req.FramingExtras)))
 	pos += 4
 
 	// 12
@@ -69,6 +109,11 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 	}
 	pos += 8
 
+	if len(req.FramingExtras) > 0 {
+		copy(data[pos:pos+len(req.FramingExtras)], req.FramingExtras)
+		pos += len(req.FramingExtras)
+	}
+
 	if len(req.Extras) > 0 {
 		copy(data[pos:pos+len(req.Extras)], req.Extras)
 		pos += len(req.Extras)
@@ -84,7 +129,7 @@ func (req *MCRequest) fillHeaderBytes(data []byte) int {
 // HeaderBytes will return the wire representation of the request header
 // (with the extras and key).
 func (req *MCRequest) HeaderBytes() []byte {
-	data := make([]byte, HDR_LEN+len(req.Extras)+len(req.Key))
+	data := make([]byte, HDR_LEN+len(req.FramingExtras)+len(req.Extras)+len(req.Key))
 
 	req.fillHeaderBytes(data)
 
@@ -132,11 +177,15 @@ func (req *MCRequest) Receive(r io.Reader, hdrBytes []byte) (int, error) {
 		return n, err
 	}
 
-	if hdrBytes[0] != RES_MAGIC && hdrBytes[0] != REQ_MAGIC {
+	var flen, klen int
+	switch hdrBytes[0] {
+	case RES_MAGIC, REQ_MAGIC:
+		klen = int(binary.BigEndian.Uint16(hdrBytes[2:]))
+	case ALT_REQ_MAGIC:
+		flen, klen = int(hdrBytes[2]), int(hdrBytes[3])
+	default:
 		return n, fmt.Errorf("bad magic: 0x%02x", hdrBytes[0])
 	}
-
-	klen := int(binary.BigEndian.Uint16(hdrBytes[2:]))
 	elen := int(hdrBytes[4])
 
 	req.Datatype = uint8(hdrBytes[5])
@@ -144,14 +193,19 @@ func (req *MCRequest) Receive(r io.Reader, hdrBytes []byte) (int, error) {
 	// Vbucket at 6:7
 	req.VBucket = binary.BigEndian.Uint16(hdrBytes[6:])
 	bodyLen := int(binary.BigEndian.Uint32(hdrBytes[8:]) -
-		uint32(klen) - uint32(elen))
+		uint32(flen) - uint32(klen) - uint32(elen))
 	req.Opaque = binary.BigEndian.Uint32(hdrBytes[12:])
 	req.Cas = binary.BigEndian.Uint64(hdrBytes[16:])
 
-	buf := make([]byte, klen+elen+bodyLen)
+	buf := make([]byte, flen+klen+elen+bodyLen)
 	m, err := io.ReadFull(r, buf)
 	n += m
 	if err == nil {
+		req.FramingExtras = nil
+		if flen > 0 {
+			req.FramingExtras = buf[:flen]
+			buf = buf[flen:]
+		}
 		if req.Opcode >= TAP_MUTATION &&
 			req.Opcode <= TAP_CHECKPOINT_END &&
 			len(buf) > 1 {
@@ -247,6 +247,46 @@ func TestReceiveRequestNoContent(t *testing.T) {
 	}
 }
 
+func TestReceiveRequestWithStreamId(t *testing.T) {
+	req := MCRequest{
+		Opcode:        DCP_MUTATION,
+		Cas:           0,
+		Opaque:        7242,
+		VBucket:       824,
+		FramingExtras: StreamIdFrame(0x1234),
+		Extras:        []byte{1},
+		Key:           []byte("somekey"),
+		Body:          []byte("somevalue"),
+	}
+
+	data := req.Bytes()
+	if data[0] != ALT_REQ_MAGIC {
+		t.Fatalf("Expected magic 0x%02x, got 0x%02x", ALT_REQ_MAGIC, data[0])
+	}
+	if len(data) != req.Size() {
+		t.Fatalf("Expected %v bytes, got %v", req.Size(), len(data))
+	}
+
+	req2 := MCRequest{}
+	n, err := req2.Receive(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("Error receiving: %v", err)
+	}
+	if len(data) != n {
+		t.Errorf("Expected to read %v bytes, read %v", len(data), n)
+	}
+	if !reflect.DeepEqual(req, req2) {
+		t.Fatalf("Expected %#v == %#v", req, req2)
+	}
+
+	if sid, ok := req2.StreamId(); !ok || sid != 0x1234 {
+		t.Errorf("Expected stream-id 0x1234, got %v %v", sid, ok)
+	}
+	if _, ok := (&MCRequest{}).StreamId(); ok {
+		t.Errorf("Expected no stream-id")
+	}
+}
+
 func TestReceiveRequestShortHdr(t *testing.T) {
 	req := MCRequest{}
 	n, err := req.Receive(bytes.NewReader([]byte{1, 2, 3}), nil)
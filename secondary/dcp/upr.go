@@ -13,6 +13,8 @@ import (
 	"github.com/couchbase/indexing/secondary/security"
 )
 
+var dcpLog = logging.GetComponentLogger(logging.DcpComponent)
+
 // ErrorInvalidVbucket
 var ErrorInvalidVbucket = errors.New("dcp.invalidVbucket")
 
@@ -54,7 +56,7 @@ func (b *Bucket) GetFailoverLogs(
 	for _, vb := range vBuckets {
 		if l := len(vbm.VBucketMap); int(vb) >= l {
 			fmsg := "DCPF[] ##%x invalid vbucket id %d >= %d"
-			logging.Errorf(fmsg, opaque, vb, l)
+			dcpLog.Errorf(fmsg, opaque, vb, l)
 			return nil, ErrorInvalidVbucket
 		}
 
@@ -62,7 +64,7 @@ func (b *Bucket) GetFailoverLogs(
 		master := b.getMasterNode(masterID)
 		if master == "" {
 			fmsg := "DCP[] ##%x master node not found for vbucket %d"
-			logging.Errorf(fmsg, opaque, vb)
+			dcpLog.Errorf(fmsg, opaque, vb)
 			return nil, ErrorInvalidVbucket
 		}
 
@@ -149,6 +151,7 @@ func (b *Bucket) StartDcpFeed(
 //      "genChanSize", buffer channel size for control path.
 //      "dataChanSize", buffer channel size for data path.
 //      "numConnections", number of connections with DCP for local vbuckets.
+//      "streamIds", multiplex streams for the same vbucket by stream-id.
 func (b *Bucket) StartDcpFeedOver(
 	name DcpFeedName,
 	sequence, flags uint32,
@@ -177,7 +180,7 @@ func (b *Bucket) StartDcpFeedOver(
 
 	feed.C = feed.output
 	if err := feed.connectToNodes(kvaddrs, opaque, flags, config); err != nil {
-		logging.Errorf("%v ##%x Bucket::StartDcpFeedOver : error %v in connectToNodes",
+		dcpLog.Errorf("%v ##%x Bucket::StartDcpFeedOver : error %v in connectToNodes",
 			feed.logPrefix, opaque, err)
 		return nil, ErrorInvalidBucket
 	}
@@ -202,6 +205,20 @@ func (feed *DcpFeed) DcpRequestStream(
 	vbuuid, startSequence, endSequence, snapStart, snapEnd uint64,
 	manifestUID, scopeId string, collectionIds []string) error {
 
+	return feed.DcpRequestStreamWithId(
+		0, vb, opaque, flags, vbuuid, startSequence, endSequence,
+		snapStart, snapEnd, manifestUID, scopeId, collectionIds)
+}
+
+// DcpRequestStreamWithId starts a stream for a vb on a feed, identified
+// by streamId, so that more than one stream can be open for the same vb.
+// The feed must be started with "streamIds" config enabled.
+// Synchronous call.
+func (feed *DcpFeed) DcpRequestStreamWithId(
+	streamId, vb uint16, opaque uint16, flags uint32,
+	vbuuid, startSequence, endSequence, snapStart, snapEnd uint64,
+	manifestUID, scopeId string, collectionIds []string) error {
+
 	// only request active vbucket
 	if feed.activeVbOnly {
 		flags = flags | DCP_ADD_STREAM_ACTIVE_VB_ONLY
@@ -211,7 +228,7 @@ func (feed *DcpFeed) DcpRequestStream(
 	cmd := []interface{}{
 		ufCmdRequestStream, vb, opaque, flags, vbuuid, startSequence,
 		endSequence, snapStart, snapEnd,
-		manifestUID, scopeId, collectionIds,
+		manifestUID, scopeId, collectionIds, streamId,
 		respch}
 	resp, err := failsafeOp(feed.reqch, respch, cmd, feed.finch)
 	return opError(err, resp, 0)
@@ -221,8 +238,13 @@ func (feed *DcpFeed) DcpRequestStream(
 // and immediately returns, it is upto the channel listener
 // to detect StreamEnd.
 func (feed *DcpFeed) DcpCloseStream(vb, opaqueMSB uint16) error {
+	return feed.DcpCloseStreamWithId(0, vb, opaqueMSB)
+}
+
+// DcpCloseStreamWithId closes the stream identified by streamId for a vb.
+func (feed *DcpFeed) DcpCloseStreamWithId(streamId, vb, opaqueMSB uint16) error {
 	respch := make(chan []interface{}, 1)
-	cmd := []interface{}{ufCmdCloseStream, vb, opaqueMSB, respch}
+	cmd := []interface{}{ufCmdCloseStream, vb, opaqueMSB, streamId, respch}
 	resp, err := failsafeOp(feed.reqch, respch, cmd, feed.finch)
 	return opError(err, resp, 0)
 }
@@ -277,8 +299,8 @@ func (feed *DcpFeed) genServer(reqch chan []interface{}, opaque uint16) {
 	defer func() { // panic safe
 		close(feed.finch)
 		if r := recover(); r != nil {
-			logging.Errorf("%v ##%x crashed: %v\n", feed.logPrefix, opaque, r)
-			logging.Errorf("%s", logging.StackTrace())
+			dcpLog.Errorf("%v ##%x crashed: %v\n", feed.logPrefix, opaque, r)
+			dcpLog.Errorf("%s", logging.StackTrace())
 		}
 		closeNodeFeeds()
 		close(feed.output)
@@ -301,18 +323,20 @@ loop:
 					manifestUID := msg[9].(string)
 					scopeId := msg[10].(string)
 					collectionIds := msg[11].([]string)
+					streamId := msg[12].(uint16)
 
 					err := feed.dcpRequestStream(
-						vb, opaque, flags, vbuuid, startSeq, endSeq,
+						streamId, vb, opaque, flags, vbuuid, startSeq, endSeq,
 						snapStart, snapEnd,
 						manifestUID, scopeId, collectionIds)
-					respch := msg[12].(chan []interface{})
+					respch := msg[13].(chan []interface{})
 					respch <- []interface{}{err}
 
 				case ufCmdCloseStream:
 					vb, opaqueMSB := msg[1].(uint16), msg[2].(uint16)
-					err := feed.dcpCloseStream(vb, opaqueMSB)
-					respch := msg[3].(chan []interface{})
+					streamId := msg[3].(uint16)
+					err := feed.dcpCloseStream(streamId, vb, opaqueMSB)
+					respch := msg[4].(chan []interface{})
 					respch <- []interface{}{err}
 
 				case ufCmdGetSeqnos:
@@ -337,7 +361,7 @@ loop:
 				case transport.DCP_STREAMEND:
 					feed.cleanupVb(msg)
 				default:
-					logging.Fatalf("%v DcpFeed::genServer Should not receive a message other than DCP_STREAMEND, msg: %v", feed.logPrefix, msg)
+					dcpLog.Fatalf("%v DcpFeed::genServer Should not receive a message other than DCP_STREAMEND, msg: %v", feed.logPrefix, msg)
 					break loop
 				}
 			}
@@ -353,7 +377,7 @@ func (feed *DcpFeed) connectToNodes(
 	m, err := feed.bucket.GetVBmap(kvaddrs)
 	if err != nil {
 		fmsg := "%v ##%x GetVBmap(%v) failed: %v\n"
-		logging.Errorf(fmsg, prefix, opaque, kvaddrs, err)
+		dcpLog.Errorf(fmsg, prefix, opaque, kvaddrs, err)
 		return memcached.ErrorInvalidFeed
 	}
 	for kvaddr := range m {
@@ -387,12 +411,12 @@ func (feed *DcpFeed) connectToNodes(
 					singleFeed.dcpFeed.Close()
 				}
 				fmsg := "%v ##%x DcpFeed::connectToNodes StartDcpFeed failed for %v with err %v\n"
-				logging.Errorf(fmsg, prefix, opaque, feedname, err)
+				dcpLog.Errorf(fmsg, prefix, opaque, feedname, err)
 				return memcached.ErrorInvalidFeed
 			}
 			// add the node to the connection map
 			feedInfo := &FeedInfo{
-				vbnos:   make([]uint16, 0),
+				vbnos:   make([]uint32, 0),
 				dcpFeed: singleFeed,
 				host:    serverConn.host,
 			}
@@ -428,12 +452,12 @@ func (feed *DcpFeed) reConnectToNodes(
 				feedname, feed.sequence, flags, feed.output, opaque, feed.reqch, config)
 			if err != nil {
 				fmsg := "%v ##%x DcpFeed::reConnectToNodes StartDcpFeed failed for %v with err %v\n"
-				logging.Errorf(fmsg, feed.logPrefix, opaque, feedname, err)
+				dcpLog.Errorf(fmsg, feed.logPrefix, opaque, feedname, err)
 				continue
 			}
 			// add the node to the connection map
 			feedInfo := &FeedInfo{
-				vbnos:   make([]uint16, 0),
+				vbnos:   make([]uint32, 0),
 				dcpFeed: singleFeed,
 				host:    serverConn.host,
 			}
@@ -443,7 +467,7 @@ func (feed *DcpFeed) reConnectToNodes(
 }
 
 func (feed *DcpFeed) dcpRequestStream(
-	vb uint16, opaque uint16, flags uint32,
+	streamId, vb uint16, opaque uint16, flags uint32,
 	vbuuid, startSequence, endSequence, snapStart, snapEnd uint64,
 	manifestUID, scopeId string, collectionIds []string) error {
 
@@ -451,7 +475,7 @@ func (feed *DcpFeed) dcpRequestStream(
 	vbm := feed.bucket.VBServerMap()
 	if l := len(vbm.VBucketMap); int(vb) >= l {
 		fmsg := "%v ##%x invalid vbucket id %d >= %d\n"
-		logging.Errorf(fmsg, prefix, opaque, vb, l)
+		dcpLog.Errorf(fmsg, prefix, opaque, vb, l)
 		return ErrorInvalidVbucket
 	}
 
@@ -459,12 +483,12 @@ func (feed *DcpFeed) dcpRequestStream(
 	master := feed.bucket.getMasterNode(masterID)
 	if master == "" {
 		fmsg := "%v ##%x notFound master node for vbucket %d\n"
-		logging.Errorf(fmsg, prefix, opaque, vb)
+		dcpLog.Errorf(fmsg, prefix, opaque, vb)
 		return ErrorInvalidVbucket
 	} else if len(feed.nodeFeeds[master]) == 0 {
 		fmsg := "%v ##%x len(feed.nodeFeeds[master]) is \"0\"." +
 			" Master node for vb:%d is %v\n"
-		logging.Errorf(fmsg, prefix, opaque, vb, master)
+		dcpLog.Errorf(fmsg, prefix, opaque, vb, master)
 		return ErrorInvalidVbucket
 	}
 
@@ -476,31 +500,31 @@ func (feed *DcpFeed) dcpRequestStream(
 		singleFeed, ok := addtofeed(feed.nodeFeeds[master])
 		if !ok {
 			fmsg := "%v ##%x notFound DcpFeed host: %q vb:%d\n"
-			logging.Errorf(fmsg, prefix, opaque, master, vb)
+			dcpLog.Errorf(fmsg, prefix, opaque, master, vb)
 			return memcached.ErrorInvalidFeed
 		}
-		err = singleFeed.dcpFeed.DcpRequestStream(
-			vb, opaque, flags, vbuuid, startSequence, endSequence,
+		err = singleFeed.dcpFeed.DcpRequestStreamWithId(
+			streamId, vb, opaque, flags, vbuuid, startSequence, endSequence,
 			snapStart, snapEnd,
 			manifestUID, scopeId, collectionIds)
 		if err != nil {
 			fmsg := "%v ##%x DcpFeed %v failed, trying next, err: %v"
-			logging.Errorf(fmsg, prefix, opaque, singleFeed.dcpFeed.Name(), err)
+			dcpLog.Errorf(fmsg, prefix, opaque, singleFeed.dcpFeed.Name(), err)
 			feed.nodeFeeds[master] = purgeFeed(feed.nodeFeeds[master], singleFeed)
 			continue
 		}
-		singleFeed.vbnos = append(singleFeed.vbnos, vb)
+		singleFeed.vbnos = append(singleFeed.vbnos, vbStreamKey(vb, streamId))
 		break
 	}
 	return err
 }
 
-func (feed *DcpFeed) dcpCloseStream(vb, opaqueMSB uint16) error {
+func (feed *DcpFeed) dcpCloseStream(streamId, vb, opaqueMSB uint16) error {
 	prefix := feed.logPrefix
 	vbm := feed.bucket.VBServerMap()
 	if l := len(vbm.VBucketMap); int(vb) >= l {
 		fmsg := "%v ##%x invalid vbucket id %d >= %d\n"
-		logging.Errorf(fmsg, prefix, opaqueMSB, vb, l)
+		dcpLog.Errorf(fmsg, prefix, opaqueMSB, vb, l)
 		return ErrorInvalidVbucket
 	}
 
@@ -508,21 +532,21 @@ func (feed *DcpFeed) dcpCloseStream(vb, opaqueMSB uint16) error {
 	master := feed.bucket.getMasterNode(masterID)
 	if master == "" {
 		fmsg := "%v ##%x notFound master node for vbucket %d\n"
-		logging.Errorf(fmsg, prefix, opaqueMSB, vb)
+		dcpLog.Errorf(fmsg, prefix, opaqueMSB, vb)
 		return ErrorInvalidVbucket
 	}
-	singleFeed, err := feed.getSingleFeed(master, vb, prefix, opaqueMSB)
+	singleFeed, err := feed.getSingleFeed(master, vb, streamId, prefix, opaqueMSB)
 	if err != nil {
 		return err
 	}
-	if err := singleFeed.dcpFeed.CloseStream(vb, opaqueMSB); err != nil {
+	if err := singleFeed.dcpFeed.CloseStreamWithId(streamId, vb, opaqueMSB); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (feed *DcpFeed) getSingleFeed(master string, vb uint16, prefix string, opaqueMSB uint16) (*FeedInfo, error) {
-	singleFeed, ok := removefromfeed(feed.nodeFeeds[master], vb)
+func (feed *DcpFeed) getSingleFeed(master string, vb, streamId uint16, prefix string, opaqueMSB uint16) (*FeedInfo, error) {
+	singleFeed, ok := removefromfeed(feed.nodeFeeds[master], vb, streamId)
 
 	if !ok {
 		// In case where a node gets added with localhost address first
@@ -542,22 +566,22 @@ func (feed *DcpFeed) getSingleFeed(master string, vb uint16, prefix string, opaq
 		// is same as the local IP address. If yes, we go ahead and shutdown the
 		// stream by using the local kvaddress
 		if islocalIP, err := isLocalIP(master); err != nil {
-			logging.Errorf("%v ##%x err: %v observed while retrieving local IP "+
+			dcpLog.Errorf("%v ##%x err: %v observed while retrieving local IP "+
 				"for master: %v", prefix, opaqueMSB, master)
 			return nil, memcached.ErrorInvalidFeed
 		} else if islocalIP && len(feed.kvaddrs) == 1 {
 			fmsg := "%v ##%x notFound DcpFeed host: %q vb:%d, trying with kvaddrs: %v"
-			logging.Warnf(fmsg, prefix, opaqueMSB, master, vb, feed.kvaddrs[0])
+			dcpLog.Warnf(fmsg, prefix, opaqueMSB, master, vb, feed.kvaddrs[0])
 			// Trying with local address. kvaddrs[0] is the local kv address
-			singleFeed, ok = removefromfeed(feed.nodeFeeds[feed.kvaddrs[0]], vb)
+			singleFeed, ok = removefromfeed(feed.nodeFeeds[feed.kvaddrs[0]], vb, streamId)
 			if !ok {
 				fmsg := "%v ##%x notFound DcpFeed host: %q vb:%d with kvaddrs: %v"
-				logging.Errorf(fmsg, prefix, opaqueMSB, master, vb, feed.kvaddrs[0])
+				dcpLog.Errorf(fmsg, prefix, opaqueMSB, master, vb, feed.kvaddrs[0])
 				return nil, memcached.ErrorInvalidFeed
 			}
 		} else {
 			fmsg := "%v ##%x notFound DcpFeed host: %q vb:%d, kvaddrs: %v"
-			logging.Errorf(fmsg, prefix, opaqueMSB, master, vb, feed.kvaddrs)
+			dcpLog.Errorf(fmsg, prefix, opaqueMSB, master, vb, feed.kvaddrs)
 			return nil, memcached.ErrorInvalidFeed
 		}
 	}
@@ -651,7 +675,7 @@ func (feed *DcpFeed) dcpGetSeqnos() (map[uint16]uint64, error) {
 		select {
 		case <-timeout:
 			fmsg := "%v stats-seqno timed-out %s waiting for stats"
-			logging.Errorf(fmsg, prefix, timeout)
+			dcpLog.Errorf(fmsg, prefix, timeout)
 			return nil, ErrorTimeoutDcpStats
 		case result := <-ch:
 			nodeTs := result[0].(map[uint16]uint64)
@@ -704,16 +728,17 @@ func addtofeed(nodeFeeds []*FeedInfo) (*FeedInfo, bool) {
 	return feedinfo, (feedinfo != nil)
 }
 
-func removefromfeed(nodeFeeds []*FeedInfo, forvb uint16) (*FeedInfo, bool) {
+func removefromfeed(nodeFeeds []*FeedInfo, forvb, streamId uint16) (*FeedInfo, bool) {
 	if len(nodeFeeds) == 0 {
 		return nil, false
 	}
+	key := vbStreamKey(forvb, streamId)
 	for _, singleFeed := range nodeFeeds {
 		if singleFeed == nil {
 			continue
 		}
 		for i, vbno := range singleFeed.vbnos {
-			if vbno == forvb {
+			if vbno == key {
 				copy(singleFeed.vbnos[i:], singleFeed.vbnos[i+1:])
 				n := len(singleFeed.vbnos) - 1
 				singleFeed.vbnos = singleFeed.vbnos[:n]
@@ -738,20 +763,20 @@ func purgeFeed(nodeFeeds []*FeedInfo, singleFeed *FeedInfo) []*FeedInfo {
 
 func (feed *DcpFeed) cleanupVb(msg []interface{}) {
 	dcpFeed := msg[1].(*memcached.DcpFeed)
-	forvb := msg[2].(uint16)
+	forvb, streamId := msg[2].(uint16), msg[3].(uint16)
 	// Delete the vb corresponding to the node feed
 	found := false
 outerloop:
 	for _, nodeFeeds := range feed.nodeFeeds {
 		for _, singleFeed := range nodeFeeds {
 			if singleFeed != nil && singleFeed.dcpFeed.Name() == dcpFeed.Name() {
-				_, found = removefromfeed(nodeFeeds, forvb)
+				_, found = removefromfeed(nodeFeeds, forvb, streamId)
 				break outerloop
 			}
 		}
 	}
 	if !found {
-		logging.Warnf("%v DcpFeed::genServer Could not find vb:%v for feed:%v in book-keeping", feed.logPrefix, forvb, dcpFeed.Name())
+		dcpLog.Warnf("%v DcpFeed::genServer Could not find vb:%v for feed:%v in book-keeping", feed.logPrefix, forvb, dcpFeed.Name())
 	}
 }
 
@@ -790,12 +815,18 @@ func opError(err error, vals []interface{}, idx int) error {
 
 // FeedInfo is dcp-feed from a single connection.
 type FeedInfo struct {
-	vbnos   []uint16
+	vbnos   []uint32           // vbStreamKey of streams on this connection
 	dcpFeed *memcached.DcpFeed // DCP feed handle
 	host    string             // hostname
 	mu      sync.Mutex         // protects the following field.
 }
 
+// vbStreamKey identifies a stream for vb on a connection. Same as vb when
+// streams are not multiplexed by stream-id.
+func vbStreamKey(vb, streamId uint16) uint32 {
+	return (uint32(streamId) << 16) | uint32(vb)
+}
+
 func copyconfig(config map[string]interface{}) map[string]interface{} {
 	nconfig := make(map[string]interface{})
 	for k, v := range config {
@@ -52,6 +52,7 @@ func initHandlers(api *restServer) {
 	staticRoutes = make(map[string]reqHandler)
 	staticRoutes["stats"] = api.statsHandler
 	staticRoutes["bucket"] = bucketHandler
+	staticRoutes["ddl"] = ddlHandler
 }
 
 func NewRestServer(cluster string, stMgr *statsManager) (*restServer, Message) {
@@ -304,3 +305,17 @@ func genErrStr(keyspaceName string) string {
 func bucketHandler(req request) {
 	manager.BucketRequestHandler(req.w, req.r, req.creds)
 }
+
+//
+// DDL Handler. The DDL operations pending in the cluster are listed, queued
+// builds cancelled, and the indexes of a scope or collection dropped, using
+// /api/v1/ddl
+//
+// /api/v1/ddl
+// /api/v1/ddl/cancelBuild?defnId=<defnId>
+// /api/v1/ddl/dropIndexes?bucket=<bucket>&scope=<scope>[&collection=<collection>]
+// /api/v1/ddl/dropIndexes[/<id>]
+//
+func ddlHandler(req request) {
+	manager.DDLRequestHandler(req.w, req.r, req.creds)
+}
@@ -205,6 +205,18 @@ func CompareArrayEntriesWithCount(newKey, oldKey [][]byte, newKeyCount, oldKeyCo
 	return newKey, oldKey
 }
 
+// Number of old array entries left in place by CompareArrayEntriesWithCount,
+// i.e. the writes saved by applying only the delta of a mutation.
+func keptArrayEntries(oldKey [][]byte) int {
+	kept := 0
+	for _, item := range oldKey {
+		if item == nil {
+			kept++
+		}
+	}
+	return kept
+}
+
 func FlattenArray(arrayItem [][]byte, tmpBuf []byte, codec *collatejson.Codec) ([][]byte, error) {
 
 	for itemIndex, arrItem := range arrayItem {
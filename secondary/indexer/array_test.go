@@ -0,0 +1,32 @@
+package indexer
+
+import (
+	"testing"
+)
+
+func TestCompareArrayEntriesWithCount(t *testing.T) {
+	bs := func(items ...string) [][]byte {
+		r := make([][]byte, len(items))
+		for i, item := range items {
+			r[i] = []byte(item)
+		}
+		return r
+	}
+
+	// Only the delta of a small edit is applied, an entry whose count of
+	// duplicates changed is replaced
+	newKey, oldKey := bs("a", "b", "c", "e"), bs("a", "b", "c", "d")
+	added, deleted := CompareArrayEntriesWithCount(newKey, oldKey,
+		[]int{1, 2, 1, 1}, []int{1, 1, 1, 1})
+
+	expAdded := []string{"", "b", "", "e"}
+	expDeleted := []string{"", "b", "", "d"}
+	for i := range added {
+		if string(added[i]) != expAdded[i] || string(deleted[i]) != expDeleted[i] {
+			t.Fatalf("Unexpected delta %q %q", added, deleted)
+		}
+	}
+	if kept := keptArrayEntries(deleted); kept != 2 {
+		t.Errorf("Expected 2 entries kept, found %v", kept)
+	}
+}
@@ -59,6 +59,10 @@ func getKeySizeConfig(cfg common.Config) keySizeConfig {
 	keyCfg.maxSecKeyBufferLen = keyCfg.maxSecKeyLen * 3
 	keyCfg.maxIndexEntrySize = keyCfg.maxSecKeyBufferLen + MAX_DOCID_LEN + 2
 
+	// Only supported by MOI
+	keyCfg.overflowLargeKeys = cfg["settings.overflow_large_keys"].Bool() &&
+		common.GetStorageMode() == common.MOI
+
 	return keyCfg
 }
 
@@ -80,5 +84,10 @@ func keySizeConfigUpdated(cfg, oldCfg common.Config) bool {
 		return true
 	}
 
+	if cfg["settings.overflow_large_keys"].Bool() !=
+		oldCfg["settings.overflow_large_keys"].Bool() {
+		return true
+	}
+
 	return false
 }
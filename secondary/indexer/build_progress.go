@@ -0,0 +1,220 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/audit"
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Build progress of a stream/keyspace is estimated per vbucket, as the
+// mutations flushed since the stream was opened against those that KV has
+// beyond the open timestamp:
+//
+//	done  = sum(min(flushed[vb], kv[vb]) - open[vb])
+//	total = sum(kv[vb] - open[vb])
+//
+// The rate at which done grows between stats refreshes gives the estimated
+// time for the remaining mutations.
+
+// weight of the latest sample in the moving average of the build rate
+const buildRateWeight = 0.5
+
+type buildProgressSample struct {
+	time int64   // UnixNano
+	done uint64  // mutations processed
+	rate float64 // mutations per second
+}
+
+// buildProgressTracker keeps the samples needed to compute the build rate
+// of each stream/keyspace. It is not safe for concurrent use.
+type buildProgressTracker struct {
+	samples map[common.StreamId]map[string]*buildProgressSample
+}
+
+func newBuildProgressTracker() *buildProgressTracker {
+	return &buildProgressTracker{
+		samples: make(map[common.StreamId]map[string]*buildProgressSample),
+	}
+}
+
+// estimateBuildProgress returns the mutations processed since openTs, and
+// the total to be processed to catch up with kvTs. For vbuckets receiving
+// an OSO snapshot, the count of flushed mutations stands for the seqno.
+func estimateBuildProgress(openTs, flushedTs *common.TsVbuuid,
+	kvTs Timestamp) (done, total uint64) {
+
+	for vb, kvSeqno := range kvTs {
+		var openSeqno, flushedSeqno uint64
+		if openTs != nil && vb < len(openTs.Seqnos) {
+			openSeqno = openTs.Seqnos[vb]
+		}
+		if flushedTs != nil && vb < len(flushedTs.Seqnos) {
+			flushedSeqno = flushedTs.Seqnos[vb]
+			if flushedTs.OSOCount != nil && flushedTs.OSOCount[vb] != 0 {
+				flushedSeqno = openSeqno + flushedTs.OSOCount[vb]
+			}
+		}
+
+		if kvSeqno <= openSeqno {
+			continue
+		}
+		total += kvSeqno - openSeqno
+
+		if flushedSeqno > kvSeqno {
+			flushedSeqno = kvSeqno
+		}
+		if flushedSeqno > openSeqno {
+			done += flushedSeqno - openSeqno
+		}
+	}
+	return
+}
+
+// update records done for streamId/keyspaceId and returns the percent
+// complete and the estimated time remaining in seconds. The estimate is -1
+// until the build rate is known.
+func (t *buildProgressTracker) update(streamId common.StreamId, keyspaceId string,
+	done, total uint64, now int64) (percent float64, eta int64) {
+
+	if total == 0 || done >= total {
+		t.forget(streamId, keyspaceId)
+		return 100.00, 0
+	}
+	percent = float64(done) * 100.00 / float64(total)
+
+	if _, ok := t.samples[streamId]; !ok {
+		t.samples[streamId] = make(map[string]*buildProgressSample)
+	}
+
+	last := t.samples[streamId][keyspaceId]
+	if last == nil || done < last.done {
+		// first sample, or stream was restarted
+		t.samples[streamId][keyspaceId] = &buildProgressSample{time: now, done: done}
+		return percent, -1
+	}
+
+	if now > last.time {
+		rate := float64(done-last.done) / (float64(now-last.time) / float64(time.Second))
+		if last.rate == 0 {
+			last.rate = rate
+		} else {
+			last.rate = buildRateWeight*rate + (1-buildRateWeight)*last.rate
+		}
+		last.time = now
+		last.done = done
+	}
+
+	if last.rate <= 0 {
+		return percent, -1
+	}
+	return percent, int64(float64(total-done) / last.rate)
+}
+
+func (t *buildProgressTracker) forget(streamId common.StreamId, keyspaceId string) {
+	delete(t.samples[streamId], keyspaceId)
+}
+
+// retain drops the samples of stream/keyspaces other than those in keep.
+func (t *buildProgressTracker) retain(keep map[common.StreamId]map[string]Timestamp) {
+	for streamId, keyspaceIds := range t.samples {
+		for keyspaceId := range keyspaceIds {
+			if _, ok := keep[streamId][keyspaceId]; !ok {
+				delete(keyspaceIds, keyspaceId)
+			}
+		}
+	}
+}
+
+// BuildProgress is the estimated progress of an index instance being built.
+type BuildProgress struct {
+	InstId          common.IndexInstId `json:"instId"`
+	Name            string             `json:"name"`
+	Bucket          string             `json:"bucket"`
+	Scope           string             `json:"scope"`
+	Collection      string             `json:"collection"`
+	State           string             `json:"state"`
+	PercentComplete float64            `json:"percentComplete"`
+	EtaSeconds      int64              `json:"etaSeconds"` // -1 if not known
+	NumDocsPending  int64              `json:"numDocsPending"`
+	NumDocsQueued   int64              `json:"numDocsQueued"`
+	UpdateTime      json.Number        `json:"updateTime"`
+}
+
+// handleBuildProgressReq returns the progress of the index instances in
+// INITIAL or CATCHUP state. With ?async=false, progress is refreshed
+// before it is returned.
+func (s *statsManager) handleBuildProgressReq(w http.ResponseWriter, r *http.Request) {
+	_, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		audit.Audit(common.AUDIT_UNAUTHORIZED, r, "StatsManager::handleBuildProgressReq", "")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "POST" && r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	stats := s.stats.Get()
+	if common.IndexerState(stats.indexerState.Value()) != common.INDEXER_BOOTSTRAP &&
+		r.URL.Query().Get("async") == "false" {
+		s.tryUpdateStats(true)
+		stats = s.stats.Get()
+	}
+
+	progress := make([]*BuildProgress, 0)
+	for instId, ss := range stats.indexes {
+		state := common.IndexState(ss.indexState.Value())
+		if state != common.INDEX_STATE_INITIAL && state != common.INDEX_STATE_CATCHUP {
+			continue
+		}
+
+		progress = append(progress, &BuildProgress{
+			InstId:          instId,
+			Name:            ss.dispName,
+			Bucket:          ss.bucket,
+			Scope:           ss.scope,
+			Collection:      ss.collection,
+			State:           state.String(),
+			PercentComplete: math.Float64frombits(uint64(ss.completionProgress.Value())),
+			EtaSeconds:      ss.buildEta.Value(),
+			NumDocsPending:  ss.numDocsPending.Value(),
+			NumDocsQueued:   ss.numDocsQueued.Value(),
+			UpdateTime:      json.Number(ss.progressStatTime.Value()),
+		})
+	}
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].InstId < progress[j].InstId
+	})
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,78 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestEstimateBuildProgress(t *testing.T) {
+	openTs := &common.TsVbuuid{Seqnos: []uint64{10, 0, 5, 0}}
+	flushedTs := &common.TsVbuuid{Seqnos: []uint64{60, 20, 5, 0}}
+	kvTs := Timestamp{110, 20, 5, 50}
+
+	done, total := estimateBuildProgress(openTs, flushedTs, kvTs)
+	if done != 70 || total != 170 {
+		t.Fatalf("expected 70/170, got %v/%v", done, total)
+	}
+
+	// flushed past the kv seqnos fetched earlier
+	flushedTs = &common.TsVbuuid{Seqnos: []uint64{200, 20, 5, 50}}
+	done, total = estimateBuildProgress(openTs, flushedTs, kvTs)
+	if done != total {
+		t.Fatalf("expected done, got %v/%v", done, total)
+	}
+
+	// OSO snapshot, count of mutations in place of seqno
+	flushedTs = &common.TsVbuuid{
+		Seqnos:   []uint64{0, 0, 0, 0},
+		OSOCount: []uint64{30, 0, 0, 10},
+	}
+	done, total = estimateBuildProgress(nil, flushedTs, kvTs)
+	if done != 40 || total != 185 {
+		t.Fatalf("expected 40/185, got %v/%v", done, total)
+	}
+}
+
+func TestBuildProgressTracker(t *testing.T) {
+	tracker := newBuildProgressTracker()
+	stream, keyspace := common.INIT_STREAM, "default"
+	now := time.Now().UnixNano()
+
+	percent, eta := tracker.update(stream, keyspace, 0, 1000, now)
+	if percent != 0 || eta != -1 {
+		t.Fatalf("unexpected first sample %v %v", percent, eta)
+	}
+
+	now += int64(time.Second)
+	percent, eta = tracker.update(stream, keyspace, 100, 1000, now)
+	if percent != 10 || eta != 9 {
+		t.Fatalf("unexpected progress %v %v", percent, eta)
+	}
+
+	// stream restart resets the rate
+	now += int64(time.Second)
+	if _, eta = tracker.update(stream, keyspace, 50, 1000, now); eta != -1 {
+		t.Fatalf("expected unknown eta after restart, got %v", eta)
+	}
+
+	percent, eta = tracker.update(stream, keyspace, 1000, 1000, now)
+	if percent != 100 || eta != 0 {
+		t.Fatalf("expected done, got %v %v", percent, eta)
+	}
+
+	tracker.update(stream, keyspace, 50, 1000, now)
+	tracker.retain(nil)
+	if len(tracker.samples[stream]) != 0 {
+		t.Fatalf("expected samples to be dropped")
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+)
+
+// For very large collections, streaming the whole DCP history to build an
+// index can be the bottleneck. With build.ingest.path set, the initial build
+// of the indexes of a collection first ingests a local KV export of the
+// collection found in <path>/<bucket>/<scope>/<collection>, if any:
+//
+//   manifest.json  the bucket UUID, collection id and number of vbuckets
+//                  of the export, and the vbuuid and seqno of each vbucket
+//                  the export is a snapshot of
+//   *.jsonl        the documents, one JSON object per line, with the
+//                  fields key, vb, seqno, cas, flags, expiration and value
+//
+// The documents are evaluated in the indexer, as the projector would, and
+// flushed to the slices as the first snapshot of the build. The build stream
+// is then opened from the seqnos of the export, so that DCP only streams the
+// mutations done since the export. If DCP cannot resume from them, e.g. the
+// vbuuids are no longer in the failover logs, the stream rolls back and the
+// build restarts from 0 as usual.
+//
+// While the export is ingested, the build is considered a flush in progress
+// of the INIT_STREAM, so that indexes dropped meanwhile are cleaned up once
+// it is done.
+
+const kvExportManifestFile = "manifest.json"
+const kvExportDocsSuffix = ".jsonl"
+
+// Max size of a document line of an export
+const kvExportMaxLineSize = 64 * 1024 * 1024
+
+type kvExportManifest struct {
+	BucketUUID   string   `json:"bucketUUID"`
+	CollectionId string   `json:"collectionId"`
+	NumVBuckets  int      `json:"numVBuckets"`
+	Vbuuids      []uint64 `json:"vbuuids"`
+	Seqnos       []uint64 `json:"seqnos"`
+}
+
+type kvExportDoc struct {
+	Key        string          `json:"key"`
+	VBucket    uint16          `json:"vb"`
+	Seqno      uint64          `json:"seqno"`
+	Cas        uint64          `json:"cas"`
+	Flags      uint32          `json:"flags"`
+	Expiration uint32          `json:"expiration"`
+	Value      json.RawMessage `json:"value"`
+}
+
+type kvExport struct {
+	dir      string
+	manifest kvExportManifest
+	files    []string
+}
+
+// kvExportDir returns the dir of the export of the collection of defn.
+func kvExportDir(path string, defn *common.IndexDefn) string {
+	return filepath.Join(path, defn.Bucket, defn.Scope, defn.Collection)
+}
+
+// openKVExport returns the export in dir, if it is a snapshot of the
+// collection of defn. It returns nil and no error if there is no export.
+func openKVExport(dir string, defn *common.IndexDefn, numVbuckets int) (*kvExport, error) {
+
+	bs, err := ioutil.ReadFile(filepath.Join(dir, kvExportManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	e := &kvExport{dir: dir}
+	if err := json.Unmarshal(bs, &e.manifest); err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", kvExportManifestFile, err)
+	}
+
+	m := &e.manifest
+	if m.BucketUUID != defn.BucketUUID {
+		return nil, fmt.Errorf("export of bucket UUID %v, expected %v", m.BucketUUID, defn.BucketUUID)
+	}
+	if m.CollectionId != defn.CollectionId {
+		return nil, fmt.Errorf("export of collection id %v, expected %v", m.CollectionId, defn.CollectionId)
+	}
+	if m.NumVBuckets != numVbuckets || len(m.Vbuuids) != numVbuckets || len(m.Seqnos) != numVbuckets {
+		return nil, fmt.Errorf("export of %v vbuckets with %v vbuuids and %v seqnos, expected %v",
+			m.NumVBuckets, len(m.Vbuuids), len(m.Seqnos), numVbuckets)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range infos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), kvExportDocsSuffix) {
+			e.files = append(e.files, filepath.Join(dir, fi.Name()))
+		}
+	}
+	sort.Strings(e.files)
+
+	return e, nil
+}
+
+// restartTs returns the timestamp to resume the stream of bucket from, once
+// the export is ingested.
+func (e *kvExport) restartTs(bucket string) *common.TsVbuuid {
+	ts := common.NewTsVbuuid(bucket, e.manifest.NumVBuckets)
+	for vb, seqno := range e.manifest.Seqnos {
+		ts.Seqnos[vb] = seqno
+		ts.Vbuuids[vb] = e.manifest.Vbuuids[vb]
+		ts.Snapshots[vb] = [2]uint64{seqno, seqno}
+	}
+	return ts
+}
+
+// kvIngester evaluates the documents of an export for the indexes of a
+// keyspace, and flushes them to their slices.
+type kvIngester struct {
+	keyspaceId string
+	evaluators []*protobuf.IndexEvaluator
+	flusher    *flusher
+
+	numDocs int64
+}
+
+func newKVIngester(keyspaceId string, protoInsts []*protobuf.Instance,
+	indexInstMap common.IndexInstMap, indexPartnMap IndexPartnMap,
+	config common.Config, stats *IndexerStats) (*kvIngester, error) {
+
+	in := &kvIngester{keyspaceId: keyspaceId}
+	for _, protoInst := range protoInsts {
+		ie, err := protobuf.NewIndexEvaluator(protoInst.GetIndexInstance(),
+			protobuf.FeedVersion_cheshireCat, keyspaceId)
+		if err != nil {
+			return nil, err
+		}
+		in.evaluators = append(in.evaluators, ie)
+	}
+
+	in.flusher = NewFlusher(config, stats)
+	in.flusher.indexInstMap = common.CopyIndexInstMap(indexInstMap)
+	in.flusher.indexPartnMap = CopyIndexPartnMap(indexPartnMap)
+	return in, nil
+}
+
+// ingest ingests the files of e with numWorkers files at a time, and waits
+// for the slices to be done with them.
+func (in *kvIngester) ingest(e *kvExport, numWorkers int) error {
+
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	filech := make(chan string, len(e.files))
+	for _, f := range e.files {
+		filech <- f
+	}
+	close(filech)
+
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range filech {
+				if err := in.ingestFile(e, f); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%v: %v", filepath.Base(f), err)
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, partnInstMap := range in.flusher.indexPartnMap {
+		for _, partnInst := range partnInstMap {
+			for _, slice := range partnInst.Sc.GetAllSlices() {
+				slice.FlushDone()
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func (in *kvIngester) ingestFile(e *kvExport, path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 1024*1024)
+	var encodeBuf []byte
+
+	for lineno := 1; ; lineno++ {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// long line
+			var full []byte
+			full = append(full, line...)
+			for err == bufio.ErrBufferFull && len(full) <= kvExportMaxLineSize {
+				line, err = r.ReadSlice('\n')
+				full = append(full, line...)
+			}
+			line = full
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(strings.TrimSpace(string(line))) != 0 {
+			var doc kvExportDoc
+			if err1 := json.Unmarshal(line, &doc); err1 != nil {
+				return fmt.Errorf("line %v: %v", lineno, err1)
+			}
+			if err1 := in.ingestDoc(e, &doc, &encodeBuf); err1 != nil {
+				return fmt.Errorf("line %v: %v", lineno, err1)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (in *kvIngester) ingestDoc(e *kvExport, doc *kvExportDoc, encodeBuf *[]byte) error {
+
+	vb := doc.VBucket
+	if int(vb) >= e.manifest.NumVBuckets {
+		return fmt.Errorf("invalid vbucket %v", vb)
+	}
+	if doc.Seqno > e.manifest.Seqnos[vb] {
+		// not in the snapshot of the export, DCP will stream it
+		return nil
+	}
+	vbuuid := e.manifest.Vbuuids[vb]
+
+	m := &mc.DcpEvent{
+		Opcode:  mcd.DCP_MUTATION,
+		VBucket: vb,
+		VBuuid:  vbuuid,
+		Key:     []byte(doc.Key),
+		Value:   []byte(doc.Value),
+		Cas:     doc.Cas,
+		Seqno:   doc.Seqno,
+		Flags:   doc.Flags,
+		Expiry:  doc.Expiration,
+	}
+	m.TreatAsJSON()
+
+	nvalue := qvalue.NewParsedValueWithOptions(m.Value, true, true)
+	context := qexpr.NewIndexContext()
+	docval := qvalue.NewAnnotatedValue(nvalue)
+
+	data := make(map[string]interface{})
+	for _, ie := range in.evaluators {
+		newBuf, _, err := ie.TransformRoute(vbuuid, m, data, *encodeBuf, docval, context,
+			len(in.evaluators), 0, false)
+		if err != nil {
+			logging.Errorf("kvIngester::ingestDoc TransformRoute: %v for index %v docid %s",
+				err, ie.GetIndexName(), logging.TagStrUD(m.Key))
+		}
+		if cap(newBuf) > cap(*encodeBuf) {
+			*encodeBuf = newBuf[:0]
+		}
+	}
+
+	mutk := NewMutationKeys()
+	mutk.meta = NewMutationMeta()
+	mutk.meta.keyspaceId = in.keyspaceId
+	mutk.meta.vbucket = Vbucket(vb)
+	mutk.meta.vbuuid = Vbuuid(vbuuid)
+	mutk.meta.seqno = doc.Seqno
+	mutk.meta.projVer = common.ProjVer_7_0_0
+	// the indexes are empty, and each document is in the export once
+	mutk.meta.firstSnap = true
+	mutk.docid = m.Key
+	mutk.mut = mutk.mut[:0]
+
+	for _, d := range data {
+		dkv, ok := d.(*common.DataportKeyVersions)
+		if !ok {
+			continue
+		}
+		kv := dkv.Kv
+		for i, cmd := range kv.Commands {
+			mut := NewMutation()
+			mut.uuid = common.IndexInstId(kv.Uuids[i])
+			mut.key = append(mut.key, kv.Keys[i]...)
+			mut.command = cmd
+			if len(kv.Partnkeys) != 0 && len(kv.Partnkeys[i]) != 0 {
+				mut.partnkey = append(mut.partnkey, kv.Partnkeys[i]...)
+			}
+			mutk.mut = append(mutk.mut, mut)
+		}
+	}
+
+	in.flusher.flushSingleMutation(mutk, common.INIT_STREAM)
+	atomic.AddInt64(&in.numDocs, 1)
+	return nil
+}
+
+// findKVExport returns the export to build the indexes of instIdList from,
+// or nil if there is none or the indexes are to be built with DCP.
+func (idx *indexer) findKVExport(keyspaceId string,
+	instIdList []common.IndexInstId) *kvExport {
+
+	path := idx.config["build.ingest.path"].String()
+	if path == "" || len(instIdList) == 0 {
+		return nil
+	}
+
+	defn := idx.indexInstMap[instIdList[0]].Defn
+	dir := kvExportDir(path, &defn)
+	e, err := openKVExport(dir, &defn, getNumVBuckets(defn.Bucket, idx.config))
+	if err != nil {
+		logging.Errorf("Indexer::findKVExport %v Unable to use the export in %v, "+
+			"building with DCP. Err %v", keyspaceId, dir, err)
+		return nil
+	}
+	return e
+}
+
+// startKVIngest ingests the export e for the indexes of instIdList, in the
+// background. The stream is opened once done.
+func (idx *indexer) startKVIngest(e *kvExport, instIdList []common.IndexInstId,
+	buildStream common.StreamId, keyspaceId string, cid string,
+	clusterVer uint64, buildTs Timestamp) error {
+
+	var indexList []common.IndexInst
+	for _, instId := range instIdList {
+		indexList = append(indexList, idx.indexInstMap[instId])
+	}
+
+	idx.cinfoProviderLock.RLock()
+	protoInsts := convertIndexListToProto(idx.config, idx.cinfoProvider, indexList, buildStream)
+	idx.cinfoProviderLock.RUnlock()
+
+	in, err := newKVIngester(keyspaceId, protoInsts, idx.indexInstMap,
+		idx.indexPartnMap, idx.config, idx.stats)
+	if err != nil {
+		return err
+	}
+
+	idx.streamKeyspaceIdFlushInProgress[buildStream][keyspaceId] = true
+
+	numWorkers := idx.config["build.ingest.numWorkers"].Int()
+	logging.Infof("Indexer::startKVIngest %v %v Ingesting %v files of %v for %v",
+		buildStream, keyspaceId, len(e.files), e.dir, instIdList)
+
+	go func() {
+		start := time.Now()
+		err := in.ingest(e, numWorkers)
+		if err == nil {
+			logging.Infof("Indexer::startKVIngest %v %v Ingested %v docs of %v. Took %v",
+				buildStream, keyspaceId, atomic.LoadInt64(&in.numDocs), e.dir, time.Since(start))
+		}
+
+		idx.internalRecvCh <- &MsgKVIngestDone{
+			streamId:   buildStream,
+			keyspaceId: keyspaceId,
+			instIdList: instIdList,
+			cid:        cid,
+			clusterVer: clusterVer,
+			buildTs:    buildTs,
+			restartTs:  e.restartTs(GetBucketFromKeyspaceId(keyspaceId)),
+			err:        err,
+		}
+	}()
+
+	return nil
+}
+
+// handleKVIngestDone opens the build stream from the seqnos of the ingested
+// export, or from 0 if the ingest failed.
+func (idx *indexer) handleKVIngestDone(msg Message) {
+
+	streamId := msg.(*MsgKVIngestDone).streamId
+	keyspaceId := msg.(*MsgKVIngestDone).keyspaceId
+	restartTs := msg.(*MsgKVIngestDone).restartTs
+	err := msg.(*MsgKVIngestDone).err
+
+	//the indexes dropped meanwhile are removed from the stream once it is
+	//open, like after a flush
+	var instIdList []common.IndexInstId
+	for _, instId := range msg.(*MsgKVIngestDone).instIdList {
+		if _, ok := idx.indexInstMap[instId]; ok {
+			instIdList = append(instIdList, instId)
+		}
+	}
+
+	if err != nil {
+		logging.Errorf("Indexer::handleKVIngestDone %v %v Unable to ingest export. "+
+			"Building with DCP. Err %v", streamId, keyspaceId, err)
+
+		for _, instId := range instIdList {
+			for _, partnInst := range idx.indexPartnMap[instId] {
+				for _, slice := range partnInst.Sc.GetAllSlices() {
+					if err := slice.RollbackToZero(); err != nil {
+						common.CrashOnError(err)
+					}
+				}
+			}
+		}
+		restartTs = nil
+	}
+
+	if len(instIdList) != 0 {
+		idx.sendStreamUpdateForBuildIndex(instIdList, streamId, keyspaceId,
+			msg.(*MsgKVIngestDone).cid, msg.(*MsgKVIngestDone).clusterVer,
+			msg.(*MsgKVIngestDone).buildTs, restartTs, nil)
+		idx.setStreamKeyspaceIdState(streamId, keyspaceId, STREAM_ACTIVE)
+	}
+
+	idx.streamKeyspaceIdFlushInProgress[streamId][keyspaceId] = false
+
+	//if there is any drop waiting for the ingest, notify
+	idx.notifyFlushObserver(&MsgMutMgrFlushDone{mType: STORAGE_SNAP_DONE,
+		streamId:   streamId,
+		keyspaceId: keyspaceId})
+}
@@ -0,0 +1,91 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func writeKVExportManifest(t *testing.T, dir string, m *kvExportManifest) {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, kvExportManifestFile), bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenKVExport(t *testing.T) {
+	path, err := ioutil.TempDir("", "kvexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	defn := &common.IndexDefn{Bucket: "b1", Scope: "s1", Collection: "c1",
+		BucketUUID: "uuid1", CollectionId: "8"}
+	dir := kvExportDir(path, defn)
+
+	// No export
+	if e, err := openKVExport(dir, defn, 4); e != nil || err != nil {
+		t.Fatalf("Expected no export, found %v err %v", e, err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := &kvExportManifest{
+		BucketUUID:   "uuid1",
+		CollectionId: "8",
+		NumVBuckets:  4,
+		Vbuuids:      []uint64{11, 12, 13, 14},
+		Seqnos:       []uint64{100, 0, 300, 400},
+	}
+	writeKVExportManifest(t, dir, m)
+	for _, name := range []string{"docs-1.jsonl", "docs-0.jsonl", "README"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := openKVExport(dir, defn, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.files) != 2 || filepath.Base(e.files[0]) != "docs-0.jsonl" {
+		t.Errorf("Expected the 2 doc files in order, found %v", e.files)
+	}
+
+	ts := e.restartTs("b1")
+	for vb := 0; vb < 4; vb++ {
+		if ts.Seqnos[vb] != m.Seqnos[vb] || ts.Vbuuids[vb] != m.Vbuuids[vb] ||
+			ts.Snapshots[vb] != [2]uint64{m.Seqnos[vb], m.Seqnos[vb]} {
+			t.Errorf("vb %v: unexpected restart ts %v %v %v", vb,
+				ts.Seqnos[vb], ts.Vbuuids[vb], ts.Snapshots[vb])
+		}
+	}
+
+	// Exports of another bucket or collection, or of another number of
+	// vbuckets, are not used
+	if _, err := openKVExport(dir, defn, 1024); err == nil {
+		t.Errorf("Expected an error for the number of vbuckets")
+	}
+	m.BucketUUID = "uuid2"
+	writeKVExportManifest(t, dir, m)
+	if _, err := openKVExport(dir, defn, 4); err == nil {
+		t.Errorf("Expected an error for the bucket UUID")
+	}
+}
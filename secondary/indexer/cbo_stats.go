@@ -0,0 +1,219 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/cbauth/metakv"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// The cost-based optimizer of the query service reads the statistics of
+// the indexes it costs from metakv. With settings.cbo_stats.refreshInterval
+// set, each indexer node periodically publishes, for each active index
+// instance it hosts, the item counts, average item size, resident ratio and
+// key distributions of the partitions of the instance on the node, at
+// CBOStatsMetakvDir/<instId>/<nodeUUID>. The optimizer sums the entries of
+// an instance over the nodes hosting its partitions.
+//
+// A change to the index instances of the node (index created, built,
+// dropped or moved) refreshes the statistics without waiting for the next
+// period, so that those of a dropped index are removed and those of a new
+// index are published.
+
+const CBOStatsMetakvDir = common.IndexingMetaDir + "cbo/stats/"
+
+const cboStatsVersion = 1
+
+// While publishing is disabled, the refresh interval is checked every
+// cboStatsDisabledPollInterval.
+const cboStatsDisabledPollInterval = time.Minute
+
+// CBOIndexStats is the statistics of the partitions of an index instance
+// hosted on a node, in the format read by the cost-based optimizer.
+type CBOIndexStats struct {
+	Version         int                        `json:"version"`
+	InstId          common.IndexInstId         `json:"instId"`
+	Name            string                     `json:"name"`
+	Bucket          string                     `json:"bucket"`
+	Scope           string                     `json:"scope"`
+	Collection      string                     `json:"collection"`
+	NodeUUID        string                     `json:"nodeUUID"`
+	PartitionIds    []common.PartitionId       `json:"partitionIds"`
+	NumItems        int64                      `json:"numItems"`
+	NumDocs         int64                      `json:"numDocs"`
+	AvgItemSize     int64                      `json:"avgItemSize"`
+	ResidentPercent int64                      `json:"residentPercent"`
+	Keys            []*KeyPositionDistribution `json:"keys,omitempty"`
+	RefreshTime     int64                      `json:"refreshTime"`
+}
+
+func cboStatsPath(instId common.IndexInstId, nodeUUID string) string {
+	return CBOStatsMetakvDir + strconv.FormatUint(uint64(instId), 10) + "/" + nodeUUID
+}
+
+// parseCBOStatsPath returns the instance and node of a path returned by
+// cboStatsPath.
+func parseCBOStatsPath(path string) (common.IndexInstId, string, bool) {
+	if !strings.HasPrefix(path, CBOStatsMetakvDir) {
+		return 0, "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, CBOStatsMetakvDir), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", false
+	}
+	instId, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return common.IndexInstId(instId), parts[1], true
+}
+
+func newCBOIndexStats(instId common.IndexInstId, is *IndexStats, nodeUUID string,
+	now time.Time) *CBOIndexStats {
+
+	cs := &CBOIndexStats{
+		Version:      cboStatsVersion,
+		InstId:       instId,
+		Name:         is.dispName,
+		Bucket:       is.bucket,
+		Scope:        is.scope,
+		Collection:   is.collection,
+		NodeUUID:     nodeUUID,
+		PartitionIds: is.getPartitions(),
+		RefreshTime:  now.UnixNano(),
+	}
+	sort.Slice(cs.PartitionIds, func(i, j int) bool {
+		return cs.PartitionIds[i] < cs.PartitionIds[j]
+	})
+
+	if is.useArrItemsCount {
+		cs.NumItems = is.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.arrItemsCount.Value()
+		})
+	} else {
+		cs.NumItems = is.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.itemsCount.Value()
+		})
+	}
+
+	cs.NumDocs = cs.NumItems
+	if is.isArrayIndex {
+		cs.NumDocs = is.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.docidCount.Value()
+		})
+	}
+
+	cs.AvgItemSize = computeAvgItemSize(
+		is.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.rawDataSize.Value()
+		}), cs.NumItems)
+	cs.ResidentPercent = is.partnAvgInt64Stats(func(ss *IndexStats) int64 {
+		return ss.residentPercent.Value()
+	})
+
+	for i, ps := range is.mergedKeyStats(nil) {
+		cs.Keys = append(cs.Keys, newKeyPositionDistribution(i, ps))
+	}
+	return cs
+}
+
+// invalidateCBOStats makes the publisher refresh the statistics now.
+func (s *statsManager) invalidateCBOStats() {
+	select {
+	case s.cboStatsInvalidateCh <- true:
+	default:
+	}
+}
+
+// runCBOStatsPublisher publishes the statistics of the index instances of
+// this node every settings.cbo_stats.refreshInterval seconds, and when
+// invalidated, unless the interval is 0.
+func (s *statsManager) runCBOStatsPublisher() {
+
+	// Entries published before a restart are removed unless refreshed
+	published := make(map[common.IndexInstId]bool)
+	nodeUUID := s.config.Load()["nodeuuid"].String()
+	if entries, err := common.MetakvList(CBOStatsMetakvDir); err == nil {
+		for _, entry := range entries {
+			if instId, node, ok := parseCBOStatsPath(entry.Path); ok && node == nodeUUID {
+				published[instId] = true
+			}
+		}
+	} else {
+		logging.Warnf("StatsManager::runCBOStatsPublisher Error listing published stats: %v", err)
+	}
+
+	for {
+		interval := time.Duration(s.config.Load()["settings.cbo_stats.refreshInterval"].Uint64()) * time.Second
+		if interval == 0 {
+			s.unpublishCBOStats(published, nil, nodeUUID)
+			interval = cboStatsDisabledPollInterval
+		} else {
+			s.publishCBOStats(published, nodeUUID)
+		}
+
+		select {
+		case <-s.cboStatsInvalidateCh:
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (s *statsManager) publishCBOStats(published map[common.IndexInstId]bool, nodeUUID string) {
+	stats := s.stats.Get()
+	if stats == nil || common.IndexerState(stats.indexerState.Value()) == common.INDEXER_BOOTSTRAP {
+		return
+	}
+
+	now := time.Now()
+	current := make(map[common.IndexInstId]bool)
+	for instId, is := range stats.indexes {
+		if common.IndexState(is.indexState.Value()) != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+		current[instId] = true
+
+		data, err := json.Marshal(newCBOIndexStats(instId, is, nodeUUID, now))
+		if err == nil {
+			err = metakv.Set(cboStatsPath(instId, nodeUUID), data, nil)
+		}
+		if err != nil {
+			logging.Warnf("StatsManager::publishCBOStats Error publishing stats of %v: %v", instId, err)
+			continue
+		}
+		published[instId] = true
+	}
+
+	s.unpublishCBOStats(published, current, nodeUUID)
+}
+
+// unpublishCBOStats removes the published statistics of the instances not
+// in current.
+func (s *statsManager) unpublishCBOStats(published, current map[common.IndexInstId]bool,
+	nodeUUID string) {
+
+	for instId := range published {
+		if current[instId] {
+			continue
+		}
+		if err := metakv.Delete(cboStatsPath(instId, nodeUUID), nil); err != nil {
+			logging.Warnf("StatsManager::unpublishCBOStats Error removing stats of %v: %v", instId, err)
+			continue
+		}
+		delete(published, instId)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCBOStatsPath(t *testing.T) {
+	path := cboStatsPath(common.IndexInstId(1234), "node1")
+	instId, node, ok := parseCBOStatsPath(path)
+	if !ok || instId != 1234 || node != "node1" {
+		t.Fatalf("expected 1234 on node1, got %v on %v (%v)", instId, node, ok)
+	}
+
+	for _, p := range []string{
+		CBOStatsMetakvDir + "1234",
+		CBOStatsMetakvDir + "1234/",
+		CBOStatsMetakvDir + "abc/node1",
+		common.IndexingMetaDir + "rebalance/1234/node1",
+	} {
+		if _, _, ok := parseCBOStatsPath(p); ok {
+			t.Fatalf("expected %v not to be a stats path", p)
+		}
+	}
+}
+
+func TestNewCBOIndexStats(t *testing.T) {
+	is := &IndexStats{name: "idx", dispName: "idx", bucket: "b", scope: "s", collection: "c"}
+	is.Init()
+	is.partitions = make(map[common.PartitionId]*IndexStats)
+	for _, id := range []common.PartitionId{2, 1} {
+		is.addPartition(id)
+		ps := is.getPartitionStats(id)
+		ps.itemsCount.Set(100)
+		ps.rawDataSize.Set(2000)
+		ps.residentPercent.Set(int64(id) * 40)
+	}
+
+	cs := newCBOIndexStats(common.IndexInstId(7), is, "node1", time.Unix(0, 10))
+	if cs.InstId != 7 || cs.NodeUUID != "node1" || cs.RefreshTime != 10 {
+		t.Fatalf("unexpected identity %+v", cs)
+	}
+	if len(cs.PartitionIds) != 2 || cs.PartitionIds[0] != 1 || cs.PartitionIds[1] != 2 {
+		t.Fatalf("expected partitions [1 2], got %v", cs.PartitionIds)
+	}
+	if cs.NumItems != 200 || cs.NumDocs != 200 || cs.AvgItemSize != 20 || cs.ResidentPercent != 60 {
+		t.Fatalf("unexpected counts %+v", cs)
+	}
+	if len(cs.Keys) != 0 {
+		t.Fatalf("expected no key distributions without sketches, got %v", len(cs.Keys))
+	}
+}
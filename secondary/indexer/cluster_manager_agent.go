@@ -482,7 +482,7 @@ func (c *clustMgrAgent) handleGetGlobalTopology(cmd Message) {
 				partitions[i] = common.PartitionId(partn.PartId)
 				versions[i] = int(partn.Version)
 			}
-			pc := c.metaNotifier.makeDefaultPartitionContainer(partitions, versions, inst.NumPartitions, idxDefn.PartitionScheme, idxDefn.HashScheme)
+			pc := c.metaNotifier.makeDefaultPartitionContainer(idxDefn.Bucket, partitions, versions, inst.NumPartitions, idxDefn.PartitionScheme, idxDefn.HashScheme)
 
 			// create index instance
 			idxInst := common.IndexInst{
@@ -699,7 +699,7 @@ func (meta *metaNotifier) OnIndexCreate(indexDefn *common.IndexDefn, instId comm
 		" instId %v, indexDefn %+v, reqCtx %+v, partitions %v",
 		_OnIndexCreate, instId, indexDefn, reqCtx, partitions)
 
-	pc := meta.makeDefaultPartitionContainer(partitions, versions, numPartitions, indexDefn.PartitionScheme, indexDefn.HashScheme)
+	pc := meta.makeDefaultPartitionContainer(indexDefn.Bucket, partitions, versions, numPartitions, indexDefn.PartitionScheme, indexDefn.HashScheme)
 
 	idxInst := common.IndexInst{InstId: instId,
 		Defn:       *indexDefn,
@@ -925,10 +925,10 @@ func (meta *metaNotifier) fetchStats() {
 	}
 }
 
-func (meta *metaNotifier) makeDefaultPartitionContainer(partitions []common.PartitionId, versions []int, numPartitions uint32,
+func (meta *metaNotifier) makeDefaultPartitionContainer(bucket string, partitions []common.PartitionId, versions []int, numPartitions uint32,
 	scheme common.PartitionScheme, hash common.HashScheme) common.PartitionContainer {
 
-	numVbuckets := meta.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(bucket, meta.config)
 	pc := common.NewKeyPartitionContainer(numVbuckets, int(numPartitions), scheme, hash)
 
 	//Add one partition for now
@@ -132,6 +132,7 @@ type IndexStorageStats struct {
 	Bucket     string
 	Scope      string
 	Collection string
+	Tier       StorageTier
 	Stats      StorageStatistics
 }
 
@@ -48,6 +48,7 @@ type compactionDaemon struct {
 	clusterAddr  string
 	lastCheckDay int32
 	mutex        sync.Mutex
+	advisor      *defragAdvisor
 }
 
 type indexCompaction struct {
@@ -132,6 +133,10 @@ loop:
 						cd.compactPlasma()
 					}
 				}
+
+				if ok {
+					cd.adviseCompaction()
+				}
 			}
 
 			conf := cd.config.Load()
@@ -750,9 +755,12 @@ func (cm *compactionManager) newCompactionDaemon() *compactionDaemon {
 		lastCheckDay: -1,
 		compactions:  make(map[string]*indexCompaction),
 		history:      make(map[string]*indexCompaction),
+		advisor:      newDefragAdvisor(),
 	}
 	cd.config.Store(cfg)
 
+	GetHTTPMux().HandleFunc("/compactionAdvice", cd.handleCompactionAdviceReq)
+
 	return cd
 }
 
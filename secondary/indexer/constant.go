@@ -77,9 +77,14 @@ const MAX_PROJ_RETRY = 20
 // Constants for stats persistence in snapshot meta
 const SNAPSHOT_META_VERSION_MOI_1 = 1
 const SNAPSHOT_META_VERSION_PLASMA_1 = 1
+
+// Snapshot meta with the timestamp in CompactTs, see common.EncodeTsVbuuid
+const SNAPSHOT_META_VERSION_MOI_2 = 2
+const SNAPSHOT_META_VERSION_PLASMA_2 = 2
 const SNAP_STATS_KEY_SIZES = "key_size_dist"
 const SNAP_STATS_ARRKEY_SIZES = "arrkey_size_dist"
 const SNAP_STATS_KEY_SIZES_SINCE = "key_size_stats_since"
 const SNAP_STATS_RAW_DATA_SIZE = "raw_data_size"
 const SNAP_STATS_BACKSTORE_RAW_DATA_SIZE = "backstore_raw_data_size"
 const SNAP_STATS_ARR_ITEMS_COUNT = "arr_items_count"
+const SNAP_STATS_OVERFLOW_KEYS = "num_overflow_keys"
@@ -62,6 +62,8 @@ type DDLServiceMgr struct {
 	handleClusterStorageModeMutex sync.Mutex    // serializes handleClusterStorageMode calls
 
 	deleteTokenCache map[common.IndexDefnId]int64 // unixnano timestamp
+
+	replicaRepairer *replicaRepairer // repairs replicas lost on failover
 }
 
 const DELETE_TOKEN_DELAYED_CLEANUP_INTERVAL = 24 * time.Hour
@@ -119,6 +121,7 @@ func NewDDLServiceMgr(indexerId common.IndexerId, supvCmdch MsgChannel, supvMsgc
 		allowDDL:                     true,
 		tokenCleanerStopCh:           make(chan struct{}),
 		deleteTokenCache:             make(map[common.IndexDefnId]int64),
+		replicaRepairer:              newReplicaRepairer(),
 	}
 
 	mgr.startCommandListner()
@@ -135,9 +138,11 @@ func NewDDLServiceMgr(indexerId common.IndexerId, supvCmdch MsgChannel, supvMsgc
 	mux.HandleFunc("/listScheduleCreateTokens", mgr.handleListScheduleCreateTokens)
 	mux.HandleFunc("/listStopScheduleCreateTokens", mgr.handleListStopScheduleCreateTokens)
 	mux.HandleFunc("/transferScheduleCreateTokens", mgr.handleTransferScheduleCreateTokens)
+	mux.HandleFunc("/replicaRepairStatus", mgr.handleReplicaRepairStatus)
 
 	go mgr.run()
 	go mgr.runTokenCleaner()
+	go mgr.runReplicaRepair()
 
 	setDDLServiceMgr(mgr)
 
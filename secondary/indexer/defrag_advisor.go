@@ -0,0 +1,296 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// A single fragmentation threshold compacts a small partition with high
+// fragmentation before a large one holding far more garbage. With
+// compaction.advisor.enabled, the compaction daemon samples the space each
+// index partition would reclaim on compaction at every check, and tracks
+// how fast it grows. A partition is recommended for compaction once it
+// would reclaim compaction.advisor.minSavings, or once its fragmentation
+// is over min_frag, the largest projected savings first. Recommendations
+// are listed by /compactionAdvice and, with compaction.advisor.autoCompact,
+// compacted, at most compaction.advisor.maxCompactions at a time.
+
+const defragAdvisorMaxSamples = 60
+
+// fragSample is the fragmentation of a partition at a time.
+type fragSample struct {
+	time        int64 // unix nano
+	fragPercent int64
+	diskSize    int64
+	wasted      int64 // bytes reclaimed by compaction
+}
+
+// fragHistory is the fragmentation of a partition since it was last
+// compacted, from the oldest sample.
+type fragHistory struct {
+	instId     common.IndexInstId
+	partnId    common.PartitionId
+	bucket     string
+	scope      string
+	collection string
+	name       string
+	samples    []fragSample
+}
+
+// DefragRecommendation is the compaction advice for an index partition.
+type DefragRecommendation struct {
+	InstId           common.IndexInstId `json:"instId"`
+	PartitionId      common.PartitionId `json:"partitionId"`
+	Bucket           string             `json:"bucket"`
+	Scope            string             `json:"scope"`
+	Collection       string             `json:"collection"`
+	Name             string             `json:"name"`
+	FragPercent      int64              `json:"fragPercent"`
+	DiskSize         int64              `json:"diskSize"`
+	Reclaimable      int64              `json:"reclaimable"`
+	GrowthPerHour    int64              `json:"growthPerHour"`
+	ProjectedSavings int64              `json:"projectedSavings"`
+	Compact          bool               `json:"compact"`
+	Compacting       bool               `json:"compacting"`
+}
+
+// defragAdvisor holds the fragmentation history of index partitions.
+type defragAdvisor struct {
+	mu        sync.Mutex
+	histories map[string]*fragHistory
+}
+
+func newDefragAdvisor() *defragAdvisor {
+	return &defragAdvisor{histories: make(map[string]*fragHistory)}
+}
+
+// partnWastedSpace returns the bytes compaction would reclaim from a
+// partition.
+func partnWastedSpace(ps *IndexStats, isPlasma bool) int64 {
+	if isPlasma {
+		logSpace, dataSize := ps.logSpaceOnDisk.Value(), ps.dataSizeOnDisk.Value()
+		if dataSize > 0 && logSpace > dataSize {
+			return logSpace - dataSize
+		}
+		return 0
+	}
+	return computeGarbage(ps)
+}
+
+// sample records the fragmentation of the partitions of indexInstMap at now,
+// dropping partitions no longer in indexInstMap.
+func (a *defragAdvisor) sample(stats *IndexerStats, indexInstMap common.IndexInstMap,
+	isPlasma bool, now time.Time) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	histories := make(map[string]*fragHistory)
+	for instId, inst := range indexInstMap {
+		if inst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		for _, partn := range inst.Pc.GetAllPartitions() {
+			partnId := partn.GetPartitionId()
+			ps := stats.GetPartitionStats(instId, partnId)
+			if ps == nil {
+				continue
+			}
+
+			name := indexCompactionName(instId, partnId)
+			h, ok := a.histories[name]
+			if !ok {
+				h = &fragHistory{
+					instId:     instId,
+					partnId:    partnId,
+					bucket:     inst.Defn.Bucket,
+					scope:      inst.Defn.Scope,
+					collection: inst.Defn.Collection,
+					name:       inst.Defn.Name,
+				}
+			}
+			h.add(fragSample{
+				time:        now.UnixNano(),
+				fragPercent: ps.fragPercent.Value(),
+				diskSize:    ps.diskSize.Value(),
+				wasted:      partnWastedSpace(ps, isPlasma),
+			})
+			histories[name] = h
+		}
+	}
+	a.histories = histories
+}
+
+// add appends s, restarting the history if the partition was compacted.
+func (h *fragHistory) add(s fragSample) {
+	if n := len(h.samples); n > 0 && s.wasted < h.samples[n-1].wasted {
+		h.samples = h.samples[:0]
+	}
+	h.samples = append(h.samples, s)
+	if len(h.samples) > defragAdvisorMaxSamples {
+		h.samples = append(h.samples[:0], h.samples[len(h.samples)-defragAdvisorMaxSamples:]...)
+	}
+}
+
+// advise returns the compaction advice for the partition of h, projecting
+// its savings over horizon.
+func (h *fragHistory) advise(minSavings, minFrag int64, horizon time.Duration) *DefragRecommendation {
+	if len(h.samples) == 0 {
+		return nil
+	}
+
+	first, last := h.samples[0], h.samples[len(h.samples)-1]
+	var growth int64
+	if elapsed := time.Duration(last.time - first.time); elapsed > 0 {
+		growth = int64(float64(last.wasted-first.wasted) * float64(time.Hour) / float64(elapsed))
+	}
+
+	return &DefragRecommendation{
+		InstId:           h.instId,
+		PartitionId:      h.partnId,
+		Bucket:           h.bucket,
+		Scope:            h.scope,
+		Collection:       h.collection,
+		Name:             h.name,
+		FragPercent:      last.fragPercent,
+		DiskSize:         last.diskSize,
+		Reclaimable:      last.wasted,
+		GrowthPerHour:    growth,
+		ProjectedSavings: last.wasted + int64(float64(growth)*horizon.Hours()),
+		Compact:          last.wasted > 0 && (last.wasted >= minSavings || last.fragPercent >= minFrag),
+	}
+}
+
+// recommendations returns the advice for the partitions with space to
+// reclaim, the largest projected savings first.
+func (a *defragAdvisor) recommendations(minSavings, minFrag int64,
+	horizon time.Duration) []*DefragRecommendation {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]*DefragRecommendation, 0, len(a.histories))
+	for _, h := range a.histories {
+		if rec := h.advise(minSavings, minFrag, horizon); rec != nil && rec.Reclaimable > 0 {
+			result = append(result, rec)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ProjectedSavings != result[j].ProjectedSavings {
+			return result[i].ProjectedSavings > result[j].ProjectedSavings
+		}
+		if result[i].InstId != result[j].InstId {
+			return result[i].InstId < result[j].InstId
+		}
+		return result[i].PartitionId < result[j].PartitionId
+	})
+	return result
+}
+
+// adviseCompaction samples the fragmentation of the partitions and, with
+// autoCompact, compacts the recommended ones.
+func (cd *compactionDaemon) adviseCompaction() {
+
+	config := cd.config.Load()
+	if !config["advisor.enabled"].Bool() {
+		return
+	}
+
+	stats := cd.stats.Get()
+	if stats == nil {
+		return
+	}
+
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+
+	cd.advisor.sample(stats, cd.indexInstMap, common.GetStorageMode() == common.PLASMA, time.Now())
+	if !config["advisor.autoCompact"].Bool() {
+		return
+	}
+
+	quota := config["advisor.maxCompactions"].Int() - cd.numCompactionsNoLock()
+	for _, rec := range cd.getCompactionAdviceNoLock(config) {
+		if quota <= 0 {
+			break
+		}
+		if !rec.Compact || rec.Compacting {
+			continue
+		}
+
+		partnStats := stats.GetPartitionStats(rec.InstId, rec.PartitionId)
+		if cd.addIndexCompactionNoLock(rec.InstId, rec.PartitionId, partnStats) {
+			logging.Infof("CompactionDaemon: advised compaction: inst %v partition %v fragmentation %v "+
+				"reclaimable %v projected savings %v.", rec.InstId, rec.PartitionId, rec.FragPercent,
+				rec.Reclaimable, rec.ProjectedSavings)
+			go cd.runCompaction(newMsgIndexCompact(rec.InstId, rec.PartitionId, 0))
+			quota--
+		}
+	}
+}
+
+func (cd *compactionDaemon) getCompactionAdviceNoLock(config common.Config) []*DefragRecommendation {
+
+	minSavings := int64(config["advisor.minSavings"].Int()) * 1024 * 1024
+	minFrag := int64(config["min_frag"].Int())
+	horizon := time.Duration(config["advisor.horizon"].Int()) * time.Second
+
+	recs := cd.advisor.recommendations(minSavings, minFrag, horizon)
+	for _, rec := range recs {
+		rec.Compacting = cd.isIndexCompactingNoLock(rec.InstId, rec.PartitionId)
+	}
+	return recs
+}
+
+// handleCompactionAdviceReq responds to /compactionAdvice with the
+// compaction advice for the index partitions on this node.
+func (cd *compactionDaemon) handleCompactionAdviceReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.settings!read"}, r, w,
+		"CompactionDaemon::handleCompactionAdviceReq") {
+		return
+	}
+
+	recs := func() []*DefragRecommendation {
+		cd.mutex.Lock()
+		defer cd.mutex.Unlock()
+		return cd.getCompactionAdviceNoLock(cd.config.Load())
+	}()
+
+	data, err := json.Marshal(recs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
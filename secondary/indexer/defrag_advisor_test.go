@@ -0,0 +1,67 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFragHistoryAdvise(t *testing.T) {
+	now := time.Now()
+	sample := func(min time.Duration, frag, wasted int64) fragSample {
+		return fragSample{time: now.Add(min * time.Minute).UnixNano(), fragPercent: frag, wasted: wasted}
+	}
+
+	h := &fragHistory{instId: 1}
+	h.add(sample(0, 10, 100))
+	h.add(sample(30, 15, 200))
+
+	rec := h.advise(1000, 30, time.Hour)
+	if rec.GrowthPerHour != 200 || rec.ProjectedSavings != 400 || rec.Compact {
+		t.Fatalf("expected 200/hour growth and no compaction, got %+v", rec)
+	}
+	if rec := h.advise(200, 30, time.Hour); !rec.Compact {
+		t.Fatalf("expected compaction over min savings, got %+v", rec)
+	}
+	if rec := h.advise(1000, 15, time.Hour); !rec.Compact {
+		t.Fatalf("expected compaction over min frag, got %+v", rec)
+	}
+
+	// Compacted
+	h.add(sample(40, 1, 10))
+	if len(h.samples) != 1 {
+		t.Fatalf("expected the history to restart after compaction, got %v samples", len(h.samples))
+	}
+
+	for i := 0; i < 2*defragAdvisorMaxSamples; i++ {
+		h.add(sample(time.Duration(50+i), 1, int64(10+i)))
+	}
+	if len(h.samples) != defragAdvisorMaxSamples {
+		t.Fatalf("expected %v samples, got %v", defragAdvisorMaxSamples, len(h.samples))
+	}
+}
+
+func TestDefragAdvisorRecommendations(t *testing.T) {
+	now := time.Now().UnixNano()
+	a := newDefragAdvisor()
+	a.histories["small"] = &fragHistory{instId: 1, samples: []fragSample{{now, 80, 10, 8}}}
+	a.histories["large"] = &fragHistory{instId: 2, samples: []fragSample{{now, 20, 1000, 200}}}
+	a.histories["clean"] = &fragHistory{instId: 3, samples: []fragSample{{now, 0, 1000, 0}}}
+
+	recs := a.recommendations(100, 50, time.Hour)
+	if len(recs) != 2 || recs[0].InstId != 2 || recs[1].InstId != 1 {
+		t.Fatalf("expected the large partition first, got %+v", recs)
+	}
+	for _, rec := range recs {
+		if !rec.Compact {
+			t.Fatalf("expected both partitions recommended, got %+v", rec)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/logging/systemevent"
+)
+
+// Transient incidents such as memory spikes, goroutine leaks or scan backlogs are usually gone
+// by the time anyone looks at them. With diagnostics.enabled, the indexer checks every
+// diagnostics.interval whether the memory used, the number of goroutines or the number of
+// pending scan requests exceeds its threshold and, if so, captures heap and goroutine profiles
+// to a directory named after the time and the trigger under diagnostics.dir, and logs an event.
+// Captures are at least diagnostics.cooldown apart and only the diagnostics.max_captures most
+// recent ones are kept.
+
+const diagnosticsPrefix = "indexer_diag_"
+
+// diagnosticsProfiles are the pprof profiles captured.
+var diagnosticsProfiles = []string{"heap", "goroutine"}
+
+type diagnosticsThresholds struct {
+	memoryFrac    float64 // of memory quota
+	numGoroutines int64
+	pendingScans  int64
+}
+
+type diagnosticsMetrics struct {
+	memoryQuota   int64
+	memoryUsed    int64
+	numGoroutines int64
+	pendingScans  int64
+}
+
+// trigger returns the first threshold exceeded by m, with the value and the threshold.
+// Thresholds of 0 are disabled.
+func (t diagnosticsThresholds) trigger(m diagnosticsMetrics) (string, int64, int64, bool) {
+	if t.memoryFrac > 0 && m.memoryQuota > 0 {
+		threshold := int64(t.memoryFrac * float64(m.memoryQuota))
+		if m.memoryUsed > threshold {
+			return "memory", m.memoryUsed, threshold, true
+		}
+	}
+	if t.numGoroutines > 0 && m.numGoroutines > t.numGoroutines {
+		return "goroutines", m.numGoroutines, t.numGoroutines, true
+	}
+	if t.pendingScans > 0 && m.pendingScans > t.pendingScans {
+		return "pending_scans", m.pendingScans, t.pendingScans, true
+	}
+	return "", 0, 0, false
+}
+
+// captureDiagnostics writes the diagnostics profiles to a new directory under dir and removes
+// the oldest captures beyond maxCaptures. It returns the path of the capture.
+func captureDiagnostics(dir, trigger string, now time.Time, maxCaptures int) (string, error) {
+	name := fmt.Sprintf("%v%v_%v", diagnosticsPrefix,
+		now.UTC().Format("20060102T150405.000"), trigger)
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", err
+	}
+
+	for _, profile := range diagnosticsProfiles {
+		if err := writeProfile(filepath.Join(path, profile+".pprof"), profile); err != nil {
+			return path, err
+		}
+	}
+
+	return path, pruneDiagnostics(dir, maxCaptures)
+}
+
+func writeProfile(filename, profile string) error {
+	fd, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	p := pprof.Lookup(profile)
+	if p == nil {
+		return fmt.Errorf("unknown profile %v", profile)
+	}
+	return p.WriteTo(fd, 0)
+}
+
+// pruneDiagnostics removes the oldest captures under dir beyond maxCaptures.
+func pruneDiagnostics(dir string, maxCaptures int) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Names start with the time of the capture
+	var captures []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), diagnosticsPrefix) {
+			captures = append(captures, entry.Name())
+		}
+	}
+	sort.Strings(captures)
+
+	for len(captures) > maxCaptures {
+		if err := os.RemoveAll(filepath.Join(dir, captures[0])); err != nil {
+			return err
+		}
+		captures = captures[1:]
+	}
+	return nil
+}
+
+// diagnosticsDir returns diagnostics.dir, defaulting to a directory under the log directory
+// or else the storage directory.
+func (idx *indexer) diagnosticsDir() string {
+	if dir := idx.config["diagnostics.dir"].String(); dir != "" {
+		return dir
+	}
+	if dir := idx.config["log_dir"].String(); dir != "" {
+		return filepath.Join(dir, "diagnostics")
+	}
+	return filepath.Join(idx.config["storage_dir"].String(), "diagnostics")
+}
+
+// pendingScans returns the number of scan requests received and not yet completed.
+func (idx *indexer) pendingScans() int64 {
+	stats := idx.statsMgr.stats.Get()
+	if stats == nil {
+		return 0
+	}
+
+	var pending int64
+	for _, is := range stats.indexes {
+		pending += is.int64Stats(func(ss *IndexStats) int64 {
+			return ss.numRequests.Value()
+		}) - is.numCompletedRequests.Value()
+	}
+	return pending
+}
+
+// monitorDiagnostics captures diagnostics when a resource exceeds its threshold.
+func (idx *indexer) monitorDiagnostics() {
+
+	logging.Infof("Indexer::monitorDiagnostics started...")
+
+	var lastCapture time.Time
+	for {
+		interval := time.Duration(idx.config["diagnostics.interval"].Int()) * time.Second
+		if interval <= 0 {
+			interval = time.Second
+		}
+		time.Sleep(interval)
+
+		if !idx.config["diagnostics.enabled"].Bool() {
+			continue
+		}
+
+		cooldown := time.Duration(idx.config["diagnostics.cooldown"].Int()) * time.Second
+		if time.Since(lastCapture) < cooldown {
+			continue
+		}
+
+		thresholds := diagnosticsThresholds{
+			memoryFrac:    idx.config["diagnostics.memory_frac"].Float64(),
+			numGoroutines: int64(idx.config["diagnostics.num_goroutines"].Int()),
+			pendingScans:  int64(idx.config["diagnostics.pending_scans"].Int()),
+		}
+
+		used, _, _ := idx.memoryUsed(false)
+		metrics := diagnosticsMetrics{
+			memoryQuota:   int64(idx.config.GetIndexerMemoryQuota()),
+			memoryUsed:    int64(used),
+			numGoroutines: int64(runtime.NumGoroutine()),
+			pendingScans:  idx.pendingScans(),
+		}
+
+		trigger, value, threshold, ok := thresholds.trigger(metrics)
+		if !ok {
+			continue
+		}
+
+		lastCapture = time.Now()
+		path, err := captureDiagnostics(idx.diagnosticsDir(), trigger, lastCapture,
+			idx.config["diagnostics.max_captures"].Int())
+		if err != nil {
+			logging.Errorf("Indexer::monitorDiagnostics %v %v exceeds %v: capture to %v failed"+
+				" with error %v", trigger, value, threshold, path, err)
+			continue
+		}
+
+		idx.stats.numDiagnosticsCaptures.Add(1)
+		logging.Infof("Indexer::monitorDiagnostics %v %v exceeds %v: captured %v",
+			trigger, value, threshold, path)
+
+		ev := systemevent.NewDiagnosticsEvent("Indexer::monitorDiagnostics",
+			trigger, value, threshold, path)
+		systemevent.WarnEvent("Indexer", systemevent.EVENTID_INDEXER_DIAGNOSTICS_CAPTURED, ev)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsTrigger(t *testing.T) {
+	thresholds := diagnosticsThresholds{memoryFrac: 0.9, numGoroutines: 1000, pendingScans: 100}
+
+	m := diagnosticsMetrics{memoryQuota: 1000, memoryUsed: 800, numGoroutines: 500, pendingScans: 10}
+	if trigger, _, _, ok := thresholds.trigger(m); ok {
+		t.Fatalf("expected no trigger, got %v", trigger)
+	}
+
+	m.memoryUsed = 950
+	if trigger, value, threshold, ok := thresholds.trigger(m); !ok || trigger != "memory" ||
+		value != 950 || threshold != 900 {
+		t.Fatalf("expected memory trigger, got %v %v %v %v", trigger, value, threshold, ok)
+	}
+
+	m.memoryUsed = 800
+	m.pendingScans = 200
+	if trigger, _, _, ok := thresholds.trigger(m); !ok || trigger != "pending_scans" {
+		t.Fatalf("expected pending_scans trigger, got %v %v", trigger, ok)
+	}
+
+	thresholds.pendingScans = 0
+	if trigger, _, _, ok := thresholds.trigger(m); ok {
+		t.Fatalf("expected disabled threshold, got %v", trigger)
+	}
+}
+
+func TestCaptureDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path, err := captureDiagnostics(dir, "memory", now.Add(time.Duration(i)*time.Second), 2)
+		if err != nil {
+			t.Fatalf("capture failed with error %v", err)
+		}
+		paths = append(paths, path)
+
+		for _, profile := range diagnosticsProfiles {
+			if fi, err := os.Stat(filepath.Join(path, profile+".pprof")); err != nil || fi.Size() == 0 {
+				t.Fatalf("expected %v profile in %v, got error %v", profile, path, err)
+			}
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 captures kept, got %v", len(entries))
+	}
+	for _, path := range paths[:2] {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected oldest capture %v removed", path)
+		}
+	}
+}
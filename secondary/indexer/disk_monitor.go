@@ -0,0 +1,143 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// The indexer monitors the usage of the storage volumes, the one of the
+// storage dir and the one of the cold storage tier if any. When a volume
+// is fuller than high_disk_mark, the indexer pauses, as it does when its
+// memory is full, rather than failing to write to it: the streams stop
+// processing mutations, no more flushes are done, and stale=ok scans are
+// still served from the existing snapshots. The indexer resumes once all
+// volumes are below low_disk_mark.
+//
+// This is opt-in with pause_if_disk_full. The whole indexer is paused,
+// rather than only the slices on the full volume, so a full cold tier
+// volume also stops the indexes kept in memory or on the storage dir.
+
+// storageVolumePaths returns the paths of the storage volumes of cfg.
+func storageVolumePaths(cfg common.Config) []string {
+	paths := []string{cfg["storage_dir"].String()}
+	if coldPath := cfg["storage.tiers.coldPath"].String(); coldPath != "" {
+		paths = append(paths, coldPath)
+	}
+	return paths
+}
+
+// maxDiskUsage returns the used fraction of the space of the fullest volume
+// of paths, and its path. The volumes which cannot be checked are skipped.
+func maxDiskUsage(paths []string) (usage float64, path string, err error) {
+	checked := false
+	for _, p := range paths {
+		used, total, err1 := getDiskUsage(p)
+		if err1 != nil {
+			err = err1
+			continue
+		}
+
+		checked = true
+		if total == 0 {
+			continue
+		}
+		if u := float64(used) / float64(total); u >= usage {
+			usage, path = u, p
+		}
+	}
+
+	if checked {
+		return usage, path, nil
+	}
+	if err == nil {
+		err = errors.New("no storage volume to check")
+	}
+	return 0, "", err
+}
+
+// diskFullAction returns whether to pause or resume the indexer for the
+// usage of the fullest storage volume, given whether it is active and
+// whether it was paused for disk usage.
+func diskFullAction(enabled, active, pausedForDisk bool,
+	usage, highMark, lowMark float64) (pause, resume bool) {
+
+	if !enabled {
+		return false, pausedForDisk
+	}
+
+	if active {
+		return usage > highMark, false
+	}
+	return false, pausedForDisk && usage < lowMark
+}
+
+// monitor the usage of the storage volumes, if more than specified mark
+// generate message to pause Indexer
+func (idx *indexer) monitorDiskUsage() {
+
+	logging.Infof("Indexer::monitorDiskUsage started...")
+
+	//a paused state recovered at bootstrap can be resumed here,
+	//unless memory usage is monitored
+	if idx.getIndexerState() == common.INDEXER_PAUSED &&
+		!(common.GetStorageMode() == common.MOI && idx.config["pause_if_memory_full"].Bool()) {
+		atomic.StoreInt32(&idx.diskFullPaused, 1)
+	}
+
+	for {
+
+		monitorInterval := idx.config["disk_usage_check_interval"].Int()
+		if monitorInterval <= 0 {
+			monitorInterval = 10
+		}
+
+		usage, path, err := maxDiskUsage(storageVolumePaths(idx.config))
+		if err != nil {
+			logging.Errorf("Indexer::monitorDiskUsage Unable to check disk usage. Err %v", err)
+			time.Sleep(time.Second * time.Duration(monitorInterval))
+			continue
+		}
+		idx.stats.diskUsedPercent.Set(int64(usage * 100))
+
+		//an active indexer is not paused for disk usage, even if a pause
+		//was requested and ignored
+		state := idx.getIndexerState()
+		if state == common.INDEXER_ACTIVE {
+			atomic.StoreInt32(&idx.diskFullPaused, 0)
+		}
+		pausedForDisk := atomic.LoadInt32(&idx.diskFullPaused) == 1
+		pause, resume := diskFullAction(idx.config["pause_if_disk_full"].Bool(),
+			state == common.INDEXER_ACTIVE, pausedForDisk, usage,
+			idx.config["high_disk_mark"].Float64(), idx.config["low_disk_mark"].Float64())
+
+		if pause {
+			logging.Warnf("Indexer::monitorDiskUsage Storage volume of %v is %.1f%% full. "+
+				"Pausing indexer.", path, usage*100)
+			atomic.StoreInt32(&idx.diskFullPaused, 1)
+			idx.stats.numDiskFullPauses.Add(1)
+			idx.internalRecvCh <- &MsgIndexerState{mType: INDEXER_PAUSE}
+
+		} else if resume {
+			atomic.StoreInt32(&idx.diskFullPaused, 0)
+			if state == common.INDEXER_PAUSED {
+				logging.Infof("Indexer::monitorDiskUsage Storage volumes are %.1f%% full at most. "+
+					"Resuming indexer.", usage*100)
+				idx.internalRecvCh <- &MsgIndexerState{mType: INDEXER_RESUME}
+			}
+		}
+
+		time.Sleep(time.Second * time.Duration(monitorInterval))
+	}
+}
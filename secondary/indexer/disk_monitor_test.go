@@ -0,0 +1,60 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaxDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskusage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	usage, path, err := maxDiskUsage([]string{dir, filepath.Join(dir, "missing")})
+	if err != nil || path != dir || usage <= 0 || usage > 1 {
+		t.Fatalf("expected the usage of %v, got %v %v %v", dir, usage, path, err)
+	}
+
+	if _, _, err := maxDiskUsage([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Fatalf("expected an error without a volume to check")
+	}
+}
+
+func TestDiskFullAction(t *testing.T) {
+	high, low := 0.95, 0.9
+
+	tests := []struct {
+		enabled, active, pausedForDisk bool
+		usage                          float64
+		pause, resume                  bool
+	}{
+		{true, true, false, 0.5, false, false},
+		{true, true, false, 0.96, true, false},
+		{true, false, false, 0.96, false, false}, // paused for memory
+		{true, false, true, 0.92, false, false},
+		{true, false, true, 0.85, false, true},
+		{false, false, true, 0.99, false, true},
+		{false, true, false, 0.99, false, false},
+	}
+
+	for i, test := range tests {
+		pause, resume := diskFullAction(test.enabled, test.active, test.pausedForDisk,
+			test.usage, high, low)
+		if pause != test.pause || resume != test.resume {
+			t.Fatalf("test %v: expected pause %v resume %v, got %v %v", i,
+				test.pause, test.resume, pause, resume)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+//go:build !windows
+// +build !windows
+
+package indexer
+
+import (
+	"syscall"
+)
+
+// getDiskUsage returns the used and total space, in bytes, of the volume of
+// path, as seen by unprivileged users.
+func getDiskUsage(path string) (used, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+
+	bsize := uint64(st.Bsize)
+	free := uint64(st.Bfree) * bsize
+	avail := uint64(st.Bavail) * bsize
+	total = uint64(st.Blocks)*bsize - (free - avail) // less the reserved space
+	return total - avail, total, nil
+}
@@ -86,6 +86,7 @@ const (
 	ERROR_SCAN_COORD_QUERYPORT_FAIL
 	ERROR_BUCKET_EPHEMERAL
 	ERROR_BUCKET_EPHEMERAL_STD
+	ERROR_STORAGE_MGR_INVALID_CONFIG
 )
 
 type errSeverity int16
@@ -0,0 +1,198 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// A flush of the mutation queue which does not reach its timestamp is
+// reported aborted, and the storage manager takes no snapshot for it. The
+// scans waiting for that timestamp keep waiting for the next one. The
+// mutation manager attributes each aborted flush to a cause, counted in
+// the stats of the keyspace in its stream, and the indexer keeps the last
+// maxFlushAbortEvents of them, listed by /internal/flushAborts.
+
+const (
+	flushAbortShutdown = "shutdown"        // the mutation manager was shutting down
+	flushAbortRollback = "rollback"        // aborted by timekeeper to recover from a rollback
+	flushAbortMemory   = "memory_pressure" // the mutation queues were out of memory
+	flushAbortOther    = "other"
+)
+
+const maxFlushAbortEvents = 256
+
+// flushAbortCause returns the cause of an aborted flush. abortRequested
+// tells if the supervisor asked for the abort.
+func (m *mutationMgr) flushAbortCause(abortRequested bool) string {
+
+	select {
+	case <-m.shutdownCh:
+		return flushAbortShutdown
+	default:
+	}
+
+	if abortRequested {
+		return flushAbortRollback
+	}
+
+	maxMemory := atomic.LoadInt64(&m.maxMemory)
+	if maxMemory > 0 && atomic.LoadInt64(&m.memUsed) >= maxMemory {
+		return flushAbortMemory
+	}
+	return flushAbortOther
+}
+
+// flushAbortEvent is an aborted flush.
+type flushAbortEvent struct {
+	Time       time.Time `json:"time"`
+	Stream     string    `json:"stream"`
+	KeyspaceId string    `json:"keyspaceId"`
+	Cause      string    `json:"cause"`
+	Seqnos     uint64    `json:"seqnos"` // sum of the seqnos of the flush timestamp
+}
+
+type flushAbortLog struct {
+	mu     sync.Mutex
+	ring   [maxFlushAbortEvents]flushAbortEvent
+	count  int // events recorded
+	counts map[string]int64
+}
+
+func newFlushAbortLog() *flushAbortLog {
+	return &flushAbortLog{counts: make(map[string]int64)}
+}
+
+func (l *flushAbortLog) record(ev flushAbortEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ring[l.count%maxFlushAbortEvents] = ev
+	l.count++
+	l.counts[ev.Cause]++
+}
+
+// list returns the recorded events of streamId and keyspaceId, oldest
+// first. An empty streamId or keyspaceId matches all of them.
+func (l *flushAbortLog) list(streamId, keyspaceId string) []flushAbortEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.count
+	if n > maxFlushAbortEvents {
+		n = maxFlushAbortEvents
+	}
+	events := make([]flushAbortEvent, 0, n)
+	for i := l.count - n; i < l.count; i++ {
+		ev := l.ring[i%maxFlushAbortEvents]
+		if (streamId == "" || ev.Stream == streamId) &&
+			(keyspaceId == "" || ev.KeyspaceId == keyspaceId) {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// causeCounts returns the number of aborts by cause since the start.
+func (l *flushAbortLog) causeCounts() map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[string]int64, len(l.counts))
+	for cause, n := range l.counts {
+		counts[cause] = n
+	}
+	return counts
+}
+
+// recordFlushAbort records the aborted flush of msg.
+func (idx *indexer) recordFlushAbort(msg *MsgMutMgrFlushDone) {
+
+	streamId := msg.GetStreamId()
+	keyspaceId := msg.GetKeyspaceId()
+	cause := msg.GetAbortCause()
+
+	ev := flushAbortEvent{
+		Time:       time.Now(),
+		Stream:     streamId.String(),
+		KeyspaceId: keyspaceId,
+		Cause:      cause,
+	}
+	if ts := msg.GetTS(); ts != nil {
+		for _, seqno := range ts.Seqnos {
+			ev.Seqnos += seqno
+		}
+	}
+	idx.flushAborts.record(ev)
+
+	if keyspaceStats := idx.stats.GetKeyspaceStats(streamId, keyspaceId); keyspaceStats != nil {
+		keyspaceStats.numFlushAborts.Add(1)
+		switch cause {
+		case flushAbortMemory:
+			keyspaceStats.numFlushAbortsMem.Add(1)
+		case flushAbortRollback:
+			keyspaceStats.numFlushAbortsRbk.Add(1)
+		case flushAbortShutdown:
+			keyspaceStats.numFlushAbortsStop.Add(1)
+		}
+	}
+
+	logging.Warnf("Indexer::recordFlushAbort %v %v Flush aborted, cause %v. "+
+		"No snapshot is taken for it.", streamId, keyspaceId, cause)
+}
+
+func (idx *indexer) handleFlushAbortsReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.admin.internal.index!read"}, r, w,
+		"Indexer::handleFlushAbortsReq") {
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	resp := struct {
+		Counts map[string]int64  `json:"counts"`
+		Events []flushAbortEvent `json:"events"`
+	}{
+		Counts: idx.flushAborts.causeCounts(),
+		Events: idx.flushAborts.list(r.URL.Query().Get("stream"), r.URL.Query().Get("keyspace")),
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,66 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFlushAbortCause(t *testing.T) {
+	m := &mutationMgr{shutdownCh: make(DoneChannel), maxMemory: 100}
+
+	if cause := m.flushAbortCause(false); cause != flushAbortOther {
+		t.Fatalf("expected cause %v, got %v", flushAbortOther, cause)
+	}
+
+	m.memUsed = 100
+	if cause := m.flushAbortCause(false); cause != flushAbortMemory {
+		t.Fatalf("expected cause %v, got %v", flushAbortMemory, cause)
+	}
+	if cause := m.flushAbortCause(true); cause != flushAbortRollback {
+		t.Fatalf("expected cause %v, got %v", flushAbortRollback, cause)
+	}
+
+	close(m.shutdownCh)
+	if cause := m.flushAbortCause(true); cause != flushAbortShutdown {
+		t.Fatalf("expected cause %v, got %v", flushAbortShutdown, cause)
+	}
+}
+
+func TestFlushAbortLog(t *testing.T) {
+	l := newFlushAbortLog()
+
+	now := time.Now()
+	for i := 0; i < maxFlushAbortEvents+10; i++ {
+		l.record(flushAbortEvent{
+			Time:       now.Add(time.Duration(i) * time.Second),
+			Stream:     "MAINT_STREAM",
+			KeyspaceId: fmt.Sprintf("b%v", i%2),
+			Cause:      flushAbortRollback,
+			Seqnos:     uint64(i),
+		})
+	}
+
+	events := l.list("", "")
+	if len(events) != maxFlushAbortEvents || events[0].Seqnos != 10 {
+		t.Fatalf("expected the last %v events oldest first, got %v from %v",
+			maxFlushAbortEvents, len(events), events[0].Seqnos)
+	}
+	if events := l.list("MAINT_STREAM", "b1"); len(events) != maxFlushAbortEvents/2 {
+		t.Fatalf("expected the events of b1, got %v", len(events))
+	}
+	if events := l.list("INIT_STREAM", ""); len(events) != 0 {
+		t.Fatalf("expected no events of INIT_STREAM, got %v", len(events))
+	}
+	if n := l.causeCounts()[flushAbortRollback]; n != maxFlushAbortEvents+10 {
+		t.Fatalf("expected all aborts counted, got %v", n)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// With timekeeper.maxConcurrentFlush set, at most that many keyspaces of
+// active streams flush at a time. A keyspace whose stability TS is due while
+// all flush slots are taken waits with its TS in the pending list, and free
+// slots go to the waiting keyspace that is falling furthest behind, i.e.
+// with the largest difference between the mutation rate and drain rate of
+// its indexes. This keeps the wait of session consistent scans bounded for
+// busy keyspaces, instead of each keyspace getting its turn regardless of
+// its backlog. A keyspace that has been waiting for longer than
+// flushStarvationTime goes first, so that idle keyspaces are not starved.
+
+const flushStarvationTime = 10 * time.Second
+
+// flushScheduler orders the keyspaces waiting for a flush slot. It is not
+// safe for concurrent use, timekeeper accesses it with tk.lock held.
+type flushScheduler struct {
+	lag       map[common.StreamId]map[string]int64 // mutations per second
+	waitSince map[common.StreamId]map[string]time.Time
+
+	// keyspace handed a free slot, while it is dispatched
+	grantStream   common.StreamId
+	grantKeyspace string
+	granted       bool
+}
+
+func newFlushScheduler() *flushScheduler {
+	return &flushScheduler{
+		lag:       make(map[common.StreamId]map[string]int64),
+		waitSince: make(map[common.StreamId]map[string]time.Time),
+	}
+}
+
+// setLag replaces the lag of all keyspaces.
+func (fs *flushScheduler) setLag(lag map[common.StreamId]map[string]int64) {
+	fs.lag = lag
+}
+
+// wait adds streamId/keyspaceId to the waiting keyspaces, if not already
+// waiting.
+func (fs *flushScheduler) wait(streamId common.StreamId, keyspaceId string, now time.Time) {
+	if _, ok := fs.waitSince[streamId]; !ok {
+		fs.waitSince[streamId] = make(map[string]time.Time)
+	}
+	if _, ok := fs.waitSince[streamId][keyspaceId]; !ok {
+		fs.waitSince[streamId][keyspaceId] = now
+	}
+}
+
+// done removes streamId/keyspaceId from the waiting keyspaces.
+func (fs *flushScheduler) done(streamId common.StreamId, keyspaceId string) {
+	delete(fs.waitSince[streamId], keyspaceId)
+}
+
+// grant hands the next flush slot to streamId/keyspaceId, removing it from
+// the waiting keyspaces.
+func (fs *flushScheduler) grant(streamId common.StreamId, keyspaceId string) {
+	fs.done(streamId, keyspaceId)
+	fs.grantStream, fs.grantKeyspace, fs.granted = streamId, keyspaceId, true
+}
+
+// takeGrant returns true if the slot was granted to streamId/keyspaceId,
+// and clears the grant.
+func (fs *flushScheduler) takeGrant(streamId common.StreamId, keyspaceId string) bool {
+	if !fs.granted || fs.grantStream != streamId || fs.grantKeyspace != keyspaceId {
+		return false
+	}
+	fs.granted = false
+	return true
+}
+
+func (fs *flushScheduler) numWaiting() int {
+	n := 0
+	for _, keyspaceIds := range fs.waitSince {
+		n += len(keyspaceIds)
+	}
+	return n
+}
+
+// next returns the waiting keyspace to be given the next flush slot.
+func (fs *flushScheduler) next(now time.Time) (common.StreamId, string, bool) {
+
+	var nextStream common.StreamId
+	var nextKeyspace string
+	var nextSince time.Time
+	var nextLag int64
+	var nextStarved, found bool
+
+	for streamId, keyspaceIds := range fs.waitSince {
+		for keyspaceId, since := range keyspaceIds {
+			lag := fs.lag[streamId][keyspaceId]
+			starved := now.Sub(since) > flushStarvationTime
+
+			better := false
+			switch {
+			case !found:
+				better = true
+			case starved != nextStarved:
+				better = starved
+			case starved:
+				better = since.Before(nextSince)
+			case lag != nextLag:
+				better = lag > nextLag
+			default:
+				better = since.Before(nextSince)
+			}
+
+			if better {
+				nextStream, nextKeyspace = streamId, keyspaceId
+				nextSince, nextLag, nextStarved = since, lag, starved
+				found = true
+			}
+		}
+	}
+	return nextStream, nextKeyspace, found
+}
+
+// computeFlushLag returns, for each stream and keyspace, the rate at which
+// mutations are received in excess of the rate at which they are flushed,
+// summed over its indexes.
+func computeFlushLag(indexInstMap common.IndexInstMap,
+	stats *IndexerStats) map[common.StreamId]map[string]int64 {
+
+	lag := make(map[common.StreamId]map[string]int64)
+	if stats == nil {
+		return lag
+	}
+
+	for instId, inst := range indexInstMap {
+		if inst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		idxStats := stats.indexes[instId]
+		if idxStats == nil {
+			continue
+		}
+
+		mutationRate := idxStats.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.avgMutationRate.Value()
+		})
+		drainRate := idxStats.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.avgDrainRate.Value()
+		})
+
+		keyspaceId := inst.Defn.KeyspaceId(inst.Stream)
+		if _, ok := lag[inst.Stream]; !ok {
+			lag[inst.Stream] = make(map[string]int64)
+		}
+		lag[inst.Stream][keyspaceId] += mutationRate - drainRate
+	}
+	return lag
+}
+
+// flushSlotAvailable returns true if streamId/keyspaceId can flush now.
+// Otherwise, it is added to the keyspaces waiting for a flush slot.
+// Caller of this method holds tk.lock write locked.
+func (tk *timekeeper) flushSlotAvailable(streamId common.StreamId, keyspaceId string) bool {
+
+	maxFlush := tk.config["timekeeper.maxConcurrentFlush"].Int()
+	if maxFlush <= 0 || tk.ss.streamKeyspaceIdStatus[streamId][keyspaceId] != STREAM_ACTIVE {
+		return true
+	}
+
+	if tk.flushSched.takeGrant(streamId, keyspaceId) {
+		return true
+	}
+
+	now := time.Now()
+	if tk.ss.numFlushInProgress() < maxFlush && tk.flushSched.numWaiting() == 0 {
+		return true
+	}
+
+	// Free slots go to the waiting keyspaces falling furthest behind. Those
+	// other than the caller are dispatched now, rather than when the next
+	// flush is done.
+	tk.flushSched.wait(streamId, keyspaceId, now)
+	for tk.ss.numFlushInProgress() < maxFlush {
+		s, k, ok := tk.flushSched.next(now)
+		if !ok {
+			break
+		}
+		if s == streamId && k == keyspaceId {
+			tk.flushSched.done(streamId, keyspaceId)
+			return true
+		}
+		tk.dispatchFlush(s, k)
+	}
+	return false
+}
+
+// dispatchPendingFlush hands out free flush slots to the waiting keyspaces.
+// Caller of this method holds tk.lock write locked.
+func (tk *timekeeper) dispatchPendingFlush() {
+
+	maxFlush := tk.config["timekeeper.maxConcurrentFlush"].Int()
+	if maxFlush <= 0 {
+		return
+	}
+
+	for tk.ss.numFlushInProgress() < maxFlush {
+		streamId, keyspaceId, ok := tk.flushSched.next(time.Now())
+		if !ok {
+			return
+		}
+		tk.dispatchFlush(streamId, keyspaceId)
+	}
+}
+
+// dispatchFlush hands a free flush slot to the waiting streamId/keyspaceId
+// and sends its pending TS. If it has nothing to flush, or flush is not
+// possible for now, it stops waiting.
+// Caller of this method holds tk.lock write locked.
+func (tk *timekeeper) dispatchFlush(streamId common.StreamId, keyspaceId string) {
+	tk.flushSched.grant(streamId, keyspaceId)
+	tk.processPendingTS(streamId, keyspaceId)
+	tk.flushSched.takeGrant(streamId, keyspaceId)
+}
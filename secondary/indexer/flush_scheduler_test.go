@@ -0,0 +1,116 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestFlushSchedulerNext(t *testing.T) {
+	fs := newFlushScheduler()
+	now := time.Now()
+
+	if _, _, ok := fs.next(now); ok {
+		t.Fatalf("expected no waiting keyspace")
+	}
+
+	fs.setLag(map[common.StreamId]map[string]int64{
+		common.MAINT_STREAM: {"b1": 100, "b2": 5000, "b3": -10},
+	})
+	fs.wait(common.MAINT_STREAM, "b1", now.Add(-3*time.Second))
+	fs.wait(common.MAINT_STREAM, "b2", now)
+	fs.wait(common.MAINT_STREAM, "b3", now.Add(-2*time.Second))
+
+	// waiting again does not reset the wait time
+	fs.wait(common.MAINT_STREAM, "b1", now)
+
+	expected := []string{"b2", "b1", "b3"}
+	for _, keyspaceId := range expected {
+		streamId, next, ok := fs.next(now)
+		if !ok || streamId != common.MAINT_STREAM || next != keyspaceId {
+			t.Fatalf("expected %v, got %v %v %v", keyspaceId, streamId, next, ok)
+		}
+		fs.done(streamId, next)
+	}
+	if fs.numWaiting() != 0 {
+		t.Fatalf("expected no waiting keyspace, got %v", fs.numWaiting())
+	}
+
+	// starved keyspace goes ahead of the ones falling behind
+	fs.wait(common.MAINT_STREAM, "b2", now)
+	fs.wait(common.INIT_STREAM, "b3", now.Add(-2*flushStarvationTime))
+	if streamId, next, _ := fs.next(now); streamId != common.INIT_STREAM || next != "b3" {
+		t.Fatalf("expected starved keyspace, got %v %v", streamId, next)
+	}
+}
+
+func TestFlushSlotToOtherWaiter(t *testing.T) {
+	cfg := common.Config{
+		"numVbuckets":                     common.ConfigValue{Value: 8},
+		"timekeeper.maxConcurrentFlush":   common.ConfigValue{Value: 1},
+		"timekeeper.monitor_flush":        common.ConfigValue{Value: false},
+		"settings.largeSnapshotThreshold": common.ConfigValue{Value: uint64(200)},
+	}
+	tk := &timekeeper{
+		ss:         InitStreamState(cfg),
+		config:     cfg,
+		flushSched: newFlushScheduler(),
+		supvRespch: make(MsgChannel, 10),
+	}
+	tk.stats.Set(NewIndexerStats())
+
+	tk.ss.initNewStream(common.MAINT_STREAM)
+	for _, keyspaceId := range []string{"b1", "b2", "b3"} {
+		tk.ss.initKeyspaceIdInStream(common.MAINT_STREAM, keyspaceId)
+		tk.ss.streamKeyspaceIdStatus[common.MAINT_STREAM][keyspaceId] = STREAM_ACTIVE
+	}
+
+	// b1 flushes, while b2, further behind than b3, waits with a pending TS
+	tk.ss.streamKeyspaceIdFlushInProgressTsMap[common.MAINT_STREAM]["b1"] =
+		common.NewTsVbuuid("b1", 8)
+	tk.flushSched.setLag(map[common.StreamId]map[string]int64{
+		common.MAINT_STREAM: {"b2": 5000, "b3": 10},
+	})
+	if tk.flushSlotAvailable(common.MAINT_STREAM, "b2") {
+		t.Fatalf("expected no flush slot while b1 flushes")
+	}
+	ts := common.NewTsVbuuid("b2", 8)
+	ts.SetSnapType(common.FORCE_COMMIT_MERGE)
+	tk.ss.streamKeyspaceIdTsListMap[common.MAINT_STREAM]["b2"].PushBack(&TsListElem{ts: ts})
+
+	// Once b1 is done, b3 asks for the slot, which goes to b2 and is
+	// dispatched right away instead of staying idle.
+	tk.ss.streamKeyspaceIdFlushInProgressTsMap[common.MAINT_STREAM]["b1"] = nil
+	if tk.flushSlotAvailable(common.MAINT_STREAM, "b3") {
+		t.Fatalf("expected the flush slot to go to b2")
+	}
+	if tk.ss.streamKeyspaceIdFlushInProgressTsMap[common.MAINT_STREAM]["b2"] == nil {
+		t.Fatalf("expected flush of b2 dispatched")
+	}
+	select {
+	case msg := <-tk.supvRespch:
+		if msg.(*MsgTKStabilityTS).keyspaceId != "b2" {
+			t.Fatalf("expected stability TS of b2, got %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected stability TS of b2 sent")
+	}
+	if tk.flushSched.numWaiting() != 1 {
+		t.Fatalf("expected b3 waiting, got %v waiting", tk.flushSched.numWaiting())
+	}
+
+	// and b3 gets the slot once b2 is done
+	tk.ss.streamKeyspaceIdFlushInProgressTsMap[common.MAINT_STREAM]["b2"] = nil
+	if !tk.flushSlotAvailable(common.MAINT_STREAM, "b3") || tk.flushSched.numWaiting() != 0 {
+		t.Fatalf("expected the flush slot to go to b3")
+	}
+}
@@ -11,9 +11,12 @@ package indexer
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/logging/systemevent"
 )
 
 //Flusher is the only component which does read/dequeue from a MutationQueue.
@@ -73,6 +76,74 @@ type flusher struct {
 	indexPartnMap IndexPartnMap
 	config        common.Config
 	stats         *IndexerStats
+	purged        *purgedInstSet
+}
+
+//purgedInstSet is the set of index instances whose queued mutations
+//are to be discarded, even by a flush which started before the instances
+//were deleted (e.g. indexes of a dropped collection).
+type purgedInstSet struct {
+	count int64
+	insts sync.Map // IndexInstId -> bool
+
+	//held by the flusher while it writes the mutations of a vbucket, so
+	//that quiesce can wait for writes to purged instances to be done.
+	vbLocks [numPurgeVbLocks]purgeVbLock
+}
+
+const numPurgeVbLocks = 1024
+
+//purgeVbLock is padded to a cache line, vbuckets are flushed in parallel.
+type purgeVbLock struct {
+	sync.Mutex
+	_ [56]byte
+}
+
+func (p *purgedInstSet) lockVb(vbucket Vbucket) {
+	if p != nil {
+		p.vbLocks[int(vbucket)%numPurgeVbLocks].Lock()
+	}
+}
+
+func (p *purgedInstSet) unlockVb(vbucket Vbucket) {
+	if p != nil {
+		p.vbLocks[int(vbucket)%numPurgeVbLocks].Unlock()
+	}
+}
+
+//quiesce waits for the mutations being written by a flush in progress.
+//Once it returns, flushes skip the instances added before, and no longer
+//write to their slices.
+func (p *purgedInstSet) quiesce() {
+	for i := range p.vbLocks {
+		p.vbLocks[i].Lock()
+		p.vbLocks[i].Unlock()
+	}
+}
+
+func (p *purgedInstSet) add(instIds []common.IndexInstId) {
+	for _, instId := range instIds {
+		if _, loaded := p.insts.LoadOrStore(instId, true); !loaded {
+			atomic.AddInt64(&p.count, 1)
+		}
+	}
+}
+
+func (p *purgedInstSet) remove(instIds []common.IndexInstId) {
+	for _, instId := range instIds {
+		if _, ok := p.insts.Load(instId); ok {
+			p.insts.Delete(instId)
+			atomic.AddInt64(&p.count, -1)
+		}
+	}
+}
+
+func (p *purgedInstSet) contains(instId common.IndexInstId) bool {
+	if p == nil || atomic.LoadInt64(&p.count) == 0 {
+		return false
+	}
+	_, ok := p.insts.Load(instId)
+	return ok
 }
 
 //NewFlusher returns new instance of flusher
@@ -413,6 +484,9 @@ func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
 		return fmt.Sprintf("Flusher::flush Flushing Stream %v Mutations %v", streamId, logging.TagUD(mutk))
 	})
 
+	f.purged.lockVb(mutk.meta.vbucket)
+	defer f.purged.unlockVb(mutk.meta.vbucket)
+
 	processedUpserts := make(map[common.IndexInstId]bool)
 	for _, mut := range mutk.mut {
 
@@ -441,8 +515,9 @@ func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
 		}
 
 		//Skip mutations for indexes in DELETED state. This may happen if complete
-		//couldn't happen when processing drop index.
-		if idxInst.State == common.INDEX_STATE_DELETED {
+		//couldn't happen when processing drop index. Indexes purged after this
+		//flush started, are not DELETED in its copy of the map.
+		if idxInst.State == common.INDEX_STATE_DELETED || f.purged.contains(mut.uuid) {
 			logging.LazyTrace(func() string {
 				return fmt.Sprintf("Flusher::flush Found Mutation For IndexId: %v In "+
 					"DELETED State. Skipped Mutation Key %v", idxInst.InstId, logging.TagUD(mut.key))
@@ -514,6 +589,13 @@ func (f *flusher) processUpsert(mut *Mutation, docid []byte, meta *MutationMeta)
 				logging.Errorf("Flusher::processUpsert Error removing entry due to error %v Key: %s "+
 					"docid: %s in Slice: %v. Error: %v", err, logging.TagUD(mut.key), logging.TagStrUD(docid), slice.Id(), err2)
 			}
+		} else {
+			if f.config["settings.keyStats.enabled"].Bool() && !idxInst.Defn.IsArrayIndex {
+				f.sampleKeyStats(mut, partnId)
+			}
+			if f.config["settings.hotKeys.enabled"].Bool() {
+				f.trackHotKey(mut, docid, partnId, &idxInst)
+			}
 		}
 	} else {
 		logging.LazyDebug(func() string {
@@ -524,6 +606,42 @@ func (f *flusher) processUpsert(mut *Mutation, docid []byte, meta *MutationMeta)
 
 }
 
+func (f *flusher) sampleKeyStats(mut *Mutation, partnId common.PartitionId) {
+
+	ps := f.stats.GetPartitionStats(mut.uuid, partnId)
+	if ps == nil || ps.keyStats == nil {
+		return
+	}
+	if err := ps.keyStats.sample(mut.key, f.config["settings.keyStats.sampleRate"].Int()); err != nil {
+		logging.Debugf("Flusher::sampleKeyStats Error sampling Key: %s for IndexInstId: %v. Error: %v",
+			logging.TagUD(mut.key), mut.uuid, err)
+	}
+}
+
+func (f *flusher) trackHotKey(mut *Mutation, docid []byte, partnId common.PartitionId,
+	idxInst *common.IndexInst) {
+
+	ps := f.stats.GetPartitionStats(mut.uuid, partnId)
+	if ps == nil || ps.hotKeys == nil {
+		return
+	}
+
+	window := time.Duration(f.config["settings.hotKeys.window"].Int()) * time.Second
+	threshold := uint64(f.config["settings.hotKeys.threshold"].Int())
+	count, hot := ps.hotKeys.add(docid, time.Now().UnixNano(), window, threshold)
+	if !hot {
+		return
+	}
+
+	logging.Warnf("Flusher::trackHotKey DocId: %s updated %v times in %v for IndexInstId: %v "+
+		"PartitionId: %v", logging.TagStrUD(docid), count, window, mut.uuid, partnId)
+
+	ev := systemevent.NewHotKeyEvent("Flusher:trackHotKey", idxInst.Defn.DefnId,
+		idxInst.InstId, uint64(idxInst.ReplicaId), uint64(partnId),
+		fmt.Sprintf("%v", logging.TagStrUD(docid)), count, window.String())
+	systemevent.WarnEvent("Indexer", systemevent.EVENTID_INDEX_HOT_KEY, ev)
+}
+
 func (f *flusher) processDelete(mut *Mutation, docid []byte, meta *MutationMeta) {
 
 	var partnInstMap PartitionInstMap
@@ -613,6 +613,7 @@ func (fdb *fdbSlice) insertSecArrayIndex(key []byte, rawKey []byte, docid []byte
 		indexEntriesToBeDeleted = oldEntriesBytes
 	} else {
 		indexEntriesToBeAdded, indexEntriesToBeDeleted = CompareArrayEntriesWithCount(newEntriesBytes, oldEntriesBytes, newKeyCount, oldKeyCount)
+		fdb.idxStats.numArrEntriesKept.Add(int64(keptArrayEntries(indexEntriesToBeDeleted)))
 	}
 
 	nmut = 0
@@ -1657,6 +1658,10 @@ func tryDeleteFdbSlice(fdb *fdbSlice) {
 	logging.Infof("ForestDBSlice::Destroy Destroying Slice Id %v, IndexInstId %v, "+
 		"IndexDefnId %v", fdb.id, fdb.idxInstId, fdb.idxDefnId)
 
+	if trashDroppedSlice(fdb.idxInstId, common.NON_PARTITION_ID, fdb.path) {
+		return
+	}
+
 	if err := forestdb.Destroy(fdb.currfile, fdb.config); err != nil {
 		logging.Errorf("ForestDBSlice::Destroy Error Destroying  Slice Id %v, "+
 			"IndexInstId %v, IndexDefnId %v. Error %v", fdb.id, fdb.idxInstId, fdb.idxDefnId, err)
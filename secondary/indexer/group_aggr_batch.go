@@ -0,0 +1,224 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"time"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+// The N1QL expression group keys and aggregates of a scan are evaluated
+// over batches of up to scan.expr_eval_batch_size rows rather than row
+// by row. Each row of a batch has its own annotated value, kept across
+// batches, covering its index keys, and each expression is evaluated over
+// the whole batch with the context of the scan. The rows are then grouped
+// and projected in scan order, as with row by row evaluation. A row equal
+// to the previous one reuses its values, like the entry cache does.
+//
+// Scans which stop at the first valid aggregate evaluate row by row, as a
+// batch would evaluate the rows past it.
+
+// exprBatchRow is a row of an exprBatch. It holds copies of the keys of
+// the row, as the scan reuses its buffers for the next one.
+type exprBatchRow struct {
+	entry      []byte
+	ck         [][]byte
+	ckbuf      []byte
+	dk         value.Values
+	docid      []byte
+	count      int
+	cacheValid bool // same keys as the previous row
+}
+
+type exprBatch struct {
+	groupAggr *GroupAggr
+
+	rows []exprBatchRow
+	n    int
+
+	avs       []value.AnnotatedValue
+	groupVals []value.Values // by group key, then by row
+	aggrVals  []value.Values // by aggregate, then by row
+}
+
+// newExprBatch returns a batch of size rows for the expressions of
+// groupAggr, or nil if they are to be evaluated row by row.
+func newExprBatch(groupAggr *GroupAggr, size int) *exprBatch {
+
+	if groupAggr == nil || !groupAggr.HasExpr || groupAggr.FirstValidAggrOnly || size <= 1 {
+		return nil
+	}
+
+	b := &exprBatch{
+		groupAggr: groupAggr,
+		rows:      make([]exprBatchRow, size),
+		avs:       make([]value.AnnotatedValue, size),
+		groupVals: make([]value.Values, len(groupAggr.Group)),
+		aggrVals:  make([]value.Values, len(groupAggr.Aggrs)),
+	}
+
+	for i := range b.avs {
+		b.avs[i] = value.NewAnnotatedValue(value.NewScopeValue(make(map[string]interface{}), nil))
+	}
+	for i, gk := range groupAggr.Group {
+		if gk.KeyPos < 0 && gk.ExprValue == nil {
+			b.groupVals[i] = make(value.Values, size)
+		}
+	}
+	for i, ak := range groupAggr.Aggrs {
+		if ak.KeyPos < 0 && ak.ExprValue == nil {
+			b.aggrVals[i] = make(value.Values, size)
+		}
+	}
+	return b
+}
+
+// add adds a row to the batch. It returns true if the batch is full.
+func (b *exprBatch) add(entry []byte, compositekeys [][]byte, decodedkeys value.Values,
+	docid []byte, count int, cacheValid bool) bool {
+
+	row := &b.rows[b.n]
+	b.n++
+
+	row.entry = append(row.entry[:0], entry...)
+	row.docid = append(row.docid[:0], docid...)
+	row.count = count
+	row.cacheValid = cacheValid
+
+	size := 0
+	for _, k := range compositekeys {
+		size += len(k)
+	}
+	if size > cap(row.ckbuf) {
+		row.ckbuf = make([]byte, 0, size+1024)
+	}
+	if cap(row.ck) < len(compositekeys) {
+		row.ck = make([][]byte, len(compositekeys))
+	}
+	row.ck = row.ck[:len(compositekeys)]
+	tmpbuf := row.ckbuf[:0]
+	for i, k := range compositekeys {
+		tmpbuf = append(tmpbuf[:0], k...)
+		row.ck[i] = tmpbuf[:len(k)]
+		tmpbuf = tmpbuf[len(k):]
+	}
+
+	if cap(row.dk) < len(decodedkeys) {
+		row.dk = make(value.Values, len(decodedkeys))
+	}
+	row.dk = row.dk[:len(decodedkeys)]
+	for i, k := range decodedkeys {
+		row.dk[i] = nil
+		if k != nil {
+			row.dk[i] = k.Copy()
+		}
+	}
+
+	return b.n == len(b.rows)
+}
+
+func (b *exprBatch) reuseValues(i int) bool {
+	return i > 0 && b.rows[i].cacheValid && !b.groupAggr.DependsOnPrimaryKey
+}
+
+// evaluate evaluates the expressions over the rows of the batch.
+func (b *exprBatch) evaluate(p *ScanPipeline) error {
+
+	groupAggr := b.groupAggr
+
+	for i := 0; i < b.n; i++ {
+		if !b.reuseValues(i) {
+			setCoverForExprEval(b.avs[i], groupAggr, b.rows[i].dk, b.rows[i].docid)
+		}
+	}
+
+	t0 := time.Now()
+	num := int64(0)
+	evaluate := func(expr expression.Expression, vals value.Values) error {
+		for i := 0; i < b.n; i++ {
+			if b.reuseValues(i) {
+				vals[i] = vals[i-1]
+				continue
+			}
+			scalar, _, err := expr.EvaluateForIndex(b.avs[i], groupAggr.exprContext)
+			if err != nil {
+				return err
+			}
+			vals[i] = scalar
+			num++
+		}
+		return nil
+	}
+
+	for i, gk := range groupAggr.Group {
+		if b.groupVals[i] != nil {
+			if err := evaluate(gk.Expr, b.groupVals[i]); err != nil {
+				return err
+			}
+		}
+	}
+	for i, ak := range groupAggr.Aggrs {
+		if b.aggrVals[i] != nil {
+			if err := evaluate(ak.Expr, b.aggrVals[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.exprEvalDur += time.Since(t0)
+	p.exprEvalNum += num
+	return nil
+}
+
+// computeGroupAggr adds row i of the evaluated batch to aggrRes.
+func (b *exprBatch) computeGroupAggr(i int, aggrRes *aggrResult, p *ScanPipeline) error {
+
+	groupAggr := b.groupAggr
+	row := &b.rows[i]
+
+	for j, gk := range groupAggr.Group {
+		var exprVal value.Value
+		if b.groupVals[j] != nil {
+			exprVal = b.groupVals[j][i]
+		}
+		err := computeGroupKey(groupAggr, gk, row.ck, row.dk, row.docid, j, p, false, exprVal)
+		if err != nil {
+			return err
+		}
+	}
+
+	for j, ak := range groupAggr.Aggrs {
+		var exprVal value.Value
+		if b.aggrVals[j] != nil {
+			exprVal = b.aggrVals[j][i]
+		}
+		err := computeAggrVal(groupAggr, ak, row.ck, row.dk, row.docid, row.count, nil, j, p, false, exprVal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return aggrRes.AddNewGroup(groupAggr, p, row.cacheValid)
+}
+
+func (b *exprBatch) reset() {
+	for _, vals := range b.groupVals {
+		for i := range vals {
+			vals[i] = nil
+		}
+	}
+	for _, vals := range b.aggrVals {
+		for i := range vals {
+			vals[i] = nil
+		}
+	}
+	b.n = 0
+}
@@ -0,0 +1,172 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+type exprBatchTestRow struct {
+	ck         [][]byte
+	dk         value.Values
+	cacheValid bool
+}
+
+// newExprGroupAggr returns the GroupAggr of GROUP BY a * 10, SUM(a + b)
+func newExprGroupAggr(tb testing.TB) *GroupAggr {
+	compile := func(expr string) expression.Expression {
+		e, err := compileN1QLExpression(expr)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return e
+	}
+
+	ga := &GroupAggr{
+		Group: []*GroupKey{{EntryKeyId: 0, KeyPos: -1,
+			Expr: compile("(cover ((`default`.`a`)) * 10)")}},
+		Aggrs: []*Aggregate{{AggrFunc: common.AGG_SUM, EntryKeyId: 1, KeyPos: -1,
+			Expr: compile("(cover ((`default`.`a`)) + cover ((`default`.`b`)))")}},
+		DependsOnIndexKeys: []int32{0, 1},
+		IndexKeyNames:      []string{"(`default`.`a`)", "(`default`.`b`)"},
+		HasExpr:            true,
+		NeedDecode:         true,
+		NeedExplode:        true,
+	}
+	ga.cv = value.NewScopeValue(make(map[string]interface{}), nil)
+	ga.av = value.NewAnnotatedValue(ga.cv)
+	ga.exprContext = expression.NewIndexContext()
+	ga.groups = []*groupKey{new(groupKey)}
+	ga.aggrs = []*aggrVal{new(aggrVal)}
+	return ga
+}
+
+func exprBatchTestRows(n int) []exprBatchTestRow {
+	rows := make([]exprBatchTestRow, n)
+	for i := range rows {
+		a, b := i/4, i%3
+		rows[i].ck = [][]byte{[]byte(fmt.Sprint(a)), []byte(fmt.Sprint(b))}
+		rows[i].dk = value.Values{value.NewValue(a), value.NewValue(b)}
+		// every other row repeats the previous one
+		if i%2 == 1 {
+			rows[i] = rows[i-1]
+			rows[i].cacheValid = true
+		}
+	}
+	return rows
+}
+
+func groupAggrRows(res *aggrResult) []string {
+	var rows []string
+	for _, row := range res.rows {
+		rows = append(rows, fmt.Sprintf("%v %v", row.groups[0].obj, row.aggrs[0].fn.Value()))
+	}
+	return rows
+}
+
+func TestExprBatch(t *testing.T) {
+	rows := exprBatchTestRows(50)
+	p := &ScanPipeline{}
+
+	ga := newExprGroupAggr(t)
+	expected := &aggrResult{maxRows: len(rows)}
+	for _, row := range rows {
+		cachedEntry := entryCache{valid: row.cacheValid}
+		if err := computeGroupAggr(row.ck, row.dk, 1, nil, nil, nil, expected, ga,
+			nil, nil, &cachedEntry, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, size := range []int{2, 7, 64} {
+		ga := newExprGroupAggr(t)
+		b := newExprBatch(ga, size)
+		res := &aggrResult{maxRows: len(rows)}
+
+		write := func() {
+			defer b.reset()
+			if err := b.evaluate(p); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < b.n; i++ {
+				if err := b.computeGroupAggr(i, res, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		for _, row := range rows {
+			if b.add(nil, row.ck, row.dk, nil, 1, row.cacheValid) {
+				write()
+			}
+		}
+		write()
+
+		if e, g := fmt.Sprint(groupAggrRows(expected)), fmt.Sprint(groupAggrRows(res)); e != g {
+			t.Fatalf("batch size %v: expected groups %v, got %v", size, e, g)
+		}
+	}
+
+	ga.FirstValidAggrOnly = true
+	if newExprBatch(ga, 64) != nil {
+		t.Fatalf("expected row by row evaluation for the first valid aggregate only")
+	}
+}
+
+func BenchmarkGroupAggrExprEvalRow(b *testing.B) {
+	rows := exprBatchTestRows(1024)
+	p := &ScanPipeline{}
+	ga := newExprGroupAggr(b)
+	res := &aggrResult{maxRows: 1}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		row := &rows[n%len(rows)]
+		cachedEntry := entryCache{valid: row.cacheValid}
+		if err := computeGroupAggr(row.ck, row.dk, 1, nil, nil, nil, res, ga,
+			nil, nil, &cachedEntry, p); err != nil {
+			b.Fatal(err)
+		}
+		res.rows = res.rows[:0]
+	}
+}
+
+func BenchmarkGroupAggrExprEvalBatch(b *testing.B) {
+	rows := exprBatchTestRows(1024)
+	p := &ScanPipeline{}
+	ga := newExprGroupAggr(b)
+	res := &aggrResult{maxRows: 1}
+	batch := newExprBatch(ga, 64)
+
+	write := func() {
+		defer batch.reset()
+		if err := batch.evaluate(p); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < batch.n; i++ {
+			if err := batch.computeGroupAggr(i, res, p); err != nil {
+				b.Fatal(err)
+			}
+			res.rows = res.rows[:0]
+		}
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		row := &rows[n%len(rows)]
+		if batch.add(nil, row.ck, row.dk, nil, 1, row.cacheValid) {
+			write()
+		}
+	}
+	write()
+}
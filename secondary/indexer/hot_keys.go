@@ -0,0 +1,309 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/audit"
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Documents updated over and over amplify the writes to their indexes. With
+// settings.hotKeys.enabled, the flusher counts the updates of each docid per
+// index partition in a Space-Saving sketch, which keeps hotKeysCapacity
+// counters and replaces the least updated docid when a new one comes in.
+// Sketches are restarted every settings.hotKeys.window, keeping the counts of
+// the previous window, so /stats/hotKeys reports the most updated docids of
+// each index over the last one to two windows. A docid updated more than
+// settings.hotKeys.threshold times in a window is logged to the event log,
+// once per window.
+
+const (
+	hotKeysCapacity = 256
+
+	defaultHotKeysLimit = 10
+)
+
+// hotKeyCounter counts the updates of a docid. Counts of docids that
+// replaced another one are overestimated by at most err.
+type hotKeyCounter struct {
+	key      string
+	count    uint64
+	err      uint64
+	pos      int // in hotKeySketch.heap
+	reported bool
+}
+
+// hotKeyHeap is a min-heap of counters by count.
+type hotKeyHeap []*hotKeyCounter
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	c := x.(*hotKeyCounter)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// hotKeySketch is a Space-Saving sketch of the most updated docids.
+type hotKeySketch struct {
+	capacity int
+	counters map[string]*hotKeyCounter
+	heap     hotKeyHeap
+}
+
+func newHotKeySketch(capacity int) *hotKeySketch {
+	return &hotKeySketch{
+		capacity: capacity,
+		counters: make(map[string]*hotKeyCounter, capacity),
+	}
+}
+
+func (s *hotKeySketch) add(key []byte) *hotKeyCounter {
+	if c, ok := s.counters[string(key)]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.pos)
+		return c
+	}
+
+	if len(s.heap) < s.capacity {
+		c := &hotKeyCounter{key: string(key), count: 1}
+		s.counters[c.key] = c
+		heap.Push(&s.heap, c)
+		return c
+	}
+
+	// Replace the least updated docid
+	c := s.heap[0]
+	delete(s.counters, c.key)
+	c.key = string(key)
+	c.err = c.count
+	c.count++
+	c.reported = false
+	s.counters[c.key] = c
+	heap.Fix(&s.heap, 0)
+	return c
+}
+
+// indexHotKeys tracks the updates of the docids of an index partition,
+// shared by clones of its stats.
+type indexHotKeys struct {
+	mu       sync.Mutex
+	window   int64 // of current, as UnixNano / window width
+	current  *hotKeySketch
+	previous map[string]uint64
+}
+
+// add counts an update of docid. It returns the guaranteed count of the
+// docid in the current window, and whether it just exceeded threshold.
+func (hk *indexHotKeys) add(docid []byte, now int64, width time.Duration,
+	threshold uint64) (uint64, bool) {
+
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+
+	hk.rotate(now, width)
+
+	c := hk.current.add(docid)
+	count := c.count - c.err
+	if threshold > 0 && count > threshold && !c.reported {
+		c.reported = true
+		return count, true
+	}
+	return count, false
+}
+
+// rotate restarts the sketch when a new window begins.
+func (hk *indexHotKeys) rotate(now int64, width time.Duration) {
+	if width <= 0 {
+		width = time.Minute
+	}
+	w := now / int64(width)
+	if hk.current != nil && w == hk.window {
+		return
+	}
+
+	hk.previous = nil
+	if hk.current != nil && w == hk.window+1 {
+		hk.previous = hk.current.counts()
+	}
+	hk.current = newHotKeySketch(hotKeysCapacity)
+	hk.window = w
+}
+
+func (s *hotKeySketch) counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(s.counters))
+	for key, c := range s.counters {
+		counts[key] = c.count
+	}
+	return counts
+}
+
+// mergeInto adds the counts of the last two windows to counts.
+func (hk *indexHotKeys) mergeInto(counts map[string]uint64, now int64, width time.Duration) {
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+
+	hk.rotate(now, width)
+
+	for key, n := range hk.previous {
+		counts[key] += n
+	}
+	for key, c := range hk.current.counters {
+		counts[key] += c.count
+	}
+}
+
+// HotKey is the number of updates of a docid.
+type HotKey struct {
+	DocId   string `json:"docid"`
+	Updates uint64 `json:"updates"`
+}
+
+// IndexHotKeys is the report of the most updated docids of an index.
+type IndexHotKeys struct {
+	InstId     common.IndexInstId `json:"instId"`
+	Name       string             `json:"name"`
+	Bucket     string             `json:"bucket"`
+	Scope      string             `json:"scope"`
+	Collection string             `json:"collection"`
+	Keys       []HotKey           `json:"keys"`
+}
+
+// topHotKeys returns the limit docids with the most updates.
+func topHotKeys(counts map[string]uint64, limit int) []HotKey {
+	keys := make([]HotKey, 0, len(counts))
+	for key, n := range counts {
+		keys = append(keys, HotKey{DocId: key, Updates: n})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Updates != keys[j].Updates {
+			return keys[i].Updates > keys[j].Updates
+		}
+		return keys[i].DocId < keys[j].DocId
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// getHotKeys returns the most updated docids of the indexes accepted by
+// filter, merged over their partitions.
+func getHotKeys(stats *IndexerStats, filter func(*IndexStats) bool, now int64,
+	width time.Duration, limit int) []*IndexHotKeys {
+
+	result := make([]*IndexHotKeys, 0)
+	for instId, is := range stats.indexes {
+		if !filter(is) {
+			continue
+		}
+
+		counts := make(map[string]uint64)
+		for _, ps := range is.partitions {
+			if ps.hotKeys != nil {
+				ps.hotKeys.mergeInto(counts, now, width)
+			}
+		}
+		if len(counts) == 0 {
+			continue
+		}
+
+		result = append(result, &IndexHotKeys{
+			InstId:     instId,
+			Name:       is.dispName,
+			Bucket:     is.bucket,
+			Scope:      is.scope,
+			Collection: is.collection,
+			Keys:       topHotKeys(counts, limit),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].InstId < result[j].InstId
+	})
+	return result
+}
+
+// handleHotKeysReq returns the most updated docids of the indexes on this
+// node, optionally filtered by ?bucket=, ?scope=, ?collection= and ?index=.
+// ?limit= is the number of docids per index.
+func (s *statsManager) handleHotKeysReq(w http.ResponseWriter, r *http.Request) {
+	_, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		audit.Audit(common.AUDIT_UNAUTHORIZED, r, "StatsManager::handleHotKeysReq", "")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultHotKeysLimit
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid limit " + v + "\n"))
+			return
+		}
+	}
+
+	match := func(param, value string) bool {
+		v := query.Get(param)
+		return v == "" || v == value
+	}
+	filter := func(is *IndexStats) bool {
+		return match("bucket", is.bucket) && match("scope", is.scope) &&
+			match("collection", is.collection) && match("index", is.name)
+	}
+
+	config := s.config.Load()
+	width := time.Duration(config["settings.hotKeys.window"].Int()) * time.Second
+	data, err := json.Marshal(getHotKeys(s.stats.Get(), filter, time.Now().UnixNano(), width, limit))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,103 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestHotKeySketch(t *testing.T) {
+	s := newHotKeySketch(8)
+
+	// A few hot docids among many updated once
+	for i := 0; i < 200; i++ {
+		s.add([]byte("cold" + strconv.Itoa(i)))
+		if i%2 == 0 {
+			s.add([]byte("hot1"))
+		}
+		if i%4 == 0 {
+			s.add([]byte("hot2"))
+		}
+	}
+
+	if len(s.counters) != 8 || len(s.heap) != 8 {
+		t.Fatalf("Expected 8 counters, found %v %v", len(s.counters), len(s.heap))
+	}
+
+	top := topHotKeys(s.counts(), 2)
+	if len(top) != 2 || top[0].DocId != "hot1" || top[1].DocId != "hot2" {
+		t.Fatalf("Expected hot1 and hot2, found %v", top)
+	}
+	if c := s.counters["hot1"]; c.count-c.err > 100 || c.count < 100 {
+		t.Errorf("Expected about 100 updates of hot1, found %v err %v", c.count, c.err)
+	}
+}
+
+func TestIndexHotKeys(t *testing.T) {
+	var hk indexHotKeys
+	window := time.Minute
+	now := int64(10 * window)
+
+	for i := 1; i <= 5; i++ {
+		count, hot := hk.add([]byte("doc1"), now, window, 3)
+		if count != uint64(i) || hot != (i == 4) {
+			t.Errorf("Update %v: unexpected count %v hot %v", i, count, hot)
+		}
+	}
+
+	// Counts of the previous window are reported, and the threshold is
+	// checked again in the new window
+	now += int64(window)
+	if _, hot := hk.add([]byte("doc2"), now, window, 3); hot {
+		t.Errorf("Unexpected hot doc2")
+	}
+	counts := make(map[string]uint64)
+	hk.mergeInto(counts, now, window)
+	if counts["doc1"] != 5 || counts["doc2"] != 1 {
+		t.Errorf("Unexpected counts %v", counts)
+	}
+
+	// Older windows are dropped
+	counts = make(map[string]uint64)
+	hk.mergeInto(counts, now+2*int64(window), window)
+	if len(counts) != 0 {
+		t.Errorf("Expected no counts, found %v", counts)
+	}
+}
+
+func TestGetHotKeys(t *testing.T) {
+	is := &IndexStats{name: "idx1", dispName: "idx1", bucket: "b1",
+		partitions: make(map[common.PartitionId]*IndexStats)}
+	for pid := common.PartitionId(1); pid <= 2; pid++ {
+		is.partitions[pid] = &IndexStats{hotKeys: &indexHotKeys{}}
+	}
+	stats := &IndexerStats{indexes: map[common.IndexInstId]*IndexStats{1: is}}
+
+	window := time.Minute
+	now := time.Now().UnixNano()
+	is.partitions[1].hotKeys.add([]byte("doc1"), now, window, 0)
+	is.partitions[2].hotKeys.add([]byte("doc1"), now, window, 0)
+	is.partitions[2].hotKeys.add([]byte("doc2"), now, window, 0)
+
+	all := func(*IndexStats) bool { return true }
+	result := getHotKeys(stats, all, now, window, 1)
+	if len(result) != 1 || len(result[0].Keys) != 1 ||
+		result[0].Keys[0] != (HotKey{DocId: "doc1", Updates: 2}) {
+		t.Fatalf("Unexpected hot keys %+v", result)
+	}
+
+	none := func(*IndexStats) bool { return false }
+	if result := getHotKeys(stats, none, now, window, 1); len(result) != 0 {
+		t.Errorf("Expected no indexes, found %v", result)
+	}
+}
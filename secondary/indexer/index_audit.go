@@ -0,0 +1,562 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+// An index can be audited against its collection with /internal/indexAudit,
+// to find entries lost or left behind by indexing bugs. Documents are
+// sampled at random from KV, and entries at random from the index, and the
+// index expressions are evaluated on each sampled document to find the
+// entry it should have. After the sampled documents are read, the index is
+// checked at a snapshot as recent as KV, so that every document is compared
+// with an index that has seen it. Documents changed while the index is
+// checked are not reported.
+//
+// Array indexes and indexes on xattrs are not supported. For partitioned
+// indexes, documents missing from the partitions on this node are only
+// reported if all partitions are on this node.
+
+const (
+	defaultAuditSamples = 100
+	maxAuditSamples     = 10000
+
+	auditWaitTimeout = time.Minute
+)
+
+var ErrAuditNotSupported = errors.New("Audit is not supported for array indexes or indexes on xattrs")
+
+// IndexAuditReport is the result of an audit of an index instance.
+type IndexAuditReport struct {
+	DefnId     common.IndexDefnId `json:"defnId"`
+	InstId     common.IndexInstId `json:"instId"`
+	Name       string             `json:"name"`
+	Bucket     string             `json:"bucket"`
+	Scope      string             `json:"scope"`
+	Collection string             `json:"collection"`
+
+	NumDocsSampled    int `json:"numDocsSampled"`    // from KV
+	NumEntriesSampled int `json:"numEntriesSampled"` // from the index
+	NumChecked        int `json:"numChecked"`
+	NumChanged        int `json:"numChangedDuringAudit"`
+	NumUnverified     int `json:"numUnverified"` // may be in partitions on other nodes
+
+	Missing    []string `json:"missing"`    // documents with no entry
+	Extra      []string `json:"extra"`      // entries of no document, or duplicates
+	Mismatched []string `json:"mismatched"` // entries with a stale key
+
+	Duration int64 `json:"duration"` // ms
+}
+
+// auditDoc is a sampled document, as read from KV.
+type auditDoc struct {
+	data  []byte
+	cas   uint64
+	found bool
+}
+
+// indexAuditor evaluates the entries documents should have in an index,
+// and splits entries of the index to compare with.
+type indexAuditor struct {
+	defn    *common.IndexDefn
+	desc    []bool
+	skExprs []interface{}
+	whExpr  interface{}
+	context expression.Context
+
+	encodeBuf []byte
+	entryBuf  []byte
+}
+
+func newIndexAuditor(defn *common.IndexDefn) (*indexAuditor, error) {
+	if defn.IsArrayIndex {
+		return nil, ErrAuditNotSupported
+	}
+	for _, expr := range append([]string{defn.WhereExpr}, defn.SecExprs...) {
+		if strings.Contains(expr, "xattrs") {
+			return nil, ErrAuditNotSupported
+		}
+	}
+
+	a := &indexAuditor{
+		defn:      defn,
+		context:   expression.NewIndexContext(),
+		encodeBuf: make([]byte, 0, 1024),
+	}
+	if defn.HasDescending() {
+		a.desc = defn.Desc
+	}
+
+	if defn.IsPrimary {
+		return a, nil
+	}
+
+	var err error
+	if a.skExprs, err = protobuf.CompileN1QLExpression(defn.SecExprs); err != nil {
+		return nil, err
+	}
+	if defn.WhereExpr != "" {
+		exprs, err := protobuf.CompileN1QLExpression([]string{defn.WhereExpr})
+		if err != nil {
+			return nil, err
+		}
+		a.whExpr = exprs[0]
+	}
+	return a, nil
+}
+
+// expectedKey returns the encoded key of the entry doc should have, or nil
+// if it should not be indexed. Entries of primary indexes are the docid.
+func (a *indexAuditor) expectedKey(docid string, doc *auditDoc) ([]byte, error) {
+	if !doc.found {
+		return nil, nil
+	}
+	if a.defn.IsPrimary {
+		return []byte(docid), nil
+	}
+
+	docval := value.NewAnnotatedValue(value.NewParsedValueWithOptions(doc.data, true, true))
+	meta := docval.NewMeta()
+	meta["cas"] = doc.cas
+	docval.SetId(docid)
+
+	if a.whExpr != nil {
+		out, _, err := protobuf.N1QLTransform(nil, docval, a.context,
+			[]interface{}{a.whExpr}, 0, nil, nil)
+		if err != nil || string(out) != "true" {
+			return nil, err
+		}
+	}
+
+	key, newBuf, err := protobuf.N1QLTransform([]byte(docid), docval, a.context,
+		a.skExprs, 0, a.encodeBuf, nil)
+	if newBuf != nil {
+		a.encodeBuf = newBuf
+	}
+	return key, err
+}
+
+// splitEntry returns the encoded key and docid of entry, in storage format.
+// They are only valid until the next call.
+func (a *indexAuditor) splitEntry(entry []byte) ([]byte, []byte, error) {
+	if a.defn.IsPrimary {
+		return entry, entry, nil
+	}
+
+	// Copy, as storage may return the item itself
+	a.entryBuf = append(a.entryBuf[:0], entry...)
+	if a.desc != nil {
+		if _, err := jsonEncoder.ReverseCollate(a.entryBuf, a.desc); err != nil {
+			return nil, nil, err
+		}
+	}
+	return siSplitEntryCJson(a.entryBuf)
+}
+
+// docidReservoir samples docids uniformly from those added.
+type docidReservoir struct {
+	size   int
+	seen   int64
+	docids []string
+	rnd    *rand.Rand
+}
+
+func newDocidReservoir(size int) *docidReservoir {
+	return &docidReservoir{
+		size:   size,
+		docids: make([]string, 0, size),
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (r *docidReservoir) add(docid []byte) {
+	r.seen++
+	if len(r.docids) < r.size {
+		r.docids = append(r.docids, string(docid))
+	} else if i := r.rnd.Int63n(r.seen); i < int64(r.size) {
+		r.docids[i] = string(docid)
+	}
+}
+
+// auditResult is the outcome of the check of a sampled document.
+type auditResult int
+
+const (
+	auditOk auditResult = iota
+	auditMissing
+	auditExtra
+	auditMismatched
+	auditUnverified
+)
+
+// checkAuditDoc compares the expected key of a document with the keys of
+// its entries in the index. Without all partitions, a document with no
+// entry cannot be verified.
+func checkAuditDoc(expected []byte, keys [][]byte, allPartns bool) auditResult {
+	if expected == nil {
+		if len(keys) != 0 {
+			return auditExtra
+		}
+		return auditOk
+	}
+
+	if len(keys) == 0 {
+		if !allPartns {
+			return auditUnverified
+		}
+		return auditMissing
+	}
+	for _, key := range keys {
+		if bytes.Equal(key, expected) {
+			if len(keys) > 1 {
+				return auditExtra
+			}
+			return auditOk
+		}
+	}
+	return auditMismatched
+}
+
+// auditSnapshot calls reservoir, if not nil, with the docid of every entry
+// of is, and adds the keys of entries of docids to it.
+func (s *scanCoordinator) auditSnapshot(instId common.IndexInstId, is IndexSnapshot,
+	a *indexAuditor, docids map[string][][]byte, reservoir *docidReservoir,
+	donech chan bool) error {
+
+	callb := func(entry []byte) error {
+		key, docid, err := a.splitEntry(entry)
+		if err != nil {
+			return err
+		}
+		if reservoir != nil {
+			reservoir.add(docid)
+		}
+		if keys, ok := docids[string(docid)]; ok {
+			docids[string(docid)] = append(keys, append([]byte(nil), key...))
+		}
+		return nil
+	}
+
+	for partnId, pi := range is.Partitions() {
+		for _, ss := range pi.Slices() {
+			if err := s.iterateSlice(instId, partnId, ss, callb, donech); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// latestSnapshot returns a clone of the latest snapshot of instId, waiting
+// until deadline for one consistent with reqTs if not nil.
+func (s *scanCoordinator) latestSnapshot(instId common.IndexInstId,
+	reqTs *common.TsVbuuid, deadline time.Time, donech chan bool) (IndexSnapshot, error) {
+
+	for {
+		var is IndexSnapshot
+		if sc, ok := s.lastSnapshot.Get()[instId]; ok && sc != nil {
+			is = sc.cloneSnapshot()
+		}
+		if is != nil && (reqTs == nil || isSnapshotConsistent(is, common.SessionConsistency, reqTs)) {
+			return is, nil
+		}
+		if is != nil {
+			DestroyIndexSnapshot(is)
+		}
+
+		if reqTs == nil {
+			return nil, ErrSnapNotAvailable
+		}
+		if time.Now().After(deadline) {
+			return nil, common.ErrScanTimedOut
+		}
+		select {
+		case <-donech:
+			return nil, common.ErrClientCancel
+		case <-time.After(scanSessionRetryInterval):
+		}
+	}
+}
+
+// auditIndex audits inst with numSamples documents sampled from KV and as
+// many entries sampled from the index.
+func (s *scanCoordinator) auditIndex(inst *common.IndexInst, numSamples int,
+	donech chan bool) (*IndexAuditReport, error) {
+
+	t0 := time.Now()
+	defn := &inst.Defn
+
+	a, err := newIndexAuditor(defn)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := s.config.Load()
+	cluster := cfg["clusterAddr"].String()
+	b, err := common.ConnectBucket(cluster, common.DEFAULT_POOL, defn.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	report := &IndexAuditReport{
+		DefnId:     defn.DefnId,
+		InstId:     inst.InstId,
+		Name:       defn.Name,
+		Bucket:     defn.Bucket,
+		Scope:      defn.Scope,
+		Collection: defn.Collection,
+		Missing:    make([]string, 0),
+		Extra:      make([]string, 0),
+		Mismatched: make([]string, 0),
+	}
+
+	// Sample entries from the index
+	is, err := s.latestSnapshot(inst.InstId, nil, time.Time{}, donech)
+	if err != nil {
+		return nil, err
+	}
+	allPartns := !common.IsPartitioned(defn.PartitionScheme) ||
+		len(is.Partitions()) >= int(defn.NumPartitions)
+
+	reservoir := newDocidReservoir(numSamples)
+	err = s.auditSnapshot(inst.InstId, is, a, nil, reservoir, donech)
+	DestroyIndexSnapshot(is)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the sampled documents from KV
+	docs := make(map[string]*auditDoc)
+	for i := 0; i < 2*numSamples && report.NumDocsSampled < numSamples; i++ {
+		docid, data, cas, err := b.GetRandomDocC(defn.CollectionId)
+		if mcd.IsNotFound(err) {
+			break // no documents
+		} else if err != nil {
+			return nil, err
+		}
+		if _, ok := docs[docid]; !ok {
+			docs[docid] = &auditDoc{data: data, cas: cas, found: true}
+			report.NumDocsSampled++
+		}
+	}
+	for _, docid := range reservoir.docids {
+		if _, ok := docs[docid]; ok {
+			continue
+		}
+		doc, err := getAuditDoc(b, docid, defn.CollectionId)
+		if err != nil {
+			return nil, err
+		}
+		docs[docid] = doc
+		report.NumEntriesSampled++
+	}
+
+	// Check the index at a snapshot with all the documents as read
+	seqnos, err := bucketSeqsWithRetry(cfg["settings.scan_getseqnos_retries"].Int(),
+		s.logPrefix, cluster, defn.Bucket, getNumVBuckets(defn.Bucket, cfg), "", true)
+	if err != nil {
+		return nil, err
+	}
+	reqTs := &common.TsVbuuid{Bucket: defn.Bucket, Seqnos: seqnos}
+
+	is, err = s.latestSnapshot(inst.InstId, reqTs, time.Now().Add(auditWaitTimeout), donech)
+	if err != nil {
+		return nil, err
+	}
+
+	docids := make(map[string][][]byte, len(docs))
+	for docid := range docs {
+		docids[docid] = nil
+	}
+	err = s.auditSnapshot(inst.InstId, is, a, docids, nil, donech)
+	DestroyIndexSnapshot(is)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(docs))
+	for docid := range docs {
+		ids = append(ids, docid)
+	}
+	sort.Strings(ids)
+
+	for _, docid := range ids {
+		doc := docs[docid]
+		expected, err := a.expectedKey(docid, doc)
+		if err != nil {
+			return nil, err
+		}
+
+		result := checkAuditDoc(expected, docids[docid], allPartns)
+		if result == auditOk {
+			report.NumChecked++
+			continue
+		} else if result == auditUnverified {
+			report.NumUnverified++
+			continue
+		}
+
+		// Documents changed since they were read may be ahead of the index
+		current, err := getAuditDoc(b, docid, defn.CollectionId)
+		if err != nil {
+			return nil, err
+		}
+		if current.found != doc.found || current.cas != doc.cas {
+			report.NumChanged++
+			continue
+		}
+
+		report.NumChecked++
+		switch result {
+		case auditMissing:
+			report.Missing = append(report.Missing, docid)
+		case auditExtra:
+			report.Extra = append(report.Extra, docid)
+		case auditMismatched:
+			report.Mismatched = append(report.Mismatched, docid)
+		}
+	}
+
+	report.Duration = int64(time.Since(t0) / time.Millisecond)
+	return report, nil
+}
+
+func getAuditDoc(b *couchbase.Bucket, docid, cid string) (*auditDoc, error) {
+	data, _, cas, err := b.GetsRawC(docid, cid)
+	if mcd.IsNotFound(err) {
+		return &auditDoc{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &auditDoc{data: data, cas: cas, found: true}, nil
+}
+
+// handleIndexAuditReq audits the instances of index ?defnId= on this node,
+// with ?samples= documents and entries sampled.
+func (s *scanCoordinator) handleIndexAuditReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	q := r.URL.Query()
+	defnId, err := strconv.ParseUint(q.Get("defnId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid defnId " + q.Get("defnId") + "\n"))
+		return
+	}
+
+	numSamples := defaultAuditSamples
+	if v := q.Get("samples"); v != "" {
+		if numSamples, err = strconv.Atoi(v); err != nil || numSamples <= 0 ||
+			numSamples > maxAuditSamples {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid samples " + v + "\n"))
+			return
+		}
+	}
+
+	insts, err := s.findScannableInsts([]common.IndexDefnId{common.IndexDefnId(defnId)})
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	instIds := make([]common.IndexInstId, 0, len(insts))
+	for instId := range insts {
+		instIds = append(instIds, instId)
+	}
+	sort.Slice(instIds, func(i, j int) bool { return instIds[i] < instIds[j] })
+
+	defn := insts[instIds[0]].Defn
+	if !common.IsAllAllowed(creds, []string{"cluster.settings!write", common.GetScanPermission(&defn)},
+		r, w, "ScanCoordinator::handleIndexAuditReq") {
+		return
+	}
+
+	// Stop the audit if the client goes away
+	donech := make(chan bool)
+	finch := make(chan bool)
+	defer close(finch)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			close(donech)
+		case <-finch:
+		}
+	}()
+
+	reports := make([]*IndexAuditReport, 0, len(instIds))
+	for _, instId := range instIds {
+		inst := insts[instId]
+		report, err := s.auditIndex(&inst, numSamples, donech)
+		if err != nil {
+			scanLog.Errorf("%v Audit of index %v:%v failed: %v", s.logPrefix,
+				instId, logging.TagUD(inst.DisplayName()), err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+		logf := scanLog.Infof
+		if len(report.Missing) != 0 || len(report.Extra) != 0 || len(report.Mismatched) != 0 {
+			logf = scanLog.Warnf
+		}
+		logf("%v Audit of index %v:%v checked %v documents: %v missing, %v extra, "+
+			"%v mismatched, %v changed, %v unverified", s.logPrefix, instId,
+			logging.TagUD(inst.DisplayName()), report.NumChecked, len(report.Missing),
+			len(report.Extra), len(report.Mismatched), report.NumChanged, report.NumUnverified)
+
+		reports = append(reports, report)
+	}
+
+	data, err := json.Marshal(reports)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
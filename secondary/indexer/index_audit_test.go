@@ -0,0 +1,62 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckAuditDoc(t *testing.T) {
+	k1, k2 := []byte("k1"), []byte("k2")
+
+	for i, tc := range []struct {
+		expected  []byte
+		keys      [][]byte
+		allPartns bool
+		result    auditResult
+	}{
+		{nil, nil, true, auditOk},
+		{nil, [][]byte{k1}, true, auditExtra},
+		{k1, [][]byte{k1}, true, auditOk},
+		{k1, nil, true, auditMissing},
+		{k1, nil, false, auditUnverified},
+		{k1, [][]byte{k2}, true, auditMismatched},
+		{k1, [][]byte{k2, k1}, false, auditExtra},
+	} {
+		if r := checkAuditDoc(tc.expected, tc.keys, tc.allPartns); r != tc.result {
+			t.Fatalf("case %v: expected %v, got %v", i, tc.result, r)
+		}
+	}
+}
+
+func TestDocidReservoir(t *testing.T) {
+	r := newDocidReservoir(10)
+	for i := 0; i < 5; i++ {
+		r.add([]byte(fmt.Sprintf("doc-%v", i)))
+	}
+	if len(r.docids) != 5 {
+		t.Fatalf("expected all 5 docids sampled, got %v", r.docids)
+	}
+
+	for i := 5; i < 1000; i++ {
+		r.add([]byte(fmt.Sprintf("doc-%v", i)))
+	}
+	if len(r.docids) != 10 || r.seen != 1000 {
+		t.Fatalf("expected 10 of 1000 docids sampled, got %v of %v", len(r.docids), r.seen)
+	}
+
+	seen := make(map[string]bool)
+	for _, docid := range r.docids {
+		if seen[docid] {
+			t.Fatalf("docid %v sampled twice", docid)
+		}
+		seen[docid] = true
+	}
+}
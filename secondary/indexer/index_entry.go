@@ -35,7 +35,8 @@ type keySizeConfig struct {
 	maxSecKeyBufferLen int
 	maxIndexEntrySize  int
 
-	allowLargeKeys bool
+	allowLargeKeys    bool
+	overflowLargeKeys bool
 }
 
 func init() {
@@ -110,6 +111,7 @@ func (e *primaryIndexEntry) String() string {
 // Format:
 // [collate_json_encoded_sec_key][raw_docid_bytes][optional_count_2_bytes][len_of_docid_2_bytes]
 // The MSB of right byte of docid length indicates whether count is encoded or not
+// The next bit indicates an overflow entry (see overflow_keys.go)
 type secondaryIndexEntry []byte
 
 func NewSecondaryIndexEntry(key []byte, docid []byte, isArray bool, count int,
@@ -201,7 +203,7 @@ func (e *secondaryIndexEntry) lenDocId() int {
 	rbuf := []byte(*e)
 	offset := len(rbuf) - 2
 	l := binary.LittleEndian.Uint16(rbuf[offset : offset+2])
-	len := l & 0x7fff // Length & 0111111 11111111 (as MSB of length is used to indicate presence of count)
+	len := l & 0x3fff // Length & 0011111 11111111 (as the 2 MSBs of length indicate count and overflow)
 	return int(len)
 }
 
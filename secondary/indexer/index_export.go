@@ -0,0 +1,397 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// The entries of an index can be exported with /internal/indexExport, for
+// offline analysis or to verify the index against KV. The latest snapshot of
+// the index on this node is streamed, optionally gzipped, as JSON lines of
+// {"docid": ..., "key": [...]}, or as CSV with a column for the docid and
+// for each index key. As errors can only be known after the response has
+// started, the number of entries exported and any error are sent in the
+// X-Export-Count and X-Export-Error trailers.
+
+const (
+	exportFormatJSONL = "jsonl"
+	exportFormatCSV   = "csv"
+
+	exportBufferSize = 64 * 1024
+)
+
+// indexExporter writes the entries of an index.
+type indexExporter struct {
+	isPrimary bool
+	desc      []bool // set if the index has descending keys
+	w         io.Writer
+	csv       *csv.Writer
+	header    []string
+
+	limit int64 // 0 for no limit
+	count int64
+
+	buf    []byte
+	revbuf []byte
+	line   []byte
+	record []string
+	keys   []json.RawMessage
+}
+
+func newIndexExporter(defn *common.IndexDefn, format string, w io.Writer,
+	limit int64) (*indexExporter, error) {
+
+	e := &indexExporter{
+		isPrimary: defn.IsPrimary,
+		w:         w,
+		limit:     limit,
+	}
+	if defn.HasDescending() {
+		e.desc = defn.Desc
+	}
+
+	switch format {
+	case exportFormatJSONL:
+	case exportFormatCSV:
+		e.csv = csv.NewWriter(w)
+		e.header = []string{"docid"}
+		if !defn.IsPrimary {
+			e.header = append(e.header, defn.SecExprs...)
+		}
+	default:
+		return nil, errors.New("Unsupported export format " + format)
+	}
+	return e, nil
+}
+
+func (e *indexExporter) start() error {
+	if e.csv != nil {
+		return e.csv.Write(e.header)
+	}
+	return nil
+}
+
+// export writes entry, in storage format. It returns ErrLimitReached once
+// limit entries are exported.
+func (e *indexExporter) export(entry []byte) error {
+	if e.limit > 0 && e.count >= e.limit {
+		return ErrLimitReached
+	}
+
+	var key, docid []byte
+	if e.isPrimary {
+		docid = entry
+	} else {
+		if e.desc != nil {
+			// Copy, as storage may return the item itself
+			e.revbuf = append(e.revbuf[:0], entry...)
+			if _, err := jsonEncoder.ReverseCollate(e.revbuf, e.desc); err != nil {
+				return err
+			}
+			entry = e.revbuf
+		}
+
+		if len(entry)*3 > cap(e.buf) {
+			e.buf = make([]byte, 0, len(entry)*3)
+		}
+
+		var err error
+		if key, docid, _, err = siSplitEntry(entry, e.buf[:0]); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if e.csv != nil {
+		err = e.writeCSV(key, docid)
+	} else {
+		err = e.writeJSON(key, docid)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.count++
+	return nil
+}
+
+func (e *indexExporter) writeJSON(key, docid []byte) error {
+	id, err := json.Marshal(string(docid))
+	if err != nil {
+		return err
+	}
+
+	e.line = append(e.line[:0], `{"docid":`...)
+	e.line = append(e.line, id...)
+	if !e.isPrimary {
+		e.line = append(e.line, `,"key":`...)
+		e.line = append(e.line, key...)
+	}
+	e.line = append(e.line, "}\n"...)
+
+	_, err = e.w.Write(e.line)
+	return err
+}
+
+// writeCSV writes a record of the docid and each index key, as JSON except
+// for strings, which are unquoted.
+func (e *indexExporter) writeCSV(key, docid []byte) error {
+	e.record = append(e.record[:0], string(docid))
+
+	if !e.isPrimary {
+		if err := json.Unmarshal(key, &e.keys); err != nil {
+			return err
+		}
+		for _, k := range e.keys {
+			var s string
+			if len(k) > 0 && k[0] == '"' && json.Unmarshal(k, &s) == nil {
+				e.record = append(e.record, s)
+			} else {
+				e.record = append(e.record, string(k))
+			}
+		}
+	}
+
+	return e.csv.Write(e.record)
+}
+
+func (e *indexExporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// exportIndex writes the entries of the latest snapshots of insts with e,
+// until donech is closed.
+func (s *scanCoordinator) exportIndex(insts map[common.IndexInstId]common.IndexInst,
+	e *indexExporter, donech chan bool) error {
+
+	instIds := make([]common.IndexInstId, 0, len(insts))
+	for instId := range insts {
+		instIds = append(instIds, instId)
+	}
+	sort.Slice(instIds, func(i, j int) bool { return instIds[i] < instIds[j] })
+
+	lastSnapshot := s.lastSnapshot.Get()
+	for _, instId := range instIds {
+		sc, ok := lastSnapshot[instId]
+		if !ok || sc == nil {
+			return ErrSnapNotAvailable
+		}
+		is := sc.cloneSnapshot()
+		if is == nil {
+			return ErrSnapNotAvailable
+		}
+
+		err := func() error {
+			defer DestroyIndexSnapshot(is)
+
+			partns := is.Partitions()
+			partnIds := make([]common.PartitionId, 0, len(partns))
+			for partnId := range partns {
+				partnIds = append(partnIds, partnId)
+			}
+			sort.Slice(partnIds, func(i, j int) bool { return partnIds[i] < partnIds[j] })
+
+			for _, partnId := range partnIds {
+				for _, ss := range partns[partnId].Slices() {
+					if err := s.iterateSlice(instId, partnId, ss, e.export, donech); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterateSlice calls callb with each entry of slice snapshot ss of a
+// partition of instId, until donech is closed.
+func (s *scanCoordinator) iterateSlice(instId common.IndexInstId, partnId common.PartitionId,
+	ss SliceSnapshot, callb EntryCallback, donech chan bool) error {
+
+	ctx := func() IndexReaderContext {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		partition, ok := s.indexPartnMap[instId][partnId]
+		if !ok {
+			return nil
+		}
+		slice := partition.Sc.GetSliceById(ss.SliceId())
+		if slice == nil {
+			return nil
+		}
+		return slice.GetReaderContext()
+	}()
+	if ctx == nil {
+		return ErrNotMyPartition
+	}
+
+	if !ctx.Init(donech) {
+		return common.ErrClientCancel
+	}
+	defer ctx.Done()
+
+	return ss.Snapshot().All(ctx, callb)
+}
+
+// handleIndexExportReq streams the entries of index ?defnId= in ?format=
+// jsonl (default) or csv, gzipped with ?gzip=true. At most ?limit= entries
+// are exported if given.
+func (s *scanCoordinator) handleIndexExportReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	q := r.URL.Query()
+	defnId, err := strconv.ParseUint(q.Get("defnId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid defnId " + q.Get("defnId") + "\n"))
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = exportFormatJSONL
+	}
+	compress := q.Get("gzip") == "true"
+
+	var limit int64
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.ParseInt(v, 10, 64); err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid limit " + v + "\n"))
+			return
+		}
+	}
+
+	insts, err := s.findScannableInsts([]common.IndexDefnId{common.IndexDefnId(defnId)})
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	var defn common.IndexDefn
+	for _, inst := range insts {
+		defn = inst.Defn
+		break
+	}
+
+	if !common.IsAllAllowed(creds, []string{"cluster.settings!write", common.GetScanPermission(&defn)},
+		r, w, "ScanCoordinator::handleIndexExportReq") {
+		return
+	}
+
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	bw := bufio.NewWriterSize(out, exportBufferSize)
+
+	e, err := newIndexExporter(&defn, format, bw, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	filename := defn.Name + "." + format
+	contentType := "application/x-ndjson"
+	if format == exportFormatCSV {
+		contentType = "text/csv"
+	}
+	if compress {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Header().Set("Trailer", "X-Export-Count, X-Export-Error")
+	w.WriteHeader(http.StatusOK)
+
+	// Stop iterating if the client goes away
+	donech := make(chan bool)
+	finch := make(chan bool)
+	defer close(finch)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			close(donech)
+		case <-finch:
+		}
+	}()
+
+	t0 := time.Now()
+	err = e.start()
+	if err == nil {
+		err = s.exportIndex(insts, e, donech)
+		if err == ErrLimitReached {
+			err = nil
+		}
+	}
+	if ferr := e.flush(); err == nil {
+		err = ferr
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if gz != nil {
+		if ferr := gz.Close(); err == nil {
+			err = ferr
+		}
+	}
+
+	w.Header().Set("X-Export-Count", strconv.FormatInt(e.count, 10))
+	if err != nil {
+		w.Header().Set("X-Export-Error", err.Error())
+		scanLog.Errorf("%v Export of index %v:%v failed after %v entries: %v", s.logPrefix,
+			defnId, logging.TagUD(defn.Name), e.count, err)
+		return
+	}
+
+	scanLog.Infof("%v Exported %v entries of index %v:%v as %v in %v", s.logPrefix,
+		e.count, defnId, logging.TagUD(defn.Name), format, time.Since(t0))
+}
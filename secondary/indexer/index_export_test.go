@@ -0,0 +1,78 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func exportTestEntries(t *testing.T, e *indexExporter, keys, docids []string) {
+	if err := e.start(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, key := range keys {
+		entry, err := newSKEntry([]byte(key), []byte(docids[i]))
+		if err != nil {
+			t.Fatalf("unexpected error creating entry %v", err)
+		}
+		if err := e.export(entry); err != nil {
+			if err == ErrLimitReached {
+				break
+			}
+			t.Fatalf("unexpected error exporting entry %v", err)
+		}
+	}
+	if err := e.flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestIndexExportJSONL(t *testing.T) {
+	defn := &common.IndexDefn{SecExprs: []string{"`name`", "`city`"}}
+	keys := []string{`["alice","paris"]`, `["bob","rome"]`}
+	docids := []string{"user::1", "user::\"2\""}
+
+	var out bytes.Buffer
+	e, err := newIndexExporter(defn, exportFormatJSONL, &out, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	exportTestEntries(t, e, keys, docids)
+
+	expected := `{"docid":"user::1","key":["alice","paris"]}` + "\n" +
+		`{"docid":"user::\"2\"","key":["bob","rome"]}` + "\n"
+	if out.String() != expected || e.count != 2 {
+		t.Fatalf("expected %q, got %q (%v entries)", expected, out.String(), e.count)
+	}
+}
+
+func TestIndexExportCSV(t *testing.T) {
+	defn := &common.IndexDefn{SecExprs: []string{"`name`", "`city`"}}
+	keys := []string{`["alice","paris, france"]`, `["bob","rome"]`}
+	docids := []string{"user::1", "user::2"}
+
+	var out bytes.Buffer
+	e, err := newIndexExporter(defn, exportFormatCSV, &out, 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	exportTestEntries(t, e, keys, docids)
+
+	expected := "docid,`name`,`city`\n" + "user::1,alice,\"paris, france\"\n"
+	if out.String() != expected || e.count != 1 {
+		t.Fatalf("expected %q, got %q (%v entries)", expected, out.String(), e.count)
+	}
+
+	if _, err := newIndexExporter(defn, "xml", &out, 0); err == nil {
+		t.Fatalf("expected error for unsupported format")
+	}
+}
@@ -51,6 +51,9 @@ type indexSnapshot struct {
 	// For debugging, not supposed to go to production
 	snapId       int64
 	creationTime uint64
+
+	refs  *snapshotRefs // nil if not tracked
+	clone *cloneRecord  // origin of the clone, with debug.snapshotLeakCheck
 }
 
 func (is *indexSnapshot) IndexInstId() common.IndexInstId {
@@ -93,6 +96,10 @@ func (ps *partitionSnapshot) Slices() map[SliceId]SliceSnapshot {
 type sliceSnapshot struct {
 	id   SliceId
 	snap Snapshot
+
+	// Items count of the snapshot, materialized when it is created
+	itemsCount    uint64
+	hasItemsCount bool
 }
 
 func (ss *sliceSnapshot) SliceId() SliceId {
@@ -103,6 +110,15 @@ func (ss *sliceSnapshot) Snapshot() Snapshot {
 	return ss.snap
 }
 
+// Returns the materialized items count of a slice snapshot if it has one,
+// else counts the items of the storage snapshot.
+func countTotal(ss SliceSnapshot, ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
+	if s, ok := ss.(*sliceSnapshot); ok && s.hasItemsCount {
+		return s.itemsCount, nil
+	}
+	return ss.Snapshot().CountTotal(ctx, stopch)
+}
+
 func DestroyIndexSnapshot(is IndexSnapshot) error {
 	if is == nil {
 		return nil
@@ -112,6 +128,14 @@ func DestroyIndexSnapshot(is IndexSnapshot) error {
 			ss.Snapshot().Close()
 		}
 	}
+	if s, ok := is.(*indexSnapshot); ok {
+		if s.refs != nil {
+			s.refs.close()
+		}
+		if s.clone != nil {
+			snapshotClones.remove(s.clone)
+		}
+	}
 	return nil
 }
 
@@ -124,6 +148,17 @@ func CloneIndexSnapshot(is IndexSnapshot) IndexSnapshot {
 			ss.Snapshot().Open()
 		}
 	}
+	if s, ok := is.(*indexSnapshot); ok {
+		if s.refs != nil {
+			s.refs.open()
+		}
+		// Each clone is a distinct copy, destroyed with its record
+		if snapshotLeakCheckEnabled() {
+			clone := *s
+			clone.clone = snapshotClones.add(s, 1)
+			return &clone
+		}
+	}
 	return is
 }
 
@@ -0,0 +1,269 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/audit"
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Usage of an index is tracked as the scans and rows returned over the last
+// hour, by minute, and over the last week, by hour. Together with the last
+// scan time, which is persisted, it is reported by /stats/unusedIndexes for
+// operators to find indexes that are candidates for dropping.
+
+const (
+	usageMinuteBuckets = 60  // last hour
+	usageHourBuckets   = 168 // last week
+
+	defaultUnusedFor = 7 * 24 * time.Hour
+)
+
+// rollingCounter sums the values added over the last len(counts) periods.
+type rollingCounter struct {
+	width   int64   // period in nanoseconds
+	periods []int64 // period of each bucket, as UnixNano / width
+	counts  []int64
+}
+
+func newRollingCounter(width time.Duration, n int) rollingCounter {
+	return rollingCounter{
+		width:   int64(width),
+		periods: make([]int64, n),
+		counts:  make([]int64, n),
+	}
+}
+
+func (c *rollingCounter) add(now int64, v int64) {
+	p := now / c.width
+	i := int(p % int64(len(c.counts)))
+	if c.periods[i] != p {
+		c.periods[i] = p
+		c.counts[i] = 0
+	}
+	c.counts[i] += v
+}
+
+// sum returns the total over the last window, rounded up to whole periods.
+func (c *rollingCounter) sum(now int64, window time.Duration) int64 {
+	p := now / c.width
+	n := (int64(window) + c.width - 1) / c.width
+	if n > int64(len(c.counts)) {
+		n = int64(len(c.counts))
+	}
+
+	var total int64
+	for i, period := range c.periods {
+		if period > p-n && period <= p {
+			total += c.counts[i]
+		}
+	}
+	return total
+}
+
+// indexUsage tracks the scans of an index instance. It is shared by the
+// clones of its IndexStats.
+type indexUsage struct {
+	mu            sync.Mutex
+	scansByMinute rollingCounter
+	rowsByMinute  rollingCounter
+	scansByHour   rollingCounter
+	rowsByHour    rollingCounter
+}
+
+func (u *indexUsage) init() {
+	if u.scansByMinute.counts == nil {
+		u.scansByMinute = newRollingCounter(time.Minute, usageMinuteBuckets)
+		u.rowsByMinute = newRollingCounter(time.Minute, usageMinuteBuckets)
+		u.scansByHour = newRollingCounter(time.Hour, usageHourBuckets)
+		u.rowsByHour = newRollingCounter(time.Hour, usageHourBuckets)
+	}
+}
+
+func (u *indexUsage) addScan(now int64) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.init()
+	u.scansByMinute.add(now, 1)
+	u.scansByHour.add(now, 1)
+}
+
+func (u *indexUsage) addRows(now int64, rows int64) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.init()
+	u.rowsByMinute.add(now, rows)
+	u.rowsByHour.add(now, rows)
+}
+
+// get returns the scans and rows returned over the last hour, day and week.
+func (u *indexUsage) get(now int64) (scans, rows [3]int64) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.scansByMinute.counts == nil {
+		return
+	}
+
+	scans[0] = u.scansByMinute.sum(now, time.Hour)
+	scans[1] = u.scansByHour.sum(now, 24*time.Hour)
+	scans[2] = u.scansByHour.sum(now, 7*24*time.Hour)
+	rows[0] = u.rowsByMinute.sum(now, time.Hour)
+	rows[1] = u.rowsByHour.sum(now, 24*time.Hour)
+	rows[2] = u.rowsByHour.sum(now, 7*24*time.Hour)
+	return
+}
+
+// recordScan counts a scan of the index at consistency cons.
+func (s *IndexStats) recordScan(cons common.Consistency, now int64) {
+	switch cons {
+	case common.AnyConsistency:
+		s.numAnyConsScans.Add(1)
+	case common.SessionConsistency, common.SessionConsistencyStrict:
+		s.numSessionConsScans.Add(1)
+	case common.QueryConsistency:
+		s.numQueryConsScans.Add(1)
+	}
+	s.usage.addScan(now)
+}
+
+// IndexUsage is the usage of an index instance, reported by
+// /stats/unusedIndexes.
+type IndexUsage struct {
+	InstId     common.IndexInstId `json:"instId"`
+	Name       string             `json:"name"`
+	Bucket     string             `json:"bucket"`
+	Scope      string             `json:"scope"`
+	Collection string             `json:"collection"`
+
+	LastScanTime    int64 `json:"lastScanTime"` // UnixNano, 0 if never scanned
+	NumScans        int64 `json:"numScans"`
+	NumAnyConsScans int64 `json:"numAnyConsScans"`
+	NumSessionScans int64 `json:"numSessionConsScans"`
+	NumQueryScans   int64 `json:"numQueryConsScans"`
+
+	ScansLastHour int64 `json:"scansLastHour"`
+	ScansLastDay  int64 `json:"scansLastDay"`
+	ScansLastWeek int64 `json:"scansLastWeek"`
+	RowsLastHour  int64 `json:"rowsReturnedLastHour"`
+	RowsLastDay   int64 `json:"rowsReturnedLastDay"`
+	RowsLastWeek  int64 `json:"rowsReturnedLastWeek"`
+}
+
+// getUnusedIndexes returns the usage of active index instances not scanned
+// for unusedFor, least recently scanned first.
+func getUnusedIndexes(stats *IndexerStats, unusedFor time.Duration, now time.Time) []*IndexUsage {
+
+	unused := make([]*IndexUsage, 0)
+	if stats == nil {
+		return unused
+	}
+
+	for instId, ss := range stats.indexes {
+		if common.IndexState(ss.indexState.Value()) != common.INDEX_STATE_ACTIVE {
+			continue
+		}
+
+		lastScanTime := ss.lastScanTime.Value()
+		if lastScanTime != 0 && now.Sub(time.Unix(0, lastScanTime)) < unusedFor {
+			continue
+		}
+
+		scans, rows := ss.usage.get(now.UnixNano())
+		unused = append(unused, &IndexUsage{
+			InstId:          instId,
+			Name:            ss.dispName,
+			Bucket:          ss.bucket,
+			Scope:           ss.scope,
+			Collection:      ss.collection,
+			LastScanTime:    lastScanTime,
+			NumScans:        ss.numRequests.Value(),
+			NumAnyConsScans: ss.numAnyConsScans.Value(),
+			NumSessionScans: ss.numSessionConsScans.Value(),
+			NumQueryScans:   ss.numQueryConsScans.Value(),
+			ScansLastHour:   scans[0],
+			ScansLastDay:    scans[1],
+			ScansLastWeek:   scans[2],
+			RowsLastHour:    rows[0],
+			RowsLastDay:     rows[1],
+			RowsLastWeek:    rows[2],
+		})
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		if unused[i].LastScanTime != unused[j].LastScanTime {
+			return unused[i].LastScanTime < unused[j].LastScanTime
+		}
+		return unused[i].InstId < unused[j].InstId
+	})
+	return unused
+}
+
+// handleUnusedIndexesReq returns the active indexes not scanned for the
+// duration given by ?unusedFor=, a week by default. With ?unusedFor=0 the
+// usage of all active indexes is returned.
+func (s *statsManager) handleUnusedIndexesReq(w http.ResponseWriter, r *http.Request) {
+	_, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		audit.Audit(common.AUDIT_UNAUTHORIZED, r, "StatsManager::handleUnusedIndexesReq", "")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	unusedFor := defaultUnusedFor
+	if v := r.URL.Query().Get("unusedFor"); v != "" {
+		if unusedFor, err = time.ParseDuration(v); err != nil || unusedFor < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid unusedFor " + v + "\n"))
+			return
+		}
+	}
+
+	data, err := json.Marshal(getUnusedIndexes(s.stats.Get(), unusedFor, time.Now()))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,67 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingCounter(t *testing.T) {
+	c := newRollingCounter(time.Minute, 60)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	at := func(d time.Duration) int64 { return start + int64(d) }
+
+	for i := 0; i < 90; i++ {
+		c.add(at(time.Duration(i)*time.Minute), 1)
+	}
+
+	now := at(89 * time.Minute)
+	if got := c.sum(now, 10*time.Minute); got != 10 {
+		t.Errorf("expected 10 in the last 10 minutes, got %v", got)
+	}
+	// Older periods have been overwritten
+	if got := c.sum(now, 2*time.Hour); got != 60 {
+		t.Errorf("expected 60 in the last hour, got %v", got)
+	}
+
+	// Nothing added for more than an hour
+	if got := c.sum(at(200*time.Minute), time.Hour); got != 0 {
+		t.Errorf("expected 0, got %v", got)
+	}
+
+	c.add(at(200*time.Minute), 5)
+	if got := c.sum(at(200*time.Minute), time.Hour); got != 5 {
+		t.Errorf("expected 5, got %v", got)
+	}
+}
+
+func TestIndexUsage(t *testing.T) {
+	var u *indexUsage
+	u.addScan(0)
+	if scans, _ := u.get(0); scans[0] != 0 {
+		t.Errorf("expected no scans for nil usage")
+	}
+
+	u = &indexUsage{}
+	now := time.Now()
+	u.addScan(now.Add(-2 * time.Hour).UnixNano())
+	u.addRows(now.Add(-2*time.Hour).UnixNano(), 100)
+	u.addScan(now.Add(-3 * 24 * time.Hour).UnixNano())
+	u.addScan(now.UnixNano())
+	u.addRows(now.UnixNano(), 10)
+
+	scans, rows := u.get(now.UnixNano())
+	if scans != [3]int64{1, 2, 3} {
+		t.Errorf("expected scans [1 2 3], got %v", scans)
+	}
+	if rows != [3]int64{10, 110, 110} {
+		t.Errorf("expected rows [10 110 110], got %v", rows)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"runtime/pprof"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/indexing/secondary/audit"
@@ -93,8 +94,18 @@ type indexer struct {
 	id    string
 	state common.IndexerState
 
-	indexInstMap  common.IndexInstMap //map of indexInstId to IndexInst
-	indexPartnMap IndexPartnMap       //map of indexInstId to PartitionInst
+	diskFullPaused int32 // 1 if paused by monitorDiskUsage
+
+	indexInstMap   common.IndexInstMap //map of indexInstId to IndexInst
+	indexPartnMap  IndexPartnMap       //map of indexInstId to PartitionInst
+	instMapVersion uint64              //version of indexInstMap last distributed to workers
+
+	stateHistory *stateHistory // state transitions of the index instances
+	stateCause   string        // type of the message being processed, the cause of state transitions
+
+	trash *sliceTrash // data of the dropped index instances
+
+	flushAborts *flushAbortLog // the flushes of the mutation queues aborted
 
 	streamKeyspaceIdStatus map[common.StreamId]KeyspaceIdStatus
 
@@ -210,6 +221,9 @@ type indexer struct {
 	pendingReset map[common.IndexInstId]bool
 
 	streamKeyspaceIdPendCollectionDrop map[common.StreamId]map[string][]common.IndexInstId
+	//instances of a dropped collection whose data is cleaned up, pending
+	//their removal from stream once the flush in progress is done
+	pendCollectionDropInsts map[common.IndexInstId]common.IndexInst
 }
 
 type kvRequest struct {
@@ -279,6 +293,9 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 
 		indexInstMap:  make(common.IndexInstMap),
 		indexPartnMap: make(IndexPartnMap),
+		stateHistory:  newStateHistory(),
+		stateCause:    "bootstrap",
+		flushAborts:   newFlushAbortLog(),
 
 		merged: make(map[common.IndexInstId]common.IndexInst),
 		pruned: make(map[common.IndexInstId]common.IndexInst),
@@ -311,6 +328,7 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		pendingReset: make(map[common.IndexInstId]bool),
 
 		streamKeyspaceIdPendCollectionDrop: make(map[common.StreamId]map[string][]common.IndexInstId),
+		pendCollectionDropInsts:            make(map[common.IndexInstId]common.IndexInst),
 	}
 
 	logging.Infof("Indexer::NewIndexer Status Warmup")
@@ -331,6 +349,9 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 	port := idx.config["httpPort"].String()
 	httpAddr := net.JoinHostPort(host, port) // "127.0.0.1:<indexer_http_port"> (eg 9102, 9108, ...)
 
+	idx.trash = newSliceTrash(idx.config["storage_dir"].String(), httpAddr)
+	droppedSlices = idx.trash
+
 	// CPU throttling is disabled until CpuThrottle.SetCpuThrottling(true) is called
 	idx.cpuThrottle = NewCpuThrottle(idx.config["cpu.throttle.target"].Float64())
 	autofailoverMgr := NewAutofailoverServiceManager(httpAddr, idx.cpuThrottle)
@@ -408,15 +429,11 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 		snapshotNotifych[i] = make(chan IndexSnapshot, 5000)
 	}
 
-	snapReqWorkers := idx.getSnapshotReqWorkers()
-	snapshotReqCh := make([]MsgChannel, snapReqWorkers)
-	for i := 0; i < snapReqWorkers; i++ {
-		snapshotReqCh[i] = make(MsgChannel, 5000)
-	}
+	snapshotReqRouter := newSnapshotReqRouter(idx.getSnapshotReqWorkers())
 
 	//Start Scan Coordinator
 	idx.scanCoord, res = NewScanCoordinator(idx.scanCoordCmdCh, idx.wrkrRecvCh,
-		idx.config, snapshotNotifych, snapshotReqCh, idx.stats.Clone(), idx.cpuThrottle)
+		idx.config, snapshotNotifych, snapshotReqRouter, idx.stats.Clone(), idx.cpuThrottle)
 	if res.GetMsgType() != MSG_SUCCESS {
 		logging.Fatalf("Indexer::NewIndexer Scan Coordinator Init Error %+v", res)
 		return nil, res
@@ -468,7 +485,7 @@ func NewIndexer(config common.Config) (Indexer, Message) {
 	close(idx.enableSecurityChange)
 
 	//bootstrap phase 1
-	idx.bootstrap1(snapshotNotifych, snapshotReqCh)
+	idx.bootstrap1(snapshotNotifych, snapshotReqRouter)
 
 	//Start DDL Service Manager
 	//Initialize DDL Service Manager before rebalance manager so DDL service manager is ready
@@ -626,11 +643,13 @@ func (idx *indexer) handleSecurityChange(msg Message) {
 			exitFn(fmt.Sprintf("Fail to restart mutation mgr on security change. Error %v", err))
 		}
 
-		//restart scan coordinator
-		logging.Infof("handleSecurityChange: restarting scan coordinator")
-		if err := idx.sendMsgToWorker(msg, idx.scanCoordCmdCh); err != nil {
-			exitFn(fmt.Sprintf("Fail to restart scan coordinator on security change. Error %v", err))
-		}
+	}
+
+	// refresh scan coordinator, on certificate refresh as well, since the
+	// queryport drains connections secured with the old certificate
+	logging.Infof("handleSecurityChange: refreshing scan coordinator")
+	if err := idx.sendMsgToWorker(msg, idx.scanCoordCmdCh); err != nil {
+		exitFn(fmt.Sprintf("Fail to refresh scan coordinator on security change. Error %v", err))
 	}
 
 	// start HTTP server
@@ -667,7 +686,8 @@ func (idx *indexer) initFromConfig() {
 	// Read memquota setting
 	memQuota := int64(idx.config.GetIndexerMemoryQuota())
 	idx.stats.memoryQuota.Set(memQuota)
-	plasma.SetMemoryQuota(int64(float64(memQuota) * PLASMA_MEMQUOTA_FRAC))
+	plasma.SetMemoryQuota(plasmaMemoryQuota(memQuota))
+	idx.setResourceStats()
 	memdb.Debug(idx.config["settings.moi.debug"].Bool())
 	updateMOIWriters(idx.config["settings.moi.persistence_threads"].Int())
 	reclaimBlockSize := int64(idx.config["plasma.LSSReclaimBlockSize"].Int())
@@ -744,6 +764,11 @@ func (idx *indexer) initHTTPMux() {
 	idx.settingsMgr.RegisterRestEndpoints()
 	idx.statsMgr.RegisterRestEndpoints()
 	idx.clustMgrAgent.RegisterRestEndpoints()
+	idx.scanCoord.RegisterRestEndpoints()
+	httpMux.HandleFunc("/internal/indexStateHistory", idx.handleStateHistoryReq)
+	httpMux.HandleFunc("/internal/trash", idx.handleTrashReq)
+	httpMux.HandleFunc("/internal/trash/restore", idx.handleTrashRestoreReq)
+	httpMux.HandleFunc("/internal/flushAborts", idx.handleFlushAbortsReq)
 }
 
 func (idx *indexer) initPeriodicProfile() {
@@ -1198,6 +1223,8 @@ func (idx *indexer) listenWorkerMsgs() {
 // handleWorkerMsgs handles worker messages (wrkrPrioRecvCh, internalRecvCh).
 func (idx *indexer) handleWorkerMsgs(msg Message) {
 
+	idx.stateCause = msg.GetMsgType().String()
+
 	switch msg.GetMsgType() {
 
 	case STREAM_READER_HWT,
@@ -1221,6 +1248,9 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 		logging.Warnf("Indexer::handleWorkerMsgs Received Drop Data "+
 			"From Mutation Mgr %v. Ignored.", msg)
 
+	case STREAM_READER_EVAL_SKIP:
+		idx.handleEvalSkip(msg)
+
 	case TK_STABILITY_TIMESTAMP:
 		//send TS to Mutation Manager
 		ts := msg.(*MsgTKStabilityTS).GetTimestamp()
@@ -1265,6 +1295,10 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 
 	case MUT_MGR_FLUSH_DONE:
 
+		if flushDone := msg.(*MsgMutMgrFlushDone); flushDone.GetAborted() {
+			idx.recordFlushAbort(flushDone)
+		}
+
 		idx.storageMgrCmdCh <- msg
 		<-idx.storageMgrCmdCh
 
@@ -1305,6 +1339,9 @@ func (idx *indexer) handleWorkerMsgs(msg Message) {
 	case TK_INIT_BUILD_DONE:
 		idx.handleInitialBuildDone(msg)
 
+	case INDEXER_KV_INGEST_DONE:
+		idx.handleKVIngestDone(msg)
+
 	case TK_MERGE_STREAM:
 		idx.handleMergeStream(msg)
 
@@ -1536,6 +1573,14 @@ func (idx *indexer) updateStorageMode(newConfig common.Config) {
 	}
 }
 
+// setResourceStats sets the stats of the cgroup limits and the number of CPUs in use, which the
+// settings manager derives from them.
+func (idx *indexer) setResourceStats() {
+	idx.stats.cgroupMemoryLimit.Set(int64(idx.config["cgroup.memory_quota"].Uint64()))
+	idx.stats.cgroupCpuPercent.Set(int64(idx.config["cgroup.max_cpu_percent"].Int()))
+	idx.stats.numCPU.Set(int64(runtime.GOMAXPROCS(0)))
+}
+
 // handleConfigUpdate updates Indexer config settings and propagates them to children / workers.
 func (idx *indexer) handleConfigUpdate(msg Message) {
 
@@ -1546,12 +1591,14 @@ func (idx *indexer) handleConfigUpdate(msg Message) {
 
 	idx.updateStorageMode(newConfig)
 
-	if newConfig["settings.memory_quota"].Uint64() !=
-		oldConfig["settings.memory_quota"].Uint64() {
+	idx.setResourceStats()
+
+	// The memory quota changes with settings.memory_quota or with the cgroup memory limit
+	if newConfig.GetIndexerMemoryQuota() != oldConfig.GetIndexerMemoryQuota() {
 
 		memQuota := int64(idx.config.GetIndexerMemoryQuota())
 		idx.stats.memoryQuota.Set(memQuota)
-		plasma.SetMemoryQuota(int64(float64(memQuota) * PLASMA_MEMQUOTA_FRAC))
+		plasma.SetMemoryQuota(plasmaMemoryQuota(memQuota))
 
 		if common.GetStorageMode() == common.FORESTDB ||
 			common.GetStorageMode() == common.NOT_SET {
@@ -1651,8 +1698,13 @@ func (idx *indexer) handleConfigUpdate(msg Message) {
 	idx.sendMsgToClustMgr(msg)
 
 	idx.storageMgrCmdCh <- msg
-	<-idx.storageMgrCmdCh
-	idx.updateSliceWithConfig(newConfig)
+	if resp := <-idx.storageMgrCmdCh; resp.GetMsgType() == MSG_ERROR {
+		// The storage manager keeps its config, so do the slices
+		logging.Errorf("Indexer::handleConfigUpdate Storage manager rejected config update: %v",
+			resp.(*MsgError).GetError().cause)
+	} else {
+		idx.updateSliceWithConfig(newConfig)
+	}
 
 	newUseCInfoLite := newConfig["use_cinfo_lite"].Bool()
 	oldUseCInfoLite := oldConfig["use_cinfo_lite"].Bool()
@@ -1682,6 +1734,8 @@ func (idx *indexer) handleConfigUpdate(msg Message) {
 // handleAdminMsgs handles admin (DDL) messages (internalAdminRecvCh).
 func (idx *indexer) handleAdminMsgs(msg Message) (resp Message) {
 
+	idx.stateCause = msg.GetMsgType().String()
+
 	switch msg.GetMsgType() {
 
 	case CLUST_MGR_CREATE_INDEX_DDL,
@@ -1879,6 +1933,9 @@ func (idx *indexer) handleCreateIndex(msg Message) {
 		idx.stats.AddPartitionStats(indexInst, partnDefn.GetPartitionId())
 	}
 
+	//a recreated index gets its dropped data back from the trash
+	idx.trash.restoreSlices(&indexInst)
+
 	//allocate partition/slice
 	partnInstMap, _, err := idx.initPartnInstance(indexInst, clientCh, false)
 	if err != nil {
@@ -1898,8 +1955,7 @@ func (idx *indexer) handleCreateIndex(msg Message) {
 	idx.indexInstMap[indexInst.InstId] = indexInst
 	idx.indexPartnMap[indexInst.InstId] = partnInstMap
 
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(common.IndexInstList{indexInst})
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(common.IndexInstList{indexInst}, nil)
 
 	msgUpdateIndexPartnMap := &MsgUpdatePartnMap{indexPartnMap: idx.indexPartnMap}
 	msgUpdateIndexPartnMap.SetUpdatedPartnMap(partnInstMap)
@@ -2568,8 +2624,7 @@ func (idx *indexer) cleanupIndexAfterMerge(inst common.IndexInst, merged map[com
 	idx.stats.RemoveIndexStats(inst)
 
 	// Update index maps with this index
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendDeletedInstIds([]common.IndexInstId{inst.InstId})
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(nil, []common.IndexInstId{inst.InstId})
 
 	msgUpdateIndexPartnMap := &MsgUpdatePartnMap{indexPartnMap: idx.indexPartnMap}
 	msgUpdateIndexPartnMap.AppendDeletedInstIds([]common.IndexInstId{inst.InstId})
@@ -2833,8 +2888,7 @@ func (idx *indexer) prunePartition(bucket string, streamId common.StreamId, inst
 			}
 
 			// Update index maps with this index
-			msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-			msgUpdateIndexInstMap.AppendUpdatedInsts(common.IndexInstList{inst})
+			msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(common.IndexInstList{inst}, nil)
 			msgUpdateIndexPartnMap := &MsgUpdatePartnMap{indexPartnMap: idx.indexPartnMap}
 			msgUpdateIndexPartnMap.SetUpdatedPartnMap(idx.indexPartnMap[inst.InstId])
 
@@ -3030,7 +3084,7 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 		collectionId := inst.Defn.CollectionId
 
 		cluster := idx.config["clusterAddr"].String()
-		numVbuckets := idx.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), idx.config)
 
 		//all indexes get built using INIT_STREAM
 		var buildStream common.StreamId = common.INIT_STREAM
@@ -3082,9 +3136,7 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 		logging.Infof("Indexer::handleBuildIndex Added Index: %v to Stream: %v State: %v",
 			instIdList, buildStream, buildState)
 
-		msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-		updatedIndexes := idx.getInsts(instIdList)
-		msgUpdateIndexInstMap.AppendUpdatedInsts(updatedIndexes)
+		msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(idx.getInsts(instIdList), nil)
 
 		if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 			if clientCh != nil {
@@ -3097,11 +3149,29 @@ func (idx *indexer) handleBuildIndex(msg Message) {
 			common.CrashOnError(err)
 		}
 
-		//send Stream Update to workers
-		idx.sendStreamUpdateForBuildIndex(instIdList, buildStream, keyspaceId,
-			reqcid, clusterVer, buildTs, clientCh)
+		//an index restored from the trash is built from its snapshot
+		restartTs := idx.restoredBuildTs(instIdList)
+
+		//ingest the KV export of the keyspace if any, the stream is opened
+		//once done. Otherwise send Stream Update to workers
+		ingesting := false
+		if e := idx.findKVExport(keyspaceId, instIdList); e != nil && buildStream == common.INIT_STREAM &&
+			restartTs == nil {
+			if err := idx.startKVIngest(e, instIdList, buildStream, keyspaceId,
+				reqcid, clusterVer, buildTs); err != nil {
+				logging.Errorf("Indexer::handleBuildIndex %v %v Unable to ingest export. "+
+					"Building with DCP. Err %v", buildStream, keyspaceId, err)
+			} else {
+				ingesting = true
+			}
+		}
+
+		if !ingesting {
+			idx.sendStreamUpdateForBuildIndex(instIdList, buildStream, keyspaceId,
+				reqcid, clusterVer, buildTs, restartTs, clientCh)
 
-		idx.setStreamKeyspaceIdState(buildStream, keyspaceId, STREAM_ACTIVE)
+			idx.setStreamKeyspaceIdState(buildStream, keyspaceId, STREAM_ACTIVE)
+		}
 
 		//store updated state and streamId in meta store
 		if idx.enableManager {
@@ -3201,11 +3271,12 @@ func (idx *indexer) handleDropIndex(msg Message) (resp Message) {
 	//Second step, is the actual cleanup of index instance from internal maps
 	//and purging of physical slice files.
 
+	idx.trashOnDrop(&indexInst, msg.(*MsgDropIndex).GetRequestCtx())
+
 	indexInst.State = common.INDEX_STATE_DELETED
 	idx.indexInstMap[indexInst.InstId] = indexInst
 
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(common.IndexInstList{indexInst})
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(common.IndexInstList{indexInst}, nil)
 
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		clientCh <- &MsgError{
@@ -3553,6 +3624,36 @@ func (idx *indexer) handleStorageRollbackDone(msg Message) {
 
 }
 
+// handleEvalSkip stops the scans of an index instance whose mutations the
+// projector skipped evaluating, as its entries no longer match the
+// documents. The instance serves scans again once it is rebuilt, by a drop
+// and recreate or a reset on rollback.
+func (idx *indexer) handleEvalSkip(msg Message) {
+
+	instId := msg.(*MsgEvalSkip).GetInstId()
+	inst, ok := idx.indexInstMap[instId]
+	if !ok || inst.State == common.INDEX_STATE_DELETED ||
+		inst.Error == common.ErrIndexEvalSkipped.Error() {
+		return
+	}
+
+	logging.Errorf("Indexer::handleEvalSkip Index %v (%v:%v:%v:%v) missed mutation %v. "+
+		"Scans of the index are stopped until it is rebuilt.", instId, inst.Defn.Bucket,
+		inst.Defn.Scope, inst.Defn.Collection, inst.Defn.Name, msg.(*MsgEvalSkip).GetMutationMeta())
+
+	idx.updateError(instId, common.ErrIndexEvalSkipped.Error())
+
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg([]common.IndexInst{idx.indexInstMap[instId]}, nil)
+	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+		common.CrashOnError(err)
+	}
+
+	if err := idx.updateMetaInfoForIndexList([]common.IndexInstId{instId}, false, false, true,
+		false, false, false, false, false, nil); err != nil {
+		common.CrashOnError(err)
+	}
+}
+
 func (idx *indexer) resetIndexesOnRollback(streamId common.StreamId,
 	keyspaceId string, sessionId uint64) {
 
@@ -3672,8 +3773,7 @@ func (idx *indexer) doResetIndexesOnRollback(keyspaceId string,
 	}
 
 	//send updated maps to all workers
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(updatedInstances)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(updatedInstances, nil)
 
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		common.CrashOnError(err)
@@ -4148,7 +4248,7 @@ func (idx *indexer) initRecoveryForOSO(streamId common.StreamId,
 		"Initiate Recovery.", streamId, keyspaceId, sessionId)
 
 	//create zero ts for rollback to 0
-	numVbuckets := idx.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), idx.config)
 	restartTs := common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 
 	idx.handleInitPrepRecovery(&MsgRecovery{mType: INDEXER_INIT_PREP_RECOVERY,
@@ -4252,9 +4352,8 @@ func (idx *indexer) handleKeyspaceNotFound(msg Message) {
 	logging.Infof("Indexer::handleKeyspaceNotFound Updated Index State to DELETED %v",
 		deletedInstIds)
 
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
 	deletedInsts := idx.getInsts(deletedInstIds)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(deletedInsts)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(deletedInsts, nil)
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		common.CrashOnError(err)
 	}
@@ -4341,9 +4440,10 @@ func (idx *indexer) processCollectionDrop(streamId common.StreamId,
 	logging.Infof("Indexer::processCollectionDrop Updated Index State to DELETED %v",
 		instIdList)
 
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
+	idx.purgeIndexData(streamId, keyspaceId, instIdList)
+
 	updatedInstances := idx.getInsts(instIdList)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(updatedInstances)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(updatedInstances, nil)
 
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		common.CrashOnError(err)
@@ -4352,14 +4452,54 @@ func (idx *indexer) processCollectionDrop(streamId common.StreamId,
 	if !idx.streamKeyspaceIdFlushInProgress[streamId][keyspaceId] {
 		idx.cleanupIndexDataForCollectionDrop(streamId, keyspaceId, instIdList)
 	} else {
-		logging.Infof("Indexer::processCollectionDrop %v %v Add to pending list %v", streamId,
-			keyspaceId, instIdList)
+		//the flush in progress no longer writes to the purged instances,
+		//their slices are destroyed right away. Only their removal from
+		//stream waits for the flush to be done.
+		logging.Infof("Indexer::processCollectionDrop %v %v Cleanup index data, "+
+			"add to pending list %v", streamId, keyspaceId, instIdList)
+		deletedInsts := idx.getInsts(instIdList)
+		idx.cleanupIndexData(deletedInsts, nil)
+		for _, inst := range deletedInsts {
+			idx.pendCollectionDropInsts[inst.InstId] = inst
+		}
 		currList := idx.streamKeyspaceIdPendCollectionDrop[streamId][keyspaceId]
 		currList = append(currList, instIdList...)
 		idx.streamKeyspaceIdPendCollectionDrop[streamId][keyspaceId] = currList
 	}
 }
 
+// purgeIndexData releases the data of indexes in a dropped collection ahead
+// of the regular cleanup. Mutation manager discards their queued mutations,
+// and responds once a flush in progress no longer writes to them, so that
+// their slices can be destroyed without waiting for the flush. Storage
+// manager destroys their snapshots, so that the scan coordinator lets go of
+// them right away.
+func (idx *indexer) purgeIndexData(streamId common.StreamId,
+	keyspaceId string, instIdList []common.IndexInstId) {
+
+	logging.Infof("Indexer::purgeIndexData %v %v %v", streamId, keyspaceId, instIdList)
+
+	msg := &MsgPurgeIndexes{
+		mType:      MUT_MGR_PURGE_INDEXES,
+		streamId:   streamId,
+		keyspaceId: keyspaceId,
+		instIds:    instIdList,
+	}
+	if err := idx.sendMsgToWorker(msg, idx.mutMgrCmdCh); err != nil {
+		common.CrashOnError(err)
+	}
+
+	msg = &MsgPurgeIndexes{
+		mType:      STORAGE_PURGE_INDEX_SNAPSHOTS,
+		streamId:   streamId,
+		keyspaceId: keyspaceId,
+		instIds:    instIdList,
+	}
+	if err := idx.sendMsgToWorker(msg, idx.storageMgrCmdCh); err != nil {
+		common.CrashOnError(err)
+	}
+}
+
 // cleanupIndexDataForCollectionDrop deletes the metadata for built indexes in the dropped
 // collection, distributes updated maps to all the workers, and deletes the associated slices.
 // Caller must guarantee instIdList is non-empty. Similar to handleKeyspaceNotFound for buckets.
@@ -4368,9 +4508,24 @@ func (idx *indexer) cleanupIndexDataForCollectionDrop(streamId common.StreamId,
 
 	logging.Infof("Indexer::cleanupIndexDataForCollectionDrop %v %v", streamId, keyspaceId)
 
-	bucketUUID := idx.indexInstMap[deletedInstIds[0]].Defn.BucketUUID // to-be-deleted info needed below
-	deletedInsts := idx.getInsts(deletedInstIds)
-	idx.cleanupIndexData(deletedInsts, nil)
+	// Instances of processCollectionDrop during a flush have their data
+	// cleaned up already, and are only to be removed from stream.
+	var deletedInsts, cleanupInsts []common.IndexInst
+	for _, instId := range deletedInstIds {
+		if inst, ok := idx.pendCollectionDropInsts[instId]; ok {
+			delete(idx.pendCollectionDropInsts, instId)
+			deletedInsts = append(deletedInsts, inst)
+		} else {
+			inst := idx.indexInstMap[instId]
+			deletedInsts = append(deletedInsts, inst)
+			cleanupInsts = append(cleanupInsts, inst)
+		}
+	}
+
+	bucketUUID := deletedInsts[0].Defn.BucketUUID // to-be-deleted info needed below
+	if len(cleanupInsts) != 0 {
+		idx.cleanupIndexData(cleanupInsts, nil)
+	}
 
 	// Skip instances with NIL_STREAM
 	indexesWithStream := make([]common.IndexInst, 0)
@@ -4389,6 +4544,27 @@ func (idx *indexer) newIndexInstMsg(m common.IndexInstMap) *MsgUpdateInstMap {
 		rollbackTimes: idx.keyspaceIdRollbackTimes}
 }
 
+// newIndexInstDeltaMsg returns a message with indexInstMap, carrying the
+// instances updated or deleted since the last distributed message as a delta.
+// Those must be all the changes to indexInstMap since then. The updated
+// instances are sent as they are in indexInstMap now, or as deleted if they
+// are gone from it.
+func (idx *indexer) newIndexInstDeltaMsg(updated common.IndexInstList,
+	deleted []common.IndexInstId) *MsgUpdateInstMap {
+
+	msg := idx.newIndexInstMsg(idx.indexInstMap)
+	msg.delta = true
+	for _, inst := range updated {
+		if inst, ok := idx.indexInstMap[inst.InstId]; ok {
+			msg.updatedInsts = append(msg.updatedInsts, inst)
+		} else {
+			msg.deletedInstIds = append(msg.deletedInstIds, inst.InstId)
+		}
+	}
+	msg.AppendDeletedInstIds(deleted)
+	return msg
+}
+
 func (idx *indexer) newKeyspaceStatsMsg() *MsgUpdateKeyspaceStatsMap {
 	return &MsgUpdateKeyspaceStatsMap{keyspaceStatsMap: idx.stats.GetKeyspaceStatsMap().Clone()}
 }
@@ -4410,11 +4586,13 @@ func (idx *indexer) cleanupIndexData(indexInsts []common.IndexInst,
 		delete(idx.indexPartnMap, indexInstId)
 		deleteFreeWriters(indexInstId)
 		idx.deletePendingReset(indexInstId)
+		if idx.trash.isRestored(indexInstId) {
+			idx.trash.remove(indexInstId)
+		}
 	}
 
 	// Send the updated maps to all workers
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendDeletedInstIds(indexInstIds)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(nil, indexInstIds)
 	msgUpdateIndexPartnMap := &MsgUpdatePartnMap{indexPartnMap: idx.indexPartnMap}
 	msgUpdateIndexPartnMap.AppendDeletedInstIds(indexInstIds)
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap,
@@ -4626,7 +4804,8 @@ func (idx *indexer) Shutdown() Message {
 // all other cases.)
 func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstId,
 	buildStream common.StreamId, keyspaceId string, cid string,
-	clusterVer uint64, buildTs Timestamp, clientCh MsgChannel) {
+	clusterVer uint64, buildTs Timestamp, restartTs *common.TsVbuuid,
+	clientCh MsgChannel) {
 
 	var cmd Message
 	var indexList []common.IndexInst
@@ -4640,7 +4819,7 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 	respCh := make(MsgChannel)
 
 	clustAddr := idx.config["clusterAddr"].String()
-	numVb := idx.config["numVbuckets"].Int()
+	numVb := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), idx.config)
 	enableAsync := idx.config["enableAsyncOpenStream"].Bool()
 	enableOSO := idx.config["build.enableOSO"].Bool()
 
@@ -4656,10 +4835,12 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 			"Magma bucket.", buildStream, keyspaceId)
 	}
 
+	//OSO is for a backfill from 0
 	if enableOSO &&
 		clusterVer >= common.INDEXER_71_VERSION &&
 		buildStream == common.INIT_STREAM &&
-		!isMagmaStorage {
+		!isMagmaStorage &&
+		restartTs == nil {
 		enableOSO = true
 	} else {
 		enableOSO = false
@@ -4683,7 +4864,7 @@ func (idx *indexer) sendStreamUpdateForBuildIndex(instIdList []common.IndexInstI
 		indexList:          indexList,
 		buildTs:            buildTs,
 		respCh:             respCh,
-		restartTs:          nil,
+		restartTs:          restartTs,
 		allowMarkFirstSnap: true,
 		rollbackTime:       idx.keyspaceIdRollbackTimes[keyspaceId],
 		async:              async,
@@ -5076,6 +5257,8 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 	//get all partitions for this index
 	partnDefnList := indexInst.Pc.GetAllPartitions()
 
+	idx.updateNumVBuckets(indexInst.Defn.Bucket)
+
 	for _, partnDefn := range partnDefnList {
 		//TODO: Ignore partitions which do not belong to this
 		//indexer node(based on the endpoints)
@@ -5093,7 +5276,8 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 		if err != nil {
 			logging.Errorf("Indexer::initPartnInstance Failed to check bucket type ephemeral: %v\n", err)
 		} else {
-			slice, err = NewSlice(SliceId(0), &indexInst, &partnInst, idx.config, idx.stats, ephemeral, !bootstrapPhase)
+			isNew := !bootstrapPhase && !idx.trash.isRestored(indexInst.InstId)
+			slice, err = NewSlice(SliceId(0), &indexInst, &partnInst, idx.config, idx.stats, ephemeral, isNew)
 		}
 
 		if err == nil {
@@ -5131,6 +5315,14 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 func (idx *indexer) distributeIndexMapsToWorkers(msgUpdateIndexInstMap Message,
 	msgUpdateIndexPartnMap Message) error {
 
+	idx.stateHistory.observe(idx.indexInstMap, idx.stateCause, time.Now())
+
+	if msg, ok := msgUpdateIndexInstMap.(*MsgUpdateInstMap); ok {
+		msg.baseVersion = idx.instMapVersion
+		idx.instMapVersion++
+		msg.version = idx.instMapVersion
+	}
+
 	//update index map in storage manager
 	if err := idx.sendUpdatedIndexMapToWorker(msgUpdateIndexInstMap, msgUpdateIndexPartnMap, idx.storageMgrCmdCh,
 		"StorageMgr"); err != nil {
@@ -5606,8 +5798,7 @@ func (idx *indexer) processBuildDoneCatchup(streamId common.StreamId,
 	}
 
 	//send updated maps to all workers
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(indexList)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(indexList, nil)
 
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		common.CrashOnError(err)
@@ -5796,8 +5987,7 @@ func (idx *indexer) processBuildDoneNoCatchup(streamId common.StreamId,
 	idx.updateRStateForPendingReset(indexList)
 
 	//send updated maps to all workers
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(indexList)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(indexList, nil)
 
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		common.CrashOnError(err)
@@ -5979,8 +6169,7 @@ func (idx *indexer) handleMergeInitStream(msg Message) {
 	idx.updateRStateForPendingReset(indexList)
 
 	//send updated maps to all workers
-	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
-	msgUpdateIndexInstMap.AppendUpdatedInsts(indexList)
+	msgUpdateIndexInstMap := idx.newIndexInstDeltaMsg(indexList, nil)
 
 	if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
 		common.CrashOnError(err)
@@ -6418,7 +6607,7 @@ func (idx *indexer) startKeyspaceIdStream(streamId common.StreamId, keyspaceId s
 	}
 
 	clustAddr := idx.config["clusterAddr"].String()
-	numVb := idx.config["numVbuckets"].Int()
+	numVb := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), idx.config)
 	enableAsync := idx.config["enableAsyncOpenStream"].Bool()
 
 	idx.cinfoProviderLock.RLock()
@@ -6828,7 +7017,7 @@ func (idx *indexer) checkDuplicateDropRequest(indexInst common.IndexInst,
 	return false
 }
 
-func (idx *indexer) bootstrap1(snapshotNotifych []chan IndexSnapshot, snapshotReqCh []MsgChannel) error {
+func (idx *indexer) bootstrap1(snapshotNotifych []chan IndexSnapshot, snapshotReqRouter *snapshotReqRouter) error {
 
 	logging.Infof("Indexer::indexer version %v", common.INDEXER_CUR_VERSION)
 	idx.genIndexerId()
@@ -6865,7 +7054,7 @@ func (idx *indexer) bootstrap1(snapshotNotifych []chan IndexSnapshot, snapshotRe
 		var res Message
 		stats := idx.stats.Clone()
 		idx.storageMgr, res = NewStorageManager(idx.storageMgrCmdCh, idx.wrkrRecvCh,
-			idx.indexPartnMap, idx.config, snapshotNotifych, snapshotReqCh, stats)
+			idx.indexPartnMap, idx.config, snapshotNotifych, snapshotReqRouter, stats)
 		if res.GetMsgType() == MSG_ERROR {
 			err := res.(*MsgError).GetError()
 			logging.Fatalf("Indexer::NewIndexer Storage Manager Init Error %v", err)
@@ -7094,6 +7283,10 @@ func (idx *indexer) handleStorageWarmupDone(msg Message) {
 	NewRestServer(idx.config["clusterAddr"].String(), idx.statsMgr)
 
 	go idx.monitorMemUsage()
+	go idx.governMemory()
+	go idx.monitorDiagnostics()
+	go idx.monitorDiskUsage()
+	go idx.purgeTrash()
 	go idx.logMemstats()
 	go idx.collectProgressStats(true)
 
@@ -7631,9 +7824,11 @@ func (idx *indexer) forceCleanupIndexPartition(indexInst *common.IndexInst,
 	logging.Infof("Indexer::forceCleanupIndexPartition Cleaning up data files for %v %v",
 		indexInst.InstId, partnId)
 
-	// backup the corrupt index data files, if enabled
+	// backup the corrupt index data files, if enabled, or if they have
+	// failed verification
 	needsDataCleanup := true
-	if idx.config["settings.enable_corrupt_index_backup"].Bool() {
+	if idx.config["settings.enable_corrupt_index_backup"].Bool() ||
+		idx.config["settings.verify_slice_on_startup"].Bool() {
 		needsDataCleanup = idx.backupCorruptIndexDataFiles(indexInst, partnId, SliceId(0))
 	}
 
@@ -7736,6 +7931,13 @@ func (idx *indexer) recoverInstMapFromFile() error {
 		return nil
 	}
 
+	// The instance map is stored in plain if it was written with
+	// metadata encryption disabled
+	if instBytes, err = decryptMeta(newFileKeyRing(idx.config), instBytes); err != nil {
+		logging.Fatalf("Indexer::recoverInstMapFromFile Decrypt Error %v", err)
+		return err
+	}
+
 	decBuf := bytes.NewBuffer(instBytes)
 	dec := gob.NewDecoder(decBuf)
 	err = dec.Decode(&idx.indexInstMap)
@@ -8754,6 +8956,24 @@ func NewSlice(id SliceId, indInst *common.IndexInst, partnInst *PartitionInst,
 	}
 	path := filepath.Join(storage_dir, IndexPath(indInst, partnInst.Defn.GetPartitionId(), id))
 
+	// Move the slice to its storage tier before it is opened
+	if !isNew {
+		tier := STORAGE_TIER_HOT
+		coldPath := conf["storage.tiers.coldPath"].String()
+		if coldPath != "" {
+			tier = loadStorageTiers(storage_dir).tier(filepath.Base(path))
+		}
+		if err := placeSlice(path, tier, coldPath); err != nil {
+			logging.Errorf("NewSlice: unable to move slice %v to the %v tier, err %v", path, tier, err)
+		}
+
+		if conf["settings.verify_slice_on_startup"].Bool() {
+			if err := verifySlice(indInst, partnInst.Defn.GetPartitionId(), path, conf); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	partitionId := partnInst.Defn.GetPartitionId()
 	numPartitions := indInst.Pc.GetNumPartitions()
 	instId := GetRealIndexInstId(indInst)
@@ -8777,14 +8997,23 @@ func NewSlice(id SliceId, indInst *common.IndexInst, partnInst *PartitionInst,
 
 func DestroySlice(mode common.StorageMode, storageDir string, path string) error {
 
+	// The path of a slice on the cold tier is a symlink to its dir
+	coldDir := coldSliceDir(path)
+
+	var err error
 	switch mode {
 	case common.MOI, common.FORESTDB, common.NOT_SET:
-		return os.RemoveAll(path)
+		err = os.RemoveAll(path)
 	case common.PLASMA:
-		return DestroyPlasmaSlice(storageDir, path)
+		err = DestroyPlasmaSlice(storageDir, path)
+	default:
+		return fmt.Errorf("unable to delete instance %v : unrecognized storage type %v", path, mode)
 	}
 
-	return fmt.Errorf("unable to delete instance %v : unrecognized storage type %v", path, mode)
+	if err == nil && coldDir != "" {
+		err = os.RemoveAll(coldDir)
+	}
+	return err
 }
 
 func ListSlices(mode common.StorageMode, storageDir string) ([]string, error) {
@@ -9248,7 +9477,9 @@ func (idx *indexer) monitorMemUsage() {
 				}
 
 			case common.INDEXER_PAUSED:
-				if float64(mem_used) < (low_mem_mark*float64(memory_quota)) && canResume {
+				//if paused for disk usage, monitorDiskUsage resumes
+				if float64(mem_used) < (low_mem_mark*float64(memory_quota)) && canResume &&
+					atomic.LoadInt32(&idx.diskFullPaused) == 0 {
 					idx.internalRecvCh <- &MsgIndexerState{mType: INDEXER_RESUME}
 					canResume = false
 				}
@@ -9947,6 +10178,9 @@ func (idx *indexer) cleanupAllStreamKeyspaceIdState(
 	delete(idx.streamKeyspaceIdPendStart[streamId], keyspaceId)
 	delete(idx.streamKeyspaceIdCollectionId[streamId], keyspaceId)
 	delete(idx.streamKeyspaceIdOSOException[streamId], keyspaceId)
+	for _, instId := range idx.streamKeyspaceIdPendCollectionDrop[streamId][keyspaceId] {
+		delete(idx.pendCollectionDropInsts, instId)
+	}
 	delete(idx.streamKeyspaceIdPendCollectionDrop[streamId], keyspaceId)
 }
 
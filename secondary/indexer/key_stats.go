@@ -0,0 +1,479 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/audit"
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// To estimate the selectivity of a predicate, the query planner and the
+// index advisor would otherwise have to sample the index with scans. With
+// settings.keyStats.enabled, the flusher samples the secondary keys of
+// non-array indexes and updates, for each key position of each partition, a
+// HyperLogLog sketch of its distinct values and a t-digest of its numeric
+// values. /stats/keyDistribution merges the sketches of the partitions of
+// each index and returns the estimated number of distinct values and the
+// quantiles of each key position.
+//
+// Sketches are kept in memory from the time stats collection is enabled.
+// They count the values indexed since then, including those of documents
+// since updated or deleted, so they describe the distribution of the keys
+// rather than the exact contents of the index.
+
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+
+	tDigestCompression = 100
+	tDigestBufferSize  = 5 * tDigestCompression
+)
+
+var keyStatsQuantiles = []float64{0, 0.1, 0.25, 0.5, 0.75, 0.9, 1}
+
+// hllSketch estimates the number of distinct values added to it.
+type hllSketch struct {
+	registers [hllRegisters]uint8
+}
+
+func hashKeyValue(v []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(v)
+
+	// fnv does not spread short values over the high bits
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (s *hllSketch) add(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+	}
+}
+
+func (s *hllSketch) merge(o *hllSketch) {
+	for i, r := range o.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+}
+
+func (s *hllSketch) estimate() uint64 {
+	m := float64(hllRegisters)
+
+	var sum float64
+	var zeros int
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// Linear counting for small cardinalities
+		est = m * math.Log(m/float64(zeros))
+	}
+	return uint64(est + 0.5)
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tDigest estimates the quantiles of the values added to it.
+type tDigest struct {
+	centroids []centroid
+	buffer    []centroid
+	count     float64
+	min, max  float64
+}
+
+func (d *tDigest) add(x float64) {
+	d.addWeighted(x, 1)
+}
+
+func (d *tDigest) addWeighted(x float64, w float64) {
+	if d.count == 0 || x < d.min {
+		d.min = x
+	}
+	if d.count == 0 || x > d.max {
+		d.max = x
+	}
+	d.count += w
+
+	d.buffer = append(d.buffer, centroid{x, w})
+	if len(d.buffer) >= tDigestBufferSize {
+		d.compress()
+	}
+}
+
+func (d *tDigest) merge(o *tDigest) {
+	if o.count == 0 {
+		return
+	}
+	min, max := o.min, o.max
+	if d.count > 0 {
+		min, max = math.Min(min, d.min), math.Max(max, d.max)
+	}
+
+	for _, c := range o.centroids {
+		d.addWeighted(c.mean, c.weight)
+	}
+	for _, c := range o.buffer {
+		d.addWeighted(c.mean, c.weight)
+	}
+	d.min, d.max = min, max
+}
+
+// compress merges the buffered values into the centroids, limiting the
+// weight of a centroid by its quantile q to 4*count*q*(1-q)/compression,
+// so that centroids are smaller, and quantiles more accurate, in the tails.
+func (d *tDigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+
+	all := append(d.centroids, d.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	cur := all[0]
+	var cumulative float64
+	for _, c := range all[1:] {
+		q := (cumulative + (cur.weight+c.weight)/2) / d.count
+		limit := 4 * d.count * q * (1 - q) / tDigestCompression
+		if cur.weight+c.weight <= math.Max(limit, 1) {
+			cur.mean += (c.mean - cur.mean) * c.weight / (cur.weight + c.weight)
+			cur.weight += c.weight
+		} else {
+			cumulative += cur.weight
+			merged = append(merged, cur)
+			cur = c
+		}
+	}
+	merged = append(merged, cur)
+
+	d.centroids = merged
+	d.buffer = d.buffer[:0]
+}
+
+// quantile returns the estimated value at quantile q, interpolating
+// between the centroids.
+func (d *tDigest) quantile(q float64) float64 {
+	d.compress()
+	if d.count == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return d.min
+	}
+	if q >= 1 {
+		return d.max
+	}
+
+	target := q * d.count
+	var cumulative float64
+	prevMean, prevCenter := d.min, 0.0
+	for _, c := range d.centroids {
+		center := cumulative + c.weight/2
+		if target < center {
+			if center == prevCenter {
+				return c.mean
+			}
+			return prevMean + (c.mean-prevMean)*(target-prevCenter)/(center-prevCenter)
+		}
+		cumulative += c.weight
+		prevMean, prevCenter = c.mean, center
+	}
+
+	if d.count == prevCenter {
+		return d.max
+	}
+	return prevMean + (d.max-prevMean)*(target-prevCenter)/(d.count-prevCenter)
+}
+
+// cdf returns the estimated fraction of the values below x, interpolating
+// between the centroids.
+func (d *tDigest) cdf(x float64) float64 {
+	d.compress()
+	if d.count == 0 {
+		return math.NaN()
+	}
+	if x < d.min {
+		return 0
+	}
+	if x >= d.max {
+		return 1
+	}
+
+	var cumulative float64
+	prevMean, prevCenter := d.min, 0.0
+	for _, c := range d.centroids {
+		center := cumulative + c.weight/2
+		if x < c.mean {
+			if c.mean == prevMean {
+				return prevCenter / d.count
+			}
+			return (prevCenter + (center-prevCenter)*(x-prevMean)/(c.mean-prevMean)) / d.count
+		}
+		cumulative += c.weight
+		prevMean, prevCenter = c.mean, center
+	}
+
+	if d.max == prevMean {
+		return 1
+	}
+	return (prevCenter + (d.count-prevCenter)*(x-prevMean)/(d.max-prevMean)) / d.count
+}
+
+// keyPositionStats is the distribution of the values of a key position.
+type keyPositionStats struct {
+	count   uint64
+	numeric uint64
+	hll     hllSketch
+	digest  tDigest
+}
+
+// indexKeyStats holds the sketches of the key positions of an index
+// partition, shared by clones of its stats.
+type indexKeyStats struct {
+	mu        sync.Mutex
+	sampled   uint64
+	positions []*keyPositionStats
+}
+
+// sample adds the values of a key to the sketches, one in sampleRate
+// calls. Keys are arrays of the encoded or JSON values of the key
+// positions.
+func (ks *indexKeyStats) sample(key []byte, sampleRate int) error {
+	if sampleRate > 1 && atomic.AddUint64(&ks.sampled, 1)%uint64(sampleRate) != 0 {
+		return nil
+	}
+
+	var err error
+	code := key
+	if isJSONEncoded(key) {
+		if code, err = jsonEncoder.Encode(key, make([]byte, 0, len(key)*3+ENCODE_BUF_SAFE_PAD)); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 0, len(code)*3+ENCODE_BUF_SAFE_PAD)
+	fields, err := jsonEncoder.ExplodeArray(code, buf)
+	if err != nil {
+		return err
+	}
+
+	// Numbers are decoded for the t-digest
+	numbers := make([]float64, len(fields))
+	isNumber := make([]bool, len(fields))
+	for i, field := range fields {
+		if len(field) == 0 || field[0] != collatejson.TypeNumber {
+			continue
+		}
+		text, err := jsonEncoder.Decode(field, buf[:0])
+		if err != nil {
+			return err
+		}
+		if numbers[i], err = strconv.ParseFloat(string(text), 64); err == nil {
+			isNumber[i] = true
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for len(ks.positions) < len(fields) {
+		ks.positions = append(ks.positions, &keyPositionStats{})
+	}
+	for i, field := range fields {
+		ps := ks.positions[i]
+		ps.count++
+		ps.hll.add(hashKeyValue(field))
+		if isNumber[i] {
+			ps.numeric++
+			ps.digest.add(numbers[i])
+		}
+	}
+	return nil
+}
+
+// mergeInto merges the sketches of ks into positions.
+func (ks *indexKeyStats) mergeInto(positions []*keyPositionStats) []*keyPositionStats {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for i, ps := range ks.positions {
+		if i == len(positions) {
+			positions = append(positions, &keyPositionStats{})
+		}
+		positions[i].count += ps.count
+		positions[i].numeric += ps.numeric
+		positions[i].hll.merge(&ps.hll)
+		positions[i].digest.merge(&ps.digest)
+	}
+	return positions
+}
+
+// mergedKeyStats returns the sketches of partitions partnIds of the index
+// merged by key position, or of all its partitions if partnIds is empty.
+func (s *IndexStats) mergedKeyStats(partnIds []common.PartitionId) []*keyPositionStats {
+	if len(partnIds) == 0 {
+		partnIds = s.getPartitions()
+	}
+
+	var positions []*keyPositionStats
+	for _, partnId := range partnIds {
+		if ps := s.getPartitionStats(partnId); ps != nil && ps.keyStats != nil {
+			positions = ps.keyStats.mergeInto(positions)
+		}
+	}
+	return positions
+}
+
+// KeyPositionDistribution is the estimated distribution of the values of
+// a key position of an index.
+type KeyPositionDistribution struct {
+	Position       int                `json:"position"`
+	NumSampled     uint64             `json:"numSampled"`
+	DistinctValues uint64             `json:"distinctValues"`
+	NumNumeric     uint64             `json:"numNumeric"`
+	Quantiles      map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// IndexKeyDistribution is the estimated distribution of the keys of an
+// index.
+type IndexKeyDistribution struct {
+	InstId     common.IndexInstId         `json:"instId"`
+	Name       string                     `json:"name"`
+	Bucket     string                     `json:"bucket"`
+	Scope      string                     `json:"scope"`
+	Collection string                     `json:"collection"`
+	Keys       []*KeyPositionDistribution `json:"keys"`
+}
+
+func newKeyPositionDistribution(pos int, ps *keyPositionStats) *KeyPositionDistribution {
+	kd := &KeyPositionDistribution{
+		Position:       pos,
+		NumSampled:     ps.count,
+		DistinctValues: ps.hll.estimate(),
+		NumNumeric:     ps.numeric,
+	}
+	if kd.DistinctValues > kd.NumSampled {
+		kd.DistinctValues = kd.NumSampled
+	}
+
+	if ps.numeric > 0 {
+		kd.Quantiles = make(map[string]float64)
+		for _, q := range keyStatsQuantiles {
+			kd.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = ps.digest.quantile(q)
+		}
+	}
+	return kd
+}
+
+// getKeyDistributions returns the key distributions of the indexes
+// accepted by filter.
+func getKeyDistributions(stats *IndexerStats, filter func(*IndexStats) bool) []*IndexKeyDistribution {
+
+	result := make([]*IndexKeyDistribution, 0)
+	for instId, is := range stats.indexes {
+		if !filter(is) {
+			continue
+		}
+
+		positions := is.mergedKeyStats(nil)
+		if len(positions) == 0 {
+			continue
+		}
+
+		dist := &IndexKeyDistribution{
+			InstId:     instId,
+			Name:       is.dispName,
+			Bucket:     is.bucket,
+			Scope:      is.scope,
+			Collection: is.collection,
+		}
+		for i, ps := range positions {
+			dist.Keys = append(dist.Keys, newKeyPositionDistribution(i, ps))
+		}
+		result = append(result, dist)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].InstId < result[j].InstId
+	})
+	return result
+}
+
+// handleKeyDistributionReq returns the estimated key distributions of the
+// indexes on this node, optionally filtered by ?bucket=, ?scope=,
+// ?collection= and ?index=.
+func (s *statsManager) handleKeyDistributionReq(w http.ResponseWriter, r *http.Request) {
+	_, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		audit.Audit(common.AUDIT_UNAUTHORIZED, r, "StatsManager::handleKeyDistributionReq", "")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	query := r.URL.Query()
+	match := func(param, value string) bool {
+		v := query.Get(param)
+		return v == "" || v == value
+	}
+	filter := func(is *IndexStats) bool {
+		return match("bucket", is.bucket) && match("scope", is.scope) &&
+			match("collection", is.collection) && match("index", is.name)
+	}
+
+	data, err := json.Marshal(getKeyDistributions(s.stats.Get(), filter))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
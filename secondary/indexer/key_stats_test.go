@@ -0,0 +1,76 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHLLSketch(t *testing.T) {
+	var a, b hllSketch
+	for i := 0; i < 10000; i++ {
+		a.add(hashKeyValue([]byte(strconv.Itoa(i))))
+		a.add(hashKeyValue([]byte(strconv.Itoa(i)))) // duplicates are not counted
+		b.add(hashKeyValue([]byte(strconv.Itoa(i + 5000))))
+	}
+
+	if est := a.estimate(); math.Abs(float64(est)-10000) > 500 {
+		t.Fatalf("expected about 10000 distinct values, got %v", est)
+	}
+
+	a.merge(&b)
+	if est := a.estimate(); math.Abs(float64(est)-15000) > 750 {
+		t.Fatalf("expected about 15000 distinct values after merge, got %v", est)
+	}
+
+	var small hllSketch
+	for i := 0; i < 10; i++ {
+		small.add(hashKeyValue([]byte(strconv.Itoa(i))))
+	}
+	if est := small.estimate(); est != 10 {
+		t.Fatalf("expected 10 distinct values, got %v", est)
+	}
+}
+
+func TestTDigestQuantiles(t *testing.T) {
+	var a, b tDigest
+	for i := 1; i <= 10000; i++ {
+		if i%2 == 0 {
+			a.add(float64(i))
+		} else {
+			b.add(float64(i))
+		}
+	}
+	a.merge(&b)
+
+	if a.quantile(0) != 1 || a.quantile(1) != 10000 {
+		t.Fatalf("expected min 1 and max 10000, got %v and %v", a.quantile(0), a.quantile(1))
+	}
+	for _, q := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99} {
+		if v := a.quantile(q); math.Abs(v-q*10000) > 100 {
+			t.Fatalf("expected quantile %v near %v, got %v", q, q*10000, v)
+		}
+	}
+
+	for _, x := range []float64{100, 2500, 5000, 9000} {
+		if f := a.cdf(x); math.Abs(f-x/10000) > 0.01 {
+			t.Fatalf("expected cdf of %v near %v, got %v", x, x/10000, f)
+		}
+	}
+	if a.cdf(0) != 0 || a.cdf(10000) != 1 {
+		t.Fatalf("expected cdf 0 below min and 1 at max, got %v and %v", a.cdf(0), a.cdf(10000))
+	}
+
+	var empty tDigest
+	if !math.IsNaN(empty.quantile(0.5)) {
+		t.Fatalf("expected no quantile for an empty digest")
+	}
+}
@@ -314,7 +314,7 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, keyspaceId string,
 		return
 	}
 
-	numVbuckets := k.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(bucket, k.config)
 	if len(vbnos) != numVbuckets {
 		logging.Warnf("KVSender::openMutationStream mismatch in number of configured "+
 			"vbuckets. conf %v actual %v", numVbuckets, vbnos)
@@ -472,7 +472,7 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, keyspaceId string,
 
 	//convert TS to protobuf format
 	var protoRestartTs *protobuf.TsVbuuid
-	numVbuckets := k.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(bucket, k.config)
 	protoTs := protobuf.NewTsVbuuid(DEFAULT_POOL, bucket, numVbuckets)
 	protoRestartTs = protoTs.FromTsVbuuid(restartTs)
 
@@ -666,7 +666,7 @@ func (k *kvSender) addIndexForExistingKeyspace(streamId c.StreamId, keyspaceId s
 		}
 
 		//check if we have received currentTs for all vbuckets
-		numVbuckets := k.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(bucket, k.config)
 		if currentTs == nil || currentTs.Len() != numVbuckets {
 			return errors.New("ErrPartialVbStart")
 		} else {
@@ -692,7 +692,7 @@ func (k *kvSender) addIndexForExistingKeyspace(streamId c.StreamId, keyspaceId s
 		return
 	}
 
-	numVbuckets := k.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(bucket, k.config)
 	nativeTs := currentTs.ToTsVbuuid(numVbuckets)
 
 	respCh <- &MsgStreamUpdate{mType: MSG_SUCCESS,
@@ -1124,7 +1124,7 @@ func getTopicForStreamId(streamId c.StreamId) string {
 
 func (k *kvSender) computeShutdownTs(restartTs *protobuf.TsVbuuid, connErrVbs []Vbucket) *protobuf.TsVbuuid {
 
-	numVbuckets := k.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(*restartTs.Bucket, k.config)
 	shutdownTs := protobuf.NewTsVbuuid(*restartTs.Pool, *restartTs.Bucket, numVbuckets)
 	for _, vbno1 := range connErrVbs {
 		for i, vbno2 := range restartTs.Vbnos {
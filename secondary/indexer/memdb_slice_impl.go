@@ -55,9 +55,10 @@ type indexMutation struct {
 func docIdFromEntryBytes(e []byte) []byte {
 	offset := len(e) - 2
 	l := binary.LittleEndian.Uint16(e[offset : offset+2])
-	// Length & 0111111 00000000
+	// Length & 0011111 11111111
 	// as MSB of length is used to indicate presence of count
-	docidlen := int(l & 0x7fff)
+	// and the next bit to indicate an overflow entry
+	docidlen := int(l & 0x3fff)
 	offset = len(e) - 1
 	if (e[offset] & 0x80) == 0x80 { // if count is encoded
 		offset = len(e) - docidlen - 4
@@ -159,7 +160,13 @@ type memdbSlice struct {
 	sysconf  common.Config // system configuration settings
 	confLock sync.RWMutex  // protects sysconf
 
-	isPersistorActive int32
+	// Committed snapshots are persisted in order by the persister, while new
+	// snapshots are created. The snapshots waiting to be persisted are queued,
+	// oldest first, up to moi.persistence.queue_size.
+	persistLock     sync.Mutex
+	persistQueue    []*memdbSnapshot
+	persistNotifyCh chan bool
+	persistStopCh   DoneChannel
 
 	lastRollbackTs *common.TsVbuuid
 
@@ -219,7 +226,7 @@ func NewMemDBSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
 	mdb.numWriters = sysconf["numSliceWriters"].Int()
 	mdb.maxRollbacks = sysconf["settings.moi.recovery.max_rollbacks"].Int()
 	mdb.maxDiskSnaps = sysconf["recovery.max_disksnaps"].Int()
-	mdb.numVbuckets = sysconf["numVbuckets"].Int()
+	mdb.numVbuckets = getNumVBuckets(idxDefn.Bucket, sysconf)
 	mdb.clusterAddr = sysconf["clusterAddr"].String()
 	mdb.exposeItemCopy = sysconf["moi.exposeItemCopy"].Bool()
 
@@ -275,6 +282,12 @@ func NewMemDBSlice(path string, sliceId SliceId, idxDefn common.IndexDefn,
 		go mdb.handleCommandsWorker(i)
 	}
 
+	mdb.persistNotifyCh = make(chan bool, 1)
+	mdb.persistStopCh = make(DoneChannel)
+	if mdb.hasPersistence {
+		go mdb.persister()
+	}
+
 	mdb.setCommittedCount()
 	return mdb, nil
 }
@@ -331,7 +344,11 @@ func (mdb *memdbSlice) initStores() {
 	cfg.SetExposeItemCopy(mdb.exposeItemCopy)
 	cfg.SetIOConcurrency(ioConcurrency)
 
-	cfg.SetKeyComparator(byteItemCompare)
+	if mdb.isPrimary {
+		cfg.SetKeyComparator(byteItemCompare)
+	} else {
+		cfg.SetKeyComparator(overflowItemCompare)
+	}
 	mdb.mainstore = memdb.NewWithConfig(cfg)
 	mdb.main = make([]*memdb.Writer, mdb.numWriters)
 	for i := 0; i < mdb.numWriters; i++ {
@@ -588,8 +605,9 @@ func (mdb *memdbSlice) insertSecIndex(key []byte, docid []byte, workerId int, me
 	szConf := mdb.updateSliceBuffers(workerId)
 	mdb.encodeBuf[workerId] = resizeEncodeBuf(mdb.encodeBuf[workerId], len(key), szConf.allowLargeKeys)
 
-	entry, err := NewSecondaryIndexEntry(key, docid, mdb.idxDefn.IsArrayIndex,
-		1, mdb.idxDefn.Desc, mdb.encodeBuf[workerId], meta, szConf)
+	canOverflow := mdb.canOverflowKeys(szConf)
+	entry, err := NewSecondaryIndexEntry2(key, docid, mdb.idxDefn.IsArrayIndex,
+		1, mdb.idxDefn.Desc, mdb.encodeBuf[workerId], !canOverflow, meta, szConf)
 	if err != nil {
 		logging.Errorf("MemDBSlice::insertSecIndex Slice Id %v IndexInstId %v PartitionId %v "+
 			"Skipping docid:%s (%v)", mdb.Id, mdb.idxInstId, mdb.idxPartnId, logging.TagStrUD(docid), err)
@@ -597,6 +615,13 @@ func (mdb *memdbSlice) insertSecIndex(key []byte, docid []byte, workerId int, me
 		return mdb.deleteSecIndex(docid, workerId)
 	}
 
+	isOverflow := false
+	if canOverflow && entry.lenKey() > szConf.maxSecKeyBufferLen {
+		mdb.putOverflowRecord(docid, newOverflowRecord(docid, entry, nil), workerId)
+		entry = newOverflowIndexEntry(entry, szConf.maxSecKeyBufferLen, nil)
+		isOverflow = true
+	}
+
 	newNode := mdb.main[workerId].Put2(entry)
 
 	mdb.idxStats.Timings.stKVSet.Put(time.Now().Sub(t0))
@@ -604,6 +629,10 @@ func (mdb *memdbSlice) insertSecIndex(key []byte, docid []byte, workerId int, me
 	// Insert succeeded. Failure means same entry already exist.
 	if newNode != nil {
 		if updated, oldNode := mdb.back[workerId].Update(entry, unsafe.Pointer(newNode)); updated {
+			if !isOverflow {
+				mdb.deleteOverflowRecordOf(oldNode, docid, workerId)
+			}
+
 			t0 := time.Now()
 			oldSz := getNodeItemSize((*skiplist.Node)(oldNode))
 			mdb.main[workerId].DeleteNode((*skiplist.Node)(oldNode))
@@ -695,6 +724,7 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 	}
 
 	entryBytesToBeAdded, entryBytesToDeleted := CompareArrayEntriesWithCount(newEntriesBytes, oldEntriesBytes, newKeyCount, oldKeyCount)
+	mdb.idxStats.numArrEntriesKept.Add(int64(keptArrayEntries(entryBytesToDeleted)))
 	nmut = 0
 
 	emptyList := func() int {
@@ -839,6 +869,7 @@ func (mdb *memdbSlice) deleteSecIndex(docid []byte, workerId int) int {
 	success, node := mdb.back[workerId].Remove(lookupentry)
 	if success {
 		mdb.idxStats.Timings.stKVDelete.Put(time.Since(t0))
+		mdb.deleteOverflowRecordOf(node, docid, workerId)
 
 		mdb.idxStats.backstoreRawDataSize.Add(0 - int64(len(lookupentry)))
 		atomic.AddInt64(&mdb.delete_bytes, int64(len(docid)))
@@ -915,8 +946,9 @@ func (mdb *memdbSlice) checkFatalDbError(err error) {
 }
 
 type memdbSnapshotInfo struct {
-	Ts       *common.TsVbuuid
-	MainSnap *memdb.Snapshot `json:"-"`
+	Ts        *common.TsVbuuid
+	CompactTs []byte          `json:",omitempty"`
+	MainSnap  *memdb.Snapshot `json:"-"`
 
 	Committed bool `json:"-"`
 	dataPath  string
@@ -936,6 +968,8 @@ type memdbSnapshot struct {
 	info       *memdbSnapshotInfo
 	committed  bool
 
+	numOverflowKeys int64
+
 	refCount int32
 }
 
@@ -953,6 +987,8 @@ func (mdb *memdbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
 		info:       info.(*memdbSnapshotInfo),
 		ts:         snapInfo.Timestamp(),
 		committed:  info.IsCommitted(),
+
+		numOverflowKeys: mdb.idxStats.numOverflowKeys.Value(),
 	}
 
 	s.Open()
@@ -971,6 +1007,7 @@ func (mdb *memdbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
 			// cleanup is needed when loadSnapshot returns an error.
 			s.Close()
 		}
+		s.numOverflowKeys = mdb.idxStats.numOverflowKeys.Value()
 	}
 
 	if mdb.idxStats.useArrItemsCount {
@@ -999,103 +1036,194 @@ func (mdb *memdbSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
 	return s, err
 }
 
-// doPersistSnapshot writes a snapshot to disk, including a checksum file, manifests, and
-// snapshot info with a subset of stats. (The checksum only covers the snapshot itself.)
-// Most of the work is done in a separate goroutine running the anonymous function below.
+// doPersistSnapshot queues a snapshot to be written to disk by the persister,
+// with a subset of stats added to its snapshot info. If the queue is full, the
+// oldest queued snapshots are not persisted.
 func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
-	var concurrency int = 1
+	// Add persisted subset of stats to snapshot info
+	snapshotStats := make(map[string]interface{})
+	snapshotStats[SNAP_STATS_KEY_SIZES] = getKeySizesStats(mdb.idxStats)
+	snapshotStats[SNAP_STATS_KEY_SIZES_SINCE] = mdb.idxStats.keySizeStatsSince.Value()
+	snapshotStats[SNAP_STATS_RAW_DATA_SIZE] = mdb.idxStats.rawDataSize.Value()
+	snapshotStats[SNAP_STATS_BACKSTORE_RAW_DATA_SIZE] = mdb.idxStats.backstoreRawDataSize.Value()
+	if mdb.idxStats.useArrItemsCount {
+		snapshotStats[SNAP_STATS_ARR_ITEMS_COUNT] = mdb.idxStats.arrItemsCount.Value()
+	}
+	snapshotStats[SNAP_STATS_OVERFLOW_KEYS] = s.numOverflowKeys
+	s.info.IndexStats = snapshotStats
 
-	if atomic.CompareAndSwapInt32(&mdb.isPersistorActive, 0, 1) {
-		// Add persisted subset of stats to snapshot info
-		snapshotStats := make(map[string]interface{})
-		snapshotStats[SNAP_STATS_KEY_SIZES] = getKeySizesStats(mdb.idxStats)
-		snapshotStats[SNAP_STATS_KEY_SIZES_SINCE] = mdb.idxStats.keySizeStatsSince.Value()
-		snapshotStats[SNAP_STATS_RAW_DATA_SIZE] = mdb.idxStats.rawDataSize.Value()
-		snapshotStats[SNAP_STATS_BACKSTORE_RAW_DATA_SIZE] = mdb.idxStats.backstoreRawDataSize.Value()
-		if mdb.idxStats.useArrItemsCount {
-			snapshotStats[SNAP_STATS_ARR_ITEMS_COUNT] = mdb.idxStats.arrItemsCount.Value()
-		}
-		s.info.IndexStats = snapshotStats
+	mdb.confLock.RLock()
+	maxQueued := mdb.sysconf["moi.persistence.queue_size"].Int()
+	mdb.confLock.RUnlock()
+	if maxQueued < 1 {
+		maxQueued = 1
+	}
 
-		go func() {
-			defer atomic.StoreInt32(&mdb.isPersistorActive, 0)
+	var skipped []*memdbSnapshot
 
-			t0 := time.Now()
-			dir := newSnapshotPath(mdb.path)
-			tmpdir := filepath.Join(mdb.path, tmpDirName)
-			manifest := filepath.Join(tmpdir, "manifest.json")
-			os.RemoveAll(tmpdir)
-
-			mdb.confLock.RLock()
-			maxThreads := mdb.sysconf["settings.moi.persistence_threads"].Int()
-			mdb.confLock.RUnlock()
-
-			total := atomic.LoadInt64(&totalMemDBItems)
-			indexCount := mdb.GetCommittedCount()
-			// Compute number of workers to be used for taking backup
-			if total > 0 {
-				concurrency = int(math.Ceil(float64(maxThreads) * float64(indexCount) / float64(total)))
-			}
+	mdb.persistLock.Lock()
+	select {
+	case <-mdb.persistStopCh:
+		mdb.persistLock.Unlock()
+		s.info.MainSnap.Close()
+		return
+	default:
+	}
+	mdb.persistQueue = append(mdb.persistQueue, s)
+	if n := len(mdb.persistQueue) - maxQueued; n > 0 {
+		skipped = append(skipped, mdb.persistQueue[:n]...)
+		mdb.persistQueue = append([]*memdbSnapshot(nil), mdb.persistQueue[n:]...)
+	}
+	mdb.idxStats.diskSnapPersistBacklog.Set(int64(len(mdb.persistQueue)))
+	mdb.persistLock.Unlock()
 
-			// Prepare for persistence.
-			if err := mdb.mainstore.PreparePersistence(tmpdir, s.info.MainSnap); err != nil {
-				logging.Errorf("MemDBSlice Slice Id %v, IndexInstId %v, PartitionId %v failed to"+
-					" prepare persistence for create ondisk snapshot %v (error=%v)", mdb.id, mdb.idxInstId, mdb.idxPartnId, dir, err)
+	for _, s := range skipped {
+		logging.Infof("MemDBSlice Slice Id %v, IndexInstId %v, PartitionId %v Skipping ondisk"+
+			" snapshot %v. Persistence queue is full.", mdb.id, mdb.idxInstId, mdb.idxPartnId, s.info)
+		s.info.MainSnap.Close()
+		mdb.idxStats.numDiskSnapsSkipped.Add(1)
+	}
 
-				os.RemoveAll(tmpdir)
-				os.RemoveAll(dir)
+	select {
+	case mdb.persistNotifyCh <- true:
+	default:
+	}
+}
 
-				return
+// persister persists the queued snapshots, oldest first, until the slice is
+// closed.
+func (mdb *memdbSlice) persister() {
+	for {
+		select {
+		case <-mdb.persistNotifyCh:
+			for s := mdb.nextPersistSnapshot(); s != nil; s = mdb.nextPersistSnapshot() {
+				mdb.persistSnapshot(s)
 			}
 
-			// StoreToDisk call below will spawn 'concurrency' goroutines to write
-			// and will wait for this group to complete.
-			// To ensure that CPU isn't overwhelmed, we limit how many such groups
-			// can run in parallel.
-			moiWriterSemaphoreCh <- true
-			defer func() {
-				<-moiWriterSemaphoreCh
-			}()
-			err := mdb.mainstore.StoreToDisk(tmpdir, s.info.MainSnap, concurrency, nil)
-			if err == nil {
-				// Add details to snapshot info
-				s.info.Version = SNAPSHOT_META_VERSION_MOI_1
-				s.info.InstId = mdb.idxInstId
-				s.info.PartnId = mdb.idxPartnId
-
-				// Append info with stats to manifest file
-				var bs []byte // declare to avoid shadowing err with :=
-				bs, err = json.Marshal(s.info)
-				if err == nil {
-					err = common.WriteFileWithSync(manifest, bs, 0755)
-				}
+		case <-mdb.persistStopCh:
+			mdb.discardPersistQueue()
+			return
+		}
+	}
+}
 
-				// If everything succeeded, rename the temp dir to the final dir
-				// and clean up old disk snapshots
-				if err == nil {
-					err = os.Rename(tmpdir, dir)
-					if err == nil {
-						mdb.cleanupOldSnapshotFiles(mdb.maxRollbacks, s.info)
-					}
-				}
-			}
+func (mdb *memdbSlice) nextPersistSnapshot() *memdbSnapshot {
+	mdb.persistLock.Lock()
+	defer mdb.persistLock.Unlock()
+
+	select {
+	case <-mdb.persistStopCh:
+		return nil
+	default:
+	}
+
+	if len(mdb.persistQueue) == 0 {
+		return nil
+	}
+
+	s := mdb.persistQueue[0]
+	mdb.persistQueue[0] = nil
+	mdb.persistQueue = mdb.persistQueue[1:]
+	mdb.idxStats.diskSnapPersistBacklog.Set(int64(len(mdb.persistQueue)))
+	return s
+}
+
+// discardPersistQueue drops the snapshots waiting to be persisted.
+func (mdb *memdbSlice) discardPersistQueue() {
+	mdb.persistLock.Lock()
+	queue := mdb.persistQueue
+	mdb.persistQueue = nil
+	mdb.idxStats.diskSnapPersistBacklog.Set(0)
+	mdb.persistLock.Unlock()
+
+	for _, s := range queue {
+		s.info.MainSnap.Close()
+	}
+}
+
+// persistSnapshot writes a snapshot to disk, including a checksum file, manifests, and
+// snapshot info with a subset of stats. (The checksum only covers the snapshot itself.)
+func (mdb *memdbSlice) persistSnapshot(s *memdbSnapshot) {
+	var concurrency int = 1
+
+	t0 := time.Now()
+	dir := newSnapshotPath(mdb.path)
+	tmpdir := filepath.Join(mdb.path, tmpDirName)
+	manifest := filepath.Join(tmpdir, "manifest.json")
+	os.RemoveAll(tmpdir)
+
+	mdb.confLock.RLock()
+	maxThreads := mdb.sysconf["settings.moi.persistence_threads"].Int()
+	compactTs := mdb.sysconf["persisted_snapshot.compact_ts"].Bool()
+	mdb.confLock.RUnlock()
+
+	total := atomic.LoadInt64(&totalMemDBItems)
+	indexCount := mdb.GetCommittedCount()
+	// Compute number of workers to be used for taking backup
+	if total > 0 {
+		concurrency = int(math.Ceil(float64(maxThreads) * float64(indexCount) / float64(total)))
+	}
+
+	// Prepare for persistence.
+	if err := mdb.mainstore.PreparePersistence(tmpdir, s.info.MainSnap); err != nil {
+		logging.Errorf("MemDBSlice Slice Id %v, IndexInstId %v, PartitionId %v failed to"+
+			" prepare persistence for create ondisk snapshot %v (error=%v)", mdb.id, mdb.idxInstId, mdb.idxPartnId, dir, err)
+
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(dir)
+
+		return
+	}
+
+	// StoreToDisk call below will spawn 'concurrency' goroutines to write
+	// and will wait for this group to complete.
+	// To ensure that CPU isn't overwhelmed, we limit how many such groups
+	// can run in parallel.
+	moiWriterSemaphoreCh <- true
+	defer func() {
+		<-moiWriterSemaphoreCh
+	}()
+	err := mdb.mainstore.StoreToDisk(tmpdir, s.info.MainSnap, concurrency, nil)
+	if err == nil {
+		// Add details to snapshot info
+		s.info.Version = SNAPSHOT_META_VERSION_MOI_1
+		s.info.InstId = mdb.idxInstId
+		s.info.PartnId = mdb.idxPartnId
+
+		info := *s.info
+		if compactTs {
+			info.Version = SNAPSHOT_META_VERSION_MOI_2
+			info.CompactTs = common.EncodeTsVbuuid(info.Ts)
+			info.Ts = nil
+		}
+
+		// Append info with stats to manifest file
+		var bs []byte // declare to avoid shadowing err with :=
+		bs, err = json.Marshal(&info)
+		if err == nil {
+			err = common.WriteFileWithSync(manifest, bs, 0755)
+		}
 
+		// If everything succeeded, rename the temp dir to the final dir
+		// and clean up old disk snapshots
+		if err == nil {
+			err = os.Rename(tmpdir, dir)
 			if err == nil {
-				dur := time.Since(t0)
-				logging.Infof("MemDBSlice Slice Id %v, Threads %d, IndexInstId %v, PartitionId %v created ondisk"+
-					" snapshot %v. Took %v", mdb.id, concurrency, mdb.idxInstId, mdb.idxPartnId, dir, dur)
-				mdb.idxStats.diskSnapStoreDuration.Set(int64(dur / time.Millisecond))
-			} else {
-				logging.Errorf("MemDBSlice Slice Id %v, IndexInstId %v, PartitionId %v failed to"+
-					" create ondisk snapshot %v (error=%v)", mdb.id, mdb.idxInstId, mdb.idxPartnId, dir, err)
-				os.RemoveAll(tmpdir)
-				os.RemoveAll(dir)
+				mdb.cleanupOldSnapshotFiles(mdb.maxRollbacks, s.info)
 			}
-		}()
+		}
+	}
+
+	if err == nil {
+		dur := time.Since(t0)
+		logging.Infof("MemDBSlice Slice Id %v, Threads %d, IndexInstId %v, PartitionId %v created ondisk"+
+			" snapshot %v. Took %v", mdb.id, concurrency, mdb.idxInstId, mdb.idxPartnId, dir, dur)
+		mdb.idxStats.diskSnapStoreDuration.Set(int64(dur / time.Millisecond))
 	} else {
-		logging.Infof("MemDBSlice Slice Id %v, IndexInstId %v, PartitionId %v Skipping ondisk"+
-			" snapshot. A snapshot writer is in progress.", mdb.id, mdb.idxInstId, mdb.idxPartnId)
-		s.info.MainSnap.Close()
+		logging.Errorf("MemDBSlice Slice Id %v, IndexInstId %v, PartitionId %v failed to"+
+			" create ondisk snapshot %v (error=%v)", mdb.id, mdb.idxInstId, mdb.idxPartnId, dir, err)
+		os.RemoveAll(tmpdir)
+		os.RemoveAll(dir)
 	}
 }
 
@@ -1184,9 +1312,15 @@ func (mdb *memdbSlice) diskSize() int64 {
 }
 
 func (mdb *memdbSlice) getSnapshotManifests() []string {
+	return getMemdbSnapshotManifests(mdb.path)
+}
+
+// getMemdbSnapshotManifests returns the manifests of the disk snapshots
+// of the slice at path, oldest first.
+func getMemdbSnapshotManifests(path string) []string {
 	var files []string
 	pattern := "*/manifest.json"
-	all, _ := filepath.Glob(filepath.Join(mdb.path, pattern))
+	all, _ := filepath.Glob(filepath.Join(path, pattern))
 	for _, f := range all {
 		if !strings.Contains(f, tmpDirName) {
 			files = append(files, f)
@@ -1206,10 +1340,17 @@ func (mdb *memdbSlice) GetSnapshots() ([]SnapshotInfo, error) {
 }
 
 func (mdb *memdbSlice) getSnapshots() ([]SnapshotInfo, []string, error) {
+	infos, outfiles := readMemdbSnapshotInfos(mdb.getSnapshotManifests())
+	return infos, outfiles, nil
+}
+
+// readMemdbSnapshotInfos returns the snapshot infos of the manifests files,
+// and the files read, in reverse order. The manifests that cannot be read
+// are skipped.
+func readMemdbSnapshotInfos(files []string) ([]SnapshotInfo, []string) {
 	var infos []SnapshotInfo
 	var outfiles []string
 
-	files := mdb.getSnapshotManifests()
 	for i := len(files) - 1; i >= 0; i-- {
 		f := files[i]
 		info := &memdbSnapshotInfo{dataPath: filepath.Dir(f)}
@@ -1219,6 +1360,10 @@ func (mdb *memdbSlice) getSnapshots() ([]SnapshotInfo, []string, error) {
 			bs, err := ioutil.ReadAll(fd)
 			if err == nil {
 				err = json.Unmarshal(bs, info)
+				if err == nil && len(info.CompactTs) != 0 {
+					info.Ts, err = common.DecodeTsVbuuid(info.CompactTs)
+					info.CompactTs = nil
+				}
 				if err == nil {
 					infos = append(infos, info)
 					outfiles = append(outfiles, f)
@@ -1226,7 +1371,7 @@ func (mdb *memdbSlice) getSnapshots() ([]SnapshotInfo, []string, error) {
 			}
 		}
 	}
-	return infos, outfiles, nil
+	return infos, outfiles
 }
 
 func (mdb *memdbSlice) setCommittedCount() {
@@ -1288,6 +1433,9 @@ func (mdb *memdbSlice) Rollback(info SnapshotInfo) error {
 	//are no flush workers before calling rollback.
 	mdb.waitPersist()
 
+	// Snapshots queued for persistence are rolled back too
+	mdb.discardPersistQueue()
+
 	qc := atomic.LoadInt64(&mdb.qCount)
 	if qc > 0 {
 		common.CrashOnError(errors.New("Slice Invariant Violation - rollback with pending mutations"))
@@ -1341,6 +1489,7 @@ func (mdb *memdbSlice) updateStatsFromSnapshotMeta(o SnapshotInfo) {
 
 		mdb.idxStats.keySizeStatsSince.Set(safeGetInt64(stats[SNAP_STATS_KEY_SIZES_SINCE]))
 		mdb.idxStats.arrItemsCount.Set(safeGetInt64(stats[SNAP_STATS_ARR_ITEMS_COUNT]))
+		mdb.idxStats.numOverflowKeys.Set(safeGetInt64(stats[SNAP_STATS_OVERFLOW_KEYS]))
 	} else {
 		// Since stats are not available, update keySizeStatsSince to current time
 		// to indicate we start tracking the stat since now.
@@ -1404,6 +1553,9 @@ func (mdb *memdbSlice) loadSnapshot(snapInfo *memdbSnapshotInfo) (err error) {
 		}
 
 		backIndexCallback = func(e *memdb.ItemEntry) {
+			if isOverflowRecord(e.Item().Bytes()) {
+				return
+			}
 			wId := vbucketFromEntryBytes(e.Item().Bytes(), mdb.numVbuckets) % mdb.numWriters
 			partShardCh[wId] <- e
 		}
@@ -1461,6 +1613,9 @@ func (mdb *memdbSlice) RollbackToZero() error {
 	//are no flush workers before calling rollback.
 	mdb.waitPersist()
 
+	// Snapshots queued for persistence are rolled back too
+	mdb.discardPersistQueue()
+
 	mdb.resetStores()
 	mdb.cleanupAllOldSnapshotFiles()
 
@@ -1565,6 +1720,9 @@ func (mdb *memdbSlice) Close() {
 		<-mdb.stopCh[i]
 	}
 
+	//signal shutdown for the persister, which drops the queued snapshots
+	close(mdb.persistStopCh)
+
 	if mdb.refCount > 0 {
 		mdb.isSoftClosed = true
 		logging.Infof("MemDBSlice::Close Soft Closing Slice Id %v, IndexInstId %v, PartitionId %v, "+
@@ -1673,36 +1831,46 @@ func (mdb *memdbSlice) Statistics(consumerFilter uint64) (StorageStatistics, err
 	itemsCount := mdb.mainstore.ItemsCount()
 	docidCount := itemsCount
 
-	internalDataMap["MainStore"] = mdb.mainstore.DumpStatsMap()
-	internalData = append(internalData, fmt.Sprintf("{\n\"MainStore\": %s", mdb.mainstore.DumpStats()))
-	if !mdb.isPrimary {
-		docidCount = 0
-		for i := 0; i < mdb.numWriters; i++ {
-			internalData = append(internalData, ",\n")
-			internalData = append(internalData, fmt.Sprintf(`"BackStore_%d": %s`, i, mdb.back[i].Stats()))
-			internalDataMap[fmt.Sprintf("BackStore_%d", i)] = mdb.back[i].StatsMap()
-			ntMemUsed += mdb.back[i].MemoryInUse()
-			docidCount += mdb.back[i].ItemsCount()
+	if !needStorageInternalData(consumerFilter) {
+		if !mdb.isPrimary {
+			docidCount = 0
+			for i := 0; i < mdb.numWriters; i++ {
+				ntMemUsed += mdb.back[i].MemoryInUse()
+				docidCount += mdb.back[i].ItemsCount()
+			}
 		}
-	}
-	internalDataMap["data_size"] = mdb.mainstore.MemoryInUse()
-	internalDataMap["items_count"] = itemsCount
-	internalDataMap["lastGCSn"] = mdb.mainstore.GetLastGCSn()
-	internalDataMap["currSn"] = mdb.mainstore.GetCurrSn()
+	} else {
+		internalDataMap["MainStore"] = mdb.mainstore.DumpStatsMap()
+		internalData = append(internalData, fmt.Sprintf("{\n\"MainStore\": %s", mdb.mainstore.DumpStats()))
+		if !mdb.isPrimary {
+			docidCount = 0
+			for i := 0; i < mdb.numWriters; i++ {
+				internalData = append(internalData, ",\n")
+				internalData = append(internalData, fmt.Sprintf(`"BackStore_%d": %s`, i, mdb.back[i].Stats()))
+				internalDataMap[fmt.Sprintf("BackStore_%d", i)] = mdb.back[i].StatsMap()
+				ntMemUsed += mdb.back[i].MemoryInUse()
+				docidCount += mdb.back[i].ItemsCount()
+			}
+		}
+		internalDataMap["data_size"] = mdb.mainstore.MemoryInUse()
+		internalDataMap["items_count"] = itemsCount
+		internalDataMap["lastGCSn"] = mdb.mainstore.GetLastGCSn()
+		internalDataMap["currSn"] = mdb.mainstore.GetCurrSn()
 
-	sts.InternalDataMap = internalDataMap
+		sts.InternalDataMap = internalDataMap
+
+		internalData = append(internalData, ",\n")
+		internalData = append(internalData, fmt.Sprintf(`"data_size": %v`, mdb.mainstore.MemoryInUse()))
+		internalData = append(internalData, ",\n")
+		internalData = append(internalData, fmt.Sprintf(`"items_count": %v`, itemsCount))
+		internalData = append(internalData, ",\n")
+		internalData = append(internalData, fmt.Sprintf(`"lastGCSn": %v`, mdb.mainstore.GetLastGCSn()))
+		internalData = append(internalData, ",\n")
+		internalData = append(internalData, fmt.Sprintf(`"currSn": %v`, mdb.mainstore.GetCurrSn()))
+		internalData = append(internalData, "\n}")
 
-	internalData = append(internalData, ",\n")
-	internalData = append(internalData, fmt.Sprintf(`"data_size": %v`, mdb.mainstore.MemoryInUse()))
-	internalData = append(internalData, ",\n")
-	internalData = append(internalData, fmt.Sprintf(`"items_count": %v`, itemsCount))
-	internalData = append(internalData, ",\n")
-	internalData = append(internalData, fmt.Sprintf(`"lastGCSn": %v`, mdb.mainstore.GetLastGCSn()))
-	internalData = append(internalData, ",\n")
-	internalData = append(internalData, fmt.Sprintf(`"currSn": %v`, mdb.mainstore.GetCurrSn()))
-	internalData = append(internalData, "\n}")
-
-	sts.InternalData = internalData
+		sts.InternalData = internalData
+	}
 	sts.DataSize = mdb.mainstore.MemoryInUse()
 	sts.MemUsed = mdb.mainstore.MemoryInUse() + ntMemUsed
 	sts.DiskSize = mdb.diskSize()
@@ -1767,6 +1935,10 @@ func (mdb *memdbSlice) String() string {
 
 func tryDeletememdbSlice(mdb *memdbSlice) {
 
+	if trashDroppedSlice(mdb.idxInstId, mdb.idxPartnId, mdb.path) {
+		return
+	}
+
 	//cleanup the disk directory
 	if err := os.RemoveAll(mdb.path); err != nil {
 		logging.Errorf("MemDBSlice::Destroy Error Cleaning Up Slice Id %v, "+
@@ -1914,6 +2086,9 @@ func (s *memdbSnapshot) Info() SnapshotInfo {
 // Approximate items count
 func (s *memdbSnapshot) StatCountTotal() (uint64, error) {
 	c := s.slice.GetCommittedCount()
+	if n := uint64(s.numOverflowKeys); c > n {
+		c -= n
+	}
 	return c, nil
 }
 
@@ -1921,7 +2096,8 @@ func (s *memdbSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (
 	if s.slice.idxStats.useArrItemsCount {
 		return s.info.IndexStats[SNAP_STATS_ARR_ITEMS_COUNT].(uint64), nil
 	}
-	return uint64(s.info.MainSnap.Count()), nil
+	// Overflow records are not index entries
+	return uint64(s.info.MainSnap.Count() - s.numOverflowKeys), nil
 }
 
 func (s *memdbSnapshot) CountRange(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
@@ -2094,9 +2270,11 @@ func (s *memdbSnapshot) Iterate(ctx IndexReaderContext, low, high IndexKey, incl
 	cmpFn CmpEntry, callback EntryCallback) error {
 	var entry IndexEntry
 	var err error
+	var run overflowRun
 	t0 := time.Now()
 	it := s.info.MainSnap.NewIterator()
 	defer it.Close()
+	defer run.close()
 
 	if low.Bytes() == nil {
 		it.SeekFirst()
@@ -2105,7 +2283,7 @@ func (s *memdbSnapshot) Iterate(ctx IndexReaderContext, low, high IndexKey, incl
 
 		// Discard equal keys if low inclusion is requested
 		if inclusion == Neither || inclusion == High {
-			err = s.iterEqualKeys(low, it, cmpFn, nil)
+			err = s.iterEqualKeys(low, it, cmpFn, nil, &run)
 			if err != nil {
 				return err
 			}
@@ -2116,6 +2294,9 @@ func (s *memdbSnapshot) Iterate(ctx IndexReaderContext, low, high IndexKey, incl
 loop:
 	for it.Valid() {
 		itm := it.Get()
+		if s.isOverflowArea(itm) {
+			break loop
+		}
 		s.newIndexEntry(itm, &entry)
 
 		// Iterator has reached past the high key, no need to scan further
@@ -2123,7 +2304,7 @@ loop:
 			break loop
 		}
 
-		err = callback(entry.Bytes())
+		err = s.emitEntry(itm, &run, callback)
 		if err != nil {
 			return err
 		}
@@ -2133,19 +2314,25 @@ loop:
 
 	// Include equal keys if high inclusion is requested
 	if inclusion == Both || inclusion == High {
-		err = s.iterEqualKeys(high, it, cmpFn, callback)
+		err = s.iterEqualKeys(high, it, cmpFn, callback, &run)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return run.flush(callback)
 }
 
 func (s *memdbSnapshot) isPrimary() bool {
 	return s.slice.isPrimary
 }
 
+// isOverflowArea returns whether itm is an overflow record, which sort
+// after all the entries of a secondary index.
+func (s *memdbSnapshot) isOverflowArea(itm []byte) bool {
+	return !s.slice.isPrimary && isOverflowRecord(itm)
+}
+
 func (s *memdbSnapshot) newIndexEntry(b []byte, entry *IndexEntry) {
 	var err error
 
@@ -2158,16 +2345,19 @@ func (s *memdbSnapshot) newIndexEntry(b []byte, entry *IndexEntry) {
 }
 
 func (s *memdbSnapshot) iterEqualKeys(k IndexKey, it *memdb.Iterator,
-	cmpFn CmpEntry, callback func([]byte) error) error {
+	cmpFn CmpEntry, callback func([]byte) error, run *overflowRun) error {
 	var err error
 
 	var entry IndexEntry
 	for ; it.Valid(); it.Next() {
 		itm := it.Get()
+		if s.isOverflowArea(itm) {
+			break
+		}
 		s.newIndexEntry(itm, &entry)
 		if cmpFn(k, entry) == 0 {
 			if callback != nil {
-				err = callback(itm)
+				err = s.emitEntry(itm, run, callback)
 				if err != nil {
 					return err
 				}
@@ -3,6 +3,7 @@ package indexer
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"runtime"
@@ -174,3 +175,71 @@ func TestMemDBInsertionPerf(t *testing.T) {
 		}
 	}
 }
+
+func TestMemDBPersistQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdbpersist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stats := &IndexStats{}
+	stats.Init()
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	cfg.SetValue("numSliceWriters", 1)
+	cfg.SetValue("moi.persistence.queue_size", 1)
+	idxDefn := common.IndexDefn{DefnId: common.IndexDefnId(1)}
+	slice, err := NewMemDBSlice(dir, SliceId(0), idxDefn, common.IndexInstId(1),
+		common.PartitionId(0), false, true, 1, cfg, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	// Snapshots are created without waiting for the previous ones to be
+	// persisted, and only the latest queued snapshot is kept.
+	numSnapshots := 10
+	for i := 1; i <= numSnapshots; i++ {
+		meta := NewMutationMeta()
+		slice.Insert([]byte(fmt.Sprintf(`["key-%d"]`, i)), []byte(fmt.Sprintf("docid-%d", i)), meta)
+		meta.Free()
+
+		ts := common.NewTsVbuuid("default", 8)
+		ts.Seqnos[0] = uint64(i)
+		ts.SetSnapType(common.DISK_SNAP_OSO) // no cluster seqnos to clean up disk snapshots
+
+		info, err := slice.NewSnapshot(ts, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap, err := slice.OpenSnapshot(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.Close()
+
+		if backlog := stats.diskSnapPersistBacklog.Value(); backlog > 1 {
+			t.Fatalf("expected at most 1 snapshot queued, got %v", backlog)
+		}
+	}
+
+	// The latest snapshot is always persisted
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		infos, err := slice.GetSnapshots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != 0 && infos[0].Timestamp().Seqnos[0] == uint64(numSnapshots) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected snapshot %v persisted, got %v", numSnapshots, infos)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if backlog := stats.diskSnapPersistBacklog.Value(); backlog != 0 {
+		t.Fatalf("expected no snapshot queued, got %v", backlog)
+	}
+}
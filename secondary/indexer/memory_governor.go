@@ -0,0 +1,241 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/stubs/nitro/mm"
+	"github.com/couchbase/indexing/secondary/stubs/nitro/plasma"
+)
+
+// The storage cache, the mutation queues and the scan buffers each size themselves from the
+// indexer memory quota, unaware of each other. With memory_governor.enabled, the memory governor
+// arbitrates between them: every memory_governor.interval it compares the memory used by the
+// indexer with the quota and, above memory_governor.high_frac of it, shrinks the budgets of the
+// consumers using more than their share of the quota, in proportion to their excess, until the
+// memory used is expected back at memory_governor.low_frac. Budgets are shrunk down to
+// memory_governor.min_scale of their share, and grow back by memory_governor.grow_step per
+// interval once the memory used is below memory_governor.low_frac.
+//
+// The budgets are published as scales of the share of each consumer, applied by
+// plasmaMemoryQuota to the plasma memory quota, by the mutation queues to their maximum memory
+// and by queueSize to the rows buffered per partition scan. MOI cannot evict, so its storage
+// budget only releases freed memory to the OS.
+
+type memoryConsumer int
+
+const (
+	memStorage memoryConsumer = iota
+	memQueue
+	memScan
+	numMemoryConsumers
+)
+
+func (c memoryConsumer) String() string {
+	switch c {
+	case memStorage:
+		return "storage"
+	case memQueue:
+		return "queue"
+	case memScan:
+		return "scan"
+	}
+	return "unknown"
+}
+
+// memoryBudgetScales are the budget scales of the consumers in permille.
+var memoryBudgetScales = [numMemoryConsumers]int64{1000, 1000, 1000}
+
+// scanBufferRows is the number of rows of the queues of the running partition scans.
+var scanBufferRows int64
+
+func memoryBudgetScale(c memoryConsumer) float64 {
+	return float64(atomic.LoadInt64(&memoryBudgetScales[c])) / 1000
+}
+
+// scaleMemoryBudget scales budget by the budget scale of c.
+func scaleMemoryBudget(budget int64, c memoryConsumer) int64 {
+	scale := atomic.LoadInt64(&memoryBudgetScales[c])
+	if scale == 1000 {
+		return budget
+	}
+	return budget * scale / 1000
+}
+
+// plasmaMemoryQuota returns the plasma memory quota for the indexer memory quota.
+func plasmaMemoryQuota(memQuota int64) int64 {
+	return int64(float64(memQuota) * PLASMA_MEMQUOTA_FRAC * memoryBudgetScale(memStorage))
+}
+
+type memoryGovernorConfig struct {
+	highFrac float64
+	lowFrac  float64
+	minScale float64
+	growStep float64
+}
+
+func newMemoryGovernorConfig(config common.Config) memoryGovernorConfig {
+	return memoryGovernorConfig{
+		highFrac: config["memory_governor.high_frac"].Float64(),
+		lowFrac:  config["memory_governor.low_frac"].Float64(),
+		minScale: config["memory_governor.min_scale"].Float64(),
+		growStep: config["memory_governor.grow_step"].Float64(),
+	}
+}
+
+// arbitrateMemory returns the budget scales of the consumers given the memory used in total
+// and by each consumer, their shares of the quota and their current scales.
+func arbitrateMemory(quota, used int64, usage, shares [numMemoryConsumers]int64,
+	scales [numMemoryConsumers]float64, cfg memoryGovernorConfig) [numMemoryConsumers]float64 {
+
+	if quota <= 0 {
+		return scales
+	}
+
+	if float64(used) < cfg.lowFrac*float64(quota) {
+		for c := range scales {
+			scales[c] = math.Min(scales[c]+cfg.growStep, 1)
+		}
+		return scales
+	}
+
+	if float64(used) <= cfg.highFrac*float64(quota) {
+		return scales
+	}
+
+	// Consumers over their share give back the excess; if none is, all of them shrink
+	var excess [numMemoryConsumers]int64
+	var total int64
+	for c := range usage {
+		if usage[c] > shares[c] {
+			excess[c] = usage[c] - shares[c]
+			total += excess[c]
+		}
+	}
+	if total == 0 {
+		excess = usage
+		for c := range usage {
+			total += usage[c]
+		}
+	}
+	if total == 0 {
+		return scales
+	}
+
+	target := used - int64(cfg.lowFrac*float64(quota))
+	for c := range scales {
+		if excess[c] == 0 || shares[c] <= 0 {
+			continue
+		}
+
+		cut := float64(target) * float64(excess[c]) / float64(total)
+		budget := math.Max(float64(usage[c])-cut, 0)
+		scale := math.Max(budget/float64(shares[c]), cfg.minScale)
+		scales[c] = math.Min(scales[c], scale)
+	}
+	return scales
+}
+
+// governMemory periodically arbitrates the memory quota between the storage cache, the mutation
+// queues and the scan buffers.
+func (idx *indexer) governMemory() {
+
+	logging.Infof("Indexer::governMemory started...")
+
+	for {
+		interval := time.Duration(idx.config["memory_governor.interval"].Int()) * time.Second
+		if interval <= 0 {
+			interval = time.Second
+		}
+		time.Sleep(interval)
+
+		enabled := idx.config["memory_governor.enabled"].Bool()
+		if !enabled && memoryBudgetScale(memStorage) == 1 && memoryBudgetScale(memQueue) == 1 &&
+			memoryBudgetScale(memScan) == 1 {
+			continue
+		}
+
+		quota := int64(idx.config.GetIndexerMemoryQuota())
+		used, _, storage := idx.memoryUsed(false)
+
+		var usage, shares [numMemoryConsumers]int64
+		usage[memStorage] = int64(storage)
+		usage[memQueue] = idx.stats.memoryUsedQueue.Value()
+		usage[memScan] = atomic.LoadInt64(&scanBufferRows) * int64(ScanBufPoolSize)
+
+		shares[memQueue] = int64(getMutationQueueMemFrac(idx.config) * float64(quota))
+		maxQueueMem := int64(idx.config["mutation_manager.maxQueueMem"].Uint64())
+		if shares[memQueue] > maxQueueMem {
+			shares[memQueue] = maxQueueMem
+		}
+		shares[memScan] = int64(idx.config["memory_governor.scan_frac"].Float64() * float64(quota))
+		shares[memStorage] = int64(float64(quota) * PLASMA_MEMQUOTA_FRAC)
+
+		var scales [numMemoryConsumers]float64
+		for c := range scales {
+			scales[c] = memoryBudgetScale(memoryConsumer(c))
+		}
+
+		var newScales [numMemoryConsumers]float64
+		if enabled {
+			newScales = arbitrateMemory(quota, int64(used), usage, shares, scales,
+				newMemoryGovernorConfig(idx.config))
+		} else {
+			newScales = [numMemoryConsumers]float64{1, 1, 1}
+		}
+
+		idx.setMemoryBudgetScales(quota, used, usage, scales, newScales)
+	}
+}
+
+// setMemoryBudgetScales publishes the new budget scales and requests the consumers to shrink.
+func (idx *indexer) setMemoryBudgetScales(quota int64, used uint64,
+	usage [numMemoryConsumers]int64, scales, newScales [numMemoryConsumers]float64) {
+
+	changed := false
+	for c := range newScales {
+		permille := int64(math.Round(newScales[c] * 1000))
+		if permille == atomic.LoadInt64(&memoryBudgetScales[c]) {
+			continue
+		}
+
+		atomic.StoreInt64(&memoryBudgetScales[c], permille)
+		changed = true
+
+		if newScales[c] < scales[c] {
+			idx.stats.numMemoryShrinks.Add(1)
+			logging.Infof("Indexer::governMemory memory used %v quota %v: shrink %v budget"+
+				" to %.0f%% of its share, using %v", used, quota, memoryConsumer(c),
+				newScales[c]*100, usage[c])
+		}
+	}
+
+	idx.stats.memoryBudgetStorage.Set(atomic.LoadInt64(&memoryBudgetScales[memStorage]) / 10)
+	idx.stats.memoryBudgetQueue.Set(atomic.LoadInt64(&memoryBudgetScales[memQueue]) / 10)
+	idx.stats.memoryBudgetScan.Set(atomic.LoadInt64(&memoryBudgetScales[memScan]) / 10)
+	idx.stats.memoryUsedScan.Set(usage[memScan])
+
+	if !changed {
+		return
+	}
+
+	switch common.GetStorageMode() {
+	case common.PLASMA:
+		plasma.SetMemoryQuota(plasmaMemoryQuota(quota))
+	case common.MOI:
+		if newScales[memStorage] < scales[memStorage] {
+			mm.FreeOSMemory()
+		}
+	}
+}
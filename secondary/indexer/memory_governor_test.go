@@ -0,0 +1,95 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+)
+
+func TestArbitrateMemory(t *testing.T) {
+	cfg := memoryGovernorConfig{highFrac: 0.95, lowFrac: 0.85, minScale: 0.25, growStep: 0.05}
+	full := [numMemoryConsumers]float64{1, 1, 1}
+
+	const quota = 1000
+	var shares [numMemoryConsumers]int64
+	shares[memStorage] = 800
+	shares[memQueue] = 100
+	shares[memScan] = 100
+
+	// Within the marks, nothing changes
+	usage := [numMemoryConsumers]int64{700, 100, 50}
+	if scales := arbitrateMemory(quota, 900, usage, shares, full, cfg); scales != full {
+		t.Fatalf("expected budgets unchanged, got %v", scales)
+	}
+
+	// Only the queues are over their share, they give back the excess: 150 to get back
+	// to 850 used leaves them their share
+	usage = [numMemoryConsumers]int64{750, 250, 0}
+	scales := arbitrateMemory(quota, 1000, usage, shares, full, cfg)
+	if scales[memStorage] != 1 || scales[memScan] != 1 {
+		t.Fatalf("expected storage and scan budgets unchanged, got %v", scales)
+	}
+	if math.Abs(scales[memQueue]-1) > 1e-9 {
+		t.Fatalf("expected queue budget at its share, got %v", scales[memQueue])
+	}
+
+	usage = [numMemoryConsumers]int64{750, 400, 0}
+	scales = arbitrateMemory(quota, 1300, usage, shares, full, cfg)
+	if scales[memQueue] != cfg.minScale {
+		t.Fatalf("expected queue budget at minimum, got %v", scales[memQueue])
+	}
+
+	// Storage and scans are over their shares, they shrink in proportion to their excess
+	usage = [numMemoryConsumers]int64{900, 50, 200}
+	scales = arbitrateMemory(quota, 1150, usage, shares, full, cfg)
+	if scales[memQueue] != 1 {
+		t.Fatalf("expected queue budget unchanged, got %v", scales[memQueue])
+	}
+	if expected := (900 - 300*100/200.0) / 800; math.Abs(scales[memStorage]-expected) > 1e-9 {
+		t.Fatalf("expected storage budget %v, got %v", expected, scales[memStorage])
+	}
+	if expected := (200 - 300*100/200.0) / 100; math.Abs(scales[memScan]-expected) > 1e-9 {
+		t.Fatalf("expected scan budget %v, got %v", expected, scales[memScan])
+	}
+
+	// Budgets never grow while memory is high
+	shrunk := [numMemoryConsumers]float64{0.5, 0.5, 0.5}
+	usage = [numMemoryConsumers]int64{700, 150, 150}
+	if scales = arbitrateMemory(quota, 1000, usage, shares, shrunk, cfg); scales[memQueue] != 0.5 {
+		t.Fatalf("expected queue budget kept shrunk, got %v", scales[memQueue])
+	}
+
+	// Below the low mark, budgets grow back
+	usage = [numMemoryConsumers]int64{500, 50, 50}
+	scales = shrunk
+	for i := 0; i < 20; i++ {
+		scales = arbitrateMemory(quota, 600, usage, shares, scales, cfg)
+	}
+	if scales != full {
+		t.Fatalf("expected budgets grown back, got %v", scales)
+	}
+}
+
+func TestScaleMemoryBudget(t *testing.T) {
+	defer atomic.StoreInt64(&memoryBudgetScales[memQueue], 1000)
+
+	if budget := scaleMemoryBudget(1000, memQueue); budget != 1000 {
+		t.Fatalf("expected full budget, got %v", budget)
+	}
+
+	atomic.StoreInt64(&memoryBudgetScales[memQueue], 250)
+	if budget := scaleMemoryBudget(1000, memQueue); budget != 250 {
+		t.Fatalf("expected shrunk budget, got %v", budget)
+	}
+	if budget := scaleMemoryBudget(1000, memStorage); budget != 1000 {
+		t.Fatalf("expected storage budget unchanged, got %v", budget)
+	}
+}
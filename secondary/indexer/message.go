@@ -40,6 +40,7 @@ const (
 	STREAM_READER_HWT
 	STREAM_READER_SYSTEM_EVENT
 	STREAM_READER_OSO_SNAPSHOT_MARKER
+	STREAM_READER_EVAL_SKIP
 
 	//MUTATION_MANAGER
 	MUT_MGR_PERSIST_MUTATION_QUEUE
@@ -50,6 +51,7 @@ const (
 	MUT_MGR_SHUTDOWN
 	MUT_MGR_FLUSH_DONE
 	MUT_MGR_ABORT_DONE
+	MUT_MGR_PURGE_INDEXES
 
 	//TIMEKEEPER
 	TK_SHUTDOWN
@@ -73,6 +75,7 @@ const (
 	STORAGE_INDEX_MERGE_SNAPSHOT
 	STORAGE_INDEX_PRUNE_SNAPSHOT
 	STORAGE_UPDATE_SNAP_MAP
+	STORAGE_PURGE_INDEX_SNAPSHOTS
 
 	//KVSender
 	KV_SENDER_SHUTDOWN
@@ -141,6 +144,7 @@ const (
 	INDEXER_SECURITY_CHANGE
 	INDEXER_RESET_INDEX_DONE
 	INDEXER_ACTIVE
+	INDEXER_KV_INGEST_DONE
 
 	//SCAN COORDINATOR
 	SCAN_COORD_SHUTDOWN
@@ -354,6 +358,35 @@ func (m *MsgStream) String() string {
 
 }
 
+// STREAM_READER_EVAL_SKIP
+// A mutation the projector did not evaluate for an index instance
+type MsgEvalSkip struct {
+	streamId common.StreamId
+	meta     *MutationMeta
+	instId   common.IndexInstId
+}
+
+func (m *MsgEvalSkip) GetMsgType() MsgType {
+	return STREAM_READER_EVAL_SKIP
+}
+
+func (m *MsgEvalSkip) GetStreamId() common.StreamId {
+	return m.streamId
+}
+
+func (m *MsgEvalSkip) GetMutationMeta() *MutationMeta {
+	return m.meta
+}
+
+func (m *MsgEvalSkip) GetInstId() common.IndexInstId {
+	return m.instId
+}
+
+func (m *MsgEvalSkip) String() string {
+	return fmt.Sprintf("\n\tMessage: MsgEvalSkip\n\tStreamId: %v\n\tMeta: %v\n\tInstId: %v",
+		m.streamId, m.meta, m.instId)
+}
+
 //Stream Error Message
 type MsgStreamError struct {
 	streamId common.StreamId
@@ -667,12 +700,18 @@ func (m *MsgMutMgrGetTimestamp) GetStreamId() common.StreamId {
 }
 
 //UPDATE_INDEX_INSTANCE_MAP
+//If delta is set, updatedInsts and deletedInstIds are all the changes of
+//indexInstMap since the message of baseVersion, so that a worker which has
+//applied that message can apply only them instead of copying indexInstMap.
 type MsgUpdateInstMap struct {
 	indexInstMap   common.IndexInstMap
 	stats          *IndexerStats
 	rollbackTimes  map[string]int64
 	updatedInsts   common.IndexInstList
-	deletedInstIds []common.IndexInstId // used only for logging
+	deletedInstIds []common.IndexInstId
+	delta          bool
+	version        uint64 // 0 if not distributed to all workers
+	baseVersion    uint64
 }
 
 func (m *MsgUpdateInstMap) GetMsgType() MsgType {
@@ -707,6 +746,16 @@ func (m *MsgUpdateInstMap) AppendDeletedInstIds(instIds []common.IndexInstId) {
 	m.deletedInstIds = append(m.deletedInstIds, instIds...)
 }
 
+func (m *MsgUpdateInstMap) GetVersion() uint64 {
+	return m.version
+}
+
+//CanApplyDelta returns true if a worker holding the instance map of version
+//can apply the message as a delta.
+func (m *MsgUpdateInstMap) CanApplyDelta(version uint64) bool {
+	return m.delta && version != 0 && m.baseVersion == version
+}
+
 func (m *MsgUpdateInstMap) String() string {
 
 	str := "\n\tMessage: MsgUpdateInstMap"
@@ -852,6 +901,7 @@ type MsgMutMgrFlushDone struct {
 	streamId   common.StreamId
 	keyspaceId string
 	aborted    bool
+	abortCause string
 	hasAllSB   bool
 }
 
@@ -875,6 +925,10 @@ func (m *MsgMutMgrFlushDone) GetAborted() bool {
 	return m.aborted
 }
 
+func (m *MsgMutMgrFlushDone) GetAbortCause() string {
+	return m.abortCause
+}
+
 func (m *MsgMutMgrFlushDone) HasAllSB() bool {
 	return m.hasAllSB
 }
@@ -886,6 +940,9 @@ func (m *MsgMutMgrFlushDone) String() string {
 	str += fmt.Sprintf("\n\tKeyspaceId: %v", m.keyspaceId)
 	str += fmt.Sprintf("\n\tTS: %v", m.ts)
 	str += fmt.Sprintf("\n\tAborted: %v", m.aborted)
+	if m.aborted {
+		str += fmt.Sprintf("\n\tAbortCause: %v", m.abortCause)
+	}
 	return str
 
 }
@@ -1684,6 +1741,22 @@ func (m *MsgPoolChange) GetKeyspaceId() string {
 	return m.keyspaceId
 }
 
+//INDEXER_KV_INGEST_DONE
+type MsgKVIngestDone struct {
+	streamId   common.StreamId
+	keyspaceId string
+	instIdList []common.IndexInstId
+	cid        string
+	clusterVer uint64
+	buildTs    Timestamp
+	restartTs  *common.TsVbuuid
+	err        error
+}
+
+func (m *MsgKVIngestDone) GetMsgType() MsgType {
+	return INDEXER_KV_INGEST_DONE
+}
+
 //TK_INIT_BUILD_DONE
 //TK_INIT_BUILD_DONE_ACK
 //TK_INIT_BUILD_DONE_NO_CATCHUP_ACK
@@ -1728,6 +1801,7 @@ func (m *MsgTKInitBuildDone) GetSessionId() uint64 {
 
 type MsgIndexSnapRequest struct {
 	ts          *common.TsVbuuid
+	vbnos       []uint16 // vbuckets of a partial at_plus ts, nil for all
 	cons        common.Consistency
 	idxInstId   common.IndexInstId
 	expiredTime time.Time
@@ -1744,6 +1818,10 @@ func (m *MsgIndexSnapRequest) GetTS() *common.TsVbuuid {
 	return m.ts
 }
 
+func (m *MsgIndexSnapRequest) GetVbnos() []uint16 {
+	return m.vbnos
+}
+
 func (m *MsgIndexSnapRequest) GetConsistency() common.Consistency {
 	return m.cons
 }
@@ -1829,6 +1907,36 @@ func (m *MsgUpdateSnapMap) GetKeyspaceId() string {
 	return m.keyspaceId
 }
 
+//MUT_MGR_PURGE_INDEXES
+//STORAGE_PURGE_INDEX_SNAPSHOTS
+type MsgPurgeIndexes struct {
+	mType      MsgType
+	streamId   common.StreamId
+	keyspaceId string
+	instIds    []common.IndexInstId
+}
+
+func (m *MsgPurgeIndexes) GetMsgType() MsgType {
+	return m.mType
+}
+
+func (m *MsgPurgeIndexes) GetStreamId() common.StreamId {
+	return m.streamId
+}
+
+func (m *MsgPurgeIndexes) GetKeyspaceId() string {
+	return m.keyspaceId
+}
+
+func (m *MsgPurgeIndexes) GetInstIds() []common.IndexInstId {
+	return m.instIds
+}
+
+func (m *MsgPurgeIndexes) String() string {
+	return fmt.Sprintf("%v StreamId: %v KeyspaceId: %v InstIds: %v",
+		m.mType, m.streamId, m.keyspaceId, m.instIds)
+}
+
 type MsgIndexStorageStats struct {
 	respch chan []IndexStorageStats
 	spec   *statsSpec
@@ -2334,6 +2442,8 @@ func (m MsgType) String() string {
 		return "STREAM_READER_SYSTEM_EVENT"
 	case STREAM_READER_OSO_SNAPSHOT_MARKER:
 		return "STREAM_READER_OSO_SNAPSHOT_MARKER"
+	case STREAM_READER_EVAL_SKIP:
+		return "STREAM_READER_EVAL_SKIP"
 
 	case MUT_MGR_PERSIST_MUTATION_QUEUE:
 		return "MUT_MGR_PERSIST_MUTATION_QUEUE"
@@ -2351,6 +2461,8 @@ func (m MsgType) String() string {
 		return "MUT_MGR_FLUSH_DONE"
 	case MUT_MGR_ABORT_DONE:
 		return "MUT_MGR_ABORT_DONE"
+	case MUT_MGR_PURGE_INDEXES:
+		return "MUT_MGR_PURGE_INDEXES"
 
 	case TK_SHUTDOWN:
 		return "TK_SHUTDOWN"
@@ -2451,6 +2563,8 @@ func (m MsgType) String() string {
 		return "INDEXER_RESET_INDEX_DONE"
 	case INDEXER_ACTIVE:
 		return "INDEXER_ACTIVE"
+	case INDEXER_KV_INGEST_DONE:
+		return "INDEXER_KV_INGEST_DONE"
 
 	case SCAN_COORD_SHUTDOWN:
 		return "SCAN_COORD_SHUTDOWN"
@@ -2553,6 +2667,8 @@ func (m MsgType) String() string {
 		return "STORAGE_INDEX_PRUNE_SNAPSHOT"
 	case STORAGE_UPDATE_SNAP_MAP:
 		return "STORAGE_UPDATE_SNAP_MAP"
+	case STORAGE_PURGE_INDEX_SNAPSHOTS:
+		return "STORAGE_PURGE_INDEX_SNAPSHOTS"
 
 	case CONFIG_SETTINGS_UPDATE:
 		return "CONFIG_SETTINGS_UPDATE"
@@ -0,0 +1,210 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Metadata persisted by the indexer itself (the IndexInstMap, when the
+// index manager is not enabled) can be encrypted with AES-GCM. Encrypted
+// payloads are framed as
+//
+//	metaEncryptMagic | len(keyId) | keyId | nonce | ciphertext
+//
+// so that they are told apart from plain gob payloads, which are read
+// as is, and are decrypted with the key they were written with after the
+// active key is rotated.
+//
+// The keys are provisioned on the node, outside the data and the config
+// of the indexer, and are never written by it. While encryption is
+// enabled, the instance map is encrypted with the active key on each of
+// its updates. An update fails, rather than store the map in plain, if it
+// cannot be encrypted.
+
+var metaEncryptMagic = []byte("GSIENC1")
+
+const metaKeySize = 32 // AES-256
+
+var ErrMetaKeyNotFound = errors.New("Metadata encryption key not found")
+var ErrMetaDecrypt = errors.New("Metadata decryption failed")
+
+// metaKeyRing provides the keys used to encrypt and decrypt metadata.
+type metaKeyRing interface {
+	// ActiveKey returns the key to encrypt with.
+	ActiveKey() (id string, key []byte, err error)
+	// Key returns the key with the given id, to decrypt with.
+	Key(id string) ([]byte, error)
+}
+
+func encryptMeta(ring metaKeyRing, plain []byte) ([]byte, error) {
+	id, key, err := ring.ActiveKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("Metadata key id %v too long", id)
+	}
+
+	gcm, err := newMetaGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(metaEncryptMagic)
+	buf.WriteByte(byte(len(id)))
+	buf.WriteString(id)
+	buf.Write(nonce)
+	// The framing is authenticated along with the payload
+	return gcm.Seal(buf.Bytes(), nonce, plain, buf.Bytes()), nil
+}
+
+// encryptMetaIfEnabled encrypts data if metadata encryption is enabled in
+// config, and returns it as is otherwise.
+func encryptMetaIfEnabled(config common.Config, data []byte) ([]byte, error) {
+	if !config["settings.metadata_encryption.enable"].Bool() {
+		return data, nil
+	}
+	return encryptMeta(newFileKeyRing(config), data)
+}
+
+// decryptMeta returns data as is if it is not encrypted.
+func decryptMeta(ring metaKeyRing, data []byte) ([]byte, error) {
+	if !isEncryptedMeta(data) {
+		return data, nil
+	}
+
+	rest := data[len(metaEncryptMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return nil, ErrMetaDecrypt
+	}
+	idLen := int(rest[0])
+	id := string(rest[1 : 1+idLen])
+	rest = rest[1+idLen:]
+
+	key, err := ring.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newMetaGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrMetaDecrypt
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	header := data[:len(data)-len(ciphertext)]
+
+	plain, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, ErrMetaDecrypt
+	}
+	return plain, nil
+}
+
+func isEncryptedMeta(data []byte) bool {
+	return bytes.HasPrefix(data, metaEncryptMagic)
+}
+
+func newMetaGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/////////////////////////////////////////////////////////////////////////
+// key file
+/////////////////////////////////////////////////////////////////////////
+
+// fileKeyRing takes the keys from a JSON file provisioned on the node,
+// settings.metadata_encryption.keys_file, of the form
+//
+//	{"active": "<id>", "keys": {"<id>": "<base64 of 32 bytes>", ...}}
+//
+// Keys are rotated by adding a key to the file and making it active, with
+// the older keys kept in the file for what was written with them. The
+// file is read on each use, so rotations apply without a restart.
+type fileKeyRing struct {
+	path string
+}
+
+type metaKeysFile struct {
+	Active string            `json:"active"`
+	Keys   map[string][]byte `json:"keys"`
+}
+
+func newFileKeyRing(config common.Config) fileKeyRing {
+	return fileKeyRing{path: config["settings.metadata_encryption.keys_file"].String()}
+}
+
+func (r fileKeyRing) load() (*metaKeysFile, error) {
+	if r.path == "" {
+		return nil, ErrMetaKeyNotFound
+	}
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	keys := &metaKeysFile{}
+	if err := json.Unmarshal(data, keys); err != nil {
+		return nil, fmt.Errorf("Invalid metadata keys file %v: %v", r.path, err)
+	}
+	return keys, nil
+}
+
+func (r fileKeyRing) ActiveKey() (string, []byte, error) {
+	keys, err := r.load()
+	if err != nil {
+		return "", nil, err
+	}
+	if keys.Active == "" {
+		return "", nil, ErrMetaKeyNotFound
+	}
+	key, err := keys.find(keys.Active)
+	return keys.Active, key, err
+}
+
+func (r fileKeyRing) Key(id string) ([]byte, error) {
+	keys, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return keys.find(id)
+}
+
+func (f *metaKeysFile) find(id string) ([]byte, error) {
+	key, ok := f.Keys[id]
+	if !ok {
+		return nil, ErrMetaKeyNotFound
+	}
+	if len(key) != metaKeySize {
+		return nil, fmt.Errorf("Metadata key %v is %v bytes, expected %v", id, len(key), metaKeySize)
+	}
+	return key, nil
+}
@@ -0,0 +1,179 @@
+package indexer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// memKeyRing is an in memory metaKeyRing for tests.
+type memKeyRing struct {
+	ids  []string
+	keys map[string][]byte
+}
+
+func (r *memKeyRing) ActiveKey() (string, []byte, error) {
+	if len(r.ids) == 0 {
+		if _, err := r.Rotate(); err != nil {
+			return "", nil, err
+		}
+	}
+	id := r.ids[len(r.ids)-1]
+	return id, r.keys[id], nil
+}
+
+func (r *memKeyRing) Key(id string) ([]byte, error) {
+	if key, ok := r.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrMetaKeyNotFound
+}
+
+func (r *memKeyRing) Rotate() (string, error) {
+	key := make([]byte, metaKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	if r.keys == nil {
+		r.keys = make(map[string][]byte)
+	}
+	id := fmt.Sprintf("key%d", len(r.ids))
+	r.ids = append(r.ids, id)
+	r.keys[id] = key
+	return id, nil
+}
+
+func TestMetaEncryptionRoundTrip(t *testing.T) {
+	ring := &memKeyRing{}
+	plain := []byte("index inst map")
+
+	enc, err := encryptMeta(ring, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedMeta(enc) || bytes.Contains(enc, plain) {
+		t.Fatalf("Payload not encrypted")
+	}
+
+	// Payloads written with an older key remain readable after rotation
+	if _, err := ring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := decryptMeta(ring, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, plain) {
+		t.Errorf("Expected %s, found %s", plain, dec)
+	}
+
+	enc[len(enc)-1] ^= 0xff
+	if _, err := decryptMeta(ring, enc); err != ErrMetaDecrypt {
+		t.Errorf("Expected %v on tampered payload, found %v", ErrMetaDecrypt, err)
+	}
+}
+
+func TestMetaEncryptionPlainPassthrough(t *testing.T) {
+	plain := []byte("gob encoded map")
+	dec, err := decryptMeta(&memKeyRing{}, plain)
+	if err != nil || !bytes.Equal(dec, plain) {
+		t.Errorf("Expected plain payload as is, found %s, err %v", dec, err)
+	}
+}
+
+func TestMetaEncryptionKeysFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metakeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "keys.json")
+	config := common.Config{
+		"settings.metadata_encryption.keys_file": common.ConfigValue{Value: file},
+	}
+	ring := newFileKeyRing(config)
+
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, metaKeySize))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, metaKeySize))
+	writeKeys := func(keys string) {
+		if err := ioutil.WriteFile(file, []byte(keys), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeKeys(fmt.Sprintf(`{"active": "k1", "keys": {"k1": %q}}`, key1))
+	plain := []byte("index inst map")
+	enc, err := encryptMeta(ring, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated by a new active key, with the older one kept in the file
+	writeKeys(fmt.Sprintf(`{"active": "k2", "keys": {"k1": %q, "k2": %q}}`, key1, key2))
+	if id, _, err := ring.ActiveKey(); err != nil || id != "k2" {
+		t.Fatalf("Expected active key k2, found %v, err %v", id, err)
+	}
+	if dec, err := decryptMeta(ring, enc); err != nil || !bytes.Equal(dec, plain) {
+		t.Fatalf("Expected %s, found %s, err %v", plain, dec, err)
+	}
+
+	writeKeys(fmt.Sprintf(`{"active": "k2", "keys": {"k2": %q}}`, key2))
+	if _, err := decryptMeta(ring, enc); err != ErrMetaKeyNotFound {
+		t.Errorf("Expected %v once the key is retired, found %v", ErrMetaKeyNotFound, err)
+	}
+
+	writeKeys(`{"active": "k3", "keys": {"k3": "c2hvcnQ="}}`)
+	if _, _, err := ring.ActiveKey(); err == nil {
+		t.Errorf("Expected key of the wrong size rejected")
+	}
+
+	if _, _, err := newFileKeyRing(common.Config{
+		"settings.metadata_encryption.keys_file": common.ConfigValue{Value: ""},
+	}).ActiveKey(); err != ErrMetaKeyNotFound {
+		t.Errorf("Expected %v without a keys file, found %v", ErrMetaKeyNotFound, err)
+	}
+}
+
+func TestMetaEncryptionNoPlainFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metakeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := common.Config{
+		"settings.metadata_encryption.enable":    common.ConfigValue{Value: true},
+		"settings.metadata_encryption.keys_file": common.ConfigValue{Value: filepath.Join(dir, "keys.json")},
+	}
+	plain := []byte("index inst map")
+
+	// Without the keys file the map is not returned in plain
+	if data, err := encryptMetaIfEnabled(config, plain); err == nil {
+		t.Fatalf("Expected an error without keys, found %s", data)
+	}
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, metaKeySize))
+	keys := fmt.Sprintf(`{"active": "k1", "keys": {"k1": %q}}`, key)
+	if err := ioutil.WriteFile(config["settings.metadata_encryption.keys_file"].String(),
+		[]byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := encryptMetaIfEnabled(config, plain)
+	if err != nil || !isEncryptedMeta(data) {
+		t.Fatalf("Expected the map encrypted, found %s, err %v", data, err)
+	}
+
+	config["settings.metadata_encryption.enable"] = common.ConfigValue{Value: false}
+	if data, err := encryptMetaIfEnabled(config, plain); err != nil || !bytes.Equal(data, plain) {
+		t.Fatalf("Expected the map in plain while disabled, found %s, err %v", data, err)
+	}
+}
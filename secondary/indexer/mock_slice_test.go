@@ -0,0 +1,426 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+var errMockUnknownSnapshot = errors.New("MockSlice: unknown snapshot")
+
+// MockSlice is an in-memory Slice for unit tests of the storage manager,
+// standing in for forestdb, plasma or memdb slices. It keeps the docs
+// written to it, and a snapshot of them at each timestamp it is snapshotted
+// at. A rollback restores the docs of the snapshot and discards the newer
+// ones. Failures are injected by setting the *Err fields.
+type MockSlice struct {
+	mu sync.Mutex
+
+	id     SliceId
+	instId common.IndexInstId
+	defnId common.IndexDefnId
+	status SliceStatus
+	active bool
+	dirty  bool
+
+	docs           map[string][]byte   // docid to key
+	infos          []*mockSnapshotInfo // latest first, like GetSnapshots
+	lastRollbackTs *common.TsVbuuid
+
+	numFlushes         int
+	numCommits         int
+	numRollbacks       int
+	numRollbacksToZero int
+	refs               int32
+	closed             bool
+	destroyed          bool
+
+	newSnapshotErr    error
+	openSnapshotErr   error
+	getSnapshotsErr   error
+	rollbackErr       error
+	rollbackToZeroErr error
+}
+
+func NewMockSlice(id SliceId, instId common.IndexInstId, defnId common.IndexDefnId) *MockSlice {
+	return &MockSlice{
+		id:     id,
+		instId: instId,
+		defnId: defnId,
+		status: SLICE_STATUS_ACTIVE,
+		active: true,
+		docs:   make(map[string][]byte),
+		refs:   1,
+	}
+}
+
+func (s *MockSlice) Id() SliceId                     { return s.id }
+func (s *MockSlice) Path() string                    { return "" }
+func (s *MockSlice) IndexInstId() common.IndexInstId { return s.instId }
+func (s *MockSlice) IndexDefnId() common.IndexDefnId { return s.defnId }
+func (s *MockSlice) UpdateConfig(common.Config)      {}
+func (s *MockSlice) RecoveryDone()                   {}
+func (s *MockSlice) PrepareStats()                   {}
+
+func (s *MockSlice) GetReaderContext() IndexReaderContext {
+	return &cursorCtx{}
+}
+
+func (s *MockSlice) Status() SliceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *MockSlice) SetStatus(status SliceStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *MockSlice) IsActive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+func (s *MockSlice) SetActive(active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = active
+}
+
+func (s *MockSlice) IsDirty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirty
+}
+
+func (s *MockSlice) Insert(key []byte, docid []byte, meta *MutationMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[string(docid)] = append([]byte(nil), key...)
+	s.dirty = true
+	return nil
+}
+
+func (s *MockSlice) Delete(docid []byte, meta *MutationMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs, string(docid))
+	s.dirty = true
+	return nil
+}
+
+func (s *MockSlice) FlushDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numFlushes++
+}
+
+func (s *MockSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.newSnapshotErr != nil {
+		return nil, s.newSnapshotErr
+	}
+
+	info := &mockSnapshotInfo{
+		ts:        ts.Copy(),
+		committed: commit,
+		docs:      copyMockDocs(s.docs),
+	}
+	if commit {
+		s.numCommits++
+		s.infos = append([]*mockSnapshotInfo{info}, s.infos...)
+	}
+	s.dirty = false
+	return info, nil
+}
+
+func (s *MockSlice) GetSnapshots() ([]SnapshotInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.getSnapshotsErr != nil {
+		return nil, s.getSnapshotsErr
+	}
+
+	infos := make([]SnapshotInfo, 0, len(s.infos))
+	for _, info := range s.infos {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *MockSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.openSnapshotErr != nil {
+		return nil, s.openSnapshotErr
+	}
+	return &MockSnapshot{slice: s, info: info.(*mockSnapshotInfo), refs: 1}, nil
+}
+
+func (s *MockSlice) Rollback(info SnapshotInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rollbackErr != nil {
+		return s.rollbackErr
+	}
+
+	for i, si := range s.infos {
+		if si == info {
+			s.infos = s.infos[i:]
+			s.docs = copyMockDocs(si.docs)
+			s.dirty = false
+			s.numRollbacks++
+			return nil
+		}
+	}
+	return errMockUnknownSnapshot
+}
+
+func (s *MockSlice) RollbackToZero() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rollbackToZeroErr != nil {
+		return s.rollbackToZeroErr
+	}
+
+	s.infos = nil
+	s.docs = make(map[string][]byte)
+	s.dirty = false
+	s.numRollbacksToZero++
+	return nil
+}
+
+func (s *MockSlice) LastRollbackTs() *common.TsVbuuid {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRollbackTs
+}
+
+func (s *MockSlice) SetLastRollbackTs(ts *common.TsVbuuid) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRollbackTs = ts
+}
+
+func (s *MockSlice) Statistics(consumerFilter uint64) (StorageStatistics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sts StorageStatistics
+	for docid, key := range s.docs {
+		sts.DataSize += int64(len(docid) + len(key))
+	}
+	return sts, nil
+}
+
+func (s *MockSlice) Compact(abortTime time.Time, minFrag int) error {
+	return nil
+}
+
+func (s *MockSlice) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+func (s *MockSlice) IncrRef() {
+	atomic.AddInt32(&s.refs, 1)
+}
+
+func (s *MockSlice) DecrRef() {
+	atomic.AddInt32(&s.refs, -1)
+}
+
+func (s *MockSlice) CheckAndIncrRef() bool {
+	for {
+		refs := atomic.LoadInt32(&s.refs)
+		if refs <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&s.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (s *MockSlice) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// numSnapshots returns the number of committed snapshots of the slice.
+func (s *MockSlice) numSnapshots() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.infos)
+}
+
+func copyMockDocs(docs map[string][]byte) map[string][]byte {
+	out := make(map[string][]byte, len(docs))
+	for docid, key := range docs {
+		out[docid] = key
+	}
+	return out
+}
+
+type mockSnapshotInfo struct {
+	ts        *common.TsVbuuid
+	committed bool
+	docs      map[string][]byte
+}
+
+func (info *mockSnapshotInfo) Timestamp() *common.TsVbuuid   { return info.ts }
+func (info *mockSnapshotInfo) IsCommitted() bool             { return info.committed }
+func (info *mockSnapshotInfo) IsOSOSnap() bool               { return info.ts.GetSnapType() == common.DISK_SNAP_OSO }
+func (info *mockSnapshotInfo) Stats() map[string]interface{} { return nil }
+
+// MockSnapshot reads the docs of a MockSlice as of a snapshot. Keys are
+// compared as bytes.
+type MockSnapshot struct {
+	slice *MockSlice
+	info  *mockSnapshotInfo
+	refs  int32
+}
+
+func (s *MockSnapshot) Open() error {
+	atomic.AddInt32(&s.refs, 1)
+	return nil
+}
+
+func (s *MockSnapshot) Close() error {
+	if atomic.AddInt32(&s.refs, -1) < 0 {
+		panic("MockSnapshot closed more times than opened")
+	}
+	return nil
+}
+
+func (s *MockSnapshot) IsOpen() bool                    { return atomic.LoadInt32(&s.refs) > 0 }
+func (s *MockSnapshot) Id() SliceId                     { return s.slice.id }
+func (s *MockSnapshot) IndexInstId() common.IndexInstId { return s.slice.instId }
+func (s *MockSnapshot) IndexDefnId() common.IndexDefnId { return s.slice.defnId }
+func (s *MockSnapshot) Timestamp() *common.TsVbuuid     { return s.info.ts }
+func (s *MockSnapshot) Info() SnapshotInfo              { return s.info }
+
+func (s *MockSnapshot) keys() []string {
+	keys := make([]string, 0, len(s.info.docs))
+	for _, key := range s.info.docs {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *MockSnapshot) inRange(key string, low, high IndexKey, inclusion Inclusion) bool {
+	if low != nil && low.Bytes() != nil {
+		if c := compareMockKey(key, low); c < 0 || (c == 0 && inclusion&Low == 0) {
+			return false
+		}
+	}
+	if high != nil && high.Bytes() != nil {
+		if c := compareMockKey(key, high); c > 0 || (c == 0 && inclusion&High == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareMockKey(key string, other IndexKey) int {
+	switch o := string(other.Bytes()); {
+	case key < o:
+		return -1
+	case key > o:
+		return 1
+	}
+	return 0
+}
+
+func (s *MockSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
+	return uint64(len(s.info.docs)), nil
+}
+
+func (s *MockSnapshot) StatCountTotal() (uint64, error) {
+	return uint64(len(s.info.docs)), nil
+}
+
+func (s *MockSnapshot) Exists(ctx IndexReaderContext, key IndexKey, stopch StopChannel) (bool, error) {
+	for _, k := range s.keys() {
+		if compareMockKey(k, key) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MockSnapshot) Lookup(ctx IndexReaderContext, key IndexKey, callb EntryCallback) error {
+	return s.Range(ctx, key, key, Both, callb)
+}
+
+func (s *MockSnapshot) All(ctx IndexReaderContext, callb EntryCallback) error {
+	return s.Range(ctx, nil, nil, Both, callb)
+}
+
+func (s *MockSnapshot) Range(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	callb EntryCallback) error {
+
+	for _, k := range s.keys() {
+		if s.inRange(k, low, high, inclusion) {
+			if err := callb([]byte(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MockSnapshot) CountRange(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	stopch StopChannel) (uint64, error) {
+
+	var count uint64
+	err := s.Range(ctx, low, high, inclusion, func([]byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func (s *MockSnapshot) CountLookup(ctx IndexReaderContext, keys []IndexKey,
+	stopch StopChannel) (uint64, error) {
+
+	var count uint64
+	for _, key := range keys {
+		n, err := s.CountRange(ctx, key, key, Both, stopch)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func (s *MockSnapshot) MultiScanCount(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
+	scan Scan, distinct bool, stopch StopChannel) (uint64, error) {
+
+	return s.CountRange(ctx, low, high, inclusion, stopch)
+}
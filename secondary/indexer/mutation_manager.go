@@ -51,6 +51,7 @@ type mutationMgr struct {
 	streamReaderExitChMap map[common.StreamId]DoneChannel //Channel to indicate stream reader exited
 
 	streamFlusherStopChMap    map[common.StreamId]KeyspaceIdStopChMap //stop channels for flusher
+	flushAbortRequested       map[common.StreamId]map[string]bool     //flushes asked to abort by supervisor
 	streamKeyspaceIdSessionId map[common.StreamId]KeyspaceIdSessionId
 	streamKeyspaceIdEnableOSO map[common.StreamId]KeyspaceIdEnableOSO
 
@@ -64,8 +65,8 @@ type mutationMgr struct {
 
 	indexInstMap  IndexInstMapHolder
 	indexPartnMap IndexPartnMapHolder
+	purgedInsts   *purgedInstSet //instances whose queued mutations are discarded
 
-	numVbuckets uint16 //number of vbuckets
 
 	flusherWaitGroup sync.WaitGroup
 
@@ -105,6 +106,7 @@ func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		streamReaderCmdChMap:      make(map[common.StreamId]MsgChannel),
 		streamReaderExitChMap:     make(map[common.StreamId]DoneChannel),
 		streamFlusherStopChMap:    make(map[common.StreamId]KeyspaceIdStopChMap),
+		flushAbortRequested:       make(map[common.StreamId]map[string]bool),
 		streamKeyspaceIdSessionId: make(map[common.StreamId]KeyspaceIdSessionId),
 		streamKeyspaceIdEnableOSO: make(map[common.StreamId]KeyspaceIdEnableOSO),
 
@@ -114,13 +116,13 @@ func NewMutationManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		shutdownCh:     make(DoneChannel),
 		supvCmdch:      supvCmdch,
 		supvRespch:     supvRespch,
-		numVbuckets:    uint16(config["numVbuckets"].Int()),
 		config:         config,
 		memUsed:        0,
 		maxMemory:      0,
 		vbMap:          &VbMapHolder{},
 		numVbsPerNode:  make(map[string]int64),
 		cpuThrottle:    cpuThrottle,
+		purgedInsts:    &purgedInstSet{},
 	}
 
 	m.setEnableAuth()
@@ -341,6 +343,9 @@ func (m *mutationMgr) handleSupervisorCommands(cmd Message) {
 	case MUT_MGR_ABORT_PERSIST:
 		m.handleAbortPersist(cmd)
 
+	case MUT_MGR_PURGE_INDEXES:
+		m.handlePurgeIndexes(cmd)
+
 	case CONFIG_SETTINGS_UPDATE:
 		m.handleConfigUpdate(cmd)
 
@@ -371,6 +376,7 @@ func (m *mutationMgr) handleWorkerMessage(cmd Message) {
 		STREAM_READER_HWT,
 		STREAM_READER_SYSTEM_EVENT,
 		STREAM_READER_OSO_SNAPSHOT_MARKER,
+		STREAM_READER_EVAL_SKIP,
 		RESET_STREAM:
 		//send message to supervisor to take decision
 		logging.Tracef("MutationMgr::handleWorkerMessage Received %v from worker", cmd)
@@ -488,7 +494,8 @@ func (m *mutationMgr) handleOpenStream(cmd Message) {
 		if _, ok := keyspaceIdQueueMap[keyspaceId]; !ok {
 			//init mutation queue
 			var queue MutationQueue
-			if queue = NewAtomicMutationQueue(keyspaceId, m.numVbuckets,
+			if queue = NewAtomicMutationQueue(keyspaceId,
+				uint16(getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), m.config)),
 				&m.maxMemory, &m.memUsed, m.config); queue == nil {
 				m.supvCmdch <- &MsgError{
 					err: Error{code: ERROR_MUTATION_QUEUE_INIT,
@@ -588,7 +595,8 @@ func (m *mutationMgr) addIndexListToExistingStream(streamId common.StreamId,
 		if _, ok := keyspaceIdQueueMap[keyspaceId]; !ok {
 			//init mutation queue
 			var queue MutationQueue
-			if queue = NewAtomicMutationQueue(keyspaceId, m.numVbuckets,
+			if queue = NewAtomicMutationQueue(keyspaceId,
+				uint16(getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), m.config)),
 				&m.maxMemory, &m.memUsed, m.config); queue == nil {
 				return &MsgError{
 					err: Error{code: ERROR_MUTATION_QUEUE_INIT,
@@ -995,6 +1003,7 @@ func (m *mutationMgr) cleanupStream(streamId common.StreamId) {
 	m.flock.Lock()
 	defer m.flock.Unlock()
 	delete(m.streamFlusherStopChMap, streamId)
+	delete(m.flushAbortRequested, streamId)
 
 }
 
@@ -1046,6 +1055,7 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 		}
 
 		flusher := NewFlusher(config, stats)
+		flusher.purged = m.purgedInsts
 		sts := Timestamp(ts.Seqnos)
 		msgch := flusher.PersistUptoTS(q.queue, streamId, keyspaceId,
 			m.indexInstMap.Get(), m.indexPartnMap.Get(), sts, changeVec, countVec, stopch)
@@ -1053,12 +1063,16 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 		msg := <-msgch
 
 		//update map and free lock before blocking on the supv channel
+		var abortRequested bool
 		func() {
 			m.flock.Lock()
 			defer m.flock.Unlock()
 
 			//delete the stop channel from the map
 			delete(m.streamFlusherStopChMap[streamId], keyspaceId)
+
+			abortRequested = m.flushAbortRequested[streamId][keyspaceId]
+			delete(m.flushAbortRequested[streamId], keyspaceId)
 		}()
 
 		stats.memoryUsedQueue.Set(atomic.LoadInt64(&m.memUsed))
@@ -1075,7 +1089,8 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 				streamId:   streamId,
 				keyspaceId: keyspaceId,
 				ts:         ts,
-				aborted:    true}
+				aborted:    true,
+				abortCause: m.flushAbortCause(abortRequested)}
 		}
 		keyspaceStats := m.stats.GetKeyspaceStats(streamId, keyspaceId)
 		if keyspaceStats != nil {
@@ -1159,6 +1174,10 @@ func (m *mutationMgr) handleAbortPersist(cmd Message) {
 			if stopch, ok := keyspaceIdStopChMap[keyspaceId]; ok {
 				if stopch != nil {
 					close(stopch)
+					if m.flushAbortRequested[streamId] == nil {
+						m.flushAbortRequested[streamId] = make(map[string]bool)
+					}
+					m.flushAbortRequested[streamId][keyspaceId] = true
 				}
 			}
 		}
@@ -1171,6 +1190,22 @@ func (m *mutationMgr) handleAbortPersist(cmd Message) {
 
 }
 
+//handlePurgeIndexes discards the queued mutations of the given instances.
+//A flush in progress skips their mutations from then on, instead of
+//writing them to slices which are about to be destroyed. It responds once
+//the mutations being written are done, so that the slices can be destroyed
+//without waiting for the flush.
+func (m *mutationMgr) handlePurgeIndexes(cmd Message) {
+
+	logging.Infof("MutationMgr::handlePurgeIndexes %v", cmd)
+
+	instIds := cmd.(*MsgPurgeIndexes).GetInstIds()
+	m.purgedInsts.add(instIds)
+	m.purgedInsts.quiesce()
+
+	m.supvCmdch <- &MsgSuccess{}
+}
+
 //handleGetMutationQueueHWT calculates HWT for a mutation queue
 //for a given stream and keyspaceId
 func (m *mutationMgr) handleGetMutationQueueHWT(cmd Message) {
@@ -1223,6 +1258,8 @@ func (m *mutationMgr) handleUpdateIndexInstMap(cmd Message) {
 	deletedInstIds := req.GetDeletedInstIds()
 	if len(deletedInstIds) > 0 {
 		logging.Infof("MutationMgr::handleUpdateIndexInstMap, deleted instance ids: %v", deletedInstIds)
+		//instances are gone from the map, and no flush refers to them anymore
+		m.purgedInsts.remove(deletedInstIds)
 	}
 	logging.Tracef("MutationMgr::handleUpdateIndexInstMap %v", cmd)
 
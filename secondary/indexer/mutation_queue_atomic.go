@@ -447,7 +447,7 @@ func (q *atomicMutationQueue) allocNode(vbucket Vbucket, appch StopChannel) *nod
 func (q *atomicMutationQueue) checkMemAndAlloc(vbucket Vbucket) *node {
 
 	currMem := atomic.LoadInt64(q.memUsed)
-	maxMem := atomic.LoadInt64(q.maxMemory)
+	maxMem := scaleMemoryBudget(atomic.LoadInt64(q.maxMemory), memQueue)
 	currLen := atomic.LoadInt64(&q.size[vbucket])
 
 	if currMem < maxMem || currLen < int64(q.minQueueLen) {
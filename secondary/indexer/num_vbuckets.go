@@ -0,0 +1,69 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Buckets usually have 1024 vbuckets, the numVbuckets setting, but buckets
+// of serverless clusters can have 128 or 64. The number of vbuckets of a
+// bucket is taken from cluster info when an index partition of the bucket
+// is initialised, and used wherever a timestamp of the bucket is created.
+// Buckets not known yet fall back to numVbuckets.
+
+var bucketNumVBuckets struct {
+	sync.RWMutex
+	numVbs map[string]int
+}
+
+func setNumVBuckets(bucket string, numVbs int) {
+	bucketNumVBuckets.Lock()
+	defer bucketNumVBuckets.Unlock()
+
+	if bucketNumVBuckets.numVbs == nil {
+		bucketNumVBuckets.numVbs = make(map[string]int)
+	}
+	bucketNumVBuckets.numVbs[bucket] = numVbs
+}
+
+// getNumVBuckets returns the number of vbuckets of bucket, or numVbuckets
+// from cfg if it is not known.
+func getNumVBuckets(bucket string, cfg common.Config) int {
+	bucketNumVBuckets.RLock()
+	numVbs, ok := bucketNumVBuckets.numVbs[bucket]
+	bucketNumVBuckets.RUnlock()
+
+	if ok {
+		return numVbs
+	}
+	return cfg["numVbuckets"].Int()
+}
+
+// updateNumVBuckets fetches the number of vbuckets of bucket from cluster
+// info. On error the last known value, if any, is kept.
+func (idx *indexer) updateNumVBuckets(bucket string) {
+	idx.cinfoProviderLock.RLock()
+	numVbs, err := idx.cinfoProvider.GetNumVBuckets(bucket)
+	idx.cinfoProviderLock.RUnlock()
+
+	if err != nil || numVbs <= 0 {
+		logging.Warnf("Indexer::updateNumVBuckets Bucket %v. Unable to get number of "+
+			"vbuckets, err %v. Using %v.", bucket, err, getNumVBuckets(bucket, idx.config))
+		return
+	}
+
+	if numVbs != getNumVBuckets(bucket, idx.config) {
+		logging.Infof("Indexer::updateNumVBuckets Bucket %v has %v vbuckets", bucket, numVbs)
+	}
+	setNumVBuckets(bucket, numVbs)
+}
@@ -0,0 +1,173 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestNumVBuckets(t *testing.T) {
+	cfg := common.Config{"numVbuckets": common.ConfigValue{Value: 1024}}
+
+	if n := getNumVBuckets("numvbs_default", cfg); n != 1024 {
+		t.Fatalf("expected numVbuckets 1024 for unknown bucket, got %v", n)
+	}
+
+	setNumVBuckets("numvbs_serverless", 128)
+	setNumVBuckets("numvbs_small", 64)
+	if n := getNumVBuckets("numvbs_serverless", cfg); n != 128 {
+		t.Fatalf("expected 128 vbuckets, got %v", n)
+	}
+	if n := getNumVBuckets("numvbs_small", cfg); n != 64 {
+		t.Fatalf("expected 64 vbuckets, got %v", n)
+	}
+
+	ts := common.NewTsVbuuid("numvbs_serverless", getNumVBuckets("numvbs_serverless", cfg))
+	if len(ts.Seqnos) != 128 || len(ts.Vbuuids) != 128 {
+		t.Fatalf("expected timestamp of 128 vbuckets, got %v", len(ts.Seqnos))
+	}
+
+	setNumVBuckets("numvbs_serverless", 1024)
+	if n := getNumVBuckets("numvbs_serverless", cfg); n != 1024 {
+		t.Fatalf("expected 1024 vbuckets after update, got %v", n)
+	}
+}
+
+func TestSessionConsistentScanNumVBuckets(t *testing.T) {
+	bucket := "numvbs_session"
+	cfg := common.Config{
+		"numVbuckets":                     common.ConfigValue{Value: 1024},
+		"use_bucket_seqnos":               common.ConfigValue{Value: false},
+		"settings.scan_getseqnos_retries": common.ConfigValue{Value: 1},
+		"clusterAddr":                     common.ConfigValue{Value: "127.0.0.1:8091"},
+		"scan.seqnosCache.ttl":            common.ConfigValue{Value: 60000},
+	}
+	setNumVBuckets(bucket, 64)
+
+	// The HWT of the stream, which flush and snapshot timestamps derive
+	// from, has the vbuckets of the bucket
+	ss := InitStreamState(cfg)
+	ss.initNewStream(common.MAINT_STREAM)
+	ss.initKeyspaceIdInStream(common.MAINT_STREAM, bucket)
+	hwt := ss.streamKeyspaceIdHWTMap[common.MAINT_STREAM][bucket]
+	if len(hwt.Seqnos) != 64 {
+		t.Fatalf("expected HWT of 64 vbuckets, got %v", len(hwt.Seqnos))
+	}
+	for i := range hwt.Seqnos {
+		hwt.Seqnos[i], hwt.Vbuuids[i] = uint64(100+i), uint64(1000+i)
+	}
+	snap := &indexSnapshot{ts: hwt.Copy()}
+
+	// KV seqnos of the scan, as fetched for the 64 vbuckets of the bucket
+	sco := &scanCoordinator{seqnosCache: newSeqnosCache()}
+	sco.config.Store(cfg)
+	kvSeqnos := make([]uint64, 64)
+	for i := range kvSeqnos {
+		kvSeqnos[i] = uint64(50 + i)
+	}
+	key := seqnosCacheKey{bucket: bucket}
+	sco.seqnosCache.Get(key, time.Minute, time.Now(), func() ([]uint64, error) {
+		return kvSeqnos, nil
+	})
+
+	r := &ScanRequest{sco: sco, Bucket: bucket}
+	if err := r.setConsistency(common.SessionConsistency, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(r.Ts.Seqnos) != 64 {
+		t.Fatalf("expected request timestamp of 64 vbuckets, got %v", len(r.Ts.Seqnos))
+	}
+	if !isSnapshotConsistent(snap, common.SessionConsistency, r.Ts) {
+		t.Fatalf("expected snapshot ahead of the KV seqnos to satisfy the scan")
+	}
+
+	kvSeqnos[63] = 200
+	sco.seqnosCache = newSeqnosCache()
+	sco.seqnosCache.Get(key, time.Minute, time.Now(), func() ([]uint64, error) {
+		return kvSeqnos, nil
+	})
+	if err := r.setConsistency(common.SessionConsistency, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if isSnapshotConsistent(snap, common.SessionConsistency, r.Ts) {
+		t.Fatalf("expected snapshot behind the KV seqnos not to satisfy the scan")
+	}
+
+	// A snapshot sized with numVbuckets never satisfies the scan
+	snap1024 := &indexSnapshot{ts: common.NewTsVbuuid(bucket, 1024)}
+	for i := range snap1024.ts.Seqnos {
+		snap1024.ts.Seqnos[i] = 1000
+	}
+	if isSnapshotConsistent(snap1024, common.SessionConsistency, r.Ts) {
+		t.Fatalf("expected snapshot of 1024 vbuckets not to match the bucket")
+	}
+}
+
+func TestStreamSyncNumVBuckets(t *testing.T) {
+	bucket := "numvbs_sync"
+	numWorkers := 4
+	cfg := common.SystemConfig.SectionConfig("indexer.", true /*trim*/)
+	setNumVBuckets(bucket, 64)
+
+	var maxMemory, memUsed int64 = 1024 * 1024, 0
+	q := NewAtomicMutationQueue(bucket, uint16(getNumVBuckets(bucket, cfg)), &maxMemory, &memUsed, cfg)
+
+	r := &mutationStreamReader{
+		streamId:            common.MAINT_STREAM,
+		supvRespch:          make(MsgChannel, 1),
+		keyspaceIdQueueMap:  KeyspaceIdQueueMap{bucket: IndexerMutationQueue{queue: q}},
+		keyspaceIdEnableOSO: make(KeyspaceIdEnableOSO),
+		numWorkers:          numWorkers,
+		streamWorkers:       make([]*streamWorker, numWorkers),
+		config:              cfg,
+	}
+	r.stats.Set(NewIndexerStats())
+	for i := 0; i < numWorkers; i++ {
+		r.streamWorkers[i] = newStreamWorker(common.MAINT_STREAM, numWorkers, i, cfg, r,
+			nil, false, nil, nil, nil)
+	}
+
+	// Each worker owns every numWorkers-th vbucket of the bucket
+	for vb := 0; vb < 64; vb++ {
+		w := r.streamWorkers[vb%numWorkers]
+		filter := w.keyspaceIdFilter[bucket]
+		filter.Seqnos[vb] = uint64(100 + vb)
+		filter.Vbuuids[vb] = uint64(1000 + vb)
+		filter.Snapshots[vb] = [2]uint64{0, uint64(100 + vb)}
+	}
+	r.streamWorkers[0].keyspaceIdSyncDue[bucket] = true
+
+	if !r.maybeSendSync(true) {
+		t.Fatalf("expected a sync to be sent")
+	}
+	msg := (<-r.supvRespch).(*MsgKeyspaceHWT)
+	if len(msg.hwt.Seqnos) != 64 || len(msg.prevSnap.Seqnos) != 64 {
+		t.Fatalf("expected HWT of 64 vbuckets, got %v", len(msg.hwt.Seqnos))
+	}
+
+	// The sync updates the HWT of the stream, which has the vbuckets of
+	// the bucket as well
+	ss := InitStreamState(cfg)
+	ss.initNewStream(common.MAINT_STREAM)
+	ss.initKeyspaceIdInStream(common.MAINT_STREAM, bucket)
+	ss.updateHWT(common.MAINT_STREAM, bucket, msg.hwt, msg.hwtOSO, msg.prevSnap)
+
+	hwt := ss.streamKeyspaceIdHWTMap[common.MAINT_STREAM][bucket]
+	for vb := 0; vb < 64; vb++ {
+		if hwt.Seqnos[vb] != uint64(100+vb) || hwt.Snapshots[vb][1] != uint64(100+vb) {
+			t.Fatalf("expected seqno %v for vbucket %v, got %v", 100+vb, vb, hwt.Seqnos[vb])
+		}
+	}
+	if !ss.checkNewTSDue(common.MAINT_STREAM, bucket) {
+		t.Fatalf("expected a new timestamp to be due after the sync")
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/memdb"
+	"github.com/couchbase/indexing/secondary/memdb/skiplist"
+)
+
+// With allow_large_keys disabled, a document whose secondary key is over
+// max_seckey_size is skipped and missing from the index. With
+// overflow_large_keys, a MOI slice of a non-array index instead stores the
+// entry of such a key with the leading bytes of its encoded key followed by
+// a hash of the full key, and keeps the full entry in an overflow record of
+// the same store, keyed by docid. Overflow records sort after all index
+// entries and are persisted and recovered with the snapshots of the slice.
+//
+// Scan keys are at most max_seckey_size, so the leading bytes of a large key
+// order an overflow entry against any scan key as the full key would. Scans
+// replace overflow entries with the full entry from the overflow record
+// before filtering, so the large key is transparent to the scan pipeline.
+// Overflow entries with the same leading bytes are ordered by the hash of
+// their full key in the store, so scans take each run of them and return
+// their full entries sorted, as they would be ordered without overflow.
+
+// Overflow entries set the overflowEntryFlag bit in the high byte of the
+// docid length.
+const overflowEntryFlag = 0x40
+
+// Overflow records start with overflowRecordMarker, which no encoded
+// secondary key starts with.
+const overflowRecordMarker = 0xff
+
+const overflowKeyHashLen = 4
+
+var errOverflowRecordNotFound = errors.New("Overflow record not found")
+
+func (e secondaryIndexEntry) isOverflow() bool {
+	return len(e) > 2 && e[len(e)-1]&overflowEntryFlag == overflowEntryFlag
+}
+
+// newOverflowIndexEntry returns the overflow entry of entry, keeping
+// prefixLen bytes of its key.
+func newOverflowIndexEntry(entry secondaryIndexEntry, prefixLen int, buf []byte) secondaryIndexEntry {
+	key := entry.ReadSecKeyCJson()
+	if prefixLen > len(key) {
+		prefixLen = len(key)
+	}
+	docidLen := entry.lenDocId()
+	docid := entry[len(key) : len(key)+docidLen]
+
+	buf = append(buf[:0], key[:prefixLen]...)
+	var hash [overflowKeyHashLen]byte
+	binary.BigEndian.PutUint32(hash[:], crc32.ChecksumIEEE(key))
+	buf = append(buf, hash[:]...)
+	buf = append(buf, docid...)
+
+	var l [2]byte
+	binary.LittleEndian.PutUint16(l[:], uint16(docidLen))
+	l[1] |= overflowEntryFlag
+	return secondaryIndexEntry(append(buf, l[:]...))
+}
+
+// overflowKeyHash returns the hash of the full key in an overflow entry.
+func (e secondaryIndexEntry) overflowKeyHash() uint32 {
+	key := e.ReadSecKeyCJson()
+	return binary.BigEndian.Uint32(key[len(key)-overflowKeyHashLen:])
+}
+
+// Overflow record format:
+// [overflowRecordMarker][len_of_docid_2_bytes][raw_docid_bytes][full_entry]
+func overflowRecordKey(docid []byte, buf []byte) []byte {
+	buf = append(buf[:0], overflowRecordMarker, 0, 0)
+	binary.BigEndian.PutUint16(buf[1:3], uint16(len(docid)))
+	return append(buf, docid...)
+}
+
+func newOverflowRecord(docid []byte, entry secondaryIndexEntry, buf []byte) []byte {
+	buf = overflowRecordKey(docid, buf)
+	return append(buf, entry...)
+}
+
+func isOverflowRecord(b []byte) bool {
+	return len(b) > 0 && b[0] == overflowRecordMarker
+}
+
+func overflowRecordKeyLen(rec []byte) int {
+	if len(rec) < 3 {
+		return len(rec)
+	}
+	return 3 + int(binary.BigEndian.Uint16(rec[1:3]))
+}
+
+// overflowRecordEntry returns the full entry in an overflow record.
+func overflowRecordEntry(rec []byte) secondaryIndexEntry {
+	return secondaryIndexEntry(rec[overflowRecordKeyLen(rec):])
+}
+
+// overflowItemCompare orders the items of the main store of a secondary
+// index, comparing overflow records by docid only, so that there is one
+// record per docid and it can be found from the docid.
+func overflowItemCompare(a, b []byte) int {
+	if isOverflowRecord(a) && isOverflowRecord(b) {
+		return bytes.Compare(a[:overflowRecordKeyLen(a)], b[:overflowRecordKeyLen(b)])
+	}
+	return bytes.Compare(a, b)
+}
+
+// canOverflowKeys returns whether large keys are stored as overflow entries
+// instead of being skipped.
+func (mdb *memdbSlice) canOverflowKeys(szConf keySizeConfig) bool {
+	return szConf.overflowLargeKeys && !szConf.allowLargeKeys && !mdb.isPrimary &&
+		!mdb.idxDefn.IsArrayIndex
+}
+
+// putOverflowRecord replaces the overflow record of docid with rec.
+func (mdb *memdbSlice) putOverflowRecord(docid []byte, rec []byte, workerId int) {
+	if !mdb.deleteOverflowRecord(docid, workerId) {
+		mdb.idxStats.numOverflowKeys.Add(1)
+	}
+	mdb.main[workerId].Put(rec)
+	mdb.idxStats.rawDataSize.Add(int64(len(rec)))
+}
+
+// deleteOverflowRecord deletes the overflow record of docid, returning
+// whether there was one.
+func (mdb *memdbSlice) deleteOverflowRecord(docid []byte, workerId int) bool {
+	node, success := mdb.main[workerId].Delete2(overflowRecordKey(docid, nil))
+	if !success {
+		return false
+	}
+
+	if node != nil {
+		mdb.idxStats.rawDataSize.Add(0 - int64(getNodeItemSize(node)))
+	}
+	mdb.idxStats.numOverflowKeys.Add(-1)
+	atomic.AddInt64(&mdb.delete_bytes, int64(len(docid)))
+	return true
+}
+
+// deleteOverflowRecordOf deletes the overflow record of the entry in the
+// main store node p, if it is an overflow entry.
+func (mdb *memdbSlice) deleteOverflowRecordOf(p unsafe.Pointer, docid []byte, workerId int) {
+	itm := (*memdb.Item)((*skiplist.Node)(p).Item())
+	if secondaryIndexEntry(itm.Bytes()).isOverflow() {
+		mdb.deleteOverflowRecord(docid, workerId)
+	}
+}
+
+// overflowRun holds the full entries of consecutive overflow entries with
+// the same leading bytes of the key, while they are taken by a scan.
+type overflowRun struct {
+	it      *memdb.Iterator // of overflow records, created on first use
+	prefix  []byte
+	entries [][]byte
+}
+
+// emitEntry passes itm to callback, or adds the full entry to run if itm is
+// an overflow entry. The entries of run are passed to callback, sorted,
+// once an entry with other leading bytes is taken.
+func (s *memdbSnapshot) emitEntry(itm []byte, run *overflowRun, callback func([]byte) error) error {
+	if s.slice.isPrimary || !secondaryIndexEntry(itm).isOverflow() {
+		if err := run.flush(callback); err != nil {
+			return err
+		}
+		return callback(itm)
+	}
+
+	key := secondaryIndexEntry(itm).ReadSecKeyCJson()
+	prefix := key[:len(key)-overflowKeyHashLen]
+	if len(run.entries) > 0 && !bytes.Equal(prefix, run.prefix) {
+		if err := run.flush(callback); err != nil {
+			return err
+		}
+	}
+
+	entry, err := s.resolveOverflowEntry(itm, &run.it)
+	if err != nil {
+		return err
+	}
+	run.prefix = prefix
+	run.entries = append(run.entries, entry)
+	return nil
+}
+
+// flush passes the entries of run to callback in the order of the store.
+func (run *overflowRun) flush(callback func([]byte) error) error {
+	sort.Slice(run.entries, func(i, j int) bool {
+		return bytes.Compare(run.entries[i], run.entries[j]) < 0
+	})
+
+	entries := run.entries
+	run.entries = run.entries[:0]
+	for _, entry := range entries {
+		if err := callback(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (run *overflowRun) close() {
+	if run.it != nil {
+		run.it.Close()
+	}
+}
+
+// resolveOverflowEntry returns the full entry of itm if it is an overflow
+// entry, looking up its overflow record with *it, which is created on
+// first use.
+func (s *memdbSnapshot) resolveOverflowEntry(itm []byte, it **memdb.Iterator) ([]byte, error) {
+	if s.slice.isPrimary || !secondaryIndexEntry(itm).isOverflow() {
+		return itm, nil
+	}
+
+	if *it == nil {
+		*it = s.info.MainSnap.NewIterator()
+	}
+	entry, err := lookupOverflowEntry(*it, itm)
+	if err != nil {
+		logging.Errorf("MemDBSnapshot::resolveOverflowEntry Slice Id %v IndexInstId %v PartitionId %v "+
+			"docid:%s (%v)", s.slice.Id(), s.idxInstId, s.idxPartnId,
+			logging.TagStrUD(docIdFromEntryBytes(itm)), err)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// lookupOverflowEntry returns the full entry of overflow entry e from its
+// overflow record.
+func lookupOverflowEntry(it *memdb.Iterator, e secondaryIndexEntry) (secondaryIndexEntry, error) {
+	docid := docIdFromEntryBytes(e)
+	key := overflowRecordKey(docid, nil)
+
+	it.Seek(key)
+	if !it.Valid() {
+		return nil, errOverflowRecordNotFound
+	}
+	rec := it.Get()
+	if !bytes.HasPrefix(rec, key) || overflowRecordKeyLen(rec) != len(key) {
+		return nil, errOverflowRecordNotFound
+	}
+
+	entry := overflowRecordEntry(rec)
+	if crc32.ChecksumIEEE(entry.ReadSecKeyCJson()) != e.overflowKeyHash() {
+		return nil, errOverflowRecordNotFound
+	}
+	return entry, nil
+}
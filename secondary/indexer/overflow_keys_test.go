@@ -0,0 +1,164 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/memdb"
+)
+
+func newTestOverflowEntry(t *testing.T, key string, docid string) secondaryIndexEntry {
+	entry, err := NewSecondaryIndexEntry2([]byte(key), []byte(docid), false, 1, nil, nil,
+		false, nil, keySizeConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestOverflowIndexEntry(t *testing.T) {
+	large := fmt.Sprintf(`["%v"]`, strings.Repeat("a", 200))
+	entry := newTestOverflowEntry(t, large, "doc1")
+
+	ov := newOverflowIndexEntry(entry, 64, nil)
+	if !ov.isOverflow() || entry.isOverflow() {
+		t.Fatalf("expected only the overflow entry to be flagged")
+	}
+	if ov.lenKey() != 64+overflowKeyHashLen {
+		t.Fatalf("expected key length %v, got %v", 64+overflowKeyHashLen, ov.lenKey())
+	}
+	if docid, _ := ov.ReadDocId(nil); string(docid) != "doc1" {
+		t.Fatalf("expected docid doc1, got %s", docid)
+	}
+	if !bytes.Equal(docIdFromEntryBytes(ov), []byte("doc1")) {
+		t.Fatalf("expected docid doc1, got %s", docIdFromEntryBytes(ov))
+	}
+
+	// Leading bytes order overflow entries as their full keys
+	smaller := newTestOverflowEntry(t, `["a"]`, "doc2")
+	larger := newTestOverflowEntry(t, `["b"]`, "doc3")
+	if bytes.Compare(smaller, ov) >= 0 || bytes.Compare(ov, larger) >= 0 {
+		t.Fatalf("expected the overflow entry between its neighbours")
+	}
+}
+
+func TestOverflowRecords(t *testing.T) {
+	cfg := memdb.DefaultConfig()
+	cfg.SetKeyComparator(overflowItemCompare)
+	db := memdb.NewWithConfig(cfg)
+	defer db.Close()
+	w := db.NewWriter()
+
+	large := fmt.Sprintf(`["%v"]`, strings.Repeat("x", 200))
+	entries := []secondaryIndexEntry{
+		newTestOverflowEntry(t, `["a"]`, "doc1"),
+		newTestOverflowEntry(t, large, "doc2"),
+		newTestOverflowEntry(t, `["z"]`, "doc3"),
+	}
+	w.Put(newOverflowRecord([]byte("doc2"), entries[1], nil))
+	w.Put(newOverflowIndexEntry(entries[1], 16, nil))
+	w.Put(entries[0])
+	w.Put(entries[2])
+
+	// One record per docid
+	if w.Put2(newOverflowRecord([]byte("doc2"), entries[0], nil)) != nil {
+		t.Fatalf("expected a single overflow record for doc2")
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	it := snap.NewIterator()
+	defer it.Close()
+
+	var items [][]byte
+	for it.SeekFirst(); it.Valid(); it.Next() {
+		items = append(items, append([]byte(nil), it.Get()...))
+	}
+	if len(items) != 4 || !isOverflowRecord(items[3]) {
+		t.Fatalf("expected the overflow record after the entries, got %v items", len(items))
+	}
+
+	ov := secondaryIndexEntry(items[1])
+	if !ov.isOverflow() {
+		t.Fatalf("expected an overflow entry")
+	}
+	it2 := snap.NewIterator()
+	defer it2.Close()
+	full, err := lookupOverflowEntry(it2, ov)
+	if err != nil || !bytes.Equal(full, entries[1]) {
+		t.Fatalf("expected the full entry, got %v", err)
+	}
+
+	if _, err := lookupOverflowEntry(it2, newOverflowIndexEntry(entries[2], 16, nil)); err != errOverflowRecordNotFound {
+		t.Fatalf("expected %v, got %v", errOverflowRecordNotFound, err)
+	}
+
+	if !w.Delete(overflowRecordKey([]byte("doc2"), nil)) {
+		t.Fatalf("expected the overflow record to be deleted by docid")
+	}
+}
+
+func TestOverflowRunOrder(t *testing.T) {
+	cfg := memdb.DefaultConfig()
+	cfg.SetKeyComparator(overflowItemCompare)
+	db := memdb.NewWithConfig(cfg)
+	defer db.Close()
+	w := db.NewWriter()
+
+	// Large keys differing after the leading bytes kept in their overflow entries
+	var entries []secondaryIndexEntry
+	prefix := strings.Repeat("x", 200)
+	for i := 0; i < 20; i++ {
+		docid := fmt.Sprintf("doc%v", i)
+		entry := newTestOverflowEntry(t, fmt.Sprintf(`["%v%02d"]`, prefix, i), docid)
+		entries = append(entries, entry)
+		w.Put(newOverflowRecord([]byte(docid), entry, nil))
+		w.Put(newOverflowIndexEntry(entry, 16, nil))
+	}
+	small := newTestOverflowEntry(t, `["a"]`, "doc20")
+	large := newTestOverflowEntry(t, `["z"]`, "doc21")
+	w.Put(small)
+	w.Put(large)
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	s := &memdbSnapshot{slice: &memdbSlice{}, info: &memdbSnapshotInfo{MainSnap: snap}}
+
+	var got [][]byte
+	callback := func(entry []byte) error {
+		got = append(got, entry)
+		return nil
+	}
+
+	var run overflowRun
+	defer run.close()
+	it := snap.NewIterator()
+	defer it.Close()
+	for it.SeekFirst(); it.Valid() && !s.isOverflowArea(it.Get()); it.Next() {
+		if err := s.emitEntry(it.Get(), &run, callback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := run.flush(callback); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(entries)+2 || !bytes.Equal(got[0], small) || !bytes.Equal(got[len(got)-1], large) {
+		t.Fatalf("expected %v entries between their neighbours, got %v", len(entries)+2, len(got))
+	}
+	for i, entry := range entries {
+		if !bytes.Equal(got[i+1], entry) {
+			t.Fatalf("expected full entries in key order, got %s at %v", got[i+1], i)
+		}
+	}
+}
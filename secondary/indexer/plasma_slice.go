@@ -144,7 +144,7 @@ type plasmaSlice struct {
 	saturateCount    int     // number of misses on meeting minimum drain rate
 
 	// config
-	enableWriterTuning bool    // enable tuning on writers
+	enableWriterTuning int32   // 1 if tuning on writers, updated at runtime
 	adjustInterval     uint64  // interval to check whether writer need tuning
 	samplingWindow     uint64  // sampling window
 	samplingInterval   uint64  // sampling interval
@@ -152,9 +152,11 @@ type plasmaSlice struct {
 	scalingFactor      float64 // scaling factor for percentage increase on drain rate
 	threshold          int     // threshold on number of misses on drain rate
 
-	writerLock    sync.Mutex // mutex for writer tuning
-	samplerStopCh chan bool  // stop sampler
-	token         *token     // token
+	writerLock     sync.Mutex // mutex for writer tuning
+	samplerStopCh  chan bool  // stop sampler
+	samplerResetCh chan bool  // sampler to pick up new settings
+	samplerRunning bool       // protected by writerLock
+	token          *token     // token
 
 	// Below are used to periodically reset/shrink slice buffers
 	lastBufferSizeCheckTime     time.Time
@@ -201,7 +203,7 @@ func newPlasmaSlice(storage_dir string, log_dir string, path string, sliceId Sli
 	slice.maxNumWriters = sysconf["numSliceWriters"].Int()
 	slice.hasPersistence = !sysconf["plasma.disablePersistence"].Bool()
 	slice.clusterAddr = sysconf["clusterAddr"].String()
-	slice.numVbuckets = sysconf["numVbuckets"].Int()
+	slice.numVbuckets = getNumVBuckets(idxDefn.Bucket, sysconf)
 
 	slice.maxRollbacks = sysconf["settings.plasma.recovery.max_rollbacks"].Int()
 	slice.maxDiskSnaps = sysconf["recovery.max_disksnaps"].Int()
@@ -218,14 +220,11 @@ func newPlasmaSlice(storage_dir string, log_dir string, path string, sliceId Sli
 	slice.numPartitions = numPartitions
 
 	slice.samplingWindow = uint64(sysconf["plasma.writer.tuning.sampling.window"].Int()) * uint64(time.Millisecond)
-	slice.enableWriterTuning = sysconf["plasma.writer.tuning.enable"].Bool()
-	slice.adjustInterval = uint64(sysconf["plasma.writer.tuning.adjust.interval"].Int()) * uint64(time.Millisecond)
-	slice.samplingInterval = uint64(sysconf["plasma.writer.tuning.sampling.interval"].Int()) * uint64(time.Millisecond)
 	slice.scalingFactor = sysconf["plasma.writer.tuning.throughput.scalingFactor"].Float64()
 	slice.threshold = sysconf["plasma.writer.tuning.throttling.threshold"].Int()
-	slice.drainRate = common.NewSample(int(slice.samplingWindow / slice.samplingInterval))
-	slice.mutationRate = common.NewSample(int(slice.samplingWindow / slice.samplingInterval))
 	slice.samplerStopCh = make(chan bool)
+	slice.samplerResetCh = make(chan bool, 1)
+	slice.updateWriterTuning(sysconf)
 	slice.snapInterval = sysconf["settings.inmemory_snapshot.moi.interval"].Uint64() * uint64(time.Millisecond)
 
 	if err := slice.initStores(); err != nil {
@@ -690,7 +689,7 @@ loop:
 			mdb.idxStats.numItemsFlushed.Add(int64(nmut))
 			mdb.idxStats.numDocsIndexed.Add(1)
 
-			if mdb.enableWriterTuning {
+			if mdb.writerTuningEnabled() {
 				atomic.AddInt64(&mdb.drainTime, elapsed.Nanoseconds())
 				atomic.AddInt64(&mdb.numItems, int64(nmut))
 			}
@@ -986,6 +985,7 @@ func (mdb *plasmaSlice) insertSecArrayIndex(key []byte, docid []byte, workerId i
 		indexEntriesToBeDeleted = oldEntriesBytes
 	} else {
 		indexEntriesToBeAdded, indexEntriesToBeDeleted = CompareArrayEntriesWithCount(newEntriesBytes, oldEntriesBytes, newKeyCount, oldKeyCount)
+		mdb.idxStats.numArrEntriesKept.Add(int64(keptArrayEntries(indexEntriesToBeDeleted)))
 	}
 
 	nmut = 0
@@ -1374,6 +1374,7 @@ func (mdb *plasmaSlice) checkFatalDbError(err error) {
 
 type plasmaSnapshotInfo struct {
 	Ts        *common.TsVbuuid
+	CompactTs []byte `json:",omitempty"`
 	Committed bool
 	Count     int64
 
@@ -1489,7 +1490,18 @@ func (mdb *plasmaSlice) doPersistSnapshot(s *plasmaSnapshot) {
 			s.info.InstId = mdb.idxInstId
 			s.info.PartnId = mdb.idxPartnId
 
-			meta, err := json.Marshal(s.info)
+			mdb.confLock.RLock()
+			compactTs := mdb.sysconf["persisted_snapshot.compact_ts"].Bool()
+			mdb.confLock.RUnlock()
+
+			info := *s.info
+			if compactTs {
+				info.Version = SNAPSHOT_META_VERSION_PLASMA_2
+				info.CompactTs = common.EncodeTsVbuuid(info.Ts)
+				info.Ts = nil
+			}
+
+			meta, err := json.Marshal(&info)
 			common.CrashOnError(err)
 			timeHdr := make([]byte, 8)
 			binary.BigEndian.PutUint64(timeHdr, uint64(time.Now().UnixNano()))
@@ -1794,6 +1806,11 @@ func (mdb *plasmaSlice) getRPSnapInfo(rp *plasma.RecoveryPoint) (*plasmaSnapshot
 			return nil, fmt.Errorf("Unable to decode snapshot info from meta. err %v", err)
 		}
 		info.Ts = snapInfo.Ts
+		if len(snapInfo.CompactTs) != 0 {
+			if info.Ts, err = common.DecodeTsVbuuid(snapInfo.CompactTs); err != nil {
+				return nil, fmt.Errorf("Unable to decode snapshot timestamp from meta. err %v", err)
+			}
+		}
 		info.IndexStats = snapInfo.IndexStats
 	} else {
 		// old format
@@ -2044,7 +2061,8 @@ func (mdb *plasmaSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotI
 
 func (mdb *plasmaSlice) FlushDone() {
 
-	if !mdb.enableWriterTuning {
+	if !mdb.writerTuningEnabled() {
+		mdb.restoreWriters()
 		return
 	}
 
@@ -2287,7 +2305,8 @@ func (mdb *plasmaSlice) Statistics(consumerFilter uint64) (StorageStatistics, er
 	mainStoreStatsLoggingEnabled := false
 	backStoreStatsLoggingEnabled := false
 
-	if pStats.StatsLoggingEnabled || (consumerFilter == statsMgmt.AllStatsFilter) {
+	if (pStats.StatsLoggingEnabled && needStorageInternalData(consumerFilter)) ||
+		(consumerFilter == statsMgmt.AllStatsFilter) {
 		mainStoreStatsLoggingEnabled = true
 		internalData = append(internalData, fmt.Sprintf("{\n\"MainStore\":\n%s", pStats))
 	}
@@ -2300,7 +2319,8 @@ func (mdb *plasmaSlice) Statistics(consumerFilter uint64) (StorageStatistics, er
 		bsNumRecsDisk += pStats.NumRecordSwapOut - pStats.NumRecordSwapIn
 		bsNumRecsMem += pStats.NumRecordAllocs - pStats.NumRecordFrees + pStats.NumRecordCompressed
 		sts.MemUsed += pStats.MemSz + pStats.MemSzIndex
-		if pStats.StatsLoggingEnabled || (consumerFilter == statsMgmt.AllStatsFilter) {
+		if (pStats.StatsLoggingEnabled && needStorageInternalData(consumerFilter)) ||
+			(consumerFilter == statsMgmt.AllStatsFilter) {
 			backStoreStatsLoggingEnabled = true
 			if mainStoreStatsLoggingEnabled {
 				internalData = append(internalData, fmt.Sprintf(",\n\"BackStore\":\n%s", pStats))
@@ -2397,6 +2417,10 @@ func updatePlasmaConfig(cfg common.Config) {
 }
 
 func (mdb *plasmaSlice) UpdateConfig(cfg common.Config) {
+	// writer tuning takes writerLock before confLock, update it after
+	// releasing confLock
+	defer mdb.updateWriterTuning(cfg)
+
 	mdb.confLock.Lock()
 	defer mdb.confLock.Unlock()
 
@@ -2567,6 +2591,10 @@ func (mdb *plasmaSlice) String() string {
 
 func tryDeleteplasmaSlice(mdb *plasmaSlice) {
 
+	if trashDroppedSlice(mdb.idxInstId, mdb.idxPartnId, mdb.path) {
+		return
+	}
+
 	//cleanup the disk directory
 	if err := destroyPlasmaSlice(mdb.storageDir, mdb.path); err != nil {
 		logging.Errorf("plasmaSlice::Destroy Error Cleaning Up Slice Id %v, "+
@@ -3443,7 +3471,7 @@ func (slice *plasmaSlice) adjustWriters() {
 //
 func (slice *plasmaSlice) canExpandWriters(needed int) bool {
 
-	return slice.enableWriterTuning &&
+	return slice.writerTuningEnabled() &&
 		slice.numWriters < slice.maxNumWriters &&
 		needed > slice.numWriters
 }
@@ -3456,7 +3484,7 @@ func (slice *plasmaSlice) canExpandWriters(needed int) bool {
 //
 func (slice *plasmaSlice) canReduceWriters(needed int) bool {
 
-	return slice.enableWriterTuning &&
+	return slice.writerTuningEnabled() &&
 		slice.numWriters > 1 &&
 		needed < slice.numWriters
 }
@@ -3510,7 +3538,7 @@ func (slice *plasmaSlice) updateSample(elapsed int64, needLog bool) {
 //
 func (slice *plasmaSlice) shouldAdjustWriter() bool {
 
-	if !slice.enableWriterTuning {
+	if !slice.writerTuningEnabled() {
 		return false
 	}
 
@@ -3564,15 +3592,22 @@ func (slice *plasmaSlice) numWritersNeeded() int {
 
 func (slice *plasmaSlice) runSampler() {
 
-	if !slice.enableWriterTuning {
+	slice.writerLock.Lock()
+	if !slice.writerTuningEnabled() || slice.samplerRunning {
+		slice.writerLock.Unlock()
 		return
 	}
+	slice.samplerRunning = true
+	interval := slice.samplingInterval
+	slice.writerLock.Unlock()
 
 	lastTime := time.Now()
 	lastLogTime := lastTime
 
-	ticker := time.NewTicker(time.Duration(slice.samplingInterval))
-	defer ticker.Stop()
+	ticker := time.NewTicker(time.Duration(interval))
+	defer func() {
+		ticker.Stop()
+	}()
 
 	for {
 		select {
@@ -3583,12 +3618,101 @@ func (slice *plasmaSlice) runSampler() {
 			if needLog {
 				lastLogTime = lastTime
 			}
+		case <-slice.samplerResetCh:
+			slice.writerLock.Lock()
+			if !slice.writerTuningEnabled() {
+				slice.samplerRunning = false
+				slice.writerLock.Unlock()
+				return
+			}
+			if slice.samplingInterval != interval {
+				interval = slice.samplingInterval
+				ticker.Stop()
+				ticker = time.NewTicker(time.Duration(interval))
+			}
+			slice.writerLock.Unlock()
 		case <-slice.samplerStopCh:
 			return
 		}
 	}
 }
 
+func (slice *plasmaSlice) writerTuningEnabled() bool {
+	return atomic.LoadInt32(&slice.enableWriterTuning) == 1
+}
+
+//
+// Apply the writer tuning settings of the index in cfg. The sampler
+// is started, stopped or reset for the new settings.
+//
+func (slice *plasmaSlice) updateWriterTuning(cfg common.Config) {
+
+	settings := getWriterTuningSettings(cfg, &slice.idxDefn)
+
+	slice.writerLock.Lock()
+	defer slice.writerLock.Unlock()
+
+	changed := false
+	if settings.samplingInterval != slice.samplingInterval {
+		slice.samplingInterval = settings.samplingInterval
+		slice.drainRate = common.NewSample(int(slice.samplingWindow / slice.samplingInterval))
+		slice.mutationRate = common.NewSample(int(slice.samplingWindow / slice.samplingInterval))
+		changed = true
+	}
+	slice.adjustInterval = settings.adjustInterval
+
+	var enable int32
+	if settings.enable {
+		enable = 1
+	}
+	if atomic.SwapInt32(&slice.enableWriterTuning, enable) != enable {
+		logging.Infof("plasmaSlice %v:%v writer tuning enabled %v", slice.idxInstId,
+			slice.idxPartnId, settings.enable)
+		changed = true
+	}
+
+	// the sampler is started along with the writers at creation
+	if slice.token == nil || !changed {
+		return
+	}
+	if slice.samplerRunning {
+		select {
+		case slice.samplerResetCh <- true:
+		default:
+		}
+	} else if settings.enable {
+		go slice.runSampler()
+	}
+}
+
+//
+// Restore the initial number of writers once writer tuning is disabled
+//
+func (slice *plasmaSlice) restoreWriters() {
+
+	slice.writerLock.Lock()
+	defer slice.writerLock.Unlock()
+
+	numWriters := slice.numWritersPerPartition()
+	if slice.numWriters == numWriters {
+		return
+	}
+
+	slice.waitPersist()
+
+	lastNumWriters := slice.numWriters
+	if slice.numWriters > numWriters {
+		slice.stopWriters(numWriters)
+		slice.token.increment(lastNumWriters - numWriters)
+	} else {
+		increment := slice.token.decrement(numWriters-slice.numWriters, true)
+		slice.startWriters(slice.numWriters + increment)
+	}
+
+	logging.Infof("plasmaSlice %v:%v restore writers from %v to %v token %v",
+		slice.idxInstId, slice.idxPartnId, lastNumWriters, slice.numWriters, slice.token.num())
+}
+
 type windowFunc func(sample *common.Sample, count int) float64
 
 //
@@ -0,0 +1,126 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// newPurgeTestFlusher returns a flusher of MAINT_STREAM over non-partitioned
+// indexes instIds, each with a MockSlice.
+func newPurgeTestFlusher(purged *purgedInstSet,
+	instIds ...common.IndexInstId) (*flusher, map[common.IndexInstId]*MockSlice) {
+
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	cfg.SetValue("settings.keyStats.enabled", false)
+	cfg.SetValue("settings.hotKeys.enabled", false)
+
+	f := NewFlusher(cfg, nil)
+	f.purged = purged
+	f.indexInstMap = make(common.IndexInstMap)
+	f.indexPartnMap = make(IndexPartnMap)
+
+	slices := make(map[common.IndexInstId]*MockSlice)
+	for _, instId := range instIds {
+		inst := common.IndexInst{
+			InstId: instId,
+			Defn:   common.IndexDefn{DefnId: common.IndexDefnId(instId), Bucket: "default"},
+			State:  common.INDEX_STATE_ACTIVE,
+			Stream: common.MAINT_STREAM,
+			Pc:     common.NewKeyPartitionContainer(testNumVbuckets, 1, common.SINGLE, common.CRC32),
+		}
+		defn := common.KeyPartitionDefn{Id: common.NON_PARTITION_ID}
+		inst.Pc.AddPartition(common.NON_PARTITION_ID, defn)
+
+		slice := NewMockSlice(0, instId, inst.Defn.DefnId)
+		sc := NewHashedSliceContainer()
+		sc.AddSlice(0, slice)
+
+		f.indexInstMap[instId] = inst
+		f.indexPartnMap[instId] = PartitionInstMap{common.NON_PARTITION_ID: {Defn: defn, Sc: sc}}
+		slices[instId] = slice
+	}
+	return f, slices
+}
+
+// newPurgeTestMutation returns an upsert of docid in vbucket for each of instIds.
+func newPurgeTestMutation(docid string, vbucket Vbucket,
+	instIds ...common.IndexInstId) *MutationKeys {
+
+	mutk := &MutationKeys{
+		meta:  &MutationMeta{keyspaceId: "default", vbucket: vbucket, seqno: 1},
+		docid: []byte(docid),
+	}
+	for _, instId := range instIds {
+		mutk.mut = append(mutk.mut, &Mutation{uuid: instId, command: common.Upsert, key: []byte(`["k"]`)})
+	}
+	return mutk
+}
+
+func TestFlushSkipsPurgedInsts(t *testing.T) {
+	m := &mutationMgr{purgedInsts: &purgedInstSet{}, supvCmdch: make(MsgChannel, 1)}
+	f, slices := newPurgeTestFlusher(m.purgedInsts, 1, 2)
+
+	m.handlePurgeIndexes(&MsgPurgeIndexes{mType: MUT_MGR_PURGE_INDEXES, instIds: []common.IndexInstId{1}})
+	if msg := <-m.supvCmdch; msg.GetMsgType() != MSG_SUCCESS {
+		t.Fatalf("expected success, got %v", msg)
+	}
+
+	f.flush(newPurgeTestMutation("doc", 5, 1, 2), common.MAINT_STREAM)
+
+	if slices[1].IsDirty() {
+		t.Errorf("mutation flushed to purged instance")
+	}
+	if !slices[2].IsDirty() {
+		t.Errorf("mutation not flushed to instance not purged")
+	}
+
+	m.purgedInsts.remove([]common.IndexInstId{1})
+	if m.purgedInsts.contains(1) {
+		t.Errorf("instance removed still purged")
+	}
+}
+
+func TestPurgeIndexesWaitsForFlush(t *testing.T) {
+	m := &mutationMgr{purgedInsts: &purgedInstSet{}, supvCmdch: make(MsgChannel, 1)}
+
+	// a flush in progress is writing mutations of vbucket 5.
+	m.purgedInsts.lockVb(5)
+
+	go m.handlePurgeIndexes(&MsgPurgeIndexes{mType: MUT_MGR_PURGE_INDEXES, instIds: []common.IndexInstId{1}})
+
+	select {
+	case msg := <-m.supvCmdch:
+		t.Fatalf("purge done during the write of a mutation: %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	m.purgedInsts.unlockVb(5)
+
+	select {
+	case msg := <-m.supvCmdch:
+		if msg.GetMsgType() != MSG_SUCCESS {
+			t.Fatalf("expected success, got %v", msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("purge not done once the write of the mutation is done")
+	}
+
+	// slices can be destroyed now, later mutations are skipped.
+	f, slices := newPurgeTestFlusher(m.purgedInsts, 1)
+	slices[1].Close()
+	slices[1].Destroy()
+	f.flush(newPurgeTestMutation("doc", 5, 1), common.MAINT_STREAM)
+	if slices[1].IsDirty() {
+		t.Errorf("mutation flushed to destroyed slice of purged instance")
+	}
+}
@@ -31,7 +31,6 @@ import (
 	"github.com/couchbase/indexing/secondary/audit"
 	c "github.com/couchbase/indexing/secondary/common"
 	forestdb "github.com/couchbase/indexing/secondary/fdb"
-	"github.com/couchbase/indexing/secondary/logging"
 	l "github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/manager"
 	"github.com/couchbase/indexing/secondary/manager/client"
@@ -136,7 +135,7 @@ func NewRebalanceServiceManager(supvCmdch MsgChannel, supvMsgch MsgChannel,
 	supvPrioMsgch MsgChannel, config c.Config, rebalanceRunning bool,
 	rebalanceToken *RebalanceToken, statsMgr *statsManager) *RebalanceServiceManager {
 
-	l.Infof("RebalanceServiceManager::NewRebalanceServiceManager %v %v ", rebalanceRunning, rebalanceToken)
+	rebalanceLog.Infof("RebalanceServiceManager::NewRebalanceServiceManager %v %v ", rebalanceRunning, rebalanceToken)
 
 	mgr := &RebalanceServiceManager{
 		svcMgrMu: &sync.RWMutex{},
@@ -196,7 +195,7 @@ func NewRebalanceServiceManager(supvCmdch MsgChannel, supvMsgch MsgChannel,
 
 func (m *RebalanceServiceManager) initService(cleanupPending bool) {
 
-	l.Infof("RebalanceServiceManager::initService Init")
+	rebalanceLog.Infof("RebalanceServiceManager::initService Init")
 
 	//allow trivial cleanups to finish to reduce noise
 	if cleanupPending {
@@ -219,7 +218,7 @@ func (m *RebalanceServiceManager) updateNodeList() {
 
 	topology, err := getGlobalTopology(m.localhttp)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::updateNodeList Error Fetching Topology %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::updateNodeList Error Fetching Topology %v", err)
 		return
 	}
 
@@ -234,7 +233,7 @@ func (m *RebalanceServiceManager) updateNodeList() {
 			s.servers = nodeList
 		}
 	})
-	l.Infof("RebalanceServiceManager::updateNodeList Updated Node List %v", nodeList)
+	rebalanceLog.Infof("RebalanceServiceManager::updateNodeList Updated Node List %v", nodeList)
 }
 
 //run starts the rebalance manager loop which listens to messages
@@ -249,7 +248,7 @@ loop:
 		case cmd, ok := <-m.supvCmdch:
 			if ok {
 				if cmd.GetMsgType() == ADMIN_MGR_SHUTDOWN {
-					l.Infof("Rebalance Manager: Shutting Down")
+					rebalanceLog.Infof("Rebalance Manager: Shutting Down")
 					m.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -273,7 +272,7 @@ func (m *RebalanceServiceManager) handleSupervisorCommands(cmd Message) {
 		m.handleIndexerReady(cmd)
 
 	default:
-		l.Fatalf("RebalanceServiceManager::handleSupervisorCommands Unknown Message %+v", cmd)
+		rebalanceLog.Fatalf("RebalanceServiceManager::handleSupervisorCommands Unknown Message %+v", cmd)
 		c.CrashOnError(errors.New("Unknown Msg On Supv Channel"))
 	}
 
@@ -318,7 +317,7 @@ func (m *RebalanceServiceManager) Shutdown() error {
 func (m *RebalanceServiceManager) GetTaskList(rev service.Revision,
 	cancel service.Cancel) (*service.TaskList, error) {
 
-	l.Infof("RebalanceServiceManager::GetTaskList %v", rev)
+	rebalanceLog.Infof("RebalanceServiceManager::GetTaskList %v", rev)
 
 	currState, err := m.wait(rev, cancel)
 	if err != nil {
@@ -326,14 +325,14 @@ func (m *RebalanceServiceManager) GetTaskList(rev service.Revision,
 	}
 
 	taskList := stateToTaskList(currState)
-	l.Infof("RebalanceServiceManager::GetTaskList returns %v", taskList)
+	rebalanceLog.Infof("RebalanceServiceManager::GetTaskList returns %v", taskList)
 
 	return taskList, nil
 }
 
 // CancelTask is an external API called by ns_server (via cbauth).
 func (m *RebalanceServiceManager) CancelTask(id string, rev service.Revision) error {
-	l.Infof("RebalanceServiceManager::CancelTask %v %v", id, rev)
+	rebalanceLog.Infof("RebalanceServiceManager::CancelTask %v %v", id, rev)
 
 	currState := m.copyState()
 	tasks := stateToTaskList(currState).Tasks
@@ -357,7 +356,7 @@ func (m *RebalanceServiceManager) CancelTask(id string, rev service.Revision) er
 	}
 
 	if rev != nil && !bytes.Equal(rev, task.Rev) {
-		l.Errorf("RebalanceServiceManager::CancelTask %v %v", rev, task.Rev)
+		rebalanceLog.Errorf("RebalanceServiceManager::CancelTask %v %v", rev, task.Rev)
 		return service.ErrConflict
 	}
 
@@ -370,7 +369,7 @@ func (m *RebalanceServiceManager) CancelTask(id string, rev service.Revision) er
 func (m *RebalanceServiceManager) GetCurrentTopology(rev service.Revision,
 	cancel service.Cancel) (*service.Topology, error) {
 
-	l.Infof("RebalanceServiceManager::GetCurrentTopology %v", rev)
+	rebalanceLog.Infof("RebalanceServiceManager::GetCurrentTopology %v", rev)
 
 	currState, err := m.wait(rev, cancel)
 	if err != nil {
@@ -379,7 +378,7 @@ func (m *RebalanceServiceManager) GetCurrentTopology(rev service.Revision,
 
 	topology := m.stateToTopology(currState)
 
-	l.Infof("RebalanceServiceManager::GetCurrentTopology returns %v", topology)
+	rebalanceLog.Infof("RebalanceServiceManager::GetCurrentTopology returns %v", topology)
 
 	return topology, nil
 }
@@ -391,11 +390,11 @@ func (m *RebalanceServiceManager) GetCurrentTopology(rev service.Revision,
 //All errors need to be reported as return value. Status of prepared task is not
 //considered for failure reporting.
 func (m *RebalanceServiceManager) PrepareTopologyChange(change service.TopologyChange) error {
-	l.Infof("RebalanceServiceManager::PrepareTopologyChange %v", change)
+	rebalanceLog.Infof("RebalanceServiceManager::PrepareTopologyChange %v", change)
 
 	currState := m.copyState()
 	if currState.rebalanceID != "" {
-		l.Errorf("RebalanceServiceManager::PrepareTopologyChange err %v %v",
+		rebalanceLog.Errorf("RebalanceServiceManager::PrepareTopologyChange err %v %v",
 			service.ErrConflict, currState.rebalanceID)
 		if change.Type == service.TopologyChangeTypeRebalance {
 			m.setStateIsBalanced(false)
@@ -429,7 +428,7 @@ func (m *RebalanceServiceManager) PrepareTopologyChange(change service.TopologyC
 		s.servers = nodeList
 	})
 
-	logging.Infof("RebalanceServiceManager::PrepareTopologyChange Success. isBalanced %v",
+	rebalanceLog.Infof("RebalanceServiceManager::PrepareTopologyChange Success. isBalanced %v",
 		currState.isBalanced)
 	return nil
 }
@@ -448,7 +447,7 @@ func (m *RebalanceServiceManager) prepareFailover(change service.TopologyChange)
 	var err error
 	if m.rebalanceToken != nil && m.rebalanceToken.Source == RebalSourceClusterOp {
 
-		l.Infof("%v Found Rebalance In Progress %v", method, m.rebalanceToken)
+		rebalanceLog.Infof("%v Found Rebalance In Progress %v", method, m.rebalanceToken)
 
 		if m.rebalancerF != nil {
 			m.rebalancerF.Cancel()
@@ -463,7 +462,7 @@ func (m *RebalanceServiceManager) prepareFailover(change service.TopologyChange)
 			}
 		}
 		if !masterAlive {
-			l.Infof("%v Master Missing From Cluster Node List. Cleanup", method)
+			rebalanceLog.Infof("%v Master Missing From Cluster Node List. Cleanup", method)
 			err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 		} else {
 			err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, false)
@@ -472,14 +471,14 @@ func (m *RebalanceServiceManager) prepareFailover(change service.TopologyChange)
 	}
 
 	if m.rebalanceRunning && m.rebalanceToken.Source == RebalSourceClusterOp {
-		l.Infof("%v Found Node In Prepared State. Cleanup.", method)
+		rebalanceLog.Infof("%v Found Node In Prepared State. Cleanup.", method)
 		err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, false)
 		return err
 	}
 
 	if m.rebalanceToken != nil && m.rebalanceToken.Source == RebalSourceMoveIndex {
 
-		l.Infof("%v Found Move Index In Progress %v. Aborting.", method, m.rebalanceToken)
+		rebalanceLog.Infof("%v Found Move Index In Progress %v. Aborting.", method, m.rebalanceToken)
 
 		masterAlive := false
 		masterCleanup := false
@@ -491,7 +490,7 @@ func (m *RebalanceServiceManager) prepareFailover(change service.TopologyChange)
 		}
 
 		if !masterAlive {
-			l.Infof("%v Master Missing From Cluster Node List. Cleanup MoveIndex As Master.",
+			rebalanceLog.Infof("%v Master Missing From Cluster Node List. Cleanup MoveIndex As Master.",
 				method)
 			masterCleanup = true
 		}
@@ -534,18 +533,18 @@ func (m *RebalanceServiceManager) prepareRebalance(change service.TopologyChange
 		m.setStateIsBalanced(false)
 		err = errors.New("indexer rebalance failure - cleanup pending from previous  " +
 			"failed/aborted rebalance/failover/move index. please retry the request later.")
-		l.Errorf("%v %v", method, err)
+		rebalanceLog.Errorf("%v %v", method, err)
 		return err
 	}
 
 	if m.rebalanceToken != nil && m.rebalanceToken.Source == RebalSourceMoveIndex {
 		err = errors.New("indexer rebalance failure - move index in progress")
-		l.Errorf("%v %v", method, err)
+		rebalanceLog.Errorf("%v %v", method, err)
 		return err
 	}
 
 	if m.rebalanceToken != nil && m.rebalanceToken.Source == RebalSourceClusterOp {
-		l.Warnf("%v Found Rebalance In Progress. Cleanup.", method)
+		rebalanceLog.Warnf("%v Found Rebalance In Progress. Cleanup.", method)
 		if m.rebalancerF != nil {
 			m.rebalancerF.Cancel()
 			m.rebalancerF = nil
@@ -556,14 +555,14 @@ func (m *RebalanceServiceManager) prepareRebalance(change service.TopologyChange
 	}
 
 	if m.checkRebalanceRunning() {
-		l.Warnf("%v Found Rebalance Running Flag. Cleanup Prepare Phase", method)
+		rebalanceLog.Warnf("%v Found Rebalance Running Flag. Cleanup Prepare Phase", method)
 		if err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, false); err != nil {
 			return err
 		}
 	}
 
 	if m.checkLocalCleanupPending() {
-		l.Warnf("%v Found Pending Local Cleanup Token. Run Cleanup.", method)
+		rebalanceLog.Warnf("%v Found Pending Local Cleanup Token. Run Cleanup.", method)
 		if err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, false); err != nil {
 			return err
 		}
@@ -571,21 +570,21 @@ func (m *RebalanceServiceManager) prepareRebalance(change service.TopologyChange
 		if m.checkLocalCleanupPending() {
 			err = errors.New("indexer rebalance failure - cleanup pending from previous  " +
 				"failed/aborted rebalance/failover/move index. please retry the request later.")
-			l.Errorf("%v %v", method, err)
+			rebalanceLog.Errorf("%v %v", method, err)
 			return err
 		}
 	}
 
 	if c.GetBuildMode() == c.ENTERPRISE {
 		m.p.ddlRunning, m.p.ddlRunningIndexNames = m.checkDDLRunning()
-		l.Infof("%v Found DDL Running %v", method, m.p.ddlRunningIndexNames)
+		rebalanceLog.Infof("%v Found DDL Running %v", method, m.p.ddlRunningIndexNames)
 	}
 
-	l.Infof("%v Init Prepare Phase", method)
+	rebalanceLog.Infof("%v Init Prepare Phase", method)
 
 	if isSingleNodeRebal(change) && change.KeepNodes[0].NodeInfo.NodeID != m.nodeInfo.NodeID {
 		err := errors.New("indexer - node receiving prepare request not part of cluster")
-		l.Errorf("%v %v", method, err)
+		rebalanceLog.Errorf("%v %v", method, err)
 		return err
 	} else {
 		if err := m.initPreparePhaseRebalance(); err != nil {
@@ -603,7 +602,7 @@ func (m *RebalanceServiceManager) prepareRebalance(change service.TopologyChange
 func (m *RebalanceServiceManager) StartTopologyChange(change service.TopologyChange) error {
 	const method = "RebalanceServiceManager::StartTopologyChange:" // for logging
 
-	l.Infof("%v change: %v", method, change)
+	rebalanceLog.Infof("%v change: %v", method, change)
 
 	// To avoid having more than one Rebalancer object at a time, we must hold svcMgrMu write locked from
 	// the check for nil m.rebalancer through execution of children startFailover or startRebalance
@@ -614,7 +613,7 @@ func (m *RebalanceServiceManager) StartTopologyChange(change service.TopologyCha
 	currState := m.copyState()
 	rebalancer := m.rebalancer
 	if currState.rebalanceID != change.ID || rebalancer != nil {
-		l.Errorf("%v err %v %v %v %v", method, service.ErrConflict,
+		rebalanceLog.Errorf("%v err %v %v %v %v", method, service.ErrConflict,
 			currState.rebalanceID, change.ID, rebalancer)
 		if change.Type == service.TopologyChangeTypeRebalance {
 			m.setStateIsBalanced(false)
@@ -625,7 +624,7 @@ func (m *RebalanceServiceManager) StartTopologyChange(change service.TopologyCha
 	if change.CurrentTopologyRev != nil {
 		haveRev := DecodeRev(change.CurrentTopologyRev)
 		if haveRev != currState.rev {
-			l.Errorf("%v err %v %v %v", method, service.ErrConflict, haveRev, currState.rev)
+			rebalanceLog.Errorf("%v err %v %v %v", method, service.ErrConflict, haveRev, currState.rev)
 			if change.Type == service.TopologyChangeTypeRebalance {
 				m.setStateIsBalanced(false)
 			}
@@ -646,7 +645,7 @@ func (m *RebalanceServiceManager) StartTopologyChange(change service.TopologyCha
 		err = service.ErrNotSupported
 	}
 
-	logging.Infof("%v returns Error %v. isBalanced %v.", method, err, currState.isBalanced)
+	rebalanceLog.Infof("%v returns Error %v. isBalanced %v.", method, err, currState.isBalanced)
 	return err
 }
 
@@ -680,7 +679,7 @@ func (m *RebalanceServiceManager) startRebalance(change service.TopologyChange)
 
 	if isSingleNodeRebal(change) && change.KeepNodes[0].NodeInfo.NodeID != m.nodeInfo.NodeID {
 		err := errors.New("Node receiving Start request not part of cluster")
-		l.Errorf("RebalanceServiceManager::startRebalance %v Self %v Cluster %v", err, m.nodeInfo.NodeID,
+		rebalanceLog.Errorf("RebalanceServiceManager::startRebalance %v Self %v Cluster %v", err, m.nodeInfo.NodeID,
 			change.KeepNodes[0].NodeInfo.NodeID)
 		m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 		return err
@@ -690,27 +689,27 @@ func (m *RebalanceServiceManager) startRebalance(change service.TopologyChange)
 
 		err = m.cleanupOrphanTokens(change)
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::startRebalance Error During Cleanup Orphan Tokens %v", err)
+			rebalanceLog.Errorf("RebalanceServiceManager::startRebalance Error During Cleanup Orphan Tokens %v", err)
 			m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 			return err
 		}
 
 		rtoken, err := m.checkExistingGlobalRToken()
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::startRebalance Error Checking Global RToken %v", err)
+			rebalanceLog.Errorf("RebalanceServiceManager::startRebalance Error Checking Global RToken %v", err)
 			m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 			return err
 		}
 
 		if rtoken != nil {
-			l.Errorf("RebalanceServiceManager::startRebalance Found Existing Global RToken %v", rtoken)
+			rebalanceLog.Errorf("RebalanceServiceManager::startRebalance Found Existing Global RToken %v", rtoken)
 			m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 			return errors.New("Protocol Conflict Error: Existing Rebalance Token Found")
 		}
 
 		err, skipRebalance = m.initStartPhase(change)
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::startRebalance Error During Start Phase Init %v", err)
+			rebalanceLog.Errorf("RebalanceServiceManager::startRebalance Error During Start Phase Init %v", err)
 			m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 			return err
 		}
@@ -718,13 +717,13 @@ func (m *RebalanceServiceManager) startRebalance(change service.TopologyChange)
 		cfg := m.config.Load()
 
 		if c.GetBuildMode() != c.ENTERPRISE {
-			l.Infof("RebalanceServiceManager::startRebalance skip planner for non-enterprise edition")
+			rebalanceLog.Infof("RebalanceServiceManager::startRebalance skip planner for non-enterprise edition")
 			runPlanner = false
 		} else if cfg["rebalance.disable_index_move"].Bool() {
-			l.Infof("RebalanceServiceManager::startRebalance skip planner as disable_index_move is set")
+			rebalanceLog.Infof("RebalanceServiceManager::startRebalance skip planner as disable_index_move is set")
 			runPlanner = false
 		} else if skipRebalance {
-			l.Infof("RebalanceServiceManager::startRebalance skip planner due to skipRebalance flag")
+			rebalanceLog.Infof("RebalanceServiceManager::startRebalance skip planner due to skipRebalance flag")
 			runPlanner = false
 		} else {
 			runPlanner = true
@@ -804,7 +803,7 @@ func (m *RebalanceServiceManager) checkExistingGlobalRToken() (*RebalanceToken,
 	var err error
 	found, err = c.MetakvGet(RebalanceTokenPath, &rtoken)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::checkExistingGlobalRToken Error Fetching "+
+		rebalanceLog.Errorf("RebalanceServiceManager::checkExistingGlobalRToken Error Fetching "+
 			"Rebalance Token From Metakv %v. Path %v", err, RebalanceTokenPath)
 		return nil, err
 	}
@@ -814,7 +813,7 @@ func (m *RebalanceServiceManager) checkExistingGlobalRToken() (*RebalanceToken,
 
 	found, err = c.MetakvGet(MoveIndexTokenPath, &rtoken)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::checkExistingGlobalRToken Error Fetching "+
+		rebalanceLog.Errorf("RebalanceServiceManager::checkExistingGlobalRToken Error Fetching "+
 			"MoveIndex Token From Metakv %v. Path %v", err, MoveIndexTokenPath)
 		return nil, err
 	}
@@ -865,9 +864,9 @@ func (m *RebalanceServiceManager) registerRebalanceRunning(checkDDL bool) error
 		if errMsg == ErrDDLRunning {
 			m.p.ddlRunning = true
 			m.p.ddlRunningIndexNames = resp.GetInProgressIndexes()
-			l.Infof("RebalanceServiceManager::registerRebalanceRunning Found DDL Running %v", m.p.ddlRunningIndexNames)
+			rebalanceLog.Infof("RebalanceServiceManager::registerRebalanceRunning Found DDL Running %v", m.p.ddlRunningIndexNames)
 		} else {
-			l.Errorf("RebalanceServiceManager::registerRebalanceRunning Unable to set RebalanceRunning In Local"+
+			rebalanceLog.Errorf("RebalanceServiceManager::registerRebalanceRunning Unable to set RebalanceRunning In Local"+
 				"Meta Storage. Err %v", errMsg)
 
 			return errMsg
@@ -883,7 +882,7 @@ func (m *RebalanceServiceManager) registerRebalanceRunning(checkDDL bool) error
 // runCleanupPhaseLOCKED caller should be holding mutex svcMgrMu write(?) locked.
 func (m *RebalanceServiceManager) runCleanupPhaseLOCKED(path string, isMaster bool) error {
 
-	l.Infof("RebalanceServiceManager::runCleanupPhase path %v isMaster %v", path, isMaster)
+	rebalanceLog.Infof("RebalanceServiceManager::runCleanupPhase path %v isMaster %v", path, isMaster)
 
 	if m.monitorStopCh != nil {
 		close(m.monitorStopCh)
@@ -900,13 +899,13 @@ func (m *RebalanceServiceManager) runCleanupPhaseLOCKED(path string, isMaster bo
 	if m.indexerReady {
 		rtokens, err := m.getCurrRebalTokens()
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::runCleanupPhase Error Fetching Metakv Tokens %v", err)
+			rebalanceLog.Errorf("RebalanceServiceManager::runCleanupPhase Error Fetching Metakv Tokens %v", err)
 		}
 
 		if rtokens != nil && len(rtokens.TT) != 0 {
 			err := m.cleanupTransferTokens(rtokens.TT)
 			if err != nil {
-				l.Errorf("RebalanceServiceManager::runCleanupPhase Error Cleaning Transfer Tokens %v", err)
+				rebalanceLog.Errorf("RebalanceServiceManager::runCleanupPhase Error Cleaning Transfer Tokens %v", err)
 			}
 		}
 	}
@@ -929,16 +928,16 @@ func (m *RebalanceServiceManager) cleanupGlobalRToken(path string) error {
 	var rtoken RebalanceToken
 	found, err := c.MetakvGet(path, &rtoken)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::cleanupGlobalRToken Error Fetching Rebalance Token From Metakv %v. Path %v", err, path)
+		rebalanceLog.Errorf("RebalanceServiceManager::cleanupGlobalRToken Error Fetching Rebalance Token From Metakv %v. Path %v", err, path)
 		return err
 	}
 
 	if found {
-		l.Infof("RebalanceServiceManager::cleanupGlobalRToken Delete Global Rebalance Token %v", rtoken)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupGlobalRToken Delete Global Rebalance Token %v", rtoken)
 
 		err := c.MetakvDel(path)
 		if err != nil {
-			l.Fatalf("RebalanceServiceManager::cleanupGlobalRToken Unable to delete RebalanceToken from "+
+			rebalanceLog.Fatalf("RebalanceServiceManager::cleanupGlobalRToken Unable to delete RebalanceToken from "+
 				"Meta Storage. %v. Err %v", rtoken, err)
 			return err
 		}
@@ -954,7 +953,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 
 	rtokens, err := m.getCurrRebalTokens()
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::cleanupOrphanTokens Error Fetching Metakv Tokens %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::cleanupOrphanTokens Error Fetching Metakv Tokens %v", err)
 		return err
 	}
 
@@ -965,7 +964,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 	cleanup := func(path, token string) error {
 		err := c.MetakvDel(path)
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::cleanupOrphanTokens Unable to delete %v from "+
+			rebalanceLog.Errorf("RebalanceServiceManager::cleanupOrphanTokens Unable to delete %v from "+
 				"Meta Storage. %v. Err %v", token, rtokens.RT, err)
 			return err
 		}
@@ -974,7 +973,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 
 	masterAlive := false
 	if rtokens.RT != nil {
-		l.Infof("RebalanceServiceManager::cleanupOrphanTokens Found Token %v", rtokens.RT)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupOrphanTokens Found Token %v", rtokens.RT)
 
 		for _, node := range change.KeepNodes {
 			if rtokens.RT.MasterId == string(node.NodeInfo.NodeID) {
@@ -983,7 +982,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 			}
 		}
 		if !masterAlive || rtokens.RT.Error != "" {
-			l.Infof("RebalanceServiceManager::cleanupOrphanTokens Cleaning Up Token %v as masterAlive: %v, err: %v",
+			rebalanceLog.Infof("RebalanceServiceManager::cleanupOrphanTokens Cleaning Up Token %v as masterAlive: %v, err: %v",
 				rtokens.RT, masterAlive, rtokens.RT.Error)
 			if err := cleanup(RebalanceTokenPath, "RebalanceToken"); err != nil {
 				return err
@@ -993,7 +992,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 
 	masterAlive = false
 	if rtokens.MT != nil {
-		l.Infof("RebalanceServiceManager::cleanupOrphanTokens Found MoveIndexToken %v", rtokens.MT)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupOrphanTokens Found MoveIndexToken %v", rtokens.MT)
 
 		for _, node := range change.KeepNodes {
 			if rtokens.MT.MasterId == string(node.NodeInfo.NodeID) {
@@ -1002,7 +1001,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 			}
 		}
 		if !masterAlive || rtokens.MT.Error != "" {
-			l.Infof("RebalanceServiceManager::cleanupOrphanTokens Cleaning Up Token %v as masterAlive: %v, err: %v",
+			rebalanceLog.Infof("RebalanceServiceManager::cleanupOrphanTokens Cleaning Up Token %v as masterAlive: %v, err: %v",
 				rtokens.MT, masterAlive, rtokens.MT.Error)
 			if err := cleanup(MoveIndexTokenPath, "MoveIndexToken"); err != nil {
 				return err
@@ -1024,10 +1023,10 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 		}
 
 		if !ownerAlive {
-			l.Infof("RebalanceServiceManager::cleanupOrphanTokens Cleaning Up Token Owner %v %v %v", ownerId, ttid, tt)
+			rebalanceLog.Infof("RebalanceServiceManager::cleanupOrphanTokens Cleaning Up Token Owner %v %v %v", ownerId, ttid, tt)
 			err := c.MetakvDel(RebalanceMetakvDir + ttid)
 			if err != nil {
-				l.Errorf("RebalanceServiceManager::cleanupOrphanTokens Unable to delete TransferToken from "+
+				rebalanceLog.Errorf("RebalanceServiceManager::cleanupOrphanTokens Unable to delete TransferToken from "+
 					"Meta Storage. %v. Err %v", ttid, err)
 				return err
 			}
@@ -1041,7 +1040,7 @@ func (m *RebalanceServiceManager) cleanupOrphanTokens(change service.TopologyCha
 func (m *RebalanceServiceManager) cleanupTransferTokens(tts map[string]*c.TransferToken) error {
 
 	if tts == nil || len(tts) == 0 {
-		l.Infof("RebalanceServiceManager::cleanupTransferTokens No Tokens Found For Cleanup")
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupTransferTokens No Tokens Found For Cleanup")
 		return nil
 	}
 
@@ -1061,7 +1060,7 @@ func (m *RebalanceServiceManager) cleanupTransferTokens(tts map[string]*c.Transf
 	// cleanup transfer token
 	for ttid, tt := range tts {
 
-		l.Infof("RebalanceServiceManager::cleanupTransferTokens Cleaning Up %v %v", ttid, tt)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupTransferTokens Cleaning Up %v %v", ttid, tt)
 
 		if tt.MasterId == string(m.nodeInfo.NodeID) {
 			m.cleanupTransferTokensForMaster(ttid, tt)
@@ -1083,10 +1082,10 @@ func (m *RebalanceServiceManager) cleanupTransferTokensForMaster(ttid string, tt
 	switch tt.State {
 
 	case c.TransferTokenCommit, c.TransferTokenDeleted:
-		l.Infof("RebalanceServiceManager::cleanupTransferTokensForMaster Cleanup Token %v %v", ttid, tt)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupTransferTokensForMaster Cleanup Token %v %v", ttid, tt)
 		err := c.MetakvDel(RebalanceMetakvDir + ttid)
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::cleanupTransferTokensForMaster Unable to delete TransferToken In "+
+			rebalanceLog.Errorf("RebalanceServiceManager::cleanupTransferTokensForMaster Unable to delete TransferToken In "+
 				"Meta Storage. %v. Err %v", tt, err)
 			return err
 		}
@@ -1103,7 +1102,7 @@ func (m *RebalanceServiceManager) cleanupTransferTokensForSource(ttid string, tt
 
 	case c.TransferTokenReady:
 		var err error
-		l.Infof("RebalanceServiceManager::cleanupTransferTokensForSource Cleanup Token %v %v", ttid, tt)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupTransferTokensForSource Cleanup Token %v %v", ttid, tt)
 		defn := tt.IndexInst.Defn
 		defn.InstId = tt.InstId
 		defn.RealInstId = tt.RealInstId
@@ -1111,7 +1110,7 @@ func (m *RebalanceServiceManager) cleanupTransferTokensForSource(ttid string, tt
 		if err == nil {
 			err = c.MetakvDel(RebalanceMetakvDir + ttid)
 			if err != nil {
-				l.Errorf("RebalanceServiceManager::cleanupTransferTokensForSource Unable to delete TransferToken In "+
+				rebalanceLog.Errorf("RebalanceServiceManager::cleanupTransferTokensForSource Unable to delete TransferToken In "+
 					"Meta Storage. %v. Err %v", tt, err)
 				return err
 			}
@@ -1126,7 +1125,7 @@ func (m *RebalanceServiceManager) cleanupTransferTokensForDest(ttid string, tt *
 
 	cleanup := func() error {
 		var err error
-		l.Infof("RebalanceServiceManager::cleanupTransferTokensForDest Cleanup Token %v %v", ttid, tt)
+		rebalanceLog.Infof("RebalanceServiceManager::cleanupTransferTokensForDest Cleanup Token %v %v", ttid, tt)
 		defn := tt.IndexInst.Defn
 		defn.InstId = tt.InstId
 		defn.RealInstId = tt.RealInstId
@@ -1134,7 +1133,7 @@ func (m *RebalanceServiceManager) cleanupTransferTokensForDest(ttid string, tt *
 		if err == nil {
 			err = c.MetakvDel(RebalanceMetakvDir + ttid)
 			if err != nil {
-				l.Errorf("RebalanceServiceManager::cleanupTransferTokensForDest Unable to delete TransferToken In "+
+				rebalanceLog.Errorf("RebalanceServiceManager::cleanupTransferTokensForDest Unable to delete TransferToken In "+
 					"Meta Storage. %v. Err %v", tt, err)
 				return err
 			}
@@ -1158,7 +1157,7 @@ func (m *RebalanceServiceManager) cleanupTransferTokensForDest(ttid string, tt *
 
 		if !ok {
 			if err := c.MetakvDel(RebalanceMetakvDir + ttid); err != nil {
-				l.Errorf("RebalanceServiceManager::cleanupTransferTokensForDest Unable to delete TransferToken In "+
+				rebalanceLog.Errorf("RebalanceServiceManager::cleanupTransferTokensForDest Unable to delete TransferToken In "+
 					"Meta Storage. %v. Err %v", tt, err)
 				return err
 			}
@@ -1182,7 +1181,7 @@ func (m *RebalanceServiceManager) cleanupIndex(indexDefn c.IndexDefn) error {
 	req := manager.IndexRequest{Index: indexDefn}
 	body, err := json.Marshal(&req)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::cleanupIndex Error marshal drop index %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::cleanupIndex Error marshal drop index %v", err)
 		return err
 	}
 
@@ -1193,22 +1192,22 @@ func (m *RebalanceServiceManager) cleanupIndex(indexDefn c.IndexDefn) error {
 	url := "/dropIndex"
 	resp, err := postWithAuth(localaddr+url, "application/json", bodybuf)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::cleanupIndex Error drop index on %v %v", localaddr+url, err)
+		rebalanceLog.Errorf("RebalanceServiceManager::cleanupIndex Error drop index on %v %v", localaddr+url, err)
 		return err
 	}
 	defer resp.Body.Close()
 	bytes, _ := ioutil.ReadAll(resp.Body)
 	response := new(manager.IndexResponse)
 	if err := json.Unmarshal(bytes, &response); err != nil {
-		l.Errorf("RebalanceServiceManager::cleanupIndex Error unmarshal response %v %v", localaddr+url, err)
+		rebalanceLog.Errorf("RebalanceServiceManager::cleanupIndex Error unmarshal response %v %v", localaddr+url, err)
 		return err
 	}
 	if response.Code == manager.RESP_ERROR {
 		if strings.Contains(response.Error, forestdb.FDB_RESULT_KEY_NOT_FOUND.Error()) {
-			l.Errorf("RebalanceServiceManager::cleanupIndex Error dropping index %v %v. Ignored.", localaddr+url, response.Error)
+			rebalanceLog.Errorf("RebalanceServiceManager::cleanupIndex Error dropping index %v %v. Ignored.", localaddr+url, response.Error)
 			return nil
 		}
-		l.Errorf("RebalanceServiceManager::cleanupIndex Error dropping index %v %v", localaddr+url, response.Error)
+		rebalanceLog.Errorf("RebalanceServiceManager::cleanupIndex Error dropping index %v %v", localaddr+url, response.Error)
 		return err
 	}
 
@@ -1218,7 +1217,7 @@ func (m *RebalanceServiceManager) cleanupIndex(indexDefn c.IndexDefn) error {
 
 func (m *RebalanceServiceManager) cleanupRebalanceRunning() error {
 
-	l.Infof("RebalanceServiceManager::cleanupRebalanceRunning Cleanup")
+	rebalanceLog.Infof("RebalanceServiceManager::cleanupRebalanceRunning Cleanup")
 
 	respch := make(MsgChannel)
 	m.supvMsgch <- &MsgClustMgrLocal{
@@ -1232,7 +1231,7 @@ func (m *RebalanceServiceManager) cleanupRebalanceRunning() error {
 
 	errMsg := resp.GetError()
 	if errMsg != nil {
-		l.Fatalf("RebalanceServiceManager::cleanupRebalanceRunning Unable to delete RebalanceRunning In Local"+
+		rebalanceLog.Fatalf("RebalanceServiceManager::cleanupRebalanceRunning Unable to delete RebalanceRunning In Local"+
 			"Meta Storage. Err %v", errMsg)
 		c.CrashOnError(errMsg)
 	}
@@ -1248,7 +1247,7 @@ func (m *RebalanceServiceManager) cleanupRebalanceRunning() error {
 
 func (m *RebalanceServiceManager) cleanupLocalRToken() error {
 
-	l.Infof("RebalanceServiceManager::cleanupLocalRToken Cleanup")
+	rebalanceLog.Infof("RebalanceServiceManager::cleanupLocalRToken Cleanup")
 
 	respch := make(MsgChannel)
 	m.supvMsgch <- &MsgClustMgrLocal{
@@ -1262,7 +1261,7 @@ func (m *RebalanceServiceManager) cleanupLocalRToken() error {
 
 	errMsg := resp.GetError()
 	if errMsg != nil {
-		l.Fatalf("RebalanceServiceManager::cleanupLocalRToken Unable to delete Rebalance Token In Local"+
+		rebalanceLog.Fatalf("RebalanceServiceManager::cleanupLocalRToken Unable to delete Rebalance Token In Local"+
 			"Meta Storage. Path %v Err %v", RebalanceTokenPath, errMsg)
 		c.CrashOnError(errMsg)
 	}
@@ -1293,13 +1292,13 @@ loop:
 				defer c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, m.svcMgrMu, "svcMgrMu", method, "")
 
 				if m.rebalanceToken == nil && elapsed > startPhaseBeginTimeout {
-					l.Infof("%v Timeout Waiting for RebalanceToken. Cleanup Prepare Phase",
+					rebalanceLog.Infof("%v Timeout Waiting for RebalanceToken. Cleanup Prepare Phase",
 						method)
 					//TODO handle server side differently
 					m.runCleanupPhaseLOCKED(RebalanceTokenPath, false)
 					done = true
 				} else if m.rebalanceToken != nil {
-					l.Infof("%v Found RebalanceToken %v.", method, m.rebalanceToken)
+					rebalanceLog.Infof("%v Found RebalanceToken %v.", method, m.rebalanceToken)
 					m.monitorStopCh = nil
 					done = true
 				}
@@ -1323,19 +1322,19 @@ func (m *RebalanceServiceManager) rebalanceJanitor() {
 	for {
 		time.Sleep(time.Second * 30)
 
-		l.Infof("%v Running Periodic Cleanup", _rebalanceJanitor)
+		rebalanceLog.Infof("%v Running Periodic Cleanup", _rebalanceJanitor)
 		lockTime := c.TraceRWMutexLOCK(c.LOCK_WRITE, m.svcMgrMu, "svcMgrMu", _rebalanceJanitor, "")
 		if !m.rebalanceRunning {
 			rtokens, err := m.getCurrRebalTokens()
 			if err != nil {
-				l.Errorf("%v Error Fetching Metakv Tokens %v", _rebalanceJanitor, err)
+				rebalanceLog.Errorf("%v Error Fetching Metakv Tokens %v", _rebalanceJanitor, err)
 			}
 
 			if rtokens != nil && len(rtokens.TT) != 0 {
-				l.Infof("%v Found %v tokens. Cleaning up.", _rebalanceJanitor, len(rtokens.TT))
+				rebalanceLog.Infof("%v Found %v tokens. Cleaning up.", _rebalanceJanitor, len(rtokens.TT))
 				err := m.cleanupTransferTokens(rtokens.TT)
 				if err != nil {
-					l.Errorf("%v Error Cleaning Transfer Tokens %v", _rebalanceJanitor, err)
+					rebalanceLog.Errorf("%v Error Cleaning Transfer Tokens %v", _rebalanceJanitor, err)
 				}
 			}
 
@@ -1343,10 +1342,10 @@ func (m *RebalanceServiceManager) rebalanceJanitor() {
 				cfg := m.config.Load()
 				nodeID := cfg["nodeuuid"].String()
 				if rtokens.MT.Error != "" && rtokens.MT.MasterId == nodeID { // let janitor in master node clean-up the move token
-					l.Infof("%v Found erroneous MoveIndexToken: %v. Cleaning up.", _rebalanceJanitor, rtokens.MT)
+					rebalanceLog.Infof("%v Found erroneous MoveIndexToken: %v. Cleaning up.", _rebalanceJanitor, rtokens.MT)
 					err := c.MetakvDel(MoveIndexTokenPath)
 					if err != nil {
-						l.Errorf("%v Unable to delete MoveIndexToken from Meta Storage. %v. Err %v", _rebalanceJanitor, rtokens.RT, err)
+						rebalanceLog.Errorf("%v Unable to delete MoveIndexToken from Meta Storage. %v. Err %v", _rebalanceJanitor, rtokens.RT, err)
 					}
 				}
 			}
@@ -1432,11 +1431,11 @@ func (m *RebalanceServiceManager) registerLocalRebalanceToken(rebalToken *Rebala
 
 	errMsg := resp.GetError()
 	if errMsg != nil {
-		l.Errorf("%v Unable to set RebalanceToken In Local Meta Storage. Err %v",
+		rebalanceLog.Errorf("%v Unable to set RebalanceToken In Local Meta Storage. Err %v",
 			method, errMsg)
 		return err
 	}
-	l.Infof("%v Registered Rebalance Token In Local Meta %v", method, rebalToken)
+	rebalanceLog.Infof("%v Registered Rebalance Token In Local Meta %v", method, rebalToken)
 
 	return nil
 }
@@ -1447,10 +1446,10 @@ func (m *RebalanceServiceManager) registerRebalanceTokenInMetakv(rebalToken *Reb
 
 	err := c.MetakvSet(RebalanceTokenPath, rebalToken)
 	if err != nil {
-		l.Errorf("%v Unable to set RebalanceToken In Metakv Storage. Err %v", method, err)
+		rebalanceLog.Errorf("%v Unable to set RebalanceToken In Metakv Storage. Err %v", method, err)
 		return err
 	}
-	l.Infof("%v Registered Global Rebalance Token In Metakv %v", method, rebalToken)
+	rebalanceLog.Infof("%v Registered Global Rebalance Token In Metakv %v", method, rebalToken)
 
 	return nil
 }
@@ -1475,14 +1474,14 @@ func (m *RebalanceServiceManager) registerGlobalRebalanceToken(rebalToken *Rebal
 		nids = m.cinfo.GetNodesByServiceType(c.INDEX_HTTP_SERVICE)
 		if len(nids) != numKnownNodes { // invalid case
 			if isSingleNodeRebal(change) {
-				l.Infof("%v ClusterInfo Node List doesn't match Known Nodes in Rebalance"+
+				rebalanceLog.Infof("%v ClusterInfo Node List doesn't match Known Nodes in Rebalance"+
 					" Request. Skip Rebalance. cinfo.nodes : %v, change : %v",
 					method, m.cinfo.Nodes(), change)
 				return nil, true
 			}
 
 			err = errors.New("ClusterInfo Node List doesn't match Known Nodes in Rebalance Request")
-			l.Errorf("%v err: %v, nids: %v, allKnownNodes: %v. Retrying %v",
+			rebalanceLog.Errorf("%v err: %v, nids: %v, allKnownNodes: %v. Retrying %v",
 				method, err, nids, numKnownNodes, i)
 
 			if i+1 <= maxRetry { // will retry
@@ -1490,7 +1489,7 @@ func (m *RebalanceServiceManager) registerGlobalRebalanceToken(rebalToken *Rebal
 
 				// cinfo.Fetch has its own internal retries
 				if err = m.cinfo.Fetch(); err != nil {
-					l.Errorf("%v Error on iter %v fetching cluster information: %v", method, i, err)
+					rebalanceLog.Errorf("%v Error on iter %v fetching cluster information: %v", method, i, err)
 				}
 			}
 
@@ -1501,7 +1500,7 @@ func (m *RebalanceServiceManager) registerGlobalRebalanceToken(rebalToken *Rebal
 	}
 
 	if !valid {
-		l.Errorf("%v cinfo.nodes: %v, change: %v", method, m.cinfo.Nodes(), change)
+		rebalanceLog.Errorf("%v cinfo.nodes: %v, change: %v", method, m.cinfo.Nodes(), change)
 		return err, true
 	}
 
@@ -1513,18 +1512,18 @@ func (m *RebalanceServiceManager) registerGlobalRebalanceToken(rebalToken *Rebal
 
 			localaddr, err := m.cinfo.GetLocalServiceAddress(c.INDEX_HTTP_SERVICE, true)
 			if err != nil {
-				l.Errorf("%v Error Fetching Local Service Address %v", method, err)
+				rebalanceLog.Errorf("%v Error Fetching Local Service Address %v", method, err)
 				return errors.New(fmt.Sprintf("Fail to retrieve http endpoint for local node %v", err)), true
 			}
 
 			if addr == localaddr {
-				l.Infof("%v Skip local service %v", method, addr)
+				rebalanceLog.Infof("%v Skip local service %v", method, addr)
 				continue
 			}
 
 			body, err := json.Marshal(&rebalToken)
 			if err != nil {
-				l.Errorf("%v Error registering rebalance token on %v, err: %v",
+				rebalanceLog.Errorf("%v Error registering rebalance token on %v, err: %v",
 					method, addr+url, err)
 				return err, true
 			}
@@ -1533,18 +1532,18 @@ func (m *RebalanceServiceManager) registerGlobalRebalanceToken(rebalToken *Rebal
 
 			resp, err := postWithAuth(addr+url, "application/json", bodybuf)
 			if err != nil {
-				l.Errorf("%v Error registering rebalance token on %v, err: %v",
+				rebalanceLog.Errorf("%v Error registering rebalance token on %v, err: %v",
 					method, addr+url, err)
 				return err, true
 			}
 			ioutil.ReadAll(resp.Body)
 			resp.Body.Close()
 		} else {
-			l.Errorf("%v Error Fetching Service Address %v", method, err)
+			rebalanceLog.Errorf("%v Error Fetching Service Address %v", method, err)
 			return errors.New(fmt.Sprintf("Fail to retrieve http endpoint for index node %v", err)), true
 		}
 
-		l.Infof("%v Successfully registered rebalance token on %v", method, addr+url)
+		rebalanceLog.Infof("%v Successfully registered rebalance token on %v", method, addr+url)
 	}
 
 	return nil, false
@@ -1562,26 +1561,26 @@ func (m *RebalanceServiceManager) observeGlobalRebalanceToken(rebalToken Rebalan
 		var rtoken RebalanceToken
 		found, err := c.MetakvGet(RebalanceTokenPath, &rtoken)
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::observeGlobalRebalanceToken Error Checking Rebalance Token In Metakv %v", err)
+			rebalanceLog.Errorf("RebalanceServiceManager::observeGlobalRebalanceToken Error Checking Rebalance Token In Metakv %v", err)
 			continue
 		}
 
 		if found {
 			if reflect.DeepEqual(rtoken, rebalToken) {
-				l.Infof("RebalanceServiceManager::observeGlobalRebalanceToken Global And Local Rebalance Token Match %v", rtoken)
+				rebalanceLog.Infof("RebalanceServiceManager::observeGlobalRebalanceToken Global And Local Rebalance Token Match %v", rtoken)
 				return true
 			} else {
-				l.Errorf("RebalanceServiceManager::observeGlobalRebalanceToken Mismatch in Global and Local Rebalance Token. Global %v. Local %v.", rtoken, rebalToken)
+				rebalanceLog.Errorf("RebalanceServiceManager::observeGlobalRebalanceToken Mismatch in Global and Local Rebalance Token. Global %v. Local %v.", rtoken, rebalToken)
 				return false
 			}
 		}
 
-		l.Infof("RebalanceServiceManager::observeGlobalRebalanceToken Waiting for Global Rebalance Token In Metakv")
+		rebalanceLog.Infof("RebalanceServiceManager::observeGlobalRebalanceToken Waiting for Global Rebalance Token In Metakv")
 		time.Sleep(time.Second * time.Duration(1))
 		elapsed += 1
 	}
 
-	l.Errorf("RebalanceServiceManager::observeGlobalRebalanceToken Timeout Waiting for Global Rebalance Token In Metakv")
+	rebalanceLog.Errorf("RebalanceServiceManager::observeGlobalRebalanceToken Timeout Waiting for Global Rebalance Token In Metakv")
 
 	return false
 
@@ -1718,7 +1717,7 @@ func (m *RebalanceServiceManager) onRebalanceDoneLOCKED(err error, forceUnbalanc
 	m.setStateIsBalanced(isBalancedNew) // set new isBalanced state
 	m.rebalancer = nil
 	m.rebalancerF = nil
-	l.Infof("RebalanceServiceManager::onRebalanceDoneLOCKED Rebalance Done: "+
+	rebalanceLog.Infof("RebalanceServiceManager::onRebalanceDoneLOCKED Rebalance Done: "+
 		"isBalanced %v, isMaster %v, forceUnbalanced %v, err: %v",
 		isBalancedNew, isMaster, forceUnbalanced, err)
 }
@@ -1967,11 +1966,11 @@ func (m *RebalanceServiceManager) recoverRebalance() {
 	m.indexerReady = true
 
 	if m.cleanupPending {
-		l.Infof("%v Init Pending Cleanup", method)
+		rebalanceLog.Infof("%v Init Pending Cleanup", method)
 
 		rtokens, err := m.getCurrRebalTokens()
 		if err != nil {
-			l.Errorf("%v Error Fetching Metakv Tokens %v", method, err)
+			rebalanceLog.Errorf("%v Error Fetching Metakv Tokens %v", method, err)
 			c.CrashOnError(err)
 		}
 
@@ -1998,7 +1997,7 @@ func (m *RebalanceServiceManager) recoverRebalance() {
 
 func (m *RebalanceServiceManager) doRecoverRebalance(gtoken *RebalanceToken) {
 
-	l.Infof("RebalanceServiceManager::doRecoverRebalance Found Global Rebalance Token %v", gtoken)
+	rebalanceLog.Infof("RebalanceServiceManager::doRecoverRebalance Found Global Rebalance Token %v", gtoken)
 
 	if gtoken.MasterId == string(m.nodeInfo.NodeID) {
 		m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
@@ -2010,7 +2009,7 @@ func (m *RebalanceServiceManager) doRecoverRebalance(gtoken *RebalanceToken) {
 
 func (m *RebalanceServiceManager) doRecoverMoveIndex(gtoken *RebalanceToken) {
 
-	l.Infof("RebalanceServiceManager::doRecoverMoveIndex Found Global Rebalance Token %v.", gtoken)
+	rebalanceLog.Infof("RebalanceServiceManager::doRecoverMoveIndex Found Global Rebalance Token %v.", gtoken)
 	m.runCleanupPhaseLOCKED(MoveIndexTokenPath, true)
 }
 
@@ -2018,7 +2017,7 @@ func (m *RebalanceServiceManager) checkLocalCleanupPending() bool {
 
 	rtokens, err := m.getCurrRebalTokens()
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::checkLocalCleanupPending Error Fetching Metakv Tokens %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::checkLocalCleanupPending Error Fetching Metakv Tokens %v", err)
 		return true
 	}
 
@@ -2026,7 +2025,7 @@ func (m *RebalanceServiceManager) checkLocalCleanupPending() bool {
 		for _, tt := range rtokens.TT {
 			ownerId := m.getTransferTokenOwner(tt)
 			if ownerId == string(m.nodeInfo.NodeID) {
-				l.Infof("RebalanceServiceManager::checkLocalCleanupPending Found Local Pending Cleanup Token %v", tt)
+				rebalanceLog.Infof("RebalanceServiceManager::checkLocalCleanupPending Found Local Pending Cleanup Token %v", tt)
 				return true
 			}
 		}
@@ -2039,7 +2038,7 @@ func (m *RebalanceServiceManager) checkGlobalCleanupPending() bool {
 
 	rtokens, err := m.getCurrRebalTokens()
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::checkGlobalCleanupPending Error Fetching Metakv Tokens %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::checkGlobalCleanupPending Error Fetching Metakv Tokens %v", err)
 		return true
 	}
 
@@ -2049,11 +2048,11 @@ func (m *RebalanceServiceManager) checkGlobalCleanupPending() bool {
 			ownerId := m.getTransferTokenOwner(tt)
 			for _, s := range servers {
 				if ownerId == string(s) {
-					l.Infof("RebalanceServiceManager::checkGlobalCleanupPending Found Global Pending Cleanup for Owner %v Token %v", ownerId, tt)
+					rebalanceLog.Infof("RebalanceServiceManager::checkGlobalCleanupPending Found Global Pending Cleanup for Owner %v Token %v", ownerId, tt)
 					return true
 				}
 			}
-			l.Infof("RebalanceServiceManager::checkGlobalCleanupPending Found Global Pending Cleanup Token Without Owner Token %v", tt)
+			rebalanceLog.Infof("RebalanceServiceManager::checkGlobalCleanupPending Found Global Pending Cleanup Token Without Owner Token %v", tt)
 		}
 	}
 
@@ -2089,22 +2088,22 @@ func (m *RebalanceServiceManager) handleListRebalanceTokens(w http.ResponseWrite
 
 	_, ok := m.validateAuth(w, r)
 	if !ok {
-		l.Errorf("RebalanceServiceManager::handleListRebalanceTokens Validation Failure req: %v", c.GetHTTPReqInfo(r))
+		rebalanceLog.Errorf("RebalanceServiceManager::handleListRebalanceTokens Validation Failure req: %v", c.GetHTTPReqInfo(r))
 		return
 	}
 
 	if r.Method == "GET" {
 
-		l.Infof("RebalanceServiceManager::handleListRebalanceTokens Processing Request req: %v", c.GetHTTPReqInfo(r))
+		rebalanceLog.Infof("RebalanceServiceManager::handleListRebalanceTokens Processing Request req: %v", c.GetHTTPReqInfo(r))
 		rinfo, err := m.getCurrRebalTokens()
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::handleListRebalanceTokens Error %v", err)
+			rebalanceLog.Errorf("RebalanceServiceManager::handleListRebalanceTokens Error %v", err)
 			m.writeError(w, err)
 			return
 		}
 		out, err1 := json.Marshal(rinfo)
 		if err1 != nil {
-			l.Errorf("RebalanceServiceManager::handleListRebalanceTokens Error %v", err1)
+			rebalanceLog.Errorf("RebalanceServiceManager::handleListRebalanceTokens Error %v", err1)
 			m.writeError(w, err1)
 		} else {
 			m.writeJson(w, out)
@@ -2121,25 +2120,25 @@ func (m *RebalanceServiceManager) handleCleanupRebalance(w http.ResponseWriter,
 
 	_, ok := m.validateAuth(w, r)
 	if !ok {
-		l.Errorf("%v Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
+		rebalanceLog.Errorf("%v Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
 		return
 	}
 
 	if r.Method == "GET" || r.Method == "POST" {
 
-		l.Infof("%v Processing Request req: %v", method, c.GetHTTPReqInfo(r))
+		rebalanceLog.Infof("%v Processing Request req: %v", method, c.GetHTTPReqInfo(r))
 		lockTime := c.TraceRWMutexLOCK(c.LOCK_WRITE, m.svcMgrMu, "svcMgrMu", method, "")
 		defer c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, m.svcMgrMu, "svcMgrMu", method, "")
 
 		if !m.indexerReady {
-			l.Errorf("%v Cannot Process Request %v", method, c.ErrIndexerInBootstrap)
+			rebalanceLog.Errorf("%v Cannot Process Request %v", method, c.ErrIndexerInBootstrap)
 			m.writeError(w, c.ErrIndexerInBootstrap)
 			return
 		}
 
 		rtokens, err := m.getCurrRebalTokens()
 		if err != nil {
-			l.Errorf("%v Error %v", method, err)
+			rebalanceLog.Errorf("%v Error %v", method, err)
 		}
 
 		if rtokens != nil {
@@ -2147,13 +2146,13 @@ func (m *RebalanceServiceManager) handleCleanupRebalance(w http.ResponseWriter,
 				m.setStateIsBalanced(false)
 				err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, true)
 				if err != nil {
-					l.Errorf("%v RebalanceTokenPath Error %v", method, err)
+					rebalanceLog.Errorf("%v RebalanceTokenPath Error %v", method, err)
 				}
 			}
 			if rtokens.MT != nil {
 				err = m.runCleanupPhaseLOCKED(MoveIndexTokenPath, true)
 				if err != nil {
-					l.Errorf("%v MoveIndexTokenPath Error %v", method, err)
+					rebalanceLog.Errorf("%v MoveIndexTokenPath Error %v", method, err)
 				}
 			}
 		}
@@ -2162,7 +2161,7 @@ func (m *RebalanceServiceManager) handleCleanupRebalance(w http.ResponseWriter,
 		err = m.runCleanupPhaseLOCKED(RebalanceTokenPath, false)
 
 		if err != nil {
-			l.Errorf("%v Error %v", method, err)
+			rebalanceLog.Errorf("%v Error %v", method, err)
 			m.writeError(w, err)
 		} else {
 			m.writeOk(w)
@@ -2177,12 +2176,12 @@ func (m *RebalanceServiceManager) handleNodeuuid(w http.ResponseWriter, r *http.
 
 	_, ok := m.validateAuth(w, r)
 	if !ok {
-		l.Errorf("RebalanceServiceManager::handleNodeuuid Validation Failure req: %v", c.GetHTTPReqInfo(r))
+		rebalanceLog.Errorf("RebalanceServiceManager::handleNodeuuid Validation Failure req: %v", c.GetHTTPReqInfo(r))
 		return
 	}
 
 	if r.Method == "GET" || r.Method == "POST" {
-		l.Infof("RebalanceServiceManager::handleNodeuuid Processing Request req: %v", c.GetHTTPReqInfo(r))
+		rebalanceLog.Infof("RebalanceServiceManager::handleNodeuuid Processing Request req: %v", c.GetHTTPReqInfo(r))
 		m.writeBytes(w, []byte(m.nodeInfo.NodeID))
 	} else {
 		m.writeError(w, errors.New("Unsupported method"))
@@ -2222,7 +2221,7 @@ func (m *RebalanceServiceManager) getCurrRebalTokens() (*RebalTokens, error) {
 			json.Unmarshal(kv.Value, &tt)
 			rinfo.TT[ttid] = &tt
 		} else {
-			l.Errorf("RebalanceServiceManager::getCurrRebalTokens Unknown Token %v. Ignored.", kv)
+			rebalanceLog.Errorf("RebalanceServiceManager::getCurrRebalTokens Unknown Token %v. Ignored.", kv)
 		}
 
 	}
@@ -2237,7 +2236,7 @@ func (m *RebalanceServiceManager) handleRegisterRebalanceToken(w http.ResponseWr
 
 	_, ok := m.validateAuth(w, r)
 	if !ok {
-		l.Errorf("%v Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
+		rebalanceLog.Errorf("%v Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
 		return
 	}
 
@@ -2245,12 +2244,12 @@ func (m *RebalanceServiceManager) handleRegisterRebalanceToken(w http.ResponseWr
 	if r.Method == "POST" {
 		bytes, _ := ioutil.ReadAll(r.Body)
 		if err := json.Unmarshal(bytes, &rebalToken); err != nil {
-			l.Errorf("%v %v", method, err)
+			rebalanceLog.Errorf("%v %v", method, err)
 			m.writeError(w, err)
 			return
 		}
 
-		l.Infof("%v New Rebalance Token %v", method, rebalToken)
+		rebalanceLog.Infof("%v New Rebalance Token %v", method, rebalToken)
 
 		if m.observeGlobalRebalanceToken(rebalToken) {
 
@@ -2259,14 +2258,14 @@ func (m *RebalanceServiceManager) handleRegisterRebalanceToken(w http.ResponseWr
 
 			if !m.rebalanceRunning {
 				errStr := fmt.Sprintf("Node %v not in Prepared State for Rebalance", string(m.nodeInfo.NodeID))
-				l.Errorf("%v %v", method, errStr)
+				rebalanceLog.Errorf("%v %v", method, errStr)
 				m.writeError(w, errors.New(errStr))
 				return
 			}
 
 			m.rebalanceToken = &rebalToken
 			if err := m.registerLocalRebalanceToken(m.rebalanceToken); err != nil {
-				l.Errorf("%v %v", method, err)
+				rebalanceLog.Errorf("%v %v", method, err)
 				m.writeError(w, err)
 				return
 			}
@@ -2279,7 +2278,7 @@ func (m *RebalanceServiceManager) handleRegisterRebalanceToken(w http.ResponseWr
 
 		} else {
 			err := errors.New("Rebalance Token Wait Timeout")
-			l.Errorf("%v %v", method, err)
+			rebalanceLog.Errorf("%v %v", method, err)
 			m.writeError(w, err)
 			return
 		}
@@ -2296,7 +2295,7 @@ func getGlobalTopology(addr string) (*manager.ClusterIndexMetadata, error) {
 
 	resp, err := getWithAuth(addr + url)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::getGlobalTopology Error gathering global topology %v %v", addr+url, err)
+		rebalanceLog.Errorf("RebalanceServiceManager::getGlobalTopology Error gathering global topology %v %v", addr+url, err)
 		return nil, err
 	}
 
@@ -2304,7 +2303,7 @@ func getGlobalTopology(addr string) (*manager.ClusterIndexMetadata, error) {
 	bytes, _ := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err := json.Unmarshal(bytes, &topology); err != nil {
-		l.Errorf("RebalanceServiceManager::getGlobalTopology Error unmarshal global topology %v %v %s",
+		rebalanceLog.Errorf("RebalanceServiceManager::getGlobalTopology Error unmarshal global topology %v %v %s",
 			addr+url, err, l.TagUD(string(bytes)))
 		return nil, err
 	}
@@ -2321,13 +2320,13 @@ func getGlobalTopology(addr string) (*manager.ClusterIndexMetadata, error) {
 
 func (m *RebalanceServiceManager) listenMoveIndex() {
 
-	l.Infof("RebalanceServiceManager::listenMoveIndex %v", m.nodeInfo)
+	rebalanceLog.Infof("RebalanceServiceManager::listenMoveIndex %v", m.nodeInfo)
 
 	cancel := make(chan struct{})
 	for {
 		err := metakv.RunObserveChildren(RebalanceMetakvDir, m.processMoveIndex, cancel)
 		if err != nil {
-			l.Infof("RebalanceServiceManager::listenMoveIndex metakv err %v. Retrying...", err)
+			rebalanceLog.Infof("RebalanceServiceManager::listenMoveIndex metakv err %v. Retrying...", err)
 			time.Sleep(2 * time.Second)
 		}
 	}
@@ -2338,14 +2337,14 @@ func (m *RebalanceServiceManager) processMoveIndex(kve metakv.KVEntry) error {
 	if kve.Path == MoveIndexTokenPath {
 		const method = "RebalanceServiceManager::processMoveIndex:" // for logging
 
-		l.Infof("%v MoveIndexToken Received %v %s", method, kve.Path, kve.Value)
+		rebalanceLog.Infof("%v MoveIndexToken Received %v %s", method, kve.Path, kve.Value)
 
 		var rebalToken RebalanceToken
 		if kve.Value == nil { //move index token deleted
 			return nil
 		} else {
 			if err := json.Unmarshal(kve.Value, &rebalToken); err != nil {
-				l.Errorf("%v Error reading move index token %v", method, err)
+				rebalanceLog.Errorf("%v Error reading move index token %v", method, err)
 				return err
 			}
 		}
@@ -2362,7 +2361,7 @@ func (m *RebalanceServiceManager) processMoveIndex(kve metakv.KVEntry) error {
 				// If master (source) receving a token with error, then cancel move index
 				// and return the error to user.
 				if len(rebalToken.Error) != 0 {
-					l.Infof("%v received error from destination", method)
+					rebalanceLog.Infof("%v received error from destination", method)
 
 					if m.rebalancer != nil {
 						m.rebalancer.Cancel()
@@ -2374,21 +2373,21 @@ func (m *RebalanceServiceManager) processMoveIndex(kve metakv.KVEntry) error {
 				}
 			}
 
-			l.Infof("%v Skip MoveIndex Token for Self Node", method)
+			rebalanceLog.Infof("%v Skip MoveIndex Token for Self Node", method)
 			return nil
 
 		} else {
 			// If destination receving a token with error, then skip.  The destination is
 			// the one that posted the error.
 			if len(rebalToken.Error) != 0 {
-				l.Infof("%v Skip MoveIndex Token with error", method)
+				rebalanceLog.Infof("%v Skip MoveIndex Token with error", method)
 				return nil
 			}
 		}
 
 		if m.rebalanceRunning {
 			err := errors.New("Cannot Process Move Index - Rebalance In Progress")
-			l.Errorf("%v %v %v", method, err, m.rebalanceToken)
+			rebalanceLog.Errorf("%v %v %v", method, err, m.rebalanceToken)
 			m.setErrorInMoveIndexToken(&rebalToken, err)
 			return nil
 		} else {
@@ -2397,7 +2396,7 @@ func (m *RebalanceServiceManager) processMoveIndex(kve metakv.KVEntry) error {
 			var err error
 			if err = m.registerRebalanceRunning(true); err != nil || m.p.ddlRunning {
 				if m.p.ddlRunning {
-					l.Errorf("%v Found index build running. Cannot process move index.", method)
+					rebalanceLog.Errorf("%v Found index build running. Cannot process move index.", method)
 					fmtMsg := "move index failure - index build is in progress for indexes: %v."
 					err = errors.New(fmt.Sprintf(fmtMsg, m.p.ddlRunningIndexNames))
 				}
@@ -2425,7 +2424,7 @@ func (m *RebalanceServiceManager) handleMoveIndex(w http.ResponseWriter, r *http
 
 	creds, ok := m.validateAuth(w, r)
 	if !ok {
-		l.Errorf("%v: Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
+		rebalanceLog.Errorf("%v: Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
 		return
 	}
 
@@ -2497,7 +2496,7 @@ func (m *RebalanceServiceManager) handleMoveIndex(w http.ResponseWriter, r *http
 		}
 		if defn == nil {
 			err := errors.New(fmt.Sprintf("Fail to find index definition for bucket %v index %v.", bucket, index))
-			l.Errorf("%v: %v", method, err)
+			rebalanceLog.Errorf("%v: %v", method, err)
 			send(http.StatusInternalServerError, w, err.Error())
 			return
 		}
@@ -2527,7 +2526,7 @@ func (m *RebalanceServiceManager) handleMoveIndexInternal(w http.ResponseWriter,
 
 	creds, ok := m.validateAuth(w, r)
 	if !ok {
-		l.Errorf("%v: Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
+		rebalanceLog.Errorf("%v: Validation Failure req: %v", method, c.GetHTTPReqInfo(r))
 		return
 	}
 
@@ -2535,7 +2534,7 @@ func (m *RebalanceServiceManager) handleMoveIndexInternal(w http.ResponseWriter,
 		bytes, _ := ioutil.ReadAll(r.Body)
 		var req manager.IndexRequest
 		if err := json.Unmarshal(bytes, &req); err != nil {
-			l.Errorf("%v: err: %v", method, err)
+			rebalanceLog.Errorf("%v: err: %v", method, err)
 			sendIndexResponseWithError(http.StatusBadRequest, w, err.Error())
 			return
 		}
@@ -2575,21 +2574,21 @@ func (m *RebalanceServiceManager) handleMoveIndexInternal(w http.ResponseWriter,
 
 func (m *RebalanceServiceManager) doHandleMoveIndex(req *manager.IndexRequest) (int, string) {
 
-	l.Infof("RebalanceServiceManager::doHandleMoveIndex %v", l.TagUD(req))
+	rebalanceLog.Infof("RebalanceServiceManager::doHandleMoveIndex %v", l.TagUD(req))
 
 	nodes, err := validateMoveIndexReq(req)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::doHandleMoveIndex %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::doHandleMoveIndex %v", err)
 		return http.StatusBadRequest, err.Error()
 	}
 
 	err, noop := m.initMoveIndex(req, nodes)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::doHandleMoveIndex %v %v", err, m.rebalanceToken)
+		rebalanceLog.Errorf("RebalanceServiceManager::doHandleMoveIndex %v %v", err, m.rebalanceToken)
 		return http.StatusInternalServerError, err.Error()
 	} else if noop {
 		warnStr := "No Index Movement Required for Specified Destination List"
-		l.Warnf("RebalanceServiceManager::doHandleMoveIndex %v", warnStr)
+		rebalanceLog.Warnf("RebalanceServiceManager::doHandleMoveIndex %v", warnStr)
 		return http.StatusBadRequest, warnStr
 	} else {
 		go m.monitorMoveIndex()
@@ -2603,10 +2602,10 @@ func (m *RebalanceServiceManager) monitorMoveIndex() {
 		if err != nil {
 			cfg := m.config.Load()
 			clusterAddr := cfg["clusterAddr"].String()
-			l.Errorf("RebalanceServiceManager::doHandleMoveIndex MoveIndex failed: %v", err.Error())
+			rebalanceLog.Errorf("RebalanceServiceManager::doHandleMoveIndex MoveIndex failed: %v", err.Error())
 			c.Console(clusterAddr, fmt.Sprintf("MoveIndex failed: %v", err.Error()))
 		} else {
-			l.Infof("RebalanceServiceManager: Move Index succeeded")
+			rebalanceLog.Infof("RebalanceServiceManager: Move Index succeeded")
 		}
 	}
 }
@@ -2619,19 +2618,19 @@ func (m *RebalanceServiceManager) initMoveIndex(req *manager.IndexRequest, nodes
 
 	var err error
 	if !m.indexerReady {
-		l.Errorf("%v: Cannot Process Request %v", method, c.ErrIndexerInBootstrap)
+		rebalanceLog.Errorf("%v: Cannot Process Request %v", method, c.ErrIndexerInBootstrap)
 		return c.ErrIndexerInBootstrap, false
 	}
 
 	if m.checkRebalanceRunning() {
 		err = errors.New("Cannot Process Move Index - Rebalance/MoveIndex In Progress")
-		l.Errorf("%v: err: %v", method, err)
+		rebalanceLog.Errorf("%v: err: %v", method, err)
 		return err, false
 	}
 
 	if m.getStateRebalanceID() != "" {
 		err = errors.New("Cannot Process Move Index - Failover In Progress")
-		l.Errorf("%v: err: %v", method, err)
+		rebalanceLog.Errorf("%v: err: %v", method, err)
 		return err, false
 	}
 
@@ -2639,7 +2638,7 @@ func (m *RebalanceServiceManager) initMoveIndex(req *manager.IndexRequest, nodes
 	if m.checkGlobalCleanupPending() {
 		err = errors.New("Cannot Process Move Index - cleanup pending from previous " +
 			"failed/aborted rebalance/failover/move index. please retry the request later.")
-		l.Errorf("%v: err: %v", method, err)
+		rebalanceLog.Errorf("%v: err: %v", method, err)
 		return err, false
 	}
 
@@ -2654,7 +2653,7 @@ func (m *RebalanceServiceManager) initMoveIndex(req *manager.IndexRequest, nodes
 		return err, false
 	}
 
-	l.Infof("%v: New Move Index Token %v Dest %v", method, m.rebalanceToken, nodes)
+	rebalanceLog.Infof("%v: New Move Index Token %v Dest %v", method, m.rebalanceToken, nodes)
 	transferTokens, err := m.generateTransferTokenForMoveIndex(req, nodes)
 	if err != nil {
 		m.rebalanceToken = nil
@@ -2668,7 +2667,7 @@ func (m *RebalanceServiceManager) initMoveIndex(req *manager.IndexRequest, nodes
 
 	if err = m.registerRebalanceRunning(true); err != nil || m.p.ddlRunning {
 		if m.p.ddlRunning {
-			l.Errorf("%v: Found index build running. Cannot process move index.", method)
+			rebalanceLog.Errorf("%v: Found index build running. Cannot process move index.", method)
 			fmtMsg := "move index failure - index build is in progress for indexes: %v."
 			err = errors.New(fmt.Sprintf(fmtMsg, m.p.ddlRunningIndexNames))
 		}
@@ -2718,7 +2717,7 @@ func (m *RebalanceServiceManager) setErrorInMoveIndexToken(token *RebalanceToken
 		return err
 	}
 
-	l.Infof("RebalanceServiceManager::setErrorInMoveIndexToken done")
+	rebalanceLog.Infof("RebalanceServiceManager::setErrorInMoveIndexToken done")
 
 	return nil
 }
@@ -2728,11 +2727,11 @@ func (m *RebalanceServiceManager) registerMoveIndexTokenInMetakv(token *Rebalanc
 	updateRToken := func() error {
 		err := c.MetakvSet(MoveIndexTokenPath, token)
 		if err != nil {
-			l.Errorf("RebalanceServiceManager::registerMoveIndexTokenInMetakv Unable to set "+
+			rebalanceLog.Errorf("RebalanceServiceManager::registerMoveIndexTokenInMetakv Unable to set "+
 				"RebalanceToken In Meta Storage. Err %v", err)
 			return err
 		}
-		l.Infof("RebalanceServiceManager::registerMoveIndexTokenInMetakv Registered Global Rebalance"+
+		rebalanceLog.Infof("RebalanceServiceManager::registerMoveIndexTokenInMetakv Registered Global Rebalance"+
 			"Token In Metakv %v %v", MoveIndexTokenPath, token)
 		return nil
 	}
@@ -2740,7 +2739,7 @@ func (m *RebalanceServiceManager) registerMoveIndexTokenInMetakv(token *Rebalanc
 	var rtoken RebalanceToken
 	found, err := c.MetakvGet(MoveIndexTokenPath, &rtoken)
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::registerMoveIndexTokenInMetakv Unable to get "+
+		rebalanceLog.Errorf("RebalanceServiceManager::registerMoveIndexTokenInMetakv Unable to get "+
 			"RebalanceToken from Meta Storage. Err %v", err)
 		return err
 	}
@@ -2752,12 +2751,12 @@ func (m *RebalanceServiceManager) registerMoveIndexTokenInMetakv(token *Rebalanc
 	} else if found && rtoken.RebalId == token.RebalId { // Caller's token is same as the token in metakv. Update the token
 		return updateRToken()
 	} else if found && upsert { // Caller has a different token than the token in metakv. Return err
-		l.Errorf("RebalanceServiceManager::registerMoveIndexTokenInMetakv Move token: %v is different "+
+		rebalanceLog.Errorf("RebalanceServiceManager::registerMoveIndexTokenInMetakv Move token: %v is different "+
 			"from the token in metakv. found: %v, rtoken: %v", token, found, rtoken)
 		return errors.New("Inconsistent MoveToken in metakv")
 	} else { // The token in metakv is different from the caller's version and the caller is trying to update it.
 		// Ignore the update as the caller's version of the token might have been deleted
-		l.Infof("RebalanceServiceManager::registerMoveIndexTokenInMetakv Move token: %v is probably deleted "+
+		rebalanceLog.Infof("RebalanceServiceManager::registerMoveIndexTokenInMetakv Move token: %v is probably deleted "+
 			"from metakv. found: %v, rtoken: %v", found, rtoken)
 	}
 
@@ -2784,7 +2783,7 @@ func (m *RebalanceServiceManager) generateTransferTokenForMoveIndex(req *manager
 		}
 	}
 
-	l.Infof("%v: nodes %v, uuid %v", method, reqNodes, reqNodeUUID)
+	rebalanceLog.Infof("%v: nodes %v, uuid %v", method, reqNodes, reqNodeUUID)
 
 	var currNodeUUID []string
 	var currInst [][]*c.IndexInst
@@ -2799,7 +2798,7 @@ func (m *RebalanceServiceManager) generateTransferTokenForMoveIndex(req *manager
 				numCurrInst++
 				for i, uuid := range reqNodeUUID {
 					if localMeta.IndexerId == uuid {
-						l.Infof("%v: Skip Index %v. Already exist on dest %v.", method, index.DefnId, uuid)
+						rebalanceLog.Infof("%v: Skip Index %v. Already exist on dest %v.", method, index.DefnId, uuid)
 						reqNodeUUID = append(reqNodeUUID[:i], reqNodeUUID[i+1:]...)
 						break outerloop
 					}
@@ -2808,19 +2807,19 @@ func (m *RebalanceServiceManager) generateTransferTokenForMoveIndex(req *manager
 				topology := findTopologyByCollection(localMeta.IndexTopologies, index.Bucket, index.Scope, index.Collection)
 				if topology == nil {
 					err := errors.New(fmt.Sprintf("Fail to find index topology for bucket %v for node %v.", index.Bucket, localMeta.NodeUUID))
-					l.Errorf("%v: err: %v", method, err)
+					rebalanceLog.Errorf("%v: err: %v", method, err)
 					return nil, err
 				}
 
 				insts := topology.GetIndexInstancesByDefn(index.DefnId)
 				if len(insts) == 0 {
 					err := errors.New(fmt.Sprintf("Fail to find index instance for definition %v for node %v.", index.DefnId, localMeta.NodeUUID))
-					l.Errorf("%v: err: %v", method, err)
+					rebalanceLog.Errorf("%v: err: %v", method, err)
 					return nil, err
 				}
 
 				cfg := m.config.Load()
-				numVbuckets := cfg["numVbuckets"].Int()
+				numVbuckets := getNumVBuckets(index.Bucket, cfg)
 
 				var instList []*c.IndexInst
 				for _, inst := range insts {
@@ -2853,14 +2852,14 @@ func (m *RebalanceServiceManager) generateTransferTokenForMoveIndex(req *manager
 	if len(reqNodes) != numCurrInst {
 		err := errors.New(fmt.Sprintf("Target node list must specify exactly one destination for each "+
 			"instances of the index. Request Nodes %v", reqNodes))
-		l.Errorf("%v: err: %v", method, err)
+		rebalanceLog.Errorf("%v: err: %v", method, err)
 		return nil, err
 	}
 
 	if len(currNodeUUID) != len(reqNodeUUID) {
 		err := errors.New(fmt.Sprintf("Server error in computing new destination for index. "+
 			"Request Nodes %v. Curr Nodes %v", reqNodeUUID, currNodeUUID))
-		l.Errorf("%v: err: %v", method, err)
+		rebalanceLog.Errorf("%v: err: %v", method, err)
 		return nil, err
 	}
 
@@ -2872,10 +2871,10 @@ func (m *RebalanceServiceManager) generateTransferTokenForMoveIndex(req *manager
 				return nil, err
 			}
 			if tt.SourceId == tt.DestId {
-				l.Infof("%v: Skip No-op TransferToken %v %v", method, ttid, tt)
+				rebalanceLog.Infof("%v: Skip No-op TransferToken %v %v", method, ttid, tt)
 				continue
 			}
-			l.Infof("%v: Generated TransferToken %v %v", method, ttid, tt)
+			rebalanceLog.Infof("%v: Generated TransferToken %v %v", method, ttid, tt)
 			transferTokens[ttid] = tt
 		}
 	}
@@ -2901,7 +2900,7 @@ func (m *RebalanceServiceManager) getNodeIdFromDest(dest string) (string, error)
 	defer m.cinfo.Unlock()
 
 	if err := m.cinfo.FetchNodesAndSvsInfo(); err != nil {
-		l.Errorf("RebalanceServiceManager::getNodeIdFromDest Error Fetching Cluster Information %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::getNodeIdFromDest Error Fetching Cluster Information %v", err)
 		return "", err
 	}
 
@@ -2929,7 +2928,7 @@ func (m *RebalanceServiceManager) getNodeIdFromDest(dest string) (string, error)
 
 			resp, err := getWithAuth(haddr + url)
 			if err != nil {
-				l.Errorf("RebalanceServiceManager::getNodeIdFromDest Unable to Fetch Node UUID %v %v", haddr, err)
+				rebalanceLog.Errorf("RebalanceServiceManager::getNodeIdFromDest Unable to Fetch Node UUID %v %v", haddr, err)
 				return "", err
 			} else {
 				bytes, _ := ioutil.ReadAll(resp.Body)
@@ -2940,7 +2939,7 @@ func (m *RebalanceServiceManager) getNodeIdFromDest(dest string) (string, error)
 
 	}
 	errStr := fmt.Sprintf("Unable to find Index service for destination %v or desintation is not part of the cluster", dest)
-	l.Errorf("RebalanceServiceManager::getNodeIdFromDest %v", errStr)
+	rebalanceLog.Errorf("RebalanceServiceManager::getNodeIdFromDest %v", errStr)
 
 	return "", errors.New(errStr)
 }
@@ -2994,10 +2993,10 @@ func (m *RebalanceServiceManager) moveIndexDoneCallback(err error, cancel <-chan
 func (m *RebalanceServiceManager) onMoveIndexDoneLOCKED(err error) {
 
 	if err != nil {
-		l.Errorf("RebalanceServiceManager::onMoveIndexDone Err %v", err)
+		rebalanceLog.Errorf("RebalanceServiceManager::onMoveIndexDone Err %v", err)
 	}
 
-	l.Infof("RebalanceServiceManager::onMoveIndexDone Cleanup")
+	rebalanceLog.Infof("RebalanceServiceManager::onMoveIndexDone Cleanup")
 
 	if m.rebalancer != nil {
 		m.runCleanupPhaseLOCKED(MoveIndexTokenPath, true)
@@ -3161,8 +3160,7 @@ func postWithAuth(url string, bodyType string, body io.Reader) (*http.Response,
 }
 
 func sendIndexResponseWithError(status int, w http.ResponseWriter, msg string) {
-	res := &manager.IndexResponse{Code: manager.RESP_ERROR, Error: msg}
-	send(status, w, res)
+	send(status, w, manager.NewIndexErrorResponse(msg))
 }
 
 func sendIndexResponse(w http.ResponseWriter) {
@@ -3182,11 +3180,11 @@ func send(status int, w http.ResponseWriter, res interface{}) {
 
 	if buf, err := json.Marshal(res); err == nil {
 		w.WriteHeader(status)
-		l.Debugf("RebalanceServiceManager::sendResponse: sending response back to caller. %v", string(buf))
+		rebalanceLog.Debugf("RebalanceServiceManager::sendResponse: sending response back to caller. %v", string(buf))
 		w.Write(buf)
 	} else {
 		// note : buf is nil if err != nil
-		l.Debugf("RebalanceServiceManager::sendResponse: fail to marshall response back to caller. %s", err)
+		rebalanceLog.Debugf("RebalanceServiceManager::sendResponse: fail to marshall response back to caller. %s", err)
 		sendHttpError(w, "RebalanceServiceManager::sendResponse: Unable to marshall response", http.StatusInternalServerError)
 	}
 }
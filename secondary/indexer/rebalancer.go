@@ -28,13 +28,14 @@ import (
 	c "github.com/couchbase/indexing/secondary/common"
 	forestdb "github.com/couchbase/indexing/secondary/fdb"
 	"github.com/couchbase/indexing/secondary/logging"
-	l "github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/manager"
 	"github.com/couchbase/indexing/secondary/manager/client"
 	mc "github.com/couchbase/indexing/secondary/manager/common"
 	"github.com/couchbase/indexing/secondary/planner"
 )
 
+var rebalanceLog = logging.GetComponentLogger(logging.RebalanceComponent)
+
 type DoneCallback func(err error, cancel <-chan struct{})
 type ProgressCallback func(progress float64, cancel <-chan struct{})
 
@@ -124,7 +125,7 @@ func NewRebalancer(transferTokens map[string]*c.TransferToken, rebalToken *Rebal
 	runPlanner bool, runParams *runParams, statsMgr *statsManager) *Rebalancer {
 
 	clusterVersion := common.GetClusterVersion()
-	l.Infof("NewRebalancer nodeId %v rebalToken %v master %v localaddr %v runPlanner %v runParam %v clusterVersion %v", nodeUUID,
+	rebalanceLog.Infof("NewRebalancer nodeId %v rebalToken %v master %v localaddr %v runPlanner %v runParam %v clusterVersion %v", nodeUUID,
 		rebalToken, master, localaddr, runPlanner, runParams, clusterVersion)
 
 	r := &Rebalancer{
@@ -205,19 +206,19 @@ func (r *Rebalancer) RemoveDuplicateIndexes(hostIndexMap map[string]map[common.I
 		for i := 0; i < 3; i++ { // 3 retries in case of error on PostDeleteCommandToken
 			select {
 			case <-r.cancel:
-				l.Warnf("%v Cancel Received. Skip processing drop duplicate indexes.", method)
+				rebalanceLog.Warnf("%v Cancel Received. Skip processing drop duplicate indexes.", method)
 				return
 			case <-r.done:
-				l.Warnf("%v Cannot drop duplicate index when rebalance is done.", method)
+				rebalanceLog.Warnf("%v Cannot drop duplicate index when rebalance is done.", method)
 				return
 			default:
-				l.Infof("%v posting dropToken for defnid %v", method, defnId)
+				rebalanceLog.Infof("%v posting dropToken for defnid %v", method, defnId)
 				if err := mc.PostDeleteCommandToken(defnId, true); err != nil {
 					if i == 2 { // all retries have failed to post drop token
 						uniqueDefns[defnId] = true
-						l.Errorf("%v failed to post delete command token after 3 retries, for index defnId %v due to internal errors.  Error=%v.", method, defnId, err)
+						rebalanceLog.Errorf("%v failed to post delete command token after 3 retries, for index defnId %v due to internal errors.  Error=%v.", method, defnId, err)
 					} else {
-						l.Warnf("%v failed to post delete command token for index defnId %v due to internal errors.  Error=%v.", method, defnId, err)
+						rebalanceLog.Warnf("%v failed to post delete command token for index defnId %v due to internal errors.  Error=%v.", method, defnId, err)
 					}
 					break // break select and retry PostDeleteCommandToken
 				}
@@ -232,10 +233,10 @@ func (r *Rebalancer) RemoveDuplicateIndexes(hostIndexMap map[string]map[common.I
 		for _, index := range indexes {
 			select {
 			case <-r.cancel:
-				l.Warnf("%v Cancel Received. Skip processing drop duplicate indexes.", method)
+				rebalanceLog.Warnf("%v Cancel Received. Skip processing drop duplicate indexes.", method)
 				return
 			case <-r.done:
-				l.Warnf("%v Cannot drop duplicate index when rebalance is done.", method)
+				rebalanceLog.Warnf("%v Cannot drop duplicate index when rebalance is done.", method)
 				return
 			default:
 				if uniqueDefns[index.DefnId] == true { // we were not able to post dropToken for this index.
@@ -257,10 +258,10 @@ func (r *Rebalancer) RemoveDuplicateIndexes(hostIndexMap map[string]map[common.I
 
 	select {
 	case <-r.cancel:
-		l.Warnf("%v Cancel Received. Skip processing drop duplicate indexes.", method)
+		rebalanceLog.Warnf("%v Cancel Received. Skip processing drop duplicate indexes.", method)
 		return
 	case <-r.done:
-		l.Warnf("%v Cannot drop duplicate index when rebalance is done.", method)
+		rebalanceLog.Warnf("%v Cannot drop duplicate index when rebalance is done.", method)
 		return
 	default:
 		for host, indexes := range hostIndexMap {
@@ -272,7 +273,7 @@ func (r *Rebalancer) RemoveDuplicateIndexes(hostIndexMap map[string]map[common.I
 
 		for host, indexes := range errMap {
 			for defnId, err := range indexes { // not really an error as we already posted drop tokens
-				l.Warnf("%v encountered error while removing index on host %v, defnId %v, err %v", method, host, defnId, err)
+				rebalanceLog.Warnf("%v encountered error while removing index on host %v, defnId %v, err %v", method, host, defnId, err)
 			}
 		}
 	}
@@ -283,7 +284,7 @@ func (r *Rebalancer) makeDropIndexRequest(defn *common.IndexDefn, host string) e
 	req := manager.IndexRequest{Index: *defn}
 	body, err := json.Marshal(&req)
 	if err != nil {
-		l.Errorf("%v error in marshal drop index defnId %v err %v", method, defn.DefnId, err)
+		rebalanceLog.Errorf("%v error in marshal drop index defnId %v err %v", method, defn.DefnId, err)
 		return err
 	}
 
@@ -292,7 +293,7 @@ func (r *Rebalancer) makeDropIndexRequest(defn *common.IndexDefn, host string) e
 	url := "/dropIndex"
 	resp, err := postWithAuth(host+url, "application/json", bodybuf)
 	if err != nil {
-		l.Errorf("%v error in drop index on host %v, defnId %v, err %v", method, host, defn.DefnId, err)
+		rebalanceLog.Errorf("%v error in drop index on host %v, defnId %v, err %v", method, host, defn.DefnId, err)
 		if err == io.EOF {
 			// should not rety in case of rebalance done or cancel
 			select {
@@ -304,7 +305,7 @@ func (r *Rebalancer) makeDropIndexRequest(defn *common.IndexDefn, host string) e
 				bodybuf := bytes.NewBuffer(body)
 				resp, err = postWithAuth(host+url, "application/json", bodybuf)
 				if err != nil {
-					l.Errorf("%v error in drop index on host %v, defnId %v, err %v", method, host, defn.DefnId, err)
+					rebalanceLog.Errorf("%v error in drop index on host %v, defnId %v, err %v", method, host, defn.DefnId, err)
 					return err
 				}
 			}
@@ -316,19 +317,19 @@ func (r *Rebalancer) makeDropIndexRequest(defn *common.IndexDefn, host string) e
 	response := new(manager.IndexResponse)
 	err = convertResponse(resp, response)
 	if err != nil {
-		l.Errorf("%v encountered error parsing response, host %v, defnId %v, err %v", method, host, defn.DefnId, err)
+		rebalanceLog.Errorf("%v encountered error parsing response, host %v, defnId %v, err %v", method, host, defn.DefnId, err)
 		return err
 	}
 
 	if response.Code == manager.RESP_ERROR {
 		if strings.Contains(response.Error, forestdb.FDB_RESULT_KEY_NOT_FOUND.Error()) {
-			l.Errorf("%v error dropping index, host %v defnId %v err %v. Ignored.", method, host, defn.DefnId, response.Error)
+			rebalanceLog.Errorf("%v error dropping index, host %v defnId %v err %v. Ignored.", method, host, defn.DefnId, response.Error)
 			return nil
 		}
-		l.Errorf("%v error dropping index, host %v, defnId %v, err %v", method, host, defn.DefnId, response.Error)
+		rebalanceLog.Errorf("%v error dropping index, host %v, defnId %v, err %v", method, host, defn.DefnId, response.Error)
 		return err
 	}
-	l.Infof("%v removed index defnId %v, defn %v, from host %v", method, defn.DefnId, defn, host)
+	rebalanceLog.Infof("%v removed index defnId %v, defn %v, from host %v", method, defn.DefnId, defn, host)
 	return nil
 }
 
@@ -356,11 +357,11 @@ func (r *Rebalancer) initRebalAsync() {
 		for {
 			select {
 			case <-r.cancel:
-				l.Infof("Rebalancer::initRebalAsync Cancel Received")
+				rebalanceLog.Infof("Rebalancer::initRebalAsync Cancel Received")
 				return
 
 			case <-r.done:
-				l.Infof("Rebalancer::initRebalAsync Done Received")
+				rebalanceLog.Infof("Rebalancer::initRebalAsync Done Received")
 				return
 
 			default:
@@ -368,12 +369,12 @@ func (r *Rebalancer) initRebalAsync() {
 				if allWarmedup {
 					globalTopology, err := getGlobalTopology(r.localaddr)
 					if err != nil {
-						l.Errorf("Rebalancer::initRebalAsync Error Fetching Topology %v", err)
+						rebalanceLog.Errorf("Rebalancer::initRebalAsync Error Fetching Topology %v", err)
 						go r.finishRebalance(err)
 						return
 					}
 					r.globalTopology = globalTopology
-					l.Infof("Rebalancer::initRebalAsync Global Topology %v", globalTopology)
+					rebalanceLog.Infof("Rebalancer::initRebalAsync Global Topology %v", globalTopology)
 
 					onEjectOnly := cfg["rebalance.node_eject_only"].Bool()
 					optimizePlacement := cfg["settings.rebalance.redistribute_indexes"].Bool()
@@ -398,7 +399,7 @@ func (r *Rebalancer) initRebalAsync() {
 						r.nodeUUID, onEjectOnly, disableReplicaRepair, threshold, timeout, cpuProfile,
 						minIterPerTemp, maxIterPerTemp)
 					if err != nil {
-						l.Errorf("Rebalancer::initRebalAsync Planner Error %v", err)
+						rebalanceLog.Errorf("Rebalancer::initRebalAsync Planner Error %v", err)
 						go r.finishRebalance(err)
 						return
 					}
@@ -411,11 +412,11 @@ func (r *Rebalancer) initRebalAsync() {
 					}
 
 					elapsed := time.Since(start)
-					l.Infof("Rebalancer::initRebalAsync Planner Time Taken %v", elapsed)
+					rebalanceLog.Infof("Rebalancer::initRebalAsync Planner Time Taken %v", elapsed)
 					break loop
 				}
 			}
-			l.Errorf("Rebalancer::initRebalAsync All Indexers Not Active. Waiting...")
+			rebalanceLog.Errorf("Rebalancer::initRebalAsync All Indexers Not Active. Waiting...")
 			time.Sleep(5 * time.Second)
 		}
 	}
@@ -426,7 +427,7 @@ func (r *Rebalancer) initRebalAsync() {
 // Cancel cancels a currently running rebalance or failover and waits
 // for its go routines to finish.
 func (r *Rebalancer) Cancel() {
-	l.Infof("Rebalancer::Cancel Exiting")
+	rebalanceLog.Infof("Rebalancer::Cancel Exiting")
 
 	r.cancelMetakv()
 
@@ -454,7 +455,7 @@ func (r *Rebalancer) finishRebalance(err error) {
 }
 
 func (r *Rebalancer) doFinish() {
-	l.Infof("Rebalancer::doFinish Cleanup %v", r.retErr)
+	rebalanceLog.Infof("Rebalancer::doFinish Cleanup %v", r.retErr)
 	atomic.StoreInt32(&r.isDone, 1)
 	close(r.done)
 	r.cancelMetakv()
@@ -515,7 +516,7 @@ func (r *Rebalancer) doRebalance() {
 
 		select {
 		case <-r.cancel:
-			l.Infof("Rebalancer::doRebalance Cancel Received. Skip Publishing Tokens.")
+			rebalanceLog.Infof("Rebalancer::doRebalance Cancel Received. Skip Publishing Tokens.")
 			return
 
 		default:
@@ -552,9 +553,9 @@ func (r *Rebalancer) publishDeferredTokens() {
 		}
 	}
 	if published > 0 {
-		l.Infof("%v Published %v deferred tokens: %v", method, published, publishedIds.String())
+		rebalanceLog.Infof("%v Published %v deferred tokens: %v", method, published, publishedIds.String())
 	} else {
-		l.Infof("%v No deferred tokens to publish", method)
+		rebalanceLog.Infof("%v No deferred tokens to publish", method)
 	}
 }
 
@@ -654,7 +655,7 @@ func (r *Rebalancer) publishTransferTokenBatch(first bool) {
 		fmt.Fprintf(&publishedIds, " %v", ttid)
 	}
 	c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, &r.bigMutex, "bigMutex", method, "")
-	l.Infof("Rebalancer::publishTransferTokenBatch: first %v, published %v transfer tokens: %v",
+	rebalanceLog.Infof("Rebalancer::publishTransferTokenBatch: first %v, published %v transfer tokens: %v",
 		first, len(startTokens), publishedIds.String())
 }
 
@@ -841,7 +842,7 @@ func (r *Rebalancer) selectSmartToBuildTokensLOCKED(batchSize int, numToSelect i
 	// Log stats of selected tokens
 	ttShares := // stream shares: if N TTs share a stream, this counts as N-1 shares of the stream
 		ttHist[SB_RR_SHARED] + ttHist[SB_PARTN_SHARED] + ttHist[SB_COLL_SHARED]
-	logging.Infof("%v Selected %v tokens (%v stream shares, %v low load, %v high load):"+
+	rebalanceLog.Infof("%v Selected %v tokens (%v stream shares, %v low load, %v high load):"+
 		" RR shared %v, RR %v, Partn shared %v, Coll shared %v, Other %v",
 		_selectSmartToBuildTokensLOCKED, ttTot, ttShares, ttLow, ttHigh, ttHist[SB_RR_SHARED],
 		ttHist[SB_RR], ttHist[SB_PARTN_SHARED], ttHist[SB_COLL_SHARED], ttHist[SB_OTHER])
@@ -1078,7 +1079,7 @@ func (r *Rebalancer) setNodeUUIDStatsHighLoadLOCKED(nodeUUID string) {
 func (r *Rebalancer) getNodeIndexerStatsLoop() {
 	const method = "Rebalancer::getNodeIndexerStatsLoop:" // for logging
 
-	l.Infof("%v Goroutine started", method)
+	rebalanceLog.Infof("%v Goroutine started", method)
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
@@ -1087,10 +1088,10 @@ func (r *Rebalancer) getNodeIndexerStatsLoop() {
 		case <-ticker.C:
 			r.getNodeIndexerStats()
 		case <-r.cancel:
-			l.Infof("%v Cancel received", method)
+			rebalanceLog.Infof("%v Cancel received", method)
 			return
 		case <-r.done:
-			l.Infof("%v Done received", method)
+			rebalanceLog.Infof("%v Done received", method)
 			return
 		}
 	}
@@ -1143,7 +1144,7 @@ func (r *Rebalancer) getNodeIndexerStats() {
 	}
 
 	// Log stats on current node loads
-	logging.Infof("%v Node loads: %v low %v, %v high %v, %v unavailable assumed high %v",
+	rebalanceLog.Infof("%v Node loads: %v low %v, %v high %v, %v unavailable assumed high %v",
 		_getNodeIndexerStats, len(availLow), availLow, len(availHigh), availHigh,
 		len(unavailHigh), unavailHigh)
 }
@@ -1248,16 +1249,16 @@ func (r *Rebalancer) findSameCollectionLOCKED(toBuildTTsForNode, selectedTTsForN
 // on each mutation of a TT until an error occurs or its stop channel is closed. These
 // callbacks trigger the individual index movement steps of the rebalance.
 func (r *Rebalancer) observeRebalance() {
-	l.Infof("Rebalancer::observeRebalance %v master:%v", r.rebalToken, r.master)
+	rebalanceLog.Infof("Rebalancer::observeRebalance %v master:%v", r.rebalToken, r.master)
 
 	<-r.waitForTokenPublish
 
 	err := metakv.RunObserveChildren(RebalanceMetakvDir, r.processTokens, r.metakvCancel)
 	if err != nil {
-		l.Infof("Rebalancer::observeRebalance Exiting On Metakv Error %v", err)
+		rebalanceLog.Infof("Rebalancer::observeRebalance Exiting On Metakv Error %v", err)
 		r.finishRebalance(err)
 	}
-	l.Infof("Rebalancer::observeRebalance exiting err %v", err)
+	rebalanceLog.Infof("Rebalancer::observeRebalance exiting err %v", err)
 }
 
 // processTokens is the callback registered on the metakv transfer token directory for
@@ -1267,9 +1268,9 @@ func (r *Rebalancer) processTokens(kve metakv.KVEntry) error {
 	const _processTokens string = "Rebalancer::processTokens:" // for logging
 
 	if kve.Path == RebalanceTokenPath || kve.Path == MoveIndexTokenPath {
-		l.Infof("%v RebalanceToken %v %s", _processTokens, kve.Path, kve.Value)
+		rebalanceLog.Infof("%v RebalanceToken %v %s", _processTokens, kve.Path, kve.Value)
 		if kve.Value == nil {
-			l.Infof("%v Rebalance Token Deleted. Mark Done.", _processTokens)
+			rebalanceLog.Infof("%v Rebalance Token Deleted. Mark Done.", _processTokens)
 			r.cancelMetakv()
 			r.finishRebalance(nil)
 		}
@@ -1277,12 +1278,12 @@ func (r *Rebalancer) processTokens(kve metakv.KVEntry) error {
 		if kve.Value != nil {
 			ttid, tt, err := r.decodeTransferToken(kve.Path, kve.Value)
 			if err != nil {
-				l.Errorf("%v Unable to decode transfer token. Ignored.", _processTokens)
+				rebalanceLog.Errorf("%v Unable to decode transfer token. Ignored.", _processTokens)
 				return nil
 			}
 			r.processTransferToken(ttid, tt)
 		} else {
-			l.Infof("%v Received empty or deleted transfer token %v", _processTokens, kve.Path)
+			rebalanceLog.Infof("%v Received empty or deleted transfer token %v", _processTokens, kve.Path)
 		}
 
 		// In a cluster with down-level nodes, we cannot overlap builds/merges with drops/prunes as
@@ -1347,7 +1348,7 @@ func (r *Rebalancer) processTokenAsSource(ttid string, tt *c.TransferToken) bool
 	const method string = "Rebalancer::processTokenAsSource:" // for logging
 
 	if tt.RebalId != r.rebalToken.RebalId {
-		l.Warnf("%v Found TransferToken with Unknown "+
+		rebalanceLog.Warnf("%v Found TransferToken with Unknown "+
 			"RebalanceId. Local RId %v Token %v. Ignored.", method, r.rebalToken.RebalId, tt)
 		return true
 	}
@@ -1363,7 +1364,7 @@ func (r *Rebalancer) processTokenAsSource(ttid string, tt *c.TransferToken) bool
 		defer c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, &r.bigMutex, "bigMutex", method, "")
 
 		r.sourceTokens[ttid] = tt
-		logging.Infof("%v Processing transfer token: %v", method, tt)
+		rebalanceLog.Infof("%v Processing transfer token: %v", method, tt)
 
 		// If there are pre-7.1.0 nodes we cannot pipeline the work due to MB-48191
 		if r.clusterVersion >= common.INDEXER_71_VERSION {
@@ -1390,14 +1391,14 @@ func (r *Rebalancer) processDropIndexQueue() {
 	for {
 		select {
 		case <-r.cancel:
-			l.Infof("Rebalancer::processDropIndexQueue Cancel Received")
+			rebalanceLog.Infof("Rebalancer::processDropIndexQueue Cancel Received")
 			return
 		case <-r.done:
-			l.Infof("Rebalancer::processDropIndexQueue Done Received")
+			rebalanceLog.Infof("Rebalancer::processDropIndexQueue Done Received")
 			return
 		case ttid := <-r.dropQueue:
 			var tt c.TransferToken
-			logging.Infof("Rebalancer::processDropIndexQueue processing drop index request for ttid: %v", ttid)
+			rebalanceLog.Infof("Rebalancer::processDropIndexQueue processing drop index request for ttid: %v", ttid)
 			if first {
 				// If it is the first drop, let wait to give a chance for the target's metadata
 				// being synchronized with the cbq nodes.  This is to ensure that the cbq nodes
@@ -1414,7 +1415,7 @@ func (r *Rebalancer) processDropIndexQueue() {
 			c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, &r.bigMutex, "bigMutex", method, "")
 
 			if !ok {
-				l.Warnf("Rebalancer::processDropIndexQueue: Cannot find token %v in r.sourceTokens. Skip drop index.", ttid)
+				rebalanceLog.Warnf("Rebalancer::processDropIndexQueue: Cannot find token %v in r.sourceTokens. Skip drop index.", ttid)
 				continue
 			}
 
@@ -1423,10 +1424,10 @@ func (r *Rebalancer) processDropIndexQueue() {
 					notifych <- true
 					go r.dropIndexWhenIdle(ttid, &tt, notifych)
 				} else {
-					logging.Warnf("Rebalancer::processDropIndexQueue Skip processing drop index request for tt: %v as rebalancer can not add to wait group", tt)
+					rebalanceLog.Warnf("Rebalancer::processDropIndexQueue Skip processing drop index request for tt: %v as rebalancer can not add to wait group", tt)
 				}
 			} else {
-				logging.Warnf("Rebalancer::processDropIndexQueue Skipping drop index request for tt: %v as state: %v != TransferTokenReady", tt, tt.State.String())
+				rebalanceLog.Warnf("Rebalancer::processDropIndexQueue Skipping drop index request for tt: %v as state: %v != TransferTokenReady", tt, tt.State.String())
 			}
 		}
 	}
@@ -1443,11 +1444,11 @@ func (r *Rebalancer) queueDropIndexLOCKED(ttid string) {
 
 	select {
 	case <-r.cancel:
-		l.Warnf("%v Cannot drop index when rebalance being canceled.", method)
+		rebalanceLog.Warnf("%v Cannot drop index when rebalance being canceled.", method)
 		return
 
 	case <-r.done:
-		l.Warnf("%v Cannot drop index when rebalance is done.", method)
+		rebalanceLog.Warnf("%v Cannot drop index when rebalance is done.", method)
 		return
 
 	default:
@@ -1455,9 +1456,9 @@ func (r *Rebalancer) queueDropIndexLOCKED(ttid string) {
 		if _, member := r.dropQueued[ttid]; !member {
 			r.dropQueued[ttid] = struct{}{}
 			r.dropQueue <- ttid
-			logging.Infof("%v Queued index %v for drop, ttid: %v", method, indexName, ttid)
+			rebalanceLog.Infof("%v Queued index %v for drop, ttid: %v", method, indexName, ttid)
 		} else {
-			logging.Warnf("%v Skipped index %v that was previously queued for drop, ttid: %v",
+			rebalanceLog.Warnf("%v Skipped index %v that was previously queued for drop, ttid: %v",
 				method, indexName, ttid)
 		}
 	}
@@ -1478,7 +1479,7 @@ func (r *Rebalancer) maybeQueueDropIndexesForDownLevelCluster() {
 			r.queueDropIndexLOCKED(ttid)
 		}
 		c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, &r.bigMutex, "bigMutex", method, "")
-		logging.Infof("%v queued ttids for drop: %v", method, dropTokenIds)
+		rebalanceLog.Infof("%v queued ttids for drop: %v", method, dropTokenIds)
 	}
 }
 
@@ -1541,10 +1542,10 @@ loop:
 	labelselect:
 		select {
 		case <-r.cancel:
-			l.Infof("%v Cancel Received", method)
+			rebalanceLog.Infof("%v Cancel Received", method)
 			break loop
 		case <-r.done:
-			l.Infof("%v Done Received", method)
+			rebalanceLog.Infof("%v Done Received", method)
 			break loop
 
 		default:
@@ -1552,7 +1553,7 @@ loop:
 			defnKey := getStatsDefnKey(tt)
 			defnStats := allStats.indexes[defnKey] // stats for current defn
 			if defnStats == nil {
-				l.Infof("%v Missing defnStats for instId %v. Retrying...", method, defnKey)
+				rebalanceLog.Infof("%v Missing defnStats for instId %v. Retrying...", method, defnKey)
 				break
 			}
 
@@ -1563,7 +1564,7 @@ loop:
 					numRequests = partnStats.numRequests.GetValue().(int64)
 					numCompletedRequests = partnStats.numCompletedRequests.GetValue().(int64)
 				} else {
-					l.Infof("%v Missing partnStats for instId %d partition %v. Retrying...",
+					rebalanceLog.Infof("%v Missing partnStats for instId %d partition %v. Retrying...",
 						method, defnKey, partitionId)
 					missingStatRetry++
 					if missingStatRetry > (50 / sleepSecs) {
@@ -1579,7 +1580,7 @@ loop:
 			}
 
 			if pending > 0 {
-				l.Infof("%v Index %v:%v:%v:%v has %v pending scans",
+				rebalanceLog.Infof("%v Index %v:%v:%v:%v has %v pending scans",
 					method, defn.Bucket, defn.Scope, defn.Collection, defn.Name, pending)
 				break
 			}
@@ -1587,7 +1588,7 @@ loop:
 			req := manager.IndexRequest{Index: *defn}
 			body, err := json.Marshal(&req)
 			if err != nil {
-				l.Errorf("%v Error marshal drop index %v", method, err)
+				rebalanceLog.Errorf("%v Error marshal drop index %v", method, err)
 				r.setTransferTokenError(ttid, tt, err.Error())
 				return
 			}
@@ -1598,14 +1599,14 @@ loop:
 			resp, err := postWithAuth(r.localaddr+url, "application/json", bodybuf)
 			if err != nil {
 				// Error from HTTP layer, not from index processing code
-				l.Errorf("%v Error drop index on %v %v", method, r.localaddr+url, err)
+				rebalanceLog.Errorf("%v Error drop index on %v %v", method, r.localaddr+url, err)
 				r.setTransferTokenError(ttid, tt, err.Error())
 				return
 			}
 
 			response := new(manager.IndexResponse)
 			if err := convertResponse(resp, response); err != nil {
-				l.Errorf("%v Error unmarshal response %v %v", method, r.localaddr+url, err)
+				rebalanceLog.Errorf("%v Error unmarshal response %v %v", method, r.localaddr+url, err)
 				r.setTransferTokenError(ttid, tt, err.Error())
 				return
 			}
@@ -1613,13 +1614,13 @@ loop:
 			if response.Code == manager.RESP_ERROR {
 				// Error from index processing code (e.g. the string from common.ErrCollectionNotFound)
 				if !isMissingBSC(response.Error) {
-					l.Errorf("%v Error dropping index %v %v",
+					rebalanceLog.Errorf("%v Error dropping index %v %v",
 						method, r.localaddr+url, response.Error)
 					r.setTransferTokenError(ttid, tt, response.Error)
 					return
 				}
 				// Ok: failed to drop source index because b/s/c was dropped. Continue to TransferTokenCommit state.
-				l.Infof("%v Source index already dropped due to bucket/scope/collection dropped. tt %v.", method, tt)
+				rebalanceLog.Infof("%v Source index already dropped due to bucket/scope/collection dropped. tt %v.", method, tt)
 			}
 			tt.State = c.TransferTokenCommit
 			setTransferTokenInMetakv(ttid, tt)
@@ -1642,19 +1643,19 @@ func (r *Rebalancer) needRetryForDrop(ttid string, tt *c.TransferToken) bool {
 
 	localMeta, err := getLocalMeta(r.localaddr)
 	if err != nil {
-		l.Errorf("%v Error Fetching Local Meta %v %v", method, r.localaddr, err)
+		rebalanceLog.Errorf("%v Error Fetching Local Meta %v %v", method, r.localaddr, err)
 		return true
 	}
 	indexState, errStr := getIndexStatusFromMeta(tt, localMeta)
 	if errStr != "" {
-		l.Errorf("%v Error Fetching Index Status %v %v", method, r.localaddr, errStr)
+		rebalanceLog.Errorf("%v Error Fetching Index Status %v %v", method, r.localaddr, errStr)
 		return true
 	}
 
 	if indexState == c.INDEX_STATE_NIL {
 		//if index cannot be found in metadata, most likely its drop has already succeeded.
 		//instead of waiting indefinitely, it is better to assume success and proceed.
-		l.Infof("%v Missing Metadata for %v. Assume success and abort retry",
+		rebalanceLog.Infof("%v Missing Metadata for %v. Assume success and abort retry",
 			method, tt.IndexInst)
 		tt.State = c.TransferTokenCommit
 		setTransferTokenInMetakv(ttid, tt)
@@ -1679,7 +1680,7 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 	const method string = "Rebalancer::processTokenAsDest:" // for logging
 
 	if tt.RebalId != r.rebalToken.RebalId {
-		l.Warnf("%v Found TransferToken with Unknown "+
+		rebalanceLog.Warnf("%v Found TransferToken with Unknown "+
 			"RebalanceId. Local RId %v Token %v. Ignored.", method, r.rebalToken.RebalId, tt)
 		return true
 	}
@@ -1704,7 +1705,7 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 			ir := manager.IndexRequest{Index: indexDefn}
 			body, err := json.Marshal(&ir)
 			if err != nil {
-				l.Errorf("%v Error marshal clone index %v", method, err)
+				rebalanceLog.Errorf("%v Error marshal clone index %v", method, err)
 				r.setTransferTokenError(ttid, tt, err.Error())
 				return nil, true
 			}
@@ -1724,7 +1725,7 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 		resp, err = postWithAuth(r.localaddr+url, "application/json", bodybuf)
 		if err != nil {
 			// Error from HTTP layer, not from index processing code
-			l.Errorf("%v Error register clone index on %v %v", method, r.localaddr+url, err)
+			rebalanceLog.Errorf("%v Error register clone index on %v %v", method, r.localaddr+url, err)
 			// If the error is io.EOF, then it is possible that server side
 			// may have closed the connection while client is about the send the request.
 			// Though this is extremely unlikely, this is observed for multiple users
@@ -1749,12 +1750,12 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 				}
 				resp, err = postWithAuth(r.localaddr+url, "application/json", bodybuf)
 				if err != nil {
-					l.Errorf("%v Error register clone index during retry on %v %v",
+					rebalanceLog.Errorf("%v Error register clone index during retry on %v %v",
 						method, r.localaddr+url, err)
 					r.setTransferTokenError(ttid, tt, err.Error())
 					return true
 				} else {
-					l.Infof("%v Successful POST of createIndexRebalance during retry on %v, defnId: %v, instId: %v",
+					rebalanceLog.Infof("%v Successful POST of createIndexRebalance during retry on %v, defnId: %v, instId: %v",
 						method, r.localaddr+url, indexDefn.DefnId, indexDefn.InstId)
 				}
 			} else {
@@ -1765,19 +1766,19 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 
 		response := new(manager.IndexResponse)
 		if err := convertResponse(resp, response); err != nil {
-			l.Errorf("%v Error unmarshal response %v %v", method, r.localaddr+url, err)
+			rebalanceLog.Errorf("%v Error unmarshal response %v %v", method, r.localaddr+url, err)
 			r.setTransferTokenError(ttid, tt, err.Error())
 			return true
 		}
 		if response.Code == manager.RESP_ERROR {
 			// Error from index processing code (e.g. the string from common.ErrCollectionNotFound)
 			if !isMissingBSC(response.Error) {
-				l.Errorf("%v Error cloning index %v %v", method, r.localaddr+url, response.Error)
+				rebalanceLog.Errorf("%v Error cloning index %v %v", method, r.localaddr+url, response.Error)
 				r.setTransferTokenError(ttid, tt, response.Error)
 				return true
 			}
 			// Ok: failed to create dest index because b/s/c was dropped. Skip to TransferTokenCommit state.
-			l.Infof("%v Create destination index failed due to bucket/scope/collection dropped. Skipping. tt %v.", method, tt)
+			rebalanceLog.Infof("%v Create destination index failed due to bucket/scope/collection dropped. Skipping. tt %v.", method, tt)
 			tt.State = c.TransferTokenCommit
 		} else {
 			tt.State = c.TransferTokenAccepted
@@ -1795,7 +1796,7 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 
 		att, ok := r.acceptedTokens[ttid]
 		if !ok {
-			l.Errorf("%v Unknown TransferToken for Initiate %v %v", method, ttid, tt)
+			rebalanceLog.Errorf("%v Unknown TransferToken for Initiate %v %v", method, ttid, tt)
 			r.setTransferTokenError(ttid, tt, "Unknown TransferToken For Initiate")
 			return true
 		}
@@ -1837,7 +1838,7 @@ func (r *Rebalancer) checkValidNotifyStateDest(ttid string, tt *c.TransferToken)
 
 	if att, ok := r.acceptedTokens[ttid]; ok {
 		if tt.State <= att.State {
-			l.Warnf("%v Detected Invalid State "+
+			rebalanceLog.Warnf("%v Detected Invalid State "+
 				"Change Notification. Token Id %v Local State %v Metakv State %v", ttid,
 				method, att.State, tt.State)
 			return false
@@ -1854,7 +1855,7 @@ func (r *Rebalancer) checkValidNotifyStateSource(ttid string, tt *c.TransferToke
 
 	if tto, ok := r.sourceTokens[ttid]; ok {
 		if tt.State <= tto.State {
-			l.Warnf("%v Detected Invalid State "+
+			rebalanceLog.Warnf("%v Detected Invalid State "+
 				"Change Notification. Token Id %v Local State %v Metakv State %v", ttid,
 				method, tto.State, tt.State)
 			return false
@@ -1877,7 +1878,7 @@ func (r *Rebalancer) checkAllAcceptedIndexesReadyToBuildLOCKED() (buildTokens ma
 			tt.State != c.TransferTokenMerge &&
 			tt.State != c.TransferTokenReady &&
 			tt.State != c.TransferTokenCommit {
-			l.Infof("Rebalancer::checkAllAcceptedIndexesReadyToBuildLOCKED Not ready to build %v %v", ttid, tt)
+			rebalanceLog.Infof("Rebalancer::checkAllAcceptedIndexesReadyToBuildLOCKED Not ready to build %v %v", ttid, tt)
 			return nil
 		}
 		if tt.State == c.TransferTokenInProgress { // needs build
@@ -1932,7 +1933,7 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 	}
 
 	if len(idList.DefnIds) == 0 {
-		l.Infof("%v Nothing to build", method)
+		rebalanceLog.Infof("%v Nothing to build", method)
 		return
 	}
 
@@ -1943,7 +1944,7 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 		ir := manager.IndexRequest{IndexIds: idList}
 		body, err := json.Marshal(&ir)
 		if err != nil {
-			l.Errorf("%v Error marshalling index inst list: %v, err: %v", method, idList, err)
+			rebalanceLog.Errorf("%v Error marshalling index inst list: %v, err: %v", method, idList, err)
 			return nil, err
 		}
 		bodybuf := bytes.NewBuffer(body)
@@ -1964,18 +1965,18 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 	resp, err = postWithAuth(r.localaddr+url, "application/json", bodybuf)
 	if err != nil {
 		// Error from HTTP layer, not from index processing code
-		l.Errorf("%v Error register clone index on %v %v", method, r.localaddr+url, err)
+		rebalanceLog.Errorf("%v Error register clone index on %v %v", method, r.localaddr+url, err)
 		if strings.HasSuffix(err.Error(), ": EOF") {
 			// Retry build again before failing rebalance
 			bodybuf, err = getReqBody()
 			resp, err = postWithAuth(r.localaddr+url, "application/json", bodybuf)
 			if err != nil {
-				l.Errorf("%v Error register clone index during retry on %v %v",
+				rebalanceLog.Errorf("%v Error register clone index during retry on %v %v",
 					method, r.localaddr+url, err)
 				errStr = err.Error()
 				goto cleanup
 			} else {
-				l.Infof("%v Successful POST of buildIndexRebalance during retry on %v, instIdList: %v",
+				rebalanceLog.Infof("%v Successful POST of buildIndexRebalance during retry on %v, instIdList: %v",
 					method, r.localaddr+url, idList)
 			}
 		} else {
@@ -1985,7 +1986,7 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 	}
 
 	if err := convertResponse(resp, response); err != nil {
-		l.Errorf("%v Error unmarshal response %v %v", method, r.localaddr+url, err)
+		rebalanceLog.Errorf("%v Error unmarshal response %v %v", method, r.localaddr+url, err)
 		errStr = err.Error()
 		goto cleanup
 	}
@@ -1994,7 +1995,7 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 		// or a JSON string of a marshaled map[IndexInstId]string of error messages per instance ID. The
 		// keys for any entries having magic error string ErrIndexNotFoundRebal.Error are the submitted
 		// defnIds instead of instIds, as the metadata could not be found for these.
-		l.Errorf("%v Error cloning index %v %v", method, r.localaddr+url, response.Error)
+		rebalanceLog.Errorf("%v Error cloning index %v %v", method, r.localaddr+url, response.Error)
 		errStr = response.Error
 		if errStr == common.ErrMarshalFailed.Error() { // no detailed error info available
 			goto cleanup
@@ -2015,7 +2016,7 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 				lockTime := c.TraceRWMutexLOCK(c.LOCK_WRITE, &r.bigMutex, "1 bigMutex", method, "")
 				for ttid, tt := range buildTokens {
 					if id == tt.IndexInst.InstId {
-						l.Infof("%v Build destination index failed due to bucket/scope/collection dropped. Skipping. tt %v.", method, tt)
+						rebalanceLog.Infof("%v Build destination index failed due to bucket/scope/collection dropped. Skipping. tt %v.", method, tt)
 						tt.State = c.TransferTokenCommit
 						setTransferTokenInMetakv(ttid, tt)
 						break
@@ -2028,7 +2029,7 @@ func (r *Rebalancer) buildAcceptedIndexes(buildTokens map[string]*common.Transfe
 				lockTime := c.TraceRWMutexLOCK(c.LOCK_WRITE, &r.bigMutex, "2 bigMutex", method, "")
 				for ttid, tt := range buildTokens {
 					if defnId == tt.IndexInst.Defn.DefnId {
-						l.Infof("%v Build destination index failed due to index metadata missing; bucket/scope/collection likely dropped. Skipping. tt %v.", method, tt)
+						rebalanceLog.Infof("%v Build destination index failed due to index metadata missing; bucket/scope/collection likely dropped. Skipping. tt %v.", method, tt)
 						tt.State = c.TransferTokenCommit
 						setTransferTokenInMetakv(ttid, tt)
 					}
@@ -2067,10 +2068,10 @@ loop:
 	for {
 		select {
 		case <-r.cancel:
-			l.Infof("%v Cancel Received", method)
+			rebalanceLog.Infof("%v Cancel Received", method)
 			break loop
 		case <-r.done:
-			l.Infof("%v Done Received", method)
+			rebalanceLog.Infof("%v Done Received", method)
 			break loop
 
 		default:
@@ -2078,10 +2079,10 @@ loop:
 
 			indexerState := allStats.indexerStateHolder.GetValue().(string)
 			if indexerState == "Paused" {
-				l.Errorf("%v Paused state detected for %v", method, r.localaddr)
+				rebalanceLog.Errorf("%v Paused state detected for %v", method, r.localaddr)
 				lockTime := c.TraceRWMutexLOCK(c.LOCK_WRITE, &r.bigMutex, "1 bigMutex", method, "")
 				for ttid, tt := range r.acceptedTokens {
-					l.Errorf("%v Token State Changed to Error %v %v", method, ttid, tt)
+					rebalanceLog.Errorf("%v Token State Changed to Error %v %v", method, ttid, tt)
 					r.setTransferTokenError(ttid, tt, "Indexer In Paused State")
 				}
 				c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, &r.bigMutex, "1 bigMutex", method, "")
@@ -2090,7 +2091,7 @@ loop:
 
 			localMeta, err := getLocalMeta(r.localaddr)
 			if err != nil {
-				l.Errorf("%v Error Fetching Local Meta %v %v", method, r.localaddr, err)
+				rebalanceLog.Errorf("%v Error Fetching Local Meta %v %v", method, r.localaddr, err)
 				break
 			}
 
@@ -2108,12 +2109,12 @@ loop:
 
 				indexState, err := getIndexStatusFromMeta(tt, localMeta)
 				if indexState == c.INDEX_STATE_NIL || indexState == c.INDEX_STATE_DELETED {
-					l.Infof("%v Could not get index status; bucket/scope/collection likely dropped."+
+					rebalanceLog.Infof("%v Could not get index status; bucket/scope/collection likely dropped."+
 						" Skipping. indexState %v, err %v, tt %v.", method, indexState, err, tt)
 					tt.State = c.TransferTokenCommit // skip forward instead of failing rebalance
 					setTransferTokenInMetakv(ttid, tt)
 				} else if err != "" {
-					l.Errorf("%v Error Fetching Index Status %v %v", method, r.localaddr, err)
+					rebalanceLog.Errorf("%v Error Fetching Index Status %v %v", method, r.localaddr, err)
 					break
 				}
 
@@ -2122,7 +2123,7 @@ loop:
 				defnKey := getStatsDefnKey(tt)
 				defnStats := allStats.indexes[defnKey] // stats for current defn
 				if defnStats == nil {
-					l.Infof("%v Missing defnStats for instId %v. Retrying...", method, defnKey)
+					rebalanceLog.Infof("%v Missing defnStats for instId %v. Retrying...", method, defnKey)
 					break
 				}
 
@@ -2144,7 +2145,7 @@ loop:
 					remainingBuildTime = 0
 				}
 
-				l.Infof("%v Index: %v:%v:%v:%v State: %v"+
+				rebalanceLog.Infof("%v Index: %v:%v:%v:%v State: %v"+
 					" Pending: %v EstTime: %v Partitions: %v Destination: %v",
 					method, defn.Bucket, defn.Scope, defn.Collection, defn.Name, indexState,
 					tot_remaining, remainingBuildTime, defn.Partitions, r.localaddr)
@@ -2156,7 +2157,7 @@ loop:
 			c.TraceRWMutexUNLOCK(lockTime, c.LOCK_WRITE, &r.bigMutex, "2 bigMutex", method, "")
 
 			if allTokensReady {
-				l.Infof("%v Batch Done", method)
+				rebalanceLog.Infof("%v Batch Done", method)
 				break loop
 			}
 		} // select
@@ -2213,10 +2214,10 @@ func (r *Rebalancer) destTokenToMergeOrReadyLOCKED(ttid string, tt *c.TransferTo
 			var err error
 			select {
 			case <-r.cancel:
-				l.Infof("%v rebalancer cancel Received", method)
+				rebalanceLog.Infof("%v rebalancer cancel Received", method)
 				return
 			case <-r.done:
-				l.Infof("%v rebalancer done Received", method)
+				rebalanceLog.Infof("%v rebalancer done Received", method)
 				return
 			case err = <-respch:
 			}
@@ -2263,13 +2264,13 @@ func (r *Rebalancer) destTokenToMergeOrReadyLOCKED(ttid string, tt *c.TransferTo
 func (r *Rebalancer) processTokenAsMaster(ttid string, tt *c.TransferToken) bool {
 
 	if tt.RebalId != r.rebalToken.RebalId {
-		l.Warnf("Rebalancer::processTokenAsMaster Found TransferToken with Unknown "+
+		rebalanceLog.Warnf("Rebalancer::processTokenAsMaster Found TransferToken with Unknown "+
 			"RebalanceId. Local RId %v Token %v. Ignored.", r.rebalToken.RebalId, tt)
 		return true
 	}
 
 	if tt.Error != "" {
-		l.Errorf("Rebalancer::processTokenAsMaster Detected TransferToken in Error state %v. Abort.", tt)
+		rebalanceLog.Errorf("Rebalancer::processTokenAsMaster Detected TransferToken in Error state %v. Abort.", tt)
 
 		r.cancelMetakv()
 		go r.finishRebalance(errors.New(tt.Error))
@@ -2294,7 +2295,7 @@ func (r *Rebalancer) processTokenAsMaster(ttid string, tt *c.TransferToken) bool
 	case c.TransferTokenDeleted:
 		err := c.MetakvDel(RebalanceMetakvDir + ttid)
 		if err != nil {
-			l.Fatalf("Rebalancer::processTokenAsMaster Unable to set TransferToken In "+
+			rebalanceLog.Fatalf("Rebalancer::processTokenAsMaster Unable to set TransferToken In "+
 				"Meta Storage. %v. Err %v", tt, err)
 			c.CrashOnError(err)
 		}
@@ -2304,7 +2305,7 @@ func (r *Rebalancer) processTokenAsMaster(ttid string, tt *c.TransferToken) bool
 			if r.cb.progress != nil {
 				r.cb.progress(1.0, r.cancel)
 			}
-			l.Infof("Rebalancer::processTokenAsMaster No Tokens Found. Mark Done.")
+			rebalanceLog.Infof("Rebalancer::processTokenAsMaster No Tokens Found. Mark Done.")
 			r.cancelMetakv()
 			go r.finishRebalance(nil)
 		} else {
@@ -2346,7 +2347,7 @@ func setTransferTokenInMetakv(ttid string, tt *c.TransferToken) {
 
 	fn := func(r int, err error) error {
 		if r > 0 {
-			l.Warnf("Rebalancer::setTransferTokenInMetakv error=%v Retrying (%d)", err, r)
+			rebalanceLog.Warnf("Rebalancer::setTransferTokenInMetakv error=%v Retrying (%d)", err, r)
 		}
 		err = c.MetakvSet(RebalanceMetakvDir+ttid, tt)
 		return err
@@ -2356,7 +2357,7 @@ func setTransferTokenInMetakv(ttid string, tt *c.TransferToken) {
 	err := rh.Run()
 
 	if err != nil {
-		l.Fatalf("Rebalancer::setTransferTokenInMetakv Unable to set TransferToken In "+
+		rebalanceLog.Fatalf("Rebalancer::setTransferTokenInMetakv Unable to set TransferToken In "+
 			"Meta Storage. %v %v. Err %v", ttid, tt, err)
 		c.CrashOnError(err)
 	}
@@ -2371,12 +2372,12 @@ func (r *Rebalancer) decodeTransferToken(path string, value []byte) (string, *c.
 	tt := &c.TransferToken{}
 	err := json.Unmarshal(value, tt)
 	if err != nil {
-		l.Fatalf("Rebalancer::decodeTransferToken Failed unmarshalling value for %s: %s\n%s",
+		rebalanceLog.Fatalf("Rebalancer::decodeTransferToken Failed unmarshalling value for %s: %s\n%s",
 			path, err.Error(), string(value))
 		return "", nil, err
 	}
 
-	l.Infof("Rebalancer::decodeTransferToken TransferToken %v %v", ttid, tt)
+	rebalanceLog.Infof("Rebalancer::decodeTransferToken TransferToken %v %v", ttid, tt)
 
 	return ttid, tt, nil
 
@@ -2391,7 +2392,7 @@ func (r *Rebalancer) updateProgress() {
 	}
 	defer r.wg.Done()
 
-	l.Infof("Rebalancer::updateProgress goroutine started")
+	rebalanceLog.Infof("Rebalancer::updateProgress goroutine started")
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
@@ -2401,10 +2402,10 @@ func (r *Rebalancer) updateProgress() {
 			progress := r.computeProgress()
 			r.cb.progress(progress, r.cancel)
 		case <-r.cancel:
-			l.Infof("Rebalancer::updateProgress Cancel Received")
+			rebalanceLog.Infof("Rebalancer::updateProgress Cancel Received")
 			return
 		case <-r.done:
-			l.Infof("Rebalancer::updateProgress Done Received")
+			rebalanceLog.Infof("Rebalancer::updateProgress Done Received")
 			return
 		}
 	}
@@ -2417,7 +2418,7 @@ func (r *Rebalancer) computeProgress() (progress float64) {
 	url := "/getIndexStatus?getAll=true"
 	resp, err := getWithAuth(r.localaddr + url)
 	if err != nil {
-		l.Errorf("Rebalancer::computeProgress Error getting local metadata %v %v", r.localaddr+url, err)
+		rebalanceLog.Errorf("Rebalancer::computeProgress Error getting local metadata %v %v", r.localaddr+url, err)
 		return
 	}
 
@@ -2425,7 +2426,7 @@ func (r *Rebalancer) computeProgress() (progress float64) {
 	statusResp := new(manager.IndexStatusResponse)
 	bytes, _ := ioutil.ReadAll(resp.Body)
 	if err := json.Unmarshal(bytes, &statusResp); err != nil {
-		l.Errorf("Rebalancer::computeProgress Error unmarshal response %v %v", r.localaddr+url, err)
+		rebalanceLog.Errorf("Rebalancer::computeProgress Error unmarshal response %v %v", r.localaddr+url, err)
 		return
 	}
 
@@ -2447,7 +2448,7 @@ func (r *Rebalancer) computeProgress() (progress float64) {
 	}
 
 	progress = (totalProgress / float64(totTokens)) / 100.0
-	l.Infof("Rebalancer::computeProgress %v", progress)
+	rebalanceLog.Infof("Rebalancer::computeProgress %v", progress)
 
 	if progress < 0.1 || math.IsNaN(progress) {
 		progress = 0.1
@@ -2476,7 +2477,7 @@ func (r *Rebalancer) checkAllTokensDone() bool {
 func (r *Rebalancer) checkDDLRunning() (bool, error) {
 
 	if r.runParams != nil && r.runParams.ddlRunning {
-		l.Errorf("Rebalancer::doRebalance Found index build running. Cannot process rebalance.")
+		rebalanceLog.Errorf("Rebalancer::doRebalance Found index build running. Cannot process rebalance.")
 		fmtMsg := "indexer rebalance failure - index build is in progress for indexes: %v."
 		err := errors.New(fmt.Sprintf(fmtMsg, r.runParams.ddlRunningIndexNames))
 		return true, err
@@ -2568,7 +2569,7 @@ func (r *Rebalancer) getBuildProgress(status *manager.IndexStatusResponse, tt *c
 				}
 
 				destNode := getDestNode(defn.Partitions[0], idx.PartitionMap)
-				l.Infof("Rebalancer::getBuildProgress Index: %v:%v:%v:%v"+
+				rebalanceLog.Infof("Rebalancer::getBuildProgress Index: %v:%v:%v:%v"+
 					" Progress: %v InstId: %v RealInstId: %v Partitions: %v Destination: %v",
 					defn.Bucket, defn.Scope, defn.Collection, defn.Name,
 					progress, idx.InstId, tt.RealInstId, defn.Partitions, destNode)
@@ -2586,7 +2587,7 @@ func getLocalMeta(addr string) (*manager.LocalIndexMetadata, error) {
 	url := "/getLocalIndexMetadata?useETag=false"
 	resp, err := getWithAuth(addr + url)
 	if err != nil {
-		l.Errorf("Rebalancer::getLocalMeta Error getting local metadata %v %v", addr+url, err)
+		rebalanceLog.Errorf("Rebalancer::getLocalMeta Error getting local metadata %v %v", addr+url, err)
 		return nil, err
 	}
 
@@ -2594,7 +2595,7 @@ func getLocalMeta(addr string) (*manager.LocalIndexMetadata, error) {
 	localMeta := new(manager.LocalIndexMetadata)
 	bytes, _ := ioutil.ReadAll(resp.Body)
 	if err := json.Unmarshal(bytes, &localMeta); err != nil {
-		l.Errorf("Rebalancer::getLocalMeta Error unmarshal response %v %v", addr+url, err)
+		rebalanceLog.Errorf("Rebalancer::getLocalMeta Error unmarshal response %v %v", addr+url, err)
 		return nil, err
 	}
 
@@ -2608,12 +2609,12 @@ func checkAllIndexersWarmedup(clusterURL string) (bool, []string) {
 
 	cinfo, err := c.FetchNewClusterInfoCache2(clusterURL, c.DEFAULT_POOL, "checkAllIndexersWarmedup")
 	if err != nil {
-		l.Errorf("Rebalancer::checkAllIndexersWarmedup Error Fetching Cluster Information %v", err)
+		rebalanceLog.Errorf("Rebalancer::checkAllIndexersWarmedup Error Fetching Cluster Information %v", err)
 		return false, nil
 	}
 
 	if err := cinfo.FetchNodesAndSvsInfo(); err != nil {
-		l.Errorf("Rebalancer::checkAllIndexersWarmedup Error Fetching Nodes and serives Information %v", err)
+		rebalanceLog.Errorf("Rebalancer::checkAllIndexersWarmedup Error Fetching Nodes and serives Information %v", err)
 		return false, nil
 	}
 
@@ -2629,33 +2630,33 @@ func checkAllIndexersWarmedup(clusterURL string) (bool, []string) {
 
 			resp, err := getWithAuth(addr + url)
 			if err != nil {
-				l.Infof("Rebalancer::checkAllIndexersWarmedup Error Fetching Stats %v From %v", err, addr)
+				rebalanceLog.Infof("Rebalancer::checkAllIndexersWarmedup Error Fetching Stats %v From %v", err, addr)
 				return false, nil
 			}
 
 			stats := new(c.Statistics)
 			if err := convertResponse(resp, stats); err != nil {
-				l.Infof("Rebalancer::checkAllIndexersWarmedup Error Convert Response %v From %v", err, addr)
+				rebalanceLog.Infof("Rebalancer::checkAllIndexersWarmedup Error Convert Response %v From %v", err, addr)
 				return false, nil
 			}
 
 			statsMap := stats.ToMap()
 			if statsMap == nil {
-				l.Infof("Rebalancer::checkAllIndexersWarmedup Nil Stats From %v", addr)
+				rebalanceLog.Infof("Rebalancer::checkAllIndexersWarmedup Nil Stats From %v", addr)
 				return false, nil
 			}
 
 			if state, ok := statsMap["indexer_state"]; ok {
 				if state == "Paused" {
-					l.Infof("Rebalancer::checkAllIndexersWarmedup Paused state detected for %v", addr)
+					rebalanceLog.Infof("Rebalancer::checkAllIndexersWarmedup Paused state detected for %v", addr)
 					pausedAddr = append(pausedAddr, addr)
 				} else if state != "Active" {
-					l.Infof("Rebalancer::checkAllIndexersWarmedup Indexer %v State %v", addr, state)
+					rebalanceLog.Infof("Rebalancer::checkAllIndexersWarmedup Indexer %v State %v", addr, state)
 					allWarmedup = false
 				}
 			}
 		} else {
-			l.Errorf("Rebalancer::checkAllIndexersWarmedup Error Fetching Service Address %v", err)
+			rebalanceLog.Errorf("Rebalancer::checkAllIndexersWarmedup Error Fetching Service Address %v", err)
 			return false, nil
 		}
 	}
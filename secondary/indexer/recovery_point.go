@@ -0,0 +1,265 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Slices roll back to their own snapshots, so after a rollback the indexes
+// of a keyspace may restart from different timestamps, the stream
+// restarting from the oldest of them. With snapshot.atomicKeyspace, disk
+// snapshots are committed for all slices of a keyspace at each disk
+// snapshot, at the same timestamp. Once all of them are committed, the
+// timestamp is recorded as a recovery point of the keyspace, in a manifest
+// under storage_dir. On rollback, all slices of the keyspace roll back to
+// the latest recovery point older than the rollback timestamp which every
+// slice still has a snapshot of. If there is none, for example after an
+// index is created in the keyspace, slices pick their own snapshots.
+
+const RECOVERY_POINT_DIR = "recovery_points"
+
+// recoveryPointManifest is the persisted list of recovery points of a
+// keyspace in a stream, from the oldest.
+type recoveryPointManifest struct {
+	StreamId   common.StreamId    `json:"streamId"`
+	KeyspaceId string             `json:"keyspaceId"`
+	Points     []*common.TsVbuuid `json:"points"`
+}
+
+// recoverySliceKey identifies a slice of an index instance.
+type recoverySliceKey struct {
+	instId  common.IndexInstId
+	partnId common.PartitionId
+	sliceId SliceId
+}
+
+// recoveryPoints holds the recovery point manifests of keyspaces, loaded
+// from dir when first used.
+type recoveryPoints struct {
+	mu        sync.Mutex
+	dir       string
+	manifests map[string]*recoveryPointManifest
+}
+
+func newRecoveryPoints(dir string) *recoveryPoints {
+	return &recoveryPoints{
+		dir:       dir,
+		manifests: make(map[string]*recoveryPointManifest),
+	}
+}
+
+func (rp *recoveryPoints) path(streamId common.StreamId, keyspaceId string) string {
+	return filepath.Join(rp.dir, fmt.Sprintf("%v_%v.json", streamId, url.QueryEscape(keyspaceId)))
+}
+
+// get returns the manifest of keyspaceId in streamId. Caller holds rp.mu.
+func (rp *recoveryPoints) get(streamId common.StreamId, keyspaceId string) *recoveryPointManifest {
+	path := rp.path(streamId, keyspaceId)
+	if m, ok := rp.manifests[path]; ok {
+		return m
+	}
+
+	m := &recoveryPointManifest{StreamId: streamId, KeyspaceId: keyspaceId}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, m); err != nil {
+			storageMgrLog.Errorf("RecoveryPoints::get Discarding invalid manifest %v: %v", path, err)
+			m.Points = nil
+		}
+	} else if !os.IsNotExist(err) {
+		storageMgrLog.Errorf("RecoveryPoints::get Error reading manifest %v: %v", path, err)
+	}
+	rp.manifests[path] = m
+	return m
+}
+
+// persist writes m, replacing the previous manifest atomically. Caller
+// holds rp.mu.
+func (rp *recoveryPoints) persist(m *recoveryPointManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(rp.dir, 0755); err != nil {
+		return err
+	}
+
+	path := rp.path(m.StreamId, m.KeyspaceId)
+	tmpPath := path + ".tmp"
+	if err := common.WriteFileWithSync(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// add records ts as the latest recovery point of keyspaceId in streamId,
+// keeping at most maxPoints.
+func (rp *recoveryPoints) add(streamId common.StreamId, keyspaceId string,
+	ts *common.TsVbuuid, maxPoints int) error {
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	m := rp.get(streamId, keyspaceId)
+	m.Points = append(m.Points, ts.Copy())
+	if len(m.Points) > maxPoints {
+		m.Points = append([]*common.TsVbuuid(nil), m.Points[len(m.Points)-maxPoints:]...)
+	}
+	return rp.persist(m)
+}
+
+// truncate drops the recovery points of keyspaceId in streamId which are
+// more recent than ts, or all of them if ts is nil.
+func (rp *recoveryPoints) truncate(streamId common.StreamId, keyspaceId string,
+	ts *common.TsVbuuid) error {
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	m := rp.get(streamId, keyspaceId)
+	n := 0
+	if ts != nil {
+		for n < len(m.Points) && len(staleVbuckets(m.Points[n], ts)) == 0 {
+			n++
+		}
+	}
+	if n == len(m.Points) {
+		return nil
+	}
+	m.Points = m.Points[:n]
+	return rp.persist(m)
+}
+
+// find returns the latest recovery point of keyspaceId in streamId which is
+// not more recent than rollbackTs and which every slice in slices has a
+// snapshot of, with these snapshots. With a rollback to zero requested for
+// some vbucket, recovery points already used by a rollback are skipped
+// instead, as for slices rolling back on their own.
+func (rp *recoveryPoints) find(streamId common.StreamId, keyspaceId string,
+	rollbackTs *common.TsVbuuid, slices map[recoverySliceKey]Slice) (
+	*common.TsVbuuid, map[recoverySliceKey]SnapshotInfo) {
+
+	rp.mu.Lock()
+	points := append([]*common.TsVbuuid(nil), rp.get(streamId, keyspaceId).Points...)
+	rp.mu.Unlock()
+
+	snapInfos := make(map[recoverySliceKey][]SnapshotInfo, len(slices))
+	for key, slice := range slices {
+		infos, err := slice.GetSnapshots()
+		if err != nil {
+			return nil, nil
+		}
+		snapInfos[key] = infos
+	}
+
+	for i := len(points) - 1; i >= 0; i-- {
+		point := points[i]
+		if !isRecoveryPointUsable(point, rollbackTs, slices) {
+			continue
+		}
+
+		found := make(map[recoverySliceKey]SnapshotInfo, len(slices))
+		for key, infos := range snapInfos {
+			for _, info := range infos {
+				if ts := info.Timestamp(); ts != nil && !info.IsOSOSnap() && ts.Equal2(point, false) {
+					found[key] = info
+					break
+				}
+			}
+			if _, ok := found[key]; !ok {
+				break
+			}
+		}
+		if len(found) == len(slices) {
+			return point, found
+		}
+	}
+	return nil, nil
+}
+
+// isRecoveryPointUsable returns whether slices can roll back to point for
+// rollbackTs.
+func isRecoveryPointUsable(point, rollbackTs *common.TsVbuuid,
+	slices map[recoverySliceKey]Slice) bool {
+
+	if !rollbackTs.HasZeroSeqNum() {
+		return len(staleVbuckets(point, rollbackTs)) == 0
+	}
+
+	for _, slice := range slices {
+		if last := slice.LastRollbackTs(); last != nil && len(staleVbuckets(last, point)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// keyspaceSlices returns the slices of the indexes of keyspaceId in streamId.
+func (s *storageMgr) keyspaceSlices(streamId common.StreamId, keyspaceId string,
+	indexInstMap common.IndexInstMap, indexPartnMap IndexPartnMap) map[recoverySliceKey]Slice {
+
+	slices := make(map[recoverySliceKey]Slice)
+	for idxInstId, partnMap := range indexPartnMap {
+		idxInst, ok := indexInstMap[idxInstId]
+		if !ok || idxInst.Defn.KeyspaceId(idxInst.Stream) != keyspaceId ||
+			idxInst.Stream != streamId ||
+			idxInst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		for partnId, partnInst := range partnMap {
+			for _, slice := range partnInst.Sc.GetAllSlices() {
+				slices[recoverySliceKey{idxInstId, partnId, slice.Id()}] = slice
+			}
+		}
+	}
+	return slices
+}
+
+// recordRecoveryPoint records ts as a recovery point of keyspaceId in
+// streamId if the latest snapshot of every slice of the keyspace is at ts.
+func (s *storageMgr) recordRecoveryPoint(streamId common.StreamId, keyspaceId string,
+	ts *common.TsVbuuid, indexInstMap common.IndexInstMap, indexPartnMap IndexPartnMap,
+	maxPoints int) {
+
+	slices := s.keyspaceSlices(streamId, keyspaceId, indexInstMap, indexPartnMap)
+	if len(slices) == 0 {
+		return
+	}
+
+	for key, slice := range slices {
+		infos, err := slice.GetSnapshots()
+		if err != nil {
+			storageMgrLog.Errorf("StorageMgr::recordRecoveryPoint %v %v Error reading snapshots "+
+				"of %v: %v", streamId, keyspaceId, key.instId, err)
+			return
+		}
+		latest := NewSnapshotInfoContainer(infos).GetLatest()
+		if latest == nil || !latest.Timestamp().Equal2(ts, false) {
+			storageMgrLog.Infof("StorageMgr::recordRecoveryPoint %v %v Skipped. Index %v "+
+				"PartitionId %v SliceId %v has no snapshot at the flush timestamp", streamId,
+				keyspaceId, key.instId, key.partnId, key.sliceId)
+			return
+		}
+	}
+
+	if err := s.recoveryPoints.add(streamId, keyspaceId, ts, maxPoints); err != nil {
+		storageMgrLog.Errorf("StorageMgr::recordRecoveryPoint %v %v Error persisting "+
+			"recovery point: %v", streamId, keyspaceId, err)
+	}
+}
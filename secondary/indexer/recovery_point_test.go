@@ -0,0 +1,79 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+type testRecoverySlice struct {
+	Slice
+	infos        []SnapshotInfo
+	lastRollback *common.TsVbuuid
+}
+
+func (s *testRecoverySlice) GetSnapshots() ([]SnapshotInfo, error) { return s.infos, nil }
+func (s *testRecoverySlice) LastRollbackTs() *common.TsVbuuid      { return s.lastRollback }
+
+func TestRecoveryPoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recovery_points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyspaceId := "default:scope:collection"
+	points := []*common.TsVbuuid{
+		newTestRollbackTs(10, 10),
+		newTestRollbackTs(20, 20),
+		newTestRollbackTs(30, 30),
+	}
+
+	rp := newRecoveryPoints(dir)
+	for _, ts := range points {
+		if err := rp.add(common.MAINT_STREAM, keyspaceId, ts, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reloaded from disk, keeping the latest points
+	rp = newRecoveryPoints(dir)
+	m := rp.get(common.MAINT_STREAM, keyspaceId)
+	if len(m.Points) != 2 || !m.Points[0].Equal2(points[1], false) {
+		t.Fatalf("expected the 2 latest points, got %v", m.Points)
+	}
+
+	// Slice 2 has no snapshot of the latest point
+	slices := map[recoverySliceKey]Slice{
+		{1, 0, 0}: &testRecoverySlice{infos: []SnapshotInfo{
+			&testSnapshotInfo{points[2]}, &testSnapshotInfo{points[1]}}},
+		{2, 0, 0}: &testRecoverySlice{infos: []SnapshotInfo{
+			&testSnapshotInfo{newTestRollbackTs(25, 25)}, &testSnapshotInfo{points[1]}}},
+	}
+
+	point, snaps := rp.find(common.MAINT_STREAM, keyspaceId, newTestRollbackTs(40, 40), slices)
+	if !point.Equal2(points[1], false) || len(snaps) != 2 {
+		t.Fatalf("expected the second point, got %v", point)
+	}
+
+	if point, _ := rp.find(common.MAINT_STREAM, keyspaceId, newTestRollbackTs(15, 40), slices); point != nil {
+		t.Fatalf("expected no point older than the rollback, got %v", point)
+	}
+
+	if err := rp.truncate(common.MAINT_STREAM, keyspaceId, newTestRollbackTs(25, 25)); err != nil {
+		t.Fatal(err)
+	}
+	if m := newRecoveryPoints(dir).get(common.MAINT_STREAM, keyspaceId); len(m.Points) != 1 {
+		t.Fatalf("expected 1 point after truncate, got %v", m.Points)
+	}
+}
@@ -0,0 +1,619 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/logging/systemevent"
+	"github.com/couchbase/indexing/secondary/security"
+)
+
+// Replicas of an index are built independently on each node, so a replica
+// missing or holding stale entries goes unnoticed until queries served by
+// different replicas disagree. With replica.check.interval set, each node
+// periodically compares the partitions of replica 0 of its indexes with the
+// other replicas. A partition is summarised by its number of entries, an
+// order independent checksum of the entries and a sample of entry hashes.
+// The other replicas are asked, with /internal/replicaSummary, for their
+// summaries at the timestamp of the local snapshot, and skip the check if
+// they do not reach it within replica.check.waitTimeout. Replicas whose
+// summaries differ are reported in stats and with a system event.
+//
+// Within a DCP snapshot, mutations of a document can be deduplicated, so
+// replicas receiving the snapshots of a vbucket split differently, like from
+// disk and from memory, can hold different documents at the same seqno.
+// Replicas are therefore only compared at snapshots whose seqnos are all at
+// the end of a DCP snapshot on both sides.
+
+const (
+	replicaCheckMaxSamples    = 1024
+	replicaCheckRetryInterval = 10 * time.Millisecond
+	replicaCheckDisabledPoll  = time.Minute
+	replicaCheckHttpTimeout   = 10 * time.Minute
+)
+
+// ReplicaSummary summarises the entries of a partition of an index replica.
+type ReplicaSummary struct {
+	InstId          common.IndexInstId `json:"instId"`
+	ReplicaId       int                `json:"replicaId"`
+	PartnId         common.PartitionId `json:"partitionId"`
+	SnapshotMatched bool               `json:"snapshotMatched"`
+	NumEntries      uint64             `json:"numEntries"`
+	Checksum        uint64             `json:"checksum"`
+	Samples         []uint64           `json:"samples,omitempty"` // sorted
+}
+
+// replicaSummaryReq asks for the summaries of partitions of an index, at the
+// snapshot with timestamp seqnos, vbuuids.
+type replicaSummaryReq struct {
+	DefnId      common.IndexDefnId   `json:"defnId"`
+	PartnIds    []common.PartitionId `json:"partitionIds"`
+	Seqnos      []uint64             `json:"seqnos"`
+	Vbuuids     []uint64             `json:"vbuuids"`
+	SampleRate  uint64               `json:"sampleRate"`
+	WaitTimeout int64                `json:"waitTimeout"` // ms
+}
+
+// replicaSummarizer builds the summary of a partition from its entries. The
+// hashes of entries divisible by sampleRate are sampled, keeping the lowest
+// replicaCheckMaxSamples, so that replicas sample the same entries.
+type replicaSummarizer struct {
+	sampleRate uint64
+	h          hash.Hash64
+
+	numEntries uint64
+	checksum   uint64
+	samples    []uint64
+	bound      uint64 // highest sample kept, once samples are truncated
+	truncated  bool
+}
+
+func newReplicaSummarizer(sampleRate uint64) *replicaSummarizer {
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	return &replicaSummarizer{
+		sampleRate: sampleRate,
+		h:          fnv.New64a(),
+	}
+}
+
+func (rs *replicaSummarizer) add(entry []byte) error {
+	rs.h.Reset()
+	rs.h.Write(entry)
+	h := rs.h.Sum64()
+
+	rs.numEntries++
+	rs.checksum += h
+
+	if h%rs.sampleRate != 0 || (rs.truncated && h > rs.bound) {
+		return nil
+	}
+
+	rs.samples = append(rs.samples, h)
+	if len(rs.samples) >= 2*replicaCheckMaxSamples {
+		rs.truncate()
+	}
+	return nil
+}
+
+func (rs *replicaSummarizer) truncate() {
+	sort.Slice(rs.samples, func(i, j int) bool { return rs.samples[i] < rs.samples[j] })
+	if len(rs.samples) > replicaCheckMaxSamples {
+		rs.samples = rs.samples[:replicaCheckMaxSamples]
+		rs.bound = rs.samples[replicaCheckMaxSamples-1]
+		rs.truncated = true
+	}
+}
+
+func (rs *replicaSummarizer) summary(inst *common.IndexInst,
+	partnId common.PartitionId) *ReplicaSummary {
+
+	rs.truncate()
+	return &ReplicaSummary{
+		InstId:          inst.InstId,
+		ReplicaId:       inst.ReplicaId,
+		PartnId:         partnId,
+		SnapshotMatched: true,
+		NumEntries:      rs.numEntries,
+		Checksum:        rs.checksum,
+		Samples:         rs.samples,
+	}
+}
+
+// diffSamples returns the number of samples in only one of a and b. Samples
+// above the highest sample of a truncated side are not comparable.
+func diffSamples(a, b []uint64) int {
+	bound := ^uint64(0)
+	if len(a) >= replicaCheckMaxSamples && a[len(a)-1] < bound {
+		bound = a[len(a)-1]
+	}
+	if len(b) >= replicaCheckMaxSamples && b[len(b)-1] < bound {
+		bound = b[len(b)-1]
+	}
+
+	diff := 0
+	i, j := 0, 0
+	for i < len(a) && a[i] <= bound || j < len(b) && b[j] <= bound {
+		switch {
+		case j >= len(b) || b[j] > bound || i < len(a) && a[i] < b[j]:
+			i++
+			diff++
+		case i >= len(a) || a[i] > bound || b[j] < a[i]:
+			j++
+			diff++
+		default:
+			i++
+			j++
+		}
+	}
+	return diff
+}
+
+// replicasDiverged compares the summaries of a partition on two replicas,
+// returning the number of differing samples. It returns false if they match
+// or the summaries are not of the same snapshot.
+func replicasDiverged(local, peer *ReplicaSummary) (int, bool) {
+	if !local.SnapshotMatched || !peer.SnapshotMatched {
+		return 0, false
+	}
+	if local.NumEntries == peer.NumEntries && local.Checksum == peer.Checksum {
+		return 0, false
+	}
+	return diffSamples(local.Samples, peer.Samples), true
+}
+
+// summarizeSnapshot returns the summaries of the partitions of snapshot is of
+// inst, of partnIds only if not nil.
+func (s *scanCoordinator) summarizeSnapshot(inst *common.IndexInst, is IndexSnapshot,
+	partnIds map[common.PartitionId]bool, sampleRate uint64,
+	donech chan bool) ([]*ReplicaSummary, error) {
+
+	partns := is.Partitions()
+	ids := make([]common.PartitionId, 0, len(partns))
+	for partnId := range partns {
+		if partnIds == nil || partnIds[partnId] {
+			ids = append(ids, partnId)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	summaries := make([]*ReplicaSummary, 0, len(ids))
+	for _, partnId := range ids {
+		rs := newReplicaSummarizer(sampleRate)
+		for _, ss := range partns[partnId].Slices() {
+			if err := s.iterateSlice(inst.InstId, partnId, ss, rs.add, donech); err != nil {
+				return nil, err
+			}
+		}
+		summaries = append(summaries, rs.summary(inst, partnId))
+	}
+	return summaries, nil
+}
+
+// replicaSnapshotMatches returns whether a snapshot with timestamp snapTs
+// can be compared at ts: it is at the end of the DCP snapshots of all the
+// vbuckets and, if ts is not nil, has the seqnos of ts.
+func replicaSnapshotMatches(ts, snapTs *common.TsVbuuid) bool {
+	if snapTs == nil || !snapTs.CheckSnapAligned() {
+		return false
+	}
+	return ts == nil || ts.Equal2(snapTs, false)
+}
+
+// waitForReplicaSnapshot returns a snapshot of instId matching ts, or the
+// latest snapshot and false if there is none before deadline.
+func (s *scanCoordinator) waitForReplicaSnapshot(instId common.IndexInstId,
+	ts *common.TsVbuuid, deadline time.Time, donech chan bool) (IndexSnapshot, bool) {
+
+	for {
+		var is IndexSnapshot
+		if sc, ok := s.lastSnapshot.Get()[instId]; ok && sc != nil {
+			is = sc.cloneSnapshot()
+		}
+		if is != nil && replicaSnapshotMatches(ts, is.Timestamp()) {
+			return is, true
+		}
+		if time.Now().After(deadline) {
+			return is, false
+		}
+		if is != nil {
+			DestroyIndexSnapshot(is)
+		}
+
+		select {
+		case <-donech:
+			return nil, false
+		case <-time.After(replicaCheckRetryInterval):
+		}
+	}
+}
+
+// handleReplicaSummaryReq returns the summaries of the partitions of an
+// index on this node, for a replica on another node to compare with.
+func (s *scanCoordinator) handleReplicaSummaryReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.settings!write"}, r, w,
+		"ScanCoordinator::handleReplicaSummaryReq") {
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	var req replicaSummaryReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if len(req.Seqnos) == 0 || len(req.Seqnos) != len(req.Vbuuids) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid timestamp\n"))
+		return
+	}
+
+	insts, err := s.findScannableInsts([]common.IndexDefnId{req.DefnId})
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	partnIds := make(map[common.PartitionId]bool)
+	for _, partnId := range req.PartnIds {
+		partnIds[partnId] = true
+	}
+
+	donech := make(chan bool)
+	finch := make(chan bool)
+	defer close(finch)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			close(donech)
+		case <-finch:
+		}
+	}()
+
+	summaries, err := s.summarizeReplicas(insts, partnIds, &req, donech)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	data, err := json.Marshal(summaries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *scanCoordinator) summarizeReplicas(insts map[common.IndexInstId]common.IndexInst,
+	partnIds map[common.PartitionId]bool, req *replicaSummaryReq,
+	donech chan bool) ([]*ReplicaSummary, error) {
+
+	instIds := make([]common.IndexInstId, 0, len(insts))
+	for instId := range insts {
+		instIds = append(instIds, instId)
+	}
+	sort.Slice(instIds, func(i, j int) bool { return instIds[i] < instIds[j] })
+
+	deadline := time.Now().Add(time.Duration(req.WaitTimeout) * time.Millisecond)
+	summaries := make([]*ReplicaSummary, 0)
+	for _, instId := range instIds {
+		inst := insts[instId]
+		ts := &common.TsVbuuid{
+			Bucket:  inst.Defn.Bucket,
+			Seqnos:  req.Seqnos,
+			Vbuuids: req.Vbuuids,
+		}
+
+		is, matched := s.waitForReplicaSnapshot(instId, ts, deadline, donech)
+		if is == nil {
+			continue
+		}
+
+		err := func() error {
+			defer DestroyIndexSnapshot(is)
+
+			if matched {
+				ss, err := s.summarizeSnapshot(&inst, is, partnIds, req.SampleRate, donech)
+				if err != nil {
+					return err
+				}
+				summaries = append(summaries, ss...)
+				return nil
+			}
+
+			for partnId := range is.Partitions() {
+				if partnIds[partnId] {
+					summaries = append(summaries, &ReplicaSummary{
+						InstId:    instId,
+						ReplicaId: inst.ReplicaId,
+						PartnId:   partnId,
+					})
+				}
+			}
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return summaries, nil
+}
+
+// runReplicaChecker checks the replicas of indexes every
+// replica.check.interval, until stopch is closed.
+func (s *scanCoordinator) runReplicaChecker(stopch chan bool) {
+	for {
+		wait := time.Duration(s.config.Load()["replica.check.interval"].Uint64()) * time.Second
+		if wait == 0 {
+			wait = replicaCheckDisabledPoll
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-stopch:
+			return
+		}
+
+		if s.config.Load()["replica.check.interval"].Uint64() == 0 || s.isBootstrapMode() {
+			continue
+		}
+		s.checkReplicas(stopch)
+	}
+}
+
+// findReplicasToCheck returns the instances this node checks: the active
+// replica 0 of indexes with replicas.
+func (s *scanCoordinator) findReplicasToCheck() []common.IndexInst {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	insts := make([]common.IndexInst, 0)
+	for _, inst := range s.indexInstMap {
+		if inst.ReplicaId != 0 || inst.Defn.NumReplica == 0 ||
+			inst.State != common.INDEX_STATE_ACTIVE || inst.RState != common.REBAL_ACTIVE {
+			continue
+		}
+		insts = append(insts, inst)
+	}
+	sort.Slice(insts, func(i, j int) bool { return insts[i].InstId < insts[j].InstId })
+	return insts
+}
+
+// getReplicaCheckPeers returns the http addresses of the other index nodes.
+func getReplicaCheckPeers(clusterAddr string) ([]string, error) {
+	cinfo, err := common.FetchNewClusterInfoCache(clusterAddr, common.DEFAULT_POOL, "replicaChecker")
+	if err != nil {
+		return nil, err
+	}
+
+	localUUID := cinfo.GetLocalNodeUUID()
+	peers := make([]string, 0)
+	for _, nid := range cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE) {
+		if cinfo.GetNodeUUID(nid) == localUUID {
+			continue
+		}
+		addr, err := cinfo.GetServiceAddress(nid, common.INDEX_HTTP_SERVICE, true)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, addr)
+	}
+	return peers, nil
+}
+
+func (s *scanCoordinator) checkReplicas(donech chan bool) {
+	insts := s.findReplicasToCheck()
+	if len(insts) == 0 {
+		return
+	}
+
+	peers, err := getReplicaCheckPeers(s.config.Load()["clusterAddr"].String())
+	if err != nil {
+		scanLog.Warnf("%v checkReplicas: Unable to get index nodes: %v", s.logPrefix, err)
+		return
+	}
+	if len(peers) == 0 {
+		return
+	}
+
+	for i := range insts {
+		select {
+		case <-donech:
+			return
+		default:
+		}
+
+		if err := s.checkReplica(&insts[i], peers, donech); err != nil {
+			scanLog.Warnf("%v checkReplicas: Check of index %v:%v failed: %v", s.logPrefix,
+				insts[i].InstId, logging.TagUD(insts[i].DisplayName()), err)
+		}
+	}
+}
+
+// checkReplica compares the partitions of inst on this node with the other
+// replicas on peers.
+func (s *scanCoordinator) checkReplica(inst *common.IndexInst, peers []string,
+	donech chan bool) error {
+
+	cfg := s.config.Load()
+	sampleRate := uint64(cfg["replica.check.sampleRate"].Int())
+	waitTimeout := time.Duration(cfg["replica.check.waitTimeout"].Int()) * time.Millisecond
+
+	var stats *IndexStats
+	if st := s.stats.Get(); st != nil {
+		stats = st.indexes[inst.InstId]
+	}
+
+	is, matched := s.waitForReplicaSnapshot(inst.InstId, nil, time.Now().Add(waitTimeout), donech)
+	if is == nil {
+		return ErrSnapNotAvailable
+	}
+	defer DestroyIndexSnapshot(is)
+
+	if !matched {
+		// No snapshot at the end of DCP snapshots to compare at
+		if stats != nil {
+			stats.numReplicaChecksSkipped.Add(1)
+		}
+		return nil
+	}
+	ts := is.Timestamp()
+
+	req := &replicaSummaryReq{
+		DefnId:      inst.Defn.DefnId,
+		Seqnos:      ts.Seqnos,
+		Vbuuids:     ts.Vbuuids,
+		SampleRate:  sampleRate,
+		WaitTimeout: int64(cfg["replica.check.waitTimeout"].Int()),
+	}
+	for partnId := range is.Partitions() {
+		req.PartnIds = append(req.PartnIds, partnId)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	// Peers summarise their replicas while the local one is summarised
+	var wg sync.WaitGroup
+	peerSummaries := make([][]*ReplicaSummary, len(peers))
+	peerErrs := make([]error, len(peers))
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			peerSummaries[i], peerErrs[i] = getReplicaSummaries(peer, body)
+		}(i, peer)
+	}
+
+	local, err := s.summarizeSnapshot(inst, is, nil, sampleRate, donech)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	for i, peer := range peers {
+		if peerErrs[i] != nil {
+			scanLog.Warnf("%v checkReplica: Unable to get summaries of index %v:%v from %v: %v",
+				s.logPrefix, inst.InstId, logging.TagUD(inst.DisplayName()), peer, peerErrs[i])
+			continue
+		}
+
+		for _, ls := range local {
+			for _, ps := range peerSummaries[i] {
+				if ps.PartnId != ls.PartnId || ps.ReplicaId == ls.ReplicaId {
+					continue
+				}
+
+				if !ps.SnapshotMatched {
+					if stats != nil {
+						stats.numReplicaChecksSkipped.Add(1)
+					}
+					continue
+				}
+				if stats != nil {
+					stats.numReplicaChecks.Add(1)
+				}
+
+				numDiff, diverged := replicasDiverged(ls, ps)
+				if !diverged {
+					continue
+				}
+				if stats != nil {
+					stats.numReplicaDivergences.Add(1)
+				}
+				s.reportReplicaDivergence(inst, ls, ps, peer, numDiff)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *scanCoordinator) reportReplicaDivergence(inst *common.IndexInst,
+	local, peer *ReplicaSummary, peerAddr string, numDiff int) {
+
+	scanLog.Warnf("%v Replica %v of index %v:%v partition %v has %v entries, checksum %v. "+
+		"Replica %v (instance %v) on %v has %v entries, checksum %v. %v sampled entries differ.",
+		s.logPrefix, local.ReplicaId, inst.Defn.DefnId, logging.TagUD(inst.Defn.Name),
+		local.PartnId, local.NumEntries, local.Checksum, peer.ReplicaId, peer.InstId,
+		peerAddr, peer.NumEntries, peer.Checksum, numDiff)
+
+	ev := systemevent.NewReplicaDivergenceEvent("ScanCoordinator:checkReplica",
+		inst.Defn.DefnId, inst.InstId, uint64(local.ReplicaId), uint64(local.PartnId),
+		peer.InstId, uint64(peer.ReplicaId), peerAddr, local.NumEntries,
+		peer.NumEntries, numDiff)
+	systemevent.WarnEvent("Indexer", systemevent.EVENTID_INDEX_REPLICA_DIVERGED, ev)
+}
+
+// getReplicaSummaries requests summaries from the index node at addr. No
+// summaries are returned if the node has no replica of the index.
+func getReplicaSummaries(addr string, body []byte) ([]*ReplicaSummary, error) {
+	params := &security.RequestParams{Timeout: replicaCheckHttpTimeout}
+	resp, err := security.PostWithAuth(addr+"/internal/replicaSummary", "application/json",
+		bytes.NewBuffer(body), params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("HTTP status (%v) %s", resp.Status, data)
+	}
+
+	var summaries []*ReplicaSummary
+	if err := json.Unmarshal(data, &summaries); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func summarizeTestEntries(inst *common.IndexInst, entries []string,
+	sampleRate uint64) *ReplicaSummary {
+
+	rs := newReplicaSummarizer(sampleRate)
+	for _, e := range entries {
+		rs.add([]byte(e))
+	}
+	return rs.summary(inst, 1)
+}
+
+func TestReplicaSummary(t *testing.T) {
+	entries := make([]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		entries = append(entries, fmt.Sprintf("entry-%v", i))
+	}
+	reversed := make([]string, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		reversed = append(reversed, entries[i])
+	}
+
+	r0 := &common.IndexInst{InstId: 10, ReplicaId: 0}
+	r1 := &common.IndexInst{InstId: 11, ReplicaId: 1}
+
+	// Summaries do not depend on the order of entries
+	a := summarizeTestEntries(r0, entries, 1)
+	b := summarizeTestEntries(r1, reversed, 1)
+	if _, diverged := replicasDiverged(a, b); diverged {
+		t.Fatalf("expected replicas with the same entries to match")
+	}
+	if len(a.Samples) != replicaCheckMaxSamples {
+		t.Fatalf("expected %v samples, got %v", replicaCheckMaxSamples, len(a.Samples))
+	}
+
+	// A missing entry is found if sampled
+	missing := append(append([]string{}, entries[:5000]...), entries[5001:]...)
+	b = summarizeTestEntries(r1, missing, 1)
+	numDiff, diverged := replicasDiverged(a, b)
+	if !diverged || b.NumEntries != a.NumEntries-1 {
+		t.Fatalf("expected replicas to diverge, got %v entries", b.NumEntries)
+	}
+	if numDiff > 1 {
+		t.Fatalf("expected at most 1 differing sample, got %v", numDiff)
+	}
+
+	// A changed entry keeps the count, but not the checksum
+	changed := append([]string{}, entries...)
+	changed[42] = "entry-changed"
+	b = summarizeTestEntries(r1, changed, 2)
+	a = summarizeTestEntries(r0, entries, 2)
+	if _, diverged := replicasDiverged(a, b); !diverged || a.NumEntries != b.NumEntries {
+		t.Fatalf("expected replicas with a changed entry to diverge")
+	}
+
+	// Summaries of different snapshots are not compared
+	b.SnapshotMatched = false
+	if _, diverged := replicasDiverged(a, b); diverged {
+		t.Fatalf("expected summaries of different snapshots not to be compared")
+	}
+}
+
+func TestDiffSamples(t *testing.T) {
+	if n := diffSamples([]uint64{1, 3, 5}, []uint64{1, 4, 5, 6}); n != 3 {
+		t.Fatalf("expected 3 differing samples, got %v", n)
+	}
+	if n := diffSamples(nil, []uint64{2}); n != 1 {
+		t.Fatalf("expected 1 differing sample, got %v", n)
+	}
+
+	// Samples above the highest sample of a truncated side are not compared
+	full := make([]uint64, replicaCheckMaxSamples)
+	for i := range full {
+		full[i] = uint64(2 * i)
+	}
+	other := append(append([]uint64{1}, full[1:]...), 1<<40)
+	if n := diffSamples(full, other); n != 2 {
+		t.Fatalf("expected 2 differing samples, got %v", n)
+	}
+}
+
+func TestReplicaSnapshotMatches(t *testing.T) {
+	newTs := func(seqnos []uint64, snapEnds []uint64) *common.TsVbuuid {
+		ts := common.NewTsVbuuid("default", len(seqnos))
+		for i := range seqnos {
+			ts.Seqnos[i] = seqnos[i]
+			ts.Vbuuids[i] = 1234
+			ts.Snapshots[i] = [2]uint64{0, snapEnds[i]}
+		}
+		return ts
+	}
+
+	aligned := newTs([]uint64{10, 20}, []uint64{10, 20})
+	if !replicaSnapshotMatches(nil, aligned) {
+		t.Errorf("expected snapshot at the end of DCP snapshots to match")
+	}
+	if !replicaSnapshotMatches(aligned, newTs([]uint64{10, 20}, []uint64{10, 20})) {
+		t.Errorf("expected snapshot with the same seqnos to match")
+	}
+
+	// Same seqnos, in the middle of a DCP snapshot of the replica
+	if replicaSnapshotMatches(aligned, newTs([]uint64{10, 20}, []uint64{10, 30})) {
+		t.Errorf("expected snapshot in the middle of a DCP snapshot not to match")
+	}
+	if replicaSnapshotMatches(nil, newTs([]uint64{10, 20}, []uint64{15, 20})) {
+		t.Errorf("expected snapshot in the middle of a DCP snapshot not to match")
+	}
+	if replicaSnapshotMatches(aligned, newTs([]uint64{10, 25}, []uint64{10, 25})) {
+		t.Errorf("expected snapshot with other seqnos not to match")
+	}
+	if replicaSnapshotMatches(nil, nil) {
+		t.Errorf("expected snapshot without timestamp not to match")
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+// When a node is failed over, the replicas it hosted are lost until the next
+// rebalance. With replica.repair.enabled, the DDL service manager of one
+// indexer node, the one with the lowest node UUID, periodically looks for
+// indexes with fewer replicas than their replica count and rebuilds the
+// missing ones on other nodes, as ALTER INDEX would when adding replicas.
+// A replica is only rebuilt once it has been missing for
+// replica.repair.delay, leaving time for the failed node to be recovered,
+// within replica.repair.window if set, and with at most
+// replica.repair.maxConcurrent indexes being repaired at a time. Progress
+// is reported by /replicaRepairStatus.
+
+const (
+	replicaRepairDisabledPoll = time.Minute
+
+	replicaRepairPending   = "pending"
+	replicaRepairRepairing = "repairing"
+	replicaRepairFailed    = "failed"
+)
+
+// replicaRepairIndex is the repair state of an index with missing replicas.
+type replicaRepairIndex struct {
+	DefnId       common.IndexDefnId `json:"defnId"`
+	Bucket       string             `json:"bucket"`
+	Scope        string             `json:"scope"`
+	Collection   string             `json:"collection"`
+	Name         string             `json:"name"`
+	NumReplica   int                `json:"numReplica"`
+	LiveReplicas int                `json:"liveReplicas"`
+	MissingSince int64              `json:"missingSince"` // unix nano
+	Status       string             `json:"status"`
+	Attempts     int                `json:"attempts"`
+	LastError    string             `json:"lastError,omitempty"`
+}
+
+// replicaRepairStatus is the response of /replicaRepairStatus.
+type replicaRepairStatus struct {
+	Enabled     bool                  `json:"enabled"`
+	LastRun     int64                 `json:"lastRun"` // unix nano
+	NumRepairs  int64                 `json:"numRepairs"`
+	NumFailures int64                 `json:"numFailures"`
+	Indexes     []*replicaRepairIndex `json:"indexes"`
+}
+
+// replicaRepairer tracks the indexes with missing replicas across passes.
+type replicaRepairer struct {
+	mu          sync.Mutex
+	indexes     map[common.IndexDefnId]*replicaRepairIndex
+	lastRun     int64
+	numRepairs  int64
+	numFailures int64
+}
+
+func newReplicaRepairer() *replicaRepairer {
+	return &replicaRepairer{indexes: make(map[common.IndexDefnId]*replicaRepairIndex)}
+}
+
+// replicaHealth is the replica state of an index seen in a pass.
+type replicaHealth struct {
+	meta     *client.IndexMetadata
+	live     int
+	building bool // a replica is being built
+}
+
+// checkReplicas returns the replica state of the indexes, keyed by the
+// indexes which are missing replicas or have replicas being built.
+func checkReplicas(indexes []*client.IndexMetadata) map[common.IndexDefnId]*replicaHealth {
+
+	result := make(map[common.IndexDefnId]*replicaHealth)
+	for _, meta := range indexes {
+		if meta == nil || meta.Definition == nil || meta.Scheduled ||
+			meta.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		health := &replicaHealth{meta: meta, live: meta.NumLiveReplicas()}
+		for _, inst := range meta.Instances {
+			if inst.State == common.INDEX_STATE_INITIAL || inst.State == common.INDEX_STATE_CATCHUP {
+				health.building = true
+			}
+		}
+
+		if health.live < meta.Definition.GetNumReplica()+1 || health.building {
+			result[meta.Definition.DefnId] = health
+		}
+	}
+	return result
+}
+
+// update records the replica state of a pass at now. Indexes no longer
+// missing replicas are dropped, once their repaired replicas are built.
+func (r *replicaRepairer) update(health map[common.IndexDefnId]*replicaHealth, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastRun = now.UnixNano()
+
+	for defnId, idx := range r.indexes {
+		h, ok := health[defnId]
+		if !ok || (idx.Status != replicaRepairRepairing && h.live >= idx.NumReplica+1) {
+			delete(r.indexes, defnId)
+		}
+	}
+
+	for defnId, h := range health {
+		defn := h.meta.Definition
+		idx, ok := r.indexes[defnId]
+		if !ok {
+			if h.live >= defn.GetNumReplica()+1 {
+				continue
+			}
+			idx = &replicaRepairIndex{
+				DefnId:       defnId,
+				Bucket:       defn.Bucket,
+				Scope:        defn.Scope,
+				Collection:   defn.Collection,
+				Name:         defn.Name,
+				MissingSince: now.UnixNano(),
+				Status:       replicaRepairPending,
+			}
+			r.indexes[defnId] = idx
+		}
+		idx.NumReplica = defn.GetNumReplica()
+		idx.LiveReplicas = h.live
+
+		if idx.Status == replicaRepairRepairing && !h.building {
+			if h.live >= idx.NumReplica+1 {
+				delete(r.indexes, defnId)
+			} else {
+				// Repair did not rebuild all replicas
+				idx.Status = replicaRepairPending
+			}
+		}
+	}
+}
+
+// candidates returns the indexes to repair at now, the longest missing
+// replicas first, keeping at most maxConcurrent indexes being repaired.
+func (r *replicaRepairer) candidates(now time.Time, delay time.Duration,
+	maxConcurrent int) []common.IndexDefnId {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pending []*replicaRepairIndex
+	repairing := 0
+	for _, idx := range r.indexes {
+		if idx.Status == replicaRepairRepairing {
+			repairing++
+		} else if now.Sub(time.Unix(0, idx.MissingSince)) >= delay {
+			pending = append(pending, idx)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].MissingSince != pending[j].MissingSince {
+			return pending[i].MissingSince < pending[j].MissingSince
+		}
+		return pending[i].DefnId < pending[j].DefnId
+	})
+
+	var result []common.IndexDefnId
+	for _, idx := range pending {
+		if repairing+len(result) >= maxConcurrent {
+			break
+		}
+		result = append(result, idx.DefnId)
+	}
+	return result
+}
+
+// repaired records the outcome of repairing defnId.
+func (r *replicaRepairer) repaired(defnId common.IndexDefnId, numRepaired int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, ok := r.indexes[defnId]
+	if !ok {
+		return
+	}
+
+	idx.Attempts++
+	if err != nil {
+		idx.Status = replicaRepairFailed
+		idx.LastError = err.Error()
+		r.numFailures++
+		return
+	}
+
+	idx.LastError = ""
+	if numRepaired > 0 {
+		idx.Status = replicaRepairRepairing
+		r.numRepairs++
+	}
+}
+
+func (r *replicaRepairer) status(enabled bool) *replicaRepairStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := &replicaRepairStatus{
+		Enabled:     enabled,
+		LastRun:     r.lastRun,
+		NumRepairs:  r.numRepairs,
+		NumFailures: r.numFailures,
+		Indexes:     make([]*replicaRepairIndex, 0, len(r.indexes)),
+	}
+	for _, idx := range r.indexes {
+		idxCopy := *idx
+		status.Indexes = append(status.Indexes, &idxCopy)
+	}
+	sort.Slice(status.Indexes, func(i, j int) bool {
+		return status.Indexes[i].DefnId < status.Indexes[j].DefnId
+	})
+	return status
+}
+
+// inReplicaRepairWindow returns whether now is within window, given as
+// "HH:MM-HH:MM" in local time and possibly wrapping around midnight. An
+// empty window allows repairs at any time.
+func inReplicaRepairWindow(window string, now time.Time) (bool, error) {
+
+	window = strings.TrimSpace(window)
+	if window == "" {
+		return true, nil
+	}
+
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return false, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", window)
+	}
+
+	var bounds [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return false, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", window)
+		}
+		bounds[i] = t.Hour()*60 + t.Minute()
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	if bounds[0] <= bounds[1] {
+		return minute >= bounds[0] && minute < bounds[1], nil
+	}
+	return minute >= bounds[0] || minute < bounds[1], nil
+}
+
+// runReplicaRepair runs in a goroutine that periodically repairs missing
+// replicas, until the DDL service manager is shut down.
+func (m *DDLServiceMgr) runReplicaRepair() {
+	const method = "DDLServiceMgr::runReplicaRepair:" // for logging
+
+	for {
+		config := m.config.Load()
+
+		wait := replicaRepairDisabledPoll
+		if config["replica.repair.enabled"].Bool() {
+			m.repairReplicas(config)
+			wait = time.Duration(config["replica.repair.interval"].Int()) * time.Second
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-m.killch:
+			logging.Infof("%v Shutting down", method)
+			return
+		}
+	}
+}
+
+// repairReplicas does one pass of repairing missing replicas.
+func (m *DDLServiceMgr) repairReplicas(config common.Config) {
+	const method = "DDLServiceMgr::repairReplicas:" // for logging
+
+	if !m.canProcessDDL() {
+		logging.Debugf("%v Skipped during rebalance", method)
+		return
+	}
+
+	leader, err := m.isReplicaRepairLeader()
+	if err != nil {
+		logging.Errorf("%v Error finding indexer nodes: %v", method, err)
+		return
+	}
+	if !leader {
+		return
+	}
+
+	provider, _, err := newMetadataProvider(m.clusterAddr, nil, m.settings, method)
+	if err != nil {
+		logging.Errorf("%v Failed to start metadata provider: %v", method, err)
+		return
+	}
+	defer provider.Close()
+
+	now := time.Now()
+	indexes, _ := provider.ListIndex()
+	m.replicaRepairer.update(checkReplicas(indexes), now)
+
+	window := config["replica.repair.window"].String()
+	if ok, err := inReplicaRepairWindow(window, now); err != nil {
+		logging.Errorf("%v %v", method, err)
+		return
+	} else if !ok {
+		return
+	}
+
+	delay := time.Duration(config["replica.repair.delay"].Int()) * time.Second
+	maxConcurrent := config["replica.repair.maxConcurrent"].Int()
+	for _, defnId := range m.replicaRepairer.candidates(now, delay, maxConcurrent) {
+		if !m.canProcessDDL() {
+			return
+		}
+
+		// Leave indexes being dropped or altered alone
+		if exist, err := mc.DeleteCommandTokenExist(defnId); err != nil || exist {
+			continue
+		}
+		if exist, err := mc.CreateCommandTokenExist(defnId); err != nil || exist {
+			continue
+		}
+
+		numRepaired, err := provider.RepairReplica(defnId)
+		if err != nil {
+			logging.Errorf("%v Failed to repair replicas of index %v: %v", method, defnId, err)
+		} else if numRepaired > 0 {
+			logging.Infof("%v Repairing %v replicas of index %v", method, numRepaired, defnId)
+		}
+		m.replicaRepairer.repaired(defnId, numRepaired, err)
+	}
+}
+
+// isReplicaRepairLeader returns whether this node has the lowest node UUID
+// of the indexer nodes in the cluster, failed over nodes excluded.
+func (m *DDLServiceMgr) isReplicaRepairLeader() (bool, error) {
+
+	url, err := common.ClusterAuthUrl(m.clusterAddr)
+	if err != nil {
+		return false, err
+	}
+
+	cinfo, err := common.NewClusterInfoCache(url, DEFAULT_POOL)
+	if err != nil {
+		return false, err
+	}
+	cinfo.SetUserAgent("DDLServiceMgr::isReplicaRepairLeader")
+
+	if err := cinfo.FetchNodesAndSvsInfo(); err != nil {
+		return false, err
+	}
+
+	for _, nid := range cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE) {
+		if nodeUUID := cinfo.GetNodeUUID(nid); nodeUUID != "" && nodeUUID < string(m.nodeID) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// handleReplicaRepairStatus responds to /replicaRepairStatus with the
+// indexes with missing replicas and the progress of their repair.
+func (m *DDLServiceMgr) handleReplicaRepairStatus(w http.ResponseWriter, r *http.Request) {
+
+	if !m.validateAuth(w, r) {
+		logging.Errorf("DDLServiceMgr::handleReplicaRepairStatus Validation Failure req: %v", common.GetHTTPReqInfo(r))
+		return
+	}
+
+	if r.Method != "GET" {
+		sendHttpError(w, "Unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	enabled := m.config.Load()["replica.repair.enabled"].Bool()
+	send(http.StatusOK, w, m.replicaRepairer.status(enabled))
+}
@@ -0,0 +1,119 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func newTestReplicaMeta(defnId common.IndexDefnId, numReplica int,
+	states ...common.IndexState) *client.IndexMetadata {
+
+	meta := &client.IndexMetadata{
+		Definition: &common.IndexDefn{DefnId: defnId, NumReplica: uint32(numReplica)},
+		State:      common.INDEX_STATE_ACTIVE,
+	}
+	for i, state := range states {
+		meta.Instances = append(meta.Instances, &client.InstanceDefn{
+			DefnId:        defnId,
+			InstId:        common.IndexInstId(i + 1),
+			State:         state,
+			ReplicaId:     uint64(i),
+			NumPartitions: 1,
+			IndexerId:     map[common.PartitionId]common.IndexerId{0: "indexer"},
+		})
+	}
+	return meta
+}
+
+func TestInReplicaRepairWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 1, 1, hour, min, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		window   string
+		now      time.Time
+		expected bool
+	}{
+		{"", at(12, 0), true},
+		{"02:00-06:00", at(3, 30), true},
+		{"02:00-06:00", at(6, 0), false},
+		{"22:00-04:00", at(23, 0), true},
+		{"22:00-04:00", at(1, 0), true},
+		{"22:00-04:00", at(12, 0), false},
+	}
+
+	for i, test := range tests {
+		ok, err := inReplicaRepairWindow(test.window, test.now)
+		if err != nil || ok != test.expected {
+			t.Fatalf("test %v: expected %v, got %v %v", i, test.expected, ok, err)
+		}
+	}
+
+	if _, err := inReplicaRepairWindow("2am-6am", at(3, 0)); err == nil {
+		t.Fatalf("expected an error for an invalid window")
+	}
+}
+
+func TestReplicaRepairer(t *testing.T) {
+	now := time.Now()
+	r := newReplicaRepairer()
+
+	indexes := []*client.IndexMetadata{
+		newTestReplicaMeta(1, 1, common.INDEX_STATE_ACTIVE, common.INDEX_STATE_ACTIVE),
+		newTestReplicaMeta(2, 2, common.INDEX_STATE_ACTIVE),
+		newTestReplicaMeta(3, 1, common.INDEX_STATE_ACTIVE),
+	}
+	r.update(checkReplicas(indexes), now)
+	r.update(checkReplicas(indexes[:2]), now.Add(time.Minute))
+
+	if candidates := r.candidates(now.Add(time.Minute), 2*time.Minute, 2); len(candidates) != 0 {
+		t.Fatalf("expected no candidates before the delay, got %v", candidates)
+	}
+	candidates := r.candidates(now.Add(3*time.Minute), 2*time.Minute, 1)
+	if !reflect.DeepEqual(candidates, []common.IndexDefnId{2}) {
+		t.Fatalf("expected candidates [2], got %v", candidates)
+	}
+
+	r.repaired(2, 2, nil)
+	if candidates := r.candidates(now.Add(3*time.Minute), 2*time.Minute, 1); len(candidates) != 0 {
+		t.Fatalf("expected no candidates while repairing, got %v", candidates)
+	}
+
+	// Replicas being built
+	indexes[1] = newTestReplicaMeta(2, 2, common.INDEX_STATE_ACTIVE,
+		common.INDEX_STATE_INITIAL, common.INDEX_STATE_INITIAL)
+	r.update(checkReplicas(indexes[:2]), now.Add(4*time.Minute))
+	if status := r.status(true); len(status.Indexes) != 1 || status.Indexes[0].Status != replicaRepairRepairing {
+		t.Fatalf("expected index 2 repairing, got %+v", status.Indexes)
+	}
+
+	indexes[1] = newTestReplicaMeta(2, 2, common.INDEX_STATE_ACTIVE,
+		common.INDEX_STATE_ACTIVE, common.INDEX_STATE_ACTIVE)
+	r.update(checkReplicas(indexes[:2]), now.Add(5*time.Minute))
+	if status := r.status(true); len(status.Indexes) != 0 || status.NumRepairs != 1 {
+		t.Fatalf("expected index 2 repaired, got %+v", status)
+	}
+
+	// Failed repairs are retried
+	indexes[0] = newTestReplicaMeta(1, 1, common.INDEX_STATE_ACTIVE)
+	r.update(checkReplicas(indexes[:2]), now.Add(6*time.Minute))
+	r.repaired(1, 0, errors.New("no nodes"))
+	candidates = r.candidates(now.Add(9*time.Minute), 2*time.Minute, 1)
+	if !reflect.DeepEqual(candidates, []common.IndexDefnId{1}) {
+		t.Fatalf("expected candidates [1], got %v", candidates)
+	}
+}
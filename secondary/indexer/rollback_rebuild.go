@@ -0,0 +1,203 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"hash/crc32"
+	"sort"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// When no snapshot of a slice is old enough for a rollback, the slice is
+// rolled back to zero and the whole index is rebuilt, even if only a few
+// vbuckets, e.g. those of a failed over node, need to go back that far.
+// Instead, if rollback.maxRebuildVbucketsPercent is set, which it is not
+// by default, and these vbuckets are at most this percentage of the
+// bucket, each slice is rolled back to the latest snapshot which is
+// old enough for all other vbuckets, the entries of docs in the rebuilt
+// vbuckets are deleted and the stream restarts these vbuckets from zero.
+//
+// The vbucket of an entry is the hash of its docid, as computed by KV, so
+// entries need not be tagged with their vbucket. The index stays online for
+// scans of the other vbuckets. Deleted entries are visible to scans until
+// the next snapshot, like any other mutation. If the indexer restarts before
+// the next snapshot, the deletes are lost with the rollback, and the next
+// rollback rebuilds the same vbuckets again.
+
+// docidVbucket returns the vbucket of docid in a bucket of numVbs vbuckets.
+func docidVbucket(docid []byte, numVbs int) Vbucket {
+	return Vbucket((crc32.ChecksumIEEE(docid) >> 16) & 0x7fff & uint32(numVbs-1))
+}
+
+// staleVbuckets returns the vbuckets which are more recent in ts than in
+// rollbackTs.
+func staleVbuckets(ts, rollbackTs *common.TsVbuuid) []Vbucket {
+	var vbs []Vbucket
+	for vb, seqno := range ts.Seqnos {
+		if vb < len(rollbackTs.Seqnos) && seqno > rollbackTs.Seqnos[vb] {
+			vbs = append(vbs, Vbucket(vb))
+		}
+	}
+	return vbs
+}
+
+// findRebuildSnapshot returns the latest snapshot of infos, ordered from the
+// latest, whose stale vbuckets are all in vbs.
+func findRebuildSnapshot(infos []SnapshotInfo, rollbackTs *common.TsVbuuid,
+	vbs map[Vbucket]bool) SnapshotInfo {
+
+	for _, info := range infos {
+		ts := info.Timestamp()
+		if ts == nil || info.IsOSOSnap() {
+			continue
+		}
+
+		found := true
+		for _, vb := range staleVbuckets(ts, rollbackTs) {
+			if !vbs[vb] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return info
+		}
+	}
+	return nil
+}
+
+// findRebuildVbuckets returns the vbuckets to rebuild, for the slices of
+// keyspaceId with no snapshot old enough for rollbackTs to rollback to the
+// latest snapshot with few enough stale vbuckets. It returns nil if there
+// is no such slice, or too many vbuckets would need to be rebuilt.
+func (sm *storageMgr) findRebuildVbuckets(streamId common.StreamId, keyspaceId string,
+	rollbackTs *common.TsVbuuid) map[Vbucket]bool {
+
+	maxVbs := len(rollbackTs.Seqnos) * sm.config["rollback.maxRebuildVbucketsPercent"].Int() / 100
+	if maxVbs <= 0 {
+		return nil
+	}
+
+	vbs := make(map[Vbucket]bool)
+
+	indexInstMap := sm.indexInstMap.Get()
+	for idxInstId, partnMap := range sm.indexPartnMap.Get() {
+		idxInst := indexInstMap[idxInstId]
+		if idxInst.Defn.KeyspaceId(idxInst.Stream) != keyspaceId ||
+			idxInst.Stream != streamId ||
+			idxInst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		for _, partnInst := range partnMap {
+			for _, slice := range partnInst.Sc.GetAllSlices() {
+				if sm.findRollbackSnapshot(slice, rollbackTs) != nil {
+					continue
+				}
+
+				infos, err := slice.GetSnapshots()
+				if err != nil {
+					return nil
+				}
+
+				found := false
+				for _, info := range NewSnapshotInfoContainer(infos).List() {
+					if info.IsOSOSnap() {
+						return nil
+					}
+					if info.Timestamp() == nil {
+						continue
+					}
+					stale := staleVbuckets(info.Timestamp(), rollbackTs)
+					if len(stale) <= maxVbs {
+						for _, vb := range stale {
+							vbs[vb] = true
+						}
+						found = true
+						break
+					}
+				}
+
+				if !found || len(vbs) > maxVbs {
+					return nil
+				}
+			}
+		}
+	}
+
+	if len(vbs) == 0 {
+		return nil
+	}
+	return vbs
+}
+
+// purgeVbuckets deletes the entries of docs in vbs from slice, as of
+// snapshot info, and returns the number of docs deleted.
+func (sm *storageMgr) purgeVbuckets(slice Slice, info SnapshotInfo, isPrimary bool,
+	keyspaceId string, vbs map[Vbucket]bool) (int, error) {
+
+	numVbs := len(info.Timestamp().Seqnos)
+	docids := make(map[string]Vbucket)
+
+	err := func() error {
+		snap, err := slice.OpenSnapshot(info)
+		if err != nil {
+			return err
+		}
+		defer snap.Close()
+
+		ctx := slice.GetReaderContext()
+		ctx.Init(make(chan bool))
+		defer ctx.Done()
+
+		var buf []byte
+		return snap.All(ctx, func(entry []byte) error {
+			docid := entry
+			if !isPrimary {
+				var err error
+				if buf, err = secondaryIndexEntry(entry).ReadDocId(buf[:0]); err != nil {
+					return err
+				}
+				docid = buf
+			}
+			if vb := docidVbucket(docid, numVbs); vbs[vb] {
+				docids[string(docid)] = vb
+			}
+			return nil
+		})
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	// Deletes are queued to the writer of their vbucket, ahead of the
+	// mutations of the restarted stream
+	for docid, vb := range docids {
+		meta := NewMutationMeta()
+		meta.keyspaceId = keyspaceId
+		meta.vbucket = vb
+		err := slice.Delete([]byte(docid), meta)
+		meta.Free()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(docids), nil
+}
+
+// sortedVbuckets returns vbs in ascending order, for logging.
+func sortedVbuckets(vbs map[Vbucket]bool) []Vbucket {
+	list := make([]Vbucket, 0, len(vbs))
+	for vb := range vbs {
+		list = append(list, vb)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
@@ -0,0 +1,70 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+type testSnapshotInfo struct {
+	ts *common.TsVbuuid
+}
+
+func (info *testSnapshotInfo) Timestamp() *common.TsVbuuid   { return info.ts }
+func (info *testSnapshotInfo) IsCommitted() bool             { return true }
+func (info *testSnapshotInfo) IsOSOSnap() bool               { return false }
+func (info *testSnapshotInfo) Stats() map[string]interface{} { return nil }
+
+func newTestRollbackTs(seqnos ...uint64) *common.TsVbuuid {
+	ts := common.NewTsVbuuid("default", len(seqnos))
+	copy(ts.Seqnos, seqnos)
+	return ts
+}
+
+func TestDocidVbucket(t *testing.T) {
+	// As hashed by KV
+	expected := map[string]Vbucket{"foo": 115, "user::1": 997, "airline_10": 361}
+	for docid, vb := range expected {
+		if got := docidVbucket([]byte(docid), 1024); got != vb {
+			t.Fatalf("expected vbucket %v for %v, got %v", vb, docid, got)
+		}
+	}
+	if got := docidVbucket([]byte("foo"), 64); got != 51 {
+		t.Fatalf("expected vbucket 51 for foo with 64 vbuckets, got %v", got)
+	}
+}
+
+func TestFindRebuildSnapshot(t *testing.T) {
+	rollbackTs := newTestRollbackTs(10, 0, 10, 0)
+
+	stale := staleVbuckets(newTestRollbackTs(5, 3, 20, 0), rollbackTs)
+	if !reflect.DeepEqual(stale, []Vbucket{1, 2}) {
+		t.Fatalf("expected stale vbuckets [1 2], got %v", stale)
+	}
+
+	// Snapshots from the latest
+	infos := []SnapshotInfo{
+		&testSnapshotInfo{newTestRollbackTs(20, 8, 20, 0)},
+		&testSnapshotInfo{newTestRollbackTs(8, 8, 20, 0)},
+		&testSnapshotInfo{newTestRollbackTs(8, 5, 10, 0)},
+	}
+
+	if info := findRebuildSnapshot(infos, rollbackTs, map[Vbucket]bool{1: true}); info != infos[2] {
+		t.Fatalf("expected the oldest snapshot, got %v", info)
+	}
+	if info := findRebuildSnapshot(infos, rollbackTs, map[Vbucket]bool{1: true, 2: true}); info != infos[1] {
+		t.Fatalf("expected the second snapshot, got %v", info)
+	}
+	if info := findRebuildSnapshot(infos, rollbackTs, map[Vbucket]bool{3: true}); info != nil {
+		t.Fatalf("expected no snapshot, got %v", info)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+)
+
+// The source of a scan pipeline projects and aggregates rows with
+// temporaries (encoded aggregate values and group keys, lists of keys to
+// join) that are dropped as soon as the row is written to the pipeline.
+// Allocating them on the heap for every row puts the GC under pressure on
+// large scans. Instead, the scan worker takes a scanArena of scan.arena_size
+// bytes from a pool, allocates the temporaries of a row from it, and resets
+// it for the next row. Temporaries that do not fit in the arena are
+// allocated on the heap.
+//
+// A nil *scanArena allocates everything on the heap, so helpers shared with
+// callers outside the scan pipeline take a nil arena.
+
+type scanArena struct {
+	buf        []byte
+	off        int
+	keys       [][]byte
+	heapAllocs int
+}
+
+var scanArenaPool sync.Pool
+
+// getScanArena returns an arena of size bytes, or nil if size is 0.
+func getScanArena(size int) *scanArena {
+	if size <= 0 {
+		return nil
+	}
+
+	// Arenas of a previous size are dropped after a config change
+	if a, ok := scanArenaPool.Get().(*scanArena); ok && len(a.buf) == size {
+		a.reset()
+		a.heapAllocs = 0
+		return a
+	}
+	return &scanArena{buf: make([]byte, size)}
+}
+
+func putScanArena(a *scanArena) {
+	if a != nil {
+		scanArenaPool.Put(a)
+	}
+}
+
+// alloc returns an empty slice of capacity n, from the arena if it fits.
+// Appending beyond n reallocates on the heap, so a slice never overwrites
+// the next one.
+func (a *scanArena) alloc(n int) []byte {
+	if a == nil {
+		return make([]byte, 0, n)
+	}
+	if n > len(a.buf)-a.off {
+		a.heapAllocs++
+		return make([]byte, 0, n)
+	}
+
+	b := a.buf[a.off : a.off : a.off+n]
+	a.off += n
+	return b
+}
+
+// keyList returns an empty list of capacity n for the keys of a row. There
+// is a single list per arena, reused for every row.
+func (a *scanArena) keyList(n int) [][]byte {
+	if a == nil {
+		return make([][]byte, 0, n)
+	}
+	if cap(a.keys) < n {
+		a.keys = make([][]byte, 0, n)
+	}
+	return a.keys[:0]
+}
+
+// reset frees the temporaries of the previous row.
+func (a *scanArena) reset() {
+	if a != nil {
+		a.off = 0
+	}
+}
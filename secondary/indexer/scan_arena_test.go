@@ -0,0 +1,93 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestScanArena(t *testing.T) {
+	a := getScanArena(64)
+
+	b1 := append(a.alloc(16), "0123456789abcdef"...)
+	b2 := append(a.alloc(16), "ghijklmnopqrstuv"...)
+	if string(b1) != "0123456789abcdef" || string(b2) != "ghijklmnopqrstuv" {
+		t.Fatalf("expected independent slices, got %s and %s", b1, b2)
+	}
+	if a.off != 32 || a.heapAllocs != 0 {
+		t.Fatalf("expected 32 bytes used from the arena, got %v (%v heap)", a.off, a.heapAllocs)
+	}
+
+	// Appending beyond capacity does not overwrite the next slice
+	b1 = append(b1, 'x')
+	if string(b2) != "ghijklmnopqrstuv" {
+		t.Fatalf("expected b2 unchanged, got %s", b2)
+	}
+
+	if b := a.alloc(64); cap(b) != 64 || a.heapAllocs != 1 {
+		t.Fatalf("expected a heap allocation, got %v heap", a.heapAllocs)
+	}
+
+	a.reset()
+	if b := a.alloc(64); cap(b) != 64 || a.heapAllocs != 1 || a.off != 64 {
+		t.Fatalf("expected the arena to be reused after reset")
+	}
+
+	keys := a.keyList(4)
+	if len(keys) != 0 || cap(keys) < 4 {
+		t.Fatalf("expected an empty key list of capacity 4")
+	}
+	putScanArena(a)
+
+	var nilArena *scanArena
+	if b := nilArena.alloc(8); cap(b) != 8 {
+		t.Fatalf("expected a heap allocation from a nil arena")
+	}
+	nilArena.reset()
+	if getScanArena(0) != nil {
+		t.Fatalf("expected no arena for size 0")
+	}
+}
+
+// benchmarkRowTemporaries encodes the aggregates of a row, as
+// projectGroupAggr does, and reports the number of garbage collections.
+func benchmarkRowTemporaries(b *testing.B, arena *scanArena) {
+	vals := []interface{}{"sensor-0001", 12345.678, true, []interface{}{"a", 1}}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		arena.reset()
+		keys := arena.keyList(len(vals))
+		for _, v := range vals {
+			enc, err := encodeValue(v, arena)
+			if err != nil {
+				b.Fatal(err)
+			}
+			keys = append(keys, enc)
+		}
+	}
+
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.NumGC-before.NumGC), "gcs")
+}
+
+func BenchmarkRowTemporariesHeap(b *testing.B) {
+	benchmarkRowTemporaries(b, nil)
+}
+
+func BenchmarkRowTemporariesArena(b *testing.B) {
+	benchmarkRowTemporaries(b, getScanArena(64*1024))
+}
@@ -0,0 +1,192 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/audit"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/transport"
+)
+
+// Scan responses can be compressed, trading CPU for network between query
+// and index nodes across a slow network. A client offers the compressions
+// it supports in a HeloRequest on a connection, in order of preference, and
+// the first one allowed by queryport.compression compresses the row batches
+// of the scans on the connection from then on. Each batch is flagged with
+// its compression, so the client decompresses it without knowing what was
+// negotiated.
+//
+// Only snappy is supported. zstd compresses row batches better, but there is
+// no zstd codec in the tree to build the transport with, so it is left out
+// rather than adding a cgo dependency to the indexer and the query client.
+// Unknown compressions are ignored on both sides, so a codec can be added
+// later without changing the HeloRequest, nor breaking older clients and
+// indexers.
+//
+// The size of the row batches before and after compression is reported per
+// connection by /stats/connections.
+
+// unsupportedCompressions returns the names in the comma separated list of
+// compressions allowed that are not supported.
+func unsupportedCompressions(allowed string) []string {
+	var names []string
+	for _, name := range strings.Split(allowed, ",") {
+		if _, ok := transport.CompressionByName(name); !ok && strings.TrimSpace(name) != "" {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return names
+}
+
+func (s *scanCoordinator) warnUnsupportedCompressions(config common.Config) {
+	if names := unsupportedCompressions(config["queryport.compression"].String()); len(names) > 0 {
+		scanLog.Warnf("%v queryport.compression %v not supported, only none and snappy are",
+			s.logPrefix, names)
+	}
+}
+
+// negotiateCompression returns the first compression offered that is in the
+// comma separated list of compressions allowed.
+func negotiateCompression(offered []uint32, allowed string) byte {
+	names := strings.Split(allowed, ",")
+	for _, c := range offered {
+		for _, name := range names {
+			if compression, ok := transport.CompressionByName(name); ok && uint32(compression) == c {
+				return compression
+			}
+		}
+	}
+	return transport.CompressionNone
+}
+
+// scanConns tracks the connections scan responses are compressed on.
+type scanConns struct {
+	mu    sync.Mutex
+	conns map[*ConnectionContext]struct{}
+}
+
+func newScanConns() *scanConns {
+	return &scanConns{
+		conns: make(map[*ConnectionContext]struct{}),
+	}
+}
+
+func (c *scanConns) add(ctx *ConnectionContext) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[ctx] = struct{}{}
+}
+
+func (c *scanConns) remove(ctx *ConnectionContext) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, ctx)
+}
+
+type ConnectionCompressionStats struct {
+	Remote          string  `json:"remote"`
+	Compression     string  `json:"compression"`
+	Bytes           int64   `json:"bytes"`
+	CompressedBytes int64   `json:"compressed_bytes"`
+	Ratio           float64 `json:"compression_ratio"`
+}
+
+// stats returns the compression stats of the connections, by remote
+// address.
+func (c *scanConns) stats() []ConnectionCompressionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]ConnectionCompressionStats, 0, len(c.conns))
+	for ctx := range c.conns {
+		st := ConnectionCompressionStats{
+			Remote:          ctx.remote,
+			Compression:     transport.CompressionName(ctx.compression),
+			Bytes:           atomic.LoadInt64(&ctx.rowBytes),
+			CompressedBytes: atomic.LoadInt64(&ctx.zrowBytes),
+		}
+		if st.CompressedBytes > 0 {
+			st.Ratio = float64(st.Bytes) / float64(st.CompressedBytes)
+		}
+		stats = append(stats, st)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Remote < stats[j].Remote
+	})
+	return stats
+}
+
+// setCompression sets the compression of scan responses on the connection
+// from remote, tracked by conns if any.
+func (c *ConnectionContext) setCompression(compression byte, remote string, conns *scanConns) {
+	if c.conns != nil {
+		c.conns.remove(c)
+		c.conns = nil
+	}
+
+	c.compression = compression
+	c.remote = remote
+	if compression != transport.CompressionNone && conns != nil {
+		c.conns = conns
+		conns.add(c)
+	}
+}
+
+func (c *ConnectionContext) addRowBytes(size, zsize int) {
+	atomic.AddInt64(&c.rowBytes, int64(size))
+	atomic.AddInt64(&c.zrowBytes, int64(zsize))
+}
+
+// Close stops tracking the connection once it is closed.
+func (c *ConnectionContext) Close() {
+	if c.conns != nil {
+		c.conns.remove(c)
+	}
+}
+
+// handleConnectionsReq returns the compression stats of the connections
+// scan responses are compressed on.
+func (s *statsManager) handleConnectionsReq(w http.ResponseWriter, r *http.Request) {
+	_, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		audit.Audit(common.AUDIT_UNAUTHORIZED, r, "StatsManager::handleConnectionsReq", "")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	data, err := json.Marshal(s.stats.Get().scanConns.stats())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,66 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/transport"
+)
+
+func TestNegotiateCompression(t *testing.T) {
+	snappy := uint32(transport.CompressionSnappy)
+	gzip := uint32(transport.CompressionGzip)
+
+	if c := negotiateCompression([]uint32{gzip, snappy}, "none, snappy"); c != transport.CompressionSnappy {
+		t.Fatalf("expected snappy, got %v", c)
+	}
+	if c := negotiateCompression([]uint32{snappy}, "none"); c != transport.CompressionNone {
+		t.Fatalf("expected no compression when not allowed, got %v", c)
+	}
+	if c := negotiateCompression(nil, "snappy"); c != transport.CompressionNone {
+		t.Fatalf("expected no compression when not offered, got %v", c)
+	}
+	if c := negotiateCompression([]uint32{snappy}, "zstd, snappy"); c != transport.CompressionSnappy {
+		t.Fatalf("expected unsupported compressions allowed ignored, got %v", c)
+	}
+
+	if names := unsupportedCompressions("zstd, snappy,none,"); len(names) != 1 || names[0] != "zstd" {
+		t.Fatalf("expected zstd unsupported, got %v", names)
+	}
+}
+
+func TestScanConnsStats(t *testing.T) {
+	conns := newScanConns()
+	ctx := createConnectionContext().(*ConnectionContext)
+
+	ctx.setCompression(transport.CompressionSnappy, "10.0.0.1:5000", conns)
+	ctx.addRowBytes(1000, 250)
+	ctx.addRowBytes(1000, 250)
+
+	stats := conns.stats()
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 connection, got %v", stats)
+	}
+	if st := stats[0]; st.Remote != "10.0.0.1:5000" || st.Compression != "snappy" ||
+		st.Bytes != 2000 || st.CompressedBytes != 500 || st.Ratio != 4 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	// Connections are tracked only while compressed, until closed
+	ctx.setCompression(transport.CompressionNone, "10.0.0.1:5000", conns)
+	if stats = conns.stats(); len(stats) != 0 {
+		t.Fatalf("expected no connections, got %v", stats)
+	}
+	ctx.setCompression(transport.CompressionSnappy, "10.0.0.1:5000", conns)
+	ctx.Close()
+	if stats = conns.stats(); len(stats) != 0 {
+		t.Fatalf("expected no connections after close, got %v", stats)
+	}
+}
@@ -36,20 +36,24 @@ var (
 	ErrUnsupportedRequest = errors.New("Unsupported query request")
 	ErrVbuuidMismatch     = errors.New("Mismatch in session vbuuids")
 	ErrNotMyPartition     = errors.New("Not my partition")
+	ErrReplicaNotLocal    = errors.New("Index replica not on the node of the client")
 )
 
 const DECODE_ERR_THRESHOLD = 100
 
 var secKeyBufPool *common.BytesBufPool
 
+var scanLog = logging.GetComponentLogger(logging.ScanComponent)
+
 type ScanCoordinator interface {
+	RegisterRestEndpoints()
 }
 
 type scanCoordinator struct {
 	supvCmdch        MsgChannel //supervisor sends commands on this channel
 	supvMsgch        MsgChannel //channel to send any async message to supervisor
 	snapshotNotifych []chan IndexSnapshot
-	snapshotReqCh    []MsgChannel
+	snapshotReqs     *snapshotReqRouter
 	lastSnapshot     IndexSnapMapHolder
 	rollbackTimes    unsafe.Pointer
 
@@ -69,6 +73,18 @@ type scanCoordinator struct {
 	indexerState    atomic.Value
 	numDecodeErrors uint32       // Number of errors in collatejson decode.
 	cpuThrottle     *CpuThrottle // for Autofailover CPU throttling
+
+	profiles *scanProfileStore // profiles of scans requested with profile flag
+	limiter  *scanLimiter      // per user or bucket scan limits
+	pools    *scanPools        // per index or bucket scan pools
+
+	countCache *scanCountCache // results of count scans, if enabled
+
+	seqnosCache *seqnosCache // seqnos of session consistent scans, if enabled
+
+	sessions *scanSessionStore // open scan sessions
+
+	stopCh chan bool // closed on shutdown, to stop background tasks
 }
 
 // NewScanCoordinator returns an instance of scanCoordinator or err message
@@ -78,23 +94,31 @@ type scanCoordinator struct {
 // If supvCmdch get closed, ScanCoordinator will shut itself down.
 func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 	config common.Config, snapshotNotifych []chan IndexSnapshot,
-	snapshotReqCh []MsgChannel, stats *IndexerStats, cpuThrottle *CpuThrottle) (ScanCoordinator, Message) {
+	snapshotReqs *snapshotReqRouter, stats *IndexerStats, cpuThrottle *CpuThrottle) (ScanCoordinator, Message) {
 	var err error
 
 	s := &scanCoordinator{
 		supvCmdch:        supvCmdch,
 		supvMsgch:        supvMsgch,
 		snapshotNotifych: snapshotNotifych,
-		snapshotReqCh:    snapshotReqCh,
+		snapshotReqs:     snapshotReqs,
 		logPrefix:        "ScanCoordinator",
 		reqCounter:       0,
 		cpuThrottle:      cpuThrottle,
 		indexInstMap:     make(common.IndexInstMap),
 		indexPartnMap:    make(IndexPartnMap),
 		indexDefnMap:     make(map[common.IndexDefnId][]common.IndexInstId),
+		profiles:         newScanProfileStore(),
+		limiter:          newScanLimiter(),
+		pools:            newScanPools(),
+		countCache:       newScanCountCache(),
+		seqnosCache:      newSeqnosCache(),
+		sessions:         newScanSessionStore(),
+		stopCh:           make(chan bool),
 	}
 
 	s.config.Store(config)
+	s.warnUnsupportedCompressions(config)
 	s.initRollbackInProgress()
 	s.lastSnapshot.Init()
 
@@ -120,6 +144,9 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		go s.listenSnapshot(i)
 	}
 
+	go s.runScanSessionExpiry(s.stopCh)
+	go s.runReplicaChecker(s.stopCh)
+
 	// main loop
 	go s.run()
 
@@ -134,11 +161,11 @@ loop:
 		case cmd, ok := <-s.supvCmdch:
 			if ok {
 				if cmd.GetMsgType() == SCAN_COORD_SHUTDOWN {
-					logging.Infof("ScanCoordinator: Shutting Down")
+					scanLog.Infof("ScanCoordinator: Shutting Down")
 					s.serv.Close()
-					for i := 0; i < len(s.snapshotReqCh); i++ {
-						close(s.snapshotReqCh[i])
-					}
+					s.snapshotReqs.close()
+					close(s.stopCh)
+					s.sessions.CloseIf(func(*common.IndexInst) bool { return true })
 					s.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -157,6 +184,9 @@ func (s *scanCoordinator) listenSnapshot(index int) {
 
 			lastSnapshot := s.lastSnapshot.Get()
 
+			// Counts of earlier snapshots are not to be served anymore
+			s.countCache.Invalidate(ss.IndexInstId())
+
 			if snapContainer, ok := lastSnapshot[ss.IndexInstId()]; ok {
 				snapContainer.Lock()
 				defer snapContainer.Unlock()
@@ -226,7 +256,7 @@ func (s *scanCoordinator) handleSupvervisorCommands(cmd Message) {
 		s.handleSecurityChange(cmd)
 
 	default:
-		logging.Errorf("ScanCoordinator: Received Unknown Command %v", cmd)
+		scanLog.Errorf("ScanCoordinator: Received Unknown Command %v", cmd)
 		s.supvCmdch <- &MsgError{
 			err: Error{code: ERROR_SCAN_COORD_UNKNOWN_COMMAND,
 				severity: NORMAL,
@@ -259,22 +289,27 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	req, err := NewScanRequest(protoReq, ctx, cancelCh, s)
 	atime := time.Now()
 	w := NewProtoWriter(req.ScanType, conn)
+	w.SetConnectionContext(req.connCtx)
+	if req.GroupAggr != nil && req.GroupAggr.AllowPartialAggr {
+		w.SetGroupHints(!req.groupsComplete())
+	}
 	defer func() {
 		s.handleError(req.LogPrefix, w.Done())
+		s.finishProfile(req)
 		req.Done()
 	}()
 
 	if req.ScanType == HeloReq {
-		s.handleHeloRequest(req, w)
+		s.handleHeloRequest(req, w, conn)
 		return
 	}
 
-	logging.LazyVerbose(func() string {
+	scanLog.LazyVerbose(func() string {
 		return fmt.Sprintf("%s REQUEST %s", req.LogPrefix, logging.TagStrUD(req))
 	})
 
 	if req.Consistency != nil {
-		logging.LazyVerbose(func() string {
+		scanLog.LazyVerbose(func() string {
 			return fmt.Sprintf("%s requested timestamp: %s => %s Crc64 => %v", req.LogPrefix,
 				strings.ToLower(req.Consistency.String()), ScanTStoString(req.Ts), req.Ts.GetCrc64())
 		})
@@ -285,6 +320,15 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		return
 	}
 
+	if err == nil {
+		err = s.authorizeScan(req)
+	}
+
+	if err == nil && req.replicaPreference == protobuf.ReplicaPreference_ReplicaLocalOnly &&
+		!isLocalConn(conn) {
+		err = ErrReplicaNotLocal
+	}
+
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
@@ -298,6 +342,20 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		return
 	}
 
+	release, err := s.acquireScanLimit(req)
+	if err != nil {
+		s.tryRespondWithError(w, req, err)
+		return
+	}
+	defer release()
+
+	releasePool, err := s.acquireScanPool(req)
+	if err != nil {
+		s.tryRespondWithError(w, req, err)
+		return
+	}
+	defer releasePool()
+
 	if req.Stats != nil {
 		elapsed := time.Now().Sub(ttime).Nanoseconds()
 		req.Stats.scanReqInitDuration.Add(elapsed)
@@ -306,6 +364,10 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		now := time.Now().UnixNano()
 		req.Stats.numRequests.Add(1)
 		req.Stats.lastScanTime.Set(now)
+		if req.ScanType != EstimateReq {
+			// Estimates are planning probes, not uses of the index
+			req.Stats.recordScan(*req.Consistency, now)
+		}
 		if req.GroupAggr != nil {
 			req.Stats.numRequestsAggr.Add(1)
 		} else {
@@ -331,7 +393,7 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	t0 := time.Now()
 	is, err := s.getRequestedIndexSnapshot(req)
 	if err != nil {
-		logging.Infof("%s Error in getRequestedIndexSnapshot %v", req.LogPrefix, err)
+		scanLog.Infof("%s Error in getRequestedIndexSnapshot %v", req.LogPrefix, err)
 
 		if err == common.ErrScanTimedOut {
 			getSnapTs := func() *common.TsVbuuid {
@@ -360,7 +422,7 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	}
 	defer DestroyIndexSnapshot(is)
 
-	logging.LazyVerbose(func() string {
+	scanLog.LazyVerbose(func() string {
 		return fmt.Sprintf("%s snapshot timestamp: %s",
 			req.LogPrefix, ScanTStoString(is.Timestamp()))
 	})
@@ -370,6 +432,11 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 			elapsed := time.Now().Sub(ttime).Nanoseconds()
 			req.Stats.scanReqDuration.Add(elapsed)
 			req.Stats.scanReqLatDist.Add(elapsed)
+			req.Stats.scanLatencies.add(elapsed)
+			if req.ScanType == ScanReq || req.ScanType == ScanAllReq ||
+				req.ScanType == CountReq || req.ScanType == MultiScanCountReq {
+				req.Stats.recordScanShape(req.scanShape(), elapsed)
+			}
 		}
 	}()
 
@@ -456,18 +523,65 @@ func (s *scanCoordinator) processRequest(req *ScanRequest, w ScanResponseWriter,
 		s.handleStatsRequest(req, w, is)
 	case FastCountReq:
 		s.handleFastCountRequest(req, w, is, t0)
+	case EstimateReq:
+		s.handleEstimateRequest(req, w, is)
 	}
 }
 
-func (s *scanCoordinator) handleHeloRequest(req *ScanRequest, w ScanResponseWriter) {
+func (s *scanCoordinator) handleHeloRequest(req *ScanRequest, w ScanResponseWriter,
+	conn net.Conn) {
+
+	// A HeloRequest without compressions keeps the one negotiated
+	if len(req.compressions) > 0 {
+		allowed := s.config.Load()["queryport.compression"].String()
+		compression := negotiateCompression(req.compressions, allowed)
+		req.connCtx.setCompression(compression, conn.RemoteAddr().String(), s.stats.Get().scanConns)
+	}
+
 	err := w.Helo()
 	s.handleError(req.LogPrefix, err)
 }
 
+// isLocalConn tells if the client of conn is on the node of the indexer,
+// for scans asking for replicas on the node of the client only.
+func isLocalConn(conn net.Conn) bool {
+	if conn == nil || conn.RemoteAddr() == nil || conn.LocalAddr() == nil {
+		return false
+	}
+
+	remote, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	local, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return false
+	}
+
+	remoteIP := net.ParseIP(remote)
+	if remoteIP == nil {
+		return false
+	}
+	return remoteIP.IsLoopback() || remoteIP.Equal(net.ParseIP(local))
+}
+
 func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWriter,
 	is IndexSnapshot, t0 time.Time) {
 	waitTime := time.Now().Sub(t0)
 
+	if ts := is.Timestamp(); req.snapshotSeqnos && ts != nil {
+		if err := w.SnapshotSeqnos(ts); err != nil {
+			s.handleError(req.LogPrefix, err)
+			return
+		}
+	}
+	if ts := is.Timestamp(); req.snapshotId && ts != nil {
+		if err := w.SnapshotId(ts.SnapshotId()); err != nil {
+			s.handleError(req.LogPrefix, err)
+			return
+		}
+	}
+
 	scanPipeline := NewScanPipeline(req, w, is, s.config.Load())
 	cancelCb := NewCancelCallback(req, func(e error) {
 		scanPipeline.Cancel(e)
@@ -480,6 +594,7 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 
 	if req.Stats != nil {
 		req.Stats.numRowsReturned.Add(int64(scanPipeline.RowsReturned()))
+		req.Stats.usage.addRows(time.Now().UnixNano(), int64(scanPipeline.RowsReturned()))
 		req.Stats.scanBytesRead.Add(int64(scanPipeline.BytesRead()))
 		req.Stats.scanDuration.Add(scanTime.Nanoseconds())
 		req.Stats.scanWaitDuration.Add(waitTime.Nanoseconds())
@@ -497,9 +612,26 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 		}
 	}
 
+	if p := req.profile; p != nil {
+		p.SnapshotWaitTime = waitTime.Nanoseconds()
+		p.ActiveTime = scanPipeline.ActiveTime().Nanoseconds()
+		p.CPUTime = scanPipeline.CPUTime().Nanoseconds()
+		p.WriteTime = scanPipeline.WriteTime().Nanoseconds()
+		p.BytesRead = scanPipeline.BytesRead()
+		p.RowsScanned = scanPipeline.RowsScanned()
+		p.RowsReturned = scanPipeline.RowsReturned()
+		if p.RowsScanned > p.RowsReturned {
+			p.RowsFiltered = p.RowsScanned - p.RowsReturned
+		}
+		p.BufGrows = scanPipeline.BufGrows()
+		if err != nil {
+			p.Error = err.Error()
+		}
+	}
+
 	if err != nil {
 		status := fmt.Sprintf("(error = %s)", err)
-		logging.LazyVerbose(func() string {
+		scanLog.LazyVerbose(func() string {
 			return fmt.Sprintf("%s RESPONSE rows:%d, scanned:%d, waitTime:%v, totalTime:%v, status:%s, requestId:%s",
 				req.LogPrefix, scanPipeline.RowsReturned(), scanPipeline.RowsScanned(), waitTime, scanTime, status, req.RequestId)
 		})
@@ -509,14 +641,14 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 			if errCount > DECODE_ERR_THRESHOLD {
 				// Not sure if this is in-memory data corruption.
 				// It is safe to start afresh.
-				logging.Fatalf("Too many unexpected errors in scan decode. "+
+				scanLog.Fatalf("Too many unexpected errors in scan decode. "+
 					"Error count = %v. Indexer exiting ...", errCount)
 				os.Exit(1)
 			}
 		}
 	} else {
 		status := "ok"
-		logging.LazyVerbose(func() string {
+		scanLog.LazyVerbose(func() string {
 			return fmt.Sprintf("%s RESPONSE rows:%d, waitTime:%v, totalTime:%v, status:%s",
 				req.LogPrefix, scanPipeline.RowsReturned(), waitTime, scanTime, status)
 		})
@@ -537,15 +669,18 @@ func (s *scanCoordinator) handleCountRequest(req *ScanRequest, w ScanResponseWri
 	cancelCb.Run()
 	defer cancelCb.Done()
 
-	if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
-		rows, err = scatterCount(req, snapshots, stopch)
-	}
+	rows, err = s.cachedCount(req, is, func() (rows uint64, err error) {
+		if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
+			rows, err = scatterCount(req, snapshots, stopch)
+		}
+		return
+	})
 
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
 
-	logging.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
+	scanLog.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
 	err = w.Count(rows)
 	s.handleError(req.LogPrefix, err)
 }
@@ -564,29 +699,32 @@ func (s *scanCoordinator) handleMultiScanCountRequest(req *ScanRequest, w ScanRe
 	cancelCb.Run()
 	defer cancelCb.Done()
 
-	if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
-		previousRows := make([][]byte, len(snapshots))
-		for i := 0; i < len(previousRows); i++ {
-			buf := secKeyBufPool.Get()
-			req.keyBufList = append(req.keyBufList, buf)
-			previousRows[i] = (*buf)[:0]
-			req.Ctxs[i].SetCursorKey(&previousRows[i])
-		}
-		for _, scan := range req.Scans {
-			r, err1 := scatterMultiCount(req, scan, snapshots, previousRows, stopch)
-			if err1 != nil {
-				err = err1
-				break
+	rows, err = s.cachedCount(req, is, func() (rows uint64, err error) {
+		if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
+			previousRows := make([][]byte, len(snapshots))
+			for i := 0; i < len(previousRows); i++ {
+				buf := secKeyBufPool.Get()
+				req.keyBufList = append(req.keyBufList, buf)
+				previousRows[i] = (*buf)[:0]
+				req.Ctxs[i].SetCursorKey(&previousRows[i])
+			}
+			for _, scan := range req.Scans {
+				r, err1 := scatterMultiCount(req, scan, snapshots, previousRows, stopch)
+				if err1 != nil {
+					err = err1
+					break
+				}
+				rows += r
 			}
-			rows += r
 		}
-	}
+		return
+	})
 
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
 
-	logging.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
+	scanLog.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
 	err = w.Count(rows)
 	s.handleError(req.LogPrefix, err)
 }
@@ -605,27 +743,30 @@ func (s *scanCoordinator) handleFastCountRequest(req *ScanRequest, w ScanRespons
 	cancelCb.Run()
 	defer cancelCb.Done()
 
-	for _, scan := range req.Scans {
-		if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
-			r, err1 := scatterFastCount(req, scan, snapshots, stopch)
-			if err1 != nil {
-				err = err1
-				break
+	rows, err = s.cachedCount(req, is, func() (rows uint64, err error) {
+		for _, scan := range req.Scans {
+			if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
+				r, err1 := scatterFastCount(req, scan, snapshots, stopch)
+				if err1 != nil {
+					err = err1
+					break
+				}
+				rows += r
 			}
-			rows += r
 		}
-	}
+		return
+	})
 
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
 
-	logging.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
+	scanLog.Verbosef("%s RESPONSE count:%d status:ok", req.LogPrefix, rows)
 
 	var sk []byte
 	if req.dataEncFmt == common.DATA_ENC_COLLATEJSON {
 		result := []uint64{rows}
-		sk, err = encodeValue(result)
+		sk, err = encodeValue(result, nil)
 		if s.tryRespondWithError(w, req, err) {
 			return
 		}
@@ -664,7 +805,7 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 		return
 	}
 
-	logging.Verbosef("%s RESPONSE status:ok", req.LogPrefix)
+	scanLog.Verbosef("%s RESPONSE status:ok", req.LogPrefix)
 	err = w.Stats(rows, 0, nil, nil)
 	s.handleError(req.LogPrefix, err)
 }
@@ -685,6 +826,10 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 // This mechanism can be used to implement RYOW.
 func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexSnapshot, err error) {
 
+	if r.sessionId != "" {
+		return s.getScanSessionSnapshot(r)
+	}
+
 	snapshot, err := func() (IndexSnapshot, error) {
 
 		lastSnapshot := s.lastSnapshot.Get()
@@ -702,7 +847,7 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 
 			cfg := s.config.Load()
 			if !cfg["enable_session_consistency_strict"].Bool() || cons != common.SessionConsistency {
-				if isSnapshotConsistent(ss, cons, r.Ts) {
+				if isSnapshotConsistentVbnos(ss, cons, r.Ts, r.TsVbnos) {
 					return CloneIndexSnapshot(ss), nil
 				}
 				return nil, nil
@@ -729,7 +874,7 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 			}
 
 			seqnos, vbuuids, e := bucketSeqVbuuidsWithRetry(retries, s.logPrefix,
-				cluster, r.Bucket, cfg["numVbuckets"].Int())
+				cluster, r.Bucket, getNumVBuckets(r.Bucket, cfg))
 			if e != nil {
 				return nil, e
 			}
@@ -762,6 +907,7 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 	snapResch := make(chan interface{}, 1)
 	snapReqMsg := &MsgIndexSnapRequest{
 		ts:          r.Ts,
+		vbnos:       r.TsVbnos,
 		cons:        *r.Consistency,
 		respch:      snapResch,
 		idxInstId:   r.IndexInstId,
@@ -769,8 +915,7 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 	}
 
 	// Block wait until a ts is available for fullfilling the request
-	index := uint64(r.IndexInstId) % uint64(len(s.snapshotReqCh))
-	s.snapshotReqCh[int(index)] <- snapReqMsg
+	s.snapshotReqs.send(snapReqMsg)
 	var msg interface{}
 	select {
 	case msg = <-snapResch:
@@ -835,6 +980,19 @@ func isSnapshotConsistent(
 	return false
 }
 
+// isSnapshotConsistentVbnos is isSnapshotConsistent for a request TS of the
+// vbuckets vbnos. An at_plus scan of a few vbuckets compares those vbuckets
+// only, instead of all the vbuckets of the snapshot, so that the waiters of
+// targeted read-your-own-writes scans are cheap to check on each snapshot.
+func isSnapshotConsistentVbnos(ss IndexSnapshot, cons common.Consistency,
+	reqTs *common.TsVbuuid, vbnos []uint16) bool {
+
+	if cons != common.QueryConsistency || vbnos == nil {
+		return isSnapshotConsistent(ss, cons, reqTs)
+	}
+	return ss.Timestamp().AsRecentVbnos(reqTs, vbnos)
+}
+
 // Check whether snapshotTS is consistent with request TS
 // Also return if snapshotTS is ahead of request TS for any vb
 func isSnapshotConsistentOrAhead(ss IndexSnapshot, reqTs *common.TsVbuuid,
@@ -916,18 +1074,18 @@ func (s *scanCoordinator) isScanAllowed(c common.Consistency, scan *ScanRequest)
 
 	rollbackTimes := (*map[string]int64)(atomic.LoadPointer(&s.rollbackTimes))
 	if rollbackTimes == nil {
-		logging.Errorf("ScanCoordinator.isScanAllowed: rollback time not initialized")
+		scanLog.Errorf("ScanCoordinator.isScanAllowed: rollback time not initialized")
 		return ErrIndexRollbackOrBootstrap
 	}
 
 	rollbackTime, ok := (*rollbackTimes)[scan.Bucket]
 	if !ok {
-		logging.Errorf("ScanCoordinator.isScanAllowed: missing rollback time for bucket %v", scan.Bucket)
+		scanLog.Errorf("ScanCoordinator.isScanAllowed: missing rollback time for bucket %v", scan.Bucket)
 		return ErrIndexRollbackOrBootstrap
 	}
 
 	if scan.rollbackTime != rollbackTime {
-		logging.Errorf("ScanCoordinator.isScanAllowed: rollback time mismatch. Req %v indexer %v", scan.rollbackTime, rollbackTime)
+		scanLog.Errorf("ScanCoordinator.isScanAllowed: rollback time mismatch. Req %v indexer %v", scan.rollbackTime, rollbackTime)
 		return ErrIndexRollbackOrBootstrap
 	}
 
@@ -940,14 +1098,14 @@ func (s *scanCoordinator) respondWithError(conn net.Conn, req *ScanRequest, err
 	buf := p.GetBlock()
 	defer p.PutBlock(buf)
 
-	protoErr := &protobuf.Error{Error: proto.String(err.Error())}
+	protoErr := newProtoError(err)
 
 	switch req.ScanType {
 	case StatsReq:
 		res = &protobuf.StatisticsResponse{
 			Err: protoErr,
 		}
-	case CountReq:
+	case CountReq, EstimateReq:
 		res = &protobuf.CountResponse{
 			Count: proto.Int64(0), Err: protoErr,
 		}
@@ -968,12 +1126,44 @@ func (s *scanCoordinator) respondWithError(conn net.Conn, req *ScanRequest, err
 	}
 
 finish:
-	logging.Errorf("%s RESPONSE Failed with error (%s), requestId: %v", req.LogPrefix, err, req.RequestId)
+	scanLog.Errorf("%s RESPONSE Failed with error (%s), requestId: %v", req.LogPrefix, err, req.RequestId)
+}
+
+// authorizeScan checks that the user of the connection has n1ql.select
+// permission on the collection of the scanned index. Connections which
+// did not authenticate are only accepted in mixed version clusters, and
+// are not authorized per request.
+//
+// Only the clients connecting to the queryport as the user themselves,
+// like tools and the principals of queryport.auth, are authorized here.
+// The GSI client of a query node connects with the credentials of the
+// query service, which are allowed to scan any index, and the request
+// carries no identity of the end user. Their n1ql.select permission is
+// checked by the query service before it scans the index.
+func (s *scanCoordinator) authorizeScan(req *ScanRequest) error {
+	creds := req.connCtx.GetCreds()
+	if creds == nil {
+		return nil
+	}
+
+	permission := common.GetScanPermission(&req.IndexInst.Defn)
+	allowed, err := creds.IsAllowed(permission)
+	if err != nil {
+		scanLog.Errorf("%s authorizeScan: error checking permission %v: %v",
+			req.LogPrefix, permission, err)
+		return common.ErrScanNotAuthorized
+	}
+	if !allowed {
+		scanLog.Infof("%s authorizeScan: user %v lacks permission %v",
+			req.LogPrefix, logging.TagUD(creds.Name()), permission)
+		return common.ErrScanNotAuthorized
+	}
+	return nil
 }
 
 func (s *scanCoordinator) handleError(prefix string, err error) {
 	if err != nil {
-		logging.Errorf("%s Error occured %s", prefix, err)
+		scanLog.Errorf("%s Error occured %s", prefix, err)
 	}
 }
 
@@ -983,11 +1173,11 @@ func (s *scanCoordinator) tryRespondWithError(w ScanResponseWriter, req *ScanReq
 			stats := s.stats.Get()
 			stats.notFoundError.Add(1)
 		} else if err == common.ErrIndexerInBootstrap {
-			logging.Verbosef("%s REQUEST %s", req.LogPrefix, req)
-			logging.Verbosef("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
+			scanLog.Verbosef("%s REQUEST %s", req.LogPrefix, req)
+			scanLog.Verbosef("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
 		} else {
-			logging.Infof("%s REQUEST %s", req.LogPrefix, req)
-			logging.Infof("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
+			scanLog.Infof("%s REQUEST %s", req.LogPrefix, req)
+			scanLog.Infof("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
 		}
 		s.updateErrStats(req, err)
 		s.handleError(req.LogPrefix, w.Error(err))
@@ -1029,13 +1219,17 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 	stats := s.stats.Get()
 	st := s.serv.Statistics()
 	stats.numConnections.Set(st.Connections)
+	stats.numStreams.Set(st.Streams)
+	stats.numEvictedIdle.Set(st.EvictedIdle)
+	stats.numEvictedAged.Set(st.EvictedAged)
+	stats.numRejectedConns.Set(st.Rejected)
 
 	// Compute counts asynchronously and reply to stats request
 	go func() {
 		for id, idxStats := range stats.indexes {
 			err := s.updateItemsCount(id, idxStats)
 			if err != nil {
-				logging.Errorf("%v: Unable to compute index items_count for %v/%v/%v state %v (%v)", s.logPrefix,
+				scanLog.Errorf("%v: Unable to compute index items_count for %v/%v/%v state %v (%v)", s.logPrefix,
 					idxStats.bucket, idxStats.name, id, idxStats.indexState.Value(), err)
 			}
 
@@ -1050,7 +1244,7 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 					if idxStats.lastScanGatherTime.Value() != int64(0) {
 						scanRate := float64(numRowsScanned-partnStats.lastNumRowsScanned.Value()) / elapsed
 						partnStats.avgScanRate.Set(int64((scanRate + float64(partnStats.avgScanRate.Value())) / 2))
-						logging.Debugf("scanCoordinator.handleStats: index %v partition %v numRowsScanned %v scan rate %v avg scan rate %v",
+						scanLog.Debugf("scanCoordinator.handleStats: index %v partition %v numRowsScanned %v scan rate %v avg scan rate %v",
 							id, pid, numRowsScanned, scanRate, partnStats.avgScanRate.Value())
 					}
 					partnStats.lastNumRowsScanned.Set(numRowsScanned)
@@ -1067,7 +1261,7 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 // cloned at source. Hence, it is safe to update indexInstMap and indexPartnMap
 // by acquiring lock
 func (s *scanCoordinator) handleAddIndexInstance(cmd Message) {
-	logging.Infof("ScanCoordinator::handleAddIndexInstance %v", cmd)
+	scanLog.Infof("ScanCoordinator::handleAddIndexInstance %v", cmd)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -1094,7 +1288,7 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	defer s.mu.Unlock()
 
 	req := cmd.(*MsgUpdateInstMap)
-	logging.Tracef("ScanCoordinator::handleUpdateIndexInstMap %v", cmd)
+	scanLog.Tracef("ScanCoordinator::handleUpdateIndexInstMap %v", cmd)
 	indexInstMap := req.GetIndexInstMap()
 	s.stats.Set(req.GetStatsObject())
 	s.indexInstMap = common.CopyIndexInstMap(indexInstMap)
@@ -1107,8 +1301,14 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 
 	s.updateLastSnapshotMap()
 
+	// Release snapshots of dropped indexes pinned by scan sessions
+	s.sessions.CloseIf(func(inst *common.IndexInst) bool {
+		_, ok := s.indexInstMap[inst.InstId]
+		return !ok
+	})
+
 	if len(req.GetRollbackTimes()) != 0 {
-		logging.Infof("ScanCoordinator::initialize rollback times on new index inst map: %v", req.GetRollbackTimes())
+		scanLog.Infof("ScanCoordinator::initialize rollback times on new index inst map: %v", req.GetRollbackTimes())
 		s.initRollbackTimes(req.GetRollbackTimes())
 	}
 
@@ -1119,7 +1319,7 @@ func (s *scanCoordinator) handleUpdateIndexPartnMap(cmd Message) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	logging.Tracef("ScanCoordinator::handleUpdateIndexPartnMap %v", cmd)
+	scanLog.Tracef("ScanCoordinator::handleUpdateIndexPartnMap %v", cmd)
 	indexPartnMap := cmd.(*MsgUpdatePartnMap).GetIndexPartnMap()
 	s.indexPartnMap = CopyIndexPartnMap(indexPartnMap)
 
@@ -1128,7 +1328,21 @@ func (s *scanCoordinator) handleUpdateIndexPartnMap(cmd Message) {
 
 func (s *scanCoordinator) handleConfigUpdate(cmd Message) {
 	cfgUpdate := cmd.(*MsgConfigUpdate)
-	s.config.Store(cfgUpdate.GetConfig())
+	newConfig := cfgUpdate.GetConfig()
+
+	oldAuth := s.config.Load().SectionConfig("queryport.auth.", false)
+	if _, diff := oldAuth.Diff(newConfig.SectionConfig("queryport.auth.", false)); len(diff) > 0 {
+		authr, err := queryport.NewAuthenticator(newConfig.SectionConfig("queryport.", true))
+		if err != nil {
+			scanLog.Errorf("ScanCoordinator: invalid queryport auth config %v, keeping the current one", err)
+		} else {
+			s.serv.SetAuthenticator(authr)
+		}
+	}
+	s.serv.SetConnLimits(newConfig.SectionConfig("queryport.", true))
+	s.warnUnsupportedCompressions(newConfig)
+
+	s.config.Store(newConfig)
 	s.supvCmdch <- &MsgSuccess{}
 }
 
@@ -1143,7 +1357,7 @@ func (s *scanCoordinator) handleIndexerResume(cmd Message) {
 	msg := cmd.(*MsgIndexerState)
 	rollbackTimes := msg.GetRollbackTimes()
 	if len(rollbackTimes) != 0 {
-		logging.Infof("ScanCoordinator::initialize rollback times on indexer resume: %v", rollbackTimes)
+		scanLog.Infof("ScanCoordinator::initialize rollback times on indexer resume: %v", rollbackTimes)
 		s.initRollbackTimes(rollbackTimes)
 	}
 
@@ -1167,6 +1381,11 @@ func (s *scanCoordinator) handleIndexerRollback(cmd Message) {
 	if msg.rollbackTime != 0 {
 		s.saveRollbackTime(bucket, msg.rollbackTime)
 		s.setRollbackInProgress(bucket, true)
+
+		// Snapshots pinned by scan sessions are of data rolled back
+		s.sessions.CloseIf(func(inst *common.IndexInst) bool {
+			return inst.Defn.Bucket == bucket
+		})
 	} else {
 		s.setRollbackInProgress(bucket, false)
 	}
@@ -1176,7 +1395,8 @@ func (s *scanCoordinator) handleIndexerRollback(cmd Message) {
 
 func (s *scanCoordinator) handleSecurityChange(cmd Message) {
 
-	err := s.serv.ResetConnections()
+	msg := cmd.(*MsgSecurityChange)
+	err := s.serv.RefreshSecurity(msg.RefreshCert(), msg.RefreshEncrypt())
 	if err != nil {
 		idxErr := Error{
 			code:     ERROR_INDEXER_INTERNAL_ERROR,
@@ -1220,7 +1440,7 @@ func (s *scanCoordinator) cloneRollbackTimes() map[string]int64 {
 
 func (s *scanCoordinator) saveRollbackTime(bucket string, rollbackTime int64) {
 
-	logging.Infof("ScanCoordinator::saveRollbackTime: bucket %v time %v", bucket, rollbackTime)
+	scanLog.Infof("ScanCoordinator::saveRollbackTime: bucket %v time %v", bucket, rollbackTime)
 	newTime := s.cloneRollbackTimes()
 	newTime[bucket] = rollbackTime
 	atomic.StorePointer(&s.rollbackTimes, unsafe.Pointer(&newTime))
@@ -1243,7 +1463,7 @@ func (s *scanCoordinator) initRollbackInProgress() {
 
 func (s *scanCoordinator) setRollbackInProgress(bucket string, rollback bool) {
 
-	logging.Infof("ScanCoordinator::setRollbackInProgress bucket %v rollback %v", bucket, rollback)
+	scanLog.Infof("ScanCoordinator::setRollbackInProgress bucket %v rollback %v", bucket, rollback)
 	newRollbackInProgress := s.cloneRollbackInProgress()
 	rbMap := *s.getRollbackInProgress()
 	if v, ok := rbMap[bucket]; ok {
@@ -1284,8 +1504,7 @@ func (s *scanCoordinator) updateItemsCount(instId common.IndexInstId, idxStats *
 		idxInstId: instId,
 	}
 
-	index := uint64(instId) % uint64(len(s.snapshotReqCh))
-	s.snapshotReqCh[int(index)] <- snapReqMsg
+	s.snapshotReqs.send(snapReqMsg)
 	msg := <-snapResch
 
 	// Index snapshot is not available yet (non-active index or empty index)
@@ -1489,7 +1708,7 @@ func (s *scanCoordinator) isBootstrapMode() bool {
 func bucketSeqsWithRetry(retries int, logPrefix, cluster, bucket string, numVbs int, cid string, useBucketSeqnos bool) (seqnos []uint64, err error) {
 	fn := func(r int, err error) error {
 		if r > 0 {
-			logging.Errorf("%s BucketSeqnos(%s): failed with error (%v)...Retrying (%d)",
+			scanLog.Errorf("%s BucketSeqnos(%s): failed with error (%v)...Retrying (%d)",
 				logPrefix, bucket, err, r)
 		}
 		if useBucketSeqnos {
@@ -1514,7 +1733,7 @@ func bucketSeqVbuuidsWithRetry(retries int, logPrefix, cluster,
 	bucket string, numVbs int) (seqnos, vbuuids []uint64, err error) {
 	fn := func(r int, err error) error {
 		if r > 0 {
-			logging.Errorf("%s BucketTs(%s): failed with error (%v)...Retrying (%d)",
+			scanLog.Errorf("%s BucketTs(%s): failed with error (%v)...Retrying (%d)",
 				logPrefix, bucket, err, r)
 		}
 
@@ -0,0 +1,226 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Dashboards tend to issue the same COUNT query every few seconds. With
+// scan.countCache.ttl set, the results of count scans are cached for that
+// long, for as long as the index snapshot they were computed on is the
+// latest. A new snapshot of an index drops its cached results.
+
+// countCacheKey identifies a count scan of an index snapshot.
+type countCacheKey struct {
+	scanType ScanReqType
+	defnId   uint64
+	instId   common.IndexInstId
+	spanHash uint64
+	cons     common.Consistency
+	snapId   int64
+	snapTime uint64
+}
+
+type countCacheEntry struct {
+	count  uint64
+	expiry time.Time
+}
+
+// scanCountCache is a small TTL cache of count scan results.
+type scanCountCache struct {
+	mu      sync.Mutex
+	entries map[countCacheKey]countCacheEntry
+}
+
+func newScanCountCache() *scanCountCache {
+	return &scanCountCache{
+		entries: make(map[countCacheKey]countCacheEntry),
+	}
+}
+
+func (c *scanCountCache) Get(key countCacheKey, now time.Time) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if !now.Before(e.expiry) {
+		delete(c.entries, key)
+		return 0, false
+	}
+	return e.count, true
+}
+
+// Put caches count for ttl. With maxEntries cached, expired entries are
+// dropped, or an arbitrary entry if none has expired.
+func (c *scanCountCache) Put(key countCacheKey, count uint64, ttl time.Duration,
+	maxEntries int, now time.Time) {
+
+	if maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expiry) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = countCacheEntry{count: count, expiry: now.Add(ttl)}
+}
+
+// Invalidate drops the cached results of instId.
+func (c *scanCountCache) Invalidate(instId common.IndexInstId) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.entries {
+		if k.instId == instId {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *scanCountCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// countSpanHash returns a hash of the partitions and spans counted by req.
+func countSpanHash(req *ScanRequest) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+
+	writeInt := func(v int64) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	}
+	writeBool := func(v bool) {
+		if v {
+			writeInt(1)
+		} else {
+			writeInt(0)
+		}
+	}
+
+	writeInt(int64(len(req.PartitionIds)))
+	for _, partnId := range req.PartitionIds {
+		writeInt(int64(partnId))
+	}
+
+	writeBool(req.Distinct)
+	writeInt(int64(req.Incl))
+	hashIndexKey(h, req.Low)
+	hashIndexKey(h, req.High)
+	writeInt(int64(len(req.Keys)))
+	for _, key := range req.Keys {
+		hashIndexKey(h, key)
+	}
+
+	writeInt(int64(len(req.Scans)))
+	for _, scan := range req.Scans {
+		h.Write([]byte(scan.ScanType))
+		writeInt(int64(scan.Incl))
+		hashIndexKey(h, scan.Low)
+		hashIndexKey(h, scan.High)
+		hashIndexKey(h, scan.Equals)
+
+		writeInt(int64(len(scan.Filters)))
+		for _, filter := range scan.Filters {
+			h.Write([]byte(filter.ScanType))
+			writeInt(int64(filter.Inclusion))
+			hashIndexKey(h, filter.Low)
+			hashIndexKey(h, filter.High)
+
+			writeInt(int64(len(filter.CompositeFilters)))
+			for _, cf := range filter.CompositeFilters {
+				writeInt(int64(cf.Inclusion))
+				hashIndexKey(h, cf.Low)
+				hashIndexKey(h, cf.High)
+			}
+		}
+	}
+
+	return h.Sum64()
+}
+
+// hashIndexKey writes key to h, telling apart nil keys, the min and max
+// keys and keys of the same bytes.
+func hashIndexKey(h hash.Hash64, key IndexKey) {
+	var buf [8]byte
+
+	switch k := key.(type) {
+	case nil:
+		h.Write([]byte{0})
+	case *NilIndexKey:
+		h.Write([]byte{1, byte(k.cmp), byte(k.pcmp)})
+	default:
+		b := key.Bytes()
+		binary.LittleEndian.PutUint64(buf[:], uint64(len(b)))
+		h.Write([]byte{2})
+		h.Write(buf[:])
+		h.Write(b)
+	}
+}
+
+// cachedCount returns the count of req from the cache, or the count
+// computed by scan, which is then cached.
+func (s *scanCoordinator) cachedCount(req *ScanRequest, is IndexSnapshot,
+	scan func() (uint64, error)) (uint64, error) {
+
+	cfg := s.config.Load()
+	ttl := time.Duration(cfg["scan.countCache.ttl"].Int()) * time.Millisecond
+	if ttl <= 0 || is == nil || req.Consistency == nil {
+		return scan()
+	}
+
+	key := countCacheKey{
+		scanType: req.ScanType,
+		defnId:   req.DefnID,
+		instId:   req.IndexInstId,
+		spanHash: countSpanHash(req),
+		cons:     *req.Consistency,
+		snapId:   is.SnapId(),
+		snapTime: is.CreationTime(),
+	}
+
+	if count, ok := s.countCache.Get(key, time.Now()); ok {
+		if req.Stats != nil {
+			req.Stats.numCountCacheHits.Add(1)
+		}
+		return count, nil
+	}
+
+	count, err := scan()
+	if err == nil {
+		s.countCache.Put(key, count, ttl, cfg["scan.countCache.size"].Int(), time.Now())
+	}
+	return count, err
+}
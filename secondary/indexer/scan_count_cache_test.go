@@ -0,0 +1,93 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanCountCache(t *testing.T) {
+	c := newScanCountCache()
+	now := time.Now()
+	ttl := time.Second
+
+	key1 := countCacheKey{scanType: CountReq, instId: 1, snapId: 1}
+	key2 := countCacheKey{scanType: CountReq, instId: 2, snapId: 1}
+
+	c.Put(key1, 10, ttl, 2, now)
+	if count, ok := c.Get(key1, now.Add(ttl/2)); !ok || count != 10 {
+		t.Errorf("expected cached count 10, got %v %v", count, ok)
+	}
+	if _, ok := c.Get(key1, now.Add(ttl)); ok {
+		t.Errorf("expected entry to expire after ttl")
+	}
+
+	// A new snapshot is a different key
+	c.Put(key1, 10, ttl, 2, now)
+	next := key1
+	next.snapId = 2
+	if _, ok := c.Get(next, now); ok {
+		t.Errorf("expected miss for a new snapshot")
+	}
+
+	c.Put(key2, 20, ttl, 2, now)
+	c.Invalidate(1)
+	if _, ok := c.Get(key1, now); ok {
+		t.Errorf("expected entry of inst 1 to be invalidated")
+	}
+	if _, ok := c.Get(key2, now); !ok {
+		t.Errorf("expected entry of inst 2 to be retained")
+	}
+
+	// Bounded by maxEntries
+	for i := 0; i < 10; i++ {
+		c.Put(countCacheKey{instId: common.IndexInstId(i + 10)}, 1, ttl, 4, now)
+	}
+	if n := c.Len(); n != 4 {
+		t.Errorf("expected 4 entries, got %v", n)
+	}
+
+	c.Put(key1, 10, ttl, 0, now)
+	if _, ok := c.Get(key1, now); ok {
+		t.Errorf("expected nothing cached with maxEntries 0")
+	}
+}
+
+func TestCountSpanHash(t *testing.T) {
+	a, _ := NewPrimaryKey([]byte("a"))
+	b, _ := NewPrimaryKey([]byte("b"))
+
+	reqs := []*ScanRequest{
+		{Scans: []Scan{{Low: a, High: b, Incl: Both}}},
+		{Scans: []Scan{{Low: a, High: b, Incl: Low}}},
+		{Scans: []Scan{{Low: a, High: a, Incl: Both}}},
+		{Scans: []Scan{{Low: MinIndexKey, High: b, Incl: Both}}},
+		{Scans: []Scan{{Low: MaxIndexKey, High: b, Incl: Both}}},
+		{Scans: []Scan{{Low: a, High: b, Incl: Both}}, Distinct: true},
+		{Scans: []Scan{{Low: a, High: b, Incl: Both}}, PartitionIds: []common.PartitionId{1}},
+		{Scans: []Scan{{Low: a, High: b, Incl: Both}, {Low: a, High: b, Incl: Both}}},
+	}
+
+	seen := make(map[uint64]int)
+	for i, req := range reqs {
+		h := countSpanHash(req)
+		if j, ok := seen[h]; ok {
+			t.Errorf("requests %v and %v have the same hash", j, i)
+		}
+		seen[h] = i
+	}
+
+	same := &ScanRequest{Scans: []Scan{{Low: a, High: b, Incl: Both}}}
+	if countSpanHash(same) != countSpanHash(reqs[0]) {
+		t.Errorf("expected the same hash for the same spans")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+)
+
+// To cost alternative indexes cheaply, the query planner can send a count
+// request with estimate set. Instead of scanning, the indexer estimates the
+// number of rows of the scans from the item counts of the snapshots and the
+// key distribution sketches of the index (see key_stats.go), and returns it
+// with the average entry size of the index.
+//
+// The selectivity of a scan is the product of the selectivities of the
+// filters on its key positions, assuming key positions are independent.
+// Equality filters use the number of distinct values of the key position,
+// and range filters on numbers use its t-digest. Without sketches, filters
+// fall back to default selectivities.
+
+const (
+	defaultEqualitySelectivity = 0.1
+	defaultRangeSelectivity    = 1.0 / 3
+)
+
+func (s *scanCoordinator) handleEstimateRequest(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot) {
+
+	snapshots, err := GetSliceSnapshots(is, req.PartitionIds)
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+
+	var total uint64
+	for _, ss := range snapshots {
+		c, err := ss.Snapshot().StatCountTotal()
+		if s.tryRespondWithError(w, req, err) {
+			return
+		}
+		total += c
+	}
+
+	var positions []*keyPositionStats
+	var avgEntrySize int64
+	if req.Stats != nil {
+		positions = req.Stats.mergedKeyStats(req.PartitionIds)
+		avgEntrySize = computeAvgItemSize(
+			req.Stats.partnInt64Stats(func(ss *IndexStats) int64 {
+				return ss.rawDataSize.Value()
+			}),
+			req.Stats.partnInt64Stats(func(ss *IndexStats) int64 {
+				return ss.itemsCount.Value()
+			}))
+	}
+
+	rows := estimateScanRows(req.Scans, total, positions)
+
+	scanLog.Verbosef("%s RESPONSE estimate:%d avgEntrySize:%d status:ok", req.LogPrefix, rows, avgEntrySize)
+	err = w.Estimate(rows, uint64(avgEntrySize))
+	s.handleError(req.LogPrefix, err)
+}
+
+// estimateScanRows estimates the number of rows of scans over an index of
+// total items.
+func estimateScanRows(scans []Scan, total uint64, positions []*keyPositionStats) uint64 {
+	var sel float64
+	for _, scan := range scans {
+		sel += scanSelectivity(scan, positions)
+	}
+	if sel > 1 {
+		sel = 1
+	}
+	return uint64(math.Round(sel * float64(total)))
+}
+
+func scanSelectivity(scan Scan, positions []*keyPositionStats) float64 {
+	switch scan.ScanType {
+	case AllReq:
+		return 1
+	case LookupReq:
+		code := scan.Equals.Bytes()
+		if len(positions) == 0 || len(code) == 0 {
+			return defaultEqualitySelectivity
+		}
+		n := 1
+		if fields, err := jsonEncoder.ExplodeArray(code, make([]byte, 0, len(code)*3+ENCODE_BUF_SAFE_PAD)); err == nil && len(fields) > 0 {
+			n = len(fields)
+		}
+		sel := 1.0
+		for i := 0; i < n; i++ {
+			sel *= equalitySelectivity(keyPosition(positions, i))
+		}
+		return sel
+	}
+
+	if len(scan.Filters) == 0 {
+		return keyRangeSelectivity(scan.Low, scan.High, nil)
+	}
+
+	var sel float64
+	for _, filter := range scan.Filters {
+		fsel := 1.0
+		for i, cf := range filter.CompositeFilters {
+			fsel *= keyRangeSelectivity(cf.Low, cf.High, keyPosition(positions, i))
+		}
+		sel += fsel
+	}
+	return math.Min(sel, 1)
+}
+
+func keyPosition(positions []*keyPositionStats, i int) *keyPositionStats {
+	if i < len(positions) && positions[i].count > 0 {
+		return positions[i]
+	}
+	return nil
+}
+
+func equalitySelectivity(ps *keyPositionStats) float64 {
+	if ps == nil {
+		return defaultEqualitySelectivity
+	}
+	if distinct := ps.hll.estimate(); distinct > 0 {
+		return 1 / float64(distinct)
+	}
+	return defaultEqualitySelectivity
+}
+
+// keyRangeSelectivity returns the estimated fraction of the values of a key
+// position between low and high.
+func keyRangeSelectivity(low, high IndexKey, ps *keyPositionStats) float64 {
+	lowUnbounded, highUnbounded := low == nil || low == MinIndexKey, high == nil || high == MaxIndexKey
+	if lowUnbounded && highUnbounded {
+		return 1
+	}
+	if !lowUnbounded && !highUnbounded && low.CompareIndexKey(high) == 0 {
+		return equalitySelectivity(ps)
+	}
+
+	if ps == nil || ps.numeric == 0 {
+		return defaultRangeSelectivity
+	}
+
+	lowFrac, highFrac := 0.0, 1.0
+	if !lowUnbounded {
+		v, ok := numericKeyValue(low)
+		if !ok {
+			return defaultRangeSelectivity
+		}
+		lowFrac = ps.digest.cdf(v)
+	}
+	if !highUnbounded {
+		v, ok := numericKeyValue(high)
+		if !ok {
+			return defaultRangeSelectivity
+		}
+		highFrac = ps.digest.cdf(v)
+	}
+
+	if highFrac <= lowFrac {
+		return 0
+	}
+	return (highFrac - lowFrac) * float64(ps.numeric) / float64(ps.count)
+}
+
+// numericKeyValue returns the value of an encoded number key.
+func numericKeyValue(k IndexKey) (float64, bool) {
+	code := k.Bytes()
+	if len(code) == 0 || code[0] != collatejson.TypeNumber {
+		return 0, false
+	}
+
+	text, err := jsonEncoder.Decode(code, make([]byte, 0, len(code)*3+ENCODE_BUF_SAFE_PAD))
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(string(text), 64)
+	return v, err == nil
+}
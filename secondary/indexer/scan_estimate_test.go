@@ -0,0 +1,59 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestEstimateScanRows(t *testing.T) {
+	key := func(k string) IndexKey {
+		ik, err := NewSecondaryKey([]byte(k), make([]byte, 0, 3*len(k)+ENCODE_BUF_SAFE_PAD), true, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ik
+	}
+	filterScan := func(filters ...CompositeElementFilter) Scan {
+		return Scan{
+			ScanType: FilterRangeReq,
+			Filters:  []Filter{{CompositeFilters: filters}},
+		}
+	}
+
+	// Key 0 has 100 distinct values, key 1 is uniform over 1..1000
+	ks := &indexKeyStats{}
+	for i := 0; i < 1000; i++ {
+		if err := ks.sample([]byte(`["v`+strconv.Itoa(i%100)+`",`+strconv.Itoa(i+1)+`]`), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	positions := ks.mergeInto(nil)
+
+	check := func(name string, scan Scan, positions []*keyPositionStats, min, max uint64) {
+		if rows := estimateScanRows([]Scan{scan}, 10000, positions); rows < min || rows > max {
+			t.Fatalf("%v: expected between %v and %v rows, got %v", name, min, max, rows)
+		}
+	}
+
+	check("all", getScanAll(), positions, 10000, 10000)
+	check("equality", filterScan(CompositeElementFilter{Low: key(`"v1"`), High: key(`"v1"`)}),
+		positions, 90, 110)
+	check("range", filterScan(
+		CompositeElementFilter{Low: MinIndexKey, High: MaxIndexKey},
+		CompositeElementFilter{Low: key(`251`), High: key(`750`)}),
+		positions, 4800, 5200)
+	check("unbounded range", filterScan(
+		CompositeElementFilter{Low: MinIndexKey, High: MaxIndexKey},
+		CompositeElementFilter{Low: key(`901`), High: MaxIndexKey}),
+		positions, 900, 1100)
+	check("no sketches", filterScan(CompositeElementFilter{Low: key(`"v1"`), High: key(`"v1"`)}),
+		nil, 1000, 1000)
+}
@@ -0,0 +1,157 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+const (
+	scanLimitModeUser   = "user"
+	scanLimitModeBucket = "bucket"
+)
+
+// Interval between sweeps of the idle tenants of the scan limiter
+const scanLimiterSweepInterval = time.Minute
+
+// ScanThrottledError is returned for scans rejected by the scan limiter.
+// Clients are expected to retry after RetryAfter.
+type ScanThrottledError struct {
+	Tenant     string
+	RetryAfter time.Duration
+}
+
+func (e *ScanThrottledError) Error() string {
+	return fmt.Sprintf("%v for %v. Retry after %v", common.ErrScanThrottled,
+		e.Tenant, e.RetryAfter)
+}
+
+// scanLimiter limits the rate and concurrency of scans per tenant, a user
+// or a bucket, so that one tenant cannot monopolize the scan pipeline.
+// The rate is enforced by a token bucket holding up to a second of scans.
+//
+// Tenants without active scans whose token bucket is full are the same as
+// tenants never seen, and are dropped by a periodic sweep, so that users
+// and buckets that are gone do not stay in the map.
+type scanLimiter struct {
+	mu        sync.Mutex
+	tenants   map[string]*tenantScanLimit
+	lastSweep time.Time
+}
+
+type tenantScanLimit struct {
+	tokens float64
+	last   time.Time
+	active int
+}
+
+func newScanLimiter() *scanLimiter {
+	return &scanLimiter{
+		tenants: make(map[string]*tenantScanLimit),
+	}
+}
+
+// Acquire admits a scan of tenant, unless it exceeds qps scans per second
+// or maxConcurrent active scans. A limit of 0 is unlimited. Admitted scans
+// must call the returned release function once done.
+func (l *scanLimiter) Acquire(tenant string, qps, maxConcurrent int,
+	now time.Time) (func(), error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= scanLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	t, ok := l.tenants[tenant]
+	if !ok {
+		t = &tenantScanLimit{tokens: float64(qps), last: now}
+		l.tenants[tenant] = t
+	}
+
+	if maxConcurrent > 0 && t.active >= maxConcurrent {
+		// Retry after a typical short scan
+		return nil, &ScanThrottledError{Tenant: tenant, RetryAfter: 10 * time.Millisecond}
+	}
+
+	if qps > 0 {
+		elapsed := now.Sub(t.last).Seconds()
+		t.last = now
+		t.tokens = math.Min(float64(qps), t.tokens+elapsed*float64(qps))
+		if t.tokens < 1 {
+			wait := time.Duration((1 - t.tokens) / float64(qps) * float64(time.Second))
+			return nil, &ScanThrottledError{Tenant: tenant, RetryAfter: wait}
+		}
+		t.tokens--
+	}
+
+	t.active++
+	released := false
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if !released {
+			released = true
+			t.active--
+		}
+	}, nil
+}
+
+// sweep drops the tenants idle for over a second, by when their token
+// bucket is full again.
+func (l *scanLimiter) sweep(now time.Time) {
+	for tenant, t := range l.tenants {
+		if t.active == 0 && now.Sub(t.last) >= time.Second {
+			delete(l.tenants, tenant)
+		}
+	}
+	l.lastSweep = now
+}
+
+// acquireScanLimit applies the scan limits configured for the tenant of req.
+//
+// In user mode the tenant is the user the connection authenticated as.
+// Scans carry no identity of the end user, so this limits per user only
+// the clients connecting to the queryport as the user themselves. The
+// n1ql scans of a query node are all of the user of the query service,
+// and share one limit per query node.
+func (s *scanCoordinator) acquireScanLimit(req *ScanRequest) (func(), error) {
+	cfg := s.config.Load()
+
+	var tenant string
+	switch cfg["scan.limit.mode"].String() {
+	case scanLimitModeUser:
+		if creds := req.connCtx.GetCreds(); creds != nil {
+			tenant = "user:" + creds.Name()
+		} else {
+			// Unauthenticated connections share the limit of the bucket
+			tenant = "bucket:" + req.Bucket
+		}
+	case scanLimitModeBucket:
+		tenant = "bucket:" + req.Bucket
+	default:
+		return func() {}, nil
+	}
+
+	release, err := s.limiter.Acquire(tenant, cfg["scan.limit.qps"].Int(),
+		cfg["scan.limit.concurrency"].Int(), time.Now())
+	if err != nil {
+		scanLog.Verbosef("%s %v", req.LogPrefix, logging.TagUD(err))
+		return nil, err
+	}
+	return release, nil
+}
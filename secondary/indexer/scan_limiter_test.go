@@ -0,0 +1,94 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanLimiterQps(t *testing.T) {
+	l := newScanLimiter()
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire("bucket:b1", 2, 0, now)
+		if err != nil {
+			t.Fatalf("Scan %v rejected: %v", i, err)
+		}
+		release()
+	}
+
+	_, err := l.Acquire("bucket:b1", 2, 0, now)
+	throttled, ok := err.(*ScanThrottledError)
+	if !ok || throttled.RetryAfter <= 0 {
+		t.Fatalf("Expected retry-after error, found %v", err)
+	}
+
+	// Other tenants are not affected
+	if _, err := l.Acquire("bucket:b2", 2, 0, now); err != nil {
+		t.Errorf("Scan of other tenant rejected: %v", err)
+	}
+
+	// Tokens are replenished over time
+	if _, err := l.Acquire("bucket:b1", 2, 0, now.Add(throttled.RetryAfter)); err != nil {
+		t.Errorf("Scan rejected after retry-after: %v", err)
+	}
+}
+
+func TestScanLimiterConcurrency(t *testing.T) {
+	l := newScanLimiter()
+	now := time.Now()
+
+	release, err := l.Acquire("user:u1", 0, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire("user:u1", 0, 1, now); err == nil {
+		t.Fatalf("Expected concurrent scan to be rejected")
+	}
+
+	release()
+	release() // releasing twice must not free another slot
+	if _, err := l.Acquire("user:u1", 0, 1, now); err != nil {
+		t.Errorf("Scan rejected after release: %v", err)
+	}
+	if _, err := l.Acquire("user:u1", 0, 1, now); err == nil {
+		t.Errorf("Expected concurrent scan to be rejected")
+	}
+}
+
+func TestScanLimiterSweep(t *testing.T) {
+	l := newScanLimiter()
+	now := time.Now()
+
+	release, err := l.Acquire("user:u1", 2, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := l.Acquire("user:u2", 2, 1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle()
+
+	// Tenants with active scans are kept
+	now = now.Add(scanLimiterSweepInterval)
+	if _, err := l.Acquire("user:u3", 2, 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.tenants["user:u2"]; ok {
+		t.Errorf("Idle tenant not dropped")
+	}
+	if _, ok := l.tenants["user:u1"]; !ok {
+		t.Fatalf("Tenant with active scan dropped")
+	}
+	if _, err := l.Acquire("user:u1", 2, 1, now); err == nil {
+		t.Errorf("Expected concurrent scan to be rejected")
+	}
+
+	release()
+	now = now.Add(scanLimiterSweepInterval)
+	l.Acquire("user:u3", 2, 1, now)
+	if _, ok := l.tenants["user:u3"]; !ok || len(l.tenants) != 1 {
+		t.Errorf("Expected only tenant u3 with active scan, found %v", l.tenants)
+	}
+}
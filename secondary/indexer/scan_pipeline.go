@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"runtime"
 	"time"
 
 	"github.com/couchbase/indexing/secondary/collatejson"
@@ -38,12 +39,21 @@ type ScanPipeline struct {
 	aggrRes         *aggrResult
 	stopAggregation bool
 
+	arena *scanArena // temporaries of the row being projected, used by the source
+
 	rowsReturned  uint64
 	bytesRead     uint64
 	rowsScanned   uint64
 	cacheHitRatio int
 	exprEvalDur   time.Duration
 	exprEvalNum   int64
+
+	// Collected only for profiled requests
+	srcTime        time.Duration
+	srcBlockedTime time.Duration
+	srcCPUTime     time.Duration
+	writeTime      time.Duration
+	bufGrows       int
 }
 
 func (p *ScanPipeline) Cancel(err error) {
@@ -70,6 +80,29 @@ func (p ScanPipeline) CacheHitRatio() int {
 	return p.cacheHitRatio
 }
 
+// ActiveTime is the wall time the source spent iterating storage, filtering
+// and projecting, excluding time blocked on downstream stages. It is not CPU
+// time, as the source may as well be waiting on storage or be descheduled.
+// It is only computed for profiled requests.
+func (p ScanPipeline) ActiveTime() time.Duration {
+	return p.srcTime - p.srcBlockedTime
+}
+
+// CPUTime is the CPU time of the source, iterating storage, filtering and
+// projecting. It is only computed for profiled requests, and is 0 where the
+// CPU time of a thread is not available.
+func (p ScanPipeline) CPUTime() time.Duration {
+	return p.srcCPUTime
+}
+
+func (p ScanPipeline) WriteTime() time.Duration {
+	return p.writeTime
+}
+
+func (p ScanPipeline) BufGrows() int {
+	return p.bufGrows
+}
+
 func (p ScanPipeline) AvgExprEvalDur() time.Duration {
 
 	if p.exprEvalNum != 0 {
@@ -127,8 +160,8 @@ func (s *IndexScanSource) Routine() error {
 
 	defer func() {
 		if r := recover(); r != nil {
-			l.Fatalf("IndexScanSource - panic detected while processing %s", s.p.req)
-			l.Fatalf("%s", l.StackTraceAll())
+			scanLog.Fatalf("IndexScanSource - panic detected while processing %s", s.p.req)
+			scanLog.Fatalf("%s", l.StackTraceAll())
 			panic(r)
 		}
 	}()
@@ -137,11 +170,40 @@ func (s *IndexScanSource) Routine() error {
 	defer s.CloseWrite()
 
 	r := s.p.req
+	if r.profile != nil {
+		// Locked to its thread, for the CPU time of the thread to be
+		// the one of the source
+		runtime.LockOSThread()
+		t0 := time.Now()
+		cpu0, cpuOk := threadCPUTime()
+		defer func() {
+			s.p.srcTime = time.Since(t0)
+			if cpu1, ok := threadCPUTime(); cpuOk && ok {
+				s.p.srcCPUTime = cpu1 - cpu0
+			}
+			runtime.UnlockOSThread()
+		}()
+	}
+
+	arena := getScanArena(s.p.config["scan.arena_size"].Int())
+	s.p.arena = arena
+	defer func() {
+		if arena != nil {
+			s.p.bufGrows += arena.heapAllocs
+		}
+		s.p.arena = nil
+		putScanArena(arena)
+	}()
+
 	var currentScan Scan
 	currOffset := int64(0)
 	count := 1
 	checkDistinct := r.Distinct && !r.isPrimary
 
+	// Partial groups are aggregated further by the query service, which
+	// applies the limit and offset to the result
+	limitRows := r.groupsComplete()
+
 	var buf, buf2, revbuf *[]byte
 	var previousRow, docidbuf []byte
 	var cktmp [][]byte
@@ -160,6 +222,19 @@ func (s *IndexScanSource) Routine() error {
 		previousRow = (*buf2)[:0]
 	}
 
+	var distinctOnKeys int
+	var distinctOnBuf, distinctOnTmp *[]byte
+	var previousGroup, currentGroup []byte
+	if r.Indexprojection != nil && !r.isPrimary {
+		distinctOnKeys = r.Indexprojection.distinctOnKeys
+	}
+	if distinctOnKeys > 0 {
+		distinctOnBuf = secKeyBufPool.Get() //Tracking for distinct on
+		distinctOnTmp = secKeyBufPool.Get()
+		r.keyBufList = append(r.keyBufList, distinctOnBuf, distinctOnTmp)
+		previousGroup = (*distinctOnBuf)[:0]
+	}
+
 	hasDesc := s.p.req.IndexInst.Defn.HasDescending()
 	if hasDesc {
 		revbuf = secKeyBufPool.Get() //Reverse collation buffer
@@ -187,6 +262,87 @@ func (s *IndexScanSource) Routine() error {
 
 	}
 
+	exprBatch := newExprBatch(r.GroupAggr, s.p.config["scan.expr_eval_batch_size"].Int())
+
+	// writeRow projects and writes the row of entry, after its group
+	// keys and aggregates are computed
+	writeRow := func(entry []byte, ck [][]byte, count int) error {
+		var err error
+
+		if r.Indexprojection != nil && r.Indexprojection.projectSecKeys {
+
+			if buf == nil {
+				initTempBuf()
+			}
+
+			if r.GroupAggr != nil {
+				entry, err = projectGroupAggr((*buf)[:0], r.Indexprojection, s.p.aggrRes, r.isPrimary, arena)
+				if entry == nil {
+					return err
+				}
+			} else if !r.isPrimary {
+				if ck == nil && len(entry) > cap(*buf) {
+					*buf = make([]byte, 0, len(entry)+1024)
+					s.p.bufGrows++
+				}
+
+				entry, err = projectKeys(ck, entry, (*buf)[:0], r, cktmp, arena)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if checkDistinct {
+			if len(previousRow) != 0 && distinctCompare(entry, previousRow, false) {
+				return nil // Ignore the entry as it is same as previous entry
+			}
+		}
+
+		for i := 0; i < count; i++ {
+			if (r.Distinct || distinctOnKeys > 0) && i > 0 {
+				break
+			}
+			if currOffset >= r.Offset || !limitRows {
+				s.p.rowsReturned++
+				wrErr := s.writeItem(entry)
+				if wrErr != nil {
+					return wrErr
+				}
+				if (limitRows && s.p.rowsReturned == uint64(r.Limit)) || s.p.stopAggregation {
+					return ErrLimitReached
+				}
+			} else {
+				currOffset++
+			}
+		}
+
+		if checkDistinct {
+			previousRow = append(previousRow[:0], entry...)
+		}
+
+		return nil
+	}
+
+	// writeExprBatch groups and writes the rows of exprBatch
+	writeExprBatch := func() error {
+		defer exprBatch.reset()
+
+		if err := exprBatch.evaluate(s.p); err != nil {
+			return err
+		}
+		for i := 0; i < exprBatch.n; i++ {
+			arena.reset()
+			if err := exprBatch.computeGroupAggr(i, s.p.aggrRes, s.p); err != nil {
+				return err
+			}
+			if err := writeRow(exprBatch.rows[i].entry, exprBatch.rows[i].ck, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	iterCount := 0
 	fn := func(entry []byte) error {
 		if iterCount%SCAN_ROLLBACK_ERROR_BATCHSIZE == 0 && r.hasRollback != nil && r.hasRollback.Load() == true {
@@ -194,6 +350,7 @@ func (s *IndexScanSource) Routine() error {
 		}
 		iterCount++
 		s.p.rowsScanned++
+		arena.reset()
 
 		skipRow := false
 		var ck [][]byte
@@ -218,6 +375,7 @@ func (s *IndexScanSource) Routine() error {
 			}
 			if len(entry) > cap(*buf) {
 				*buf = make([]byte, 0, len(entry)+1024)
+				s.p.bufGrows++
 			}
 
 			skipRow, ck, dk, err = filterScanRow2(entry, currentScan,
@@ -231,6 +389,17 @@ func (s *IndexScanSource) Routine() error {
 			return nil
 		}
 
+		if distinctOnKeys > 0 {
+			currentGroup, err = distinctOnKey(entry, distinctOnKeys, distinctOnTmp, currentGroup[:0])
+			if err != nil {
+				return err
+			}
+			if len(previousGroup) != 0 && bytes.Equal(currentGroup, previousGroup) {
+				return nil // Only the first entry of a group is returned
+			}
+			previousGroup = append(previousGroup[:0], currentGroup...)
+		}
+
 		if !r.isPrimary {
 			if r.GroupAggr == nil ||
 				(r.GroupAggr != nil && !r.GroupAggr.OnePerPrimaryKey) {
@@ -247,6 +416,7 @@ func (s *IndexScanSource) Routine() error {
 
 			if ck == nil && len(entry) > cap(*buf) {
 				*buf = make([]byte, 0, len(entry)+1024)
+				s.p.bufGrows++
 			}
 
 			var docid []byte
@@ -259,65 +429,28 @@ func (s *IndexScanSource) Routine() error {
 				}
 			}
 
-			err = computeGroupAggr(ck, dk, count, docid, entry, (*buf)[:0], s.p.aggrRes, r.GroupAggr, cktmp, dktmp, &cachedEntry, s.p)
-			if err != nil {
-				return err
-			}
-			count = 1 //reset count; count is used for aggregates computation
-		}
-
-		if r.Indexprojection != nil && r.Indexprojection.projectSecKeys {
-
-			if buf == nil {
-				initTempBuf()
-			}
-
-			if r.GroupAggr != nil {
-				entry, err = projectGroupAggr((*buf)[:0], r.Indexprojection, s.p.aggrRes, r.isPrimary)
-				if entry == nil {
+			if exprBatch != nil {
+				ck, dk, err = explodeGroupAggrKeys(ck, dk, entry, (*buf)[:0], r.GroupAggr,
+					cktmp, dktmp, &cachedEntry, s.p)
+				if err != nil {
 					return err
 				}
-			} else if !r.isPrimary {
-				if ck == nil && len(entry) > cap(*buf) {
-					*buf = make([]byte, 0, len(entry)+1024)
+				full := exprBatch.add(entry, ck, dk, docid, count, cachedEntry.Valid())
+				count = 1
+				if full {
+					return writeExprBatch()
 				}
-
-				entry, err = projectKeys(ck, entry, (*buf)[:0], r, cktmp)
+				return nil
 			}
+
+			err = computeGroupAggr(ck, dk, count, docid, entry, (*buf)[:0], s.p.aggrRes, r.GroupAggr, cktmp, dktmp, &cachedEntry, s.p)
 			if err != nil {
 				return err
 			}
+			count = 1 //reset count; count is used for aggregates computation
 		}
 
-		if checkDistinct {
-			if len(previousRow) != 0 && distinctCompare(entry, previousRow, false) {
-				return nil // Ignore the entry as it is same as previous entry
-			}
-		}
-
-		for i := 0; i < count; i++ {
-			if r.Distinct && i > 0 {
-				break
-			}
-			if currOffset >= r.Offset {
-				s.p.rowsReturned++
-				wrErr := s.WriteItem(entry)
-				if wrErr != nil {
-					return wrErr
-				}
-				if s.p.rowsReturned == uint64(r.Limit) || s.p.stopAggregation {
-					return ErrLimitReached
-				}
-			} else {
-				currOffset++
-			}
-		}
-
-		if checkDistinct {
-			previousRow = append(previousRow[:0], entry...)
-		}
-
-		return nil
+		return writeRow(entry, ck, count)
 	}
 
 	sliceSnapshots, err1 := GetSliceSnapshots(s.is, s.p.req.PartitionIds)
@@ -347,6 +480,15 @@ loop:
 		}
 	}
 
+	if exprBatch != nil && err == nil {
+		err = writeExprBatch()
+		switch err {
+		case nil, p.ErrSupervisorKill, ErrLimitReached:
+		default:
+			s.CloseWithError(err)
+		}
+	}
+
 	s.p.cacheHitRatio = cachedEntry.CacheHitRatio()
 
 	if r.GroupAggr != nil && err == nil {
@@ -360,7 +502,8 @@ loop:
 		}
 
 		for {
-			entry, err := projectGroupAggr((*buf)[:0], r.Indexprojection, s.p.aggrRes, r.isPrimary)
+			arena.reset()
+			entry, err := projectGroupAggr((*buf)[:0], r.Indexprojection, s.p.aggrRes, r.isPrimary, arena)
 			if err != nil {
 				s.CloseWithError(err)
 				break
@@ -368,7 +511,8 @@ loop:
 
 			if entry == nil {
 
-				if s.p.rowsReturned == 0 {
+				// no group, not even one skipped by the offset
+				if s.p.rowsReturned == 0 && currOffset == 0 {
 
 					//handle special group rules
 					entry, err = projectEmptyResult((*buf)[:0], r.Indexprojection, r.GroupAggr)
@@ -382,7 +526,7 @@ loop:
 					}
 
 					s.p.rowsReturned++
-					wrErr := s.WriteItem(entry)
+					wrErr := s.writeItem(entry)
 					if wrErr != nil {
 						s.CloseWithError(wrErr)
 						break
@@ -396,14 +540,14 @@ loop:
 				break
 			}
 
-			if currOffset >= r.Offset {
+			if currOffset >= r.Offset || !limitRows {
 				s.p.rowsReturned++
-				wrErr := s.WriteItem(entry)
+				wrErr := s.writeItem(entry)
 				if wrErr != nil {
 					s.CloseWithError(wrErr)
 					break
 				}
-				if s.p.rowsReturned == uint64(r.Limit) {
+				if limitRows && s.p.rowsReturned == uint64(r.Limit) {
 					return nil
 				}
 			} else {
@@ -417,6 +561,19 @@ loop:
 	return nil
 }
 
+// writeItem writes entry to the decoder, accounting the time blocked
+// on it for profiled requests.
+func (s *IndexScanSource) writeItem(entry []byte) error {
+	if s.p.req.profile == nil {
+		return s.WriteItem(entry)
+	}
+
+	t0 := time.Now()
+	err := s.WriteItem(entry)
+	s.p.srcBlockedTime += time.Since(t0)
+	return err
+}
+
 func (d *IndexScanDecoder) Routine() error {
 	defer d.CloseWrite()
 	defer d.CloseRead()
@@ -427,7 +584,7 @@ func (d *IndexScanDecoder) Routine() error {
 
 loop:
 	for {
-		row, err := d.ReadItem()
+		row, ref, err := d.ReadItemRef()
 		switch err {
 		case nil:
 		case p.ErrNoMoreItem, p.ErrSupervisorKill:
@@ -437,6 +594,9 @@ loop:
 			break loop
 		}
 
+		// sk and docid are passed on without copying if they point into row
+		inRow := false
+
 		dataEncFmt := d.p.req.dataEncFmt
 
 		if dataEncFmt == c.DATA_ENC_JSON {
@@ -449,23 +609,27 @@ loop:
 		if d.p.req.GroupAggr != nil {
 			if dataEncFmt == c.DATA_ENC_COLLATEJSON {
 				sk = row
+				inRow = true
 			} else if dataEncFmt == c.DATA_ENC_JSON {
 				sk, err = jsonEncoder.Decode(row, t)
 				if err != nil {
 					err = fmt.Errorf("Collatejson decode error: %v", err)
-					l.Errorf("Error (%v) in Decode for row %v, "+
+					scanLog.Errorf("Error (%v) in Decode for row %v, "+
 						"req = %s", err, row, d.p.req)
+					ref.Release()
 					d.CloseWithError(err)
 					break loop
 				}
 			} else {
 				err = c.ErrUnexpectedDataEncFmt
+				ref.Release()
 				d.CloseWithError(err)
 				break loop
 			}
 		} else if d.p.req.isPrimary {
 			sk, docid, err = piSplitEntry(row, t)
 			if err != nil {
+				ref.Release()
 				d.CloseWithError(err)
 				break loop
 			}
@@ -473,19 +637,23 @@ loop:
 			if dataEncFmt == c.DATA_ENC_COLLATEJSON {
 				sk, docid, err = siSplitEntryCJson(row)
 				if err != nil {
+					ref.Release()
 					d.CloseWithError(err)
 					break loop
 				}
+				inRow = true
 			} else if dataEncFmt == c.DATA_ENC_JSON {
 				sk, docid, _, err = siSplitEntry(row, t)
 				if err != nil {
-					l.Errorf("Error (%v) in siSplitEntry for row %v, "+
+					scanLog.Errorf("Error (%v) in siSplitEntry for row %v, "+
 						"req = %s", err, row, d.p.req)
+					ref.Release()
 					d.CloseWithError(err)
 					break loop
 				}
 			} else {
 				err = c.ErrUnexpectedDataEncFmt
+				ref.Release()
 				d.CloseWithError(err)
 				break loop
 			}
@@ -495,7 +663,12 @@ loop:
 		if !d.p.req.isPrimary && !d.p.req.projectPrimaryKey {
 			docid = nil
 		}
-		err = d.WriteItem(sk, docid)
+		if inRow {
+			err = d.WriteItemRef(ref, sk, docid)
+		} else {
+			err = d.WriteItem(sk, docid)
+		}
+		ref.Release()
 		if err != nil {
 			break // TODO: Old code. Should it be ClosedWithError?
 		}
@@ -507,6 +680,7 @@ loop:
 func (d *IndexScanWriter) Routine() error {
 	var err error
 	var sk, pk []byte
+	var skRef, pkRef *p.BlockRef
 
 	defer func() {
 		// Send error to the client if not client requested cancel.
@@ -518,7 +692,7 @@ func (d *IndexScanWriter) Routine() error {
 
 loop:
 	for {
-		sk, err = d.ReadItem()
+		sk, skRef, err = d.ReadItemRef()
 		switch err {
 		case nil:
 		case p.ErrNoMoreItem:
@@ -528,12 +702,23 @@ loop:
 			break loop
 		}
 
-		pk, err = d.ReadItem()
+		pk, pkRef, err = d.ReadItemRef()
 		if err != nil {
+			skRef.Release()
 			return err
 		}
 
-		if err = d.w.Row(pk, sk); err != nil {
+		// Rows point into pipeline blocks until written to the client
+		if d.p.req.profile != nil {
+			t0 := time.Now()
+			err = d.w.RowRef(pk, sk, skRef, pkRef)
+			d.p.writeTime += time.Since(t0)
+		} else {
+			err = d.w.RowRef(pk, sk, skRef, pkRef)
+		}
+		skRef.Release()
+		pkRef.Release()
+		if err != nil {
 			return err
 		}
 
@@ -754,7 +939,8 @@ func distinctCompare(entryBytes1, entryBytes2 []byte, compareDocIDs bool) bool {
 	return false
 }
 
-func projectKeys(compositekeys [][]byte, key, buf []byte, r *ScanRequest, cktmp [][]byte) ([]byte, error) {
+func projectKeys(compositekeys [][]byte, key, buf []byte, r *ScanRequest, cktmp [][]byte,
+	arena *scanArena) ([]byte, error) {
 	var err error
 
 	if r.Indexprojection.entryKeysEmpty {
@@ -778,7 +964,7 @@ func projectKeys(compositekeys [][]byte, key, buf []byte, r *ScanRequest, cktmp
 		}
 	}
 
-	var keysToJoin [][]byte
+	keysToJoin := arena.keyList(len(r.Indexprojection.projectionKeys))
 	for i, projectKey := range r.Indexprojection.projectionKeys {
 		if projectKey {
 			keysToJoin = append(keysToJoin, compositekeys[i])
@@ -820,6 +1006,27 @@ func projectLeadingKey(compositekeys [][]byte, key []byte, buf *[]byte) ([]byte,
 	return *buf, nil
 }
 
+// distinctOnKey appends the encoded leading n keys of entry to out, which
+// identify its group for DISTINCT ON.
+func distinctOnKey(entry []byte, n int, tmp *[]byte, out []byte) ([]byte, error) {
+	if len(entry)*3 > cap(*tmp) {
+		*tmp = make([]byte, 0, len(entry)*3+RESIZE_PAD)
+	}
+
+	compositekeys, err := jsonEncoder.ExplodeArray(entry, (*tmp)[:0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n > len(compositekeys) {
+		n = len(compositekeys)
+	}
+	for _, key := range compositekeys[:n] {
+		out = append(out, key...)
+	}
+	return out, nil
+}
+
 /////////////////////////////////////////////////////////////////////////
 //
 // group by/aggregate implementation
@@ -889,6 +1096,43 @@ func computeGroupAggr(compositekeys [][]byte, decodedkeys value.Values, count in
 
 	var err error
 
+	compositekeys, decodedkeys, err = explodeGroupAggrKeys(compositekeys, decodedkeys, key, buf,
+		groupAggr, cktmp, dktmp, cachedEntry, p)
+	if err != nil {
+		return err
+	}
+
+	// SetCover for Annotated Value
+	setCoverForExprEval(groupAggr.av, groupAggr, decodedkeys, docid)
+
+	for i, gk := range groupAggr.Group {
+		err := computeGroupKey(groupAggr, gk, compositekeys, decodedkeys, docid, i, p, cachedEntry.Valid(), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, ak := range groupAggr.Aggrs {
+		err := computeAggrVal(groupAggr, ak, compositekeys, decodedkeys, docid, count, buf, i, p, cachedEntry.Valid(), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = aggrRes.AddNewGroup(groupAggr, p, cachedEntry.Valid())
+	return err
+
+}
+
+// explodeGroupAggrKeys returns the composite and decoded keys of key
+// needed by the group keys and aggregates, from the entry cache if key
+// is the cached entry.
+func explodeGroupAggrKeys(compositekeys [][]byte, decodedkeys value.Values, key, buf []byte,
+	groupAggr *GroupAggr, cktmp [][]byte, dktmp value.Values, cachedEntry *entryCache,
+	p *ScanPipeline) ([][]byte, value.Values, error) {
+
+	var err error
+
 	if groupAggr.IsPrimary {
 		compositekeys = make([][]byte, 1)
 		compositekeys[0] = key
@@ -915,7 +1159,7 @@ func computeGroupAggr(compositekeys [][]byte, decodedkeys value.Values, count in
 							p.req.explodePositions, p.req.decodePositions, p.req.explodeUpto)
 					}
 					if err != nil {
-						return err
+						return nil, nil, err
 					}
 				}
 				cachedEntry.Update(key, compositekeys, decodedkeys)
@@ -923,30 +1167,14 @@ func computeGroupAggr(compositekeys [][]byte, decodedkeys value.Values, count in
 		}
 	}
 
-	// SetCover for Annotated Value
-	setCoverForExprEval(groupAggr, decodedkeys, docid)
-
-	for i, gk := range groupAggr.Group {
-		err := computeGroupKey(groupAggr, gk, compositekeys, decodedkeys, docid, i, p, cachedEntry.Valid())
-		if err != nil {
-			return err
-		}
-	}
-
-	for i, ak := range groupAggr.Aggrs {
-		err := computeAggrVal(groupAggr, ak, compositekeys, decodedkeys, docid, count, buf, i, p, cachedEntry.Valid())
-		if err != nil {
-			return err
-		}
-	}
-
-	err = aggrRes.AddNewGroup(groupAggr, p, cachedEntry.Valid())
-	return err
-
+	return compositekeys, decodedkeys, nil
 }
 
+// computeGroupKey computes the group key at pos. exprVal is the value
+// of its expression if already evaluated, nil otherwise.
 func computeGroupKey(groupAggr *GroupAggr, gk *GroupKey, compositekeys [][]byte,
-	decodedkeys value.Values, docid []byte, pos int, p *ScanPipeline, cacheValid bool) error {
+	decodedkeys value.Values, docid []byte, pos int, p *ScanPipeline, cacheValid bool,
+	exprVal value.Value) error {
 
 	g := groupAggr.groups[pos]
 	if gk.KeyPos >= 0 {
@@ -957,6 +1185,8 @@ func computeGroupKey(groupAggr *GroupAggr, gk *GroupKey, compositekeys [][]byte,
 		var scalar value.Value
 		if gk.ExprValue != nil {
 			scalar = gk.ExprValue // It is a constant expression
+		} else if exprVal != nil {
+			scalar = exprVal
 		} else {
 			var err error
 			scalar, err = evaluateN1QLExpresssion(groupAggr, gk.Expr, decodedkeys, docid, p)
@@ -972,9 +1202,12 @@ func computeGroupKey(groupAggr *GroupAggr, gk *GroupKey, compositekeys [][]byte,
 	return nil
 }
 
+// computeAggrVal computes the aggregate at pos. exprVal is the value of
+// its expression if already evaluated, nil otherwise.
 func computeAggrVal(groupAggr *GroupAggr, ak *Aggregate,
 	compositekeys [][]byte, decodedkeys value.Values, docid []byte,
-	count int, buf []byte, pos int, p *ScanPipeline, cacheValid bool) error {
+	count int, buf []byte, pos int, p *ScanPipeline, cacheValid bool,
+	exprVal value.Value) error {
 
 	a := groupAggr.aggrs[pos]
 	if ak.KeyPos >= 0 {
@@ -988,6 +1221,8 @@ func computeAggrVal(groupAggr *GroupAggr, ak *Aggregate,
 		var scalar value.Value
 		if ak.ExprValue != nil {
 			scalar = ak.ExprValue // It is a constant expression
+		} else if exprVal != nil {
+			scalar = exprVal
 		} else {
 			var err error
 			scalar, err = evaluateN1QLExpresssion(groupAggr, ak.Expr, decodedkeys, docid, p)
@@ -1006,18 +1241,19 @@ func computeAggrVal(groupAggr *GroupAggr, ak *Aggregate,
 	return nil
 }
 
-func setCoverForExprEval(groupAggr *GroupAggr, decodedkeys value.Values, docid []byte) {
+func setCoverForExprEval(av value.AnnotatedValue, groupAggr *GroupAggr,
+	decodedkeys value.Values, docid []byte) {
 	if groupAggr.HasExpr {
 		if groupAggr.IsPrimary {
 			for _, ik := range groupAggr.DependsOnIndexKeys {
-				groupAggr.av.SetCover(groupAggr.IndexKeyNames[ik], value.NewValue(string(docid)))
+				av.SetCover(groupAggr.IndexKeyNames[ik], value.NewValue(string(docid)))
 			}
 		} else {
 			for _, ik := range groupAggr.DependsOnIndexKeys {
 				if int(ik) == len(decodedkeys) {
-					groupAggr.av.SetCover(groupAggr.IndexKeyNames[ik], value.NewValue(string(docid)))
+					av.SetCover(groupAggr.IndexKeyNames[ik], value.NewValue(string(docid)))
 				} else {
-					groupAggr.av.SetCover(groupAggr.IndexKeyNames[ik], decodedkeys[ik])
+					av.SetCover(groupAggr.IndexKeyNames[ik], decodedkeys[ik])
 				}
 			}
 		}
@@ -1208,7 +1444,7 @@ func projectEmptyResult(buf []byte, projection *Projection, groupAggr *GroupAggr
 		}
 
 		if buf, err = jsonEncoder.JoinArray(keysToJoin, buf); err != nil {
-			l.Errorf("ScanPipeline::projectEmptyResult join array error %v", err)
+			scanLog.Errorf("ScanPipeline::projectEmptyResult join array error %v", err)
 			return nil, err
 		}
 
@@ -1225,7 +1461,7 @@ func projectEmptyResult(buf []byte, projection *Projection, groupAggr *GroupAggr
 }
 
 func projectGroupAggr(buf []byte, projection *Projection,
-	aggrRes *aggrResult, isPrimary bool) ([]byte, error) {
+	aggrRes *aggrResult, isPrimary bool, arena *scanArena) ([]byte, error) {
 
 	var err error
 	var row *aggrRow
@@ -1243,13 +1479,13 @@ func projectGroupAggr(buf []byte, projection *Projection,
 		return nil, nil
 	}
 
-	var keysToJoin [][]byte
+	keysToJoin := arena.keyList(len(projection.projectGroupKeys))
 	for _, projGroup := range projection.projectGroupKeys {
 		if projGroup.grpKey {
 			gk := row.groups[projGroup.pos]
 			if gk.n1qlValue {
 				var newKey []byte
-				newKey, err = encodeN1qlVal(gk.obj)
+				newKey, err = encodeN1qlVal(gk.obj, arena)
 				if err != nil {
 					return nil, err
 				}
@@ -1257,9 +1493,9 @@ func projectGroupAggr(buf []byte, projection *Projection,
 			} else {
 				if isPrimary {
 					//TODO: will be optimized as part of overall pipeline optimization
-					val, err := encodeValue(string(gk.raw))
+					val, err := encodeValue(string(gk.raw), arena)
 					if err != nil {
-						l.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
+						scanLog.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
 						return nil, err
 					}
 					keysToJoin = append(keysToJoin, val)
@@ -1271,9 +1507,9 @@ func projectGroupAggr(buf []byte, projection *Projection,
 			if row.aggrs[projGroup.pos].fn.Type() == c.AGG_SUM ||
 				row.aggrs[projGroup.pos].fn.Type() == c.AGG_COUNT ||
 				row.aggrs[projGroup.pos].fn.Type() == c.AGG_COUNTN {
-				val, err := encodeValue(row.aggrs[projGroup.pos].fn.Value())
+				val, err := encodeValue(row.aggrs[projGroup.pos].fn.Value(), arena)
 				if err != nil {
-					l.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
+					scanLog.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
 					return nil, err
 				}
 				keysToJoin = append(keysToJoin, val)
@@ -1283,9 +1519,9 @@ func projectGroupAggr(buf []byte, projection *Projection,
 
 				case []byte:
 					if isPrimary && !isEncodedNull(v) {
-						val, err := encodeValue(string(v))
+						val, err := encodeValue(string(v), arena)
 						if err != nil {
-							l.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
+							scanLog.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
 							return nil, err
 						}
 						keysToJoin = append(keysToJoin, val)
@@ -1294,9 +1530,9 @@ func projectGroupAggr(buf []byte, projection *Projection,
 					}
 
 				case value.Value:
-					eval, err := encodeValue(v.ActualForIndex())
+					eval, err := encodeValue(v.ActualForIndex(), arena)
 					if err != nil {
-						l.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
+						scanLog.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
 						return nil, err
 					}
 					keysToJoin = append(keysToJoin, eval)
@@ -1306,7 +1542,7 @@ func projectGroupAggr(buf []byte, projection *Projection,
 	}
 
 	if buf, err = jsonEncoder.JoinArray(keysToJoin, buf); err != nil {
-		l.Errorf("ScanPipeline::projectGroupAggr join array error %v", err)
+		scanLog.Errorf("ScanPipeline::projectGroupAggr join array error %v", err)
 		return nil, err
 	}
 
@@ -1325,14 +1561,14 @@ func unmarshalValue(dec []byte) (interface{}, error) {
 	return actualVal, nil
 }
 
-func encodeValue(raw interface{}) ([]byte, error) {
+func encodeValue(raw interface{}, arena *scanArena) ([]byte, error) {
 
 	jsonraw, err := json.Marshal(raw)
 	if err != nil {
 		return nil, err
 	}
 
-	encbuf := make([]byte, 3*len(jsonraw)+collatejson.MinBufferSize)
+	encbuf := arena.alloc(3*len(jsonraw) + collatejson.MinBufferSize)
 	encval, err := jsonEncoder.Encode(jsonraw, encbuf)
 	if err != nil {
 		return nil, err
@@ -1341,9 +1577,9 @@ func encodeValue(raw interface{}) ([]byte, error) {
 	return encval, nil
 }
 
-func encodeN1qlVal(val value.Value) ([]byte, error) {
-	encodeBuf := make([]byte, 1024)
-	encoded, err := jsonEncoder.EncodeN1QLValue(val, encodeBuf[:0])
+func encodeN1qlVal(val value.Value, arena *scanArena) ([]byte, error) {
+	encodeBuf := arena.alloc(1024)
+	encoded, err := jsonEncoder.EncodeN1QLValue(val, encodeBuf)
 	if err != nil && err.Error() == collatejson.ErrorOutputLen.Error() {
 		valBytes, e1 := val.MarshalJSON()
 		if e1 != nil {
@@ -1400,11 +1636,11 @@ func (e *entryCache) EqualsEntry(other []byte) bool {
 
 	defer func() {
 		if r := recover(); r != nil {
-			l.Fatalf("EntryCache - panic detected")
+			scanLog.Fatalf("EntryCache - panic detected")
 			e1 := secondaryIndexEntry(e.entry)
 			e2 := secondaryIndexEntry(other)
-			l.Fatalf("Cached - Raw %v Entry %s", e1.Bytes(), e1)
-			l.Fatalf("Other - Raw %v Entry %s", e2.Bytes(), e2)
+			scanLog.Fatalf("Cached - Raw %v Entry %s", e1.Bytes(), e1)
+			scanLog.Fatalf("Other - Raw %v Entry %s", e2.Bytes(), e2)
 			panic(r)
 		}
 	}()
@@ -0,0 +1,56 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDistinctOnKey(t *testing.T) {
+	entry := func(key, docid string) []byte {
+		e, err := NewSecondaryIndexEntry2([]byte(key), []byte(docid), false, 1, nil, nil,
+			false, nil, keySizeConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	var tmp []byte
+	group := func(e []byte, n int) []byte {
+		key, err := distinctOnKey(e, n, &tmp, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	e1 := entry(`["sensor1",300,"x"]`, "doc1")
+	e2 := entry(`["sensor1",200,"y"]`, "doc2")
+	e3 := entry(`["sensor2",300,"x"]`, "doc3")
+
+	if !bytes.Equal(group(e1, 1), group(e2, 1)) {
+		t.Fatalf("expected the same group for the same leading key")
+	}
+	if bytes.Equal(group(e1, 1), group(e3, 1)) {
+		t.Fatalf("expected different groups for different leading keys")
+	}
+	if bytes.Equal(group(e1, 2), group(e2, 2)) {
+		t.Fatalf("expected different groups on two keys")
+	}
+	if !bytes.Equal(group(e1, 5), group(entry(`["sensor1",300,"x"]`, "doc4"), 5)) {
+		t.Fatalf("expected the same group on all keys for equal keys")
+	}
+
+	// Fields are terminated, so a key is not a prefix of another group
+	if bytes.Equal(group(entry(`["ab","c"]`, "doc5"), 2), group(entry(`["a","bc"]`, "doc6"), 2)) {
+		t.Fatalf("expected different groups for different keys")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Scans of all indexes share the goroutines and storage readers of the
+// indexer, so heavy scans on one index can starve the scans of the others.
+// With scan.pools.mode set to "index" or "bucket", the scans of each index,
+// or of each bucket, run in their own pool of scan.pools.size concurrent
+// scans, scaled by the priority of the index or bucket in scan.pools.priority:
+// a JSON object from "bucket.scope.collection.index" or "bucket" to "high"
+// (twice the size), "normal" or "low" (half the size). A scan finding its
+// pool full waits up to scan.pools.wait_timeout for a slot, and is then
+// rejected with a retry-after error like the scan limiter.
+
+const (
+	scanPoolModeIndex  = "index"
+	scanPoolModeBucket = "bucket"
+)
+
+var scanPoolPriorities = map[string]float64{
+	"high":   2,
+	"normal": 1,
+	"low":    0.5,
+}
+
+type scanPool struct {
+	size   int
+	slots  chan struct{}
+	active int64 // protected by scanPools.mu
+}
+
+// scanPools holds the scan pool of each index or bucket.
+type scanPools struct {
+	mu    sync.Mutex
+	pools map[string]*scanPool
+
+	// scan.pools.priority, parsed
+	priorityValue string
+	priorities    map[string]string
+}
+
+func newScanPools() *scanPools {
+	return &scanPools{pools: make(map[string]*scanPool)}
+}
+
+// pool returns the pool of key, replaced by a new pool if its size changed.
+// Scans of a replaced pool release their slots to it.
+func (p *scanPools) pool(key string, size int) *scanPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool, ok := p.pools[key]
+	if !ok || pool.size != size {
+		pool = &scanPool{size: size, slots: make(chan struct{}, size)}
+		p.pools[key] = pool
+	}
+	return pool
+}
+
+// Acquire admits a scan to the pool of key of the given size, waiting up to
+// wait for a slot. It returns whether the scan had to wait. Admitted scans
+// must call the returned release function once done.
+func (p *scanPools) Acquire(key string, size int, wait time.Duration) (func(), bool, error) {
+	pool := p.pool(key, size)
+
+	waited := false
+	select {
+	case pool.slots <- struct{}{}:
+	default:
+		waited = true
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case pool.slots <- struct{}{}:
+		case <-timer.C:
+			// Retry after a typical short scan
+			return nil, waited, &ScanThrottledError{Tenant: key, RetryAfter: 10 * time.Millisecond}
+		}
+	}
+
+	p.mu.Lock()
+	pool.active++
+	p.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			pool.active--
+			p.mu.Unlock()
+			<-pool.slots
+		})
+	}, waited, nil
+}
+
+// Active returns the number of scans admitted to the pool of key.
+func (p *scanPools) Active(key string) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pool, ok := p.pools[key]; ok {
+		return pool.active
+	}
+	return 0
+}
+
+// scanPoolSize returns the pool size for the index or bucket with the
+// given priority keys, the most specific first.
+func scanPoolSize(size int, priorities map[string]string, keys ...string) int {
+	weight := scanPoolPriorities["normal"]
+	for _, key := range keys {
+		if priority, ok := priorities[key]; ok {
+			if w, ok := scanPoolPriorities[strings.ToLower(priority)]; ok {
+				weight = w
+			}
+			break
+		}
+	}
+
+	if n := int(float64(size) * weight); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Priorities returns the parsed scan.pools.priority value.
+func (p *scanPools) Priorities(value string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.priorities == nil || value != p.priorityValue {
+		priorities, err := parseScanPoolPriorities(value)
+		if err != nil {
+			return nil, err
+		}
+		p.priorityValue, p.priorities = value, priorities
+	}
+	return p.priorities, nil
+}
+
+// parseScanPoolPriorities parses scan.pools.priority.
+func parseScanPoolPriorities(value string) (map[string]string, error) {
+	priorities := make(map[string]string)
+	if value == "" {
+		return priorities, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &priorities); err != nil {
+		return nil, err
+	}
+	for key, priority := range priorities {
+		if _, ok := scanPoolPriorities[strings.ToLower(priority)]; !ok {
+			return nil, fmt.Errorf("invalid priority %v for %v", priority, key)
+		}
+	}
+	return priorities, nil
+}
+
+// acquireScanPool admits req to the scan pool of its index or bucket.
+func (s *scanCoordinator) acquireScanPool(req *ScanRequest) (func(), error) {
+	cfg := s.config.Load()
+
+	defn := req.IndexInst.Defn
+	bucketKey := defn.Bucket
+	indexKey := strings.Join([]string{defn.Bucket, defn.Scope, defn.Collection, defn.Name}, ".")
+
+	var key string
+	var priorityKeys []string
+	switch cfg["scan.pools.mode"].String() {
+	case scanPoolModeIndex:
+		key = fmt.Sprintf("%v", req.IndexInstId)
+		priorityKeys = []string{indexKey, bucketKey}
+	case scanPoolModeBucket:
+		key = bucketKey
+		priorityKeys = []string{bucketKey}
+	default:
+		return func() {}, nil
+	}
+
+	priorities, err := s.pools.Priorities(cfg["scan.pools.priority"].String())
+	if err != nil {
+		scanLog.Errorf("%s scan.pools.priority ignored: %v", req.LogPrefix, err)
+		priorities = nil
+	}
+
+	size := scanPoolSize(cfg["scan.pools.size"].Int(), priorities, priorityKeys...)
+	wait := time.Duration(cfg["scan.pools.wait_timeout"].Int()) * time.Millisecond
+
+	release, waited, err := s.pools.Acquire(key, size, wait)
+	if req.Stats != nil {
+		req.Stats.scanPoolSize.Set(int64(size))
+		if waited {
+			req.Stats.numScanPoolWaits.Add(1)
+		}
+		if err != nil {
+			req.Stats.numScanPoolRejects.Add(1)
+		}
+	}
+	if err != nil {
+		scanLog.Verbosef("%s %v", req.LogPrefix, logging.TagUD(err))
+		return nil, err
+	}
+
+	setActive := func() {
+		if req.Stats != nil {
+			req.Stats.scanPoolActive.Set(s.pools.Active(key))
+		}
+	}
+	setActive()
+
+	return func() {
+		release()
+		setActive()
+	}, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanPoolsAcquire(t *testing.T) {
+	p := newScanPools()
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, waited, err := p.Acquire("idx1", 2, time.Millisecond)
+		if err != nil || waited {
+			t.Fatalf("expected scan %v admitted, got waited %v err %v", i, waited, err)
+		}
+		releases = append(releases, release)
+	}
+	if active := p.Active("idx1"); active != 2 {
+		t.Fatalf("expected 2 active scans, got %v", active)
+	}
+
+	// The pool of idx1 is full, idx2 is not affected
+	if _, waited, err := p.Acquire("idx1", 2, time.Millisecond); err == nil || !waited {
+		t.Fatalf("expected scan rejected after waiting, got waited %v err %v", waited, err)
+	} else if _, ok := err.(*ScanThrottledError); !ok {
+		t.Fatalf("expected ScanThrottledError, got %T", err)
+	}
+	release2, _, err := p.Acquire("idx2", 2, time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected scan of another pool admitted, got %v", err)
+	}
+	release2()
+
+	// A waiting scan gets the slot of a released one
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		releases[0]()
+	}()
+	release, waited, err := p.Acquire("idx1", 2, time.Minute)
+	if err != nil || !waited {
+		t.Fatalf("expected scan admitted after waiting, got waited %v err %v", waited, err)
+	}
+
+	// Releasing twice frees one slot
+	release()
+	release()
+	if active := p.Active("idx1"); active != 1 {
+		t.Fatalf("expected 1 active scan, got %v", active)
+	}
+	releases[1]()
+}
+
+func TestScanPoolSize(t *testing.T) {
+	priorities, err := parseScanPoolPriorities(`{"b1": "low", "b1.s1.c1.idx1": "High"}`)
+	if err != nil {
+		t.Fatalf("expected priorities parsed, got %v", err)
+	}
+
+	if size := scanPoolSize(32, priorities, "b1.s1.c1.idx1", "b1"); size != 64 {
+		t.Fatalf("expected index priority first, got size %v", size)
+	}
+	if size := scanPoolSize(32, priorities, "b1.s1.c1.idx2", "b1"); size != 16 {
+		t.Fatalf("expected bucket priority, got size %v", size)
+	}
+	if size := scanPoolSize(32, priorities, "b2.s1.c1.idx1", "b2"); size != 32 {
+		t.Fatalf("expected normal priority, got size %v", size)
+	}
+	if size := scanPoolSize(1, priorities, "b1"); size != 1 {
+		t.Fatalf("expected pool of at least 1 scan, got size %v", size)
+	}
+
+	if _, err := parseScanPoolPriorities(`{"b1": "urgent"}`); err == nil {
+		t.Fatalf("expected invalid priority rejected")
+	}
+	if _, err := parseScanPoolPriorities(`["b1"]`); err == nil {
+		t.Fatalf("expected invalid JSON rejected")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// ScanProfile records resource usage of a single scan. It is collected
+// only when the client sets the profile flag on the scan request, and is
+// retrievable by RequestId through the /scanProfile endpoint. A request
+// may scan several indexes or partitions, each scan has its own profile.
+type ScanProfile struct {
+	RequestId    string               `json:"requestId"`
+	ScanId       uint64               `json:"scanId"`
+	DefnId       uint64               `json:"defnId"`
+	InstId       common.IndexInstId   `json:"instId"`
+	PartitionIds []common.PartitionId `json:"partitionIds,omitempty"`
+	IndexName    string               `json:"indexName"`
+	Bucket       string               `json:"bucket"`
+	StartTime    int64                `json:"startTime"`
+
+	// All durations are in nanoseconds.
+	TotalTime        int64 `json:"totalTime"`
+	SnapshotWaitTime int64 `json:"snapshotWaitTime"`
+	// Wall time spent iterating storage, filtering and projecting rows,
+	// excluding time blocked on downstream pipeline stages.
+	ActiveTime int64 `json:"activeTime"`
+	// CPU time spent iterating storage, filtering and projecting rows, 0
+	// where the CPU time of a thread is not available.
+	CPUTime int64 `json:"cpuTime"`
+	// Time spent writing rows to the client connection.
+	WriteTime int64 `json:"writeTime"`
+
+	BytesRead    uint64 `json:"bytesRead"`
+	RowsScanned  uint64 `json:"rowsScanned"`
+	RowsFiltered uint64 `json:"rowsFiltered"`
+	RowsReturned uint64 `json:"rowsReturned"`
+
+	// Pooled key buffers acquired and buffers grown beyond pool size.
+	BufAllocs int `json:"bufAllocs"`
+	BufGrows  int `json:"bufGrows"`
+
+	Error string `json:"error,omitempty"`
+}
+
+func newScanProfile(r *ScanRequest) *ScanProfile {
+	return &ScanProfile{
+		RequestId: r.RequestId,
+		ScanId:    r.ScanId,
+		DefnId:    r.DefnID,
+		StartTime: time.Now().UnixNano(),
+	}
+}
+
+// scanProfileKey identifies the profile of a scan: the scans of a request
+// are told apart by index instance, partitions and scan seqno.
+type scanProfileKey struct {
+	requestId  string
+	instId     common.IndexInstId
+	partitions string
+	scanId     uint64
+}
+
+func (p *ScanProfile) key() scanProfileKey {
+	return scanProfileKey{
+		requestId:  p.RequestId,
+		instId:     p.InstId,
+		partitions: fmt.Sprint(p.PartitionIds),
+		scanId:     p.ScanId,
+	}
+}
+
+// scanProfileStore retains the most recent scan profiles, evicting the
+// oldest once maxEntries is reached.
+type scanProfileStore struct {
+	mu       sync.Mutex
+	profiles map[scanProfileKey]*ScanProfile
+	order    []scanProfileKey
+}
+
+func newScanProfileStore() *scanProfileStore {
+	return &scanProfileStore{
+		profiles: make(map[scanProfileKey]*ScanProfile),
+	}
+}
+
+func (s *scanProfileStore) Add(p *ScanProfile, maxEntries int) {
+	if p.RequestId == "" || maxEntries <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := p.key()
+	if _, ok := s.profiles[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.profiles[key] = p
+
+	for len(s.order) > maxEntries {
+		delete(s.profiles, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get returns the profiles of the scans of requestId, oldest first.
+func (s *scanProfileStore) Get(requestId string) []*ScanProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []*ScanProfile
+	for _, key := range s.order {
+		if key.requestId == requestId {
+			list = append(list, s.profiles[key])
+		}
+	}
+	return list
+}
+
+func (s *scanProfileStore) List() []*ScanProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*ScanProfile, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.profiles[id])
+	}
+	return list
+}
+
+// finishProfile completes the profile of req, if any, and adds it to the
+// profile store.
+func (s *scanCoordinator) finishProfile(req *ScanRequest) {
+	p := req.profile
+	if p == nil {
+		return
+	}
+
+	p.InstId = req.IndexInstId
+	p.PartitionIds = req.PartitionIds
+	p.IndexName = req.IndexName
+	p.Bucket = req.Bucket
+	p.TotalTime = time.Now().UnixNano() - p.StartTime
+	p.BufAllocs = len(req.keyBufList)
+
+	cfg := s.config.Load()
+	s.profiles.Add(p, cfg["scan.profile.max_entries"].Int())
+}
+
+func (s *scanCoordinator) RegisterRestEndpoints() {
+	mux := GetHTTPMux()
+	mux.HandleFunc("/scanProfile", s.handleScanProfileReq)
+	mux.HandleFunc("/scanSession", s.handleScanSessionReq)
+	mux.HandleFunc("/internal/indexExport", s.handleIndexExportReq)
+	mux.HandleFunc("/internal/replicaSummary", s.handleReplicaSummaryReq)
+	mux.HandleFunc("/internal/indexAudit", s.handleIndexAuditReq)
+}
+
+// handleScanProfileReq returns the profiles of the scans of ?requestId=, or
+// all the retained profiles when no requestId is given.
+func (s *scanCoordinator) handleScanProfileReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.n1ql.meta!read"}, r, w,
+		"ScanCoordinator::handleScanProfileReq") {
+		return
+	}
+
+	var resp interface{}
+	if requestId := r.URL.Query().Get("requestId"); requestId != "" {
+		profiles := s.profiles.Get(requestId)
+		if len(profiles) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Scan profile not found\n"))
+			return
+		}
+		resp = profiles
+	} else {
+		resp = s.profiles.List()
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanProfileStoreEviction(t *testing.T) {
+	s := newScanProfileStore()
+	for i := 0; i < 5; i++ {
+		s.Add(&ScanProfile{RequestId: fmt.Sprintf("req%d", i)}, 3)
+	}
+	s.Add(&ScanProfile{}, 3) // no RequestId, not retained
+
+	list := s.List()
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 profiles, found %v", len(list))
+	}
+	if list[0].RequestId != "req2" || list[2].RequestId != "req4" {
+		t.Errorf("Unexpected profiles retained %v %v", list[0].RequestId, list[2].RequestId)
+	}
+	if len(s.Get("req1")) != 0 {
+		t.Errorf("Expected req1 to be evicted")
+	}
+	if len(s.Get("req3")) != 1 {
+		t.Errorf("Expected req3 to be retained")
+	}
+}
+
+func TestScanProfileStoreScansOfRequest(t *testing.T) {
+	s := newScanProfileStore()
+	s.Add(&ScanProfile{RequestId: "req", ScanId: 1, InstId: 10, PartitionIds: []common.PartitionId{1}}, 10)
+	s.Add(&ScanProfile{RequestId: "req", ScanId: 2, InstId: 10, PartitionIds: []common.PartitionId{2}}, 10)
+	s.Add(&ScanProfile{RequestId: "req", ScanId: 3, InstId: 20}, 10)
+	s.Add(&ScanProfile{RequestId: "other", ScanId: 4, InstId: 10}, 10)
+
+	profiles := s.Get("req")
+	if len(profiles) != 3 {
+		t.Fatalf("Expected 3 profiles of req, found %v", len(profiles))
+	}
+	for i, p := range profiles {
+		if p.ScanId != uint64(i+1) {
+			t.Errorf("Unexpected profile %v of req, scanId %v", i, p.ScanId)
+		}
+	}
+}
+
+func TestThreadCPUTime(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cpu0, ok := threadCPUTime()
+	if !ok {
+		t.Skip("CPU time of threads not available")
+	}
+	x := 0
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		x++
+	}
+	cpu1, _ := threadCPUTime()
+	if d := cpu1 - cpu0; d < 10*time.Millisecond || d > time.Second {
+		t.Errorf("Expected about 50ms of CPU time for %v iterations, found %v", x, d)
+	}
+}
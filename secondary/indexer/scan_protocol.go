@@ -13,18 +13,24 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 	p "github.com/couchbase/indexing/secondary/pipeline"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/couchbase/indexing/secondary/transport"
 	"github.com/golang/protobuf/proto"
 	"net"
+	"time"
 )
 
 type ScanResponseWriter interface {
 	Error(err error) error
 	Stats(rows, unique uint64, min, max []byte) error
 	Count(count uint64) error
+	Estimate(count, avgEntrySize uint64) error
 	RawBytes([]byte) error
 	Row(pk, sk []byte) error
+	RowRef(pk, sk []byte, refs ...*p.BlockRef) error
 	Done() error
 	Helo() error
+	SnapshotSeqnos(ts *common.TsVbuuid) error
+	SnapshotId(id uint64) error
 }
 
 type protoResponseWriter struct {
@@ -33,7 +39,10 @@ type protoResponseWriter struct {
 	encBuf     *[]byte
 	rowBuf     *[]byte
 	rowEntries []*protobuf.IndexEntry
+	rowRefs    []*p.BlockRef // blocks retained by rows added with RowRef
 	rowSize    int
+	connCtx    *ConnectionContext   // compressing row batches, if negotiated
+	groupHints *protobuf.GroupHints // sent with row batches, if set
 }
 
 func NewProtoWriter(t ScanReqType, conn net.Conn) *protoResponseWriter {
@@ -45,26 +54,122 @@ func NewProtoWriter(t ScanReqType, conn net.Conn) *protoResponseWriter {
 	}
 }
 
+// SetConnectionContext sets the context of the connection written to.
+func (w *protoResponseWriter) SetConnectionContext(ctx *ConnectionContext) {
+	w.connCtx = ctx
+}
+
+// SetGroupHints has group hints sent with each batch of rows of a scan
+// with partial aggregates. groupsRepeat tells whether a group may be in
+// more than one row, otherwise each row is the final aggregate of its
+// group.
+func (w *protoResponseWriter) SetGroupHints(groupsRepeat bool) {
+	w.groupHints = &protobuf.GroupHints{GroupsRepeat: proto.Bool(groupsRepeat)}
+}
+
+// rowGroupHints returns the group hints of the collected rows.
+func (w *protoResponseWriter) rowGroupHints() *protobuf.GroupHints {
+	if w.groupHints == nil {
+		return nil
+	}
+
+	closedRows := 0
+	if !w.groupHints.GetGroupsRepeat() {
+		closedRows = len(w.rowEntries)
+	}
+	return &protobuf.GroupHints{
+		GroupsRepeat: w.groupHints.GroupsRepeat,
+		ClosedRows:   proto.Uint32(uint32(closedRows)),
+	}
+}
+
+// writeRows writes out a batch of rows, compressed as negotiated on the
+// connection.
+func (w *protoResponseWriter) writeRows(res *protobuf.ResponseStream) error {
+	ctx := w.connCtx
+	if ctx == nil || ctx.compression == transport.CompressionNone {
+		return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+	}
+
+	size, zsize, zbuf, err := protobuf.EncodeCompressAndWrite(w.conn, *w.encBuf,
+		ctx.zbuf, res, ctx.compression)
+	ctx.zbuf = zbuf
+	ctx.addRowBytes(size, zsize)
+	return err
+}
+
 func (w *protoResponseWriter) writeLen(l int) error {
 	binary.LittleEndian.PutUint16((*w.encBuf)[:2], uint16(l))
 	_, err := w.conn.Write((*w.rowBuf)[:2])
 	return err
 }
 
+// Suggested backoff for the retriable errors of scans
+const (
+	scanRollbackRetryAfter  = 1 * time.Second
+	scanBootstrapRetryAfter = 5 * time.Second
+)
+
+// newProtoError returns the Error message of err, with its code and retry
+// hint.
+func newProtoError(err error) *protobuf.Error {
+	var retryAfter time.Duration
+
+	code := scanErrorCode(err)
+	switch code {
+	case protobuf.ErrorCode_QuotaExceeded:
+		if e, ok := err.(*ScanThrottledError); ok {
+			retryAfter = e.RetryAfter
+		}
+	case protobuf.ErrorCode_RollbackInProgress:
+		retryAfter = scanRollbackRetryAfter
+	case protobuf.ErrorCode_IndexerBootstrap:
+		retryAfter = scanBootstrapRetryAfter
+	}
+	return protobuf.NewError(err, code, retryAfter)
+}
+
+// scanErrorCode returns the code of err, for clients to check instead of
+// its text.
+func scanErrorCode(err error) protobuf.ErrorCode {
+	if _, ok := err.(*ScanThrottledError); ok {
+		return protobuf.ErrorCode_QuotaExceeded
+	}
+
+	switch err {
+	case ErrIndexRollback, ErrIndexRollbackOrBootstrap:
+		return protobuf.ErrorCode_RollbackInProgress
+	case common.ErrIndexNotReady:
+		return protobuf.ErrorCode_IndexNotReady
+	case common.ErrIndexNotFound:
+		return protobuf.ErrorCode_IndexNotFound
+	case common.ErrScanThrottled:
+		return protobuf.ErrorCode_QuotaExceeded
+	case common.ErrScanTimedOut:
+		return protobuf.ErrorCode_Timeout
+	case common.ErrAuthMissing, common.ErrScanNotAuthorized:
+		return protobuf.ErrorCode_Auth
+	case common.ErrClientCancel:
+		return protobuf.ErrorCode_ClientCancel
+	case common.ErrIndexerInBootstrap:
+		return protobuf.ErrorCode_IndexerBootstrap
+	}
+	return protobuf.ErrorCode_Unknown
+}
+
 func (w *protoResponseWriter) Error(err error) error {
 	var res interface{}
-	protoErr := &protobuf.Error{Error: proto.String(err.Error())}
+	protoErr := newProtoError(err)
 
 	// Drop all collected rows
-	w.rowEntries = nil
-	w.rowSize = 0
+	w.releaseRows()
 
 	switch w.scanType {
 	case StatsReq:
 		res = &protobuf.StatisticsResponse{
 			Err: protoErr,
 		}
-	case CountReq, MultiScanCountReq:
+	case CountReq, MultiScanCountReq, EstimateReq:
 		res = &protobuf.CountResponse{
 			Count: proto.Int64(0), Err: protoErr,
 		}
@@ -94,6 +199,28 @@ func (w *protoResponseWriter) Helo() error {
 	res := &protobuf.HeloResponse{
 		Version: proto.Uint32(common.INDEXER_CUR_VERSION),
 	}
+	if w.connCtx != nil {
+		res.Compression = proto.Uint32(uint32(w.connCtx.compression))
+	}
+
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
+// SnapshotSeqnos sends the seqnos of the scanned snapshot ahead of its rows.
+func (w *protoResponseWriter) SnapshotSeqnos(ts *common.TsVbuuid) error {
+	res := &protobuf.ResponseStream{
+		SnapshotSeqnos: protobuf.EncodeSnapshotSeqnos(ts),
+	}
+
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
+// SnapshotId sends the id of the scanned snapshot ahead of its rows, for
+// clients to tell whether results they cached are still current.
+func (w *protoResponseWriter) SnapshotId(id uint64) error {
+	res := &protobuf.ResponseStream{
+		SnapshotId: proto.Uint64(id),
+	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 }
@@ -106,6 +233,15 @@ func (w *protoResponseWriter) Count(c uint64) error {
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 }
 
+func (w *protoResponseWriter) Estimate(c, avgEntrySize uint64) error {
+	res := &protobuf.CountResponse{
+		Count:        proto.Int64(int64(c)),
+		AvgEntrySize: proto.Int64(int64(avgEntrySize)),
+	}
+
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
 func (w *protoResponseWriter) RawBytes(b []byte) error {
 	err := w.writeLen(len(b))
 	if err != nil {
@@ -116,17 +252,53 @@ func (w *protoResponseWriter) RawBytes(b []byte) error {
 	return err
 }
 
-func (w *protoResponseWriter) Row(pk, sk []byte) error {
+// flushRows writes out the collected rows if adding l bytes would take
+// them over the size of a response.
+func (w *protoResponseWriter) flushRows(l int) error {
+	if w.rowSize == 0 || w.rowSize+l <= len(*w.rowBuf) {
+		return nil
+	}
 
-	if w.rowSize != 0 && w.rowSize+len(pk)+len(sk) > len(*w.rowBuf) {
-		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries}
-		err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
-		if err != nil {
-			return err
-		}
+	res := &protobuf.ResponseStream{IndexEntries: w.rowEntries, GroupHints: w.rowGroupHints()}
+	err := w.writeRows(res)
+	w.releaseRows()
+	return err
+}
+
+// addRow appends a row, reusing the entries of earlier responses.
+func (w *protoResponseWriter) addRow(pk, sk []byte) {
+	n := len(w.rowEntries)
+	if n < cap(w.rowEntries) && w.rowEntries[:n+1][n] != nil {
+		w.rowEntries = w.rowEntries[:n+1]
+	} else {
+		w.rowEntries = append(w.rowEntries, new(protobuf.IndexEntry))
+	}
+
+	row := w.rowEntries[n]
+	row.EntryKey = sk
+	row.PrimaryKey = pk
+}
+
+// releaseRows drops the collected rows and the blocks they retain.
+func (w *protoResponseWriter) releaseRows() {
+	for _, row := range w.rowEntries {
+		row.EntryKey = nil
+		row.PrimaryKey = nil
+	}
+	for i, ref := range w.rowRefs {
+		ref.Release()
+		w.rowRefs[i] = nil
+	}
+
+	w.rowEntries = w.rowEntries[:0]
+	w.rowRefs = w.rowRefs[:0]
+	w.rowSize = 0
+}
 
-		w.rowSize = 0
-		w.rowEntries = nil
+func (w *protoResponseWriter) Row(pk, sk []byte) error {
+
+	if err := w.flushRows(len(pk) + len(sk)); err != nil {
+		return err
 	}
 
 	if w.rowSize == 0 && len(pk)+len(sk) > cap(*w.rowBuf) {
@@ -141,24 +313,43 @@ func (w *protoResponseWriter) Row(pk, sk []byte) error {
 
 	copy(pkCopy, pk)
 	copy(skCopy, sk)
-	row := &protobuf.IndexEntry{
-		EntryKey:   skCopy,
-		PrimaryKey: pkCopy,
-	}
 
 	// TODO: remove below line
 	w.rowSize += len(sk) + len(pk)
-	w.rowEntries = append(w.rowEntries, row)
+	w.addRow(pkCopy, skCopy)
+	return nil
+}
+
+// RowRef adds a row whose pk and sk point into pipeline blocks, without
+// copying them. The blocks are retained until the row is written out.
+func (w *protoResponseWriter) RowRef(pk, sk []byte, refs ...*p.BlockRef) error {
+
+	if err := w.flushRows(len(pk) + len(sk)); err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if n := len(w.rowRefs); n > 0 && w.rowRefs[n-1] == ref {
+			continue
+		}
+		ref.Retain()
+		w.rowRefs = append(w.rowRefs, ref)
+	}
+
+	// Accounted as by Row, so that responses are of the same size
+	w.rowSize += 2 * (len(sk) + len(pk))
+	w.addRow(pk, sk)
 	return nil
 }
 
 func (w *protoResponseWriter) Done() error {
 	defer p.PutBlock(w.encBuf)
 	defer p.PutBlock(w.rowBuf)
+	defer w.releaseRows()
 
 	if (w.scanType == ScanReq || w.scanType == ScanAllReq || w.scanType == FastCountReq) && w.rowSize > 0 {
-		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries}
-		err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries, GroupHints: w.rowGroupHints()}
+		err := w.writeRows(res)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,169 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	p "github.com/couchbase/indexing/secondary/pipeline"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/snappy"
+)
+
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+func (discardConn) LocalAddr() net.Addr         { return nil }
+func (discardConn) RemoteAddr() net.Addr        { return nil }
+
+func TestSnapshotSeqnos(t *testing.T) {
+	ts := common.NewTsVbuuid("default", 1024)
+	for vb := range ts.Seqnos {
+		ts.Seqnos[vb] = uint64(100000 + vb)
+		ts.Vbuuids[vb] = uint64(vb%4) << 40
+		ts.Snapshots[vb] = [2]uint64{uint64(99000 + vb), uint64(100000 + vb)}
+	}
+
+	data := protobuf.EncodeSnapshotSeqnos(ts)
+	if len(data) >= 1024*4*8 {
+		t.Fatalf("expected seqnos to be compressed, got %v bytes", len(data))
+	}
+
+	decoded, err := protobuf.DecodeSnapshotSeqnos(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for vb := range ts.Seqnos {
+		if decoded.Seqnos[vb] != ts.Seqnos[vb] || decoded.Vbuuids[vb] != ts.Vbuuids[vb] ||
+			decoded.Snapshots[vb] != ts.Snapshots[vb] {
+			t.Fatalf("mismatch at vbucket %v", vb)
+		}
+	}
+
+	if _, err := protobuf.DecodeSnapshotSeqnos(data[:len(data)/2]); err == nil {
+		t.Fatalf("expected error decoding truncated seqnos")
+	}
+
+	// Seqnos of the earlier version: version, vbuckets, then seqno, vbuuid
+	// and snapshot markers of each vbucket
+	decoded, err = protobuf.DecodeSnapshotSeqnos(snappy.Encode(nil, []byte{1, 1, 10, 20, 5, 15}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Seqnos[0] != 10 || decoded.Vbuuids[0] != 20 || decoded.Snapshots[0] != [2]uint64{5, 15} {
+		t.Fatalf("unexpected seqnos %v", decoded)
+	}
+}
+
+const benchRows = 10000
+
+func benchmarkProtoWriter(b *testing.B, useRef bool) {
+	block := p.GetBlock()
+	ref := p.NewBlockRef(block)
+	defer ref.Release()
+
+	sk := (*block)[:100]
+	copy(sk, bytes.Repeat([]byte{'k'}, len(sk)))
+	pk := (*block)[100:120]
+	copy(pk, bytes.Repeat([]byte{'d'}, len(pk)))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(benchRows * (len(sk) + len(pk))))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := NewProtoWriter(ScanReq, discardConn{})
+		for j := 0; j < benchRows; j++ {
+			var err error
+			if useRef {
+				err = w.RowRef(pk, sk, ref)
+			} else {
+				err = w.Row(pk, sk)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := w.Done(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProtoWriterRow(b *testing.B) {
+	benchmarkProtoWriter(b, false)
+}
+
+func BenchmarkProtoWriterRowRef(b *testing.B) {
+	benchmarkProtoWriter(b, true)
+}
+
+func TestScanErrorCode(t *testing.T) {
+	tests := []struct {
+		err        error
+		code       protobuf.ErrorCode
+		retriable  bool
+		retryAfter time.Duration
+	}{
+		{ErrIndexRollback, protobuf.ErrorCode_RollbackInProgress, true, scanRollbackRetryAfter},
+		{ErrIndexRollbackOrBootstrap, protobuf.ErrorCode_RollbackInProgress, true, scanRollbackRetryAfter},
+		{common.ErrIndexNotReady, protobuf.ErrorCode_IndexNotReady, true, 0},
+		{common.ErrScanTimedOut, protobuf.ErrorCode_Timeout, true, 0},
+		{common.ErrScanNotAuthorized, protobuf.ErrorCode_Auth, false, 0},
+		{&ScanThrottledError{Tenant: "bucket:b1", RetryAfter: 20 * time.Millisecond},
+			protobuf.ErrorCode_QuotaExceeded, true, 20 * time.Millisecond},
+		{ErrInternal, protobuf.ErrorCode_Unknown, false, 0},
+	}
+
+	for _, test := range tests {
+		// clients get the code and retry hint along with the error text
+		err := newProtoError(test.err).Err()
+		if err.Error() != test.err.Error() || protobuf.GetErrorCode(err) != test.code {
+			t.Errorf("%v: expected code %v, found %v", test.err, test.code, protobuf.GetErrorCode(err))
+		}
+
+		hint := err.(*protobuf.ScanError).RetryHint()
+		if hint.Retriable != test.retriable || hint.RetryAfter != test.retryAfter {
+			t.Errorf("%v: expected retriable %v after %v, found %+v", test.err,
+				test.retriable, test.retryAfter, hint)
+		}
+	}
+}
+
+func TestGroupHints(t *testing.T) {
+	w := NewProtoWriter(ScanReq, discardConn{})
+	defer w.Done()
+
+	w.addRow(nil, []byte("g1"))
+	w.addRow(nil, []byte("g2"))
+	if h := w.rowGroupHints(); h != nil {
+		t.Fatalf("expected no group hints without partial aggregates, got %v", h)
+	}
+
+	w.SetGroupHints(false)
+	repeat, closed, ok := (&protobuf.ResponseStream{GroupHints: w.rowGroupHints()}).Groups()
+	if !ok || repeat || closed != 2 {
+		t.Fatalf("expected all rows closed, got repeat %v closed %v", repeat, closed)
+	}
+
+	w.SetGroupHints(true)
+	repeat, closed, ok = (&protobuf.ResponseStream{GroupHints: w.rowGroupHints()}).Groups()
+	if !ok || !repeat || closed != 0 {
+		t.Fatalf("expected no rows closed, got repeat %v closed %v", repeat, closed)
+	}
+
+	if repeat, _, ok := (&protobuf.ResponseStream{}).Groups(); ok || !repeat {
+		t.Fatalf("expected groups to repeat without hints")
+	}
+}
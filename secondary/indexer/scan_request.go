@@ -18,6 +18,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/couchbase/cbauth"
 	"github.com/couchbase/indexing/secondary/collatejson"
 	"github.com/couchbase/indexing/secondary/common"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
@@ -38,6 +39,7 @@ const (
 	HeloReq                       = "helo"
 	MultiScanCountReq             = "multiscancount"
 	FastCountReq                  = "fastcountreq" //generated internally
+	EstimateReq                   = "estimate"
 )
 
 type ScanRequest struct {
@@ -49,6 +51,7 @@ type ScanRequest struct {
 	CollectionId string
 	PartitionIds []common.PartitionId
 	Ts           *common.TsVbuuid
+	TsVbnos      []uint16 // vbuckets of a partial at_plus Ts, nil for all
 	Low          IndexKey
 	High         IndexKey
 	Keys         []IndexKey
@@ -112,6 +115,16 @@ type ScanRequest struct {
 
 	dataEncFmt common.DataEncodingFormat
 	keySzCfg   keySizeConfig
+
+	partnRetries int // of a partition scan failing with a transient error
+
+	profile        *ScanProfile
+	sessionId      string   // scan session pinning the snapshot to scan
+	snapshotSeqnos bool     // return the seqnos of the scanned snapshot
+	snapshotId     bool     // return the id of the scanned snapshot
+	compressions   []uint32 // of scan responses offered by a HeloRequest
+
+	replicaPreference protobuf.ReplicaPreference
 }
 
 type Projection struct {
@@ -119,6 +132,7 @@ type Projection struct {
 	projectionKeys   []bool
 	entryKeysEmpty   bool
 	projectGroupKeys []projGroup
+	distinctOnKeys   int // return the first entry per distinct value of the leading keys
 }
 
 type projGroup struct {
@@ -303,10 +317,12 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 	}
 
 	r.keySzCfg = getKeySizeConfig(cfg)
+	r.partnRetries = cfg["scan.partition_retries"].Int()
 
 	switch req := protoReq.(type) {
 	case *protobuf.HeloRequest:
 		r.ScanType = HeloReq
+		r.compressions = req.GetCompressions()
 	case *protobuf.StatisticsRequest:
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
@@ -330,11 +346,14 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.RequestId = req.GetRequestId()
 		r.rollbackTime = req.GetRollbackTime()
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
-		cons := common.Consistency(req.GetCons())
-		vector := req.GetVector()
+		cons, vector := common.Consistency(req.GetCons()), req.GetVector()
+		if cons, vector, err = withMutationTokens(cons, vector, req.GetMutationTokens()); err != nil {
+			return
+		}
 		r.ScanType = CountReq
 		r.Incl = Inclusion(req.GetSpan().GetRange().GetInclusion())
 		r.Sorted = true
+		r.sessionId = req.GetSessionId()
 
 		if err = r.setIndexParams(); err != nil {
 			return
@@ -353,7 +372,10 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		}
 
 		sc := req.GetScans()
-		if len(sc) != 0 {
+		if req.GetEstimate() {
+			err = r.fillScans(sc)
+			r.ScanType = EstimateReq
+		} else if len(sc) != 0 {
 			err = r.fillScans(sc)
 			r.ScanType = MultiScanCountReq
 			r.Distinct = req.GetDistinct()
@@ -367,8 +389,10 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.RequestId = req.GetRequestId()
 		r.rollbackTime = req.GetRollbackTime()
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
-		cons := common.Consistency(req.GetCons())
-		vector := req.GetVector()
+		cons, vector := common.Consistency(req.GetCons()), req.GetVector()
+		if cons, vector, err = withMutationTokens(cons, vector, req.GetMutationTokens()); err != nil {
+			return
+		}
 		r.ScanType = ScanReq
 		r.Incl = Inclusion(req.GetSpan().GetRange().GetInclusion())
 		r.Limit = req.GetLimit()
@@ -376,6 +400,13 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.Reverse = req.GetReverse()
 		proj := req.GetIndexprojection()
 		r.dataEncFmt = common.DataEncodingFormat(req.GetDataEncFmt())
+		if req.GetProfile() {
+			r.profile = newScanProfile(r)
+		}
+		r.sessionId = req.GetSessionId()
+		r.snapshotSeqnos = req.GetSnapshotSeqnos()
+		r.snapshotId = req.GetSnapshotId()
+		r.replicaPreference = req.GetReplicaPreference()
 		if proj == nil {
 			r.Distinct = req.GetDistinct()
 		}
@@ -396,6 +427,11 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 					err = localerr
 					return
 				}
+				// First entry per group is only known from sorted partitions
+				if r.Indexprojection.distinctOnKeys > 0 && !r.Sorted && len(r.PartitionIds) > 1 {
+					err = errors.New("DistinctOnKeys requires a sorted scan of partitioned index")
+					return
+				}
 				r.projectPrimaryKey = *proj.PrimaryKey
 			} else {
 				if r.Indexprojection, localerr = validateIndexProjectionGroupAggr(proj, req.GetGroupAggr()); localerr != nil {
@@ -426,8 +462,10 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.RequestId = req.GetRequestId()
 		r.rollbackTime = req.GetRollbackTime()
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
-		cons := common.Consistency(req.GetCons())
-		vector := req.GetVector()
+		cons, vector := common.Consistency(req.GetCons()), req.GetVector()
+		if cons, vector, err = withMutationTokens(cons, vector, req.GetMutationTokens()); err != nil {
+			return
+		}
 		r.ScanType = ScanAllReq
 		r.Limit = req.GetLimit()
 		r.Scans = make([]Scan, 1)
@@ -1140,24 +1178,73 @@ func (r *ScanRequest) setExplodePositions() {
 	}
 }
 
+// withMutationTokens returns the consistency and vector of a request with
+// mutation tokens: QueryConsistency with a vector of the latest token of
+// each vbucket, so that the scan waits for those vbuckets only.
+func withMutationTokens(cons common.Consistency, vector *protobuf.TsConsistency,
+	tokens []*protobuf.MutationToken) (common.Consistency, *protobuf.TsConsistency, error) {
+
+	if len(tokens) == 0 {
+		return cons, vector, nil
+	}
+	if vector != nil {
+		return cons, nil, errors.New("Scan request has both a consistency vector and mutation tokens")
+	}
+	if cons != common.QueryConsistency && cons != common.AnyConsistency {
+		return cons, nil, fmt.Errorf("Mutation tokens are not supported with %v", cons)
+	}
+
+	latest := make(map[uint32]*protobuf.MutationToken, len(tokens))
+	for _, token := range tokens {
+		if l, ok := latest[token.GetVbno()]; !ok || token.GetSeqno() > l.GetSeqno() {
+			latest[token.GetVbno()] = token
+		}
+	}
+
+	vector = &protobuf.TsConsistency{
+		Vbnos:   make([]uint32, 0, len(latest)),
+		Seqnos:  make([]uint64, 0, len(latest)),
+		Vbuuids: make([]uint64, 0, len(latest)),
+	}
+	for vbno, token := range latest {
+		vector.Vbnos = append(vector.Vbnos, vbno)
+		vector.Seqnos = append(vector.Seqnos, token.GetSeqno())
+		vector.Vbuuids = append(vector.Vbuuids, token.GetVbuuid())
+	}
+	return common.QueryConsistency, vector, nil
+}
+
 func (r *ScanRequest) setConsistency(cons common.Consistency, vector *protobuf.TsConsistency) (localErr error) {
 
 	r.Consistency = &cons
+	if r.sessionId != "" {
+		// Scans of a session read the snapshot pinned by the session
+		return
+	}
+
 	cfg := r.sco.config.Load()
 	if cons == common.QueryConsistency && vector != nil {
-		r.Ts = common.NewTsVbuuid(r.Bucket, cfg["numVbuckets"].Int())
+		numVbs := getNumVBuckets(r.Bucket, cfg)
+		r.Ts = common.NewTsVbuuid(r.Bucket, numVbs)
 		// if vector == nil, it is similar to AnyConsistency
 		for i, vbno := range vector.Vbnos {
+			if int(vbno) >= numVbs || i >= len(vector.Seqnos) || i >= len(vector.Vbuuids) {
+				return fmt.Errorf("Invalid vbucket %v in consistency vector", vbno)
+			}
 			r.Ts.Seqnos[vbno] = vector.Seqnos[i]
 			r.Ts.Vbuuids[vbno] = vector.Vbuuids[i]
 		}
+		// Snapshots are checked against the vbuckets of a partial vector only
+		if len(vector.Vbnos) < numVbs {
+			r.TsVbnos = make([]uint16, len(vector.Vbnos))
+			for i, vbno := range vector.Vbnos {
+				r.TsVbnos[i] = uint16(vbno)
+			}
+		}
 	} else if cons == common.SessionConsistency {
-		cluster := cfg["clusterAddr"].String()
 		r.Ts = &common.TsVbuuid{}
 		t0 := time.Now()
-		r.Ts.Seqnos, localErr = bucketSeqsWithRetry(cfg["settings.scan_getseqnos_retries"].Int(),
-			r.LogPrefix, cluster, r.Bucket, cfg["numVbuckets"].Int(), r.CollectionId,
-			cfg["use_bucket_seqnos"].Bool())
+		r.Ts.Seqnos, localErr = r.sco.sessionSeqnos(r, cfg)
 		if localErr == nil && r.Stats != nil {
 			r.Stats.Timings.dcpSeqs.Put(time.Since(t0))
 		}
@@ -1184,8 +1271,12 @@ func (r *ScanRequest) setIndexParams() (localErr error) {
 
 		if indexInst.State != common.INDEX_STATE_ACTIVE {
 			localErr = common.ErrIndexNotReady
+		} else if indexInst.Error == common.ErrIndexEvalSkipped.Error() {
+			// Entries of the index are stale until it is rebuilt
+			localErr = common.ErrIndexNotReady
 		}
 		r.Stats = stats.indexes[r.IndexInstId]
+		r.setAdaptiveTimeout()
 		rbMap := *r.sco.getRollbackInProgress()
 		r.hasRollback = rbMap[indexInst.Defn.Bucket]
 	}
@@ -1214,10 +1305,17 @@ func validateIndexProjection(projection *protobuf.IndexProjection, cklen int) (*
 		}
 	}
 
+	distinctOnKeys := projection.GetDistinctOnKeys()
+	if distinctOnKeys < 0 || distinctOnKeys > int64(cklen) {
+		e := errors.New(fmt.Sprintf("Invalid DistinctOnKeys %v in IndexProjection", distinctOnKeys))
+		return nil, e
+	}
+
 	indexProjection := &Projection{}
 	indexProjection.projectSecKeys = !projectAllSecKeys
 	indexProjection.projectionKeys = projectionKeys
 	indexProjection.entryKeysEmpty = len(projection.EntryKeys) == 0
+	indexProjection.distinctOnKeys = int(distinctOnKeys)
 
 	return indexProjection, nil
 }
@@ -1230,6 +1328,10 @@ func validateIndexProjectionGroupAggr(projection *protobuf.IndexProjection, prot
 		return nil, errors.New("Grouping without projection is not supported")
 	}
 
+	if projection.GetDistinctOnKeys() > 0 {
+		return nil, errors.New("DistinctOnKeys is not supported with Group/Aggregate")
+	}
+
 	projGrp := make([]projGroup, nproj)
 	var found bool
 	for i, entryId := range projection.GetEntryKeys() {
@@ -1328,6 +1430,11 @@ func (r *ScanRequest) fillGroupAggr(protoGroupAggr *protobuf.GroupAggr, protoSca
 		if r.canUseFastCount(protoScans) {
 			r.ScanType = FastCountReq
 		}
+	} else if cfg["scan.materialized_count"].Bool() {
+		// Unqualified counts are answered by the materialized count
+		if r.canUseFastCount(nil) {
+			r.ScanType = FastCountReq
+		}
 	}
 
 	return
@@ -1427,6 +1534,21 @@ func (r *ScanRequest) unmarshallAggrs(protoGroupAggr *protobuf.GroupAggr) error
 
 }
 
+// groupsComplete tells if the groups of the scan are emitted once each,
+// fully aggregated, so that the limit and offset of the request apply to
+// them. Groups on leading keys are complete as the scan moves to the next
+// group, unless the entries of unsorted partitions are interleaved. All
+// rows of a scan without groups make up a single group. Scans of primary
+// indexes always apply the limit and offset.
+func (r *ScanRequest) groupsComplete() bool {
+
+	if r.GroupAggr == nil || r.isPrimary || len(r.GroupAggr.Group) == 0 {
+		return true
+	}
+
+	return r.GroupAggr.IsLeadingGroup && (r.Sorted || len(r.PartitionIds) <= 1)
+}
+
 func (r *ScanRequest) validateGroupAggr() error {
 
 	if r.isPrimary {
@@ -1525,21 +1647,18 @@ func (r *ScanRequest) processFirstValidAggrOnly() bool {
 		return false
 	}
 
+	// The rows of all spans are in the order of the key at keyPos if the
+	// keys before it are fixed to the same values in all the spans
 	checkEqualityFilters := func(keyPos int32) bool {
 		if keyPos < 0 {
 			return false
 		}
-		if keyPos == 0 {
-			return true
-		}
-
-		// If keyPos > 0, check if there is more than 1 span
-		// In case of multiple spans, do not apply the optimization
-		if len(r.Scans) > 1 {
-			return false
+		for i := 0; i < int(keyPos); i++ {
+			if r.equalFilterValue(i) == nil {
+				return false
+			}
 		}
-
-		return r.hasAllEqualFiltersUpto(int(keyPos) - 1)
+		return true
 	}
 
 	isAscKey := func(keyPos int32) bool {
@@ -1552,6 +1671,13 @@ func (r *ScanRequest) processFirstValidAggrOnly() bool {
 		return true
 	}
 
+	// A key fixed to a value in all the spans is the MIN and the MAX
+	// of its rows, whatever its order
+	if (aggr.AggrFunc == common.AGG_MIN || aggr.AggrFunc == common.AGG_MAX) &&
+		aggr.KeyPos >= 0 && r.equalFilterValue(int(aggr.KeyPos)) != nil {
+		return true
+	}
+
 	if aggr.AggrFunc == common.AGG_MIN {
 		if !checkEqualityFilters(aggr.KeyPos) {
 			return false
@@ -1707,13 +1833,34 @@ func checkEqualFilter(fl *protobuf.CompositeElementFilter) bool {
 
 }
 
-func (r *ScanRequest) hasAllEqualFiltersUpto(keyPos int) bool {
-	for i := 0; i <= keyPos; i++ {
-		if !r.hasAllEqualFilters(i) {
-			return false
+// Returns the value the key at keyPos is fixed to by the filters of all
+// the scans, or nil if any of them is not an equality filter or is an
+// equality on another value.
+func (r *ScanRequest) equalFilterValue(keyPos int) []byte {
+
+	var val []byte
+	for _, scan := range r.Scans {
+		if len(scan.Filters) == 0 {
+			return nil
+		}
+		for _, filter := range scan.Filters {
+			if len(filter.CompositeFilters) <= keyPos {
+				return nil
+			}
+			cf := filter.CompositeFilters[keyPos]
+			lowBytes, highBytes := cf.Low.Bytes(), cf.High.Bytes()
+			if lowBytes == nil || highBytes == nil || !bytes.Equal(lowBytes, highBytes) ||
+				cf.Inclusion != Both {
+				return nil
+			}
+			if val == nil {
+				val = lowBytes
+			} else if !bytes.Equal(val, lowBytes) {
+				return nil
+			}
 		}
 	}
-	return true
+	return val
 }
 
 // Returns true if all filters for the given keyPos(index field) are equal
@@ -2024,6 +2171,19 @@ type ConnectionContext struct {
 	bufPool map[common.PartitionId]*common.BytesBufPool
 	cache   map[string]ConCacheObj
 	mutex   sync.RWMutex
+
+	// Credentials the connection authenticated with. Nil if the client
+	// did not authenticate, which is allowed in mixed version clusters.
+	creds cbauth.Creds
+
+	// Compression of the row batches of scan responses, negotiated by a
+	// HeloRequest, and their size before and after it.
+	compression byte
+	zbuf        []byte
+	rowBytes    int64
+	zrowBytes   int64
+	remote      string
+	conns       *scanConns // tracking the connection while compressed
 }
 
 func createConnectionContext() interface{} {
@@ -2056,6 +2216,18 @@ func (c *ConnectionContext) Put(id string, obj ConCacheObj) {
 	c.cache[id] = obj
 }
 
+func (c *ConnectionContext) SetCreds(creds cbauth.Creds) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.creds = creds
+}
+
+func (c *ConnectionContext) GetCreds() cbauth.Creds {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.creds
+}
+
 func (c *ConnectionContext) ResetCache() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -0,0 +1,272 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/common"
+	dataproto "github.com/couchbase/indexing/secondary/protobuf/data"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestWithMutationTokens(t *testing.T) {
+	token := func(vbno uint32, vbuuid, seqno uint64) *protobuf.MutationToken {
+		return &protobuf.MutationToken{
+			Vbno:   proto.Uint32(vbno),
+			Vbuuid: proto.Uint64(vbuuid),
+			Seqno:  proto.Uint64(seqno),
+		}
+	}
+
+	cons, vector, err := withMutationTokens(common.SessionConsistency, nil, nil)
+	if err != nil || cons != common.SessionConsistency || vector != nil {
+		t.Fatalf("expected the request consistency without tokens, got %v %v %v", cons, vector, err)
+	}
+
+	tokens := []*protobuf.MutationToken{token(5, 50, 10), token(9, 90, 3), token(5, 50, 12)}
+	cons, vector, err = withMutationTokens(common.AnyConsistency, nil, tokens)
+	if err != nil || cons != common.QueryConsistency {
+		t.Fatalf("expected QueryConsistency, got %v %v", cons, err)
+	}
+	if len(vector.Vbnos) != 2 {
+		t.Fatalf("expected a vector of 2 vbuckets, got %v", vector.Vbnos)
+	}
+	for i, vbno := range vector.Vbnos {
+		if (vbno == 5 && (vector.Seqnos[i] != 12 || vector.Vbuuids[i] != 50)) ||
+			(vbno == 9 && (vector.Seqnos[i] != 3 || vector.Vbuuids[i] != 90)) {
+			t.Fatalf("unexpected seqno %v vbuuid %v of vbucket %v", vector.Seqnos[i], vector.Vbuuids[i], vbno)
+		}
+	}
+
+	if _, _, err = withMutationTokens(common.QueryConsistency, vector, tokens); err == nil {
+		t.Fatalf("expected an error with both a vector and tokens")
+	}
+	if _, _, err = withMutationTokens(common.SessionConsistency, nil, tokens); err == nil {
+		t.Fatalf("expected an error with session consistency and tokens")
+	}
+}
+
+func TestScanRequestGroupsComplete(t *testing.T) {
+	r := &ScanRequest{}
+	if !r.groupsComplete() {
+		t.Fatalf("expected the rows of a scan without aggregates to be limited")
+	}
+
+	r.GroupAggr = &GroupAggr{}
+	if !r.groupsComplete() {
+		t.Fatalf("expected a single complete group without group keys")
+	}
+
+	r.GroupAggr = &GroupAggr{Group: []*GroupKey{{KeyPos: 0}}, IsLeadingGroup: true}
+	if !r.groupsComplete() {
+		t.Fatalf("expected complete groups on leading keys")
+	}
+
+	r.PartitionIds = []common.PartitionId{1, 2}
+	if r.groupsComplete() {
+		t.Fatalf("expected partial groups from unsorted partitions")
+	}
+	r.Sorted = true
+	if !r.groupsComplete() {
+		t.Fatalf("expected complete groups from sorted partitions")
+	}
+
+	r.GroupAggr.IsLeadingGroup = false
+	if r.groupsComplete() {
+		t.Fatalf("expected partial groups on non leading keys")
+	}
+}
+
+func TestFirstValidAggrOnly(t *testing.T) {
+	key := func(k string) IndexKey {
+		ik, err := NewSecondaryKey([]byte(k), make([]byte, 0, 3*len(k)+ENCODE_BUF_SAFE_PAD), true, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ik
+	}
+	eq := func(k string) CompositeElementFilter {
+		return CompositeElementFilter{Low: key(k), High: key(k), Inclusion: Both}
+	}
+	rng := func(low, high string) CompositeElementFilter {
+		return CompositeElementFilter{Low: key(low), High: key(high), Inclusion: Both}
+	}
+	scan := func(filters ...CompositeElementFilter) Scan {
+		return Scan{ScanType: FilterRangeReq, Filters: []Filter{{CompositeFilters: filters}}}
+	}
+	check := func(name string, aggr common.AggrFuncType, keyPos int32, desc []bool,
+		scans []Scan, expected bool) {
+		r := &ScanRequest{Scans: scans}
+		r.IndexInst.Defn.SecExprs = []string{"k0", "k1", "k2"}
+		r.IndexInst.Defn.Desc = desc
+		r.GroupAggr = &GroupAggr{Aggrs: []*Aggregate{{AggrFunc: aggr, KeyPos: keyPos}}}
+		if r.processFirstValidAggrOnly() != expected {
+			t.Fatalf("%v: expected FirstValidAggrOnly %v", name, expected)
+		}
+	}
+
+	check("min leading", common.AGG_MIN, 0, nil,
+		[]Scan{scan(rng(`1`, `5`)), scan(rng(`7`, `9`))}, true)
+	check("max leading", common.AGG_MAX, 0, nil,
+		[]Scan{scan(rng(`1`, `5`))}, false)
+	check("max leading desc", common.AGG_MAX, 0, []bool{true, false, false},
+		[]Scan{scan(rng(`1`, `5`))}, true)
+
+	// spans whose union is in the order of the aggregate key
+	check("min spans same prefix", common.AGG_MIN, 1, nil,
+		[]Scan{scan(eq(`"a"`), rng(`1`, `5`)), scan(eq(`"a"`), rng(`7`, `9`))}, true)
+	check("min spans other prefix", common.AGG_MIN, 1, nil,
+		[]Scan{scan(eq(`"a"`), rng(`1`, `5`)), scan(eq(`"b"`), rng(`7`, `9`))}, false)
+	check("min range prefix", common.AGG_MIN, 2, nil,
+		[]Scan{scan(eq(`"a"`), rng(`1`, `5`), rng(`1`, `5`))}, false)
+
+	// a key fixed by equality filters
+	check("min desc equality", common.AGG_MIN, 1, []bool{false, true, false},
+		[]Scan{scan(rng(`1`, `5`), eq(`3`)), scan(rng(`7`, `9`), eq(`3`))}, true)
+	check("max equality", common.AGG_MAX, 0, nil,
+		[]Scan{scan(eq(`3`))}, true)
+	check("min desc", common.AGG_MIN, 1, []bool{false, true, false},
+		[]Scan{scan(eq(`"a"`), rng(`1`, `5`))}, false)
+}
+
+func TestEvalSkipStopsScans(t *testing.T) {
+	cfg := common.SystemConfig.SectionConfig("indexer.", true /*trim*/)
+	instId, defnId := common.IndexInstId(1), common.IndexDefnId(1)
+	bucket := "default"
+
+	// The stream reader forwards the mutations the projector skipped for
+	// the index
+	var maxMemory, memUsed int64 = 1024 * 1024, 0
+	q := NewAtomicMutationQueue(bucket, uint16(getNumVBuckets(bucket, cfg)), &maxMemory, &memUsed, cfg)
+	r := &mutationStreamReader{
+		streamId:            common.MAINT_STREAM,
+		supvRespch:          make(MsgChannel, 1),
+		keyspaceIdQueueMap:  KeyspaceIdQueueMap{bucket: IndexerMutationQueue{queue: q}},
+		keyspaceIdEnableOSO: make(KeyspaceIdEnableOSO),
+		config:              cfg,
+	}
+	r.stats.Set(NewIndexerStats())
+	w := newStreamWorker(common.MAINT_STREAM, 1, 0, cfg, r, nil, false, nil, nil, nil)
+
+	kv := &dataproto.KeyVersions{
+		Seqno:     proto.Uint64(10),
+		Docid:     []byte("doc1"),
+		Uuids:     []uint64{uint64(instId)},
+		Commands:  []uint32{uint32(common.EvalSkip)},
+		Keys:      [][]byte{nil},
+		Oldkeys:   [][]byte{nil},
+		Partnkeys: [][]byte{nil},
+	}
+	w.handleSingleKeyVersion(bucket, 5, 1, 0, kv, common.ProjVer_7_0_0)
+
+	msg, ok := (<-r.supvRespch).(*MsgEvalSkip)
+	if !ok {
+		t.Fatalf("expected EvalSkip message")
+	}
+	if msg.GetInstId() != instId || msg.GetMutationMeta().seqno != 10 {
+		t.Fatalf("expected EvalSkip of instance %v at seqno 10, got %v", instId, msg)
+	}
+
+	// Scans of the index succeed until the skip is reported, and then
+	// fail rather than return stale entries
+	inst := common.IndexInst{
+		InstId: instId,
+		Defn:   common.IndexDefn{DefnId: defnId, Name: "idx1", Bucket: bucket},
+		State:  common.INDEX_STATE_ACTIVE,
+		RState: common.REBAL_ACTIVE,
+	}
+	sc := NewHashedSliceContainer()
+	sc.AddSlice(0, NewMockSlice(0, instId, defnId))
+	sco := &scanCoordinator{
+		indexInstMap:  common.IndexInstMap{instId: inst},
+		indexPartnMap: IndexPartnMap{instId: PartitionInstMap{common.NON_PARTITION_ID: {Sc: sc}}},
+		indexDefnMap:  map[common.IndexDefnId][]common.IndexInstId{defnId: {instId}},
+	}
+	sco.config.Store(cfg)
+	sco.stats.Set(NewIndexerStats())
+	sco.indexerState.Store(common.INDEXER_ACTIVE)
+	rollbackInProgress := make(map[string]*atomic.Value)
+	atomic.StorePointer(&sco.rollbackInProgress, unsafe.Pointer(&rollbackInProgress))
+
+	scan := func() error {
+		req := &ScanRequest{sco: sco, DefnID: uint64(defnId),
+			PartitionIds: []common.PartitionId{common.NON_PARTITION_ID}}
+		return req.setIndexParams()
+	}
+	if err := scan(); err != nil {
+		t.Fatalf("expected scan of the index, got %v", err)
+	}
+
+	inst.Error = common.ErrIndexEvalSkipped.Error()
+	sco.indexInstMap[instId] = inst
+	if err := scan(); err != common.ErrIndexNotReady {
+		t.Fatalf("expected %v after the skip, got %v", common.ErrIndexNotReady, err)
+	}
+	if _, err := sco.findScannableInsts([]common.IndexDefnId{defnId}); err == nil {
+		t.Fatalf("expected the index not scannable in a session after the skip")
+	}
+}
+
+// testCreds are credentials allowed the permissions in allowed.
+type testCreds struct {
+	name    string
+	allowed map[string]bool
+}
+
+func (c *testCreds) Name() string                                { return c.name }
+func (c *testCreds) Domain() string                              { return "local" }
+func (c *testCreds) User() (string, string)                      { return c.name, c.Domain() }
+func (c *testCreds) IsAllowed(permission string) (bool, error)   { return c.allowed[permission], nil }
+func (c *testCreds) IsAllowedInternal(perm string) (bool, error) { return false, nil }
+func (c *testCreds) Expiry() time.Time                           { return time.Time{} }
+
+func TestAuthorizeScan(t *testing.T) {
+	defn := common.IndexDefn{DefnId: 1, Name: "idx1", Bucket: "b", Scope: "s", Collection: "c"}
+	sco := &scanCoordinator{}
+
+	scan := func(creds cbauth.Creds) error {
+		req := &ScanRequest{
+			IndexInst: common.IndexInst{InstId: 1, Defn: defn},
+			LogPrefix: "SCAN##1",
+			connCtx:   createConnectionContext().(*ConnectionContext),
+		}
+		if creds != nil {
+			req.connCtx.SetCreds(creds)
+		}
+		return sco.authorizeScan(req)
+	}
+
+	// Connections which did not authenticate are not authorized per request
+	if err := scan(nil); err != nil {
+		t.Fatalf("expected scan of an unauthenticated connection, got %v", err)
+	}
+
+	// A user without select on the collection is rejected, also when
+	// allowed to select from other collections
+	other := common.IndexDefn{Bucket: "b", Scope: "s", Collection: "other"}
+	noSelect := &testCreds{name: "alice", allowed: map[string]bool{
+		common.GetScanPermission(&other): true,
+	}}
+	if err := scan(noSelect); err != common.ErrScanNotAuthorized {
+		t.Fatalf("expected %v for a user without select, got %v", common.ErrScanNotAuthorized, err)
+	}
+
+	allowed := &testCreds{name: "bob", allowed: map[string]bool{
+		common.GetScanPermission(&defn): true,
+	}}
+	if err := scan(allowed); err != nil {
+		t.Fatalf("expected scan of a user with select, got %v", err)
+	}
+}
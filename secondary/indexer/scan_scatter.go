@@ -9,14 +9,15 @@ package indexer
 
 import (
 	"bytes"
+	"container/heap"
 	"errors"
 	"fmt"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/couchbase/indexing/secondary/common"
-	"github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/pipeline"
 )
 
@@ -84,14 +85,16 @@ func scanMultiple(request *ScanRequest, scan Scan, snapshots []SliceSnapshot, cb
 
 		queues[i] = NewQueue(int64(size), int64(limit), notifych, m)
 	}
+	atomic.AddInt64(&scanBufferRows, int64(size*len(queues)))
 	defer func() {
+		atomic.AddInt64(&scanBufferRows, -int64(size*len(queues)))
 		for i, queue := range queues {
 			queue.Close()
 			queue.Free()
 
 			partitionId := getPartitionId(request, i)
 			if m := queue.GetAllocator(); m != nil {
-				//logging.Debugf("Free allocator %p partition id %v count %v malloc %v", m, partitionId, m.count, m.numMalloc)
+				//scanLog.Debugf("Free allocator %p partition id %v count %v malloc %v", m, partitionId, m.count, m.numMalloc)
 				request.connCtx.Put(fmt.Sprintf("%v%v", ScanQueue, partitionId), m)
 			}
 		}
@@ -137,7 +140,7 @@ func scanMultiple(request *ScanRequest, scan Scan, snapshots []SliceSnapshot, cb
 		enqCount += queue.EnqueueCount()
 		deqCount += queue.DequeueCount()
 	}
-	logging.Debugf("scan_scatter.scanMultiple: scan done.  enqueue count %v dequeue count %v", enqCount, deqCount)
+	scanLog.Debugf("scan_scatter.scanMultiple: scan done.  enqueue count %v dequeue count %v", enqCount, deqCount)
 
 	return
 }
@@ -147,7 +150,7 @@ func scanOne(request *ScanRequest, scan Scan, snapshots []SliceSnapshot, partiti
 	errch := make(chan error, 1)
 	count := scanSingleSlice(request, scan, request.Ctxs[0], snapshots[0], partitionId, nil, nil, errch, cb)
 
-	logging.Debugf("scan_scatter:scanOnce: scan done. Count %v", count)
+	scanLog.Debugf("scan_scatter:scanOnce: scan done. Count %v", count)
 
 	errcnt := len(errch)
 	for i := 0; i < errcnt; i++ {
@@ -173,13 +176,31 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 		})
 	}()
 
+	// Last entry returned, to resume from on a retry of the scan
+	var last []byte
+	returned, resume := false, false
+	track := request.partnRetries > 0
+
 	handler := func(entry []byte) error {
 		// Do not call enqueue when there is error.
 		if len(errch) != 0 {
 			return ErrFinishCallback
 		}
 
+		// Entries are returned in byte order. Skip the ones already returned
+		// before the retry.
+		if resume {
+			if bytes.Compare(entry, last) <= 0 {
+				return nil
+			}
+			resume = false
+		}
+
 		count++
+		if track {
+			last = append(last[:0], entry...)
+			returned = true
+		}
 
 		if queue != nil {
 
@@ -198,12 +219,27 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 	}
 
 	var err error
-	if scan.ScanType == AllReq {
-		err = snap.Snapshot().All(ctx, handler)
-	} else if scan.ScanType == LookupReq {
-		err = snap.Snapshot().Range(ctx, scan.Equals, scan.Equals, Both, handler)
-	} else if scan.ScanType == RangeReq || scan.ScanType == FilterRangeReq {
-		err = snap.Snapshot().Range(ctx, scan.Low, scan.High, scan.Incl, handler)
+	for retry := 0; ; retry++ {
+		if scan.ScanType == AllReq {
+			err = snap.Snapshot().All(ctx, handler)
+		} else if scan.ScanType == LookupReq {
+			err = snap.Snapshot().Range(ctx, scan.Equals, scan.Equals, Both, handler)
+		} else if scan.ScanType == RangeReq || scan.ScanType == FilterRangeReq {
+			err = snap.Snapshot().Range(ctx, scan.Low, scan.High, scan.Incl, handler)
+		}
+
+		if err == nil || retry >= request.partnRetries || len(errch) != 0 ||
+			!isTransientScanError(err) {
+			break
+		}
+
+		// Scan the partition again with a new iterator, from where it failed
+		scanLog.Warnf("%v scan_scatter.scanSingleSlice: retry %v of partition %v scan after error %v",
+			request.LogPrefix, retry+1, partitionId, err)
+		request.Stats.updatePartitionStats(partitionId, func(ps *IndexStats) {
+			ps.numPartnScanRetries.Add(1)
+		})
+		resume = returned
 	}
 
 	if err != nil {
@@ -224,6 +260,19 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 	return
 }
 
+//
+// Errors of a partition scan that are worth retrying. Storage errors report
+// themselves as temporary, e.g. when the snapshot is being replaced.
+//
+func isTransientScanError(err error) bool {
+
+	if t, ok := err.(interface{ Temporary() bool }); ok {
+		return t.Temporary()
+	}
+
+	return strings.HasPrefix(err.Error(), "ForestDB iterator: alloc failed")
+}
+
 //--------------------------
 // scatter count
 //--------------------------
@@ -266,7 +315,7 @@ func countSingleSlice(request *ScanRequest, ctx IndexReaderContext, snap SliceSn
 	if len(request.Keys) > 0 {
 		cnt, err = snap.Snapshot().CountLookup(ctx, request.Keys, stopch)
 	} else if request.Low.Bytes() == nil && request.High.Bytes() == nil {
-		cnt, err = snap.Snapshot().CountTotal(ctx, stopch)
+		cnt, err = countTotal(snap, ctx, stopch)
 	} else {
 		cnt, err = snap.Snapshot().CountRange(ctx, request.Low, request.High, request.Incl, stopch)
 	}
@@ -435,22 +484,22 @@ func fastCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContex
 
 	if !desc {
 		if scan.Incl == Low || scan.Incl == Both {
-			cnt, err = snap.Snapshot().CountTotal(ctx, stopch)
+			cnt, err = countTotal(snap, ctx, stopch)
 		} else if scan.Incl == Neither {
 			nullCnt, err = snap.Snapshot().CountRange(ctx, scan.Low, scan.Low, Both, stopch)
 			if err == nil {
-				cnt, err = snap.Snapshot().CountTotal(ctx, stopch)
+				cnt, err = countTotal(snap, ctx, stopch)
 			}
 		}
 	} else {
 		//for desc, inclusion gets flipped to High
 		if scan.Incl == High || scan.Incl == Both {
-			cnt, err = snap.Snapshot().CountTotal(ctx, stopch)
+			cnt, err = countTotal(snap, ctx, stopch)
 		} else if scan.Incl == Neither {
 			//for desc, nulls collate on the higher end
 			nullCnt, err = snap.Snapshot().CountRange(ctx, scan.High, scan.High, Both, stopch)
 			if err == nil {
-				cnt, err = snap.Snapshot().CountTotal(ctx, stopch)
+				cnt, err = countTotal(snap, ctx, stopch)
 			}
 		}
 	}
@@ -467,87 +516,82 @@ func fastCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContex
 // gather range scan
 //--------------------------
 
-func scan_sort(request *ScanRequest, queues []*Queue, rows []Row, sorted []int) bool {
+//
+// Min-heap of partition queues ordered by the row at the head of each queue.
+// Keys are compared in their stored form, where desc keys are already
+// reverse collated, so the merge follows the index order. The last row of
+// a queue always sorts last.
+//
+type mergeHeap struct {
+	request *ScanRequest
+	rows    []Row
+	ids     []int
+}
 
-	size := len(queues)
+func (h *mergeHeap) Len() int { return len(h.ids) }
 
-	for i := 0; i < size; i++ {
-		sorted[i] = i
+func (h *mergeHeap) Less(i, j int) bool {
+	r1, r2 := &h.rows[h.ids[i]], &h.rows[h.ids[j]]
+	if r1.last || r2.last {
+		return !r1.last && r2.last
 	}
+	return compareKey(h.request, r1, r2) < 0
+}
 
-	for i := 0; i < size-1; i++ {
-		if !queues[sorted[i]].Peek(&rows[sorted[i]]) {
-			return false
-		}
+func (h *mergeHeap) Swap(i, j int) { h.ids[i], h.ids[j] = h.ids[j], h.ids[i] }
 
-		for j := i + 1; j < size; j++ {
-			if !queues[sorted[j]].Peek(&rows[sorted[j]]) {
-				return false
-			}
+func (h *mergeHeap) Push(x interface{}) { h.ids = append(h.ids, x.(int)) }
 
-			if rows[sorted[i]].last && !rows[sorted[j]].last ||
-				(!rows[sorted[i]].last && !rows[sorted[j]].last &&
-					compareKey(request, &rows[sorted[i]], &rows[sorted[j]]) > 0) {
-				tmp := sorted[i]
-				sorted[i] = sorted[j]
-				sorted[j] = tmp
-			}
-		}
-	}
-
-	return true
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.ids)
+	id := h.ids[n-1]
+	h.ids = h.ids[:n-1]
+	return id
 }
 
 //
-// Gather results from multiple connections
-// rows - buffer of rows from each scatter gorountine
-// sorted - sorted order of the rows
+// Wait until the given queues have a row at their head and copy it to rows.
+// Returns false if the scan is aborted.
 //
-func scan_pick(request *ScanRequest, queues []*Queue, rows []Row, sorted []int) int {
+func gather_peek(queues []*Queue, ids []int, rows []Row, notifych chan bool, killch chan bool,
+	errch chan error) bool {
 
-	size := len(queues)
-
-	if !queues[sorted[0]].Peek(&rows[sorted[0]]) {
-		return NoPick
-	}
-
-	pos := 0
-	for i := 1; i < size; i++ {
-		if !queues[sorted[i]].Peek(&rows[sorted[i]]) {
-			return NoPick
+	for {
+		if len(errch) != 0 {
+			return false
 		}
 
-		// last value always sorted last
-		if rows[sorted[pos]].last && !rows[sorted[i]].last ||
-			(!rows[sorted[pos]].last && !rows[sorted[i]].last &&
-				compareKey(request, &rows[sorted[pos]], &rows[sorted[i]]) > 0) {
-
-			tmp := sorted[pos]
-			sorted[pos] = sorted[i]
-			sorted[i] = tmp
-			pos = i
+		found := true
+		for _, id := range ids {
+			if !queues[id].Peek(&rows[id]) {
+				found = false
+				break
+			}
+		}
 
-		} else {
-			break
+		if found {
+			return true
 		}
-	}
 
-	for i := 0; i < size; i++ {
-		if !rows[sorted[i]].last {
-			return sorted[0]
+		select {
+		case <-notifych:
+			continue
+		case <-killch:
+			return false
 		}
 	}
-
-	return Done
 }
 
+//
+// Gather results from multiple connections with a k-way merge
+// rows - buffer of rows from each scatter gorountine
+//
 func gather(request *ScanRequest, queues []*Queue, donech chan bool, notifych chan bool, killch chan bool,
 	errch chan error, cb EntryCallback) {
 
 	defer close(donech)
 
 	ensembleSize := len(queues)
-	sorted := make([]int, ensembleSize)
 
 	rows := make([]Row, ensembleSize)
 	for i := 0; i < ensembleSize; i++ {
@@ -559,46 +603,25 @@ func gather(request *ScanRequest, queues []*Queue, donech chan bool, notifych ch
 		}
 	}()
 
-	// initial sort
-	isSorted := false
-	for !isSorted {
-		if len(errch) != 0 {
-			return
-		}
-
-		isSorted = scan_sort(request, queues, rows, sorted)
+	h := &mergeHeap{request: request, rows: rows, ids: make([]int, ensembleSize)}
+	for i := 0; i < ensembleSize; i++ {
+		h.ids[i] = i
+	}
 
-		if !isSorted {
-			select {
-			case <-notifych:
-				continue
-			case <-killch:
-				return
-			}
-		}
+	// initial sort
+	if !gather_peek(queues, h.ids, rows, notifych, killch, errch) {
+		return
 	}
+	heap.Init(h)
 
 	for {
-		var id int
+		id := h.ids[0]
 
-		if len(errch) != 0 {
+		// every queue is at its last row
+		if rows[id].last {
 			return
 		}
 
-		id = scan_pick(request, queues, rows, sorted)
-		if id == Done {
-			break
-		}
-
-		if id == NoPick {
-			select {
-			case <-notifych:
-				continue
-			case <-killch:
-				return
-			}
-		}
-
 		if queues[id].Dequeue(&rows[id]) {
 			if err := cb(rows[id].key); err != nil {
 				errch <- err
@@ -610,6 +633,12 @@ func gather(request *ScanRequest, queues []*Queue, donech chan bool, notifych ch
 				return
 			}
 		}
+
+		// only the head of the dequeued queue has changed
+		if !gather_peek(queues, h.ids[:1], rows, notifych, killch, errch) {
+			return
+		}
+		heap.Fix(h, 0)
 	}
 }
 
@@ -700,6 +729,14 @@ func queueSize(partition int, sorted bool, cfg common.Config) (int, int) {
 	size := cfg["scan.queue_size"].Int()
 	limit := cfg["scan.notify_count"].Int()
 
+	// Shrunk by the memory governor, keeping room for a notification
+	if scale := memoryBudgetScale(memScan); scale < 1 {
+		size = int(float64(size) * scale)
+		if size < limit {
+			size = limit
+		}
+	}
+
 	numCpu := runtime.GOMAXPROCS(0)
 
 	if numCpu >= partition || !sorted {
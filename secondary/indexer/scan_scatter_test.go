@@ -0,0 +1,142 @@
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestGatherMergeSorted(t *testing.T) {
+	parts := [][]string{
+		{"a", "d", "g"},
+		{},
+		{"b", "c", "h", "i"},
+		{"e", "f"},
+	}
+
+	pool := common.NewByteBufferPool(16)
+	notifych := make(chan bool, 1)
+	queues := make([]*Queue, len(parts))
+	for i, keys := range parts {
+		queues[i] = NewQueue(8, 1, notifych, newAllocator(0, pool))
+		for _, k := range keys {
+			queues[i].Enqueue(&Row{key: []byte(k), len: len(k)})
+		}
+		queues[i].Enqueue(&Row{last: true})
+	}
+
+	var out [][]byte
+	cb := func(key []byte) error {
+		out = append(out, append([]byte(nil), key...))
+		return nil
+	}
+
+	donech := make(chan bool)
+	gather(&ScanRequest{}, queues, donech, notifych, make(chan bool), make(chan error, 1), cb)
+	<-donech
+
+	expected := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
+	if len(out) != len(expected) {
+		t.Fatalf("Expected %v rows, found %q", len(expected), out)
+	}
+	for i := range expected {
+		if !bytes.Equal(out[i], []byte(expected[i])) {
+			t.Fatalf("Expected %v at %v, found %q", expected[i], i, out)
+		}
+	}
+}
+
+type temporaryError struct{}
+
+func (e temporaryError) Error() string   { return "snapshot being replaced" }
+func (e temporaryError) Temporary() bool { return true }
+
+// flakySnapshot fails the first scans after returning some of the keys
+type flakySnapshot struct {
+	Snapshot
+	keys     []string
+	failAt   int
+	failures int
+}
+
+func (s *flakySnapshot) All(ctx IndexReaderContext, callb EntryCallback) error {
+	for i, k := range s.keys {
+		if i == s.failAt && s.failures > 0 {
+			s.failures--
+			return temporaryError{}
+		}
+		if err := callb([]byte(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestScanSingleSliceRetry(t *testing.T) {
+	keys := []string{"a", "b", "c", "d"}
+
+	scan := func(retries, failures int) ([]string, error) {
+		request := &ScanRequest{isPrimary: true, partnRetries: retries, Stats: &IndexStats{}}
+		snap := &sliceSnapshot{snap: &flakySnapshot{keys: keys, failAt: 2, failures: failures}}
+
+		var out []string
+		cb := func(key []byte) error {
+			out = append(out, string(key))
+			return nil
+		}
+
+		errch := make(chan error, 1)
+		scanSingleSlice(request, Scan{ScanType: AllReq}, nil, snap, 0, nil, nil, errch, cb)
+		if len(errch) != 0 {
+			return out, <-errch
+		}
+		return out, nil
+	}
+
+	out, err := scan(2, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Join(out, ",") != "a,b,c,d" {
+		t.Errorf("Expected each key once, found %v", out)
+	}
+
+	if _, err := scan(2, 3); err != (temporaryError{}) {
+		t.Errorf("Expected the error once the retries are exhausted, found %v", err)
+	}
+	if _, err := scan(0, 1); err != (temporaryError{}) {
+		t.Errorf("Expected the error without retries, found %v", err)
+	}
+}
+
+// uncountedSnapshot fails counts that need to read the snapshot
+type uncountedSnapshot struct {
+	Snapshot
+}
+
+func (s *uncountedSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (uint64, error) {
+	return 0, errors.New("snapshot read for count")
+}
+
+func TestCountMaterialized(t *testing.T) {
+	snaps := []SliceSnapshot{
+		&sliceSnapshot{snap: &uncountedSnapshot{}, itemsCount: 10, hasItemsCount: true},
+		&sliceSnapshot{snap: &uncountedSnapshot{}, itemsCount: 5, hasItemsCount: true},
+	}
+	request := &ScanRequest{Low: MinIndexKey, High: MaxIndexKey,
+		Ctxs: make([]IndexReaderContext, len(snaps))}
+
+	count, err := scatterCount(request, snaps, nil)
+	if err != nil || count != 15 {
+		t.Errorf("Expected the materialized count 15, found %v err %v", count, err)
+	}
+
+	// Snapshots without a materialized count are read
+	snaps[1] = &sliceSnapshot{snap: &uncountedSnapshot{}}
+	if _, err := scatterCount(request, snaps, nil); err == nil {
+		t.Errorf("Expected the snapshot to be read")
+	}
+}
@@ -0,0 +1,569 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// A scan session pins a snapshot of each of a set of indexes, so that the
+// scans passing the session id all read the same data, as needed by queries
+// joining or comparing the results of several indexes. Snapshots of indexes
+// on the same bucket are taken at the same flush timestamp and, with
+// request_plus consistency, are at least as recent as the bucket seqnos when
+// the session was opened. Pinned snapshots hold on to storage, so a session
+// expires scan.session.ttl after its last scan, or the ttl requested when it
+// is opened, at most scan.session.maxTtl.
+
+var (
+	ErrScanSessionNotFound = errors.New("Scan session not found or expired")
+	ErrNotInScanSession    = errors.New("Index not in scan session")
+	ErrScanSessionLimit    = errors.New("Too many open scan sessions")
+)
+
+const (
+	scanSessionExpiryInterval = 10 * time.Second
+	scanSessionRetryInterval  = 10 * time.Millisecond
+)
+
+type scanSession struct {
+	id       string
+	user     string
+	cons     common.Consistency
+	ttl      time.Duration
+	created  time.Time
+	lastUsed time.Time
+	numScans int64
+
+	insts map[common.IndexInstId]common.IndexInst
+	snaps map[common.IndexInstId]IndexSnapshot
+}
+
+func (sess *scanSession) expired(now time.Time) bool {
+	return !now.Before(sess.lastUsed.Add(sess.ttl))
+}
+
+// allowed returns whether user can use the session. Scans on connections
+// without credentials, which skip authorization, can use any session.
+func (sess *scanSession) allowed(user string) bool {
+	return user == "" || sess.user == "" || user == sess.user
+}
+
+func (sess *scanSession) destroy() {
+	for _, is := range sess.snaps {
+		DestroyIndexSnapshot(is)
+	}
+	sess.snaps = nil
+}
+
+// ScanSessionInfo describes an open scan session.
+type ScanSessionInfo struct {
+	SessionId   string              `json:"sessionId"`
+	Consistency string              `json:"consistency"`
+	Created     int64               `json:"created"`
+	Expiry      int64               `json:"expiry"`
+	NumScans    int64               `json:"numScans"`
+	Indexes     []*ScanSessionIndex `json:"indexes"`
+}
+
+type ScanSessionIndex struct {
+	DefnId     common.IndexDefnId `json:"defnId"`
+	InstId     common.IndexInstId `json:"instId"`
+	Name       string             `json:"name"`
+	Bucket     string             `json:"bucket"`
+	Scope      string             `json:"scope"`
+	Collection string             `json:"collection"`
+	SnapId     int64              `json:"snapshotId"`
+}
+
+func (sess *scanSession) info() *ScanSessionInfo {
+	info := &ScanSessionInfo{
+		SessionId:   sess.id,
+		Consistency: scanSessionConsistencyName(sess.cons),
+		Created:     sess.created.UnixNano(),
+		Expiry:      sess.lastUsed.Add(sess.ttl).UnixNano(),
+		NumScans:    sess.numScans,
+	}
+
+	for instId, inst := range sess.insts {
+		idx := &ScanSessionIndex{
+			DefnId:     inst.Defn.DefnId,
+			InstId:     instId,
+			Name:       inst.Defn.Name,
+			Bucket:     inst.Defn.Bucket,
+			Scope:      inst.Defn.Scope,
+			Collection: inst.Defn.Collection,
+		}
+		if is, ok := sess.snaps[instId]; ok {
+			idx.SnapId = is.SnapId()
+		}
+		info.Indexes = append(info.Indexes, idx)
+	}
+	sort.Slice(info.Indexes, func(i, j int) bool {
+		return info.Indexes[i].InstId < info.Indexes[j].InstId
+	})
+	return info
+}
+
+// scanSessionStore holds the open scan sessions.
+type scanSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*scanSession
+}
+
+func newScanSessionStore() *scanSessionStore {
+	return &scanSessionStore{
+		sessions: make(map[string]*scanSession),
+	}
+}
+
+// Add adds sess, unless maxSessions sessions are open.
+func (st *scanSessionStore) Add(sess *scanSession, maxSessions int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if len(st.sessions) >= maxSessions {
+		return ErrScanSessionLimit
+	}
+	st.sessions[sess.id] = sess
+	return nil
+}
+
+// get returns session id, closing it if expired.
+func (st *scanSessionStore) get(id, user string, now time.Time) (*scanSession, error) {
+	sess, ok := st.sessions[id]
+	if !ok || !sess.allowed(user) {
+		return nil, ErrScanSessionNotFound
+	}
+	if sess.expired(now) {
+		delete(st.sessions, id)
+		sess.destroy()
+		return nil, ErrScanSessionNotFound
+	}
+	return sess, nil
+}
+
+// Snapshot returns the snapshot of instId pinned by session id, for a scan
+// of the session. The caller destroys the snapshot when done.
+func (st *scanSessionStore) Snapshot(id, user string, instId common.IndexInstId,
+	now time.Time) (IndexSnapshot, error) {
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sess, err := st.get(id, user, now)
+	if err != nil {
+		return nil, err
+	}
+
+	is, ok := sess.snaps[instId]
+	if !ok {
+		return nil, ErrNotInScanSession
+	}
+
+	sess.lastUsed = now
+	sess.numScans++
+	return CloneIndexSnapshot(is), nil
+}
+
+func (st *scanSessionStore) Get(id, user string, now time.Time) (*ScanSessionInfo, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sess, err := st.get(id, user, now)
+	if err != nil {
+		return nil, err
+	}
+	return sess.info(), nil
+}
+
+// List returns the sessions user can use.
+func (st *scanSessionStore) List(user string, now time.Time) []*ScanSessionInfo {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	infos := make([]*ScanSessionInfo, 0, len(st.sessions))
+	for _, sess := range st.sessions {
+		if sess.allowed(user) && !sess.expired(now) {
+			infos = append(infos, sess.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created < infos[j].Created
+	})
+	return infos
+}
+
+func (st *scanSessionStore) Close(id, user string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sess, ok := st.sessions[id]
+	if !ok || !sess.allowed(user) {
+		return ErrScanSessionNotFound
+	}
+	delete(st.sessions, id)
+	sess.destroy()
+	return nil
+}
+
+// CloseIf closes the sessions with an index for which drop returns true,
+// returning the number of sessions closed.
+func (st *scanSessionStore) CloseIf(drop func(inst *common.IndexInst) bool) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	closed := 0
+	for id, sess := range st.sessions {
+		for _, inst := range sess.insts {
+			if drop(&inst) {
+				delete(st.sessions, id)
+				sess.destroy()
+				closed++
+				break
+			}
+		}
+	}
+	return closed
+}
+
+// Expire closes the sessions unused for their ttl, returning the number of
+// sessions closed.
+func (st *scanSessionStore) Expire(now time.Time) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expired := 0
+	for id, sess := range st.sessions {
+		if sess.expired(now) {
+			delete(st.sessions, id)
+			sess.destroy()
+			expired++
+		}
+	}
+	return expired
+}
+
+func (st *scanSessionStore) Len() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.sessions)
+}
+
+func scanSessionConsistencyName(cons common.Consistency) string {
+	if cons == common.SessionConsistency {
+		return "request_plus"
+	}
+	return "not_bounded"
+}
+
+func parseScanSessionConsistency(name string) (common.Consistency, error) {
+	switch name {
+	case "", "not_bounded":
+		return common.AnyConsistency, nil
+	case "request_plus":
+		return common.SessionConsistency, nil
+	}
+	return 0, errors.New("Invalid consistency " + name)
+}
+
+// openScanSession pins consistent snapshots of insts, waiting up to timeout
+// for them.
+func (s *scanCoordinator) openScanSession(insts map[common.IndexInstId]common.IndexInst,
+	cons common.Consistency, user string, ttl, timeout time.Duration) (*scanSession, error) {
+
+	// Bucket seqnos all snapshots need to be as recent as
+	var reqTs map[string]*common.TsVbuuid
+	if cons == common.SessionConsistency {
+		cfg := s.config.Load()
+		cluster, retries := cfg["clusterAddr"].String(), cfg["settings.scan_getseqnos_retries"].Int()
+
+		reqTs = make(map[string]*common.TsVbuuid)
+		for _, inst := range insts {
+			bucket := inst.Defn.Bucket
+			if _, ok := reqTs[bucket]; ok {
+				continue
+			}
+			seqnos, err := bucketSeqsWithRetry(retries, s.logPrefix, cluster, bucket,
+				getNumVBuckets(bucket, cfg), "", true)
+			if err != nil {
+				return nil, err
+			}
+			reqTs[bucket] = &common.TsVbuuid{Bucket: bucket, Seqnos: seqnos}
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if snaps := s.pinScanSessionSnapshots(insts, cons, reqTs); snaps != nil {
+			uuid, err := common.NewUUID()
+			if err != nil {
+				for _, is := range snaps {
+					DestroyIndexSnapshot(is)
+				}
+				return nil, err
+			}
+
+			now := time.Now()
+			return &scanSession{
+				id:       uuid.Str(),
+				user:     user,
+				cons:     cons,
+				ttl:      ttl,
+				created:  now,
+				lastUsed: now,
+				insts:    insts,
+				snaps:    snaps,
+			}, nil
+		}
+
+		if s.isBootstrapMode() && cons != common.AnyConsistency {
+			return nil, common.ErrIndexNotReady
+		}
+		if time.Now().After(deadline) {
+			return nil, common.ErrScanTimedOut
+		}
+		time.Sleep(scanSessionRetryInterval)
+	}
+}
+
+// findScannableInsts returns the instances on this node of defnIds that
+// can be scanned.
+func (s *scanCoordinator) findScannableInsts(defnIds []common.IndexDefnId) (
+	map[common.IndexInstId]common.IndexInst, error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	insts := make(map[common.IndexInstId]common.IndexInst)
+	for _, defnId := range defnIds {
+		found := false
+		for _, instId := range s.indexDefnMap[defnId] {
+			inst := s.indexInstMap[instId]
+			if inst.State != common.INDEX_STATE_ACTIVE ||
+				(inst.RState != common.REBAL_ACTIVE && inst.RState != common.REBAL_PENDING) ||
+				inst.Error == common.ErrIndexEvalSkipped.Error() {
+				continue
+			}
+			insts[instId] = inst
+			found = true
+		}
+
+		if !found {
+			if s.isBootstrapMode() {
+				return nil, common.ErrIndexNotReady
+			}
+			return nil, common.ErrIndexNotFound
+		}
+	}
+	return insts, nil
+}
+
+// pinScanSessionSnapshots returns references to the latest snapshots of
+// insts if they are consistent with each other and with reqTs, or nil.
+func (s *scanCoordinator) pinScanSessionSnapshots(insts map[common.IndexInstId]common.IndexInst,
+	cons common.Consistency, reqTs map[string]*common.TsVbuuid) map[common.IndexInstId]IndexSnapshot {
+
+	lastSnapshot := s.lastSnapshot.Get()
+
+	snaps := make(map[common.IndexInstId]IndexSnapshot)
+	release := func() map[common.IndexInstId]IndexSnapshot {
+		for _, is := range snaps {
+			DestroyIndexSnapshot(is)
+		}
+		return nil
+	}
+
+	bucketTs := make(map[string]*common.TsVbuuid)
+	for instId, inst := range insts {
+		sc, ok := lastSnapshot[instId]
+		if !ok || sc == nil {
+			return release()
+		}
+
+		is := sc.cloneSnapshot()
+		if is == nil {
+			return release()
+		}
+		snaps[instId] = is
+
+		bucket := inst.Defn.Bucket
+		if ts, ok := reqTs[bucket]; ok && !isSnapshotConsistent(is, cons, ts) {
+			return release()
+		}
+		if ts, ok := bucketTs[bucket]; ok && !ts.Equal2(is.Timestamp(), false) {
+			return release()
+		}
+		bucketTs[bucket] = is.Timestamp()
+	}
+	return snaps
+}
+
+// runScanSessionExpiry closes expired scan sessions until stopch is closed.
+func (s *scanCoordinator) runScanSessionExpiry(stopch chan bool) {
+	ticker := time.NewTicker(scanSessionExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n := s.sessions.Expire(time.Now()); n != 0 {
+				scanLog.Infof("%v Expired %v scan sessions", s.logPrefix, n)
+			}
+		case <-stopch:
+			return
+		}
+	}
+}
+
+// getScanSessionSnapshot returns the snapshot pinned by the scan session of
+// r for its index.
+func (s *scanCoordinator) getScanSessionSnapshot(r *ScanRequest) (IndexSnapshot, error) {
+	var user string
+	if creds := r.connCtx.GetCreds(); creds != nil {
+		user = creds.Name()
+	}
+	return s.sessions.Snapshot(r.sessionId, user, r.IndexInstId, time.Now())
+}
+
+// getScanSessionTtl returns the ttl of a session requested with ttl seconds,
+// the configured one if 0, at most scan.session.maxTtl.
+func getScanSessionTtl(ttl int64, cfg common.Config) time.Duration {
+	if ttl == 0 {
+		ttl = int64(cfg["scan.session.ttl"].Int())
+	}
+	if maxTtl := int64(cfg["scan.session.maxTtl"].Int()); ttl > maxTtl {
+		ttl = maxTtl
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+type scanSessionRequest struct {
+	Indexes     []common.IndexDefnId `json:"indexes"`
+	Consistency string               `json:"consistency,omitempty"`
+	Ttl         int64                `json:"ttl,omitempty"` // sec
+}
+
+// handleScanSessionReq opens a scan session with POST, returns open sessions
+// with GET, or the session ?sessionId=, and closes session ?sessionId= with
+// DELETE.
+func (s *scanCoordinator) handleScanSessionReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	user := creds.Name()
+	sessionId := r.URL.Query().Get("sessionId")
+
+	var resp interface{}
+	switch r.Method {
+	case "POST":
+		var req scanSessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+		cons, err := parseScanSessionConsistency(req.Consistency)
+		if err != nil || len(req.Indexes) == 0 || req.Ttl < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Scan session needs indexes, a consistency of not_bounded " +
+				"or request_plus and a ttl in seconds\n"))
+			return
+		}
+
+		insts, err := s.findScannableInsts(req.Indexes)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+		permissions := make([]string, 0, len(insts))
+		for _, inst := range insts {
+			permissions = append(permissions, common.GetScanPermission(&inst.Defn))
+		}
+		if !common.IsAllAllowed(creds, permissions, r, w, "ScanCoordinator::handleScanSessionReq") {
+			return
+		}
+
+		cfg := s.config.Load()
+		ttl := getScanSessionTtl(req.Ttl, cfg)
+		timeout := time.Duration(cfg["scan.session.openTimeout"].Int()) * time.Millisecond
+
+		sess, err := s.openScanSession(insts, cons, user, ttl, timeout)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+		if err := s.sessions.Add(sess, cfg["scan.session.maxSessions"].Int()); err != nil {
+			sess.destroy()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+		scanLog.Infof("%v Opened scan session %v of %v indexes for %v", s.logPrefix,
+			sess.id, len(sess.insts), logging.TagUD(user))
+		resp = sess.info()
+
+	case "GET":
+		if sessionId == "" {
+			resp = s.sessions.List(user, time.Now())
+		} else if resp, err = s.sessions.Get(sessionId, user, time.Now()); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+	case "DELETE":
+		if err := s.sessions.Close(sessionId, user); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+		scanLog.Infof("%v Closed scan session %v", s.logPrefix, sessionId)
+		w.WriteHeader(http.StatusOK)
+		return
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
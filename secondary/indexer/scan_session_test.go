@@ -0,0 +1,126 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestScanSession(id, user, bucket string, instIds []common.IndexInstId,
+	now time.Time) *scanSession {
+
+	sess := &scanSession{
+		id:       id,
+		user:     user,
+		ttl:      time.Minute,
+		created:  now,
+		lastUsed: now,
+		insts:    make(map[common.IndexInstId]common.IndexInst),
+		snaps:    make(map[common.IndexInstId]IndexSnapshot),
+	}
+	for _, instId := range instIds {
+		inst := common.IndexInst{InstId: instId}
+		inst.Defn.Bucket = bucket
+		sess.insts[instId] = inst
+		sess.snaps[instId] = &indexSnapshot{instId: instId, ts: common.NewTsVbuuid(bucket, 4)}
+	}
+	return sess
+}
+
+func TestScanSessionStore(t *testing.T) {
+	now := time.Now()
+	st := newScanSessionStore()
+
+	if err := st.Add(newTestScanSession("s1", "alice", "b1", []common.IndexInstId{1, 2}, now), 2); err != nil {
+		t.Fatalf("unexpected error adding session: %v", err)
+	}
+	if err := st.Add(newTestScanSession("s2", "bob", "b2", []common.IndexInstId{3}, now), 2); err != nil {
+		t.Fatalf("unexpected error adding session: %v", err)
+	}
+	if err := st.Add(newTestScanSession("s3", "bob", "b2", []common.IndexInstId{3}, now), 2); err != ErrScanSessionLimit {
+		t.Fatalf("expected ErrScanSessionLimit, got %v", err)
+	}
+
+	is, err := st.Snapshot("s1", "alice", 2, now.Add(30*time.Second))
+	if err != nil || is == nil || is.IndexInstId() != 2 {
+		t.Fatalf("expected snapshot of inst 2, got %v, %v", is, err)
+	}
+	DestroyIndexSnapshot(is)
+
+	if _, err := st.Snapshot("s1", "alice", 3, now); err != ErrNotInScanSession {
+		t.Fatalf("expected ErrNotInScanSession, got %v", err)
+	}
+	if _, err := st.Snapshot("s1", "bob", 1, now); err != ErrScanSessionNotFound {
+		t.Fatalf("expected ErrScanSessionNotFound for another user, got %v", err)
+	}
+
+	if infos := st.List("bob", now); len(infos) != 1 || infos[0].SessionId != "s2" {
+		t.Fatalf("expected only session s2 listed for bob, got %v", infos)
+	}
+	info, err := st.Get("s1", "alice", now)
+	if err != nil || info.NumScans != 1 || len(info.Indexes) != 2 {
+		t.Fatalf("unexpected info of session s1 %+v, %v", info, err)
+	}
+
+	// The scan at 30s extended s1, s2 expires at 1m
+	if n := st.Expire(now.Add(time.Minute)); n != 1 {
+		t.Fatalf("expected 1 session expired, got %v", n)
+	}
+	if _, err := st.Snapshot("s2", "bob", 3, now.Add(time.Minute)); err != ErrScanSessionNotFound {
+		t.Fatalf("expected ErrScanSessionNotFound for expired session, got %v", err)
+	}
+
+	if n := st.CloseIf(func(inst *common.IndexInst) bool { return inst.Defn.Bucket == "b2" }); n != 0 {
+		t.Fatalf("expected no session closed, got %v", n)
+	}
+	if n := st.CloseIf(func(inst *common.IndexInst) bool { return inst.InstId == 2 }); n != 1 {
+		t.Fatalf("expected session s1 closed, got %v", n)
+	}
+	if st.Len() != 0 {
+		t.Fatalf("expected no open sessions, got %v", st.Len())
+	}
+	if err := st.Close("s1", "alice"); err != ErrScanSessionNotFound {
+		t.Fatalf("expected ErrScanSessionNotFound closing a closed session, got %v", err)
+	}
+}
+
+func TestScanSessionConsistency(t *testing.T) {
+	for name, cons := range map[string]common.Consistency{
+		"":             common.AnyConsistency,
+		"not_bounded":  common.AnyConsistency,
+		"request_plus": common.SessionConsistency,
+	} {
+		if c, err := parseScanSessionConsistency(name); err != nil || c != cons {
+			t.Fatalf("expected consistency %v for %q, got %v, %v", cons, name, c, err)
+		}
+	}
+	if _, err := parseScanSessionConsistency("at_plus"); err == nil {
+		t.Fatalf("expected error for unsupported consistency")
+	}
+}
+
+func TestScanSessionTtl(t *testing.T) {
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	cfg.SetValue("scan.session.ttl", 300)
+	cfg.SetValue("scan.session.maxTtl", 3600)
+
+	for ttl, expected := range map[int64]time.Duration{
+		0:       300 * time.Second,
+		60:      60 * time.Second,
+		3600:    3600 * time.Second,
+		1 << 40: 3600 * time.Second,
+	} {
+		if got := getScanSessionTtl(ttl, cfg); got != expected {
+			t.Errorf("ttl %v: expected %v, got %v", ttl, expected, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/stats"
+)
+
+// The scans of an index are counted by the shape of their spans, with
+// their latencies, as num_scans_<shape> and scan_req_latency_dist_<shape>,
+// to tell whether the index serves the access patterns it was created
+// for. An index created for point lookups which mostly serves full scans
+// is likely better replaced.
+
+type scanShape int
+
+const (
+	scanShapePoint scanShape = iota // one span, equality on all index keys
+	scanShapeRange                  // one span, bounded on at least one side
+	scanShapeFull                   // one span, unbounded on both sides
+	scanShapeMulti                  // more than one span
+
+	numScanShapes
+)
+
+var scanShapeNames = [numScanShapes]string{
+	scanShapePoint: "point_lookup",
+	scanShapeRange: "range",
+	scanShapeFull:  "full",
+	scanShapeMulti: "multi_span",
+}
+
+func (s scanShape) String() string {
+	return scanShapeNames[s]
+}
+
+type scanShapeStats struct {
+	numScans    stats.Int64Val
+	latencyDist stats.Histogram
+}
+
+func (s *scanShapeStats) Init() {
+	s.numScans.Init()
+	s.latencyDist.InitLatency(scanReqLatencyDist,
+		func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
+}
+
+// scanShape returns the shape of the spans of the request.
+func (r *ScanRequest) scanShape() scanShape {
+
+	if len(r.Scans) > 1 {
+		return scanShapeMulti
+	}
+	if len(r.Scans) == 0 {
+		return scanShapeFull
+	}
+
+	scan := r.Scans[0]
+	switch scan.ScanType {
+	case AllReq:
+		return scanShapeFull
+	case LookupReq:
+		return scanShapePoint
+	}
+
+	if scan.Low == MinIndexKey && scan.High == MaxIndexKey {
+		return scanShapeFull
+	}
+
+	if r.isPrimary {
+		if scan.Low != nil && scan.High != nil && scan.Incl == Both &&
+			scan.Low.ComparePrefixIndexKey(scan.High) == 0 {
+			return scanShapePoint
+		}
+		return scanShapeRange
+	}
+
+	for i := range r.IndexInst.Defn.SecExprs {
+		if r.equalFilterValue(i) == nil {
+			return scanShapeRange
+		}
+	}
+	return scanShapePoint
+}
+
+// recordScanShape counts a scan of the given shape which took elapsed
+// nanoseconds.
+func (s *IndexStats) recordScanShape(shape scanShape, elapsed int64) {
+	s.scanShapes[shape].numScans.Add(1)
+	s.scanShapes[shape].latencyDist.Add(elapsed)
+}
+
+func (s *IndexStats) addScanShapeStats(statMap *StatsMap) {
+	for shape := scanShape(0); shape < numScanShapes; shape++ {
+		statMap.AddStatValueFiltered("num_scans_"+shape.String(), &s.scanShapes[shape].numScans)
+		statMap.AddStatValueFiltered("scan_req_latency_dist_"+shape.String(), &s.scanShapes[shape].latencyDist)
+	}
+}
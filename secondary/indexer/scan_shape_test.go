@@ -0,0 +1,70 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanShape(t *testing.T) {
+	key := func(k string) IndexKey {
+		ik, err := NewSecondaryKey([]byte(k), make([]byte, 0, 3*len(k)+ENCODE_BUF_SAFE_PAD), true, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ik
+	}
+	filter := func(low, high IndexKey) CompositeElementFilter {
+		return CompositeElementFilter{Low: low, High: high, Inclusion: Both}
+	}
+	scan := func(filters ...CompositeElementFilter) Scan {
+		return Scan{Low: filters[0].Low, High: filters[0].High, ScanType: FilterRangeReq,
+			Filters: []Filter{{CompositeFilters: filters}}}
+	}
+
+	tests := []struct {
+		name  string
+		scans []Scan
+		shape scanShape
+	}{
+		{"scan all", []Scan{{ScanType: AllReq}}, scanShapeFull},
+		{"unbounded", []Scan{{Low: MinIndexKey, High: MaxIndexKey, ScanType: RangeReq}}, scanShapeFull},
+		{"lookup", []Scan{{Equals: key(`["a",1]`), ScanType: LookupReq}}, scanShapePoint},
+		{"all keys equal", []Scan{scan(filter(key(`"a"`), key(`"a"`)), filter(key(`1`), key(`1`)))},
+			scanShapePoint},
+		{"leading key equal", []Scan{scan(filter(key(`"a"`), key(`"a"`)), filter(MinIndexKey, MaxIndexKey))},
+			scanShapeRange},
+		{"range", []Scan{scan(filter(key(`"a"`), key(`"c"`)))}, scanShapeRange},
+		{"spans", []Scan{scan(filter(key(`"a"`), key(`"a"`))), scan(filter(key(`"c"`), key(`"c"`)))},
+			scanShapeMulti},
+	}
+
+	for _, test := range tests {
+		r := &ScanRequest{Scans: test.scans}
+		r.IndexInst.Defn.SecExprs = []string{"k0", "k1"}
+		if shape := r.scanShape(); shape != test.shape {
+			t.Errorf("%v: expected shape %v, got %v", test.name, test.shape, shape)
+		}
+	}
+
+	var s IndexStats
+	for i := range s.scanShapes {
+		s.scanShapes[i].Init()
+	}
+	s.recordScanShape(scanShapePoint, int64(time.Millisecond))
+	s.recordScanShape(scanShapePoint, int64(time.Millisecond))
+	s.recordScanShape(scanShapeMulti, int64(time.Second))
+	if n := s.scanShapes[scanShapePoint].numScans.Value(); n != 2 {
+		t.Errorf("expected 2 point lookups, got %v", n)
+	}
+	if n := s.scanShapes[scanShapeFull].numScans.Value(); n != 0 {
+		t.Errorf("expected no full scans, got %v", n)
+	}
+}
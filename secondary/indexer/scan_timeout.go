@@ -0,0 +1,147 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// A single settings.scan_timeout either times out scans of large indexes
+// that are slow but progressing, or leaves scans of small indexes that are
+// stuck running for long. With settings.scan_timeout.adaptive set, the
+// timeout of a scan is instead derived from the latencies of the recent
+// scans of its index: settings.scan_timeout.multiplier times their
+// settings.scan_timeout.percentile, within settings.scan_timeout.floor and
+// settings.scan_timeout.ceiling. Until an index has
+// minAdaptiveTimeoutSamples scans, its scans use settings.scan_timeout.
+//
+// The timeout of an index is recomputed at most every
+// adaptiveTimeoutRefresh, as sorting the window on every scan is not cheap.
+
+const (
+	latencyWindowSize         = 1024
+	minAdaptiveTimeoutSamples = 32
+	adaptiveTimeoutRefresh    = time.Second
+)
+
+// latencyWindow holds the last latencyWindowSize scan latencies of an index.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [latencyWindowSize]int64
+	next    int
+	full    bool
+
+	timeout   time.Duration // last computed adaptive timeout, 0 if none
+	refreshed time.Time
+}
+
+func (w *latencyWindow) add(d int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.next] = d
+	w.next++
+	if w.next == latencyWindowSize {
+		w.next = 0
+		w.full = true
+	}
+}
+
+func (w *latencyWindow) len() int {
+	if w.full {
+		return latencyWindowSize
+	}
+	return w.next
+}
+
+// percentile returns the p-th percentile of the latencies in the window and
+// the number of latencies. Must be called with w.mu held.
+func (w *latencyWindow) percentile(p float64) (int64, int) {
+	n := w.len()
+	if n == 0 {
+		return 0, 0
+	}
+
+	sorted := make([]int64, n)
+	copy(sorted, w.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(p / 100 * float64(n))
+	if i >= n {
+		i = n - 1
+	} else if i < 0 {
+		i = 0
+	}
+	return sorted[i], n
+}
+
+// adaptiveTimeout returns the timeout of the next scan of the index, or 0
+// to use settings.scan_timeout.
+func (w *latencyWindow) adaptiveTimeout(cfg common.Config, now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if now.Sub(w.refreshed) < adaptiveTimeoutRefresh {
+		return w.timeout
+	}
+	w.refreshed = now
+
+	w.timeout = 0
+	lat, n := w.percentile(cfg["settings.scan_timeout.percentile"].Float64())
+	if n < minAdaptiveTimeoutSamples {
+		return 0
+	}
+
+	floor := time.Duration(cfg["settings.scan_timeout.floor"].Int()) * time.Millisecond
+	ceiling := time.Duration(cfg["settings.scan_timeout.ceiling"].Int()) * time.Millisecond
+	w.timeout = clampTimeout(time.Duration(float64(lat)*cfg["settings.scan_timeout.multiplier"].Float64()),
+		floor, ceiling)
+	return w.timeout
+}
+
+func clampTimeout(timeout, floor, ceiling time.Duration) time.Duration {
+	if ceiling > 0 && timeout > ceiling {
+		timeout = ceiling
+	}
+	if timeout < floor {
+		timeout = floor
+	}
+	return timeout
+}
+
+// setAdaptiveTimeout replaces the settings.scan_timeout timer of the
+// request with the adaptive timeout of its index.
+func (r *ScanRequest) setAdaptiveTimeout() {
+	if r.Timeout == nil || r.Stats == nil || r.Stats.scanLatencies == nil {
+		return
+	}
+
+	cfg := r.sco.config.Load()
+	if !cfg["settings.scan_timeout.adaptive"].Bool() {
+		return
+	}
+
+	now := time.Now()
+	timeout := r.Stats.scanLatencies.adaptiveTimeout(cfg, now)
+	r.Stats.adaptiveScanTimeout.Set(int64(timeout / time.Millisecond))
+	if timeout == 0 {
+		return
+	}
+
+	if !r.Timeout.Stop() {
+		// The default timeout already fired, nothing to adapt
+		return
+	}
+	r.Timeout.Reset(timeout)
+	r.ExpiredTime = now.Add(timeout)
+}
@@ -0,0 +1,67 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestAdaptiveScanTimeout(t *testing.T) {
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	now := time.Now()
+
+	w := &latencyWindow{}
+	for i := 1; i < minAdaptiveTimeoutSamples; i++ {
+		w.add(int64(i) * int64(time.Second))
+	}
+	if timeout := w.adaptiveTimeout(cfg, now); timeout != 0 {
+		t.Fatalf("expected no timeout with too few samples, got %v", timeout)
+	}
+
+	// Uniform over 1..100s, p99 is 100s and 4 x p99 is above the ceiling
+	for i := 0; i < 2*latencyWindowSize; i++ {
+		w.add(int64(i%100+1) * int64(time.Second))
+	}
+	if timeout := w.adaptiveTimeout(cfg, now); timeout != 0 {
+		t.Fatalf("expected the timeout not to be refreshed yet, got %v", timeout)
+	}
+	now = now.Add(adaptiveTimeoutRefresh)
+	if timeout := w.adaptiveTimeout(cfg, now); timeout != 300*time.Second {
+		t.Fatalf("expected the ceiling, got %v", timeout)
+	}
+
+	// Window of 1ms scans, below the floor
+	for i := 0; i < latencyWindowSize; i++ {
+		w.add(int64(time.Millisecond))
+	}
+	now = now.Add(adaptiveTimeoutRefresh)
+	if timeout := w.adaptiveTimeout(cfg, now); timeout != 5*time.Second {
+		t.Fatalf("expected the floor, got %v", timeout)
+	}
+
+	w = &latencyWindow{}
+	for i := 1; i <= 100; i++ {
+		w.add(int64(i) * int64(100*time.Millisecond))
+	}
+	if timeout := w.adaptiveTimeout(cfg, now); timeout != 40*time.Second {
+		t.Fatalf("expected 4 x p99 of 10s, got %v", timeout)
+	}
+}
+
+func TestClampTimeout(t *testing.T) {
+	if timeout := clampTimeout(time.Hour, time.Second, 0); timeout != time.Hour {
+		t.Fatalf("expected no ceiling, got %v", timeout)
+	}
+	if timeout := clampTimeout(time.Millisecond, time.Second, time.Minute); timeout != time.Second {
+		t.Fatalf("expected the floor, got %v", timeout)
+	}
+}
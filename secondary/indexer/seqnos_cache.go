@@ -0,0 +1,129 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Each session consistent scan reads the current seqnos of its collection
+// (or bucket) from KV. With scan.seqnosCache.ttl set, the scans of a
+// collection share a fetch of its seqnos started at most ttl before they
+// arrived, whether it is still in progress or completed. A burst of
+// session consistent scans on a collection then makes one request to KV
+// per ttl instead of one per scan.
+//
+// A scan can miss mutations acknowledged in the ttl before it arrived, so
+// session consistency is relaxed by up to ttl. The cache is disabled by
+// default.
+
+type seqnosCacheKey struct {
+	bucket          string
+	cid             string
+	useBucketSeqnos bool
+}
+
+// seqnosFetch is a fetch of the seqnos of a collection, shared by the scans
+// waiting on done.
+type seqnosFetch struct {
+	start  time.Time
+	done   chan struct{}
+	seqnos []uint64
+	err    error
+}
+
+// seqnosCache keeps the latest fetch of each collection scanned, so it
+// holds at most one entry per collection.
+type seqnosCache struct {
+	mu      sync.Mutex
+	fetches map[seqnosCacheKey]*seqnosFetch
+}
+
+func newSeqnosCache() *seqnosCache {
+	return &seqnosCache{
+		fetches: make(map[seqnosCacheKey]*seqnosFetch),
+	}
+}
+
+// Get returns the seqnos of a fetch of key started at most ttl before now,
+// waiting for it if needed, or the seqnos returned by fetch otherwise. hit
+// tells whether fetch was not called. The returned seqnos are owned by the
+// caller.
+func (c *seqnosCache) Get(key seqnosCacheKey, ttl time.Duration, now time.Time,
+	fetch func() ([]uint64, error)) (seqnos []uint64, hit bool, err error) {
+
+	c.mu.Lock()
+	f := c.fetches[key]
+	if f != nil && !f.start.Before(now.Add(-ttl)) {
+		c.mu.Unlock()
+
+		<-f.done
+		if f.err != nil {
+			return nil, true, f.err
+		}
+		return append([]uint64(nil), f.seqnos...), true, nil
+	}
+
+	f = &seqnosFetch{start: now, done: make(chan struct{})}
+	c.fetches[key] = f
+	c.mu.Unlock()
+
+	f.seqnos, f.err = fetch()
+	close(f.done)
+
+	if f.err != nil {
+		c.mu.Lock()
+		if c.fetches[key] == f {
+			delete(c.fetches, key)
+		}
+		c.mu.Unlock()
+		return nil, false, f.err
+	}
+	return append([]uint64(nil), f.seqnos...), false, nil
+}
+
+func (c *seqnosCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.fetches)
+}
+
+// sessionSeqnos returns the seqnos a session consistent scan of req waits
+// for, from the cache if enabled.
+func (s *scanCoordinator) sessionSeqnos(req *ScanRequest, cfg common.Config) ([]uint64, error) {
+	useBucketSeqnos := cfg["use_bucket_seqnos"].Bool()
+	fetch := func() ([]uint64, error) {
+		return bucketSeqsWithRetry(cfg["settings.scan_getseqnos_retries"].Int(),
+			req.LogPrefix, cfg["clusterAddr"].String(), req.Bucket,
+			getNumVBuckets(req.Bucket, cfg), req.CollectionId, useBucketSeqnos)
+	}
+
+	ttl := time.Duration(cfg["scan.seqnosCache.ttl"].Int()) * time.Millisecond
+	if ttl <= 0 {
+		return fetch()
+	}
+
+	key := seqnosCacheKey{bucket: req.Bucket, useBucketSeqnos: useBucketSeqnos}
+	if !useBucketSeqnos {
+		key.cid = req.CollectionId
+	}
+
+	seqnos, hit, err := s.seqnosCache.Get(key, ttl, time.Now(), fetch)
+	if req.Stats != nil {
+		if hit {
+			req.Stats.numSeqnosCacheHits.Add(1)
+		} else {
+			req.Stats.numSeqnosCacheMisses.Add(1)
+		}
+	}
+	return seqnos, err
+}
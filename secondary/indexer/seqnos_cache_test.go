@@ -0,0 +1,103 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSeqnosCache(t *testing.T) {
+	c := newSeqnosCache()
+	key := seqnosCacheKey{bucket: "b", cid: "8"}
+	ttl := 10 * time.Millisecond
+	now := time.Now()
+
+	var fetches int32
+	fetch := func() ([]uint64, error) {
+		atomic.AddInt32(&fetches, 1)
+		return []uint64{1, 2}, nil
+	}
+
+	seqnos, hit, err := c.Get(key, ttl, now, fetch)
+	if err != nil || hit || len(seqnos) != 2 {
+		t.Fatalf("expected a miss, got %v %v %v", seqnos, hit, err)
+	}
+
+	// The caller owns the seqnos returned
+	seqnos[0] = 100
+	seqnos, hit, err = c.Get(key, ttl, now.Add(ttl), fetch)
+	if err != nil || !hit || seqnos[0] != 1 {
+		t.Fatalf("expected a hit on the first fetch, got %v %v %v", seqnos, hit, err)
+	}
+
+	if _, hit, _ = c.Get(seqnosCacheKey{bucket: "b", cid: "9"}, ttl, now, fetch); hit {
+		t.Fatalf("expected a miss on another collection")
+	}
+	if _, hit, _ = c.Get(key, ttl, now.Add(ttl+1), fetch); hit {
+		t.Fatalf("expected a miss after ttl")
+	}
+	if fetches != 3 || c.Len() != 2 {
+		t.Fatalf("expected 3 fetches of 2 collections, got %v of %v", fetches, c.Len())
+	}
+
+	// A failed fetch is not cached
+	failed := errors.New("failed")
+	key = seqnosCacheKey{bucket: "b", cid: "10"}
+	if _, _, err = c.Get(key, ttl, now, func() ([]uint64, error) { return nil, failed }); err != failed {
+		t.Fatalf("expected the fetch error, got %v", err)
+	}
+	if _, hit, err = c.Get(key, ttl, now, fetch); hit || err != nil {
+		t.Fatalf("expected a miss after a failed fetch, got %v %v", hit, err)
+	}
+}
+
+func TestSeqnosCacheSharedFetch(t *testing.T) {
+	c := newSeqnosCache()
+	key := seqnosCacheKey{bucket: "b", useBucketSeqnos: true}
+	now := time.Now()
+
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() ([]uint64, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return []uint64{1}, nil
+	}
+
+	// Scans arriving during a fetch wait for it
+	var wg sync.WaitGroup
+	hits := make([]bool, 8)
+	for i := range hits {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, hits[i], _ = c.Get(key, time.Second, now, fetch)
+		}(i)
+		if i == 0 {
+			for atomic.LoadInt32(&fetches) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Fatalf("expected 1 fetch, got %v", fetches)
+	}
+	for i, hit := range hits {
+		if hit != (i > 0) {
+			t.Fatalf("expected only the first scan to fetch, got %v", hits)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/logging/systemevent"
 	"github.com/couchbase/indexing/secondary/pipeline"
+	"github.com/couchbase/indexing/secondary/queryport"
 	"github.com/couchbase/indexing/secondary/stubs/nitro/mm"
 	"github.com/couchbase/indexing/secondary/stubs/nitro/plasma"
 	"github.com/couchbase/indexing/secondary/system"
@@ -27,9 +28,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -47,6 +50,9 @@ type settingsManager struct {
 	compactionToken []byte
 	indexerReady    bool
 	notifyPending   bool
+	history         *settingsHistory
+
+	mu sync.Mutex // serializes config updates from metakv and cgroup limit changes
 }
 
 // NewSettingsManager is the settingsManager constructor. Indexer creates a child singleton of this.
@@ -58,20 +64,14 @@ func NewSettingsManager(supvCmdch MsgChannel,
 		supvMsgch: supvMsgch,
 		config:    config,
 		cancelCh:  make(chan struct{}),
+		history:   newSettingsHistory(),
 	}
 
 	// Set cgroup overrides; these will be 0 if cgroups are not supported. Must be set before
 	// calling GetSettingsConfig else that can result in an async callback based on the (now stale)
 	// version of config that was passed to it when called, wiping out these new config settings.
 	sigarMemoryMax, sigarNumCpuPrc := sigarGetMemoryMaxAndNumCpuPrc()
-	const memKey = "indexer.cgroup.memory_quota"
-	const cpuKey = "indexer.cgroup.max_cpu_percent"
-	value := config[memKey]
-	value.Value = sigarMemoryMax
-	config[memKey] = value
-	value = config[cpuKey]
-	value.Value = sigarNumCpuPrc
-	config[cpuKey] = value
+	setCgroupLimits(config, sigarMemoryMax, sigarNumCpuPrc)
 
 	// This method will merge metakv indexer settings onto default settings.
 	config, err := common.GetSettingsConfig(config)
@@ -86,6 +86,9 @@ func NewSettingsManager(supvCmdch MsgChannel,
 
 	// Initialize the global config settings
 	s.setGlobalSettings(nil, config)
+	if current, _, err := metakv.Get(common.IndexingSettingsMetaPath); err == nil && len(current) > 0 {
+		s.recordSettings(nil, config, current)
+	}
 
 	go func() {
 		fn := func(r int, err error) error {
@@ -140,6 +143,62 @@ func sigarGetMemoryMaxAndNumCpuPrc() (uint64, int) {
 	return cgroupInfo.MemoryMax, int(cgroupInfo.NumCpuPrc)
 }
 
+// setCgroupLimits sets the cgroup overrides of config to the given sigar values and returns
+// whether any of them changed.
+func setCgroupLimits(config common.Config, memoryMax uint64, numCpuPrc int) bool {
+	const memKey = "indexer.cgroup.memory_quota"
+	const cpuKey = "indexer.cgroup.max_cpu_percent"
+
+	changed := false
+	if value := config[memKey]; value.Uint64() != memoryMax {
+		value.Value = memoryMax
+		config[memKey] = value
+		changed = true
+	}
+	if value := config[cpuKey]; value.Int() != numCpuPrc {
+		value.Value = numCpuPrc
+		config[cpuKey] = value
+		changed = true
+	}
+	return changed
+}
+
+// refreshCgroupLimits re-reads the cgroup limits, which change when the container is resized
+// (e.g. by Kubernetes vertical scaling), and propagates the resulting memory quota and number
+// of CPUs to the indexer.
+func (s *settingsManager) refreshCgroupLimits() {
+	if !s.indexerReady {
+		return
+	}
+
+	memoryMax, numCpuPrc := sigarGetMemoryMaxAndNumCpuPrc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldConfig := s.config
+	newConfig := s.config.Clone()
+	if !setCgroupLimits(newConfig, memoryMax, numCpuPrc) {
+		return
+	}
+
+	logging.Infof("SettingsManager::refreshCgroupLimits cgroup limits changed: memory_max %v -> %v,"+
+		" num_cpu_prc %v -> %v", oldConfig["indexer.cgroup.memory_quota"].Uint64(), memoryMax,
+		oldConfig["indexer.cgroup.max_cpu_percent"].Int(), numCpuPrc)
+
+	s.setGlobalSettings(oldConfig, newConfig)
+	s.config = newConfig
+
+	s.supvMsgch <- &MsgConfigUpdate{
+		cfg: s.config.SectionConfig("indexer.", true),
+	}
+}
+
+// cgroupPollInterval returns the interval between refreshes of the cgroup limits, 0 if disabled.
+func (s *settingsManager) cgroupPollInterval() time.Duration {
+	return time.Duration(s.config["indexer.cgroup.poll_interval"].Uint64()) * time.Second
+}
+
 func (s *settingsManager) RegisterRestEndpoints() {
 	mux := GetHTTPMux()
 	mux.HandleFunc("/settings", s.handleSettingsReq)
@@ -147,6 +206,9 @@ func (s *settingsManager) RegisterRestEndpoints() {
 	mux.HandleFunc("/triggerCompaction", s.handleCompactionTrigger)
 	mux.HandleFunc("/settings/runtime/freeMemory", s.handleFreeMemoryReq)
 	mux.HandleFunc("/settings/runtime/forceGC", s.handleForceGCReq)
+	mux.HandleFunc("/settings/runtime/rotateLog", s.handleRotateLogReq)
+	mux.HandleFunc("/settings/history", s.handleSettingsHistoryReq)
+	mux.HandleFunc("/settings/revert", s.handleSettingsRevertReq)
 	mux.HandleFunc("/plasmaDiag", s.handlePlasmaDiag)
 }
 
@@ -303,7 +365,15 @@ func (s *settingsManager) handlePlasmaDiag(w http.ResponseWriter, r *http.Reques
 func (s *settingsManager) run() {
 loop:
 	for {
+		var pollch <-chan time.Time
+		if interval := s.cgroupPollInterval(); interval > 0 {
+			pollch = time.After(interval)
+		}
+
 		select {
+		case <-pollch:
+			s.refreshCgroupLimits()
+
 		case cmd, ok := <-s.supvCmdch:
 			if ok {
 				if cmd.GetMsgType() == STORAGE_MGR_SHUTDOWN {
@@ -393,6 +463,9 @@ func (s *settingsManager) applySettings(path string, value []byte, rev interface
 		return nil
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	oldConfig := s.config.Clone()
 
 	newConfig := s.config.Clone()
@@ -400,6 +473,7 @@ func (s *settingsManager) applySettings(path string, value []byte, rev interface
 	s.setGlobalSettings(s.config, newConfig)
 
 	s.config = newConfig
+	s.recordSettings(oldConfig, newConfig, value)
 
 	indexerConfig := s.config.SectionConfig("indexer.", true)
 	s.supvMsgch <- &MsgConfigUpdate{
@@ -451,6 +525,25 @@ func (s *settingsManager) handleForceGCReq(w http.ResponseWriter, r *http.Reques
 	s.writeOk(w)
 }
 
+func (s *settingsManager) handleRotateLogReq(w http.ResponseWriter, r *http.Request) {
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.settings!write"}, r, w,
+		"SettingsManager::handleRotateLogReq") {
+		return
+	}
+
+	logging.Infof("Received log rotation request")
+	if err := logging.RotateLog(); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeOk(w)
+}
+
 func (s *settingsManager) handleIndexerReady() {
 
 	s.supvCmdch <- &MsgSuccess{}
@@ -473,11 +566,54 @@ func (s *settingsManager) handleIndexerReady() {
 
 }
 
+var logComponents = []string{
+	logging.StorageMgrComponent,
+	logging.ScanComponent,
+	logging.RebalanceComponent,
+	logging.DcpComponent,
+}
+
 func setLogger(config common.Config) {
 	logLevel := config["indexer.settings.log_level"].String()
 	level := logging.Level(logLevel)
 	logging.Infof("Setting log level to %v", level)
 	logging.SetLogLevel(level)
+
+	for _, component := range logComponents {
+		cv, ok := config["indexer.settings.log_level."+component]
+		if !ok || cv.String() == "" {
+			logging.ResetComponentLogLevel(component)
+			continue
+		}
+		level := logging.Level(cv.String())
+		logging.Infof("Setting log level of %v to %v", component, level)
+		logging.SetComponentLogLevel(component, level)
+	}
+}
+
+const indexerLogFile = "indexer_gsi.log"
+
+// setLogRotation switches the default logger between stdout and a file
+// under log_dir rotated by the logging package.
+func setLogRotation(n common.Config) {
+	logDir := n["indexer.log_dir"].String()
+	if !n["indexer.settings.log_rotation.enable"].Bool() || logDir == "" {
+		logging.ResetLogFile(os.Stdout)
+		return
+	}
+
+	cfg := logging.RotateConfig{
+		MaxSize:  int64(n["indexer.settings.log_rotation.max_size"].Int()),
+		Interval: time.Duration(n["indexer.settings.log_rotation.interval"].Int()) * time.Second,
+		MaxFiles: n["indexer.settings.log_rotation.max_files"].Int(),
+		Compress: n["indexer.settings.log_rotation.compress"].Bool(),
+	}
+	path := filepath.Join(logDir, indexerLogFile)
+	if err := logging.SetLogFile(path, cfg); err != nil {
+		logging.Errorf("Unable to enable log rotation for %v: %v", path, err)
+		return
+	}
+	logging.Infof("Log rotation enabled for %v with %+v", path, cfg)
 }
 
 func setBlockPoolSize(o, n common.Config) {
@@ -512,6 +648,7 @@ func (s *settingsManager) setGlobalSettings(oldCfg, newCfg common.Config) {
 		_setGlobalSettings, ncpu, memoryQuota, memoryQuota/1024, memoryQuota/(1024*1024),
 		memoryQuota/(1024*1024*1024))
 
+	setLogRotation(newCfg)
 	setLogger(newCfg)
 	useMutationSyncPool = newCfg["indexer.useMutationSyncPool"].Bool()
 
@@ -586,6 +723,21 @@ func validateSettings(value []byte, current common.Config, internal bool) error
 		}
 	}
 
+	if val, ok := newConfig["indexer.scan.pools.priority"]; ok {
+		if _, err := parseScanPoolPriorities(val.String()); err != nil {
+			return fmt.Errorf("indexer.scan.pools.priority should be a JSON object of "+
+				"high, normal or low priorities: %v", err)
+		}
+	}
+
+	if len(newConfig.SectionConfig("indexer.queryport.auth.", false)) > 0 {
+		authConfig := current.SectionConfig("indexer.queryport.", true).Override(
+			newConfig.SectionConfig("indexer.queryport.", true))
+		if _, err := queryport.NewAuthenticator(authConfig); err != nil {
+			return fmt.Errorf("Invalid indexer.queryport.auth settings: %v", err)
+		}
+	}
+
 	if !internal {
 		if val, ok := newConfig["indexer.settings.storage_mode"]; ok {
 			if len(val.String()) != 0 {
@@ -0,0 +1,235 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth/metakv"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// The settings manager keeps the last maxSettingsHistory versions of the
+// settings it applied, with the changes of each, listed by
+// /settings/history. A POST to /settings/revert reverts the settings to
+// the version applied before the current one, to recover from a bad
+// settings change. The reverted settings are written to metakv, for all
+// the components of all indexer nodes to apply them like any other change,
+// and only if the settings have not changed meanwhile. Successive reverts
+// walk back the history.
+//
+// Versions keep the settings as they were in metakv, rather than the
+// settings config they resolved to, so that a revert does not write the
+// defaults of settings never set, which would then stop following changes
+// of the defaults.
+
+const maxSettingsHistory = 10
+
+var errNoPreviousSettings = errors.New("No previous settings to revert to")
+
+// settingsVersion is a version of the settings applied.
+type settingsVersion struct {
+	Version    int64                  `json:"version"`
+	Time       time.Time              `json:"time"`
+	Previous   map[string]interface{} `json:"previous,omitempty"`
+	Changed    map[string]interface{} `json:"changed,omitempty"`
+	RevertedTo int64                  `json:"revertedTo,omitempty"`
+
+	settings []byte // as written to metakv
+}
+
+type settingsHistory struct {
+	mu       sync.Mutex
+	versions []*settingsVersion // oldest first
+	next     int64
+	revertTo int64 // version being reverted to, 0 if none
+}
+
+func newSettingsHistory() *settingsHistory {
+	return &settingsHistory{next: 1}
+}
+
+// record records the settings applied at now, with the previous and new
+// values of the settings changed. It returns nil if the settings are those
+// of the current version.
+func (h *settingsHistory) record(settings []byte, previous, changed map[string]interface{},
+	now time.Time) *settingsVersion {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n := len(h.versions); n != 0 && bytes.Equal(h.versions[n-1].settings, settings) {
+		return nil
+	}
+
+	v := &settingsVersion{
+		Version:  h.next,
+		Time:     now,
+		Previous: previous,
+		Changed:  changed,
+		settings: settings,
+	}
+	h.next++
+
+	if h.revertTo != 0 {
+		if target := h.find(h.revertTo); target != nil && bytes.Equal(target.settings, settings) {
+			v.RevertedTo = h.revertTo
+		}
+		h.revertTo = 0
+	}
+
+	h.versions = append(h.versions, v)
+	if len(h.versions) > maxSettingsHistory {
+		h.versions = h.versions[len(h.versions)-maxSettingsHistory:]
+	}
+	return v
+}
+
+func (h *settingsHistory) find(version int64) *settingsVersion {
+	for _, v := range h.versions {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// previous returns the version applied before the current one. If the
+// current version is a revert, it is the version applied before the one
+// reverted to.
+func (h *settingsHistory) previous() *settingsVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.versions) == 0 {
+		return nil
+	}
+
+	current := h.versions[len(h.versions)-1]
+	for current.RevertedTo != 0 {
+		target := h.find(current.RevertedTo)
+		if target == nil {
+			break
+		}
+		current = target
+	}
+
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		if v := h.versions[i]; v.Version < current.Version {
+			return v
+		}
+	}
+	return nil
+}
+
+// reverting marks the next settings recorded as a revert to version, if
+// they are its settings.
+func (h *settingsHistory) reverting(version int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.revertTo = version
+}
+
+// list returns the versions, latest first.
+func (h *settingsHistory) list() []settingsVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := make([]settingsVersion, 0, len(h.versions))
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		versions = append(versions, *h.versions[i])
+	}
+	return versions
+}
+
+// recordSettings records the settings of newConfig once applied, from
+// value in metakv.
+func (s *settingsManager) recordSettings(oldConfig, newConfig common.Config, value []byte) {
+	var previous, changed map[string]interface{}
+	if oldConfig != nil {
+		diffOld, diffNew := oldConfig.FilterConfig(".settings.").Diff(newConfig.FilterConfig(".settings."))
+		previous, changed = diffOld.Map(), diffNew.Map()
+	}
+
+	v := s.history.record(value, previous, changed, time.Now())
+	if v != nil && v.RevertedTo != 0 {
+		logging.Infof("SettingsManager::recordSettings Settings version %v reverted to version %v",
+			v.Version, v.RevertedTo)
+	}
+}
+
+func (s *settingsManager) handleSettingsHistoryReq(w http.ResponseWriter, r *http.Request) {
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.settings!read"}, r, w,
+		"SettingsManager::handleSettingsHistoryReq") {
+		return
+	}
+
+	if r.Method != "GET" {
+		s.writeError(w, errors.New("Unsupported method"))
+		return
+	}
+
+	data, err := json.Marshal(s.history.list())
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJson(w, data)
+}
+
+func (s *settingsManager) handleSettingsRevertReq(w http.ResponseWriter, r *http.Request) {
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.settings!write"}, r, w,
+		"SettingsManager::handleSettingsRevertReq") {
+		return
+	}
+
+	if r.Method != "POST" {
+		s.writeError(w, errors.New("Unsupported method"))
+		return
+	}
+
+	target := s.history.previous()
+	if target == nil {
+		s.writeError(w, errNoPreviousSettings)
+		return
+	}
+
+	_, rev, err := metakv.Get(common.IndexingSettingsMetaPath)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	logging.Infof("SettingsManager::handleSettingsRevertReq Reverting settings to version %v "+
+		"applied at %v", target.Version, target.Time)
+
+	s.history.reverting(target.Version)
+	if err = metakv.Set(common.IndexingSettingsMetaPath, target.settings, rev); err != nil {
+		s.history.reverting(0)
+		s.writeError(w, err)
+		return
+	}
+	s.writeOk(w)
+}
@@ -0,0 +1,99 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestSettingsHistory(t *testing.T) {
+	h := newSettingsHistory()
+	if h.previous() != nil {
+		t.Fatalf("expected no previous settings")
+	}
+
+	now := time.Now()
+	settings := func(n int) []byte { return []byte(fmt.Sprintf(`{"n":%v}`, n)) }
+
+	for n := 1; n <= 3; n++ {
+		h.record(settings(n), nil, nil, now)
+	}
+	if v := h.record(settings(3), nil, nil, now); v != nil {
+		t.Fatalf("expected unchanged settings not recorded, got version %v", v.Version)
+	}
+
+	// Successive reverts walk back the history
+	for _, expected := range []int64{2, 1} {
+		target := h.previous()
+		if target == nil || target.Version != expected {
+			t.Fatalf("expected to revert to version %v, got %+v", expected, target)
+		}
+		h.reverting(target.Version)
+		if v := h.record(target.settings, nil, nil, now); v.RevertedTo != expected {
+			t.Fatalf("expected a revert to version %v, got %+v", expected, v)
+		}
+	}
+	if target := h.previous(); target != nil {
+		t.Fatalf("expected no version before version 1, got %+v", target)
+	}
+
+	// A change after a revert reverts to the settings reverted to
+	h.record(settings(4), nil, nil, now)
+	if target := h.previous(); target == nil || string(target.settings) != string(settings(1)) {
+		t.Fatalf("expected to revert to the settings of version 1, got %+v", target)
+	}
+
+	// Settings other than those reverted to are not a revert
+	h.reverting(1)
+	if v := h.record(settings(5), nil, nil, now); v.RevertedTo != 0 {
+		t.Fatalf("expected no revert, got %+v", v)
+	}
+
+	for n := 6; n < 6+maxSettingsHistory; n++ {
+		h.record(settings(n), nil, nil, now)
+	}
+	if versions := h.list(); len(versions) != maxSettingsHistory ||
+		string(versions[0].settings) != string(settings(5+maxSettingsHistory)) {
+		t.Fatalf("expected the latest %v versions, got %v", maxSettingsHistory, len(versions))
+	}
+}
+
+func TestSettingsRevertOnlySetKeys(t *testing.T) {
+	s := &settingsManager{history: newSettingsHistory()}
+
+	apply := func(oldConfig common.Config, value string) common.Config {
+		newConfig := oldConfig.Clone()
+		if err := newConfig.Update([]byte(value)); err != nil {
+			t.Fatalf("failed to update config: %v", err)
+		}
+		s.recordSettings(oldConfig, newConfig, []byte(value))
+		return newConfig
+	}
+	config := apply(common.SystemConfig.Clone(), `{"indexer.settings.num_replica":1}`)
+	apply(config, `{"indexer.settings.num_replica":2}`)
+
+	// The revert writes the settings set, without the defaults of the
+	// other settings
+	target := s.history.previous()
+	if target == nil {
+		t.Fatalf("expected settings to revert to")
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(target.settings, &settings); err != nil {
+		t.Fatalf("failed to unmarshal settings: %v", err)
+	}
+	if len(settings) != 1 || settings["indexer.settings.num_replica"] != float64(1) {
+		t.Fatalf("expected a revert to only num_replica 1, got %v", settings)
+	}
+}
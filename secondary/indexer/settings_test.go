@@ -0,0 +1,46 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestSetCgroupLimits(t *testing.T) {
+	config := common.SystemConfig.Clone()
+	config.SetValue("indexer.settings.memory_quota", uint64(4<<30))
+
+	if !setCgroupLimits(config, 2<<30, 100) {
+		t.Fatalf("expected cgroup limits changed")
+	}
+	if setCgroupLimits(config, 2<<30, 100) {
+		t.Fatalf("expected cgroup limits unchanged")
+	}
+
+	if quota := config.GetIndexerMemoryQuota(); quota != 2<<30 {
+		t.Fatalf("expected memory quota limited by cgroup, got %v", quota)
+	}
+	if prc := config.GetIndexerNumCpuPrc(); prc != 100 {
+		t.Fatalf("expected cpu percent limited by cgroup, got %v", prc)
+	}
+
+	// Container scaled up beyond the memory settings, no cgroup CPU limit
+	if !setCgroupLimits(config, 8<<30, 0) {
+		t.Fatalf("expected cgroup limits changed")
+	}
+	if quota := config.GetIndexerMemoryQuota(); quota != 4<<30 {
+		t.Fatalf("expected memory quota from settings, got %v", quota)
+	}
+	if prc := config.GetIndexerNumCpuPrc(); prc != runtime.NumCPU()*100 {
+		t.Fatalf("expected cpu percent of the node, got %v", prc)
+	}
+}
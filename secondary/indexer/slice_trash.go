@@ -0,0 +1,636 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager"
+)
+
+// With settings.trash_retention set, the slices of an active index dropped by
+// a user are moved to the trash, TRASH_SUBDIR of the storage dir, instead of
+// being deleted. There is an entry dir per dropped instance, with the slice
+// dirs and trashEntryFile, which keeps what is needed to recreate the index.
+// The entries are purged once older than the retention.
+//
+// An entry is restored by /internal/trash/restore, which recreates the index
+// through the local index manager with the same definition and instance id,
+// as a deferred index. The slices are moved back when the indexer creates the
+// instance, and are opened as they are. The build of the restored index then
+// rolls the slices back to their latest snapshot and opens the build stream
+// from it, so that DCP only streams the mutations done since the drop. If the
+// partitions of the index are not at the same snapshot, or the index is built
+// with others, the slices are rolled back to 0 and the index is built as
+// usual. A restored entry is kept until the build, to survive a restart.
+
+const TRASH_SUBDIR = ".trash"
+
+const trashEntryFile = "trash.json"
+
+// Interval at which the entries past the retention are purged
+const trashPurgeInterval = time.Minute
+
+var errTrashEntryNotFound = errors.New("No dropped index instance in the trash")
+
+// droppedSlices is the trash of the indexer, for the slices to move their
+// files to it when destroyed.
+var droppedSlices *sliceTrash
+
+// trashedSlice is a slice dir in a trash entry.
+type trashedSlice struct {
+	PartnId common.PartitionId `json:"partnId"`
+	Path    string             `json:"path"` // path of the slice when dropped
+	Name    string             `json:"name"` // name in the entry dir
+}
+
+// trashEntry is a dropped index instance in the trash.
+type trashEntry struct {
+	InstId     common.IndexInstId   `json:"instId"`
+	Defn       common.IndexDefn     `json:"defn"`
+	ReplicaId  int                  `json:"replicaId"`
+	Version    int                  `json:"version"`
+	Partitions []common.PartitionId `json:"partitions"`
+	Versions   []int                `json:"versions"`
+	DropTime   time.Time            `json:"dropTime"`
+	Slices     []trashedSlice       `json:"slices"`
+	Restored   bool                 `json:"restored,omitempty"` // slices moved back, until the build
+	Dir        string               `json:"dir"`
+	restoring  bool                 // recreate in progress
+}
+
+type sliceTrash struct {
+	mu         sync.Mutex
+	dir        string
+	storageDir string
+	httpAddr   string // of the local index manager
+	entries    map[common.IndexInstId]*trashEntry
+}
+
+func newSliceTrash(storageDir string, httpAddr string) *sliceTrash {
+	t := &sliceTrash{
+		dir:        filepath.Join(storageDir, TRASH_SUBDIR),
+		storageDir: storageDir,
+		httpAddr:   httpAddr,
+		entries:    make(map[common.IndexInstId]*trashEntry),
+	}
+	t.load()
+	return t
+}
+
+// load reads the entries left in the trash by the previous run.
+func (t *sliceTrash) load() {
+	dirs, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Errorf("sliceTrash::load Unable to read %v: %v", t.dir, err)
+		}
+		return
+	}
+
+	for _, fi := range dirs {
+		dir := filepath.Join(t.dir, fi.Name())
+		bs, err := ioutil.ReadFile(filepath.Join(dir, trashEntryFile))
+		if err != nil {
+			logging.Errorf("sliceTrash::load Unable to read entry %v: %v. Removing it.", dir, err)
+			os.RemoveAll(dir)
+			continue
+		}
+
+		e := &trashEntry{}
+		if err := json.Unmarshal(bs, e); err != nil {
+			logging.Errorf("sliceTrash::load Invalid entry %v: %v. Removing it.", dir, err)
+			os.RemoveAll(dir)
+			continue
+		}
+		e.Dir = dir
+
+		if old, ok := t.entries[e.InstId]; ok && old.DropTime.After(e.DropTime) {
+			e = old
+		}
+		t.entries[e.InstId] = e
+	}
+	logging.Infof("sliceTrash::load Found %v dropped index instances in %v", len(t.entries), t.dir)
+}
+
+// mark makes the slices of inst be moved to the trash when destroyed, instead
+// of deleted.
+func (t *sliceTrash) mark(inst *common.IndexInst, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := &trashEntry{
+		InstId:    inst.InstId,
+		Defn:      inst.Defn,
+		ReplicaId: inst.ReplicaId,
+		Version:   inst.Version,
+		DropTime:  now,
+		Dir:       filepath.Join(t.dir, fmt.Sprintf("%v_%v", inst.InstId, now.UnixNano())),
+	}
+	if inst.Pc != nil {
+		e.Partitions, e.Versions = inst.Pc.GetAllPartitionIds()
+	}
+
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return err
+	}
+	if err := e.save(); err != nil {
+		os.RemoveAll(e.Dir)
+		return err
+	}
+
+	// an instance dropped again after a restore
+	if old, ok := t.entries[inst.InstId]; ok {
+		t.removeLOCKED(old)
+	}
+	t.entries[inst.InstId] = e
+	return nil
+}
+
+// trashSlice moves the slice of instId and partnId at path to the trash, if
+// the instance is marked. It returns false if the slice is to be deleted.
+func (t *sliceTrash) trashSlice(instId common.IndexInstId, partnId common.PartitionId,
+	path string) bool {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[instId]
+	if !ok || e.Restored {
+		return false
+	}
+
+	name := filepath.Base(path)
+	mode := common.IndexTypeToStorageMode(e.Defn.Using)
+	if err := moveTrashedSlice(mode, t.storageDir, path, filepath.Join(e.Dir, name)); err != nil {
+		logging.Errorf("sliceTrash::trashSlice IndexInst %v Partition %v Unable to move %v "+
+			"to the trash: %v. Deleting it.", instId, partnId, path, err)
+		return false
+	}
+
+	e.Slices = append(e.Slices, trashedSlice{PartnId: partnId, Path: path, Name: name})
+	if err := e.save(); err != nil {
+		logging.Errorf("sliceTrash::trashSlice IndexInst %v Unable to save entry %v: %v",
+			instId, e.Dir, err)
+	}
+
+	logging.Infof("sliceTrash::trashSlice IndexInst %v Partition %v moved %v to %v",
+		instId, partnId, path, e.Dir)
+	return true
+}
+
+// purge removes the entries dropped before the retention, or all of them if
+// there is no retention. Restored entries are kept until the build. It
+// returns the number of entries removed.
+func (t *sliceTrash) purge(now time.Time, retention time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int
+	for _, e := range t.entries {
+		if e.Restored || e.restoring || (retention > 0 && now.Sub(e.DropTime) < retention) {
+			continue
+		}
+		logging.Infof("sliceTrash::purge Purging IndexInst %v %v dropped at %v",
+			e.InstId, e.Defn.Name, e.DropTime)
+		t.removeLOCKED(e)
+		n++
+	}
+	return n
+}
+
+// remove deletes the entry of instId, if any.
+func (t *sliceTrash) remove(instId common.IndexInstId) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[instId]; ok {
+		t.removeLOCKED(e)
+	}
+}
+
+func (t *sliceTrash) removeLOCKED(e *trashEntry) {
+	for _, s := range e.Slices {
+		path := filepath.Join(e.Dir, s.Name)
+		coldDir := coldSliceDir(path)
+		if err := os.RemoveAll(path); err != nil {
+			logging.Errorf("sliceTrash::remove Unable to remove %v: %v", path, err)
+		}
+		if coldDir != "" {
+			os.RemoveAll(coldDir)
+		}
+	}
+	if err := os.RemoveAll(e.Dir); err != nil {
+		logging.Errorf("sliceTrash::remove Unable to remove %v: %v", e.Dir, err)
+	}
+	if t.entries[e.InstId] == e {
+		delete(t.entries, e.InstId)
+	}
+}
+
+// list returns the entries of the trash, latest dropped first.
+func (t *sliceTrash) list() []trashEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]trashEntry, 0, len(t.entries))
+	for _, e := range t.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DropTime.After(entries[j].DropTime)
+	})
+	return entries
+}
+
+// startRestore returns the entry of instId to recreate the index of, and
+// marks it so that its slices are moved back when the instance is created.
+func (t *sliceTrash) startRestore(instId common.IndexInstId) (trashEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[instId]
+	if !ok {
+		return trashEntry{}, errTrashEntryNotFound
+	}
+	if e.Restored || e.restoring {
+		return trashEntry{}, fmt.Errorf("Index instance %v is already restored", instId)
+	}
+	e.restoring = true
+	return *e, nil
+}
+
+func (t *sliceTrash) abortRestore(instId common.IndexInstId) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[instId]; ok {
+		e.restoring = false
+	}
+}
+
+// restoreSlices moves the slices of inst back from the trash, if it is
+// recreated from an entry. It returns whether the slices were restored.
+func (t *sliceTrash) restoreSlices(inst *common.IndexInst) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[inst.InstId]
+	if !ok || !e.restoring {
+		return false
+	}
+	e.restoring = false
+
+	// the data of a dropped and recreated bucket or collection is stale
+	if inst.Defn.BucketUUID != e.Defn.BucketUUID || inst.Defn.CollectionId != e.Defn.CollectionId {
+		logging.Errorf("sliceTrash::restoreSlices IndexInst %v Bucket UUID %v Collection %v, "+
+			"dropped on Bucket UUID %v Collection %v. Not restoring the slices.", inst.InstId,
+			inst.Defn.BucketUUID, inst.Defn.CollectionId, e.Defn.BucketUUID, e.Defn.CollectionId)
+		t.removeLOCKED(e)
+		return false
+	}
+
+	mode := common.IndexTypeToStorageMode(e.Defn.Using)
+	for i, s := range e.Slices {
+		if err := moveTrashedSlice(mode, t.storageDir, filepath.Join(e.Dir, s.Name), s.Path); err != nil {
+			logging.Errorf("sliceTrash::restoreSlices IndexInst %v Partition %v Unable to move "+
+				"%v back: %v. Not restoring the slices.", inst.InstId, s.PartnId, s.Name, err)
+			for _, s := range e.Slices[:i] {
+				os.RemoveAll(s.Path)
+			}
+			t.removeLOCKED(e)
+			return false
+		}
+	}
+
+	e.Restored = true
+	e.Slices = nil
+	if err := e.save(); err != nil {
+		logging.Errorf("sliceTrash::restoreSlices IndexInst %v Unable to save entry %v: %v",
+			inst.InstId, e.Dir, err)
+	}
+
+	logging.Infof("sliceTrash::restoreSlices IndexInst %v restored from %v", inst.InstId, e.Dir)
+	return true
+}
+
+// isRestored returns whether the slices of instId were restored from the
+// trash and the instance is not built yet.
+func (t *sliceTrash) isRestored(instId common.IndexInstId) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[instId]
+	return ok && e.Restored
+}
+
+func (t *sliceTrash) isRestoring(instId common.IndexInstId) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[instId]
+	return ok && e.restoring
+}
+
+func (e *trashEntry) save() error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(e.Dir, trashEntryFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// moveTrashedSlice moves the slice at src, which is not open, to dest.
+func moveTrashedSlice(mode common.StorageMode, storageDir string, src string, dest string) error {
+	if mode != common.PLASMA {
+		return os.Rename(src, dest)
+	}
+
+	// plasma moves the files of the instance it keeps elsewhere too
+	rename := func(path string) (string, error) {
+		if !strings.HasPrefix(path, src) {
+			return "", fmt.Errorf("path (%v) is not in the slice (%v)", path, src)
+		}
+		return dest + path[len(src):], nil
+	}
+	return BackupCorruptedPlasmaSlice(storageDir, src, rename, func(string) {})
+}
+
+// trashDroppedSlice moves the slice of a dropped instance at path to the
+// trash, if enabled. It returns false if the slice is to be deleted.
+func trashDroppedSlice(instId common.IndexInstId, partnId common.PartitionId, path string) bool {
+	if droppedSlices == nil {
+		return false
+	}
+	return droppedSlices.trashSlice(instId, partnId, path)
+}
+
+// trashOnDrop moves the slices of indexInst, dropped by a user, to the
+// trash. Only active instances are, as others are rebuilt anyway.
+func (idx *indexer) trashOnDrop(indexInst *common.IndexInst, reqCtx *common.MetadataRequestContext) {
+
+	if idx.config["settings.trash_retention"].Int() <= 0 ||
+		reqCtx == nil || reqCtx.ReqSource != common.DDLRequestSourceUser ||
+		indexInst.State != common.INDEX_STATE_ACTIVE ||
+		indexInst.RState != common.REBAL_ACTIVE || indexInst.IsProxy() {
+		return
+	}
+
+	if err := idx.trash.mark(indexInst, time.Now()); err != nil {
+		logging.Errorf("Indexer::trashOnDrop IndexInst %v Unable to create trash entry: %v. "+
+			"Deleting the index data.", indexInst.InstId, err)
+		return
+	}
+	logging.Infof("Indexer::trashOnDrop IndexInst %v %v moving the index data to the trash",
+		indexInst.InstId, indexInst.Defn.Name)
+}
+
+// purgeTrash periodically purges the entries of the trash past the retention.
+func (idx *indexer) purgeTrash() {
+	for {
+		retention := time.Duration(idx.config["settings.trash_retention"].Int()) * time.Second
+		idx.trash.purge(time.Now(), retention)
+		time.Sleep(trashPurgeInterval)
+	}
+}
+
+// restoredBuildTs returns the timestamp to open the build stream of
+// instIdList from, if it is an index restored from the trash. The slices of
+// the index are rolled back to their latest snapshot. The slices of restored
+// indexes that are not built from their snapshot are rolled back to 0.
+func (idx *indexer) restoredBuildTs(instIdList []common.IndexInstId) *common.TsVbuuid {
+
+	var restored []common.IndexInstId
+	for _, instId := range instIdList {
+		if idx.trash.isRestored(instId) {
+			restored = append(restored, instId)
+		}
+	}
+	if len(restored) == 0 {
+		return nil
+	}
+
+	var restartTs *common.TsVbuuid
+	if len(instIdList) == 1 {
+		var err error
+		if restartTs, err = idx.rollbackRestoredSlices(instIdList[0]); err != nil {
+			logging.Errorf("Indexer::restoredBuildTs IndexInst %v Unable to build from the restored "+
+				"snapshot: %v. Building from 0.", instIdList[0], err)
+			restartTs = nil
+		}
+	} else {
+		logging.Infof("Indexer::restoredBuildTs Restored IndexInsts %v built with %v. Building from 0.",
+			restored, instIdList)
+	}
+
+	for _, instId := range restored {
+		if restartTs == nil {
+			for _, partnInst := range idx.indexPartnMap[instId] {
+				for _, slice := range partnInst.Sc.GetAllSlices() {
+					if err := slice.RollbackToZero(); err != nil {
+						common.CrashOnError(err)
+					}
+				}
+			}
+		}
+		idx.trash.remove(instId)
+	}
+	return restartTs
+}
+
+// rollbackRestoredSlices rolls the slices of instId back to their latest
+// snapshot, which must be the same for all of them, and returns it.
+func (idx *indexer) rollbackRestoredSlices(instId common.IndexInstId) (*common.TsVbuuid, error) {
+
+	var ts *common.TsVbuuid
+	var latest []SnapshotInfo
+	var slices []Slice
+	for partnId, partnInst := range idx.indexPartnMap[instId] {
+		for _, slice := range partnInst.Sc.GetAllSlices() {
+			infos, err := slice.GetSnapshots()
+			if err != nil {
+				return nil, err
+			}
+			info := NewSnapshotInfoContainer(infos).GetLatest()
+			if info == nil || info.Timestamp() == nil {
+				return nil, fmt.Errorf("no snapshot of partition %v", partnId)
+			}
+			if ts != nil && !ts.Equal(info.Timestamp()) {
+				return nil, fmt.Errorf("partition %v at a different snapshot", partnId)
+			}
+			ts = info.Timestamp()
+			latest = append(latest, info)
+			slices = append(slices, slice)
+		}
+	}
+	if ts == nil {
+		return nil, errors.New("no slices")
+	}
+
+	for i, slice := range slices {
+		if err := slice.Rollback(latest[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ts.Copy(), nil
+}
+
+// recreateFromTrash recreates the index of the entry of instId through the
+// local index manager, with the same definition and instance id.
+func (idx *indexer) recreateFromTrash(instId common.IndexInstId) error {
+
+	e, err := idx.trash.startRestore(instId)
+	if err != nil {
+		return err
+	}
+
+	defn := e.Defn
+	defn.SetCollectionDefaults()
+	defn.Nodes = nil
+	defn.Deferred = true
+	defn.InstId = e.InstId
+	defn.RealInstId = 0
+	defn.ReplicaId = e.ReplicaId
+	defn.InstVersion = e.Version
+	defn.Partitions = e.Partitions
+	defn.Versions = e.Versions
+
+	body, err := json.Marshal(&manager.IndexRequest{Index: defn})
+	if err != nil {
+		idx.trash.abortRestore(instId)
+		return err
+	}
+
+	resp, err := postWithAuth(idx.trash.httpAddr+"/createIndexRebalance", "application/json",
+		bytes.NewBuffer(body))
+	if err != nil {
+		idx.trash.abortRestore(instId)
+		return err
+	}
+	defer resp.Body.Close()
+
+	var ir manager.IndexResponse
+	bs, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(bs, &ir); err != nil {
+		idx.trash.abortRestore(instId)
+		return fmt.Errorf("invalid response %v: %v", resp.Status, strings.TrimSpace(string(bs)))
+	}
+	if ir.Code == manager.RESP_ERROR {
+		idx.trash.abortRestore(instId)
+		return errors.New(ir.Error)
+	}
+
+	// the instance was created without the slices, e.g. with another bucket
+	if idx.trash.isRestoring(instId) {
+		idx.trash.abortRestore(instId)
+		return fmt.Errorf("Index %v recreated without its data", defn.Name)
+	}
+	return nil
+}
+
+func (idx *indexer) handleTrashReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.admin.internal.index!read"}, r, w,
+		"Indexer::handleTrashReq") {
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	data, err := json.Marshal(idx.trash.list())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (idx *indexer) handleTrashRestoreReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.admin.internal.index!write"}, r, w,
+		"Indexer::handleTrashRestoreReq") {
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	v := r.URL.Query().Get("instId")
+	instId, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid instId " + v + "\n"))
+		return
+	}
+
+	if err := idx.recreateFromTrash(common.IndexInstId(instId)); err != nil {
+		logging.Errorf("Indexer::handleTrashRestoreReq IndexInst %v Unable to restore: %v", instId, err)
+		if err == errTrashEntryNotFound {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	logging.Infof("Indexer::handleTrashRestoreReq IndexInst %v recreated from the trash", instId)
+	w.WriteHeader(http.StatusOK)
+}
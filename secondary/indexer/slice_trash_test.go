@@ -0,0 +1,146 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTrashTestSlice(t *testing.T, storageDir string, inst *common.IndexInst,
+	partnId common.PartitionId) string {
+
+	path := filepath.Join(storageDir, IndexPath(inst, partnId, 0))
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "data"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSliceTrashRestore(t *testing.T) {
+	storageDir, err := ioutil.TempDir("", "slice_trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	inst := &common.IndexInst{
+		InstId: 10,
+		Defn: common.IndexDefn{DefnId: 1, Bucket: "b", Name: "idx", Using: common.MemoryOptimized,
+			BucketUUID: "uuid", CollectionId: "0"},
+		State: common.INDEX_STATE_ACTIVE,
+	}
+	path := newTrashTestSlice(t, storageDir, inst, 0)
+
+	trash := newSliceTrash(storageDir, "")
+	if trash.trashSlice(inst.InstId, 0, path) {
+		t.Fatalf("expected the slice of an unmarked instance not to be trashed")
+	}
+
+	now := time.Now()
+	if err := trash.mark(inst, now); err != nil {
+		t.Fatal(err)
+	}
+	if !trash.trashSlice(inst.InstId, 0, path) {
+		t.Fatalf("expected the slice to be trashed")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the slice moved out of the storage dir, got %v", err)
+	}
+
+	// the entries survive a restart
+	trash = newSliceTrash(storageDir, "")
+	entries := trash.list()
+	if len(entries) != 1 || entries[0].InstId != inst.InstId || len(entries[0].Slices) != 1 {
+		t.Fatalf("expected the entry of the dropped instance, got %+v", entries)
+	}
+
+	if trash.restoreSlices(inst) {
+		t.Fatalf("expected no restore without a recreate")
+	}
+	if _, err := trash.startRestore(inst.InstId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trash.startRestore(inst.InstId); err == nil {
+		t.Fatalf("expected a single restore of an entry")
+	}
+	if !trash.restoreSlices(inst) || !trash.isRestored(inst.InstId) {
+		t.Fatalf("expected the slices restored")
+	}
+	if bs, err := ioutil.ReadFile(filepath.Join(path, "data")); err != nil || string(bs) != "data" {
+		t.Fatalf("expected the data of the slice back, got %v %v", string(bs), err)
+	}
+
+	// restored entries are kept until the build
+	if n := trash.purge(now.Add(time.Hour), time.Second); n != 0 {
+		t.Fatalf("expected the restored entry not purged, got %v purged", n)
+	}
+	trash.remove(inst.InstId)
+	if len(trash.list()) != 0 {
+		t.Fatalf("expected the entry removed")
+	}
+}
+
+func TestSliceTrashPurge(t *testing.T) {
+	storageDir, err := ioutil.TempDir("", "slice_trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	trash := newSliceTrash(storageDir, "")
+	now := time.Now()
+	for i, instId := range []common.IndexInstId{10, 20} {
+		inst := &common.IndexInst{
+			InstId: instId,
+			Defn:   common.IndexDefn{Bucket: "b", Name: "idx", Using: common.MemoryOptimized},
+		}
+		path := newTrashTestSlice(t, storageDir, inst, 0)
+		if err := trash.mark(inst, now.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if !trash.trashSlice(instId, 0, path) {
+			t.Fatalf("expected the slice of %v to be trashed", instId)
+		}
+	}
+
+	if n := trash.purge(now.Add(90*time.Minute), time.Hour); n != 1 {
+		t.Fatalf("expected the entry past the retention purged, got %v", n)
+	}
+	if entries := trash.list(); len(entries) != 1 || entries[0].InstId != 20 {
+		t.Fatalf("expected the latest entry kept, got %+v", entries)
+	}
+
+	// a bucket recreated since the drop gets no stale data
+	inst := &common.IndexInst{
+		InstId: 20,
+		Defn:   common.IndexDefn{Bucket: "b", Name: "idx", Using: common.MemoryOptimized, BucketUUID: "new"},
+	}
+	if _, err := trash.startRestore(20); err != nil {
+		t.Fatal(err)
+	}
+	if trash.restoreSlices(inst) || len(trash.list()) != 0 {
+		t.Fatalf("expected the entry of another bucket dropped")
+	}
+
+	if n := trash.purge(now, 0); n != 0 {
+		t.Fatalf("expected an empty trash, got %v purged", n)
+	}
+	if dirs, _ := ioutil.ReadDir(filepath.Join(storageDir, TRASH_SUBDIR)); len(dirs) != 0 {
+		t.Fatalf("expected no entry dirs left, got %v", len(dirs))
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/logging/systemevent"
+)
+
+// With settings.verify_slice_on_startup, the files of a slice are checked on
+// bootstrap before the slice is opened:
+//
+//   - memory optimized: the manifest of each disk snapshot must decode to a
+//     timestamp, for the instance and partition of the slice. The snapshots
+//     failing the check are moved to the corrupt data directory, so that the
+//     slice recovers from an older snapshot.
+//   - plasma: the main store, and the back store of secondary indexes, must
+//     be present.
+//   - forestdb: the latest data file must be a whole number of blocks.
+//
+// A slice failing the check is reported as errStorageCorrupted, for the
+// indexer to back it up to the corrupt data directory and clean it up as it
+// does with slices that fail to open. The storage manager then also rolls
+// back an index to 0 instead of crashing when a snapshot of the index cannot
+// be opened. Both emit a slice corrupted system event.
+
+var errSnapshotUnusable = errors.New("No usable snapshot")
+
+const fdbBlockSize = 4096
+
+// verifySlice checks the files of the slice of indInst and partnId at path,
+// before it is opened on bootstrap.
+func verifySlice(indInst *common.IndexInst, partnId common.PartitionId, path string,
+	conf common.Config) error {
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	var err error
+	switch indInst.Defn.Using {
+	case common.MemDB, common.MemoryOptimized:
+		corrupt := verifyMemDBSnapshots(path, GetRealIndexInstId(indInst), partnId)
+		quarantineDir := filepath.Join(conf["storage_dir"].String(), CORRUPT_DATA_SUBDIR)
+		for snapDir, cause := range corrupt {
+			quarantinePath, qerr := quarantineSnapshot(snapDir, quarantineDir)
+			if qerr != nil {
+				// a snapshot left in place fails to open, so the slice
+				// cannot be trusted
+				logging.Errorf("verifySlice: unable to quarantine snapshot %v: %v", snapDir, qerr)
+				err = cause
+				continue
+			}
+			logging.Errorf("verifySlice: IndexInst %v Partition %v snapshot %v is corrupted: %v. "+
+				"Moved to %v", indInst.InstId, partnId, snapDir, cause, quarantinePath)
+			reportSliceCorrupted(indInst, partnId, snapDir, quarantinePath, cause)
+		}
+	case common.ForestDB:
+		err = verifyForestDBSlice(path)
+	case common.PlasmaDB:
+		err = verifyPlasmaSlice(path, indInst.Defn.IsPrimary)
+	}
+
+	if err != nil {
+		logging.Errorf("verifySlice: IndexInst %v Partition %v slice %v is corrupted: %v",
+			indInst.InstId, partnId, path, err)
+		reportSliceCorrupted(indInst, partnId, path, "", err)
+		return errStorageCorrupted
+	}
+	return nil
+}
+
+// verifyMemDBSnapshots returns the disk snapshots of the memory optimized
+// slice at path whose manifests are invalid, with the reason.
+func verifyMemDBSnapshots(path string, instId common.IndexInstId,
+	partnId common.PartitionId) map[string]error {
+
+	corrupt := make(map[string]error)
+	for _, manifest := range getMemdbSnapshotManifests(path) {
+		snapDir := filepath.Dir(manifest)
+
+		bs, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			corrupt[snapDir] = err
+			continue
+		}
+
+		var info memdbSnapshotInfo
+		if err := json.Unmarshal(bs, &info); err != nil {
+			corrupt[snapDir] = fmt.Errorf("invalid manifest: %v", err)
+			continue
+		}
+		if len(info.CompactTs) != 0 {
+			if info.Ts, err = common.DecodeTsVbuuid(info.CompactTs); err != nil {
+				corrupt[snapDir] = fmt.Errorf("invalid timestamp: %v", err)
+				continue
+			}
+		}
+		if info.Ts == nil {
+			corrupt[snapDir] = errors.New("no timestamp in manifest")
+			continue
+		}
+		if info.Version >= SNAPSHOT_META_VERSION_MOI_1 &&
+			(info.InstId != instId || info.PartnId != partnId) {
+			corrupt[snapDir] = fmt.Errorf("manifest of index %v partition %v", info.InstId, info.PartnId)
+			continue
+		}
+
+		files, err := ioutil.ReadDir(snapDir)
+		if err != nil {
+			corrupt[snapDir] = err
+		} else if len(files) < 2 {
+			corrupt[snapDir] = errors.New("no data files")
+		}
+	}
+	return corrupt
+}
+
+// quarantineSnapshot moves the snapshot directory snapDir of a slice to
+// quarantineDir, and returns its new path.
+func quarantineSnapshot(snapDir string, quarantineDir string) (string, error) {
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", err
+	}
+
+	t := time.Now()
+	strTime := fmt.Sprintf("%d-%02d-%02dT%02d-%02d-%02d-%03d", t.Year(), t.Month(),
+		t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000/1000)
+	slice := filepath.Base(filepath.Dir(snapDir))
+	dest := filepath.Join(quarantineDir, strTime+"_"+slice+"_"+filepath.Base(snapDir))
+
+	if err := os.Rename(snapDir, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// verifyPlasmaSlice checks that the stores of the plasma slice at path are
+// present.
+func verifyPlasmaSlice(path string, isPrimary bool) error {
+	stores := []string{"mainIndex"}
+	if !isPrimary {
+		stores = append(stores, "docIndex")
+	}
+
+	for _, store := range stores {
+		fi, err := os.Stat(filepath.Join(path, store))
+		if err != nil {
+			return fmt.Errorf("missing %v: %v", store, err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%v is not a directory", store)
+		}
+	}
+	return nil
+}
+
+// verifyForestDBSlice checks that the latest data file of the forestdb slice
+// at path is not truncated.
+func verifyForestDBSlice(path string) error {
+	files, err := filepath.Glob(filepath.Join(path, "data.fdb.*"))
+	if err != nil {
+		return err
+	}
+
+	var latest string
+	var latestVersion int = -1
+	for _, f := range files {
+		var version int
+		if _, err := fmt.Sscanf(filepath.Base(f), "data.fdb.%d", &version); err == nil &&
+			version > latestVersion {
+			latest, latestVersion = f, version
+		}
+	}
+	if latest == "" {
+		return nil
+	}
+
+	fi, err := os.Stat(latest)
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 || fi.Size()%fdbBlockSize != 0 {
+		return fmt.Errorf("%v is truncated to %v bytes", filepath.Base(latest), fi.Size())
+	}
+	return nil
+}
+
+func reportSliceCorrupted(indInst *common.IndexInst, partnId common.PartitionId,
+	path string, quarantinePath string, cause error) {
+
+	systemevent.ErrorEvent("Indexer", systemevent.EVENTID_INDEX_SLICE_CORRUPTED,
+		systemevent.NewSliceCorruptedEvent("Indexer", indInst.Defn.DefnId, indInst.InstId,
+			uint64(indInst.ReplicaId), uint64(partnId), path, quarantinePath, cause.Error()))
+}
+
+// reportUnusableSnapshot reports that no snapshot of the slice of idxInstId
+// and partnId can be opened, and the index is rolled back to 0.
+func (s *storageMgr) reportUnusableSnapshot(idxInstId common.IndexInstId,
+	partnId common.PartitionId, slice Slice, cause error) {
+
+	storageMgrLog.Errorf("StorageMgr::openSnapshot IndexInst:%v Partition:%v Unable to open "+
+		"snapshot of slice %v: %v. Rolling back the index to 0.", idxInstId, partnId, slice.Path(), cause)
+
+	inst, ok := s.indexInstMap.Get()[idxInstId]
+	if !ok {
+		inst = common.IndexInst{InstId: idxInstId}
+	}
+	reportSliceCorrupted(&inst, partnId, slice.Path(), "", cause)
+}
@@ -0,0 +1,117 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestVerifyMemDBSnapshots(t *testing.T) {
+	storageDir, err := ioutil.TempDir("", "slice_verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+	path := filepath.Join(storageDir, "default_idx_7_1.index")
+
+	writeSnap := func(dir string, manifest []byte, withData bool) {
+		snapDir := filepath.Join(path, dir)
+		if err := os.MkdirAll(snapDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(snapDir, "manifest.json"), manifest, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if withData {
+			if err := ioutil.WriteFile(filepath.Join(snapDir, "data"), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	manifest := func(instId common.IndexInstId, withTs bool) []byte {
+		info := &memdbSnapshotInfo{Version: SNAPSHOT_META_VERSION_MOI_1, InstId: instId, PartnId: 1}
+		if withTs {
+			info.Ts = common.NewTsVbuuid("default", 4)
+		}
+		bs, err := json.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bs
+	}
+
+	writeSnap("snapshot.1", manifest(7, true), true)
+	writeSnap("snapshot.2", []byte("{truncated"), true)
+	writeSnap("snapshot.3", manifest(7, false), true)
+	writeSnap("snapshot.4", manifest(8, true), true)
+	writeSnap("snapshot.5", manifest(7, true), false)
+
+	corrupt := verifyMemDBSnapshots(path, 7, 1)
+	if len(corrupt) != 4 {
+		t.Fatalf("expected 4 corrupt snapshots, got %v", corrupt)
+	}
+	if _, ok := corrupt[filepath.Join(path, "snapshot.1")]; ok {
+		t.Fatalf("expected the valid snapshot to pass verification")
+	}
+
+	quarantineDir := filepath.Join(storageDir, CORRUPT_DATA_SUBDIR)
+	dest, err := quarantineSnapshot(filepath.Join(path, "snapshot.2"), quarantineDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dest) != quarantineDir {
+		t.Fatalf("expected the snapshot quarantined in %v, got %v", quarantineDir, dest)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "manifest.json")); err != nil {
+		t.Fatalf("expected the snapshot moved, got %v", err)
+	}
+	if len(verifyMemDBSnapshots(path, 7, 1)) != 3 {
+		t.Fatalf("expected the quarantined snapshot no longer verified")
+	}
+}
+
+func TestVerifyPlasmaAndForestDBSlices(t *testing.T) {
+	path, err := ioutil.TempDir("", "slice_verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	if err := os.Mkdir(filepath.Join(path, "mainIndex"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPlasmaSlice(path, true); err != nil {
+		t.Fatalf("expected a primary index slice verified, got %v", err)
+	}
+	if err := verifyPlasmaSlice(path, false); err == nil {
+		t.Fatalf("expected a secondary index slice without its back store to fail verification")
+	}
+
+	if err := verifyForestDBSlice(path); err != nil {
+		t.Fatalf("expected a slice without data files verified, got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "data.fdb.1"), make([]byte, 3*fdbBlockSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyForestDBSlice(path); err != nil {
+		t.Fatalf("expected a whole data file verified, got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "data.fdb.2"), make([]byte, fdbBlockSize+10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyForestDBSlice(path); err == nil {
+		t.Fatalf("expected a truncated latest data file to fail verification")
+	}
+}
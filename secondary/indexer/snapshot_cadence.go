@@ -0,0 +1,120 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"math"
+	"time"
+)
+
+// With settings.inmemory_snapshot.adaptive.enable set, the timer generating
+// the stability timestamps of a stream/keyspace, and so its in-memory
+// snapshots, ticks at an interval following the mutation rate of the
+// keyspace instead of the fixed settings.inmemory_snapshot.*.interval:
+//
+//   - during a burst, i.e. the mutation rate is over burst_factor times its
+//     moving average, snapshots are taken every min_interval, so that session
+//     consistent scans do not wait on a backlog of mutations.
+//   - during a quiet period, i.e. both the mutation rate and its moving
+//     average are under quiet_rate, the interval doubles on each tick up to
+//     max_interval, so that trickles of mutations do not each cost a snapshot.
+//   - otherwise the interval steps back to the configured one.
+//
+// The moving average spans snapCadenceWindow, so a sustained rise of the
+// mutation rate becomes the new normal rather than a burst.
+
+const snapCadenceWindow = 30 * time.Second
+
+// snapCadence computes the in-memory snapshot interval of a stream/keyspace.
+// It is not safe for concurrent use, each timer goroutine owns its own.
+type snapCadence struct {
+	base        time.Duration // configured interval
+	min         time.Duration // interval during bursts
+	max         time.Duration // interval during quiet periods
+	burstFactor float64
+	quietRate   float64 // mutations per second
+
+	interval  time.Duration
+	avgRate   float64 // moving average of the mutation rate, per second
+	sampled   bool    // whether avgRate has been seeded
+	lastCount int64
+	lastTime  time.Time
+}
+
+// newSnapCadence returns a snapCadence starting at the base interval. The
+// min and max intervals are bounded by base.
+func newSnapCadence(base, min, max time.Duration, burstFactor, quietRate float64,
+	count int64, now time.Time) *snapCadence {
+
+	if min > base {
+		min = base
+	}
+	if max < base {
+		max = base
+	}
+	return &snapCadence{
+		base:        base,
+		min:         min,
+		max:         max,
+		burstFactor: burstFactor,
+		quietRate:   quietRate,
+		interval:    base,
+		lastCount:   count,
+		lastTime:    now,
+	}
+}
+
+// next returns the interval until the next snapshot, given the count of
+// mutations received by the keyspace so far.
+func (c *snapCadence) next(count int64, now time.Time) time.Duration {
+
+	elapsed := now.Sub(c.lastTime)
+	delta := count - c.lastCount
+	c.lastCount, c.lastTime = count, now
+
+	// the count is reset when the stream restarts
+	if elapsed <= 0 || delta < 0 {
+		return c.interval
+	}
+
+	rate := float64(delta) / elapsed.Seconds()
+	if !c.sampled {
+		c.avgRate, c.sampled = rate, true
+	}
+
+	switch {
+	case rate > c.quietRate && rate > c.avgRate*c.burstFactor:
+		c.interval = c.min
+
+	case rate < c.quietRate && c.avgRate < c.quietRate:
+		c.interval *= 2
+		if c.interval > c.max {
+			c.interval = c.max
+		}
+
+	case c.interval < c.base:
+		c.interval *= 2
+		if c.interval > c.base {
+			c.interval = c.base
+		}
+
+	case c.interval > c.base:
+		c.interval /= 2
+		if c.interval < c.base {
+			c.interval = c.base
+		}
+	}
+
+	// the average is updated after the burst check, so that a burst
+	// is measured against the rate before it
+	alpha := 1 - math.Exp(-elapsed.Seconds()/snapCadenceWindow.Seconds())
+	c.avgRate += alpha * (rate - c.avgRate)
+
+	return c.interval
+}
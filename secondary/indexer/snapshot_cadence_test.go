@@ -0,0 +1,77 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapCadence(t *testing.T) {
+	base := 10 * time.Millisecond
+	now := time.Now()
+	c := newSnapCadence(base, 2*time.Millisecond, 100*time.Millisecond, 4, 100, 0, now)
+
+	var count int64
+	tick := func(mutations int64) time.Duration {
+		interval := c.interval
+		count += mutations
+		now = now.Add(interval)
+		return c.next(count, now)
+	}
+
+	// steady traffic of 10000 mutations per second keeps the base interval
+	for i := 0; i < 10000; i++ {
+		if interval := tick(int64(c.interval / (100 * time.Microsecond))); i > 3000 && interval != base {
+			t.Fatalf("expected base interval under steady traffic, got %v", interval)
+		}
+	}
+
+	// a burst of 10x the traffic shortens the interval
+	if interval := tick(1000); interval != 2*time.Millisecond {
+		t.Fatalf("expected min interval during a burst, got %v", interval)
+	}
+	if interval := tick(200); interval != 2*time.Millisecond {
+		t.Fatalf("expected min interval while the burst lasts, got %v", interval)
+	}
+
+	// the interval steps back to base after the burst
+	for i := 0; i < 3; i++ {
+		tick(int64(c.interval / (100 * time.Microsecond)))
+	}
+	if c.interval != base {
+		t.Fatalf("expected base interval after the burst, got %v", c.interval)
+	}
+
+	// with no traffic, the interval relaxes up to max once the average falls
+	for quiet := now.Add(5 * time.Minute); now.Before(quiet); {
+		tick(0)
+	}
+	if c.interval != 100*time.Millisecond {
+		t.Fatalf("expected max interval when quiet, got %v", c.interval)
+	}
+
+	// a reset count leaves the interval unchanged
+	count = 0
+	if interval := c.next(0, now.Add(time.Millisecond)); interval != 100*time.Millisecond {
+		t.Fatalf("expected interval unchanged on reset count, got %v", interval)
+	}
+}
+
+func TestSnapCadenceBounds(t *testing.T) {
+	c := newSnapCadence(200*time.Millisecond, 5*time.Millisecond, 100*time.Millisecond, 4, 100, 0, time.Now())
+	if c.min != 5*time.Millisecond || c.max != 200*time.Millisecond {
+		t.Fatalf("expected max bounded by base, got %v %v", c.min, c.max)
+	}
+
+	c = newSnapCadence(time.Millisecond, 5*time.Millisecond, 100*time.Millisecond, 4, 100, 0, time.Now())
+	if c.min != time.Millisecond {
+		t.Fatalf("expected min bounded by base, got %v", c.min)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// The snapshots of the slices are listed with their timestamps, snapshot
+// types, OSO flags and sizes, to help decide whether an index can roll back
+// to a point or has to be rebuilt:
+//
+//   - /internal/snapshotInfos lists the snapshots of the slices of a running
+//     indexer, for all storage modes.
+//   - InspectSliceSnapshots lists the disk snapshots of a slice directory
+//     without starting the indexer, for cbindexsnap. Only memory optimized
+//     slices can be inspected offline, as plasma keeps its recovery points
+//     in its log.
+
+var ErrSnapshotInspectUnsupported = errors.New("Offline snapshot inspection is not supported for plasma slices, " +
+	"use /internal/snapshotInfos of the indexer")
+
+// SnapshotMeta describes a snapshot of a slice.
+type SnapshotMeta struct {
+	InstId    common.IndexInstId     `json:"instId"`
+	PartnId   common.PartitionId     `json:"partnId"`
+	Index     string                 `json:"index,omitempty"`
+	Path      string                 `json:"path,omitempty"` // disk snapshot directory
+	Committed bool                   `json:"committed"`
+	SnapType  string                 `json:"snapType"`
+	OSO       bool                   `json:"oso"`
+	Bucket    string                 `json:"bucket"`
+	NumVbs    int                    `json:"numVbuckets"` // vbuckets with a non-zero seqno
+	MaxSeqno  uint64                 `json:"maxSeqno"`
+	SeqnoSum  uint64                 `json:"seqnoSum"`
+	Count     int64                  `json:"itemsCount,omitempty"`
+	Size      int64                  `json:"size,omitempty"` // bytes on disk
+	Timestamp *common.TsVbuuid       `json:"timestamp,omitempty"`
+	Stats     map[string]interface{} `json:"stats,omitempty"`
+}
+
+// newSnapshotMeta returns the description of the snapshot info, with its
+// full timestamp and stats if verbose.
+func newSnapshotMeta(instId common.IndexInstId, partnId common.PartitionId,
+	info SnapshotInfo, verbose bool) *SnapshotMeta {
+
+	m := &SnapshotMeta{
+		InstId:    instId,
+		PartnId:   partnId,
+		Committed: info.IsCommitted(),
+		OSO:       info.IsOSOSnap(),
+	}
+
+	if ts := info.Timestamp(); ts != nil {
+		m.Bucket = ts.Bucket
+		m.SnapType = ts.GetSnapType().String()
+		for _, seqno := range ts.Seqnos {
+			if seqno != 0 {
+				m.NumVbs++
+			}
+			if seqno > m.MaxSeqno {
+				m.MaxSeqno = seqno
+			}
+			m.SeqnoSum += seqno
+		}
+		if verbose {
+			m.Timestamp = ts
+		}
+	}
+
+	switch info := info.(type) {
+	case *memdbSnapshotInfo:
+		if info.dataPath != "" {
+			// read from the manifest of a disk snapshot
+			m.Path = info.dataPath
+			m.Committed = true
+			m.Size, _ = common.DiskUsage(info.dataPath)
+			if instId == 0 {
+				m.InstId, m.PartnId = info.InstId, info.PartnId
+			}
+		}
+	case *plasmaSnapshotInfo:
+		m.Count = info.Count
+	}
+
+	if verbose {
+		m.Stats = info.Stats()
+	}
+	return m
+}
+
+// InspectSliceSnapshots returns the disk snapshots of the slice directory
+// path, latest first, reading only their manifests.
+func InspectSliceSnapshots(path string, verbose bool) ([]*SnapshotMeta, error) {
+
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%v is not a slice directory", path)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "mainIndex")); err == nil {
+		return nil, ErrSnapshotInspectUnsupported
+	}
+
+	infos, _ := readMemdbSnapshotInfos(getMemdbSnapshotManifests(path))
+	metas := make([]*SnapshotMeta, 0, len(infos))
+	for _, info := range infos {
+		metas = append(metas, newSnapshotMeta(0, 0, info, verbose))
+	}
+	return metas, nil
+}
+
+// getSnapshotMetas returns the snapshots of the slices of instId, or of all
+// instances if instId is 0, ordered by instance and partition.
+func (s *storageMgr) getSnapshotMetas(instId common.IndexInstId, verbose bool) ([]*SnapshotMeta, error) {
+
+	indexInstMap := s.indexInstMap.Get()
+	indexPartnMap := s.indexPartnMap.Get()
+
+	instIds := make([]common.IndexInstId, 0, len(indexPartnMap))
+	for id := range indexPartnMap {
+		if instId == 0 || id == instId {
+			instIds = append(instIds, id)
+		}
+	}
+	sort.Slice(instIds, func(i, j int) bool {
+		return instIds[i] < instIds[j]
+	})
+
+	var metas []*SnapshotMeta
+	for _, id := range instIds {
+		partnMap := indexPartnMap[id]
+		partnIds := make([]common.PartitionId, 0, len(partnMap))
+		for partnId := range partnMap {
+			partnIds = append(partnIds, partnId)
+		}
+		sort.Slice(partnIds, func(i, j int) bool {
+			return partnIds[i] < partnIds[j]
+		})
+
+		for _, partnId := range partnIds {
+			for _, slice := range partnMap[partnId].Sc.GetAllSlices() {
+				infos, err := slice.GetSnapshots()
+				if err != nil {
+					return nil, fmt.Errorf("Unable to read the snapshots of index %v partition %v: %v",
+						id, partnId, err)
+				}
+				for _, info := range infos {
+					m := newSnapshotMeta(id, partnId, info, verbose)
+					m.Index = indexInstMap[id].Defn.Name
+					metas = append(metas, m)
+				}
+			}
+		}
+	}
+	return metas, nil
+}
+
+func (s *storageMgr) handleSnapshotInfosReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.admin.internal.index!read"}, r, w,
+		"StorageMgr::handleSnapshotInfosReq") {
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	var instId uint64
+	if v := r.URL.Query().Get("instId"); v != "" {
+		if instId, err = strconv.ParseUint(v, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid instId " + v + "\n"))
+			return
+		}
+	}
+	verbose := r.URL.Query().Get("verbose") == "true"
+
+	metas, err := s.getSnapshotMetas(common.IndexInstId(instId), verbose)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	data, err := json.Marshal(metas)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
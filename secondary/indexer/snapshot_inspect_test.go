@@ -0,0 +1,74 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestInspectSliceSnapshots(t *testing.T) {
+	path, err := ioutil.TempDir("", "snapshot_inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	writeSnap := func(dir string, seqno uint64, snapType common.IndexSnapType) {
+		ts := common.NewTsVbuuid("default", 4)
+		ts.Seqnos[1], ts.Seqnos[3] = seqno, seqno*2
+		ts.SetSnapType(snapType)
+		bs, err := json.Marshal(&memdbSnapshotInfo{Ts: ts, InstId: 7, PartnId: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(path, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, dir, "manifest.json"), bs, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSnap("snapshot.2022-01-01.100000.000", 10, common.DISK_SNAP)
+	writeSnap("snapshot.2022-01-01.110000.000", 20, common.DISK_SNAP_OSO)
+	writeSnap(tmpDirName, 30, common.DISK_SNAP)
+
+	metas, err := InspectSliceSnapshots(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("expected 2 snapshots, got %v", len(metas))
+	}
+	latest := metas[0]
+	if latest.InstId != 7 || latest.PartnId != 1 || !latest.Committed || !latest.OSO ||
+		latest.SnapType != "DISK_SNAP_OSO" || latest.NumVbs != 2 || latest.MaxSeqno != 40 ||
+		latest.SeqnoSum != 60 || latest.Size == 0 || latest.Timestamp != nil {
+		t.Fatalf("unexpected latest snapshot %+v", latest)
+	}
+	if metas[1].OSO || metas[1].MaxSeqno != 20 {
+		t.Fatalf("unexpected oldest snapshot %+v", metas[1])
+	}
+
+	if metas, _ := InspectSliceSnapshots(path, true); metas[0].Timestamp == nil {
+		t.Fatalf("expected the timestamp when verbose")
+	}
+
+	if err := os.Mkdir(filepath.Join(path, "mainIndex"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InspectSliceSnapshots(path, false); err != ErrSnapshotInspectUnsupported {
+		t.Fatalf("expected plasma slices unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// With debug.snapshotLeakCheck set to N minutes, every clone of an index
+// snapshot is a copy of it recording when and where it was cloned, and
+// destroying the copy releases the record. A background checker logs, once,
+// each clone not destroyed within N minutes with the stack it was cloned at,
+// to find who leaks the references pinning storage resources. Recording the
+// stack of each clone is costly, so it is meant for debugging only.
+
+const snapshotLeakCheckInterval = time.Minute
+
+var snapshotLeakCheck int64 // minutes, 0 if disabled
+
+var snapshotLeakCheckerOnce sync.Once
+
+// setSnapshotLeakCheck sets the debug mode from config, starting the leak
+// checker on the first time it is enabled.
+func setSnapshotLeakCheck(cfg common.Config) {
+	minutes := int64(cfg["debug.snapshotLeakCheck"].Int())
+	if old := atomic.SwapInt64(&snapshotLeakCheck, minutes); old != minutes {
+		logging.Infof("setSnapshotLeakCheck: snapshot leak check set to %v minutes", minutes)
+	}
+	if minutes > 0 {
+		snapshotLeakCheckerOnce.Do(func() {
+			go runSnapshotLeakChecker(snapshotClones, snapshotLeakCheckInterval)
+		})
+	}
+}
+
+func snapshotLeakCheckEnabled() bool {
+	return atomic.LoadInt64(&snapshotLeakCheck) > 0
+}
+
+// cloneRecord is the origin of a clone of a snapshot.
+type cloneRecord struct {
+	instId   common.IndexInstId
+	snapId   int64
+	created  time.Time
+	pcs      []uintptr // stack of the caller of CloneIndexSnapshot
+	reported bool      // guarded by cloneRegistry.mu
+}
+
+func (r *cloneRecord) stack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(r.pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "\n\t%v\n\t\t%v:%v", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// cloneRegistry holds the records of the clones not destroyed yet.
+type cloneRegistry struct {
+	mu     sync.Mutex
+	clones map[*cloneRecord]struct{}
+}
+
+var snapshotClones = newCloneRegistry()
+
+func newCloneRegistry() *cloneRegistry {
+	return &cloneRegistry{
+		clones: make(map[*cloneRecord]struct{}),
+	}
+}
+
+// add records a clone of is, made skip frames above the caller of add.
+func (c *cloneRegistry) add(is *indexSnapshot, skip int) *cloneRecord {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+2, pcs)
+
+	r := &cloneRecord{
+		instId:  is.instId,
+		snapId:  is.snapId,
+		created: time.Now(),
+		pcs:     pcs[:n],
+	}
+
+	c.mu.Lock()
+	c.clones[r] = struct{}{}
+	c.mu.Unlock()
+	return r
+}
+
+func (c *cloneRegistry) remove(r *cloneRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clones, r)
+}
+
+// leaked returns the clones made more than age before now, not returned
+// before.
+func (c *cloneRegistry) leaked(now time.Time, age time.Duration) []*cloneRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var leaked []*cloneRecord
+	for r := range c.clones {
+		if !r.reported && now.Sub(r.created) > age {
+			r.reported = true
+			leaked = append(leaked, r)
+		}
+	}
+	return leaked
+}
+
+func runSnapshotLeakChecker(clones *cloneRegistry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		minutes := atomic.LoadInt64(&snapshotLeakCheck)
+		if minutes <= 0 {
+			continue
+		}
+
+		for _, r := range clones.leaked(now, time.Duration(minutes)*time.Minute) {
+			logging.Warnf("SnapshotLeakChecker: clone of snapshot %v of index %v not destroyed "+
+				"after %v, cloned at:%v", r.snapId, r.instId, now.Sub(r.created), r.stack())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshotLeakCheck(t *testing.T) {
+	is := &indexSnapshot{instId: 10, snapId: 1}
+	if CloneIndexSnapshot(is) != is {
+		t.Fatalf("expected the snapshot itself without the leak check")
+	}
+
+	atomic.StoreInt64(&snapshotLeakCheck, 1)
+	defer atomic.StoreInt64(&snapshotLeakCheck, 0)
+
+	leak := CloneIndexSnapshot(is)
+	destroyed := CloneIndexSnapshot(leak)
+	if leak == is || destroyed == leak {
+		t.Fatalf("expected a copy per clone")
+	}
+	DestroyIndexSnapshot(destroyed)
+
+	now := time.Now()
+	if leaked := snapshotClones.leaked(now, time.Minute); len(leaked) != 0 {
+		t.Fatalf("expected no leaks yet, got %v", len(leaked))
+	}
+
+	leaked := snapshotClones.leaked(now.Add(2*time.Minute), time.Minute)
+	if len(leaked) != 1 || leaked[0].instId != 10 || leaked[0].snapId != 1 {
+		t.Fatalf("expected the clone of snapshot 1 leaked, got %v", leaked)
+	}
+	if stack := leaked[0].stack(); !strings.Contains(stack, "TestSnapshotLeakCheck") {
+		t.Fatalf("expected the stack of the clone, got %v", stack)
+	}
+
+	// Leaks are reported once
+	if leaked = snapshotClones.leaked(now.Add(3*time.Minute), time.Minute); len(leaked) != 0 {
+		t.Fatalf("expected the leak reported once, got %v", len(leaked))
+	}
+
+	DestroyIndexSnapshot(leak)
+	snapshotClones.mu.Lock()
+	defer snapshotClones.mu.Unlock()
+	if len(snapshotClones.clones) != 0 {
+		t.Fatalf("expected no clones left, got %v", len(snapshotClones.clones))
+	}
+}
@@ -19,6 +19,14 @@ type IndexSnapshotContainer struct {
 	creationTime uint64
 }
 
+// cloneSnapshot returns a reference to the snapshot in the container, or
+// nil if there is none.
+func (sc *IndexSnapshotContainer) cloneSnapshot() IndexSnapshot {
+	sc.Lock()
+	defer sc.Unlock()
+	return CloneIndexSnapshot(sc.snap)
+}
+
 type IndexSnapMapHolder struct {
 	ptr *unsafe.Pointer
 }
@@ -0,0 +1,261 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Snapshot requests from scan coordinator are served by storage manager
+// workers, each listening on its own channel. All requests of an index
+// instance go to the same worker, picked by jump consistent hash of the
+// instance id. When the number of workers changes from n to m, only about
+// |n-m|/max(n,m) of the instances move to another worker, unlike with a
+// modulo of the number of workers, which moves almost all of them.
+//
+// When a worker backs up, it serves the requests queued on its channel by
+// earliest ExpiredTime first rather than FIFO, with requests without an
+// ExpiredTime last, so that fewer requests expire while queued. A request
+// queued for longer than snapshotReqMaxWait is served first regardless of
+// its deadline, so that requests with far or no deadlines are not starved.
+
+const snapshotReqChSize = 5000
+
+const snapshotReqMaxWait = time.Second
+
+const snapshotReqRetryInterval = time.Millisecond
+
+// snapshotReqRouter routes snapshot requests to storage manager workers.
+// Workers can be added or removed at runtime with resize.
+type snapshotReqRouter struct {
+	mu     sync.RWMutex
+	chs    []MsgChannel
+	listen func(MsgChannel) // starts a worker for the channel
+	closed bool
+}
+
+func newSnapshotReqRouter(numWorkers int) *snapshotReqRouter {
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+
+	r := &snapshotReqRouter{
+		chs: make([]MsgChannel, numWorkers),
+	}
+	for i := range r.chs {
+		r.chs[i] = make(MsgChannel, snapshotReqChSize)
+	}
+	return r
+}
+
+// setListener starts a worker with listen for each channel, and for those
+// added later.
+func (r *snapshotReqRouter) setListener(listen func(MsgChannel)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listen = listen
+	for _, ch := range r.chs {
+		go listen(ch)
+	}
+}
+
+// send routes req to the worker for its instance. If the router is closed
+// req is failed with ErrIndexNotReady. While the channel of the worker is
+// full, send retries without holding the lock, so that a backed up worker
+// does not block resize and close.
+func (r *snapshotReqRouter) send(req *MsgIndexSnapRequest) {
+	for !r.trySend(req) {
+		time.Sleep(snapshotReqRetryInterval)
+	}
+}
+
+// trySend routes req unless the channel of the worker is full.
+func (r *snapshotReqRouter) trySend(req *MsgIndexSnapRequest) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		req.respch <- common.ErrIndexNotReady
+		return true
+	}
+
+	select {
+	case r.chs[jumpHash(uint64(req.GetIndexId()), len(r.chs))] <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+// resize changes the number of workers to numWorkers. Removed workers
+// exit once they are done with the requests already sent to them.
+func (r *snapshotReqRouter) resize(numWorkers int) {
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	curr := len(r.chs)
+	if r.closed || numWorkers == curr {
+		return
+	}
+
+	if numWorkers > curr {
+		for i := curr; i < numWorkers; i++ {
+			ch := make(MsgChannel, snapshotReqChSize)
+			r.chs = append(r.chs, ch)
+			if r.listen != nil {
+				go r.listen(ch)
+			}
+		}
+	} else {
+		for _, ch := range r.chs[numWorkers:] {
+			close(ch)
+		}
+		r.chs = r.chs[:numWorkers:numWorkers]
+	}
+
+	logging.Infof("snapshotReqRouter::resize Workers changed from %v to %v", curr, numWorkers)
+}
+
+func (r *snapshotReqRouter) numWorkers() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.chs)
+}
+
+// close stops all workers.
+func (r *snapshotReqRouter) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+	for _, ch := range r.chs {
+		close(ch)
+	}
+}
+
+// snapshotReqQueue orders the snapshot requests taken off the channel of a
+// worker. It is not safe for concurrent use, each worker owns its own.
+type snapshotReqQueue struct {
+	heap    snapshotReqHeap    // by deadline
+	fifo    []*snapshotReqItem // by arrival, including items already popped from heap
+	seq     uint64
+	maxWait time.Duration
+}
+
+type snapshotReqItem struct {
+	req      *MsgIndexSnapRequest
+	deadline time.Time
+	arrival  time.Time
+	seq      uint64
+	index    int // in heap, -1 once popped
+}
+
+func newSnapshotReqQueue(maxWait time.Duration) *snapshotReqQueue {
+	return &snapshotReqQueue{maxWait: maxWait}
+}
+
+func (q *snapshotReqQueue) len() int {
+	return q.heap.Len()
+}
+
+func (q *snapshotReqQueue) push(req *MsgIndexSnapRequest, now time.Time) {
+	q.seq++
+	item := &snapshotReqItem{
+		req:      req,
+		deadline: req.GetExpiredTime(),
+		arrival:  now,
+		seq:      q.seq,
+	}
+	heap.Push(&q.heap, item)
+	q.fifo = append(q.fifo, item)
+}
+
+// pop removes and returns the request to serve next, that is, the oldest if
+// it has waited for maxWait, else the one with the earliest deadline. It
+// returns nil if the queue is empty.
+func (q *snapshotReqQueue) pop(now time.Time) *MsgIndexSnapRequest {
+	for len(q.fifo) > 0 && q.fifo[0].index < 0 {
+		q.fifo[0] = nil
+		q.fifo = q.fifo[1:]
+	}
+	if len(q.fifo) == 0 {
+		return nil
+	}
+
+	if oldest := q.fifo[0]; now.Sub(oldest.arrival) >= q.maxWait {
+		heap.Remove(&q.heap, oldest.index)
+		return oldest.req
+	}
+	return heap.Pop(&q.heap).(*snapshotReqItem).req
+}
+
+// snapshotReqHeap implements heap.Interface, ordering requests by deadline,
+// then by arrival. Requests without a deadline go last.
+type snapshotReqHeap []*snapshotReqItem
+
+func (h snapshotReqHeap) Len() int { return len(h) }
+
+func (h snapshotReqHeap) Less(i, j int) bool {
+	di, dj := h[i].deadline, h[j].deadline
+	if di.IsZero() != dj.IsZero() {
+		return dj.IsZero()
+	}
+	if !di.Equal(dj) {
+		return di.Before(dj)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h snapshotReqHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *snapshotReqHeap) Push(x interface{}) {
+	item := x.(*snapshotReqItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *snapshotReqHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// jumpHash maps key to one of numBuckets buckets, moving the fewest keys
+// when numBuckets changes. From "A Fast, Minimal Memory, Consistent Hash
+// Algorithm" by Lamping and Veach.
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
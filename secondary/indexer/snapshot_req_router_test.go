@@ -0,0 +1,174 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestJumpHash(t *testing.T) {
+	const numKeys = 10000
+
+	for key := uint64(0); key < numKeys; key++ {
+		if b := jumpHash(key, 1); b != 0 {
+			t.Fatalf("key %v: expected bucket 0 of 1, got %v", key, b)
+		}
+	}
+
+	// Growing from 8 to 10 buckets moves keys only to the new buckets,
+	// and about 1/5 of them.
+	moved := 0
+	for key := uint64(0); key < numKeys; key++ {
+		before, after := jumpHash(key, 8), jumpHash(key, 10)
+		if after < 0 || after >= 10 {
+			t.Fatalf("key %v: bucket %v out of range", key, after)
+		}
+		if before != after {
+			if after < 8 {
+				t.Fatalf("key %v: moved from %v to existing bucket %v", key, before, after)
+			}
+			moved++
+		}
+	}
+	if moved < numKeys/10 || moved > numKeys*3/10 {
+		t.Errorf("expected about %v keys to move, moved %v", numKeys/5, moved)
+	}
+}
+
+func TestSnapshotReqRouterResize(t *testing.T) {
+	r := newSnapshotReqRouter(2)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	served := make(map[common.IndexInstId]int)
+	r.setListener(func(reqCh MsgChannel) {
+		for cmd := range reqCh {
+			req := cmd.(*MsgIndexSnapRequest)
+			mu.Lock()
+			served[req.GetIndexId()]++
+			mu.Unlock()
+			req.respch <- nil
+			wg.Done()
+		}
+	})
+
+	sendAll := func() {
+		for instId := common.IndexInstId(1); instId <= 100; instId++ {
+			wg.Add(1)
+			req := &MsgIndexSnapRequest{idxInstId: instId, respch: make(chan interface{}, 1)}
+			r.send(req)
+			<-req.respch
+		}
+		wg.Wait()
+	}
+
+	for _, n := range []int{2, 5, 1, 3} {
+		r.resize(n)
+		if got := r.numWorkers(); got != n {
+			t.Fatalf("expected %v workers, got %v", n, got)
+		}
+		sendAll()
+	}
+
+	for instId, count := range served {
+		if count != 4 {
+			t.Errorf("inst %v: served %v requests, expected 4", instId, count)
+		}
+	}
+
+	r.close()
+	req := &MsgIndexSnapRequest{idxInstId: 1, respch: make(chan interface{}, 1)}
+	r.send(req)
+	if resp := <-req.respch; resp != common.ErrIndexNotReady {
+		t.Errorf("expected ErrIndexNotReady after close, got %v", resp)
+	}
+}
+
+func TestSnapshotReqRouterFull(t *testing.T) {
+	r := newSnapshotReqRouter(1)
+
+	// no worker takes the requests off the channel.
+	for i := 0; i < snapshotReqChSize; i++ {
+		r.send(&MsgIndexSnapRequest{idxInstId: 1, respch: make(chan interface{}, 1)})
+	}
+
+	req := &MsgIndexSnapRequest{idxInstId: 1, respch: make(chan interface{}, 1)}
+	go r.send(req)
+
+	done := make(chan bool)
+	go func() {
+		r.resize(2)
+		r.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("resize and close blocked by a send to a full channel")
+	}
+
+	select {
+	case resp := <-req.respch:
+		if resp != common.ErrIndexNotReady {
+			t.Errorf("expected ErrIndexNotReady after close, got %v", resp)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("send to a full channel not failed after close")
+	}
+}
+
+func TestSnapshotReqQueue(t *testing.T) {
+	now := time.Now()
+	q := newSnapshotReqQueue(time.Second)
+
+	push := func(instId common.IndexInstId, deadline time.Duration, at time.Time) {
+		req := &MsgIndexSnapRequest{idxInstId: instId}
+		if deadline != 0 {
+			req.expiredTime = now.Add(deadline)
+		}
+		q.push(req, at)
+	}
+	pop := func(at time.Time) common.IndexInstId {
+		req := q.pop(at)
+		if req == nil {
+			return 0
+		}
+		return req.GetIndexId()
+	}
+
+	// earliest deadline first, no deadline last, FIFO among equals
+	push(1, 0, now)
+	push(2, 30*time.Second, now)
+	push(3, 10*time.Second, now)
+	push(4, 0, now)
+	push(5, 10*time.Second, now)
+	for _, expected := range []common.IndexInstId{3, 5, 2, 1, 4, 0} {
+		if instId := pop(now); instId != expected {
+			t.Fatalf("expected inst %v, got %v", expected, instId)
+		}
+	}
+
+	// a request waiting for longer than maxWait goes first
+	push(6, 0, now)
+	push(7, 20*time.Second, now.Add(500*time.Millisecond))
+	push(8, 10*time.Second, now.Add(500*time.Millisecond))
+	for _, expected := range []common.IndexInstId{6, 8, 7} {
+		if instId := pop(now.Add(1200 * time.Millisecond)); instId != expected {
+			t.Fatalf("expected inst %v, got %v", expected, instId)
+		}
+	}
+	if q.len() != 0 {
+		t.Fatalf("expected empty queue, got %v requests", q.len())
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// An index snapshot retains memory for as long as it is open, as what is
+// written to the index after it was created cannot be reclaimed until then.
+// Each snapshot created by the storage manager is tracked with its
+// references: the one it is created with, held by the storage manager, and
+// one per clone not yet destroyed, held by scans, scan sessions, snapshot
+// notification consumers or waiters.
+//
+// With the storage stats, the number of open snapshots of an index, the age
+// of the oldest one and an estimate of the memory it retains are refreshed.
+// The estimate is the bytes inserted into and deleted from the index since
+// the oldest snapshot was created. A warning is logged once for each
+// snapshot open for longer than settings.snapshot.openWarnThreshold, so
+// that a leaked clone can be found without a heap profile.
+
+// snapshotRefs counts the open references to a tracked snapshot.
+type snapshotRefs struct {
+	snaps   *openSnapshots
+	snapId  int64
+	created time.Time
+	written int64 // bytes written to the index when created
+	refs    int32
+	warned  bool // guarded by snaps.mu
+}
+
+func (r *snapshotRefs) open() {
+	atomic.AddInt32(&r.refs, 1)
+}
+
+func (r *snapshotRefs) close() {
+	if atomic.AddInt32(&r.refs, -1) == 0 {
+		r.snaps.remove(r)
+	}
+}
+
+// openSnapshots tracks the open snapshots of an index.
+type openSnapshots struct {
+	mu    sync.Mutex
+	snaps map[*snapshotRefs]struct{}
+}
+
+func newOpenSnapshots() *openSnapshots {
+	return &openSnapshots{
+		snaps: make(map[*snapshotRefs]struct{}),
+	}
+}
+
+// track starts tracking is, created when written bytes had been written to
+// the index. It must not have been cloned yet.
+func (o *openSnapshots) track(is *indexSnapshot, written int64, now time.Time) {
+	refs := &snapshotRefs{
+		snaps:   o,
+		snapId:  is.snapId,
+		created: now,
+		written: written,
+		refs:    1,
+	}
+
+	o.mu.Lock()
+	o.snaps[refs] = struct{}{}
+	o.mu.Unlock()
+
+	is.refs = refs
+}
+
+func (o *openSnapshots) remove(refs *snapshotRefs) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.snaps, refs)
+}
+
+// openSnapshotsInfo describes the open snapshots of an index.
+type openSnapshotsInfo struct {
+	num     int
+	oldest  time.Duration // age of the oldest open snapshot
+	written int64         // bytes written to the index when it was created
+
+	// Snapshots open for longer than the warning threshold, not reported
+	// before
+	overdue []overdueSnapshot
+}
+
+type overdueSnapshot struct {
+	snapId int64
+	age    time.Duration
+	refs   int32
+}
+
+// info returns the open snapshots at now. Those open for longer than
+// warnAfter are reported as overdue once, unless warnAfter is 0.
+func (o *openSnapshots) info(now time.Time, warnAfter time.Duration) openSnapshotsInfo {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info := openSnapshotsInfo{num: len(o.snaps)}
+	for refs := range o.snaps {
+		age := now.Sub(refs.created)
+		if age > info.oldest {
+			info.oldest = age
+			info.written = refs.written
+		}
+		if warnAfter > 0 && age > warnAfter && !refs.warned {
+			refs.warned = true
+			info.overdue = append(info.overdue, overdueSnapshot{
+				snapId: refs.snapId,
+				age:    age,
+				refs:   atomic.LoadInt32(&refs.refs),
+			})
+		}
+	}
+	return info
+}
+
+// writtenBytes returns the bytes inserted into and deleted from the index,
+// as of the last storage stats.
+func (s *IndexStats) writtenBytes() int64 {
+	var written int64
+	for _, ps := range s.partitions {
+		written += ps.insertBytes.Value() + ps.deleteBytes.Value()
+	}
+	return written
+}
+
+// updateSnapshotStats refreshes the stats of the open snapshots of the
+// index instId, warning of the ones open for too long.
+func updateSnapshotStats(instId common.IndexInstId, s *IndexStats, cfg common.Config, now time.Time) {
+	warnAfter := time.Duration(cfg["settings.snapshot.openWarnThreshold"].Int()) * time.Second
+	info := s.openSnapshots.info(now, warnAfter)
+
+	retained := int64(0)
+	if info.num > 0 {
+		if retained = s.writtenBytes() - info.written; retained < 0 {
+			retained = 0
+		}
+	}
+
+	s.oldestSnapshotAge.Set(int64(info.oldest / time.Millisecond))
+	s.snapshotRetainedBytes.Set(retained)
+
+	for _, snap := range info.overdue {
+		logging.Warnf("Index %v (%v:%v:%v:%v) snapshot %v open for %v with %v references, "+
+			"%v open snapshots retain about %v bytes", instId, s.bucket, s.scope, s.collection,
+			s.name, snap.snapId, snap.age, snap.refs, info.num, retained)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpenSnapshots(t *testing.T) {
+	snaps := newOpenSnapshots()
+	now := time.Now()
+
+	old := &indexSnapshot{snapId: 1}
+	snaps.track(old, 100, now)
+	latest := &indexSnapshot{snapId: 2}
+	snaps.track(latest, 300, now.Add(time.Minute))
+
+	// A scan clones the old snapshot, before the storage manager replaces it
+	scan := CloneIndexSnapshot(old)
+	DestroyIndexSnapshot(old)
+
+	info := snaps.info(now.Add(2*time.Minute), 90*time.Second)
+	if info.num != 2 || info.oldest != 2*time.Minute || info.written != 100 {
+		t.Fatalf("expected 2 snapshots, the oldest created at 100 bytes, got %+v", info)
+	}
+	if len(info.overdue) != 1 || info.overdue[0].snapId != 1 || info.overdue[0].refs != 1 {
+		t.Fatalf("expected snapshot 1 overdue, got %+v", info.overdue)
+	}
+
+	// Overdue snapshots are reported once
+	if info = snaps.info(now.Add(3*time.Minute), 90*time.Second); len(info.overdue) != 1 ||
+		info.overdue[0].snapId != 2 {
+		t.Fatalf("expected only snapshot 2 overdue, got %+v", info.overdue)
+	}
+
+	DestroyIndexSnapshot(scan)
+	if info = snaps.info(now.Add(3*time.Minute), 0); info.num != 1 || info.written != 300 {
+		t.Fatalf("expected the latest snapshot open, got %+v", info)
+	}
+
+	DestroyIndexSnapshot(latest)
+	if info = snaps.info(now, 0); info.num != 0 || info.oldest != 0 {
+		t.Fatalf("expected no open snapshots, got %+v", info)
+	}
+}
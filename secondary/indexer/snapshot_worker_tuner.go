@@ -0,0 +1,136 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// With snapshotWorkers.autotune, the number of workers each keyspace in a
+// stream spawns to create snapshots is sized by the storage manager rather
+// than set with numSnapshotWorkers. It stays between the number of CPUs and
+// the number of instances of the largest keyspace, as more workers than
+// instances would be idle, and at most numSnapshotWorkers. Every
+// snapshotWorkers.autotuneInterval, workers are added if more than
+// snapshotWorkers.slowPercent of the snapshots generated since the last
+// tuning took longer than snapshotWorkers.targetLatency, and removed if
+// none did.
+
+// snapshotWorkerTuner tracks the number of snapshot workers and the
+// snapshot generation latencies seen at the last tuning.
+type snapshotWorkerTuner struct {
+	numWorkers int
+	lastTune   time.Time
+	prevCounts map[common.IndexInstId][]int64
+}
+
+// sample returns the number of snapshots generated since the last sample
+// and how many of them took longer than target.
+func (t *snapshotWorkerTuner) sample(stats *IndexerStats, target time.Duration) (total, slow int64) {
+	counts := make(map[common.IndexInstId][]int64, len(stats.indexes))
+	for instId, idxStats := range stats.indexes {
+		curr, bounds := idxStats.snapGenLatDist.Counts()
+		counts[instId] = curr
+
+		prev, ok := t.prevCounts[instId]
+		if !ok || len(prev) != len(curr) {
+			continue
+		}
+		for i := range curr {
+			delta := curr[i] - prev[i]
+			total += delta
+			// Slow if the whole bucket is above target
+			if i > 0 && bounds[i-1] >= int64(target) {
+				slow += delta
+			}
+		}
+	}
+	t.prevCounts = counts
+	return total, slow
+}
+
+// nextSnapshotWorkers returns the number of snapshot workers to use after
+// cur, between minWorkers and maxWorkers, given that slow of total snapshots
+// were slow, along with the reason for any change.
+func nextSnapshotWorkers(cur, minWorkers, maxWorkers int, total, slow int64,
+	slowPercent int) (int, string) {
+
+	if minWorkers > maxWorkers {
+		minWorkers = maxWorkers
+	}
+
+	next := cur
+	var reason string
+	switch {
+	case cur < minWorkers:
+		next, reason = minWorkers, "below minimum"
+	case cur > maxWorkers:
+		next, reason = maxWorkers, "above maximum"
+	case total == 0:
+	case slow*100 > total*int64(slowPercent):
+		next = cur + cur/2 + 1
+		reason = fmt.Sprintf("%v of %v snapshots slow", slow, total)
+	case slow == 0:
+		next = cur - cur/4
+		reason = fmt.Sprintf("none of %v snapshots slow", total)
+	}
+
+	if next > maxWorkers {
+		next = maxWorkers
+	}
+	if next < minWorkers {
+		next = minWorkers
+	}
+	return next, reason
+}
+
+// tuneSnapshotWorkers returns the number of snapshot workers, tuning it if
+// autotuneInterval has passed, or if the bounds no longer allow it.
+func (s *storageMgr) tuneSnapshotWorkers(maxWorkers int) int {
+	t := &s.snapWorkerTuner
+
+	maxInsts := 1
+	for _, keyspaceIdInstList := range s.streamKeyspaceIdInstList.Get() {
+		for _, instList := range keyspaceIdInstList {
+			if len(instList) > maxInsts {
+				maxInsts = len(instList)
+			}
+		}
+	}
+	if maxInsts < maxWorkers {
+		maxWorkers = maxInsts
+	}
+	minWorkers := runtime.GOMAXPROCS(0)
+
+	stats := s.stats.Get()
+	interval := time.Duration(s.config["snapshotWorkers.autotuneInterval"].Int()) * time.Second
+
+	var total, slow int64
+	if time.Since(t.lastTune) >= interval && stats != nil {
+		target := time.Duration(s.config["snapshotWorkers.targetLatency"].Int()) * time.Millisecond
+		total, slow = t.sample(stats, target)
+		t.lastTune = time.Now()
+	}
+
+	next, reason := nextSnapshotWorkers(t.numWorkers, minWorkers, maxWorkers, total, slow,
+		s.config["snapshotWorkers.slowPercent"].Int())
+	if next != t.numWorkers {
+		storageMgrLog.Infof("StorageMgr::tuneSnapshotWorkers Changing snapshot workers from %v to %v "+
+			"(%v, cpus %v, max instances %v)", t.numWorkers, next, reason, minWorkers, maxInsts)
+		t.numWorkers = next
+		if stats != nil {
+			stats.numSnapshotWorkersChanges.Add(1)
+		}
+	}
+	return t.numWorkers
+}
@@ -0,0 +1,64 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestNextSnapshotWorkers(t *testing.T) {
+	tests := []struct {
+		cur, min, max int
+		total, slow   int64
+		expected      int
+	}{
+		{0, 4, 40, 0, 0, 4},      // initial
+		{8, 4, 40, 0, 0, 8},      // no snapshots
+		{8, 4, 40, 100, 20, 13},  // slow
+		{8, 4, 40, 100, 5, 8},    // few slow
+		{8, 4, 40, 100, 0, 6},    // fast
+		{4, 4, 40, 100, 0, 4},    // fast at minimum
+		{36, 4, 40, 100, 50, 40}, // slow near maximum
+		{36, 4, 20, 0, 0, 20},    // maximum lowered
+		{4, 4, 2, 100, 100, 2},   // fewer instances than cpus
+	}
+
+	for i, test := range tests {
+		next, _ := nextSnapshotWorkers(test.cur, test.min, test.max, test.total, test.slow, 10)
+		if next != test.expected {
+			t.Fatalf("test %v: expected %v workers, got %v", i, test.expected, next)
+		}
+	}
+}
+
+func TestSnapshotWorkerTunerSample(t *testing.T) {
+	idxStats := &IndexStats{}
+	idxStats.snapGenLatDist.InitLatency(snapLatencyDist, nil)
+	stats := &IndexerStats{indexes: map[common.IndexInstId]*IndexStats{1: idxStats}}
+
+	var tuner snapshotWorkerTuner
+	idxStats.snapGenLatDist.Add(int64(time.Millisecond))
+	if total, _ := tuner.sample(stats, 50*time.Millisecond); total != 0 {
+		t.Fatalf("expected no snapshots on the first sample, got %v", total)
+	}
+
+	for i := 0; i < 8; i++ {
+		idxStats.snapGenLatDist.Add(int64(3 * time.Millisecond))
+	}
+	idxStats.snapGenLatDist.Add(int64(60 * time.Millisecond))
+	idxStats.snapGenLatDist.Add(int64(2 * time.Second))
+
+	total, slow := tuner.sample(stats, 50*time.Millisecond)
+	if total != 10 || slow != 2 {
+		t.Fatalf("expected 2 of 10 slow snapshots, got %v of %v", slow, total)
+	}
+}
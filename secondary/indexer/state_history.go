@@ -0,0 +1,247 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// The indexer keeps the last stateHistorySize state transitions of each index
+// instance, e.g. CREATED -> INITIAL -> CATCHUP -> ACTIVE -> DELETED, with the
+// time and cause of each, listed by /internal/indexStateHistory. The cause is
+// the message the indexer was processing when the transition was distributed
+// to its workers. The histories of dropped instances are kept too, for the
+// last maxDeletedStateHistories of them.
+
+const stateHistorySize = 16
+
+const maxDeletedStateHistories = 256
+
+// stateTransition is a state change of an index instance.
+type stateTransition struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	Stream string    `json:"stream"`
+	Cause  string    `json:"cause"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// instStateHistory is the state history of an index instance.
+type instStateHistory struct {
+	InstId      common.IndexInstId `json:"instId"`
+	DefnId      common.IndexDefnId `json:"defnId"`
+	Bucket      string             `json:"bucket"`
+	Scope       string             `json:"scope"`
+	Collection  string             `json:"collection"`
+	Name        string             `json:"name"`
+	ReplicaId   int                `json:"replicaId"`
+	State       string             `json:"state"`
+	Transitions []stateTransition  `json:"transitions"` // oldest first
+	Dropped     int                `json:"droppedTransitions,omitempty"`
+	state       common.IndexState
+	ring        [stateHistorySize]stateTransition
+	count       int // transitions recorded
+}
+
+type stateHistory struct {
+	mu      sync.Mutex
+	insts   map[common.IndexInstId]*instStateHistory
+	deleted []common.IndexInstId // oldest first
+}
+
+func newStateHistory() *stateHistory {
+	return &stateHistory{
+		insts: make(map[common.IndexInstId]*instStateHistory),
+	}
+}
+
+// observe records the state transitions of the instances of instMap since
+// the last observation, and the instances gone from it as DELETED.
+func (h *stateHistory) observe(instMap common.IndexInstMap, cause string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for instId, inst := range instMap {
+		hist, ok := h.insts[instId]
+		if !ok {
+			hist = &instStateHistory{
+				InstId:     instId,
+				DefnId:     inst.Defn.DefnId,
+				Bucket:     inst.Defn.Bucket,
+				Scope:      inst.Defn.Scope,
+				Collection: inst.Defn.Collection,
+				Name:       inst.Defn.Name,
+				ReplicaId:  inst.ReplicaId,
+				state:      common.INDEX_STATE_NIL,
+			}
+			h.insts[instId] = hist
+		} else if hist.state == inst.State {
+			continue
+		}
+		h.record(hist, inst.State, inst.Stream, cause, inst.Error, now)
+	}
+
+	for instId, hist := range h.insts {
+		if _, ok := instMap[instId]; !ok && hist.state != common.INDEX_STATE_DELETED {
+			h.record(hist, common.INDEX_STATE_DELETED, common.NIL_STREAM, cause, "", now)
+		}
+	}
+}
+
+func (h *stateHistory) record(hist *instStateHistory, state common.IndexState,
+	stream common.StreamId, cause, errStr string, now time.Time) {
+
+	t := stateTransition{
+		Time:   now,
+		To:     state.String(),
+		Stream: stream.String(),
+		Cause:  cause,
+		Error:  errStr,
+	}
+	if hist.state != common.INDEX_STATE_NIL {
+		t.From = hist.state.String()
+	}
+	hist.ring[hist.count%stateHistorySize] = t
+	hist.count++
+	hist.state = state
+
+	if state == common.INDEX_STATE_DELETED {
+		h.deleted = append(h.deleted, hist.InstId)
+		if len(h.deleted) > maxDeletedStateHistories {
+			delete(h.insts, h.deleted[0])
+			h.deleted = h.deleted[1:]
+		}
+	}
+}
+
+// get returns the state history of instId, or nil if there is none.
+func (h *stateHistory) get(instId common.IndexInstId) *instStateHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hist, ok := h.insts[instId]; ok {
+		return hist.export()
+	}
+	return nil
+}
+
+// list returns the state histories of the instances of defnId, or of all
+// instances if defnId is 0, ordered by instance id.
+func (h *stateHistory) list(defnId common.IndexDefnId) []*instStateHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hists := make([]*instStateHistory, 0, len(h.insts))
+	for _, hist := range h.insts {
+		if defnId == 0 || hist.DefnId == defnId {
+			hists = append(hists, hist.export())
+		}
+	}
+	sort.Slice(hists, func(i, j int) bool {
+		return hists[i].InstId < hists[j].InstId
+	})
+	return hists
+}
+
+// export returns a copy of hist with its transitions in order.
+func (hist *instStateHistory) export() *instStateHistory {
+	out := *hist
+	out.State = hist.state.String()
+
+	n := hist.count
+	if n > stateHistorySize {
+		out.Dropped = n - stateHistorySize
+		n = stateHistorySize
+	}
+	out.Transitions = make([]stateTransition, 0, n)
+	for i := hist.count - n; i < hist.count; i++ {
+		out.Transitions = append(out.Transitions, hist.ring[i%stateHistorySize])
+	}
+	return &out
+}
+
+func (idx *indexer) handleStateHistoryReq(w http.ResponseWriter, r *http.Request) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	} else if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(common.HTTP_STATUS_UNAUTHORIZED)
+		return
+	}
+
+	if !common.IsAllowed(creds, []string{"cluster.n1ql.meta!read"}, r, w,
+		"Indexer::handleStateHistoryReq") {
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported method"))
+		return
+	}
+
+	parseId := func(name string) (uint64, error) {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			return 0, nil
+		}
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, errors.New("Invalid " + name + " " + v)
+		}
+		return id, nil
+	}
+
+	instId, err := parseId("instId")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	defnId, err := parseId("defnId")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	var data []byte
+	if instId != 0 {
+		hist := idx.stateHistory.get(common.IndexInstId(instId))
+		if hist == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("No state history of index instance " + strconv.FormatUint(instId, 10) + "\n"))
+			return
+		}
+		data, err = json.Marshal(hist)
+	} else {
+		data, err = json.Marshal(idx.stateHistory.list(common.IndexDefnId(defnId)))
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,99 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestStateHistory(t *testing.T) {
+	h := newStateHistory()
+	now := time.Now()
+
+	inst := common.IndexInst{
+		InstId: 10,
+		Defn:   common.IndexDefn{DefnId: 1, Bucket: "b", Scope: "s", Collection: "c", Name: "idx"},
+		State:  common.INDEX_STATE_CREATED,
+	}
+	other := inst
+	other.InstId, other.Defn.DefnId = 20, 2
+
+	instMap := common.IndexInstMap{10: inst, 20: other}
+	h.observe(instMap, "bootstrap", now)
+
+	for _, state := range []common.IndexState{common.INDEX_STATE_INITIAL,
+		common.INDEX_STATE_INITIAL, common.INDEX_STATE_CATCHUP, common.INDEX_STATE_ACTIVE} {
+		inst.State = state
+		inst.Stream = common.INIT_STREAM
+		instMap[10] = inst
+		h.observe(instMap, "CLUST_MGR_BUILD_INDEX_DDL", now)
+	}
+	delete(instMap, 10)
+	h.observe(instMap, "CLUST_MGR_DROP_INDEX_DDL", now)
+
+	hist := h.get(10)
+	if hist == nil || hist.State != "INDEX_STATE_DELETED" || hist.Name != "idx" {
+		t.Fatalf("expected the history of a deleted instance, got %+v", hist)
+	}
+	expected := [][2]string{
+		{"", "INDEX_STATE_CREATED"},
+		{"INDEX_STATE_CREATED", "INDEX_STATE_INITIAL"},
+		{"INDEX_STATE_INITIAL", "INDEX_STATE_CATCHUP"},
+		{"INDEX_STATE_CATCHUP", "INDEX_STATE_ACTIVE"},
+		{"INDEX_STATE_ACTIVE", "INDEX_STATE_DELETED"},
+	}
+	if len(hist.Transitions) != len(expected) {
+		t.Fatalf("expected %v transitions, got %+v", len(expected), hist.Transitions)
+	}
+	for i, tr := range hist.Transitions {
+		if tr.From != expected[i][0] || tr.To != expected[i][1] {
+			t.Fatalf("expected transition %v from %v to %v, got %+v", i, expected[i][0], expected[i][1], tr)
+		}
+	}
+	if hist.Transitions[0].Cause != "bootstrap" || hist.Transitions[4].Cause != "CLUST_MGR_DROP_INDEX_DDL" {
+		t.Fatalf("expected the causes of the transitions, got %+v", hist.Transitions)
+	}
+
+	if hists := h.list(2); len(hists) != 1 || hists[0].InstId != 20 {
+		t.Fatalf("expected the history of defn 2, got %+v", hists)
+	}
+	if hists := h.list(0); len(hists) != 2 {
+		t.Fatalf("expected the histories of 2 instances, got %v", len(hists))
+	}
+}
+
+func TestStateHistoryRing(t *testing.T) {
+	h := newStateHistory()
+	now := time.Now()
+
+	states := []common.IndexState{common.INDEX_STATE_CREATED, common.INDEX_STATE_INITIAL}
+	for i := 0; i < stateHistorySize+5; i++ {
+		h.observe(common.IndexInstMap{1: {InstId: 1, State: states[i%2]}}, "test", now.Add(time.Duration(i)))
+	}
+
+	hist := h.get(1)
+	if len(hist.Transitions) != stateHistorySize || hist.Dropped != 5 {
+		t.Fatalf("expected the last %v transitions, got %v with %v dropped",
+			stateHistorySize, len(hist.Transitions), hist.Dropped)
+	}
+	if last := hist.Transitions[stateHistorySize-1]; !last.Time.Equal(now.Add(stateHistorySize + 4)) {
+		t.Fatalf("expected the latest transition last, got %v", last.Time)
+	}
+
+	// only the histories of the latest deleted instances are kept
+	for instId := common.IndexInstId(100); instId < 100+maxDeletedStateHistories+1; instId++ {
+		h.observe(common.IndexInstMap{instId: {InstId: instId, State: common.INDEX_STATE_DELETED}}, "test", now)
+	}
+	if h.get(1) != nil || h.get(100) != nil || h.get(101) == nil {
+		t.Fatalf("expected the oldest deleted histories evicted")
+	}
+}
@@ -72,16 +72,22 @@ type KeyspaceStats struct {
 	keyspaceId string
 
 	// Statistics in alphabetical order
-	avgDcpSnapSize     stats.Uint64Val
-	mutationQueueSize  stats.Int64Val
-	numMutationsQueued stats.Int64Val
-	numNonAlignTS      stats.Int64Val
-	numRollbacks       stats.Int64Val
-	numRollbacksToZero stats.Int64Val
-	tsQueueSize        stats.Int64Val
-	flushLatDist       stats.Histogram
-	snapLatDist        stats.Histogram
-	lastSnapDone       stats.Int64Val
+	avgDcpSnapSize      stats.Uint64Val
+	mutationQueueSize   stats.Int64Val
+	numFlushAborts      stats.Int64Val
+	numFlushAbortsMem   stats.Int64Val // aborts under mutation queue memory pressure
+	numFlushAbortsRbk   stats.Int64Val // aborts for the recovery of a rollback
+	numFlushAbortsStop  stats.Int64Val // aborts on shutdown
+	numMutationsQueued  stats.Int64Val
+	numNonAlignTS       stats.Int64Val
+	numPartialRollbacks stats.Int64Val
+	numRollbacks        stats.Int64Val
+	numRollbacksToZero  stats.Int64Val
+	snapInterval        stats.Int64Val // effective in-memory snapshot interval in milliseconds
+	tsQueueSize         stats.Int64Val
+	flushLatDist        stats.Histogram
+	snapLatDist         stats.Histogram
+	lastSnapDone        stats.Int64Val
 }
 
 // KeyspaceStats.Init initializes a per-keyspace stats object.
@@ -89,10 +95,16 @@ func (s *KeyspaceStats) Init(keyspaceId string) {
 	s.keyspaceId = keyspaceId
 	s.numRollbacks.Init()
 	s.numRollbacksToZero.Init()
+	s.numPartialRollbacks.Init()
+	s.numFlushAborts.Init()
+	s.numFlushAbortsMem.Init()
+	s.numFlushAbortsRbk.Init()
+	s.numFlushAbortsStop.Init()
 	s.mutationQueueSize.Init()
 	s.numMutationsQueued.Init()
 	s.tsQueueSize.Init()
 	s.numNonAlignTS.Init()
+	s.snapInterval.Init()
 	s.avgDcpSnapSize.Init()
 	s.flushLatDist.InitLatency(latencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.snapLatDist.InitLatency(snapLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
@@ -102,10 +114,16 @@ func (s *KeyspaceStats) Init(keyspaceId string) {
 func (s *KeyspaceStats) addKeyspaceStatsToStatsMap(statMap *StatsMap) {
 	statMap.AddStatValueFiltered("num_rollbacks", &s.numRollbacks)
 	statMap.AddStatValueFiltered("num_rollbacks_to_zero", &s.numRollbacksToZero)
+	statMap.AddStatValueFiltered("num_partial_rollbacks", &s.numPartialRollbacks)
+	statMap.AddStatValueFiltered("num_flush_aborts", &s.numFlushAborts)
+	statMap.AddStatValueFiltered("num_flush_aborts_memory", &s.numFlushAbortsMem)
+	statMap.AddStatValueFiltered("num_flush_aborts_rollback", &s.numFlushAbortsRbk)
+	statMap.AddStatValueFiltered("num_flush_aborts_shutdown", &s.numFlushAbortsStop)
 	statMap.AddStatValueFiltered("mutation_queue_size", &s.mutationQueueSize)
 	statMap.AddStatValueFiltered("num_mutations_queued", &s.numMutationsQueued)
 	statMap.AddStatValueFiltered("ts_queue_size", &s.tsQueueSize)
 	statMap.AddStatValueFiltered("num_nonalign_ts", &s.numNonAlignTS)
+	statMap.AddStatValueFiltered("inmem_snapshot_interval", &s.snapInterval)
 	statMap.AddStatValueFiltered("avg_dcp_snap_size", &s.avgDcpSnapSize)
 	statMap.AddStatValueFiltered("flush_latency_dist", &s.flushLatDist)
 	statMap.AddStatValueFiltered("snapshot_latency_dist", &s.snapLatDist)
@@ -168,6 +186,16 @@ type IndexStats struct {
 
 	partitions map[common.PartitionId]*IndexStats
 
+	usage *indexUsage // scans over rolling windows, shared by clones
+
+	keyStats *indexKeyStats // value distribution sketches, shared by clones
+
+	hotKeys *indexHotKeys // updates per docid, shared by clones
+
+	scanLatencies *latencyWindow // recent scan latencies, shared by clones
+
+	openSnapshots *openSnapshots // tracked snapshots not destroyed, shared by clones
+
 	scanDuration              stats.Int64Val
 	scanReqDuration           stats.Int64Val
 	scanReqInitDuration       stats.Int64Val
@@ -192,12 +220,22 @@ type IndexStats struct {
 	numRowsReturnedAggr       stats.Int64Val
 	numRowsScannedAggr        stats.Int64Val
 	scanCacheHitAggr          stats.Int64Val
+	numCountCacheHits         stats.Int64Val
+	numSeqnosCacheHits        stats.Int64Val
+	numSeqnosCacheMisses      stats.Int64Val
 	numRowsScanned            stats.Int64Val
 	numStrictConsReqs         stats.Int64Val
+	numAnyConsScans           stats.Int64Val
+	numSessionConsScans       stats.Int64Val
+	numQueryConsScans         stats.Int64Val
+	numReplicaChecks          stats.Int64Val
+	numReplicaDivergences     stats.Int64Val
+	numReplicaChecksSkipped   stats.Int64Val
 	diskSize                  stats.Int64Val
 	memUsed                   stats.Int64Val
 	buildProgress             stats.Int64Val
 	completionProgress        stats.Int64Val
+	buildEta                  stats.Int64Val // seconds, -1 if not known
 	numDocsQueued             stats.Int64Val
 	deleteBytes               stats.Int64Val
 	dataSize                  stats.Int64Val
@@ -226,12 +264,22 @@ type IndexStats struct {
 	numSnapshotWaiters        stats.Int64Val
 	numLastSnapshotReply      stats.Int64Val
 	numItemsRestored          stats.Int64Val
+	numOverflowKeys           stats.Int64Val // # large keys stored as overflow entries
 	diskSnapStoreDuration     stats.Int64Val
 	diskSnapLoadDuration      stats.Int64Val
+	diskSnapPersistBacklog    stats.Int64Val // # snapshots queued for persistence
+	numDiskSnapsSkipped       stats.Int64Val // # snapshots not persisted as the queue was full
 	notReadyError             stats.Int64Val
 	clientCancelError         stats.Int64Val
 	numScanTimeouts           stats.Int64Val
+	scanPoolSize              stats.Int64Val
+	scanPoolActive            stats.Int64Val
+	numScanPoolWaits          stats.Int64Val
+	numScanPoolRejects        stats.Int64Val
 	numScanErrors             stats.Int64Val
+	numPartnScanRetries       stats.Int64Val // # partition scans retried after a transient error
+	materializedCount         stats.Int64Val // exact items count as of the latest snapshot
+	numArrEntriesKept         stats.Int64Val // # array entries left in place by updates of array keys
 	avgScanRate               stats.Int64Val
 	avgMutationRate           stats.Int64Val
 	avgDrainRate              stats.Int64Val
@@ -239,6 +287,7 @@ type IndexStats struct {
 	lastScanGatherTime        stats.Int64Val
 	lastNumRowsScanned        stats.Int64Val
 	lastMutateGatherTime      stats.Int64Val
+	storageStatsStaleness     stats.Int64Val // ms since the storage stats were gathered
 	lastNumDocsIndexed        stats.Int64Val
 	lastNumItemsFlushed       stats.Int64Val
 	lastDiskBytes             stats.Int64Val
@@ -273,6 +322,11 @@ type IndexStats struct {
 	lastNumRequests  stats.Int64Val
 	avgScanLatency   stats.Int64Val
 
+	adaptiveScanTimeout stats.Int64Val // ms, 0 if settings.scan_timeout is used
+
+	oldestSnapshotAge     stats.Int64Val // ms
+	snapshotRetainedBytes stats.Int64Val // written since the oldest open snapshot
+
 	Timings IndexTimingStats
 
 	// Placeholder stats used during GetStats call.
@@ -291,6 +345,8 @@ type IndexStats struct {
 	scanReqWaitLatDist stats.Histogram
 	scanReqLatDist     stats.Histogram
 	snapGenLatDist     stats.Histogram
+
+	scanShapes [numScanShapes]scanShapeStats
 }
 
 type IndexerStatsHolder struct {
@@ -434,12 +490,30 @@ func (s *IndexStats) Init() {
 	s.numRowsReturnedAggr.Init()
 	s.numRowsScannedAggr.Init()
 	s.scanCacheHitAggr.Init()
+	s.numCountCacheHits.Init()
+	s.numSeqnosCacheHits.Init()
+	s.numSeqnosCacheMisses.Init()
 	s.numRowsScanned.Init()
 	s.numStrictConsReqs.Init()
+	s.numAnyConsScans.Init()
+	s.numSessionConsScans.Init()
+	s.numQueryConsScans.Init()
+	s.numReplicaChecks.Init()
+	s.numReplicaDivergences.Init()
+	s.numReplicaChecksSkipped.Init()
+	s.usage = &indexUsage{}
+	s.keyStats = &indexKeyStats{}
+	s.hotKeys = &indexHotKeys{}
+	s.scanLatencies = &latencyWindow{}
+	s.adaptiveScanTimeout.Init()
+	s.openSnapshots = newOpenSnapshots()
+	s.oldestSnapshotAge.Init()
+	s.snapshotRetainedBytes.Init()
 	s.diskSize.Init()
 	s.memUsed.Init()
 	s.buildProgress.Init()
 	s.completionProgress.Init()
+	s.buildEta.Init()
 	s.numDocsQueued.Init()
 	s.deleteBytes.Init()
 	s.dataSize.Init()
@@ -468,12 +542,22 @@ func (s *IndexStats) Init() {
 	s.numSnapshotWaiters.Init()
 	s.numLastSnapshotReply.Init()
 	s.numItemsRestored.Init()
+	s.numOverflowKeys.Init()
 	s.diskSnapStoreDuration.Init()
 	s.diskSnapLoadDuration.Init()
+	s.diskSnapPersistBacklog.Init()
+	s.numDiskSnapsSkipped.Init()
 	s.notReadyError.Init()
 	s.clientCancelError.Init()
 	s.numScanTimeouts.Init()
+	s.scanPoolSize.Init()
+	s.scanPoolActive.Init()
+	s.numScanPoolWaits.Init()
+	s.numScanPoolRejects.Init()
 	s.numScanErrors.Init()
+	s.numPartnScanRetries.Init()
+	s.materializedCount.Init()
+	s.numArrEntriesKept.Init()
 	s.avgScanRate.Init()
 	s.avgMutationRate.Init()
 	s.avgDrainRate.Init()
@@ -481,6 +565,7 @@ func (s *IndexStats) Init() {
 	s.lastScanGatherTime.Init()
 	s.lastNumRowsScanned.Init()
 	s.lastMutateGatherTime.Init()
+	s.storageStatsStaleness.Init()
 	s.lastNumDocsIndexed.Init()
 	s.lastNumItemsFlushed.Init()
 	s.lastDiskBytes.Init()
@@ -532,6 +617,9 @@ func (s *IndexStats) Init() {
 	s.scanReqWaitLatDist.InitLatency(latencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.scanReqLatDist.InitLatency(scanReqLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.snapGenLatDist.InitLatency(snapLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
+	for i := range s.scanShapes {
+		s.scanShapes[i].Init()
+	}
 
 	s.partitions = make(map[common.PartitionId]*IndexStats)
 
@@ -569,6 +657,7 @@ func (s *IndexStats) SetPlannerFilters() {
 func (s *IndexStats) SetIndexStatusFilters() {
 	s.buildProgress.AddFilter(stats.IndexStatusFilter)
 	s.completionProgress.AddFilter(stats.IndexStatusFilter)
+	s.buildEta.AddFilter(stats.IndexStatusFilter)
 	s.lastScanTime.AddFilter(stats.IndexStatusFilter)
 }
 
@@ -713,8 +802,14 @@ type IndexerStats struct {
 	keyspaceStatsMap KeyspaceStatsMapHolder
 
 	numConnections     stats.Int64Val
+	numStreams         stats.Int64Val // streams of multiplexed connections
+	numEvictedIdle     stats.Int64Val
+	numEvictedAged     stats.Int64Val
+	numRejectedConns   stats.Int64Val // past the limit of connections per client
 	memoryQuota        stats.Int64Val
 	memoryUsed         stats.Int64Val
+	cgroupMemoryLimit  stats.Int64Val // 0 if cgroups are not supported
+	cgroupCpuPercent   stats.Int64Val // 0 if cgroups are not supported
 	memoryUsedStorage  stats.Int64Val
 	memoryTotalStorage stats.Int64Val
 	memoryUsedQueue    stats.Int64Val
@@ -722,9 +817,23 @@ type IndexerStats struct {
 	statsResponse      stats.TimingStat
 	notFoundError      stats.Int64Val
 
+	memoryUsedScan stats.Int64Val // estimated, by the buffers of running scans
+
+	// budgets of the memory governor, in percent of the share of each consumer
+	memoryBudgetStorage stats.Int64Val
+	memoryBudgetQueue   stats.Int64Val
+	memoryBudgetScan    stats.Int64Val
+	numMemoryShrinks    stats.Int64Val
+
+	numDiagnosticsCaptures stats.Int64Val
+
+	numStaleStorageStats       stats.Int64Val // partitions served from the cache in the last round
+	storageStatsGatherDuration stats.Int64Val // ms of the last round
+
 	indexerState  stats.Int64Val
 	prjLatencyMap *LatencyMapHolder
 	nodeToHostMap *NodeToHostMapHolder
+	scanConns     *scanConns // compressing scan responses, shared by clones
 
 	timestamp      stats.StringVal
 	uptime         stats.StringVal
@@ -744,10 +853,16 @@ type IndexerStats struct {
 	avgDiskBps          stats.Int64Val
 	totalDataSize       stats.Int64Val
 	totalDiskSize       stats.Int64Val
+	diskUsedPercent     stats.Int64Val // of the fullest storage volume
+	numDiskFullPauses   stats.Int64Val
 
 	numGoroutine stats.Int64Val
 	numCgoCall   stats.Int64Val
 
+	numSnapshotWorkers        stats.Int64Val
+	numSnapshotWorkersChanges stats.Int64Val
+	numMergeSnapshotRetries   stats.Int64Val
+
 	// indexerStateHolder holds atomic ptr to a string giving indexer state (e.g. Active, Paused)
 	indexerStateHolder stats.StringVal
 }
@@ -756,8 +871,22 @@ func (s *IndexerStats) Init() {
 	s.indexes = make(map[common.IndexInstId]*IndexStats)
 	s.keyspaceStatsMap.Init()
 	s.numConnections.Init()
+	s.numStreams.Init()
+	s.numEvictedIdle.Init()
+	s.numEvictedAged.Init()
+	s.numRejectedConns.Init()
 	s.memoryQuota.Init()
 	s.memoryUsed.Init()
+	s.cgroupMemoryLimit.Init()
+	s.cgroupCpuPercent.Init()
+	s.memoryUsedScan.Init()
+	s.memoryBudgetStorage.Init()
+	s.memoryBudgetQueue.Init()
+	s.memoryBudgetScan.Init()
+	s.numMemoryShrinks.Init()
+	s.numDiagnosticsCaptures.Init()
+	s.numStaleStorageStats.Init()
+	s.storageStatsGatherDuration.Init()
 	s.memoryUsedStorage.Init()
 	s.memoryTotalStorage.Init()
 	s.memoryUsedQueue.Init()
@@ -771,6 +900,8 @@ func (s *IndexerStats) Init() {
 	s.nodeToHostMap = &NodeToHostMapHolder{}
 	s.nodeToHostMap.Init()
 
+	s.scanConns = newScanConns()
+
 	s.timestamp.Init()
 	s.uptime.Init()
 	s.storageMode.Init()
@@ -790,10 +921,16 @@ func (s *IndexerStats) Init() {
 	s.avgDiskBps.Init()
 	s.totalDataSize.Init()
 	s.totalDiskSize.Init()
+	s.diskUsedPercent.Init()
+	s.numDiskFullPauses.Init()
 
 	s.numGoroutine.Init()
 	s.numCgoCall.Init()
 
+	s.numSnapshotWorkers.Init()
+	s.numSnapshotWorkersChanges.Init()
+	s.numMergeSnapshotRetries.Init()
+
 	s.SetPlannerFilters()
 	s.SetSmartBatchingFilters()
 	s.SetIndexStatusFilters()
@@ -801,6 +938,10 @@ func (s *IndexerStats) Init() {
 
 	// Set values of invarients on Init.
 	s.numCPU.Set(int64(num_cpu_core))
+
+	s.memoryBudgetStorage.Set(100)
+	s.memoryBudgetQueue.Set(100)
+	s.memoryBudgetScan.Set(100)
 }
 
 // SetSmartBatchingFilters marks the IndexerStats needed by Smart Batching for Rebalance.
@@ -831,9 +972,12 @@ func (s *IndexerStats) SetSummaryFilters() {
 	s.memoryUsedStorage.AddFilter(stats.SummaryFilter)
 	s.memoryTotalStorage.AddFilter(stats.SummaryFilter)
 	s.memoryUsedQueue.AddFilter(stats.SummaryFilter)
+	s.memoryUsedScan.AddFilter(stats.SummaryFilter)
 	s.memoryRss.AddFilter(stats.SummaryFilter)
 
 	s.numCPU.AddFilter(stats.SummaryFilter)
+	s.cgroupMemoryLimit.AddFilter(stats.SummaryFilter)
+	s.cgroupCpuPercent.AddFilter(stats.SummaryFilter)
 	s.cpuUtilization.AddFilter(stats.SummaryFilter)
 	s.avgResidentPercent.AddFilter(stats.SummaryFilter)
 	s.avgMutationRate.AddFilter(stats.SummaryFilter)
@@ -1023,12 +1167,26 @@ func (s *IndexStats) getArrKeySizeStats() map[string]interface{} {
 
 func (is *IndexerStats) PopulateIndexerStats(statMap *StatsMap) {
 	statMap.AddStatValueFiltered("num_connections", &is.numConnections)
+	statMap.AddStatValueFiltered("num_streams", &is.numStreams)
+	statMap.AddStatValueFiltered("num_connections_evicted_idle", &is.numEvictedIdle)
+	statMap.AddStatValueFiltered("num_connections_evicted_age", &is.numEvictedAged)
+	statMap.AddStatValueFiltered("num_connections_rejected", &is.numRejectedConns)
 	statMap.AddStatValueFiltered("index_not_found_errcount", &is.notFoundError)
 	statMap.AddStatValueFiltered("memory_quota", &is.memoryQuota)
+	statMap.AddStatValueFiltered("cgroup_memory_limit", &is.cgroupMemoryLimit)
+	statMap.AddStatValueFiltered("cgroup_cpu_percent", &is.cgroupCpuPercent)
 	statMap.AddStatValueFiltered("memory_used", &is.memoryUsed)
 	statMap.AddStatValueFiltered("memory_used_storage", &is.memoryUsedStorage)
 	statMap.AddStatValueFiltered("memory_total_storage", &is.memoryTotalStorage)
 	statMap.AddStatValueFiltered("memory_used_queue", &is.memoryUsedQueue)
+	statMap.AddStatValueFiltered("memory_used_scan", &is.memoryUsedScan)
+	statMap.AddStatValueFiltered("memory_budget_storage_percent", &is.memoryBudgetStorage)
+	statMap.AddStatValueFiltered("memory_budget_queue_percent", &is.memoryBudgetQueue)
+	statMap.AddStatValueFiltered("memory_budget_scan_percent", &is.memoryBudgetScan)
+	statMap.AddStatValueFiltered("num_memory_shrinks", &is.numMemoryShrinks)
+	statMap.AddStatValueFiltered("num_diagnostics_captures", &is.numDiagnosticsCaptures)
+	statMap.AddStatValueFiltered("num_stale_storage_stats", &is.numStaleStorageStats)
+	statMap.AddStatValueFiltered("storage_stats_gather_duration", &is.storageStatsGatherDuration)
 	statMap.AddStatValueFiltered("needs_restart", &is.needsRestart)
 	statMap.AddStatValueFiltered("num_cpu_core", &is.numCPU)
 	statMap.AddStatValueFiltered("avg_resident_percent", &is.avgResidentPercent)
@@ -1037,8 +1195,13 @@ func (is *IndexerStats) PopulateIndexerStats(statMap *StatsMap) {
 	statMap.AddStatValueFiltered("avg_disk_bps", &is.avgDiskBps)
 	statMap.AddStatValueFiltered("total_data_size", &is.totalDataSize)
 	statMap.AddStatValueFiltered("total_disk_size", &is.totalDiskSize)
+	statMap.AddStatValueFiltered("disk_used_percent", &is.diskUsedPercent)
+	statMap.AddStatValueFiltered("num_disk_full_pauses", &is.numDiskFullPauses)
 	statMap.AddStatValueFiltered("num_storage_instances", &is.numStorageInstances)
 	statMap.AddStatValueFiltered("num_indexes", &is.numIndexes)
+	statMap.AddStatValueFiltered("num_snapshot_workers", &is.numSnapshotWorkers)
+	statMap.AddStatValueFiltered("num_snapshot_workers_changes", &is.numSnapshotWorkersChanges)
+	statMap.AddStatValueFiltered("num_merge_snapshot_retries", &is.numMergeSnapshotRetries)
 
 	is.numGoroutine.Set(int64(runtime.NumGoroutine()))
 	statMap.AddStatValueFiltered("num_goroutine", &is.numGoroutine)
@@ -1441,6 +1604,18 @@ func (s *IndexStats) constructIndexStats(skipEmpty bool, version string) common.
 		s.int64Stats(func(ss *IndexStats) int64 {
 			return ss.numScanErrors.Value()
 		}))
+	addStat("num_partition_scan_retries",
+		s.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.numPartnScanRetries.Value()
+		}))
+	addStat("materialized_items_count",
+		s.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.materializedCount.Value()
+		}))
+	addStat("num_array_entries_kept",
+		s.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.numArrEntriesKept.Value()
+		}))
 
 	return indexStats
 }
@@ -1527,6 +1702,8 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.buildProgress, s.int64Stats)
 
+	statMap.AddStatValueFiltered("build_eta", &s.buildEta)
+
 	statMap.AddAggrStatFiltered("num_docs_queued",
 		func(ss *IndexStats) int64 {
 			return ss.numDocsQueued.Value()
@@ -1594,6 +1771,18 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.numSnapshotWaiters, s.int64Stats)
 
+	statMap.AddAggrStatFiltered("disk_snap_persist_backlog",
+		func(ss *IndexStats) int64 {
+			return ss.diskSnapPersistBacklog.Value()
+		},
+		&s.diskSnapPersistBacklog, s.int64Stats)
+
+	statMap.AddAggrStatFiltered("num_disk_snaps_skipped",
+		func(ss *IndexStats) int64 {
+			return ss.numDiskSnapsSkipped.Value()
+		},
+		&s.numDiskSnapsSkipped, s.int64Stats)
+
 	statMap.AddAggrStatFiltered("num_last_snapshot_reply",
 		func(ss *IndexStats) int64 {
 			return ss.numLastSnapshotReply.Value()
@@ -1645,6 +1834,24 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.numRowsScanned, s.partnInt64Stats)
 
+	statMap.AddAggrStatFiltered("num_partition_scan_retries",
+		func(ss *IndexStats) int64 {
+			return ss.numPartnScanRetries.Value()
+		},
+		&s.numPartnScanRetries, s.partnInt64Stats)
+
+	statMap.AddAggrStatFiltered("materialized_items_count",
+		func(ss *IndexStats) int64 {
+			return ss.materializedCount.Value()
+		},
+		&s.materializedCount, s.partnInt64Stats)
+
+	statMap.AddAggrStatFiltered("num_array_entries_kept",
+		func(ss *IndexStats) int64 {
+			return ss.numArrEntriesKept.Value()
+		},
+		&s.numArrEntriesKept, s.partnInt64Stats)
+
 	statMap.AddAggrStatFiltered("disk_size",
 		func(ss *IndexStats) int64 {
 			return ss.diskSize.Value()
@@ -1731,6 +1938,12 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.numItemsRestored, s.partnInt64Stats)
 
+	statMap.AddAggrStatFiltered("num_overflow_keys",
+		func(ss *IndexStats) int64 {
+			return ss.numOverflowKeys.Value()
+		},
+		&s.numOverflowKeys, s.partnInt64Stats)
+
 	statMap.AddAggrStatFiltered("avg_scan_rate",
 		func(ss *IndexStats) int64 {
 			return ss.avgScanRate.Value()
@@ -1785,10 +1998,20 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 	statMap.AddStatValueFiltered("num_requests", &s.numRequests)
 	statMap.AddStatValueFiltered("last_known_scan_time", &s.lastScanTime)
 	statMap.AddStatValueFiltered("num_completed_requests", &s.numCompletedRequests)
+	statMap.AddStatValueFiltered("scan_pool_size", &s.scanPoolSize)
+	statMap.AddStatValueFiltered("scan_pool_active", &s.scanPoolActive)
+	statMap.AddStatValueFiltered("num_scan_pool_waits", &s.numScanPoolWaits)
+	statMap.AddStatValueFiltered("num_scan_pool_rejects", &s.numScanPoolRejects)
 	statMap.AddStatValueFiltered("last_rollback_time", &s.lastRollbackTime)
 	statMap.AddStatValueFiltered("progress_stat_time", &s.progressStatTime)
 	statMap.AddStatValueFiltered("avg_scan_latency", &s.avgScanLatency)
 	statMap.AddStatValueFiltered("num_strict_cons_scans", &s.numStrictConsReqs)
+	statMap.AddStatValueFiltered("num_any_cons_scans", &s.numAnyConsScans)
+	statMap.AddStatValueFiltered("num_session_cons_scans", &s.numSessionConsScans)
+	statMap.AddStatValueFiltered("num_query_cons_scans", &s.numQueryConsScans)
+	statMap.AddStatValueFiltered("num_replica_checks", &s.numReplicaChecks)
+	statMap.AddStatValueFiltered("num_replica_divergences", &s.numReplicaDivergences)
+	statMap.AddStatValueFiltered("num_replica_checks_skipped", &s.numReplicaChecksSkipped)
 
 	rawDataSize := s.partnInt64Stats(func(ss *IndexStats) int64 {
 		return ss.rawDataSize.Value()
@@ -1835,6 +2058,10 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 	statMap.AddStatValueFiltered("scan_req_init_latency_dist", &s.scanReqInitLatDist)
 	statMap.AddStatValueFiltered("scan_req_wait_latency_dist", &s.scanReqWaitLatDist)
 	statMap.AddStatValueFiltered("scan_req_latency_dist", &s.scanReqLatDist)
+	s.addScanShapeStats(statMap)
+	statMap.AddStatValueFiltered("adaptive_scan_timeout", &s.adaptiveScanTimeout)
+	statMap.AddStatValueFiltered("oldest_snapshot_age", &s.oldestSnapshotAge)
+	statMap.AddStatValueFiltered("snapshot_retained_bytes", &s.snapshotRetainedBytes)
 	statMap.AddStatValueFiltered("snapshot_gen_latency_dist", &s.snapGenLatDist)
 
 	if !spec.essential {
@@ -1850,6 +2077,12 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.keySizeStatsSince, s.partnMaxInt64Stats)
 
+	statMap.AddAggrStatFiltered("storage_stats_staleness",
+		func(ss *IndexStats) int64 {
+			return ss.storageStatsStaleness.Value()
+		},
+		&s.storageStatsStaleness, s.partnMaxInt64Stats)
+
 	// -------------------------------
 	// All partnAvgInt64Stats
 	// -------------------------------
@@ -2059,6 +2292,24 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 			},
 			&s.scanCacheHitAggr, s.int64Stats)
 
+		statMap.AddAggrStatFiltered("num_count_cache_hits",
+			func(ss *IndexStats) int64 {
+				return ss.numCountCacheHits.Value()
+			},
+			&s.numCountCacheHits, s.int64Stats)
+
+		statMap.AddAggrStatFiltered("num_seqnos_cache_hits",
+			func(ss *IndexStats) int64 {
+				return ss.numSeqnosCacheHits.Value()
+			},
+			&s.numSeqnosCacheHits, s.int64Stats)
+
+		statMap.AddAggrStatFiltered("num_seqnos_cache_misses",
+			func(ss *IndexStats) int64 {
+				return ss.numSeqnosCacheMisses.Value()
+			},
+			&s.numSeqnosCacheMisses, s.int64Stats)
+
 		statMap.AddStatByInstIdFiltered("completion_progress",
 			func(ss *IndexStats) int64 {
 				return ss.completionProgress.Value()
@@ -2628,6 +2879,8 @@ type statsManager struct {
 	exitPersister            uint64
 	statsUpdaterStopCh       chan bool
 
+	cboStatsInvalidateCh chan bool
+
 	stReqRecCount uint64
 }
 
@@ -2640,6 +2893,7 @@ func NewStatsManager(supvCmdch MsgChannel,
 		lastStatTime:             time.Unix(0, 0),
 		statsLogDumpInterval:     config["settings.statsLogDumpInterval"].Uint64(),
 		statsPersistenceInterval: config["statsPersistenceInterval"].Uint64(),
+		cboStatsInvalidateCh:     make(chan bool, 1),
 	}
 
 	s.config.Store(config)
@@ -2649,6 +2903,7 @@ func NewStatsManager(supvCmdch MsgChannel,
 
 	go s.run()
 	go s.runStatsDumpLogger()
+	go s.runCBOStatsPublisher()
 	StartCpuCollector()
 	return s, &MsgSuccess{}
 }
@@ -2660,6 +2915,11 @@ func (s *statsManager) RegisterRestEndpoints() {
 	mux.HandleFunc("/stats/storage/mm", s.handleStorageMMStatsReq)
 	mux.HandleFunc("/stats/storage", s.handleStorageStatsReq)
 	mux.HandleFunc("/stats/reset", s.handleStatsResetReq)
+	mux.HandleFunc("/stats/buildProgress", s.handleBuildProgressReq)
+	mux.HandleFunc("/stats/unusedIndexes", s.handleUnusedIndexesReq)
+	mux.HandleFunc("/stats/keyDistribution", s.handleKeyDistributionReq)
+	mux.HandleFunc("/stats/hotKeys", s.handleHotKeysReq)
+	mux.HandleFunc("/stats/connections", s.handleConnectionsReq)
 	mux.HandleFunc("/_prometheusMetrics", s.handleMetrics)
 	mux.HandleFunc("/_prometheusMetricsHigh", s.handleMetricsHigh)
 }
@@ -2936,6 +3196,11 @@ func (s *statsManager) getStorageStats(spec *statsSpec) string {
 	s.supvMsgch <- statReq
 	res := <-replych
 
+	var filter *storageStatsFilter
+	if spec != nil {
+		filter = getStorageStatsFilter(spec.consumerFilter)
+	}
+
 	result.WriteString("[\n")
 	for i, sts := range res {
 		if i > 0 {
@@ -2955,7 +3220,18 @@ func (s *statsManager) getStorageStats(spec *statsSpec) string {
 				sts.Bucket, sts.Scope, sts.Collection, sts.Name, sts.InstId, sts.PartnId))
 		}
 
+		result.WriteString(fmt.Sprintf("\"Tier\": \"%s\",\n", sts.Tier))
 		result.WriteString(fmt.Sprintf("\"Stats\":\n"))
+		if filter != nil {
+			data, err := filter.marshal(&sts.Stats)
+			if err == nil {
+				result.Write(data)
+				result.WriteString("\n}\n")
+				continue
+			}
+			logging.Errorf("getStorageStats: unable to marshal stats of %v:%v, err %v",
+				sts.InstId, sts.PartnId, err)
+		}
 		for _, data := range sts.GetInternalData() {
 			result.WriteString(data)
 		}
@@ -3013,7 +3289,7 @@ func (s *statsManager) handleStorageStatsReq(w http.ResponseWriter, r *http.Requ
 
 		spec := NewStatsSpec(false, false, false, false, false, indexSpec)
 		if consumerFilter != "" {
-			spec.OverrideFilter(consumerFilter)
+			spec.OverrideStorageFilter(consumerFilter)
 		}
 
 		if common.IndexerState(stats.indexerState.Value()) != common.INDEXER_BOOTSTRAP {
@@ -3161,6 +3437,7 @@ loop:
 func (s *statsManager) handleIndexInstanceUpdate(cmd Message) {
 	req := cmd.(*MsgUpdateInstMap)
 	s.stats.Set(req.GetStatsObject())
+	s.invalidateCBOStats()
 	s.supvCmdch <- &MsgSuccess{}
 }
 
@@ -0,0 +1,160 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging/systemevent"
+)
+
+// The storage manager validates a config update before applying it. The
+// settings it depends on must have the type of their default and be in
+// range, and snapshot intervals must be consistent with each other. An
+// invalid update is rejected as a whole: the storage manager keeps its
+// config, replies to the supervisor with an error and logs the rejection
+// to the event log. The changes of an applied update are logged to the
+// event log too.
+
+// storageConfigRange is the range of the numeric setting key, with max 0
+// if unbounded.
+type storageConfigRange struct {
+	key string
+	min int64
+	max int64
+}
+
+var storageConfigRanges = []storageConfigRange{
+	{"numSnapshotWorkers", 0, 10000}, // 0 for as many as indexes
+	{"settings.snapshotRequestWorkers", 0, 1024},
+	{"settings.inmemory_snapshot.interval", 1, 0},
+	{"settings.inmemory_snapshot.fdb.interval", 1, 0},
+	{"settings.inmemory_snapshot.moi.interval", 1, 0},
+	{"settings.inmemory_snapshot.adaptive.min_interval", 1, 0},
+	{"settings.inmemory_snapshot.adaptive.max_interval", 1, 0},
+	{"settings.persisted_snapshot.interval", 1, 0},
+	{"settings.persisted_snapshot.fdb.interval", 1, 0},
+	{"settings.persisted_snapshot.moi.interval", 1, 0},
+	{"settings.persisted_snapshot_init_build.interval", 1, 0},
+	{"settings.persisted_snapshot_init_build.fdb.interval", 1, 0},
+	{"settings.persisted_snapshot_init_build.moi.interval", 1, 0},
+	{"settings.memory_quota", 1, 0},
+	{"snapshot.maxRecoveryPoints", 0, 0},
+	{"settings.snapshot.openWarnThreshold", 0, 0},
+	{"debug.snapshotLeakCheck", 0, 0},
+}
+
+// Persisted snapshots are taken at in-memory snapshots, so they cannot be
+// more frequent, for each storage mode
+var storageSnapshotIntervals = []string{"", ".fdb", ".moi"}
+
+// configInt returns the value of the numeric setting key, or an error if it
+// is missing or does not have the type of its default.
+func configInt(cfg common.Config, key string) (int64, error) {
+	cv, ok := cfg[key]
+	if !ok {
+		return 0, fmt.Errorf("missing setting %v", key)
+	}
+
+	if reflect.TypeOf(cv.Value) != reflect.TypeOf(cv.DefaultVal) {
+		return 0, fmt.Errorf("invalid type %T of setting %v, expected %T", cv.Value, key, cv.DefaultVal)
+	}
+
+	v := reflect.ValueOf(cv.Value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > uint64(1<<63-1) {
+			return 0, fmt.Errorf("invalid value %v of setting %v", cv.Value, key)
+		}
+		return int64(v.Uint()), nil
+	}
+	return 0, fmt.Errorf("invalid type %T of setting %v, expected a number", cv.Value, key)
+}
+
+// validateStorageConfig returns an error for the first invalid setting of
+// cfg the storage manager depends on.
+func validateStorageConfig(cfg common.Config) error {
+
+	for _, r := range storageConfigRanges {
+		v, err := configInt(cfg, r.key)
+		if err != nil {
+			return err
+		}
+		if v < r.min || (r.max > 0 && v > r.max) {
+			if r.max > 0 {
+				return fmt.Errorf("invalid value %v of setting %v, expected %v to %v", v, r.key, r.min, r.max)
+			}
+			return fmt.Errorf("invalid value %v of setting %v, expected at least %v", v, r.key, r.min)
+		}
+	}
+
+	for _, mode := range storageSnapshotIntervals {
+		inmem := "settings.inmemory_snapshot" + mode + ".interval"
+		for _, persisted := range []string{
+			"settings.persisted_snapshot" + mode + ".interval",
+			"settings.persisted_snapshot_init_build" + mode + ".interval",
+		} {
+			if cfg[persisted].Uint64() < cfg[inmem].Uint64() {
+				return fmt.Errorf("setting %v %v is lower than %v %v", persisted,
+					cfg[persisted].Uint64(), inmem, cfg[inmem].Uint64())
+			}
+		}
+	}
+
+	if cfg["snapshot.atomicKeyspace"].Bool() && cfg["snapshot.maxRecoveryPoints"].Int() == 0 {
+		return fmt.Errorf("setting snapshot.atomicKeyspace requires snapshot.maxRecoveryPoints")
+	}
+
+	return nil
+}
+
+// logConfigRejected logs the settings of newConfig that differ from
+// oldConfig, rejected for err.
+func logConfigRejected(oldConfig, newConfig common.Config, err error) {
+	_, diffNew := oldConfig.Diff(newConfig)
+
+	storageMgrLog.Errorf("StorageMgr::handleConfigUpdate Rejected settings %v. Error %v",
+		configKeys(diffNew), err)
+
+	se := systemevent.NewSettingsRejectedEvent("StorageMgr:handleConfigUpdate",
+		diffNew.Map(), err.Error())
+	systemevent.WarnEvent("Indexer", systemevent.EVENTID_INDEXER_SETTINGS_REJECTED, se)
+}
+
+// logConfigChanges logs the settings of newConfig that differ from
+// oldConfig, once applied.
+func logConfigChanges(oldConfig, newConfig common.Config) {
+	diffOld, diffNew := oldConfig.Diff(newConfig)
+	if len(diffOld) == 0 {
+		return
+	}
+
+	for _, key := range configKeys(diffNew) {
+		storageMgrLog.Infof("StorageMgr::handleConfigUpdate Setting %v changed from %v to %v",
+			key, diffOld[key].Value, diffNew[key].Value)
+	}
+
+	se := systemevent.NewSettingsChangeEvent("StorageMgr:handleConfigUpdate",
+		diffOld.Map(), diffNew.Map())
+	systemevent.InfoEvent("Indexer", systemevent.EVENTID_INDEXER_SETTINGS_CHANGE, se)
+}
+
+func configKeys(cfg common.Config) []string {
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,55 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestValidateStorageConfig(t *testing.T) {
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	if err := validateStorageConfig(cfg); err != nil {
+		t.Fatalf("expected the default config valid, got %v", err)
+	}
+
+	invalid := map[string]func(cfg common.Config){
+		"negative workers": func(cfg common.Config) {
+			cfg.SetValue("numSnapshotWorkers", -1)
+		},
+		"zero interval": func(cfg common.Config) {
+			cfg.SetValue("settings.inmemory_snapshot.moi.interval", 0)
+		},
+		"zero quota": func(cfg common.Config) {
+			cfg.SetValue("settings.memory_quota", 0)
+		},
+		"wrong type": func(cfg common.Config) {
+			cv := cfg["settings.snapshotRequestWorkers"]
+			cv.Value = "4"
+			cfg["settings.snapshotRequestWorkers"] = cv
+		},
+		"persisted before in-memory": func(cfg common.Config) {
+			cfg.SetValue("settings.inmemory_snapshot.interval", 1000)
+			cfg.SetValue("settings.persisted_snapshot.interval", 500)
+		},
+		"atomic keyspace without recovery points": func(cfg common.Config) {
+			cfg.SetValue("snapshot.atomicKeyspace", true)
+			cfg.SetValue("snapshot.maxRecoveryPoints", 0)
+		},
+	}
+
+	for name, update := range invalid {
+		cfg := common.SystemConfig.SectionConfig("indexer.", true)
+		update(cfg)
+		if err := validateStorageConfig(cfg); err == nil {
+			t.Fatalf("expected %v rejected", name)
+		}
+	}
+}
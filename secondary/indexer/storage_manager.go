@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
@@ -29,6 +30,8 @@ var (
 	ErrIndexRollbackOrBootstrap = errors.New("Indexer rollback or warmup")
 )
 
+var storageMgrLog = logging.GetComponentLogger(logging.StorageMgrComponent)
+
 type KeyspaceIdInstList map[string][]common.IndexInstId
 type StreamKeyspaceIdInstList map[common.StreamId]KeyspaceIdInstList
 
@@ -40,6 +43,9 @@ type StreamKeyspaceIdInstsPerWorker map[common.StreamId]KeyspaceIdInstsPerWorker
 
 const INST_MAP_KEY_NAME = "IndexInstMap"
 
+// Interval at which snapshot waiters past their expiry are failed
+const snapshotWaiterReapInterval = time.Second
+
 type StorageManager interface {
 }
 
@@ -47,12 +53,14 @@ type storageMgr struct {
 	supvCmdch  MsgChannel //supervisor sends commands on this channel
 	supvRespch MsgChannel //channel to send any async message to supervisor
 
-	snapshotReqCh []MsgChannel // Channel to listen for snapshot requests from scan coordinator
+	snapshotReqs *snapshotReqRouter // Routes snapshot requests from scan coordinator to workers
 
 	snapshotNotifych []chan IndexSnapshot
 
-	indexInstMap  IndexInstMapHolder
-	indexPartnMap IndexPartnMapHolder
+	indexInstMap   IndexInstMapHolder
+	indexPartnMap  IndexPartnMapHolder
+	instMapVersion uint64              // version of indexInstMap, to apply delta updates
+	metaInstMap    common.IndexInstMap // indexInstMap as stored in meta, if the manager is disabled
 
 	streamKeyspaceIdInstList       StreamKeyspaceIdInstListHolder
 	streamKeyspaceIdInstsPerWorker StreamKeyspaceIdInstsPerWorkerHolder
@@ -75,11 +83,27 @@ type storageMgr struct {
 	statsLock sync.Mutex
 
 	lastFlushDone int64
+
+	snapWorkerTuner snapshotWorkerTuner
+
+	recoveryPoints *recoveryPoints
+
+	snapshotWorkers sync.WaitGroup // in-flight createSnapshotWorker
+
+	tiers *storageTiers // protected by statsLock, nil if tiers are disabled
+
+	statsCache *storageStatsCache // protected by statsLock
+
+	reaperStopCh StopChannel // closed on shutdown to stop reapSnapshotWaiters
+
+	// validateRestartTsVbuuid, unless replaced to not fetch failover logs
+	validateRestartTs func(keyspaceId string, restartTs *common.TsVbuuid) *common.TsVbuuid
 }
 
 type snapshotWaiter struct {
 	wch       chan interface{}
 	ts        *common.TsVbuuid
+	vbnos     []uint16 // vbuckets of a partial at_plus ts, nil for all
 	cons      common.Consistency
 	idxInstId common.IndexInstId
 	expired   time.Time
@@ -88,11 +112,12 @@ type snapshotWaiter struct {
 type PartnSnapMap map[common.PartitionId]PartitionSnapshot
 
 func newSnapshotWaiter(idxId common.IndexInstId, ts *common.TsVbuuid,
-	cons common.Consistency,
+	vbnos []uint16, cons common.Consistency,
 	ch chan interface{}, expired time.Time) *snapshotWaiter {
 
 	return &snapshotWaiter{
 		ts:        ts,
+		vbnos:     vbnos,
 		cons:      cons,
 		wch:       ch,
 		idxInstId: idxId,
@@ -115,24 +140,11 @@ func (w *snapshotWaiter) Error(err error) {
 //If supvCmdch get closed, storageMgr will shut itself down.
 func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 	indexPartnMap IndexPartnMap, config common.Config, snapshotNotifych []chan IndexSnapshot,
-	snapshotReqCh []MsgChannel, stats *IndexerStats) (StorageManager, Message) {
+	snapshotReqs *snapshotReqRouter, stats *IndexerStats) (StorageManager, Message) {
 
-	//Init the storageMgr struct
-	s := &storageMgr{
-		supvCmdch:        supvCmdch,
-		supvRespch:       supvRespch,
-		snapshotNotifych: snapshotNotifych,
-		snapshotReqCh:    snapshotReqCh,
-		config:           config,
-	}
-	s.indexInstMap.Init()
-	s.indexPartnMap.Init()
-	s.indexSnapMap.Init()
-	s.waitersMap.Init()
-	s.stats.Set(stats)
+	s := newStorageMgr(supvCmdch, supvRespch, config, snapshotNotifych, snapshotReqs, stats)
 
-	s.streamKeyspaceIdInstList.Init()
-	s.streamKeyspaceIdInstsPerWorker.Init()
+	setSnapshotLeakCheck(config)
 
 	//if manager is not enabled, create meta file
 	if config["enableManager"].Bool() == false {
@@ -150,9 +162,11 @@ func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 		}
 	}
 
-	for i := 0; i < len(s.snapshotReqCh); i++ {
-		go s.listenSnapshotReqs(i)
-	}
+	s.snapshotReqs.setListener(s.listenSnapshotReqs)
+
+	go s.reapSnapshotWaiters()
+
+	GetHTTPMux().HandleFunc("/internal/snapshotInfos", s.handleSnapshotInfosReq)
 
 	//start Storage Manager loop which listens to commands from its supervisor
 	go s.run()
@@ -161,6 +175,36 @@ func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 
 }
 
+//newStorageMgr initializes the storageMgr struct, without opening the
+//meta file or starting its loop, so that its handlers can also be
+//driven directly.
+func newStorageMgr(supvCmdch MsgChannel, supvRespch MsgChannel,
+	config common.Config, snapshotNotifych []chan IndexSnapshot,
+	snapshotReqs *snapshotReqRouter, stats *IndexerStats) *storageMgr {
+
+	s := &storageMgr{
+		supvCmdch:        supvCmdch,
+		supvRespch:       supvRespch,
+		snapshotNotifych: snapshotNotifych,
+		snapshotReqs:     snapshotReqs,
+		config:           config,
+		recoveryPoints:   newRecoveryPoints(filepath.Join(config["storage_dir"].String(), RECOVERY_POINT_DIR)),
+		reaperStopCh:     make(StopChannel),
+	}
+	s.indexInstMap.Init()
+	s.indexPartnMap.Init()
+	s.indexSnapMap.Init()
+	s.waitersMap.Init()
+	s.stats.Set(stats)
+
+	s.streamKeyspaceIdInstList.Init()
+	s.streamKeyspaceIdInstsPerWorker.Init()
+
+	s.validateRestartTs = s.validateRestartTsVbuuid
+
+	return s
+}
+
 //run starts the storage manager loop which listens to messages
 //from its supervisor(indexer)
 func (s *storageMgr) run() {
@@ -173,10 +217,15 @@ loop:
 		case cmd, ok := <-s.supvCmdch:
 			if ok {
 				if cmd.GetMsgType() == STORAGE_MGR_SHUTDOWN {
-					logging.Infof("StorageManager::run Shutting Down")
+					storageMgrLog.Infof("StorageManager::run Shutting Down")
+					if s.config["shutdown.forceCommit"].Bool() {
+						timeout := time.Duration(s.config["shutdown.forceCommitTimeout"].Int()) * time.Second
+						s.commitOnShutdown(timeout)
+					}
 					for i := 0; i < len(s.snapshotNotifych); i++ {
 						close(s.snapshotNotifych[i])
 					}
+					close(s.reaperStopCh)
 					s.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -190,6 +239,95 @@ loop:
 	}
 }
 
+//commitOnShutdown commits the latest snapshot of every slice which is not
+//on disk yet, so that the indexer recovers from it after a restart instead
+//of replaying the mutations since the last disk snapshot. Slices left at
+//the deadline recover from their last disk snapshot.
+func (s *storageMgr) commitOnShutdown(timeout time.Duration) {
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+
+	//wait for snapshots being created, which commit on their own
+	donech := make(chan bool)
+	go func() {
+		s.snapshotWorkers.Wait()
+		close(donech)
+	}()
+	select {
+	case <-donech:
+	case <-time.After(timeout):
+		storageMgrLog.Warnf("StorageMgr::commitOnShutdown Timed out waiting for snapshots. Skipping commit.")
+		return
+	}
+
+	var numCommitted, numSkipped int
+	indexInstMap := s.indexInstMap.Get()
+	indexSnapMap := s.indexSnapMap.Get()
+	for idxInstId, partnMap := range s.indexPartnMap.Get() {
+		idxInst, ok := indexInstMap[idxInstId]
+		snapC, ok2 := indexSnapMap[idxInstId]
+		if !ok || !ok2 || idxInst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		snapC.Lock()
+		is := CloneIndexSnapshot(snapC.snap)
+		snapC.Unlock()
+		if is == nil {
+			continue
+		}
+
+		for partnId, ps := range is.Partitions() {
+			partnInst, ok := partnMap[partnId]
+			if !ok {
+				continue
+			}
+
+			for _, ss := range ps.Slices() {
+				slice := partnInst.Sc.GetSliceById(ss.SliceId())
+				ts := ss.Snapshot().Timestamp()
+				if slice == nil || ts == nil {
+					continue
+				}
+
+				if time.Now().After(deadline) {
+					numSkipped++
+					continue
+				}
+
+				infos, err := slice.GetSnapshots()
+				if err != nil {
+					storageMgrLog.Errorf("StorageMgr::commitOnShutdown Error reading snapshots of "+
+						"Index: %v PartitionId: %v SliceId: %v. Skipped. Error %v", idxInstId,
+						partnId, slice.Id(), err)
+					continue
+				}
+				latest := NewSnapshotInfoContainer(infos).GetLatest()
+				if latest != nil && latest.Timestamp().Equal2(ts, false) {
+					continue
+				}
+
+				commitTs := ts.Copy()
+				commitTs.SetSnapType(common.FORCE_COMMIT)
+
+				slice.FlushDone()
+				if _, err := slice.NewSnapshot(commitTs, true); err != nil {
+					storageMgrLog.Errorf("StorageMgr::commitOnShutdown Error committing snapshot of "+
+						"Index: %v PartitionId: %v SliceId: %v. Skipped. Error %v", idxInstId,
+						partnId, slice.Id(), err)
+					continue
+				}
+				numCommitted++
+			}
+		}
+		DestroyIndexSnapshot(is)
+	}
+
+	storageMgrLog.Infof("StorageMgr::commitOnShutdown Committed %v slices in %v. "+
+		"%v slices skipped at the deadline.", numCommitted, time.Since(start), numSkipped)
+}
+
 func (s *storageMgr) handleSupvervisorCommands(cmd Message) {
 
 	switch cmd.GetMsgType() {
@@ -230,6 +368,9 @@ func (s *storageMgr) handleSupvervisorCommands(cmd Message) {
 	case STORAGE_UPDATE_SNAP_MAP:
 		s.handleUpdateIndexSnapMapForIndex(cmd)
 
+	case STORAGE_PURGE_INDEX_SNAPSHOTS:
+		s.handlePurgeIndexSnapshots(cmd)
+
 	case INDEXER_ACTIVE:
 		s.handleRecoveryDone()
 
@@ -244,7 +385,7 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 
 	s.supvCmdch <- &MsgSuccess{}
 
-	logging.Tracef("StorageMgr::handleCreateSnapshot %v", cmd)
+	storageMgrLog.Tracef("StorageMgr::handleCreateSnapshot %v", cmd)
 
 	msgFlushDone := cmd.(*MsgMutMgrFlushDone)
 
@@ -254,7 +395,9 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 	flushWasAborted := msgFlushDone.GetAborted()
 	hasAllSB := msgFlushDone.HasAllSB()
 
-	numVbuckets := s.config["numVbuckets"].Int()
+	// Size snapshot timestamps like the flush timestamp, which has an entry
+	// for each vbucket of the bucket
+	numVbuckets := len(tsVbuuid.Seqnos)
 	snapType := tsVbuuid.GetSnapType()
 	tsVbuuid.Crc64 = common.HashVbuuid(tsVbuuid.Vbuuids)
 
@@ -263,8 +406,9 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 
 	streamKeyspaceIdInstsPerWorker := s.streamKeyspaceIdInstsPerWorker.Get()
 	instsPerWorker := streamKeyspaceIdInstsPerWorker[streamId][keyspaceId]
-	// The num_snapshot_workers config has changed. Re-adjust the
-	// streamKeyspaceIdInstsPerWorker map according to new snapshot workers
+	// The num_snapshot_workers config has changed, or the number of workers
+	// has been tuned. Re-adjust the streamKeyspaceIdInstsPerWorker map
+	// according to new snapshot workers
 	numSnapshotWorkers := s.getNumSnapshotWorkers()
 	if len(instsPerWorker) != numSnapshotWorkers {
 		func() {
@@ -274,13 +418,13 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 			newStreamKeyspaceIdInstsPerWorker := getStreamKeyspaceIdInstsPerWorker(streamKeyspaceIdInstList, numSnapshotWorkers)
 			s.streamKeyspaceIdInstsPerWorker.Set(newStreamKeyspaceIdInstsPerWorker)
 			instsPerWorker = newStreamKeyspaceIdInstsPerWorker[streamId][keyspaceId]
-			logging.Infof("StorageMgr::handleCreateSnapshot Re-adjusting the streamKeyspaceIdInstsPerWorker map to %v workers. "+
+			storageMgrLog.Infof("StorageMgr::handleCreateSnapshot Re-adjusting the streamKeyspaceIdInstsPerWorker map to %v workers. "+
 				"StreamId: %v, keyspaceId: %v", numSnapshotWorkers, streamId, keyspaceId)
 		}()
 	}
 
 	if snapType == common.NO_SNAP || snapType == common.NO_SNAP_OSO {
-		logging.Debugf("StorageMgr::handleCreateSnapshot Skip Snapshot For %v "+
+		storageMgrLog.Debugf("StorageMgr::handleCreateSnapshot Skip Snapshot For %v "+
 			"%v SnapType %v", streamId, keyspaceId, snapType)
 
 		indexInstMap := s.indexInstMap.Get()
@@ -302,8 +446,15 @@ func (s *storageMgr) handleCreateSnapshot(cmd Message) {
 	tsVbuuid_copy := tsVbuuid.Copy()
 	stats := s.stats.Get()
 
+	var maxRecoveryPoints int
+	if snapType == common.DISK_SNAP && s.config["snapshot.atomicKeyspace"].Bool() {
+		maxRecoveryPoints = s.config["snapshot.maxRecoveryPoints"].Int()
+	}
+
+	s.snapshotWorkers.Add(1)
 	go s.createSnapshotWorker(streamId, keyspaceId, tsVbuuid_copy, indexSnapMap,
-		numVbuckets, indexInstMap, indexPartnMap, instIdList, instsPerWorker, stats, flushWasAborted, hasAllSB)
+		numVbuckets, indexInstMap, indexPartnMap, instIdList, instsPerWorker, stats, flushWasAborted, hasAllSB,
+		maxRecoveryPoints)
 
 }
 
@@ -311,7 +462,9 @@ func (s *storageMgr) createSnapshotWorker(streamId common.StreamId, keyspaceId s
 	tsVbuuid *common.TsVbuuid, indexSnapMap IndexSnapMap, numVbuckets int,
 	indexInstMap common.IndexInstMap, indexPartnMap IndexPartnMap,
 	instIdList []common.IndexInstId, instsPerWorker [][]common.IndexInstId,
-	stats *IndexerStats, flushWasAborted bool, hasAllSB bool) {
+	stats *IndexerStats, flushWasAborted bool, hasAllSB bool, maxRecoveryPoints int) {
+
+	defer s.snapshotWorkers.Done()
 
 	startTime := time.Now().UnixNano()
 	var needsCommit bool
@@ -324,6 +477,12 @@ func (s *storageMgr) createSnapshotWorker(streamId common.StreamId, keyspaceId s
 		forceCommit = true
 	}
 
+	//commit all slices of the keyspace at the same timestamp, to record
+	//it as a recovery point
+	if maxRecoveryPoints > 0 {
+		forceCommit = true
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(len(instIdList))
 	for _, instListPerWorker := range instsPerWorker {
@@ -339,6 +498,11 @@ func (s *storageMgr) createSnapshotWorker(streamId common.StreamId, keyspaceId s
 
 	wg.Wait()
 
+	if maxRecoveryPoints > 0 && !flushWasAborted {
+		s.recordRecoveryPoint(streamId, keyspaceId, tsVbuuid, indexInstMap,
+			indexPartnMap, maxRecoveryPoints)
+	}
+
 	keyspaceStats := s.stats.GetKeyspaceStats(streamId, keyspaceId)
 	end := time.Now().UnixNano()
 	if keyspaceStats != nil {
@@ -359,6 +523,126 @@ func (s *storageMgr) createSnapshotWorker(streamId common.StreamId, keyspaceId s
 
 }
 
+// Publish the materialized items count of a partition in stats, if all
+// of its slice snapshots have one.
+func updateMaterializedCount(idxStats *IndexStats, partnId common.PartitionId,
+	sliceSnaps map[SliceId]SliceSnapshot) {
+
+	var count uint64
+	for _, ss := range sliceSnaps {
+		s, ok := ss.(*sliceSnapshot)
+		if !ok || !s.hasItemsCount {
+			return
+		}
+		count += s.itemsCount
+	}
+
+	idxStats.updatePartitionStats(partnId, func(ps *IndexStats) {
+		ps.materializedCount.Set(int64(count))
+	})
+}
+
+// reconcileRollbackStats recomputes the per partition items count of
+// the indexes of keyspaceId in streamId from their rolled back
+// snapshots. Partitions without a snapshot (rollback to zero) are
+// reset to 0. The average item size and array length are derived from
+// these counts and are refreshed along with them.
+func (s *storageMgr) reconcileRollbackStats(streamId common.StreamId, keyspaceId string) {
+
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+
+	stats := s.stats.Get()
+	indexInstMap := s.indexInstMap.Get()
+	indexSnapMap := s.indexSnapMap.Get()
+
+	for idxInstId, partnMap := range s.indexPartnMap.Get() {
+		idxInst, ok := indexInstMap[idxInstId]
+		if !ok || idxInst.Defn.KeyspaceId(idxInst.Stream) != keyspaceId ||
+			idxInst.Stream != streamId ||
+			idxInst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		idxStats := stats.indexes[idxInstId]
+		if idxStats == nil {
+			continue
+		}
+
+		var is IndexSnapshot
+		if snapC, ok := indexSnapMap[idxInstId]; ok {
+			snapC.Lock()
+			is = CloneIndexSnapshot(snapC.snap)
+			snapC.Unlock()
+		}
+
+		counts, err := partitionItemsCounts(is)
+		if err != nil {
+			DestroyIndexSnapshot(is)
+			storageMgrLog.Errorf("StorageMgr::reconcileRollbackStats %v %v Index %v "+
+				"Error counting items %v", streamId, keyspaceId, idxInstId, err)
+			continue
+		}
+
+		for partnId := range partnMap {
+			count := int64(counts[partnId])
+			idxStats.updatePartitionStats(partnId, func(ps *IndexStats) {
+				ps.itemsCount.Set(count)
+				ps.materializedCount.Set(0)
+			})
+			if is == nil {
+				continue
+			}
+			if ps, ok := is.Partitions()[partnId]; ok {
+				sliceSnaps := make(map[SliceId]SliceSnapshot)
+				for _, ss := range ps.Slices() {
+					sliceSnaps[ss.SliceId()] = ss
+				}
+				updateMaterializedCount(idxStats, partnId, sliceSnaps)
+			}
+		}
+		DestroyIndexSnapshot(is)
+
+		itemsCount := idxStats.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.itemsCount.Value()
+		})
+		rawDataSize := idxStats.partnInt64Stats(func(ss *IndexStats) int64 {
+			return ss.rawDataSize.Value()
+		})
+		idxStats.avgItemSize.Set(computeAvgItemSize(rawDataSize, itemsCount))
+		if idxStats.isArrayIndex {
+			docidCount := idxStats.partnInt64Stats(func(ss *IndexStats) int64 {
+				return ss.docidCount.Value()
+			})
+			idxStats.avgArrLenHolder.Set(computeAvgArrayLength(itemsCount, docidCount))
+		}
+
+		storageMgrLog.Infof("StorageMgr::reconcileRollbackStats %v %v Index %v "+
+			"itemsCount %v", streamId, keyspaceId, idxInstId, itemsCount)
+	}
+}
+
+// partitionItemsCounts returns the items count of each partition of is.
+func partitionItemsCounts(is IndexSnapshot) (map[common.PartitionId]uint64, error) {
+
+	counts := make(map[common.PartitionId]uint64)
+	if is == nil {
+		return counts, nil
+	}
+
+	for partnId, ps := range is.Partitions() {
+		for _, ss := range ps.Slices() {
+			c, err := ss.Snapshot().StatCountTotal()
+			if err != nil {
+				return nil, err
+			}
+			counts[partnId] += c
+		}
+	}
+
+	return counts, nil
+}
+
 func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 	keyspaceId string, indexInstMap common.IndexInstMap,
 	indexPartnMap IndexPartnMap, indexSnapMap IndexSnapMap, numVbuckets int,
@@ -390,6 +674,10 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 	// List of snapshots for reading current timestamp
 	var isSnapCreated bool = true
 
+	// The count of a storage snapshot is cheap and exact except for forestdb
+	materializeCount := s.config["scan.materialized_count"].Bool() &&
+		common.GetStorageMode() != common.FORESTDB
+
 	partnSnaps := make(map[common.PartitionId]PartitionSnapshot)
 	hasNewSnapshot := false
 
@@ -422,8 +710,9 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 			}
 
 			var latestSnapshot Snapshot
+			var lastSliceSnap SliceSnapshot
 			if lastPartnSnap != nil {
-				lastSliceSnap := lastPartnSnap.Slices()[slice.Id()]
+				lastSliceSnap = lastPartnSnap.Slices()[slice.Id()]
 				latestSnapshot = lastSliceSnap.Snapshot()
 			}
 
@@ -452,7 +741,7 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 				var info SnapshotInfo
 				var newSnapshot Snapshot
 
-				logging.Tracef("StorageMgr::handleCreateSnapshot Creating New Snapshot "+
+				storageMgrLog.Tracef("StorageMgr::handleCreateSnapshot Creating New Snapshot "+
 					"Index: %v PartitionId: %v SliceId: %v Commit: %v Force: %v", idxInstId,
 					partnId, slice.Id(), needsCommit, forceCommit)
 
@@ -464,7 +753,7 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 
 				snapCreateStart := time.Now()
 				if info, err = slice.NewSnapshot(newTsVbuuid, needsCommit); err != nil {
-					logging.Errorf("handleCreateSnapshot::handleCreateSnapshot Error "+
+					storageMgrLog.Errorf("handleCreateSnapshot::handleCreateSnapshot Error "+
 						"Creating new snapshot Slice Index: %v Slice: %v. Skipped. Error %v", idxInstId,
 						slice.Id(), err)
 					isSnapCreated = false
@@ -477,7 +766,7 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 
 				snapOpenStart := time.Now()
 				if newSnapshot, err = slice.OpenSnapshot(info); err != nil {
-					logging.Errorf("StorageMgr::handleCreateSnapshot Error Creating Snapshot "+
+					storageMgrLog.Errorf("StorageMgr::handleCreateSnapshot Error Creating Snapshot "+
 						"for Index: %v Slice: %v. Skipped. Error %v", idxInstId,
 						slice.Id(), err)
 					isSnapCreated = false
@@ -487,7 +776,7 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 				snapOpenDur := time.Since(snapOpenStart)
 
 				if needsCommit {
-					logging.Infof("StorageMgr::handleCreateSnapshot Added New Snapshot Index: %v "+
+					storageMgrLog.Infof("StorageMgr::handleCreateSnapshot Added New Snapshot Index: %v "+
 						"PartitionId: %v SliceId: %v Crc64: %v (%v) SnapType %v SnapAligned %v "+
 						"SnapCreateDur %v SnapOpenDur %v", idxInstId, partnId, slice.Id(),
 						tsVbuuid.Crc64, info, tsVbuuid.GetSnapType(), tsVbuuid.IsSnapAligned(),
@@ -497,6 +786,11 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 					id:   slice.Id(),
 					snap: newSnapshot,
 				}
+				if materializeCount {
+					if c, err := newSnapshot.CountTotal(nil, nil); err == nil {
+						ss.itemsCount, ss.hasItemsCount = c, true
+					}
+				}
 				sliceSnaps[slice.Id()] = ss
 			} else {
 				// Increment reference
@@ -505,12 +799,15 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 					id:   slice.Id(),
 					snap: latestSnapshot,
 				}
+				if last, ok := lastSliceSnap.(*sliceSnapshot); ok && materializeCount {
+					ss.itemsCount, ss.hasItemsCount = last.itemsCount, last.hasItemsCount
+				}
 				sliceSnaps[slice.Id()] = ss
 
-				if logging.IsEnabled(logging.Debug) {
-					logging.Debugf("StorageMgr::handleCreateSnapshot Skipped Creating New Snapshot for Index %v "+
+				if storageMgrLog.IsEnabled(logging.Debug) {
+					storageMgrLog.Debugf("StorageMgr::handleCreateSnapshot Skipped Creating New Snapshot for Index %v "+
 						"PartitionId %v SliceId %v. No New Mutations. IsDirty %v", idxInstId, partnId, slice.Id(), slice.IsDirty())
-					logging.Debugf("StorageMgr::handleCreateSnapshot SnapTs %v FlushTs %v", snapTs, ts)
+					storageMgrLog.Debugf("StorageMgr::handleCreateSnapshot SnapTs %v FlushTs %v", snapTs, ts)
 				}
 				continue
 			}
@@ -521,6 +818,10 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 			slices: sliceSnaps,
 		}
 		partnSnaps[partnId] = ps
+
+		if materializeCount {
+			updateMaterializedCount(idxStats, partnId, sliceSnaps)
+		}
 	}
 
 	if hasNewSnapshot {
@@ -541,6 +842,7 @@ func (s *storageMgr) createSnapshotForIndex(streamId common.StreamId,
 	}
 
 	if isSnapCreated {
+		idxStats.openSnapshots.track(is, idxStats.writtenBytes(), time.Now())
 		s.updateSnapMapAndNotify(is, idxStats)
 	} else {
 		DestroyIndexSnapshot(is)
@@ -585,8 +887,9 @@ func (s *storageMgr) flushDone(streamId common.StreamId, keyspaceId string,
 
 	if common.GetStorageMode() == common.PLASMA {
 
-		if s.config["plasma.writer.tuning.enable"].Bool() &&
-			time.Now().UnixNano()-s.lastFlushDone > checkInterval() {
+		// writer tuning can be enabled for some indexes only, and the slices
+		// no longer tuned restore their writers, so all slices are notified
+		if time.Now().UnixNano()-s.lastFlushDone > checkInterval() {
 
 			var wg sync.WaitGroup
 
@@ -726,7 +1029,7 @@ func (s *storageMgr) updateSnapMapAndNotify(is IndexSnapshot, idxStats *IndexSta
 			continue
 		}
 
-		if isSnapshotConsistent(is, w.cons, w.ts) {
+		if isSnapshotConsistentVbnos(is, w.cons, w.ts, w.vbnos) {
 			w.Notify(CloneIndexSnapshot(is))
 			numReplies++
 			idxStats.numSnapshotWaiters.Add(-1)
@@ -738,6 +1041,66 @@ func (s *storageMgr) updateSnapMapAndNotify(is IndexSnapshot, idxStats *IndexSta
 	idxStats.numLastSnapshotReply.Set(numReplies)
 }
 
+// reapSnapshotWaiters periodically fails the snapshot waiters past their
+// expiry. Waiters are otherwise only expired when a new snapshot of their
+// index is created, so they would pile up while flushes are stalled.
+func (s *storageMgr) reapSnapshotWaiters() {
+	ticker := time.NewTicker(snapshotWaiterReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.expireSnapshotWaiters(time.Now())
+		case <-s.reaperStopCh:
+			return
+		}
+	}
+}
+
+// expireSnapshotWaiters fails with ErrScanTimedOut the snapshot waiters that
+// have expired by now. It returns the number of waiters expired.
+func (s *storageMgr) expireSnapshotWaiters(now time.Time) int {
+	stats := s.stats.Get()
+
+	var numExpired int
+	for instId, wc := range s.waitersMap.Get() {
+		var idxStats *IndexStats
+		if stats != nil {
+			idxStats = stats.indexes[instId]
+		}
+
+		n := func() int {
+			wc.Lock()
+			defer wc.Unlock()
+
+			var n int
+			var waiters []*snapshotWaiter
+			for _, w := range wc.waiters {
+				if !w.expired.IsZero() && now.After(w.expired) {
+					w.Error(common.ErrScanTimedOut)
+					if idxStats != nil {
+						idxStats.numSnapshotWaiters.Add(-1)
+					}
+					n++
+					continue
+				}
+				waiters = append(waiters, w)
+			}
+			if n != 0 {
+				wc.waiters = waiters
+			}
+			return n
+		}()
+
+		if n != 0 {
+			storageMgrLog.Infof("StorageMgr::expireSnapshotWaiters Expired %v snapshot waiters of inst %v", n, instId)
+			numExpired += n
+		}
+	}
+	return numExpired
+}
+
 func (sm *storageMgr) getSortedPartnInst(partnMap PartitionInstMap) partitionInstList {
 
 	if len(partnMap) == 0 {
@@ -770,12 +1133,37 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 	keyspaceId := cmd.(*MsgRollback).GetKeyspaceId()
 	sessionId := cmd.(*MsgRollback).GetSessionId()
 
-	logging.Infof("StorageMgr::handleRollback %v %v rollbackTs %v", streamId, keyspaceId, rollbackTs)
+	storageMgrLog.Infof("StorageMgr::handleRollback %v %v rollbackTs %v", streamId, keyspaceId, rollbackTs)
 
 	var err error
 	var restartTs *common.TsVbuuid
 	var rollbackToZero bool
 
+	atomicKeyspace := sm.config["snapshot.atomicKeyspace"].Bool()
+
+	var recoveryPoint *common.TsVbuuid
+	var recoverySnaps map[recoverySliceKey]SnapshotInfo
+	if atomicKeyspace {
+		slices := sm.keyspaceSlices(streamId, keyspaceId, sm.indexInstMap.Get(), sm.indexPartnMap.Get())
+		recoveryPoint, recoverySnaps = sm.recoveryPoints.find(streamId, keyspaceId, rollbackTs, slices)
+		if recoveryPoint != nil {
+			storageMgrLog.Infof("StorageMgr::handleRollback %v %v Using recovery point %v",
+				streamId, keyspaceId, recoveryPoint)
+		} else {
+			storageMgrLog.Infof("StorageMgr::handleRollback %v %v No recovery point found",
+				streamId, keyspaceId)
+		}
+	}
+
+	var rebuildVbs map[Vbucket]bool
+	if recoveryPoint == nil {
+		rebuildVbs = sm.findRebuildVbuckets(streamId, keyspaceId, rollbackTs)
+	}
+	if rebuildVbs != nil {
+		storageMgrLog.Infof("StorageMgr::handleRollback %v %v Rebuilding vbuckets %v",
+			streamId, keyspaceId, sortedVbuckets(rebuildVbs))
+	}
+
 	indexInstMap := sm.indexInstMap.Get()
 	indexPartnMap := sm.indexPartnMap.Get()
 	//for every index managed by this indexer
@@ -787,8 +1175,8 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 			idxInst.Stream == streamId &&
 			idxInst.State != common.INDEX_STATE_DELETED {
 
-			restartTs, err = sm.rollbackIndex(streamId,
-				keyspaceId, rollbackTs, idxInstId, partnMap, restartTs)
+			restartTs, err = sm.rollbackIndex(streamId, keyspaceId, rollbackTs,
+				idxInstId, partnMap, restartTs, rebuildVbs, recoverySnaps)
 
 			if err != nil {
 				sm.supvRespch <- &MsgRollbackDone{streamId: streamId,
@@ -838,18 +1226,43 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 
 	sm.updateIndexSnapMap(sm.indexPartnMap.Get(), streamId, keyspaceId)
 
+	//item counts are stale until the next stats request, reconcile
+	//them from the rolled back snapshots once the rollback is done
+	go sm.reconcileRollbackStats(streamId, keyspaceId)
+
+	//recovery points more recent than the rollback are gone
+	if atomicKeyspace {
+		if err := sm.recoveryPoints.truncate(streamId, keyspaceId, restartTs); err != nil {
+			storageMgrLog.Errorf("StorageMgr::handleRollback %v %v Error persisting "+
+				"recovery points: %v", streamId, keyspaceId, err)
+		}
+	}
+
 	keyspaceStats := sm.stats.GetKeyspaceStats(streamId, keyspaceId)
 	if keyspaceStats != nil {
 		keyspaceStats.numRollbacks.Add(1)
 		if rollbackToZero {
 			keyspaceStats.numRollbacksToZero.Add(1)
+		} else if rebuildVbs != nil {
+			keyspaceStats.numPartialRollbacks.Add(1)
+		}
+	}
+
+	if restartTs != nil && rebuildVbs != nil {
+		//restart the rebuilt vbuckets from zero, as their entries
+		//have been deleted
+		restartTs = restartTs.Copy()
+		for vb := range rebuildVbs {
+			restartTs.Seqnos[vb] = 0
+			restartTs.Vbuuids[vb] = 0
+			restartTs.Snapshots[vb] = [2]uint64{0, 0}
 		}
 	}
 
 	if restartTs != nil {
 		//for pre 7.0 index snapshots, the manifestUID needs to be set to epoch
 		restartTs.SetEpochManifestUIDIfEmpty()
-		restartTs = sm.validateRestartTsVbuuid(keyspaceId, restartTs)
+		restartTs = sm.validateRestartTs(keyspaceId, restartTs)
 	}
 
 	sm.supvRespch <- &MsgRollbackDone{streamId: streamId,
@@ -861,11 +1274,15 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 
 func (sm *storageMgr) rollbackIndex(streamId common.StreamId, keyspaceId string,
 	rollbackTs *common.TsVbuuid, idxInstId common.IndexInstId,
-	partnMap PartitionInstMap, minRestartTs *common.TsVbuuid) (*common.TsVbuuid, error) {
+	partnMap PartitionInstMap, minRestartTs *common.TsVbuuid,
+	rebuildVbs map[Vbucket]bool,
+	recoverySnaps map[recoverySliceKey]SnapshotInfo) (*common.TsVbuuid, error) {
 
 	var restartTs *common.TsVbuuid
 	var err error
 
+	isPrimary := sm.indexInstMap.Get()[idxInstId].Defn.IsPrimary
+
 	var markAsUsed bool
 	if rollbackTs.HasZeroSeqNum() {
 		markAsUsed = true
@@ -878,7 +1295,20 @@ func (sm *storageMgr) rollbackIndex(streamId common.StreamId, keyspaceId string,
 		sc := partnInst.Sc
 
 		for _, slice := range sc.GetAllSlices() {
-			snapInfo := sm.findRollbackSnapshot(slice, rollbackTs)
+			var snapInfo SnapshotInfo
+			if recoverySnaps != nil {
+				snapInfo = recoverySnaps[recoverySliceKey{idxInstId, partnId, slice.Id()}]
+			} else {
+				snapInfo = sm.findRollbackSnapshot(slice, rollbackTs)
+			}
+			if snapInfo == nil && rebuildVbs != nil {
+				infos, err := slice.GetSnapshots()
+				if err != nil {
+					return nil, err
+				}
+				snapInfo = findRebuildSnapshot(NewSnapshotInfoContainer(infos).List(),
+					rollbackTs, rebuildVbs)
+			}
 
 			restartTs, err = sm.rollbackToSnapshot(idxInstId, partnId,
 				slice, snapInfo, markAsUsed)
@@ -891,6 +1321,18 @@ func (sm *storageMgr) rollbackIndex(streamId common.StreamId, keyspaceId string,
 				return nil, nil
 			}
 
+			//the rebuilt vbuckets restart from zero for all slices
+			if rebuildVbs != nil {
+				numDocs, err := sm.purgeVbuckets(slice, snapInfo, isPrimary,
+					keyspaceId, rebuildVbs)
+				if err != nil {
+					return nil, err
+				}
+				storageMgrLog.Infof("StorageMgr::handleRollback Rollback Index: %v "+
+					"PartitionId: %v SliceId: %v Deleted %v docs of rebuilt vbuckets",
+					idxInstId, partnId, slice.Id(), numDocs)
+			}
+
 			//if restartTs is lower than the minimum, use that
 			if !restartTs.AsRecentTs(minRestartTs) {
 				minRestartTs = restartTs
@@ -926,7 +1368,7 @@ func (sm *storageMgr) findRollbackSnapshot(slice Slice,
 		latestSnapInfo := s.GetLatest()
 
 		if latestSnapInfo == nil || lastRollbackTs == nil {
-			logging.Infof("StorageMgr::handleRollback %v latestSnapInfo %v "+
+			storageMgrLog.Infof("StorageMgr::handleRollback %v latestSnapInfo %v "+
 				"lastRollbackTs %v. Use latest snapshot.", slice.IndexInstId(), latestSnapInfo,
 				lastRollbackTs)
 			snapInfo = latestSnapInfo
@@ -937,10 +1379,10 @@ func (sm *storageMgr) findRollbackSnapshot(slice Slice,
 					//if there are more snapshots, use the next one
 					if len(slist) >= i+2 {
 						snapInfo = slist[i+1]
-						logging.Infof("StorageMgr::handleRollback %v Discarding Already Used "+
+						storageMgrLog.Infof("StorageMgr::handleRollback %v Discarding Already Used "+
 							"Snapshot %v. Using Next snapshot %v", slice.IndexInstId(), si, snapInfo)
 					} else {
-						logging.Infof("StorageMgr::handleRollback %v Unable to find a snapshot "+
+						storageMgrLog.Infof("StorageMgr::handleRollback %v Unable to find a snapshot "+
 							"older than last used Snapshot %v. Use nil snapshot.", slice.IndexInstId(),
 							latestSnapInfo)
 						snapInfo = nil
@@ -949,7 +1391,7 @@ func (sm *storageMgr) findRollbackSnapshot(slice Slice,
 				} else {
 					//if lastRollbackTs is set(i.e. MTR after rollback wasn't completely successful)
 					//use only snapshots lower than lastRollbackTs
-					logging.Infof("StorageMgr::handleRollback %v Discarding Snapshot %v. Need older "+
+					storageMgrLog.Infof("StorageMgr::handleRollback %v Discarding Snapshot %v. Need older "+
 						"than last used snapshot %v.", slice.IndexInstId(), si, lastRollbackTs)
 				}
 			}
@@ -970,7 +1412,7 @@ func (sm *storageMgr) rollbackToSnapshot(idxInstId common.IndexInstId,
 	if snapInfo != nil {
 		err := slice.Rollback(snapInfo)
 		if err == nil {
-			logging.Infof("StorageMgr::handleRollback Rollback Index: %v "+
+			storageMgrLog.Infof("StorageMgr::handleRollback Rollback Index: %v "+
 				"PartitionId: %v SliceId: %v To Snapshot %v ", idxInstId, partnId,
 				slice.Id(), snapInfo)
 			restartTs = snapInfo.Timestamp()
@@ -986,7 +1428,7 @@ func (sm *storageMgr) rollbackToSnapshot(idxInstId common.IndexInstId,
 		//if there is no snapshot available, rollback to zero
 		err := slice.RollbackToZero()
 		if err == nil {
-			logging.Infof("StorageMgr::handleRollback Rollback Index: %v "+
+			storageMgrLog.Infof("StorageMgr::handleRollback Rollback Index: %v "+
 				"PartitionId: %v SliceId: %v To Zero ", idxInstId, partnId,
 				slice.Id())
 			//once rollback to zero has happened, set response ts to nil
@@ -1004,7 +1446,7 @@ func (sm *storageMgr) rollbackToSnapshot(idxInstId common.IndexInstId,
 func (sm *storageMgr) rollbackAllToZero(streamId common.StreamId,
 	keyspaceId string) error {
 
-	logging.Infof("StorageMgr::rollbackAllToZero %v %v", streamId, keyspaceId)
+	storageMgrLog.Infof("StorageMgr::rollbackAllToZero %v %v", streamId, keyspaceId)
 
 	indexPartnMap := sm.indexPartnMap.Get()
 	indexInstMap := sm.indexInstMap.Get()
@@ -1038,9 +1480,9 @@ func (sm *storageMgr) validateRestartTsVbuuid(keyspaceId string,
 	restartTs *common.TsVbuuid) *common.TsVbuuid {
 
 	clusterAddr := sm.config["clusterAddr"].String()
-	numVbuckets := sm.config["numVbuckets"].Int()
 
 	bucket, _, _ := SplitKeyspaceId(keyspaceId)
+	numVbuckets := getNumVBuckets(bucket, sm.config)
 
 	for i := 0; i < MAX_GETSEQS_RETRIES; i++ {
 
@@ -1048,7 +1490,7 @@ func (sm *storageMgr) validateRestartTsVbuuid(keyspaceId string,
 			bucket, numVbuckets)
 
 		if err != nil {
-			logging.Warnf("StorageMgr::validateRestartTsVbuuid Bucket %v. "+
+			storageMgrLog.Warnf("StorageMgr::validateRestartTsVbuuid Bucket %v. "+
 				"Error fetching failover log %v. Retrying(%v).", bucket, err, i+1)
 			time.Sleep(time.Second)
 			continue
@@ -1062,7 +1504,7 @@ func (sm *storageMgr) validateRestartTsVbuuid(keyspaceId string,
 				lowest, err := flog.LowestVbuuid(i, seq)
 				if err == nil && lowest != 0 &&
 					lowest != restartTs.Vbuuids[i] {
-					logging.Infof("StorageMgr::validateRestartTsVbuuid Updating Bucket %v "+
+					storageMgrLog.Infof("StorageMgr::validateRestartTsVbuuid Updating Bucket %v "+
 						"Vb %v Seqno %v Vbuuid From %v To %v. Flog %v", bucket, i, seq,
 						restartTs.Vbuuids[i], lowest, flog[i])
 					restartTs.Vbuuids[i] = lowest
@@ -1091,7 +1533,7 @@ func (s *storageMgr) initSnapshotContainerForInst(instId common.IndexInstId, is
 		stats := s.stats.Get()
 		idxStats := stats.indexes[instId]
 		if is == nil {
-			ts := common.NewTsVbuuid(bucket, s.config["numVbuckets"].Int())
+			ts := common.NewTsVbuuid(bucket, getNumVBuckets(bucket, s.config))
 			snap = &indexSnapshot{
 				instId: instId,
 				ts:     ts, // nil snapshot should have ZERO Crc64 :)
@@ -1105,7 +1547,7 @@ func (s *storageMgr) initSnapshotContainerForInst(instId common.IndexInstId, is
 			snap = is
 		}
 		indexSnapMap = s.indexSnapMap.Clone()
-		logging.Infof("StorageMgr::updateIndexSnapMapForIndex, New IndexSnapshotContainer is being created "+
+		storageMgrLog.Infof("StorageMgr::updateIndexSnapMapForIndex, New IndexSnapshotContainer is being created "+
 			"for indexInst: %v, creation time: %v, caller: %v", instId, creationTime, caller)
 		sc := &IndexSnapshotContainer{snap: snap, creationTime: creationTime}
 		indexSnapMap[instId] = sc
@@ -1139,7 +1581,7 @@ func (s *storageMgr) addNilSnapshot(idxInstId common.IndexInstId, bucket string,
 	indexSnapMap := s.indexSnapMap.Get()
 	if _, ok := indexSnapMap[idxInstId]; !ok {
 		indexSnapMap := s.indexSnapMap.Clone()
-		ts := common.NewTsVbuuid(bucket, s.config["numVbuckets"].Int())
+		ts := common.NewTsVbuuid(bucket, getNumVBuckets(bucket, s.config))
 		stats := s.stats.Get()
 		idxStats := stats.indexes[idxInstId]
 		creationTime := uint64(time.Now().UnixNano())
@@ -1153,7 +1595,7 @@ func (s *storageMgr) addNilSnapshot(idxInstId common.IndexInstId, bucket string,
 			creationTime: creationTime,
 		}
 
-		logging.Infof("StorageMgr::updateIndexSnapMapForIndex, New IndexSnapshotContainer is being created "+
+		storageMgrLog.Infof("StorageMgr::updateIndexSnapMapForIndex, New IndexSnapshotContainer is being created "+
 			"for indexInst: %v, creation time: %v, caller: %v", idxInstId, creationTime, caller)
 		indexSnapMap[idxInstId] = &IndexSnapshotContainer{snap: snap, creationTime: creationTime}
 		s.indexSnapMap.Set(indexSnapMap)
@@ -1164,7 +1606,7 @@ func (s *storageMgr) addNilSnapshot(idxInstId common.IndexInstId, bucket string,
 func (s *storageMgr) notifySnapshotDeletion(instId common.IndexInstId) {
 	defer func() {
 		if r := recover(); r != nil {
-			logging.Errorf("storageMgr::notifySnapshot %v", r)
+			storageMgrLog.Errorf("storageMgr::notifySnapshot %v", r)
 		}
 	}()
 
@@ -1179,7 +1621,7 @@ func (s *storageMgr) notifySnapshotDeletion(instId common.IndexInstId) {
 func (s *storageMgr) notifySnapshotCreation(is IndexSnapshot) {
 	defer func() {
 		if r := recover(); r != nil {
-			logging.Errorf("storageMgr::notifySnapshot %v", r)
+			storageMgrLog.Errorf("storageMgr::notifySnapshot %v", r)
 		}
 	}()
 
@@ -1187,82 +1629,155 @@ func (s *storageMgr) notifySnapshotCreation(is IndexSnapshot) {
 	s.snapshotNotifych[index] <- CloneIndexSnapshot(is)
 }
 
+// handlePurgeIndexSnapshots destroys the snapshots of the given instances
+// and notifies their deletion to the scan coordinator, without waiting for
+// the updated index instance map. Used to release the index data of a
+// dropped collection as early as possible.
+func (s *storageMgr) handlePurgeIndexSnapshots(cmd Message) {
+
+	storageMgrLog.Infof("StorageMgr::handlePurgeIndexSnapshots %v", cmd)
+	instIds := cmd.(*MsgPurgeIndexes).GetInstIds()
+
+	s.muSnap.Lock()
+	defer s.muSnap.Unlock()
+
+	indexSnapMap := s.indexSnapMap.Clone()
+	for _, instId := range instIds {
+		snapC, ok := indexSnapMap[instId]
+		if !ok {
+			continue
+		}
+
+		snapC.Lock()
+		DestroyIndexSnapshot(snapC.snap)
+		delete(indexSnapMap, instId)
+		//set sc.deleted to true to indicate to concurrent readers
+		//that this snap container should no longer be used
+		snapC.deleted = true
+
+		s.notifySnapshotDeletion(instId)
+		snapC.Unlock()
+	}
+	s.indexSnapMap.Set(indexSnapMap)
+
+	s.supvCmdch <- &MsgSuccess{}
+}
+
 func (s *storageMgr) handleUpdateIndexInstMap(cmd Message) {
 
-	logging.Tracef("StorageMgr::handleUpdateIndexInstMap %v", cmd)
+	storageMgrLog.Tracef("StorageMgr::handleUpdateIndexInstMap %v", cmd)
 	req := cmd.(*MsgUpdateInstMap)
-	indexInstMap := req.GetIndexInstMap()
-	copyIndexInstMap := common.CopyIndexInstMap(indexInstMap)
 	s.stats.Set(req.GetStatsObject())
-	s.indexInstMap.Set(copyIndexInstMap)
+
+	// Apply only the changed instances if the message is a delta of the
+	// current map, else copy the whole map
+	oldInstMap := s.indexInstMap.Get()
+	var indexInstMap common.IndexInstMap
+	var changed []common.IndexInstId
+	delta := req.CanApplyDelta(s.instMapVersion)
+	if delta {
+		indexInstMap, changed = applyIndexInstMapDelta(oldInstMap,
+			req.GetUpdatedInsts(), req.GetDeletedInstIds())
+	} else {
+		indexInstMap = common.CopyIndexInstMap(req.GetIndexInstMap())
+	}
+	s.instMapVersion = req.GetVersion()
+	s.indexInstMap.Set(indexInstMap)
 
 	s.muSnap.Lock()
 	defer s.muSnap.Unlock()
 
-	indexInstMap = s.indexInstMap.Get()
 	waitersMap := s.waitersMap.Clone()
 	indexSnapMap := s.indexSnapMap.Clone()
 
-	streamKeyspaceIdInstList := getStreamKeyspaceIdInstListFromInstMap(indexInstMap)
-	s.streamKeyspaceIdInstList.Set(streamKeyspaceIdInstList)
+	if !delta {
+		// Any instance may have been added, changed or removed
+		for instId := range indexInstMap {
+			changed = append(changed, instId)
+		}
+		for instId := range waitersMap {
+			if _, ok := indexInstMap[instId]; !ok {
+				changed = append(changed, instId)
+			}
+		}
+		for instId := range indexSnapMap {
+			if _, ok := indexInstMap[instId]; !ok {
+				if _, ok := waitersMap[instId]; !ok {
+					changed = append(changed, instId)
+				}
+			}
+		}
+	}
 
-	streamKeyspaceIdInstsPerWorker := getStreamKeyspaceIdInstsPerWorker(streamKeyspaceIdInstList, s.getNumSnapshotWorkers())
-	s.streamKeyspaceIdInstsPerWorker.Set(streamKeyspaceIdInstsPerWorker)
+	if !delta || streamKeyspaceIdsChanged(oldInstMap, indexInstMap, changed) {
+		streamKeyspaceIdInstList := getStreamKeyspaceIdInstListFromInstMap(indexInstMap)
+		s.streamKeyspaceIdInstList.Set(streamKeyspaceIdInstList)
 
-	// Initialize waitersContainer for newly created instances
-	for instId, inst := range indexInstMap {
-		if _, ok := waitersMap[instId]; !ok && inst.State != common.INDEX_STATE_DELETED {
-			waitersMap[instId] = &SnapshotWaitersContainer{}
-		}
+		streamKeyspaceIdInstsPerWorker := getStreamKeyspaceIdInstsPerWorker(streamKeyspaceIdInstList, s.getNumSnapshotWorkers())
+		s.streamKeyspaceIdInstsPerWorker.Set(streamKeyspaceIdInstsPerWorker)
 	}
 
-	// Remove all snapshot waiters for indexes that do not exist anymore
-	for id, wc := range waitersMap {
-		if inst, ok := indexInstMap[id]; !ok || inst.State == common.INDEX_STATE_DELETED {
+	for _, instId := range changed {
+		// Initialize waitersContainer for newly created instances
+		if inst, ok := indexInstMap[instId]; ok && inst.State != common.INDEX_STATE_DELETED {
+			if _, ok := waitersMap[instId]; !ok {
+				waitersMap[instId] = &SnapshotWaitersContainer{}
+			}
+			continue
+		}
+
+		// Remove all snapshot waiters for indexes that do not exist anymore
+		if wc, ok := waitersMap[instId]; ok {
 			wc.Lock()
 			for _, w := range wc.waiters {
 				w.Error(common.ErrIndexNotFound)
 			}
 			wc.waiters = nil
-			delete(waitersMap, id)
+			delete(waitersMap, instId)
 			wc.Unlock()
 		}
-	}
 
-	// Cleanup all invalid index's snapshots
-	for idxInstId, snapC := range indexSnapMap {
-		if inst, ok := indexInstMap[idxInstId]; !ok || inst.State == common.INDEX_STATE_DELETED {
+		// Cleanup all invalid index's snapshots
+		if snapC, ok := indexSnapMap[instId]; ok {
 			snapC.Lock()
 			is := snapC.snap
 			DestroyIndexSnapshot(is)
-			delete(indexSnapMap, idxInstId)
+			delete(indexSnapMap, instId)
 			//set sc.deleted to true to indicate to concurrent readers
 			//that this snap container should no longer be used
 			snapC.deleted = true
 
-			s.notifySnapshotDeletion(idxInstId)
+			s.notifySnapshotDeletion(instId)
 			snapC.Unlock()
 		}
 	}
 
 	s.indexSnapMap.Set(indexSnapMap)
 	// Add 0 items index snapshots for newly added indexes
-	for idxInstId, inst := range indexInstMap {
-		if inst.State != common.INDEX_STATE_DELETED {
-			s.addNilSnapshot(idxInstId, inst.Defn.Bucket, "handleUpdateIndexInstMap")
+	for _, instId := range changed {
+		if inst, ok := indexInstMap[instId]; ok && inst.State != common.INDEX_STATE_DELETED {
+			s.addNilSnapshot(instId, inst.Defn.Bucket, "handleUpdateIndexInstMap")
 		}
 	}
 
 	//if manager is not enable, store the updated InstMap in
-	//meta file
+	//meta file. The whole map is stored on each update, deltas
+	//are only applied to the copy kept for it.
 	if s.config["enableManager"].Bool() == false {
 
-		instMap := indexInstMap
-
-		for id, inst := range instMap {
-			inst.Pc = nil
-			instMap[id] = inst
+		//the instances are stored without their partition containers
+		if !delta || s.metaInstMap == nil {
+			s.metaInstMap = make(common.IndexInstMap, len(indexInstMap))
+		}
+		for _, instId := range changed {
+			if inst, ok := indexInstMap[instId]; ok {
+				inst.Pc = nil
+				s.metaInstMap[instId] = inst
+			} else {
+				delete(s.metaInstMap, instId)
+			}
 		}
+		instMap := s.metaInstMap
 
 		//store indexInstMap in metadata store
 		var instBytes bytes.Buffer
@@ -1271,12 +1786,23 @@ func (s *storageMgr) handleUpdateIndexInstMap(cmd Message) {
 		enc := gob.NewEncoder(&instBytes)
 		err = enc.Encode(instMap)
 		if err != nil {
-			logging.Errorf("StorageMgr::handleUpdateIndexInstMap \n\t Error Marshalling "+
+			storageMgrLog.Errorf("StorageMgr::handleUpdateIndexInstMap \n\t Error Marshalling "+
 				"IndexInstMap %v. Err %v", instMap, err)
 		}
 
-		if err = s.meta.SetKV([]byte(INST_MAP_KEY_NAME), instBytes.Bytes()); err != nil {
-			logging.Errorf("StorageMgr::handleUpdateIndexInstMap \n\tError "+
+		data, err := encryptMetaIfEnabled(s.config, instBytes.Bytes())
+		if err != nil {
+			storageMgrLog.Errorf("StorageMgr::handleUpdateIndexInstMap \n\tError "+
+				"Encrypting IndexInstMap %v", err)
+			s.supvCmdch <- &MsgError{
+				err: Error{category: STORAGE_MGR,
+					severity: FATAL,
+					cause:    err}}
+			return
+		}
+
+		if err = s.meta.SetKV([]byte(INST_MAP_KEY_NAME), data); err != nil {
+			storageMgrLog.Errorf("StorageMgr::handleUpdateIndexInstMap \n\tError "+
 				"Storing IndexInstMap %v", err)
 		}
 
@@ -1288,7 +1814,7 @@ func (s *storageMgr) handleUpdateIndexInstMap(cmd Message) {
 
 func (s *storageMgr) handleUpdateIndexPartnMap(cmd Message) {
 
-	logging.Tracef("StorageMgr::handleUpdateIndexPartnMap %v", cmd)
+	storageMgrLog.Tracef("StorageMgr::handleUpdateIndexPartnMap %v", cmd)
 	indexPartnMap := cmd.(*MsgUpdatePartnMap).GetIndexPartnMap()
 	copyIndexPartnMap := CopyIndexPartnMap(indexPartnMap)
 	s.indexPartnMap.Set(copyIndexPartnMap)
@@ -1298,7 +1824,7 @@ func (s *storageMgr) handleUpdateIndexPartnMap(cmd Message) {
 
 // handleUpdateKeyspaceStatsMap atomically swaps in the pointer to a new KeyspaceStatsMap.
 func (s *storageMgr) handleUpdateKeyspaceStatsMap(cmd Message) {
-	logging.Tracef("StorageMgr::handleUpdateKeyspaceStatsMap %v", cmd)
+	storageMgrLog.Tracef("StorageMgr::handleUpdateKeyspaceStatsMap %v", cmd)
 	req := cmd.(*MsgUpdateKeyspaceStatsMap)
 	stats := s.stats.Get()
 	if stats != nil {
@@ -1316,82 +1842,118 @@ func (s *storageMgr) handleUpdateKeyspaceStatsMap(cmd Message) {
 // available.
 func (s *storageMgr) handleGetIndexSnapshot(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
-	instId := cmd.(*MsgIndexSnapRequest).GetIndexId()
-	index := uint64(instId) % uint64(len(s.snapshotReqCh))
-	s.snapshotReqCh[int(index)] <- cmd
+	s.snapshotReqs.send(cmd.(*MsgIndexSnapRequest))
 }
 
-func (s *storageMgr) listenSnapshotReqs(index int) {
-	for cmd := range s.snapshotReqCh[index] {
-		func() {
-			req := cmd.(*MsgIndexSnapRequest)
-			inst, found := s.indexInstMap.Get()[req.GetIndexId()]
-			if !found || inst.State == common.INDEX_STATE_DELETED {
-				req.respch <- common.ErrIndexNotFound
+// listenSnapshotReqs serves the snapshot requests of a worker. The requests
+// queued on reqCh are served by deadline, see snapshotReqQueue.
+func (s *storageMgr) listenSnapshotReqs(reqCh MsgChannel) {
+	queue := newSnapshotReqQueue(snapshotReqMaxWait)
+	closed := false
+
+	for !closed || queue.len() != 0 {
+		if queue.len() == 0 {
+			cmd, ok := <-reqCh
+			if !ok {
 				return
 			}
+			queue.push(cmd.(*MsgIndexSnapRequest), time.Now())
+		}
 
-			stats := s.stats.Get()
-			idxStats := stats.indexes[req.GetIndexId()]
-
-			// Return snapshot immediately if a matching snapshot exists already
-			// Else add into waiters list so that next snapshot creation event
-			// can notify the requester when a snapshot with matching timestamp
-			// is available.
-			snapC := s.indexSnapMap.Get()[req.GetIndexId()]
-			if snapC == nil {
-				func() {
-					s.muSnap.Lock()
-					defer s.muSnap.Unlock()
-					snapC, _ = s.initSnapshotContainerForInst(req.GetIndexId(), nil, "listenSnapshotReqs")
-				}()
-				if snapC == nil {
-					req.respch <- common.ErrIndexNotFound
-					return
+		// Take the requests already queued on the channel, so that they
+		// are ordered with the others
+	drain:
+		for i := 0; !closed && i < snapshotReqChSize; i++ {
+			select {
+			case cmd, ok := <-reqCh:
+				if !ok {
+					closed = true
+					break drain
 				}
+				queue.push(cmd.(*MsgIndexSnapRequest), time.Now())
+			default:
+				break drain
 			}
+		}
 
-			snapC.Lock()
-			//snapC.deleted indicates that the snapshot container belongs to a deleted
-			//index and it should no longer be used.
-			if snapC.deleted {
-				req.respch <- common.ErrIndexNotFound
-				snapC.Unlock()
-				return
-			}
-			if isSnapshotConsistent(snapC.snap, req.GetConsistency(), req.GetTS()) {
-				req.respch <- CloneIndexSnapshot(snapC.snap)
-				snapC.Unlock()
-				return
-			}
-			snapC.Unlock()
+		now := time.Now()
+		req := queue.pop(now)
+		if expired := req.GetExpiredTime(); !expired.IsZero() && now.After(expired) {
+			req.respch <- common.ErrScanTimedOut
+			continue
+		}
+		s.serveSnapshotReq(req)
+	}
+}
 
-			waitersMap := s.waitersMap.Get()
+// serveSnapshotReq replies to req with a snapshot of the index satisfying
+// its consistency, or adds it to the waiters for the next one.
+func (s *storageMgr) serveSnapshotReq(req *MsgIndexSnapRequest) {
+	inst, found := s.indexInstMap.Get()[req.GetIndexId()]
+	if !found || inst.State == common.INDEX_STATE_DELETED {
+		req.respch <- common.ErrIndexNotFound
+		return
+	}
 
-			var waitersContainer *SnapshotWaitersContainer
-			var ok bool
-			if waitersContainer, ok = waitersMap[req.GetIndexId()]; !ok {
-				waitersContainer = s.initSnapshotWaitersForInst(req.GetIndexId())
-			}
+	stats := s.stats.Get()
+	idxStats := stats.indexes[req.GetIndexId()]
 
-			if waitersContainer == nil {
-				req.respch <- common.ErrIndexNotFound
-				return
-			}
+	// Return snapshot immediately if a matching snapshot exists already
+	// Else add into waiters list so that next snapshot creation event
+	// can notify the requester when a snapshot with matching timestamp
+	// is available.
+	snapC := s.indexSnapMap.Get()[req.GetIndexId()]
+	if snapC == nil {
+		func() {
+			s.muSnap.Lock()
+			defer s.muSnap.Unlock()
+			snapC, _ = s.initSnapshotContainerForInst(req.GetIndexId(), nil, "listenSnapshotReqs")
+		}()
+		if snapC == nil {
+			req.respch <- common.ErrIndexNotFound
+			return
+		}
+	}
 
-			w := newSnapshotWaiter(
-				req.GetIndexId(), req.GetTS(), req.GetConsistency(),
-				req.GetReplyChannel(), req.GetExpiredTime())
+	snapC.Lock()
+	//snapC.deleted indicates that the snapshot container belongs to a deleted
+	//index and it should no longer be used.
+	if snapC.deleted {
+		req.respch <- common.ErrIndexNotFound
+		snapC.Unlock()
+		return
+	}
+	if isSnapshotConsistentVbnos(snapC.snap, req.GetConsistency(), req.GetTS(), req.GetVbnos()) {
+		req.respch <- CloneIndexSnapshot(snapC.snap)
+		snapC.Unlock()
+		return
+	}
+	snapC.Unlock()
 
-			if idxStats != nil {
-				idxStats.numSnapshotWaiters.Add(1)
-			}
+	waitersMap := s.waitersMap.Get()
 
-			waitersContainer.Lock()
-			defer waitersContainer.Unlock()
-			waitersContainer.waiters = append(waitersContainer.waiters, w)
-		}()
+	var waitersContainer *SnapshotWaitersContainer
+	var ok bool
+	if waitersContainer, ok = waitersMap[req.GetIndexId()]; !ok {
+		waitersContainer = s.initSnapshotWaitersForInst(req.GetIndexId())
+	}
+
+	if waitersContainer == nil {
+		req.respch <- common.ErrIndexNotFound
+		return
+	}
+
+	w := newSnapshotWaiter(
+		req.GetIndexId(), req.GetTS(), req.GetVbnos(), req.GetConsistency(),
+		req.GetReplyChannel(), req.GetExpiredTime())
+
+	if idxStats != nil {
+		idxStats.numSnapshotWaiters.Add(1)
 	}
+
+	waitersContainer.Lock()
+	defer waitersContainer.Unlock()
+	waitersContainer.waiters = append(waitersContainer.waiters, w)
 }
 
 func (s *storageMgr) handleGetIndexStorageStats(cmd Message) {
@@ -1411,13 +1973,18 @@ func (s *storageMgr) handleGetIndexStorageStats(cmd Message) {
 func (s *storageMgr) handleStats(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 
+	cfg := s.config
 	go func() {
 		s.statsLock.Lock()
 		defer s.statsLock.Unlock()
 
 		req := cmd.(*MsgStatsRequest)
 		replych := req.GetReplyChannel()
-		storageStats := s.getIndexStorageStats(nil)
+
+		start := time.Now()
+		budget := time.Duration(cfg["stats.storage.gather_budget"].Int()) * time.Millisecond
+		storageStats, stale := s.getIndexStorageStatsIncremental(budget)
+		gatherDuration := time.Since(start)
 
 		//node level stats
 		var numStorageInstances int64
@@ -1438,6 +2005,13 @@ func (s *storageMgr) handleStats(cmd Message) {
 			// TODO(sarath): Investigate the reason for inconsistent stats map
 			// This nil check is a workaround to avoid indexer crashes for now.
 			if idxStats != nil {
+				gathered, isStale := stale[storageStatsKey{instId: st.InstId, partnId: st.PartnId}]
+				if isStale {
+					idxStats.storageStatsStaleness.Set(int64(time.Since(gathered) / time.Millisecond))
+				} else {
+					idxStats.storageStatsStaleness.Set(0)
+				}
+
 				idxStats.dataSize.Set(st.Stats.DataSize)
 				idxStats.dataSizeOnDisk.Set(st.Stats.DataSizeOnDisk)
 				idxStats.logSpaceOnDisk.Set(st.Stats.LogSpace)
@@ -1455,10 +2029,10 @@ func (s *storageMgr) handleStats(cmd Message) {
 				idxStats.insertBytes.Set(st.Stats.InsertBytes)
 				idxStats.deleteBytes.Set(st.Stats.DeleteBytes)
 
-				// compute mutation rate
+				// compute mutation rate, once the storage stats are fresh
 				now := time.Now().UnixNano()
 				elapsed := float64(now-idxStats.lastMutateGatherTime.Value()) / float64(time.Second)
-				if elapsed > 60 {
+				if elapsed > 60 && !isStale {
 					numDocsIndexed := idxStats.numDocsIndexed.Value()
 					mutationRate := float64(numDocsIndexed-idxStats.lastNumDocsIndexed.Value()) / elapsed
 					idxStats.avgMutationRate.Set(int64((mutationRate + float64(idxStats.avgMutationRate.Value())) / 2))
@@ -1474,7 +2048,7 @@ func (s *storageMgr) handleStats(cmd Message) {
 					idxStats.avgDiskBps.Set(int64((diskBps + float64(idxStats.avgDiskBps.Value())) / 2))
 					idxStats.lastDiskBytes.Set(diskBytes)
 
-					logging.Debugf("StorageManager.handleStats: partition %v DiskBps %v avgDiskBps %v drain rate %v",
+					storageMgrLog.Debugf("StorageManager.handleStats: partition %v DiskBps %v avgDiskBps %v drain rate %v",
 						st.PartnId, diskBps, idxStats.avgDiskBps.Value(), idxStats.avgDrainRate.Value())
 
 					idxStats.lastMutateGatherTime.Set(now)
@@ -1491,9 +2065,19 @@ func (s *storageMgr) handleStats(cmd Message) {
 			}
 		}
 
+		now := time.Now()
+		for instId, idxStats := range stats.indexes {
+			if inst, ok := indexInstMap[instId]; ok && inst.State != common.INDEX_STATE_DELETED {
+				updateSnapshotStats(instId, idxStats, cfg, now)
+			}
+		}
+		s.updateStorageTiers(storageStats, stats, indexInstMap, cfg, now)
+
 		stats.totalDataSize.Set(totalDataSize)
 		stats.totalDiskSize.Set(totalDiskSize)
 		stats.numStorageInstances.Set(numStorageInstances)
+		stats.numStaleStorageStats.Set(int64(len(stale)))
+		stats.storageStatsGatherDuration.Set(int64(gatherDuration / time.Millisecond))
 		stats.avgMutationRate.Set(avgMutationRate)
 		stats.avgDrainRate.Set(avgDrainRate)
 		stats.avgDiskBps.Set(avgDiskBps)
@@ -1509,8 +2093,6 @@ func (s *storageMgr) handleStats(cmd Message) {
 
 func (s *storageMgr) getIndexStorageStats(spec *statsSpec) []IndexStorageStats {
 	var stats []IndexStorageStats
-	var err error
-	var sts StorageStatistics
 
 	doPrepare := true
 
@@ -1552,86 +2134,104 @@ func (s *storageMgr) getIndexStorageStats(spec *statsSpec) []IndexStorageStats {
 		numIndexes++
 
 		for _, partnInst := range partnMap {
-			var internalData []string
-			internalDataMap := make(map[string]interface{})
-			var dataSz, dataSzOnDisk, logSpace, diskSz, memUsed, extraSnapDataSize int64
-			var getBytes, insertBytes, deleteBytes int64
-			var nslices int64
-			var needUpgrade = false
-			var hasStats = false
-
-			slices := partnInst.Sc.GetAllSlices()
-			nslices += int64(len(slices))
-			for i, slice := range slices {
-
-				// Increment the ref count before gathering stats. This is to ensure that
-				// the instance is not deleted in the middle of gathering stats.
-				if !slice.CheckAndIncrRef() {
-					continue
-				}
+			if stat, ok := getPartitionStorageStats(idxInstId, inst, partnInst, consumerFilter,
+				&doPrepare); ok {
+				stats = append(stats, stat)
+			}
+		}
+	}
+	gStats.numIndexes.Set(numIndexes)
 
-				// Prepare stats once
-				if doPrepare {
-					slice.PrepareStats()
-					doPrepare = false
-				}
+	return stats
+}
 
-				sts, err = slice.Statistics(consumerFilter)
-				slice.DecrRef()
+// getPartitionStorageStats returns the storage stats of an index partition, summed over its
+// slices, and false if they are not available.
+func getPartitionStorageStats(idxInstId common.IndexInstId, inst common.IndexInst,
+	partnInst PartitionInst, consumerFilter uint64, doPrepare *bool) (IndexStorageStats, bool) {
 
-				if err != nil {
-					break
-				}
+	var err error
+	var sts StorageStatistics
 
-				dataSz += sts.DataSize
-				dataSzOnDisk += sts.DataSizeOnDisk
-				memUsed += sts.MemUsed
-				logSpace += sts.LogSpace
-				diskSz += sts.DiskSize
-				getBytes += sts.GetBytes
-				insertBytes += sts.InsertBytes
-				deleteBytes += sts.DeleteBytes
-				extraSnapDataSize += sts.ExtraSnapDataSize
-				internalData = append(internalData, sts.InternalData...)
-				if sts.InternalDataMap != nil && len(sts.InternalDataMap) != 0 {
-					internalDataMap[fmt.Sprintf("slice_%d", i)] = sts.InternalDataMap
-				}
-				needUpgrade = needUpgrade || sts.NeedUpgrade
+	var internalData []string
+	internalDataMap := make(map[string]interface{})
+	var dataSz, dataSzOnDisk, logSpace, diskSz, memUsed, extraSnapDataSize int64
+	var getBytes, insertBytes, deleteBytes int64
+	var needUpgrade = false
+	var hasStats = false
+	var tier = STORAGE_TIER_HOT
 
-				hasStats = true
-			}
+	slices := partnInst.Sc.GetAllSlices()
+	for i, slice := range slices {
 
-			if hasStats && err == nil {
-				stat := IndexStorageStats{
-					InstId:     idxInstId,
-					PartnId:    partnInst.Defn.GetPartitionId(),
-					Name:       inst.Defn.Name,
-					Bucket:     inst.Defn.Bucket,
-					Scope:      inst.Defn.Scope,
-					Collection: inst.Defn.Collection,
-					Stats: StorageStatistics{
-						DataSize:          dataSz,
-						DataSizeOnDisk:    dataSzOnDisk,
-						LogSpace:          logSpace,
-						DiskSize:          diskSz,
-						MemUsed:           memUsed,
-						GetBytes:          getBytes,
-						InsertBytes:       insertBytes,
-						DeleteBytes:       deleteBytes,
-						ExtraSnapDataSize: extraSnapDataSize,
-						NeedUpgrade:       needUpgrade,
-						InternalData:      internalData,
-						InternalDataMap:   internalDataMap,
-					},
-				}
+		// Increment the ref count before gathering stats. This is to ensure that
+		// the instance is not deleted in the middle of gathering stats.
+		if !slice.CheckAndIncrRef() {
+			continue
+		}
 
-				stats = append(stats, stat)
-			}
+		// Prepare stats once
+		if *doPrepare {
+			slice.PrepareStats()
+			*doPrepare = false
 		}
-	}
-	gStats.numIndexes.Set(numIndexes)
 
-	return stats
+		sts, err = slice.Statistics(consumerFilter)
+		slice.DecrRef()
+
+		if err != nil {
+			break
+		}
+
+		if sliceTier(slice.Path()) == STORAGE_TIER_COLD {
+			tier = STORAGE_TIER_COLD
+		}
+
+		dataSz += sts.DataSize
+		dataSzOnDisk += sts.DataSizeOnDisk
+		memUsed += sts.MemUsed
+		logSpace += sts.LogSpace
+		diskSz += sts.DiskSize
+		getBytes += sts.GetBytes
+		insertBytes += sts.InsertBytes
+		deleteBytes += sts.DeleteBytes
+		extraSnapDataSize += sts.ExtraSnapDataSize
+		internalData = append(internalData, sts.InternalData...)
+		if sts.InternalDataMap != nil && len(sts.InternalDataMap) != 0 {
+			internalDataMap[fmt.Sprintf("slice_%d", i)] = sts.InternalDataMap
+		}
+		needUpgrade = needUpgrade || sts.NeedUpgrade
+
+		hasStats = true
+	}
+
+	if !hasStats || err != nil {
+		return IndexStorageStats{}, false
+	}
+
+	return IndexStorageStats{
+		InstId:     idxInstId,
+		PartnId:    partnInst.Defn.GetPartitionId(),
+		Name:       inst.Defn.Name,
+		Bucket:     inst.Defn.Bucket,
+		Scope:      inst.Defn.Scope,
+		Collection: inst.Defn.Collection,
+		Tier:       tier,
+		Stats: StorageStatistics{
+			DataSize:          dataSz,
+			DataSizeOnDisk:    dataSzOnDisk,
+			LogSpace:          logSpace,
+			DiskSize:          diskSz,
+			MemUsed:           memUsed,
+			GetBytes:          getBytes,
+			InsertBytes:       insertBytes,
+			DeleteBytes:       deleteBytes,
+			ExtraSnapDataSize: extraSnapDataSize,
+			NeedUpgrade:       needUpgrade,
+			InternalData:      internalData,
+			InternalDataMap:   internalDataMap,
+		},
+	}, true
 }
 
 func (s *storageMgr) handleRecoveryDone() {
@@ -1644,7 +2244,26 @@ func (s *storageMgr) handleRecoveryDone() {
 
 func (s *storageMgr) handleConfigUpdate(cmd Message) {
 	cfgUpdate := cmd.(*MsgConfigUpdate)
-	s.config = cfgUpdate.GetConfig()
+	newConfig := cfgUpdate.GetConfig()
+	if err := validateStorageConfig(newConfig); err != nil {
+		logConfigRejected(s.config, newConfig, err)
+		s.supvCmdch <- &MsgError{
+			err: Error{code: ERROR_STORAGE_MGR_INVALID_CONFIG,
+				severity: NORMAL,
+				category: STORAGE_MGR,
+				cause:    err}}
+		return
+	}
+
+	oldConfig := s.config
+	s.config = newConfig
+	logConfigChanges(oldConfig, newConfig)
+	setSnapshotLeakCheck(s.config)
+
+	snapReqWorkers := s.config["settings.snapshotRequestWorkers"].Int()
+	if snapReqWorkers > 0 && snapReqWorkers != s.snapshotReqs.numWorkers() {
+		s.snapshotReqs.resize(snapReqWorkers)
+	}
 
 	s.supvCmdch <- &MsgSuccess{}
 }
@@ -1656,9 +2275,11 @@ func (s *storageMgr) handleIndexMergeSnapshot(cmd Message) {
 	partitions := req.GetPartitions()
 
 	var source, target IndexSnapshot
-	indexSnapMap := s.indexSnapMap.Get()
+	var sourceBehind bool
+
+	validateSnapshots := func(canHeal bool) bool {
+		indexSnapMap := s.indexSnapMap.Get()
 
-	validateSnapshots := func() bool {
 		sourceC, ok := indexSnapMap[srcInstId]
 		if !ok {
 			s.supvCmdch <- &MsgSuccess{}
@@ -1698,8 +2319,13 @@ func (s *storageMgr) handleIndexMergeSnapshot(cmd Message) {
 			//
 
 			if !source.Timestamp().EqualOrGreater(target.Timestamp(), false) {
-				logging.Fatalf("StorageMgr::handleIndexMergeSnapshot, Source InstId: %v, sourceC: %+v, Target InstId: %v, targetC: %+v", srcInstId, sourceC, tgtInstId, targetC)
-				logging.Fatalf("StorageMgr::handleIndexMergeSnapshot Source InstId: %v, SnapId: %v, creationTime: %v, Target InstId: %v snapId: %v, creationTime: %v",
+				if canHeal {
+					sourceBehind = true
+					return false
+				}
+
+				storageMgrLog.Fatalf("StorageMgr::handleIndexMergeSnapshot, Source InstId: %v, sourceC: %+v, Target InstId: %v, targetC: %+v", srcInstId, sourceC, tgtInstId, targetC)
+				storageMgrLog.Fatalf("StorageMgr::handleIndexMergeSnapshot Source InstId: %v, SnapId: %v, creationTime: %v, Target InstId: %v snapId: %v, creationTime: %v",
 					source.IndexInstId(), source.SnapId(), source.CreationTime(), target.IndexInstId(), target.SnapId(), target.CreationTime())
 
 				s.supvCmdch <- &MsgError{
@@ -1756,7 +2382,7 @@ func (s *storageMgr) handleIndexMergeSnapshot(cmd Message) {
 					}
 				}
 			} else {
-				logging.Infof("skip validation in merge partitions %v between inst %v and %v", partitions, srcInstId, tgtInstId)
+				storageMgrLog.Infof("skip validation in merge partitions %v between inst %v and %v", partitions, srcInstId, tgtInstId)
 			}
 
 			// Deep clone a new snapshot by copying internal maps + increment target snapshot refcount.
@@ -1777,10 +2403,28 @@ func (s *storageMgr) handleIndexMergeSnapshot(cmd Message) {
 			}
 		}
 		return true
-	}()
+	}
 
-	if !validateSnapshots {
-		return
+	retries := 0
+	if s.config["merge.autoHeal"].Bool() {
+		retries = s.config["merge.autoHealRetries"].Int()
+	}
+	for attempt := 0; ; attempt++ {
+		sourceBehind = false
+		if validateSnapshots(attempt < retries) {
+			break
+		}
+		if !sourceBehind {
+			return
+		}
+
+		if stats := s.stats.Get(); stats != nil {
+			stats.numMergeSnapshotRetries.Add(1)
+		}
+		storageMgrLog.Warnf("StorageMgr::handleIndexMergeSnapshot Source InstId: %v is behind Target "+
+			"InstId: %v. Forcing a snapshot of the source. Attempt %v of %v", srcInstId, tgtInstId,
+			attempt+1, retries)
+		s.forceMergeSourceSnapshot(srcInstId, tgtInstId)
 	}
 
 	// decrement source snapshot refcount
@@ -1797,6 +2441,87 @@ func (s *storageMgr) handleIndexMergeSnapshot(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 }
 
+//forceMergeSourceSnapshot creates a snapshot of the source instance of a
+//partition merge at the timestamp of the latest snapshot of the target,
+//when the merge finds the source behind the target. The source is only
+//snapshotted if it is in the same stream and keyspace as the target, and
+//so has been flushed up to that timestamp.
+func (s *storageMgr) forceMergeSourceSnapshot(srcInstId, tgtInstId common.IndexInstId) {
+
+	indexInstMap := s.indexInstMap.Get()
+	srcInst, ok := indexInstMap[srcInstId]
+	tgtInst, ok2 := indexInstMap[tgtInstId]
+	if !ok || !ok2 || srcInst.Stream != tgtInst.Stream ||
+		srcInst.Defn.KeyspaceId(srcInst.Stream) != tgtInst.Defn.KeyspaceId(tgtInst.Stream) {
+		storageMgrLog.Warnf("StorageMgr::forceMergeSourceSnapshot Source InstId: %v and Target "+
+			"InstId: %v are not in the same stream. Skipped.", srcInstId, tgtInstId)
+		return
+	}
+
+	targetC, ok := s.indexSnapMap.Get()[tgtInstId]
+	if !ok {
+		return
+	}
+	targetC.Lock()
+	var ts *common.TsVbuuid
+	if targetC.snap != nil && targetC.snap.Timestamp() != nil {
+		ts = targetC.snap.Timestamp().Copy()
+	}
+	targetC.Unlock()
+	if ts == nil {
+		return
+	}
+
+	idxStats := s.stats.Get().indexes[srcInstId]
+	if idxStats == nil {
+		return
+	}
+
+	partnSnaps := make(map[common.PartitionId]PartitionSnapshot)
+	for _, partnInst := range s.indexPartnMap.Get()[srcInstId] {
+		partnId := partnInst.Defn.GetPartitionId()
+
+		sliceSnaps := make(map[SliceId]SliceSnapshot)
+		for _, slice := range partnInst.Sc.GetAllSlices() {
+			info, err := slice.NewSnapshot(ts, false)
+			if err == nil {
+				var snap Snapshot
+				if snap, err = slice.OpenSnapshot(info); err == nil {
+					sliceSnaps[slice.Id()] = &sliceSnapshot{id: slice.Id(), snap: snap}
+					continue
+				}
+			}
+
+			storageMgrLog.Errorf("StorageMgr::forceMergeSourceSnapshot Error creating snapshot "+
+				"for Index: %v PartitionId: %v SliceId: %v. Error %v", srcInstId, partnId,
+				slice.Id(), err)
+			for _, ss := range sliceSnaps {
+				ss.Snapshot().Close()
+			}
+			for _, ps := range partnSnaps {
+				for _, ss := range ps.Slices() {
+					ss.Snapshot().Close()
+				}
+			}
+			return
+		}
+
+		partnSnaps[partnId] = &partitionSnapshot{id: partnId, slices: sliceSnaps}
+	}
+
+	idxStats.numSnapshots.Add(1)
+	is := &indexSnapshot{
+		instId: srcInstId,
+		ts:     ts,
+		partns: partnSnaps,
+
+		// For debugging
+		snapId:       idxStats.numSnapshots.Value(),
+		creationTime: uint64(time.Now().UnixNano()),
+	}
+	s.updateSnapMapAndNotify(is, idxStats)
+}
+
 func (s *storageMgr) handleIndexPruneSnapshot(cmd Message) {
 	req := cmd.(*MsgIndexPruneSnapshot)
 	instId := req.GetInstId()
@@ -1965,7 +2690,11 @@ func (s *storageMgr) openSnapshot(idxInstId common.IndexInstId, partnInst Partit
 	infos, err := slice.GetSnapshots()
 	// TODO: Proper error handling if possible
 	if err != nil {
-		panic("Unable to read snapinfo -" + err.Error())
+		if !s.config["settings.verify_slice_on_startup"].Bool() {
+			panic("Unable to read snapinfo -" + err.Error())
+		}
+		s.reportUnusableSnapshot(idxInstId, pid, slice, err)
+		return partnSnapMap, nil, errSnapshotUnusable
 	}
 
 	snapInfoContainer := NewSnapshotInfoContainer(infos)
@@ -1973,10 +2702,11 @@ func (s *storageMgr) openSnapshot(idxInstId common.IndexInstId, partnInst Partit
 
 	snapFound := false
 	usableSnapFound := false
+	unusable := false
 	var tsVbuuid *common.TsVbuuid
 	for _, snapInfo := range allSnapShots {
 		snapFound = true
-		logging.Infof("StorageMgr::openSnapshot IndexInst:%v Partition:%v Attempting to open snapshot (%v)",
+		storageMgrLog.Infof("StorageMgr::openSnapshot IndexInst:%v Partition:%v Attempting to open snapshot (%v)",
 			idxInstId, pid, snapInfo)
 		usableSnapshot, err := slice.OpenSnapshot(snapInfo)
 		if err != nil {
@@ -1985,6 +2715,12 @@ func (s *storageMgr) openSnapshot(idxInstId common.IndexInstId, partnInst Partit
 				// Note: plasma and forestdb never return errStorageCorrupted for OpenSnapshot.
 				// So, we continue only in case of MOI.
 				continue
+			} else if s.config["settings.verify_slice_on_startup"].Bool() {
+				// The data of the slice may be ahead of the older snapshots,
+				// so none of them is tried.
+				s.reportUnusableSnapshot(idxInstId, pid, slice, err)
+				unusable = true
+				break
 			} else {
 				panic("Unable to open snapshot -" + err.Error())
 			}
@@ -2009,15 +2745,18 @@ func (s *storageMgr) openSnapshot(idxInstId common.IndexInstId, partnInst Partit
 	}
 
 	if !snapFound {
-		logging.Infof("StorageMgr::openSnapshot IndexInst:%v Partition:%v No Snapshot Found.",
+		storageMgrLog.Infof("StorageMgr::openSnapshot IndexInst:%v Partition:%v No Snapshot Found.",
 			idxInstId, pid)
 		partnSnapMap = nil
 		return partnSnapMap, tsVbuuid, nil
 	}
 
 	if !usableSnapFound {
-		logging.Infof("StorageMgr::openSnapshot IndexInst:%v Partition:%v No Usable Snapshot Found.",
+		storageMgrLog.Infof("StorageMgr::openSnapshot IndexInst:%v Partition:%v No Usable Snapshot Found.",
 			idxInstId, pid)
+		if unusable {
+			return partnSnapMap, nil, errSnapshotUnusable
+		}
 		return partnSnapMap, nil, errStorageCorrupted
 	}
 
@@ -2060,7 +2799,7 @@ func (s *storageMgr) updateIndexSnapMapForIndex(idxInstId common.IndexInstId, id
 	}
 
 	partitionIDs, _ := idxInst.Pc.GetAllPartitionIds()
-	logging.Infof("StorageMgr::updateIndexSnapMapForIndex IndexInst %v Partitions %v",
+	storageMgrLog.Infof("StorageMgr::updateIndexSnapMapForIndex IndexInst %v Partitions %v",
 		idxInstId, partitionIDs)
 
 	indexSnapMap := s.indexSnapMap.Clone()
@@ -2080,7 +2819,7 @@ func (s *storageMgr) updateIndexSnapMapForIndex(idxInstId common.IndexInstId, id
 
 	for _, partnInst := range partnMap {
 		partnSnapMap, tsVbuuid, err = s.openSnapshot(idxInstId, partnInst, partnSnapMap)
-		if err != nil {
+		if err != nil && err != errSnapshotUnusable {
 			if err == errStorageCorrupted {
 				needRestart = true
 			} else {
@@ -2092,8 +2831,10 @@ func (s *storageMgr) updateIndexSnapMapForIndex(idxInstId common.IndexInstId, id
 			break
 		}
 
-		//if OSO snapshot, rollback all partitions to 0
-		if tsVbuuid != nil && tsVbuuid.GetSnapType() == common.DISK_SNAP_OSO {
+		//if OSO snapshot, or no snapshot of the partition can be opened,
+		//rollback all partitions to 0
+		if err == errSnapshotUnusable ||
+			(tsVbuuid != nil && tsVbuuid.GetSnapType() == common.DISK_SNAP_OSO) {
 			for _, partnInst := range partnMap {
 				partnId := partnInst.Defn.GetPartitionId()
 				sc := partnInst.Sc
@@ -2126,7 +2867,7 @@ func (s *storageMgr) updateIndexSnapMapForIndex(idxInstId common.IndexInstId, id
 		}
 		indexSnapMap = s.indexSnapMap.Clone()
 		if snapC == nil {
-			logging.Infof("StorageMgr::updateIndexSnapMapForIndex, New IndexSnapshotContainer is being created "+
+			storageMgrLog.Infof("StorageMgr::updateIndexSnapMapForIndex, New IndexSnapshotContainer is being created "+
 				"for indexInst: %v, creation time: %v, caller: %v", idxInstId, creationTime, "updateIndexSnapMapForIndex")
 			snapC = &IndexSnapshotContainer{snap: is, creationTime: creationTime}
 		} else {
@@ -2139,7 +2880,7 @@ func (s *storageMgr) updateIndexSnapMapForIndex(idxInstId common.IndexInstId, id
 		s.indexSnapMap.Set(indexSnapMap)
 		s.notifySnapshotCreation(is)
 	} else {
-		logging.Infof("StorageMgr::updateIndexSnapMapForIndex IndexInst %v Adding Nil Snapshot.",
+		storageMgrLog.Infof("StorageMgr::updateIndexSnapMapForIndex IndexInst %v Adding Nil Snapshot.",
 			idxInstId)
 		s.addNilSnapshot(idxInstId, bucket, "updateIndexSnapMapForIndex")
 	}
@@ -2165,6 +2906,49 @@ func (s *storageMgr) handleUpdateIndexSnapMapForIndex(cmd Message) {
 	s.supvCmdch <- &MsgSuccess{}
 }
 
+// applyIndexInstMapDelta returns a copy of instMap with the updated instances
+// set and the deleted ones removed, along with the ids of the instances
+// changed. The instances not changed are shared with instMap.
+func applyIndexInstMapDelta(instMap common.IndexInstMap, updated common.IndexInstList,
+	deleted []common.IndexInstId) (common.IndexInstMap, []common.IndexInstId) {
+
+	out := make(common.IndexInstMap, len(instMap)+len(updated))
+	for instId, inst := range instMap {
+		out[instId] = inst
+	}
+
+	changed := make([]common.IndexInstId, 0, len(updated)+len(deleted))
+	for _, inst := range updated {
+		inst.Pc = inst.Pc.Clone()
+		out[inst.InstId] = inst
+		changed = append(changed, inst.InstId)
+	}
+	for _, instId := range deleted {
+		delete(out, instId)
+		changed = append(changed, instId)
+	}
+	return out, changed
+}
+
+// streamKeyspaceIdsChanged returns true if any of the changed instances was
+// added to or removed from newMap, or moved to another stream or keyspace.
+func streamKeyspaceIdsChanged(oldMap, newMap common.IndexInstMap,
+	changed []common.IndexInstId) bool {
+
+	for _, instId := range changed {
+		oldInst, oldOk := oldMap[instId]
+		newInst, newOk := newMap[instId]
+		if oldOk != newOk {
+			return true
+		}
+		if oldOk && (oldInst.Stream != newInst.Stream ||
+			oldInst.Defn.KeyspaceId(oldInst.Stream) != newInst.Defn.KeyspaceId(newInst.Stream)) {
+			return true
+		}
+	}
+	return false
+}
+
 func getStreamKeyspaceIdInstListFromInstMap(indexInstMap common.IndexInstMap) StreamKeyspaceIdInstList {
 	out := make(StreamKeyspaceIdInstList)
 	for instId, inst := range indexInstMap {
@@ -2235,5 +3019,11 @@ func (s *storageMgr) getNumSnapshotWorkers() int {
 		//Since indexer supports upto 10000 indexes in a cluster as of 7.0
 		numSnapshotWorkers = 10000
 	}
+	if s.config["snapshotWorkers.autotune"].Bool() {
+		numSnapshotWorkers = s.tuneSnapshotWorkers(numSnapshotWorkers)
+	}
+	if stats := s.stats.Get(); stats != nil {
+		stats.numSnapshotWorkers.Set(int64(numSnapshotWorkers))
+	}
 	return numSnapshotWorkers
 }
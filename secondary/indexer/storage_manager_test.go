@@ -0,0 +1,535 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+const testNumVbuckets = 8
+
+// storageMgrHarness drives the handlers of a storage manager directly, over
+// indexes of MockSlices, with the restart timestamps of rollbacks not
+// validated against the failover logs of the cluster.
+type storageMgrHarness struct {
+	t      *testing.T
+	sm     *storageMgr
+	cmdch  MsgChannel
+	respch MsgChannel
+	stats  *IndexerStats
+
+	indexInstMap  common.IndexInstMap
+	indexPartnMap IndexPartnMap
+}
+
+func newStorageMgrHarness(t *testing.T) *storageMgrHarness {
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	cfg.SetValue("numVbuckets", testNumVbuckets)
+	cfg.SetValue("enableManager", true)
+	cfg.SetValue("numSnapshotWorkers", 2)
+	cfg.SetValue("snapshotWorkers.autotune", false)
+	cfg.SetValue("merge.autoHeal", false)
+
+	h := &storageMgrHarness{
+		t:             t,
+		cmdch:         make(MsgChannel, 100),
+		respch:        make(MsgChannel, 100),
+		stats:         NewIndexerStats(),
+		indexInstMap:  make(common.IndexInstMap),
+		indexPartnMap: make(IndexPartnMap),
+	}
+	h.stats.AddKeyspaceStats(common.MAINT_STREAM, "default")
+
+	notifych := []chan IndexSnapshot{make(chan IndexSnapshot, 1000)}
+	h.sm = newStorageMgr(h.cmdch, h.respch, cfg, notifych, nil, h.stats)
+	h.sm.validateRestartTs = func(keyspaceId string, ts *common.TsVbuuid) *common.TsVbuuid {
+		return ts
+	}
+	return h
+}
+
+// addIndex adds an index on bucket default in stream, with a MockSlice for
+// each of partnIds.
+func (h *storageMgrHarness) addIndex(instId common.IndexInstId, stream common.StreamId,
+	partnIds ...common.PartitionId) map[common.PartitionId]*MockSlice {
+
+	var scheme common.PartitionScheme = common.SINGLE
+	if len(partnIds) > 1 || partnIds[0] != common.NON_PARTITION_ID {
+		scheme = common.KEY
+	}
+
+	defnId := common.IndexDefnId(instId)
+	inst := common.IndexInst{
+		InstId: instId,
+		Defn: common.IndexDefn{
+			DefnId:          defnId,
+			Name:            fmt.Sprintf("idx%v", instId),
+			Bucket:          "default",
+			PartitionScheme: scheme,
+		},
+		State:  common.INDEX_STATE_ACTIVE,
+		Stream: stream,
+		Pc:     common.NewKeyPartitionContainer(testNumVbuckets, len(partnIds), scheme, common.CRC32),
+	}
+
+	slices := make(map[common.PartitionId]*MockSlice)
+	partnMap := make(PartitionInstMap)
+	for _, partnId := range partnIds {
+		defn := common.KeyPartitionDefn{Id: partnId}
+		inst.Pc.AddPartition(partnId, defn)
+
+		slice := NewMockSlice(0, instId, defnId)
+		sc := NewHashedSliceContainer()
+		sc.AddSlice(0, slice)
+		partnMap[partnId] = PartitionInst{Defn: defn, Sc: sc}
+		slices[partnId] = slice
+
+		h.stats.AddPartitionStats(inst, partnId)
+	}
+
+	h.indexInstMap[instId] = inst
+	h.indexPartnMap[instId] = partnMap
+
+	h.sm.handleUpdateIndexPartnMap(&MsgUpdatePartnMap{indexPartnMap: h.indexPartnMap})
+	h.expectSuccess()
+	h.sm.handleUpdateIndexInstMap(&MsgUpdateInstMap{indexInstMap: h.indexInstMap, stats: h.stats})
+	h.expectSuccess()
+
+	return slices
+}
+
+func (h *storageMgrHarness) recv(ch MsgChannel) Message {
+	h.t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(10 * time.Second):
+		h.t.Fatalf("timed out waiting for the storage manager")
+	}
+	return nil
+}
+
+func (h *storageMgrHarness) expectSuccess() {
+	h.t.Helper()
+	if msg := h.recv(h.cmdch); msg.GetMsgType() != MSG_SUCCESS {
+		h.t.Fatalf("expected success, got %v", msg)
+	}
+}
+
+// flush snapshots the indexes of bucket default in stream at ts.
+func (h *storageMgrHarness) flush(stream common.StreamId, ts *common.TsVbuuid) {
+	h.t.Helper()
+	h.sm.handleCreateSnapshot(&MsgMutMgrFlushDone{mType: MUT_MGR_FLUSH_DONE,
+		streamId:   stream,
+		keyspaceId: "default",
+		ts:         ts})
+	h.expectSuccess()
+
+	if msg := h.recv(h.respch); msg.GetMsgType() != STORAGE_SNAP_DONE {
+		h.t.Fatalf("expected snapshot done, got %v", msg)
+	}
+}
+
+func (h *storageMgrHarness) rollback(rollbackTs *common.TsVbuuid) *MsgRollbackDone {
+	h.t.Helper()
+	h.sm.handleRollback(&MsgRollback{streamId: common.MAINT_STREAM,
+		keyspaceId: "default",
+		rollbackTs: rollbackTs})
+	h.expectSuccess()
+
+	msg, ok := h.recv(h.respch).(*MsgRollbackDone)
+	if !ok {
+		h.t.Fatalf("expected rollback done, got %v", msg)
+	}
+	return msg
+}
+
+// snapshot returns the latest snapshot of the index instId.
+func (h *storageMgrHarness) snapshot(instId common.IndexInstId) IndexSnapshot {
+	snapC := h.sm.indexSnapMap.Get()[instId]
+	snapC.Lock()
+	defer snapC.Unlock()
+	return snapC.snap
+}
+
+func newTestSnapTs(snapType common.IndexSnapType, seqno uint64) *common.TsVbuuid {
+	ts := common.NewTsVbuuid("default", testNumVbuckets)
+	for vb := range ts.Seqnos {
+		ts.Seqnos[vb] = seqno
+		ts.Vbuuids[vb] = 1234
+		ts.Snapshots[vb] = [2]uint64{seqno, seqno}
+	}
+	ts.SetSnapType(snapType)
+	return ts
+}
+
+func countSnapshot(t *testing.T, is IndexSnapshot, partnId common.PartitionId) uint64 {
+	t.Helper()
+	ps, ok := is.Partitions()[partnId]
+	if !ok {
+		t.Fatalf("expected a snapshot of partition %v", partnId)
+	}
+	count, err := ps.Slices()[0].Snapshot().CountTotal(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return count
+}
+
+// insertDocs writes docs docid0..docid<n-1> to slice.
+func insertDocs(slice *MockSlice, n int) {
+	for i := 0; i < n; i++ {
+		docid := []byte(fmt.Sprintf("docid%v", i))
+		slice.Insert(docid, docid, nil)
+	}
+}
+
+func TestStorageMgrCreateSnapshot(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slice := h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+
+	insertDocs(slice, 3)
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+
+	if slice.numCommits != 1 || slice.numSnapshots() != 1 {
+		t.Fatalf("expected a committed snapshot, got %v commits", slice.numCommits)
+	}
+	is := h.snapshot(1)
+	if is.Timestamp().Seqnos[0] != 10 || countSnapshot(t, is, common.NON_PARTITION_ID) != 3 {
+		t.Fatalf("expected the snapshot of 3 docs at seqno 10, got %v", is.Timestamp())
+	}
+
+	// Unchanged slices keep their snapshot, for a newer index snapshot
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.INMEM_SNAP, 20))
+	is = h.snapshot(1)
+	if is.Timestamp().Seqnos[0] != 20 {
+		t.Fatalf("expected the index snapshot at seqno 20, got %v", is.Timestamp())
+	}
+	if ss := is.Partitions()[common.NON_PARTITION_ID].Slices()[0].Snapshot(); ss.Timestamp().Seqnos[0] != 10 {
+		t.Fatalf("expected the slice snapshot at seqno 10, got %v", ss.Timestamp())
+	}
+
+	// In-memory snapshots are not committed
+	insertDocs(slice, 5)
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.INMEM_SNAP, 30))
+	is = h.snapshot(1)
+	if slice.numCommits != 1 || countSnapshot(t, is, common.NON_PARTITION_ID) != 5 {
+		t.Fatalf("expected an in-memory snapshot of 5 docs, got %v commits", slice.numCommits)
+	}
+
+	if stats := h.stats.indexes[1]; stats.numSnapshots.Value() != 2 || stats.numCommits.Value() != 1 {
+		t.Fatalf("expected 2 snapshots and 1 commit, got %v and %v",
+			stats.numSnapshots.Value(), stats.numCommits.Value())
+	}
+}
+
+func TestStorageMgrCreateSnapshotStreams(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	maint := h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+	initSlice := h.addIndex(2, common.INIT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+
+	h.flush(common.INIT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+	if maint.numCommits != 0 || initSlice.numCommits != 1 {
+		t.Fatalf("expected only the index in the flushed stream snapshotted")
+	}
+	if ts := h.snapshot(1).Timestamp(); ts.Seqnos[0] != 0 {
+		t.Fatalf("expected the nil snapshot of the index in the other stream, got %v", ts)
+	}
+}
+
+func TestStorageMgrCommitOnShutdown(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slice := h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+	unflushed := h.addIndex(2, common.INIT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+
+	// Nothing to commit past the committed snapshot, or for an index
+	// never flushed
+	insertDocs(slice, 3)
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+	h.sm.commitOnShutdown(10 * time.Second)
+	if slice.numCommits != 1 || unflushed.numCommits != 0 {
+		t.Fatalf("expected no commit on shutdown, got %v and %v commits",
+			slice.numCommits, unflushed.numCommits)
+	}
+
+	// The pending in-memory snapshot is committed
+	insertDocs(slice, 5)
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.INMEM_SNAP, 20))
+	if slice.numCommits != 1 {
+		t.Fatalf("expected the in-memory snapshot not committed, got %v commits", slice.numCommits)
+	}
+	h.sm.commitOnShutdown(10 * time.Second)
+	if slice.numCommits != 2 || unflushed.numCommits != 0 {
+		t.Fatalf("expected the pending snapshot committed on shutdown, got %v and %v commits",
+			slice.numCommits, unflushed.numCommits)
+	}
+	latest := slice.infos[0]
+	if ts := latest.Timestamp(); ts.Seqnos[0] != 20 || ts.GetSnapType() != common.FORCE_COMMIT ||
+		len(latest.docs) != 5 {
+		t.Fatalf("expected a forced commit of 5 docs at seqno 20, got %v docs at %v",
+			len(latest.docs), ts)
+	}
+
+	h.sm.commitOnShutdown(10 * time.Second)
+	if slice.numCommits != 2 {
+		t.Fatalf("expected no second commit, got %v commits", slice.numCommits)
+	}
+}
+
+func TestStorageMgrRollback(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slice := h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+
+	for i := 1; i <= 3; i++ {
+		insertDocs(slice, i)
+		h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, uint64(i*10)))
+	}
+
+	msg := h.rollback(newTestSnapTs(common.NO_SNAP, 25))
+	if msg.GetError() != nil {
+		t.Fatalf("unexpected error %v", msg.GetError())
+	}
+	if restartTs := msg.GetRestartTs(); restartTs == nil || restartTs.Seqnos[0] != 20 {
+		t.Fatalf("expected to restart from seqno 20, got %v", restartTs)
+	}
+	if slice.numRollbacks != 1 || slice.numSnapshots() != 2 {
+		t.Fatalf("expected a rollback to the second snapshot, got %v snapshots", slice.numSnapshots())
+	}
+
+	is := h.snapshot(1)
+	if is.Timestamp().Seqnos[0] != 20 || countSnapshot(t, is, common.NON_PARTITION_ID) != 2 {
+		t.Fatalf("expected the snapshot of 2 docs at seqno 20, got %v", is.Timestamp())
+	}
+
+	ksStats := h.stats.GetKeyspaceStats(common.MAINT_STREAM, "default")
+	if ksStats.numRollbacks.Value() != 1 || ksStats.numRollbacksToZero.Value() != 0 {
+		t.Fatalf("expected 1 rollback, got %v", ksStats.numRollbacks.Value())
+	}
+}
+
+func TestStorageMgrRollbackToZero(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slices := h.addIndex(1, common.MAINT_STREAM, 1, 2)
+
+	for _, slice := range slices {
+		insertDocs(slice, 2)
+	}
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+
+	msg := h.rollback(newTestSnapTs(common.NO_SNAP, 5))
+	if msg.GetError() != nil || msg.GetRestartTs() != nil {
+		t.Fatalf("expected to restart from zero, got %v %v", msg.GetRestartTs(), msg.GetError())
+	}
+	for partnId, slice := range slices {
+		if slice.numRollbacksToZero == 0 || slice.numSnapshots() != 0 {
+			t.Fatalf("expected partition %v rolled back to zero", partnId)
+		}
+	}
+	if is := h.snapshot(1); len(is.Partitions()) != 0 {
+		t.Fatalf("expected a nil snapshot, got %v partitions", len(is.Partitions()))
+	}
+
+	ksStats := h.stats.GetKeyspaceStats(common.MAINT_STREAM, "default")
+	if ksStats.numRollbacksToZero.Value() != 1 {
+		t.Fatalf("expected 1 rollback to zero, got %v", ksStats.numRollbacksToZero.Value())
+	}
+}
+
+func TestStorageMgrRollbackRetry(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slice := h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 20))
+
+	// A rollback to 0 requested by DCP is first tried from the latest
+	// snapshot, then from each older one
+	for _, seqno := range []uint64{20, 10} {
+		msg := h.rollback(newTestSnapTs(common.NO_SNAP, 0))
+		if restartTs := msg.GetRestartTs(); restartTs == nil || restartTs.Seqnos[0] != seqno {
+			t.Fatalf("expected to restart from seqno %v, got %v", seqno, restartTs)
+		}
+	}
+
+	msg := h.rollback(newTestSnapTs(common.NO_SNAP, 0))
+	if msg.GetRestartTs() != nil || slice.numRollbacksToZero == 0 {
+		t.Fatalf("expected to restart from zero, got %v", msg.GetRestartTs())
+	}
+}
+
+func TestStorageMgrRollbackError(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slice := h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)[common.NON_PARTITION_ID]
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+
+	slice.rollbackErr = errors.New("rollback failed")
+	if msg := h.rollback(newTestSnapTs(common.NO_SNAP, 15)); msg.GetError() != slice.rollbackErr {
+		t.Fatalf("expected the rollback error, got %v", msg.GetError())
+	}
+
+	slice.rollbackToZeroErr = errors.New("rollback to zero failed")
+	if msg := h.rollback(newTestSnapTs(common.NO_SNAP, 5)); msg.GetError() != slice.rollbackToZeroErr {
+		t.Fatalf("expected the rollback to zero error, got %v", msg.GetError())
+	}
+}
+
+func TestStorageMgrRollbackReconcileStats(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	slices := h.addIndex(1, common.MAINT_STREAM, 1, 2)
+
+	for i := 1; i <= 2; i++ {
+		for _, slice := range slices {
+			insertDocs(slice, i*2)
+		}
+		h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, uint64(i*10)))
+	}
+
+	// counts as of the latest snapshot, before the rollback
+	for partnId := range slices {
+		h.stats.GetPartitionStats(1, partnId).itemsCount.Set(4)
+	}
+
+	h.rollback(newTestSnapTs(common.NO_SNAP, 15))
+	h.sm.reconcileRollbackStats(common.MAINT_STREAM, "default")
+	for partnId := range slices {
+		if c := h.stats.GetPartitionStats(1, partnId).itemsCount.Value(); c != 2 {
+			t.Fatalf("expected 2 items in partition %v after the rollback, got %v", partnId, c)
+		}
+	}
+
+	h.rollback(newTestSnapTs(common.NO_SNAP, 5))
+	h.sm.reconcileRollbackStats(common.MAINT_STREAM, "default")
+	for partnId := range slices {
+		if c := h.stats.GetPartitionStats(1, partnId).itemsCount.Value(); c != 0 {
+			t.Fatalf("expected no items in partition %v after a rollback to zero, got %v", partnId, c)
+		}
+	}
+}
+
+func TestStorageMgrMergeSnapshot(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	target := h.addIndex(1, common.MAINT_STREAM, 1)[1]
+	source := h.addIndex(2, common.INIT_STREAM, 2)[2]
+
+	insertDocs(target, 2)
+	insertDocs(source, 3)
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+	h.flush(common.INIT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+
+	h.sm.handleIndexMergeSnapshot(&MsgIndexMergeSnapshot{srcInstId: 2, tgtInstId: 1,
+		partitions: []common.PartitionId{2}})
+	h.expectSuccess()
+
+	is := h.snapshot(1)
+	if len(is.Partitions()) != 2 || countSnapshot(t, is, 1) != 2 || countSnapshot(t, is, 2) != 3 {
+		t.Fatalf("expected the partitions of both indexes, got %v partitions", len(is.Partitions()))
+	}
+}
+
+func TestStorageMgrMergeSnapshotBehind(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	h.addIndex(1, common.MAINT_STREAM, 1)
+	h.addIndex(2, common.INIT_STREAM, 2)
+
+	h.flush(common.MAINT_STREAM, newTestSnapTs(common.DISK_SNAP, 20))
+	h.flush(common.INIT_STREAM, newTestSnapTs(common.DISK_SNAP, 10))
+
+	h.sm.handleIndexMergeSnapshot(&MsgIndexMergeSnapshot{srcInstId: 2, tgtInstId: 1,
+		partitions: []common.PartitionId{2}})
+	msg, ok := h.recv(h.cmdch).(*MsgError)
+	if !ok || msg.GetError().code != ERROR_STORAGE_MGR_MERGE_SNAPSHOT_FAIL {
+		t.Fatalf("expected the merge to fail, got %v", msg)
+	}
+	if is := h.snapshot(1); len(is.Partitions()) != 1 {
+		t.Fatalf("expected the target snapshot unchanged, got %v partitions", len(is.Partitions()))
+	}
+}
+
+func TestStorageMgrExpireSnapshotWaiters(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)
+
+	now := time.Now()
+	wc := h.sm.initSnapshotWaitersForInst(1)
+	var chs []chan interface{}
+	for _, expired := range []time.Time{now.Add(-time.Second), now.Add(time.Hour), {}} {
+		ch := make(chan interface{}, 1)
+		chs = append(chs, ch)
+		wc.waiters = append(wc.waiters, newSnapshotWaiter(1, nil, nil, common.AnyConsistency, ch, expired))
+	}
+	h.stats.indexes[1].numSnapshotWaiters.Set(3)
+
+	if n := h.sm.expireSnapshotWaiters(now); n != 1 {
+		t.Fatalf("expected 1 waiter expired, got %v", n)
+	}
+	if resp := <-chs[0]; resp != common.ErrScanTimedOut {
+		t.Fatalf("expected the expired waiter timed out, got %v", resp)
+	}
+	if len(wc.waiters) != 2 || len(chs[1]) != 0 || len(chs[2]) != 0 {
+		t.Fatalf("expected the other waiters kept waiting, got %v waiters", len(wc.waiters))
+	}
+	if n := h.stats.indexes[1].numSnapshotWaiters.Value(); n != 2 {
+		t.Fatalf("expected 2 snapshot waiters, got %v", n)
+	}
+
+	if n := h.sm.expireSnapshotWaiters(now.Add(2 * time.Hour)); n != 1 || len(wc.waiters) != 1 {
+		t.Fatalf("expected the waiter without expiry kept, got %v expired", n)
+	}
+}
+
+func TestStorageMgrUpdateInstMapDelta(t *testing.T) {
+	h := newStorageMgrHarness(t)
+	h.addIndex(1, common.MAINT_STREAM, common.NON_PARTITION_ID)
+	h.addIndex(2, common.MAINT_STREAM, common.NON_PARTITION_ID)
+	h.addIndex(3, common.INIT_STREAM, common.NON_PARTITION_ID)
+
+	update := func(msg *MsgUpdateInstMap) {
+		t.Helper()
+		msg.indexInstMap, msg.stats = h.indexInstMap, h.stats
+		h.sm.handleUpdateIndexInstMap(msg)
+		h.expectSuccess()
+	}
+
+	// a full update sets the version the next delta applies to
+	update(&MsgUpdateInstMap{version: 1})
+
+	// drop index 2 and move index 3 to MAINT_STREAM
+	inst3 := h.indexInstMap[3]
+	inst3.Stream = common.MAINT_STREAM
+	h.indexInstMap[3] = inst3
+	delete(h.indexInstMap, 2)
+	update(&MsgUpdateInstMap{updatedInsts: common.IndexInstList{inst3},
+		deletedInstIds: []common.IndexInstId{2}, delta: true, baseVersion: 1, version: 2})
+
+	instMap := h.sm.indexInstMap.Get()
+	if _, ok := instMap[2]; ok || len(instMap) != 2 || instMap[3].Stream != common.MAINT_STREAM {
+		t.Fatalf("expected the delta applied, got %v", instMap)
+	}
+	if _, ok := h.sm.indexSnapMap.Get()[2]; ok {
+		t.Fatalf("expected the snapshot of the dropped index destroyed")
+	}
+	if insts := h.sm.streamKeyspaceIdInstList.Get()[common.MAINT_STREAM]["default"]; len(insts) != 2 {
+		t.Fatalf("expected 2 indexes in MAINT_STREAM, got %v", insts)
+	}
+
+	// a delta not based on the current version is applied as a full update
+	delete(h.indexInstMap, 1)
+	update(&MsgUpdateInstMap{delta: true, baseVersion: 1, version: 3})
+	if instMap := h.sm.indexInstMap.Get(); len(instMap) != 1 || h.sm.instMapVersion != 3 {
+		t.Fatalf("expected the full map copied, got %v", instMap)
+	}
+	if _, ok := h.sm.indexSnapMap.Get()[1]; ok {
+		t.Fatalf("expected the snapshot of the dropped index destroyed")
+	}
+}
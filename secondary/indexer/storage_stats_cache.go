@@ -0,0 +1,219 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Gathering the storage stats walks every slice while holding statsLock, so with thousands of
+// slices, or a slow storage engine call, a round of stats can take longer than the stats
+// interval. The periodic stats round gathers the storage stats of the index partitions within
+// stats.storage.gather_budget instead: the partitions whose stats are the stalest are gathered
+// first, and the stats of the ones left when the budget is spent are served from the last round
+// that gathered them. The storage_stats_staleness of a partition is how old its storage stats
+// are, 0 if they were gathered in the last round. Requests for the storage stats of specific
+// indexes still gather them all.
+//
+// The partitions are gathered in a background goroutine, which the round waits for no longer
+// than the budget, so that a storage engine call stuck on one partition does not hold up the
+// round. No other gather starts until it is done, the rounds meanwhile serve all the stats from
+// the cache.
+
+type storageStatsKey struct {
+	instId  common.IndexInstId
+	partnId common.PartitionId
+}
+
+type cachedStorageStats struct {
+	stats    IndexStorageStats
+	gathered time.Time
+	seq      uint64 // of the gather
+}
+
+// storageStatsCache keeps the last storage stats gathered for each index partition.
+type storageStatsCache struct {
+	mu     sync.Mutex
+	partns map[storageStatsKey]*cachedStorageStats
+	donech chan bool // closed once the background gather is done, nil if none is running
+	seq    uint64    // of the last gather started
+}
+
+func newStorageStatsCache() *storageStatsCache {
+	return &storageStatsCache{partns: make(map[storageStatsKey]*cachedStorageStats)}
+}
+
+// storageStatsPartn is an index partition to gather the storage stats of.
+type storageStatsPartn struct {
+	key       storageStatsKey
+	inst      common.IndexInst
+	partnInst PartitionInst
+}
+
+// gather gathers the storage stats of partns with get, the stalest first, until budget is spent,
+// and returns the stats of all of them, with when they were gathered for the ones served from
+// the cache. At least one partition is gathered per background gather, and all of them if
+// budget is 0, in which case gather waits for them.
+func (c *storageStatsCache) gather(partns []storageStatsPartn, budget time.Duration,
+	now func() time.Time, get func(p storageStatsPartn) (IndexStorageStats, bool)) (
+	[]IndexStorageStats, map[storageStatsKey]time.Time) {
+
+	c.mu.Lock()
+	for budget <= 0 && c.donech != nil {
+		donech := c.donech
+		c.mu.Unlock()
+		<-donech
+		c.mu.Lock()
+	}
+
+	gathered := func(p storageStatsPartn) time.Time {
+		if cached, ok := c.partns[p.key]; ok {
+			return cached.gathered
+		}
+		return time.Time{}
+	}
+	sort.SliceStable(partns, func(i, j int) bool {
+		return gathered(partns[i]).Before(gathered(partns[j]))
+	})
+
+	// Forget the partitions gone since the last round
+	keys := make(map[storageStatsKey]bool, len(partns))
+	for _, p := range partns {
+		keys[p.key] = true
+	}
+	for key := range c.partns {
+		if !keys[key] {
+			delete(c.partns, key)
+		}
+	}
+
+	var seq uint64
+	if c.donech == nil {
+		c.seq++
+		seq = c.seq
+		c.donech = make(chan bool)
+		go c.gatherPartns(partns, budget, seq, now, get, c.donech)
+	}
+	donech := c.donech
+	c.mu.Unlock()
+
+	if budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+
+		select {
+		case <-donech:
+		case <-timer.C:
+		}
+	} else {
+		<-donech
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]IndexStorageStats, 0, len(partns))
+	stale := make(map[storageStatsKey]time.Time)
+	for _, p := range partns {
+		if cached, ok := c.partns[p.key]; ok {
+			stats = append(stats, cached.stats)
+			if cached.seq != seq {
+				stale[p.key] = cached.gathered
+			}
+		}
+	}
+
+	return stats, stale
+}
+
+// gatherPartns gathers the storage stats of partns into the cache as gather seq until budget is
+// spent, and closes donech once done.
+func (c *storageStatsCache) gatherPartns(partns []storageStatsPartn, budget time.Duration,
+	seq uint64, now func() time.Time, get func(p storageStatsPartn) (IndexStorageStats, bool),
+	donech chan bool) {
+
+	defer func() {
+		c.mu.Lock()
+		c.donech = nil
+		c.mu.Unlock()
+		close(donech)
+	}()
+
+	start := now()
+	for i, p := range partns {
+		if i > 0 && budget > 0 && now().Sub(start) >= budget {
+			return
+		}
+
+		st, ok := get(p)
+
+		c.mu.Lock()
+		if ok {
+			c.partns[p.key] = &cachedStorageStats{stats: st, gathered: now(), seq: seq}
+		} else {
+			delete(c.partns, p.key)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// wait waits for the background gather, if any, to be done.
+func (c *storageStatsCache) wait() {
+	c.mu.Lock()
+	donech := c.donech
+	c.mu.Unlock()
+
+	if donech != nil {
+		<-donech
+	}
+}
+
+// getIndexStorageStatsIncremental returns the storage stats of all index partitions, gathering
+// them within budget. See storageStatsCache.
+func (s *storageMgr) getIndexStorageStatsIncremental(budget time.Duration) (
+	[]IndexStorageStats, map[storageStatsKey]time.Time) {
+
+	var partns []storageStatsPartn
+	var numIndexes int64
+
+	indexInstMap := s.indexInstMap.Get()
+	indexPartnMap := s.indexPartnMap.Get()
+	for idxInstId, partnMap := range indexPartnMap {
+		inst, ok := indexInstMap[idxInstId]
+		//skip deleted indexes
+		if !ok || inst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		numIndexes++
+
+		for partnId, partnInst := range partnMap {
+			partns = append(partns, storageStatsPartn{
+				key:       storageStatsKey{instId: idxInstId, partnId: partnId},
+				inst:      inst,
+				partnInst: partnInst,
+			})
+		}
+	}
+	s.stats.Get().numIndexes.Set(numIndexes)
+
+	if s.statsCache == nil {
+		s.statsCache = newStorageStatsCache()
+	}
+
+	doPrepare := true
+	return s.statsCache.gather(partns, budget, time.Now,
+		func(p storageStatsPartn) (IndexStorageStats, bool) {
+			return getPartitionStorageStats(p.key.instId, p.inst, p.partnInst, 0, &doPrepare)
+		})
+}
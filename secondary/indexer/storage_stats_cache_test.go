@@ -0,0 +1,145 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestStorageStatsCacheGather(t *testing.T) {
+	var partns []storageStatsPartn
+	for i := 0; i < 10; i++ {
+		partns = append(partns, storageStatsPartn{
+			key: storageStatsKey{instId: common.IndexInstId(i), partnId: 0},
+		})
+	}
+
+	// Each partition takes a second to gather
+	clock := time.Unix(0, 0)
+	now := func() time.Time { return clock }
+	var round int64
+	var numGets int
+	get := func(p storageStatsPartn) (IndexStorageStats, bool) {
+		clock = clock.Add(time.Second)
+		numGets++
+		return IndexStorageStats{InstId: p.key.instId, Stats: StorageStatistics{DataSize: round}}, true
+	}
+
+	c := newStorageStatsCache()
+
+	// The first round gathers within the budget, the others have no stats yet
+	round = 1
+	stats, stale := c.gather(partns, 3*time.Second, now, get)
+	if numGets != 3 || len(stats) != 3 || len(stale) != 0 {
+		t.Fatalf("expected 3 partitions gathered, got %v gets %v stats %v stale",
+			numGets, len(stats), len(stale))
+	}
+
+	// Later rounds gather the partitions never gathered, then the stalest
+	for round = 2; round <= 4; round++ {
+		numGets = 0
+		stats, stale = c.gather(partns, 3*time.Second, now, get)
+		if numGets != 3 {
+			t.Fatalf("round %v: expected 3 partitions gathered, got %v", round, numGets)
+		}
+	}
+	if len(stats) != 10 || len(stale) != 7 {
+		t.Fatalf("expected all stats with 7 stale, got %v stats %v stale", len(stats), len(stale))
+	}
+
+	seen := make(map[common.IndexInstId]int64)
+	for _, st := range stats {
+		seen[st.InstId] = st.Stats.DataSize
+	}
+	for i := 0; i < 10; i++ {
+		if seen[common.IndexInstId(i)] == 0 {
+			t.Fatalf("expected stats of partition %v gathered in some round", i)
+		}
+	}
+	for _, p := range partns {
+		if _, ok := stale[p.key]; !ok && seen[p.key.instId] != 4 {
+			t.Fatalf("expected fresh stats of partition %v from the last round", p.key.instId)
+		}
+	}
+
+	// A budget too small still gathers one partition
+	numGets = 0
+	c.gather(partns, time.Nanosecond, now, get)
+	c.wait()
+	if numGets != 1 {
+		t.Fatalf("expected 1 partition gathered, got %v", numGets)
+	}
+
+	// No budget gathers all of them
+	numGets = 0
+	if stats, stale = c.gather(partns, 0, now, get); numGets != 10 || len(stale) != 0 {
+		t.Fatalf("expected all partitions gathered, got %v gets %v stale", numGets, len(stale))
+	}
+
+	// Dropped partitions are forgotten
+	c.gather(partns[:5], 0, now, get)
+	if len(c.partns) != 5 {
+		t.Fatalf("expected 5 cached partitions, got %v", len(c.partns))
+	}
+}
+
+func TestStorageStatsCacheGatherStuck(t *testing.T) {
+	var partns []storageStatsPartn
+	for i := 0; i < 3; i++ {
+		partns = append(partns, storageStatsPartn{
+			key: storageStatsKey{instId: common.IndexInstId(i), partnId: 0},
+		})
+	}
+
+	stuck := make(chan bool)
+	getch := make(chan common.IndexInstId, 10)
+	get := func(p storageStatsPartn) (IndexStorageStats, bool) {
+		getch <- p.key.instId
+		if p.key.instId == 1 {
+			<-stuck
+		}
+		return IndexStorageStats{InstId: p.key.instId}, true
+	}
+
+	c := newStorageStatsCache()
+	c.gather(partns, 0, time.Now, func(p storageStatsPartn) (IndexStorageStats, bool) {
+		return IndexStorageStats{InstId: p.key.instId}, true
+	})
+
+	// The round returns once the budget is spent, with the stats of the stuck partition
+	// served from the cache
+	begin := time.Now()
+	stats, stale := c.gather(partns, 100*time.Millisecond, time.Now, get)
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Fatalf("expected round done within the budget, took %v", elapsed)
+	}
+	if len(stats) != 3 || len(stale) == 0 {
+		t.Fatalf("expected all stats with stale ones, got %v stats %v stale", len(stats), len(stale))
+	}
+
+	// No other gather starts while the stuck one is running
+	for len(getch) > 0 {
+		<-getch
+	}
+	if stats, _ = c.gather(partns, 100*time.Millisecond, time.Now, get); len(stats) != 3 {
+		t.Fatalf("expected all stats from the cache, got %v", len(stats))
+	}
+	if len(getch) != 0 {
+		t.Fatalf("expected no partition gathered while a gather is running")
+	}
+
+	close(stuck)
+	c.wait()
+	if _, stale = c.gather(partns, 0, time.Now, get); len(stale) != 0 {
+		t.Fatalf("expected all partitions gathered once the stuck one is done, got %v stale", len(stale))
+	}
+}
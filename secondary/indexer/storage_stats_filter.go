@@ -0,0 +1,97 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+
+	"github.com/couchbase/indexing/secondary/stats"
+)
+
+// Callers of /stats/storage can request a named set of storage stats with
+// consumerFilter=<name>, instead of the internal data of the storage of
+// each index, which is costly to collect:
+//
+//   planner     data_size, disk_size, memory_used: to size and place indexes
+//   ui          data_size, data_size_on_disk, log_space, disk_size,
+//               memory_used: the sizes and fragmentation shown in the UI
+//   autotune    memory_used, data_size, get_bytes, insert_bytes,
+//               delete_bytes: the memory and traffic of indexes to tune
+//               the memory quota
+//   compaction  data_size_on_disk, log_space, disk_size,
+//               extra_snap_data_size, need_upgrade: to decide when to
+//               compact or upgrade the storage
+//
+// The slices skip their internal data for a named set. Other filters, and
+// no filter, return the internal data as before.
+
+type storageStatsFilter struct {
+	filter uint64
+	fields []string
+}
+
+var storageStatsFilters = map[string]*storageStatsFilter{
+	"planner": {stats.PlannerFilter,
+		[]string{"data_size", "disk_size", "memory_used"}},
+	"ui": {stats.StorageUIFilter,
+		[]string{"data_size", "data_size_on_disk", "log_space", "disk_size", "memory_used"}},
+	"autotune": {stats.StorageAutotuneFilter,
+		[]string{"memory_used", "data_size", "get_bytes", "insert_bytes", "delete_bytes"}},
+	"compaction": {stats.StorageCompactionFilter,
+		[]string{"data_size_on_disk", "log_space", "disk_size", "extra_snap_data_size", "need_upgrade"}},
+}
+
+var storageStatsFields = map[string]func(sts *StorageStatistics) interface{}{
+	"data_size":            func(sts *StorageStatistics) interface{} { return sts.DataSize },
+	"data_size_on_disk":    func(sts *StorageStatistics) interface{} { return sts.DataSizeOnDisk },
+	"log_space":            func(sts *StorageStatistics) interface{} { return sts.LogSpace },
+	"disk_size":            func(sts *StorageStatistics) interface{} { return sts.DiskSize },
+	"memory_used":          func(sts *StorageStatistics) interface{} { return sts.MemUsed },
+	"extra_snap_data_size": func(sts *StorageStatistics) interface{} { return sts.ExtraSnapDataSize },
+	"get_bytes":            func(sts *StorageStatistics) interface{} { return sts.GetBytes },
+	"insert_bytes":         func(sts *StorageStatistics) interface{} { return sts.InsertBytes },
+	"delete_bytes":         func(sts *StorageStatistics) interface{} { return sts.DeleteBytes },
+	"need_upgrade":         func(sts *StorageStatistics) interface{} { return sts.NeedUpgrade },
+}
+
+// getStorageStatsFilter returns the named set of storage stats of
+// consumerFilter, or nil if it is not one.
+func getStorageStatsFilter(consumerFilter uint64) *storageStatsFilter {
+	for _, f := range storageStatsFilters {
+		if f.filter == consumerFilter {
+			return f
+		}
+	}
+	return nil
+}
+
+// needStorageInternalData returns true if the slices are to collect their
+// internal data for consumerFilter.
+func needStorageInternalData(consumerFilter uint64) bool {
+	return getStorageStatsFilter(consumerFilter) == nil
+}
+
+// marshal returns the stats of the set in sts, as a JSON object.
+func (f *storageStatsFilter) marshal(sts *StorageStatistics) ([]byte, error) {
+	values := make(map[string]interface{}, len(f.fields))
+	for _, field := range f.fields {
+		values[field] = storageStatsFields[field](sts)
+	}
+	return json.Marshal(values)
+}
+
+// OverrideStorageFilter sets the filter of a storage stats request to the
+// named set of storage stats filt, or to the stats filter filt otherwise.
+func (spec *statsSpec) OverrideStorageFilter(filt string) {
+	if f, ok := storageStatsFilters[filt]; ok {
+		spec.consumerFilter = f.filter
+		return
+	}
+	spec.OverrideFilter(filt)
+}
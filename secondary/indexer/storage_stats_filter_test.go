@@ -0,0 +1,62 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/stats"
+)
+
+func TestStorageStatsFilters(t *testing.T) {
+	for _, filter := range []uint64{0, stats.AllStatsFilter, stats.N1QLStorageStatsFilter} {
+		if !needStorageInternalData(filter) {
+			t.Fatalf("expected internal data for filter %x", filter)
+		}
+	}
+
+	sts := StorageStatistics{DataSize: 10, DiskSize: 20, MemUsed: 30, LogSpace: 40,
+		InternalData: []string{"internal"}}
+
+	for name, f := range storageStatsFilters {
+		if needStorageInternalData(f.filter) {
+			t.Fatalf("expected no internal data for %v", name)
+		}
+		if getStorageStatsFilter(f.filter) != f {
+			t.Fatalf("expected filter %x to be %v", f.filter, name)
+		}
+
+		data, err := f.marshal(&sts)
+		if err != nil {
+			t.Fatalf("unable to marshal %v stats: %v", name, err)
+		}
+		values := make(map[string]interface{})
+		if err := json.Unmarshal(data, &values); err != nil {
+			t.Fatalf("invalid %v stats %s: %v", name, data, err)
+		}
+		if len(values) != len(f.fields) {
+			t.Fatalf("expected %v stats %v, got %s", name, f.fields, data)
+		}
+	}
+
+	spec := &statsSpec{}
+	spec.OverrideStorageFilter("ui")
+	if spec.consumerFilter != stats.StorageUIFilter {
+		t.Fatalf("expected the ui filter, got %x", spec.consumerFilter)
+	}
+	spec.OverrideStorageFilter("n1qlStorageStats")
+	if spec.consumerFilter != stats.N1QLStorageStatsFilter {
+		t.Fatalf("expected the n1qlStorageStats filter, got %x", spec.consumerFilter)
+	}
+	spec.OverrideStorageFilter("unknown")
+	if spec.consumerFilter != stats.AllStatsFilter {
+		t.Fatalf("expected all stats for an unknown filter, got %x", spec.consumerFilter)
+	}
+}
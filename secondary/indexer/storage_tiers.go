@@ -0,0 +1,349 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Slices can be placed on storage tiers by how often they are scanned. With
+// storage.tiers.coldPath set, e.g. to a HDD or a network volume, the storage
+// manager tracks the scans of each slice with the storage stats, and a slice
+// not scanned for storage.tiers.coldAfter seconds is cold. The tier of each
+// slice is saved to storageTiersFile in the storage dir.
+//
+// The files of an open slice are in use, so a slice is moved to its tier
+// when it is next opened: a cold slice is moved to the cold path and its
+// path in the storage dir becomes a symlink to it, and a hot slice is moved
+// back. A slice is first copied, and the copy only replaces the slice once
+// complete, so that a move interrupted by a crash is completed or undone the
+// next time. Storage stats report the tier of each slice.
+
+type StorageTier string
+
+const (
+	STORAGE_TIER_HOT  StorageTier = "hot"
+	STORAGE_TIER_COLD StorageTier = "cold"
+)
+
+const storageTiersFile = "storage_tiers.json"
+
+// Access times are saved at most every storageTiersSaveInterval, unless a
+// tier changes
+const storageTiersSaveInterval = 5 * time.Minute
+
+// sliceAccess is the scan activity of a slice.
+type sliceAccess struct {
+	Tier       StorageTier `json:"tier"`
+	LastAccess time.Time   `json:"lastAccess"`
+
+	numRequests int64
+	seen        bool // numRequests seen since loaded
+}
+
+type storageTiers struct {
+	slices   map[string]*sliceAccess // by slice dir name
+	lastSave time.Time
+}
+
+func newStorageTiers() *storageTiers {
+	return &storageTiers{slices: make(map[string]*sliceAccess)}
+}
+
+// loadStorageTiers returns the tiers saved in storageDir, or none if they
+// cannot be read.
+func loadStorageTiers(storageDir string) *storageTiers {
+	t := newStorageTiers()
+
+	bs, err := ioutil.ReadFile(filepath.Join(storageDir, storageTiersFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Errorf("loadStorageTiers: unable to read %v, err %v", storageTiersFile, err)
+		}
+		return t
+	}
+
+	if err := json.Unmarshal(bs, &t.slices); err != nil {
+		logging.Errorf("loadStorageTiers: unable to unmarshal %v, err %v", storageTiersFile, err)
+		return newStorageTiers()
+	}
+	return t
+}
+
+func (t *storageTiers) save(storageDir string) error {
+	bs, err := json.Marshal(t.slices)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(storageDir, storageTiersFile)
+	if err := common.WriteFileWithSync(path+".tmp", bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// update records the number of scans of slice name at now, and returns true
+// if its tier changed.
+func (t *storageTiers) update(name string, numRequests int64, now time.Time,
+	coldAfter time.Duration) bool {
+
+	a, ok := t.slices[name]
+	if !ok {
+		a = &sliceAccess{Tier: STORAGE_TIER_HOT, LastAccess: now}
+		t.slices[name] = a
+	} else if a.seen && numRequests != a.numRequests {
+		a.LastAccess = now
+	}
+	a.numRequests = numRequests
+	a.seen = true
+
+	tier := STORAGE_TIER_HOT
+	if now.Sub(a.LastAccess) >= coldAfter {
+		tier = STORAGE_TIER_COLD
+	}
+
+	changed := tier != a.Tier
+	a.Tier = tier
+	return changed
+}
+
+// retain forgets the slices not in names.
+func (t *storageTiers) retain(names map[string]bool) {
+	for name := range t.slices {
+		if !names[name] {
+			delete(t.slices, name)
+		}
+	}
+}
+
+// tier returns the tier of slice name, hot if unknown.
+func (t *storageTiers) tier(name string) StorageTier {
+	if a, ok := t.slices[name]; ok && a.Tier == STORAGE_TIER_COLD {
+		return STORAGE_TIER_COLD
+	}
+	return STORAGE_TIER_HOT
+}
+
+// updateStorageTiers updates the tiers of the slices with their scans, and
+// saves them if needed.
+func (s *storageMgr) updateStorageTiers(storageStats []IndexStorageStats,
+	stats *IndexerStats, indexInstMap common.IndexInstMap, cfg common.Config, now time.Time) {
+
+	if cfg["storage.tiers.coldPath"].String() == "" {
+		s.tiers = nil
+		return
+	}
+
+	storageDir := cfg["storage_dir"].String()
+	if s.tiers == nil {
+		s.tiers = loadStorageTiers(storageDir)
+	}
+
+	coldAfter := time.Duration(cfg["storage.tiers.coldAfter"].Uint64()) * time.Second
+
+	changed := false
+	names := make(map[string]bool)
+	for _, st := range storageStats {
+		inst, ok := indexInstMap[st.InstId]
+		if !ok || inst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		idxStats := stats.GetPartitionStats(st.InstId, st.PartnId)
+		if idxStats == nil {
+			continue
+		}
+
+		name := IndexPath(&inst, st.PartnId, SliceId(0))
+		names[name] = true
+		if s.tiers.update(name, idxStats.numRequests.Value(), now, coldAfter) {
+			storageMgrLog.Infof("StorageManager::updateStorageTiers Slice %v is %v. It will be "+
+				"moved when next opened.", name, s.tiers.tier(name))
+			changed = true
+		}
+	}
+	s.tiers.retain(names)
+
+	if changed || now.Sub(s.tiers.lastSave) >= storageTiersSaveInterval {
+		if err := s.tiers.save(storageDir); err != nil {
+			storageMgrLog.Errorf("StorageManager::updateStorageTiers Unable to save %v, err %v",
+				storageTiersFile, err)
+			return
+		}
+		s.tiers.lastSave = now
+	}
+}
+
+// sliceTier returns the tier the slice at path is on.
+func sliceTier(path string) StorageTier {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return STORAGE_TIER_COLD
+	}
+	return STORAGE_TIER_HOT
+}
+
+// coldSliceDir returns the dir of the slice at path on the cold tier, or ""
+// if it is hot.
+func coldSliceDir(path string) string {
+	if sliceTier(path) != STORAGE_TIER_COLD {
+		return ""
+	}
+	dir, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// placeSlice moves the slice at path, which is not open, to tier. Cold
+// slices are moved to coldPath.
+func placeSlice(path string, tier StorageTier, coldPath string) error {
+	staging := path + ".tiering"
+
+	// Complete or undo an interrupted move. The staging dir is a complete
+	// copy of the slice if the slice is missing.
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(staging); err == nil {
+			logging.Infof("placeSlice: recovering slice %v from %v", path, staging)
+			if err := os.Rename(staging, path); err != nil {
+				return err
+			}
+		}
+	}
+	os.RemoveAll(staging)
+
+	if _, err := os.Lstat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if sliceTier(path) == tier {
+		return nil
+	}
+
+	t0 := time.Now()
+	switch tier {
+	case STORAGE_TIER_COLD:
+		if coldPath == "" {
+			return fmt.Errorf("no cold path to move slice %v to", path)
+		}
+		if err := os.MkdirAll(coldPath, 0755); err != nil {
+			return err
+		}
+
+		coldDir := filepath.Join(coldPath, filepath.Base(path))
+		if err := copySliceDir(path, coldDir); err != nil {
+			return err
+		}
+
+		if err := os.Rename(path, staging); err != nil {
+			return err
+		}
+		if err := os.Symlink(coldDir, path); err != nil {
+			return err
+		}
+		os.RemoveAll(staging)
+
+	case STORAGE_TIER_HOT:
+		coldDir, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+
+		if err := copyDir(coldDir, staging); err != nil {
+			os.RemoveAll(staging)
+			return err
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.Rename(staging, path); err != nil {
+			return err
+		}
+		os.RemoveAll(coldDir)
+	}
+
+	logging.Infof("placeSlice: moved slice %v to the %v tier. Took %v", path, tier, time.Since(t0))
+	return nil
+}
+
+// copySliceDir copies the dir src to dst, replacing dst if it exists. dst
+// only exists once the copy is complete.
+func copySliceDir(src, dst string) error {
+	tmp := dst + ".tmp"
+	os.RemoveAll(tmp)
+	if err := copyDir(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	os.RemoveAll(dst)
+	return os.Rename(tmp, dst)
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, fi.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
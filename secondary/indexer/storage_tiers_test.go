@@ -0,0 +1,116 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageTiers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storagetiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	coldAfter := time.Hour
+
+	tiers := newStorageTiers()
+	tiers.update("a", 0, now, coldAfter)
+	tiers.update("b", 0, now, coldAfter)
+
+	now = now.Add(coldAfter)
+	if !tiers.update("a", 0, now, coldAfter) || tiers.tier("a") != STORAGE_TIER_COLD {
+		t.Fatalf("expected slice a cold without scans")
+	}
+	if tiers.update("b", 5, now, coldAfter) || tiers.tier("b") != STORAGE_TIER_HOT {
+		t.Fatalf("expected slice b hot after scans")
+	}
+
+	if err := tiers.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	tiers = loadStorageTiers(dir)
+	if tiers.tier("a") != STORAGE_TIER_COLD || tiers.tier("b") != STORAGE_TIER_HOT {
+		t.Fatalf("expected tiers loaded, got %+v", tiers.slices)
+	}
+
+	// The scans before a restart are not an access
+	now = now.Add(coldAfter)
+	tiers.update("b", 5, now, coldAfter)
+	if tiers.tier("b") != STORAGE_TIER_COLD {
+		t.Fatalf("expected slice b cold without scans since loaded")
+	}
+	tiers.update("a", 0, now, coldAfter)
+	if !tiers.update("a", 1, now.Add(time.Second), coldAfter) || tiers.tier("a") != STORAGE_TIER_HOT {
+		t.Fatalf("expected slice a hot after a scan")
+	}
+
+	tiers.retain(map[string]bool{"a": true})
+	if _, ok := tiers.slices["b"]; ok {
+		t.Fatalf("expected slice b forgotten")
+	}
+}
+
+func TestPlaceSlice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "placeslice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hot", "bucket_idx_1_0.index")
+	coldPath := filepath.Join(dir, "cold")
+	if err := os.MkdirAll(filepath.Join(path, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "data", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSlice := func(tier StorageTier) {
+		if sliceTier(path) != tier {
+			t.Fatalf("expected slice on the %v tier", tier)
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(path, "data", "file"))
+		if err != nil || string(bs) != "data" {
+			t.Fatalf("expected slice data on the %v tier, got %s, %v", tier, bs, err)
+		}
+	}
+
+	if err := placeSlice(path, STORAGE_TIER_COLD, coldPath); err != nil {
+		t.Fatal(err)
+	}
+	checkSlice(STORAGE_TIER_COLD)
+	if coldSliceDir(path) != filepath.Join(coldPath, filepath.Base(path)) {
+		t.Fatalf("expected slice in %v, got %v", coldPath, coldSliceDir(path))
+	}
+
+	if err := placeSlice(path, STORAGE_TIER_HOT, coldPath); err != nil {
+		t.Fatal(err)
+	}
+	checkSlice(STORAGE_TIER_HOT)
+	if _, err := os.Stat(filepath.Join(coldPath, filepath.Base(path))); !os.IsNotExist(err) {
+		t.Fatalf("expected slice removed from the cold tier, got %v", err)
+	}
+
+	// A move interrupted after the slice was replaced by its copy
+	if err := os.Rename(path, path+".tiering"); err != nil {
+		t.Fatal(err)
+	}
+	if err := placeSlice(path, STORAGE_TIER_HOT, coldPath); err != nil {
+		t.Fatal(err)
+	}
+	checkSlice(STORAGE_TIER_HOT)
+}
@@ -453,7 +453,6 @@ func (r *mutationStreamReader) maybeSendSync(fastpath bool) bool {
 
 	hwt := make(map[string]*common.TsVbuuid)
 	prevSnap := make(map[string]*common.TsVbuuid)
-	numVbuckets := r.config["numVbuckets"].Int()
 
 	var hwtOSOMap map[string]*common.TsVbuuid
 	var hwtOSO *common.TsVbuuid
@@ -463,13 +462,15 @@ func (r *mutationStreamReader) maybeSendSync(fastpath bool) bool {
 	r.queueMapLock.RLock()
 	for keyspaceId := range r.keyspaceIdQueueMap {
 		//actual TS uses bucket as keyspaceId
-		hwt[keyspaceId] = common.NewTsVbuuidCached(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
-		prevSnap[keyspaceId] = common.NewTsVbuuidCached(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
+		bucket := GetBucketFromKeyspaceId(keyspaceId)
+		numVbuckets := getNumVBuckets(bucket, r.config)
+		hwt[keyspaceId] = common.NewTsVbuuidCached(bucket, numVbuckets)
+		prevSnap[keyspaceId] = common.NewTsVbuuidCached(bucket, numVbuckets)
 		if r.keyspaceIdEnableOSO[keyspaceId] {
 			if hwtOSOMap == nil {
 				hwtOSOMap = make(map[string]*common.TsVbuuid)
 			}
-			hwtOSOMap[keyspaceId] = common.NewTsVbuuidCached(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
+			hwtOSOMap[keyspaceId] = common.NewTsVbuuidCached(bucket, numVbuckets)
 		}
 	}
 
@@ -480,6 +481,7 @@ func (r *mutationStreamReader) maybeSendSync(fastpath bool) bool {
 		enableOSO := false
 		sessionId := uint64(0)
 		hwtOSO = nil
+		numVbuckets := len(hwt[keyspaceId].Seqnos)
 		for i := 0; i < nWrkr; i++ {
 
 			r.streamWorkers[i].lock.Lock()
@@ -897,6 +899,14 @@ func (w *streamWorker) handleSingleKeyVersion(keyspaceId string, vbucket Vbucket
 		case common.OSOSnapshotStart, common.OSOSnapshotEnd:
 			w.updateOSOMarkerInFilter(meta, byte(cmd))
 			w.processDcpOSOMarker(meta, byte(cmd))
+
+		case common.EvalSkip:
+			//send message to supervisor to stop scans of the index
+			w.reader.supvRespch <- &MsgEvalSkip{
+				streamId: w.streamId,
+				meta:     meta.Clone(),
+				instId:   common.IndexInstId(kv.GetUuids()[i]),
+			}
 		}
 	}
 
@@ -354,7 +354,7 @@ func (ss *StreamState) initNewStream(streamId common.StreamId) {
 func (ss *StreamState) initKeyspaceIdInStream(streamId common.StreamId,
 	keyspaceId string) {
 
-	numVbuckets := ss.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 
 	bucket := GetBucketFromKeyspaceId(keyspaceId)
 	ss.streamKeyspaceIdHWTMap[streamId][keyspaceId] = common.NewTsVbuuid(bucket, numVbuckets)
@@ -595,7 +595,7 @@ func (ss *StreamState) computeRollbackTs(streamId common.StreamId, keyspaceId st
 		// Get restart Ts
 		restartTs := ss.computeRestartTs(streamId, keyspaceId)
 		if restartTs == nil {
-			numVbuckets := ss.config["numVbuckets"].Int()
+			numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 			restartTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 		} else {
 			ss.adjustNonSnapAlignedVbs(restartTs, streamId, keyspaceId, nil, false)
@@ -666,7 +666,7 @@ func (ss *StreamState) getRepairTsForKeyspaceId(streamId common.StreamId,
 
 	anythingToRepair := false
 
-	numVbuckets := ss.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 	repairTs := common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 
 	var shutdownVbs []Vbucket = nil
@@ -907,7 +907,7 @@ func (ss *StreamState) addKVRollbackTs(streamId common.StreamId, keyspaceId stri
 
 	rollbackTs := ss.streamKeyspaceIdKVRollbackTsMap[streamId][keyspaceId]
 	if rollbackTs == nil {
-		numVbuckets := ss.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 		rollbackTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 		ss.streamKeyspaceIdKVRollbackTsMap[streamId][keyspaceId] = rollbackTs
 	}
@@ -979,7 +979,7 @@ func (ss *StreamState) addKVActiveTs(streamId common.StreamId, keyspaceId string
 
 	activeTs := ss.streamKeyspaceIdKVActiveTsMap[streamId][keyspaceId]
 	if activeTs == nil {
-		numVbuckets := ss.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 		activeTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 		ss.streamKeyspaceIdKVActiveTsMap[streamId][keyspaceId] = activeTs
 	}
@@ -1015,7 +1015,7 @@ func (ss *StreamState) addKVPendingTs(streamId common.StreamId, keyspaceId strin
 
 	pendingTs := ss.streamKeyspaceIdKVPendingTsMap[streamId][keyspaceId]
 	if pendingTs == nil {
-		numVbuckets := ss.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 		pendingTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 		ss.streamKeyspaceIdKVPendingTsMap[streamId][keyspaceId] = pendingTs
 	}
@@ -1051,7 +1051,7 @@ func (ss *StreamState) setLastRepairTime(streamId common.StreamId, keyspaceId st
 
 	repairTimeMap := ss.streamKeyspaceIdLastRepairTimeMap[streamId][keyspaceId]
 	if repairTimeMap == nil {
-		numVbuckets := ss.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 		repairTimeMap = NewTimestamp(numVbuckets)
 		ss.streamKeyspaceIdLastRepairTimeMap[streamId][keyspaceId] = repairTimeMap
 	}
@@ -1097,7 +1097,7 @@ func (ss *StreamState) clearAllRepairState(streamId common.StreamId, keyspaceId
 
 	logging.Infof("StreamState::clear all repair state for %v keyspaceId %v", streamId, keyspaceId)
 
-	numVbuckets := ss.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 	bucket := GetBucketFromKeyspaceId(keyspaceId)
 	ss.streamKeyspaceIdRepairStateMap[streamId][keyspaceId] = make([]RepairState, numVbuckets)
 	ss.streamKeyspaceIdLastBeginTime[streamId][keyspaceId] = 0
@@ -1114,7 +1114,7 @@ func (ss *StreamState) clearAllRepairState(streamId common.StreamId, keyspaceId
 //
 func (ss *StreamState) seenAllVbs(streamId common.StreamId, keyspaceId string) bool {
 
-	numVbuckets := ss.config["numVbuckets"].Int()
+	numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 	vbs := ss.streamKeyspaceIdVbStatusMap[streamId][keyspaceId]
 
 	for i := 0; i < numVbuckets; i++ {
@@ -1136,7 +1136,7 @@ func (ss *StreamState) canRollbackNow(streamId common.StreamId, keyspaceId strin
 		needsRollback = true
 
 		numRollback := ss.numKVRollbackTs(streamId, keyspaceId)
-		numVbuckets := ss.config["numVbuckets"].Int()
+		numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), ss.config)
 
 		waitTime := int64(ss.config["timekeeper.rollback.StreamBeginWaitTime"].Int()) * int64(time.Second)
 		exceedWaitTime := time.Now().UnixNano()-int64(ss.streamKeyspaceIdLastBeginTime[streamId][keyspaceId]) > waitTime
@@ -1567,6 +1567,20 @@ func (ss *StreamState) canFlushNewTS(streamId common.StreamId,
 
 }
 
+//returns the number of keyspaces with a flush in progress, across streams
+func (ss *StreamState) numFlushInProgress() int {
+
+	count := 0
+	for _, keyspaceIdFlushInProgressTsMap := range ss.streamKeyspaceIdFlushInProgressTsMap {
+		for _, ts := range keyspaceIdFlushInProgressTsMap {
+			if ts != nil {
+				count++
+			}
+		}
+	}
+	return count
+}
+
 //computes which vbuckets have mutations compared to last flush
 func (ss *StreamState) computeTsChangeVec(streamId common.StreamId,
 	keyspaceId string, tsElem *TsListElem) ([]bool, bool, []uint64) {
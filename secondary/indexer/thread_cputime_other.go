@@ -0,0 +1,21 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+//go:build !linux
+// +build !linux
+
+package indexer
+
+import (
+	"time"
+)
+
+// threadCPUTime is not available on this platform.
+func threadCPUTime() (time.Duration, bool) {
+	return 0, false
+}
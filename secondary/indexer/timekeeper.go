@@ -46,6 +46,10 @@ type timekeeper struct {
 	// Lock to protect simultaneous update of stats by multiple go-routines
 	statsLock sync.Mutex
 
+	buildProgress *buildProgressTracker // protected by statsLock
+
+	flushSched *flushScheduler // protected by lock
+
 	stats           IndexerStatsHolder
 	vbCheckerStopCh map[common.StreamId]chan bool
 
@@ -93,6 +97,8 @@ func NewTimekeeper(supvCmdch MsgChannel, supvRespch MsgChannel, config common.Co
 		vbCheckerStopCh:   make(map[common.StreamId]chan bool),
 		cinfoProvider:     cip,
 		cinfoProviderLock: cipLock,
+		buildProgress:     newBuildProgressTracker(),
+		flushSched:        newFlushScheduler(),
 	}
 
 	tk.indexInstMap.Init()
@@ -682,6 +688,8 @@ func (tk *timekeeper) handleFlushDone(cmd Message) {
 		logging.Errorf("Timekeeper::handleFlushDone Invalid StreamId %v ", streamId)
 	}
 
+	//the flush slot can go to a keyspace waiting for one
+	tk.dispatchPendingFlush()
 }
 
 func (tk *timekeeper) processFlushAbort(streamId common.StreamId, keyspaceId string) {
@@ -1945,7 +1953,7 @@ func (tk *timekeeper) handleStreamRequestDone(cmd Message) {
 	}
 
 	if openTs == nil {
-		openTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), tk.config["numVbuckets"].Int())
+		openTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), tk.config))
 	}
 
 	tk.ss.streamKeyspaceIdOpenTsMap[streamId][keyspaceId] = openTs
@@ -2062,7 +2070,7 @@ func (tk *timekeeper) handleRecoveryDone(cmd Message) {
 	}
 
 	if openTs == nil {
-		openTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), tk.config["numVbuckets"].Int())
+		openTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), tk.config))
 	}
 
 	tk.resetWaitForRecovery(streamId, keyspaceId)
@@ -2076,7 +2084,7 @@ func (tk *timekeeper) handleRecoveryDone(cmd Message) {
 		if mergeTs == nil {
 			logging.Infof("Timekeeper::handleRecoveryDone %v %v. Received nil mergeTs. "+
 				"Considering it as rollback to 0", streamId, keyspaceId)
-			numVbuckets := tk.config["numVbuckets"].Int()
+			numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), tk.config)
 			mergeTs = common.NewTsVbuuid(GetBucketFromKeyspaceId(keyspaceId), numVbuckets)
 		}
 		tk.setMergeTs(streamId, keyspaceId, mergeTs)
@@ -2813,7 +2821,8 @@ func (tk *timekeeper) generateNewStabilityTS(streamId common.StreamId,
 	if tk.ss.checkNewTSDue(streamId, keyspaceId) {
 		tsElem := tk.ss.getNextStabilityTS(streamId, keyspaceId)
 
-		if tk.ss.canFlushNewTS(streamId, keyspaceId) {
+		if tk.ss.canFlushNewTS(streamId, keyspaceId) &&
+			tk.flushSlotAvailable(streamId, keyspaceId) {
 			tk.sendNewStabilityTS(tsElem, keyspaceId, streamId)
 		} else {
 			//store the ts in list
@@ -2891,6 +2900,10 @@ func (tk *timekeeper) processPendingTS(streamId common.StreamId, keyspaceId stri
 	// If there are pending TS for this keyspaceId, send the oldest one to indexer
 	tsList := tk.ss.streamKeyspaceIdTsListMap[streamId][keyspaceId]
 	if tsList.Len() > 0 {
+		if !tk.flushSlotAvailable(streamId, keyspaceId) {
+			return false
+		}
+
 		e := tsList.Front()
 		tsElem := e.Value.(*TsListElem)
 		tsVbuuid := tsElem.ts
@@ -4010,6 +4023,11 @@ func (tk *timekeeper) handleStats(cmd Message) {
 
 	go func() {
 
+		flushLag := computeFlushLag(indexInstMap, tk.stats.Get())
+		tk.lock.Lock()
+		tk.flushSched.setLag(flushLag)
+		tk.lock.Unlock()
+
 		if !req.FetchDcp() {
 			tk.updateTimestampStats()
 			replych <- true
@@ -4037,7 +4055,7 @@ func (tk *timekeeper) handleStats(cmd Message) {
 			if _, ok := keyspaceIdTsMap[stream][keyspaceId]; !ok {
 				rh := common.NewRetryHelper(maxStatsRetries, time.Second, 1, func(a int, err error) error {
 					cluster := tk.config["clusterAddr"].String()
-					numVbuckets := tk.config["numVbuckets"].Int()
+					numVbuckets := getNumVBuckets(GetBucketFromKeyspaceId(keyspaceId), tk.config)
 					cid := ""
 					if inst.Stream == common.INIT_STREAM && inst.Defn.KeyspaceId(inst.Stream) != inst.Defn.Bucket {
 						cid = keyspaceIdCollectionId[keyspaceId]
@@ -4060,7 +4078,8 @@ func (tk *timekeeper) handleStats(cmd Message) {
 		flushedCountMap := make(map[common.StreamId]map[string]uint64)
 		queuedMap := make(map[common.StreamId]map[string]uint64)
 		pendingMap := make(map[common.StreamId]map[string]uint64)
-		totalTobeFlushedMap := make(map[common.StreamId]map[string]uint64)
+		buildDoneMap := make(map[common.StreamId]map[string]uint64)
+		buildTotalMap := make(map[common.StreamId]map[string]uint64)
 
 		func() {
 			tk.lock.Lock()
@@ -4069,6 +4088,7 @@ func (tk *timekeeper) handleStats(cmd Message) {
 			flushedTsMap := tk.ss.streamKeyspaceIdLastFlushedTsMap
 			receivedTsMap := tk.ss.streamKeyspaceIdHWTMap
 			receivedTsOSOMap := tk.ss.streamKeyspaceIdHWTOSO
+			openTsMap := tk.ss.streamKeyspaceIdOpenTsMap
 			rollbackTimeMap = tk.ss.CloneKeyspaceIdRollbackTime()
 
 			// Pre-compute flushedCount for all streams and keyspaceId's
@@ -4122,12 +4142,12 @@ func (tk *timekeeper) handleStats(cmd Message) {
 			for stream, keyspaceIdMap := range keyspaceIdTsMap {
 				if _, ok := pendingMap[stream]; !ok {
 					pendingMap[stream] = make(map[string]uint64)
-					totalTobeFlushedMap[stream] = make(map[string]uint64)
+					buildDoneMap[stream] = make(map[string]uint64)
+					buildTotalMap[stream] = make(map[string]uint64)
 				}
 
 				for keyspaceId, kvTs := range keyspaceIdMap {
 					pending := uint64(0)
-					sum := uint64(0)
 					if kvTs != nil {
 
 						// Get receivedTs for this keyspaceId
@@ -4135,7 +4155,6 @@ func (tk *timekeeper) handleStats(cmd Message) {
 						recvTsOSO := receivedTsOSOMap[stream][keyspaceId]
 
 						for i, seqno := range kvTs {
-							sum += seqno
 							receivedSeqno := uint64(0)
 							if receivedTs != nil {
 								receivedSeqno = receivedTs.Seqnos[i]
@@ -4154,11 +4173,30 @@ func (tk *timekeeper) handleStats(cmd Message) {
 						}
 					}
 					pendingMap[stream][keyspaceId] = pending
-					totalTobeFlushedMap[stream][keyspaceId] = sum
+
+					done, total := estimateBuildProgress(openTsMap[stream][keyspaceId],
+						flushedTsMap[stream][keyspaceId], kvTs)
+					buildDoneMap[stream][keyspaceId] = done
+					buildTotalMap[stream][keyspaceId] = total
 				}
 			}
 		}()
 
+		// Estimate percent complete and time remaining for building keyspaces
+		percentMap := make(map[common.StreamId]map[string]float64)
+		etaMap := make(map[common.StreamId]map[string]int64)
+		for stream, keyspaceIdMap := range buildTotalMap {
+			percentMap[stream] = make(map[string]float64)
+			etaMap[stream] = make(map[string]int64)
+			for keyspaceId, total := range keyspaceIdMap {
+				percent, eta := tk.buildProgress.update(stream, keyspaceId,
+					buildDoneMap[stream][keyspaceId], total, progressStatTime)
+				percentMap[stream][keyspaceId] = percent
+				etaMap[stream][keyspaceId] = eta
+			}
+		}
+		tk.buildProgress.retain(keyspaceIdTsMap)
+
 		stats := tk.stats.Get()
 		for instId, inst := range indexInstMap {
 			//skip deleted indexes
@@ -4170,18 +4208,18 @@ func (tk *timekeeper) handleStats(cmd Message) {
 			keyspaceId := inst.Defn.KeyspaceId(inst.Stream)
 			idxStats := stats.indexes[instId]
 			v := float64(0)
+			eta := int64(0)
 			switch inst.State {
 			default:
 				v = 0.00
 			case common.INDEX_STATE_ACTIVE:
 				v = 100.00
 			case common.INDEX_STATE_INITIAL, common.INDEX_STATE_CATCHUP:
-				totalToBeflushed := totalTobeFlushedMap[stream][keyspaceId]
-				flushedCount := flushedCountMap[stream][keyspaceId]
-				if totalToBeflushed > flushedCount {
-					v = float64(flushedCount) * 100.00 / float64(totalToBeflushed)
+				if percent, ok := percentMap[stream][keyspaceId]; ok {
+					v = percent
+					eta = etaMap[stream][keyspaceId]
 				} else {
-					v = 100.00
+					eta = -1
 				}
 			}
 
@@ -4192,6 +4230,7 @@ func (tk *timekeeper) handleStats(cmd Message) {
 				idxStats.numDocsPending.Set(int64(pendingMap[stream][keyspaceId]))
 				idxStats.buildProgress.Set(int64(v))
 				idxStats.completionProgress.Set(int64(math.Float64bits(v)))
+				idxStats.buildEta.Set(eta)
 				idxStats.lastRollbackTime.Set(rollbackTimeMap[keyspaceId])
 				idxStats.progressStatTime.Set(progressStatTime)
 			}
@@ -4298,24 +4337,45 @@ func (tk *timekeeper) checkPendingStreamMerge(streamId common.StreamId,
 }
 
 //startTimer starts a per stream/keyspaceId timer to periodically check and
-//generate a new stability timestamp
+//generate a new stability timestamp. The interval of the timer adapts to the
+//mutation rate of the keyspace, see snapCadence.
 func (tk *timekeeper) startTimer(streamId common.StreamId,
 	keyspaceId string) {
 
 	logging.Infof("Timekeeper::startTimer %v %v", streamId, keyspaceId)
 
-	snapInterval := tk.getInMemSnapInterval()
-	ticker := time.NewTicker(time.Millisecond * time.Duration(snapInterval))
+	interval := time.Millisecond * time.Duration(tk.getInMemSnapInterval())
 	stopCh := tk.ss.streamKeyspaceIdTimerStopCh[streamId][keyspaceId]
 
+	var cadence *snapCadence
+	if tk.config["settings.inmemory_snapshot.adaptive.enable"].Bool() {
+		cadence = newSnapCadence(interval,
+			time.Millisecond*time.Duration(tk.config["settings.inmemory_snapshot.adaptive.min_interval"].Uint64()),
+			time.Millisecond*time.Duration(tk.config["settings.inmemory_snapshot.adaptive.max_interval"].Uint64()),
+			tk.config["settings.inmemory_snapshot.adaptive.burst_factor"].Float64(),
+			float64(tk.config["settings.inmemory_snapshot.adaptive.quiet_rate"].Uint64()),
+			tk.numMutationsQueued(streamId, keyspaceId), time.Now())
+	}
+	tk.setSnapIntervalStat(streamId, keyspaceId, interval)
+
 	go func() {
+		timer := time.NewTimer(interval)
 		for {
 			select {
-			case <-ticker.C:
+			case <-timer.C:
 				tk.generateNewStabilityTS(streamId, keyspaceId)
 
+				if cadence != nil {
+					next := cadence.next(tk.numMutationsQueued(streamId, keyspaceId), time.Now())
+					if next != interval {
+						interval = next
+						tk.setSnapIntervalStat(streamId, keyspaceId, interval)
+					}
+				}
+				timer.Reset(interval)
+
 			case <-stopCh:
-				ticker.Stop()
+				timer.Stop()
 				return
 			}
 		}
@@ -4323,6 +4383,22 @@ func (tk *timekeeper) startTimer(streamId common.StreamId,
 
 }
 
+//numMutationsQueued returns the count of mutations queued for the
+//stream/keyspaceId, or 0 if its stats are not available.
+func (tk *timekeeper) numMutationsQueued(streamId common.StreamId, keyspaceId string) int64 {
+	if keyspaceStats := tk.stats.GetKeyspaceStats(streamId, keyspaceId); keyspaceStats != nil {
+		return keyspaceStats.numMutationsQueued.Value()
+	}
+	return 0
+}
+
+func (tk *timekeeper) setSnapIntervalStat(streamId common.StreamId, keyspaceId string,
+	interval time.Duration) {
+	if keyspaceStats := tk.stats.GetKeyspaceStats(streamId, keyspaceId); keyspaceStats != nil {
+		keyspaceStats.snapInterval.Set(int64(interval / time.Millisecond))
+	}
+}
+
 //stopTimer stops the stream/keyspaceId timer started by startTimer
 func (tk *timekeeper) stopTimer(streamId common.StreamId, keyspaceId string) {
 
@@ -0,0 +1,116 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Plasma slices can tune their number of writers to the mutation rate,
+// with plasma.writer.tuning.enable for all indexes of the node. The
+// settings of some indexes can be overridden with
+// plasma.writer.tuning.overrides, a JSON object from
+// "bucket.scope.collection.index", "bucket.scope.collection" or "bucket"
+// to the settings to override, e.g.
+//
+//   {"travel.inventory.hotel": {"enable": true, "adjust_interval": 200}}
+//
+// to trial writer tuning on one workload. The most specific key wins for
+// each setting. All settings can be changed at runtime; slices which stop
+// tuning go back to their initial number of writers.
+
+// writerTuningOverride holds the overridden writer tuning settings of
+// an index or keyspace.
+type writerTuningOverride struct {
+	Enable           *bool `json:"enable,omitempty"`
+	AdjustInterval   *int  `json:"adjust_interval,omitempty"`   // millis
+	SamplingInterval *int  `json:"sampling_interval,omitempty"` // millis
+}
+
+// writerTuningSettings are the writer tuning settings of an index.
+type writerTuningSettings struct {
+	enable           bool
+	adjustInterval   uint64 // nanos
+	samplingInterval uint64 // nanos
+}
+
+// parseWriterTuningOverrides parses plasma.writer.tuning.overrides.
+func parseWriterTuningOverrides(value string) (map[string]writerTuningOverride, error) {
+	overrides := make(map[string]writerTuningOverride)
+	if value == "" {
+		return overrides, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return nil, err
+	}
+	for key, o := range overrides {
+		if o.AdjustInterval != nil && *o.AdjustInterval <= 0 {
+			return nil, fmt.Errorf("invalid adjust_interval %v for %v", *o.AdjustInterval, key)
+		}
+		if o.SamplingInterval != nil && *o.SamplingInterval <= 0 {
+			return nil, fmt.Errorf("invalid sampling_interval %v for %v", *o.SamplingInterval, key)
+		}
+	}
+	return overrides, nil
+}
+
+// getWriterTuningSettings returns the writer tuning settings of defn.
+func getWriterTuningSettings(cfg common.Config, defn *common.IndexDefn) writerTuningSettings {
+
+	s := writerTuningSettings{
+		enable:           cfg["plasma.writer.tuning.enable"].Bool(),
+		adjustInterval:   uint64(cfg["plasma.writer.tuning.adjust.interval"].Int()),
+		samplingInterval: uint64(cfg["plasma.writer.tuning.sampling.interval"].Int()),
+	}
+
+	overrides, err := parseWriterTuningOverrides(cfg["plasma.writer.tuning.overrides"].String())
+	if err != nil {
+		logging.Errorf("getWriterTuningSettings plasma.writer.tuning.overrides ignored: %v", err)
+		overrides = nil
+	}
+
+	// least specific first
+	keys := []string{
+		defn.Bucket,
+		strings.Join([]string{defn.Bucket, defn.Scope, defn.Collection}, "."),
+		strings.Join([]string{defn.Bucket, defn.Scope, defn.Collection, defn.Name}, "."),
+	}
+	for _, key := range keys {
+		o, ok := overrides[key]
+		if !ok {
+			continue
+		}
+		if o.Enable != nil {
+			s.enable = *o.Enable
+		}
+		if o.AdjustInterval != nil {
+			s.adjustInterval = uint64(*o.AdjustInterval)
+		}
+		if o.SamplingInterval != nil {
+			s.samplingInterval = uint64(*o.SamplingInterval)
+		}
+	}
+
+	// the samples keep at least one sampling interval
+	window := uint64(cfg["plasma.writer.tuning.sampling.window"].Int())
+	if s.samplingInterval > window {
+		s.samplingInterval = window
+	}
+
+	s.adjustInterval *= uint64(time.Millisecond)
+	s.samplingInterval *= uint64(time.Millisecond)
+	return s
+}
@@ -0,0 +1,52 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestWriterTuningSettings(t *testing.T) {
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	cfg.SetValue("plasma.writer.tuning.overrides", `{
+		"travel": {"enable": true, "adjust_interval": 200},
+		"travel.inventory.hotel": {"sampling_interval": 50},
+		"travel.inventory.hotel.idx1": {"enable": false}}`)
+
+	defn := &common.IndexDefn{Bucket: "travel", Scope: "inventory", Collection: "hotel", Name: "idx2"}
+	s := getWriterTuningSettings(cfg, defn)
+	if !s.enable || s.adjustInterval != uint64(200*time.Millisecond) ||
+		s.samplingInterval != uint64(50*time.Millisecond) {
+		t.Fatalf("expected the keyspace and bucket overrides, got %+v", s)
+	}
+
+	defn.Name = "idx1"
+	if s := getWriterTuningSettings(cfg, defn); s.enable {
+		t.Fatalf("expected writer tuning disabled for the index, got %+v", s)
+	}
+
+	defn.Bucket = "beer"
+	s = getWriterTuningSettings(cfg, defn)
+	if s.enable != cfg["plasma.writer.tuning.enable"].Bool() ||
+		s.adjustInterval != uint64(cfg["plasma.writer.tuning.adjust.interval"].Int())*uint64(time.Millisecond) {
+		t.Fatalf("expected the node wide settings, got %+v", s)
+	}
+
+	// invalid overrides are ignored
+	cfg.SetValue("plasma.writer.tuning.overrides", `{"beer": {"adjust_interval": 0}}`)
+	if _, err := parseWriterTuningOverrides(cfg["plasma.writer.tuning.overrides"].String()); err == nil {
+		t.Fatalf("expected an error for a zero adjust_interval")
+	}
+	if s := getWriterTuningSettings(cfg, defn); s.adjustInterval == 0 {
+		t.Fatalf("expected the node wide adjust interval, got %+v", s)
+	}
+}
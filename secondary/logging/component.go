@@ -0,0 +1,188 @@
+package logging
+
+import "fmt"
+import "sort"
+import "sync"
+import "sync/atomic"
+
+// Components whose log level can be set through indexer settings
+const (
+	StorageMgrComponent = "storage_manager"
+	ScanComponent       = "scan"
+	RebalanceComponent  = "rebalance"
+	DcpComponent        = "dcp"
+)
+
+// inheritLevel marks a component without its own log level, which logs
+// at the level of the default logger.
+const inheritLevel = -1
+
+// ComponentLogger logs to the default logger, but at a log level that can
+// be set for its component independently of the default log level.
+type ComponentLogger struct {
+	name  string
+	level int32
+}
+
+var componentsMu sync.Mutex
+var components = make(map[string]*ComponentLogger)
+
+// GetComponentLogger returns the logger for the named component, creating
+// it on first use. Until SetComponentLogLevel is called for the component,
+// it logs at the level of the default logger.
+func GetComponentLogger(name string) *ComponentLogger {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+
+	if c, ok := components[name]; ok {
+		return c
+	}
+	c := &ComponentLogger{name: name, level: inheritLevel}
+	components[name] = c
+	return c
+}
+
+// SetComponentLogLevel sets the log level of the named component.
+func SetComponentLogLevel(name string, to LogLevel) {
+	c := GetComponentLogger(name)
+	atomic.StoreInt32(&c.level, int32(to))
+}
+
+// ResetComponentLogLevel makes the named component log at the level of
+// the default logger.
+func ResetComponentLogLevel(name string) {
+	c := GetComponentLogger(name)
+	atomic.StoreInt32(&c.level, inheritLevel)
+}
+
+// ComponentLogLevels returns the components which have their own log level.
+func ComponentLogLevels() map[string]LogLevel {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+
+	levels := make(map[string]LogLevel)
+	for name, c := range components {
+		if lvl := atomic.LoadInt32(&c.level); lvl != inheritLevel {
+			levels[name] = LogLevel(lvl)
+		}
+	}
+	return levels
+}
+
+// Components returns the names of all known components, sorted.
+func Components() []string {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name of the component
+func (c *ComponentLogger) Name() string {
+	return c.name
+}
+
+// Check if enabled for the component
+func (c *ComponentLogger) IsEnabled(at LogLevel) bool {
+	lvl := atomic.LoadInt32(&c.level)
+	if lvl == inheritLevel {
+		return SystemLogger.IsEnabled(at)
+	}
+	return LogLevel(lvl) >= at
+}
+
+func (c *ComponentLogger) printf(at LogLevel, format string, v ...interface{}) {
+	if c.IsEnabled(at) {
+		SystemLogger.output(at, format, v...)
+	}
+}
+
+func (c *ComponentLogger) Warnf(format string, v ...interface{}) {
+	c.printf(Warn, format, v...)
+}
+
+func (c *ComponentLogger) Errorf(format string, v ...interface{}) {
+	c.printf(Error, format, v...)
+}
+
+func (c *ComponentLogger) Fatalf(format string, v ...interface{}) {
+	c.printf(Fatal, format, v...)
+}
+
+func (c *ComponentLogger) Infof(format string, v ...interface{}) {
+	c.printf(Info, format, v...)
+}
+
+func (c *ComponentLogger) Verbosef(format string, v ...interface{}) {
+	c.printf(Verbose, format, v...)
+}
+
+func (c *ComponentLogger) Debugf(format string, v ...interface{}) {
+	c.printf(Debug, format, v...)
+}
+
+func (c *ComponentLogger) Tracef(format string, v ...interface{}) {
+	c.printf(Trace, format, v...)
+}
+
+// Run function only if output will be logged at verbose level
+func (c *ComponentLogger) LazyVerbose(fn func() string) {
+	if c.IsEnabled(Verbose) {
+		SystemLogger.output(Verbose, "%s", fn())
+	}
+}
+
+// Run function only if output will be logged at debug level
+func (c *ComponentLogger) LazyDebug(fn func() string) {
+	if c.IsEnabled(Debug) {
+		SystemLogger.output(Debug, "%s", fn())
+	}
+}
+
+// Run function only if output will be logged at trace level
+func (c *ComponentLogger) LazyTrace(fn func() string) {
+	if c.IsEnabled(Trace) {
+		SystemLogger.output(Trace, "%s", fn())
+	}
+}
+
+// Run function only if output will be logged at verbose level
+// Only %v is allowable in format string
+func (c *ComponentLogger) LazyVerbosef(format string, fns ...func() string) {
+	c.lazyf(Verbose, format, fns...)
+}
+
+// Run function only if output will be logged at debug level
+// Only %v is allowable in format string
+func (c *ComponentLogger) LazyDebugf(format string, fns ...func() string) {
+	c.lazyf(Debug, format, fns...)
+}
+
+// Run function only if output will be logged at trace level
+// Only %v is allowable in format string
+func (c *ComponentLogger) LazyTracef(format string, fns ...func() string) {
+	c.lazyf(Trace, format, fns...)
+}
+
+func (c *ComponentLogger) lazyf(at LogLevel, format string, fns ...func() string) {
+	if c.IsEnabled(at) {
+		snippets := make([]interface{}, len(fns))
+		for i, fn := range fns {
+			snippets[i] = fn()
+		}
+		SystemLogger.output(at, format, snippets...)
+	}
+}
+
+func (c *ComponentLogger) String() string {
+	lvl := atomic.LoadInt32(&c.level)
+	if lvl == inheritLevel {
+		return fmt.Sprintf("%v:default", c.name)
+	}
+	return fmt.Sprintf("%v:%v", c.name, LogLevel(lvl))
+}
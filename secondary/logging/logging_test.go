@@ -3,10 +3,8 @@ package logging
 import (
 	"bytes"
 	"os"
-	"regexp"
 	"strings"
 	"testing"
-	"time"
 )
 
 var buffer *bytes.Buffer
@@ -160,3 +158,44 @@ func StackMe() {
 	st := StackTrace()
 	SystemLogger.Errorf(st)
 }
+
+func TestComponentLogLevel(t *testing.T) {
+	buffer.Reset()
+	SetLogWriter(buffer)
+	SetLogLevel(Info)
+	cl := GetComponentLogger("test")
+	if GetComponentLogger("test") != cl {
+		t.Errorf("GetComponentLogger() returned different loggers")
+	}
+
+	cl.Debugf("inherit-debug")
+	SetComponentLogLevel("test", Debug)
+	cl.Debugf("component-debug")
+	Debugf("default-debug")
+	if lvl, ok := ComponentLogLevels()["test"]; !ok || lvl != Debug {
+		t.Errorf("ComponentLogLevels() failed %v", ComponentLogLevels())
+	}
+
+	SetComponentLogLevel("test", Error)
+	cl.Warnf("component-warn")
+	Warnf("default-warn")
+
+	ResetComponentLogLevel("test")
+	cl.Infof("reset-info")
+
+	s := string(buffer.Bytes())
+	if strings.Contains(s, "inherit-debug") == true {
+		t.Errorf("inherited level failed %v", s)
+	} else if strings.Contains(s, "component-debug") == false {
+		t.Errorf("component Debugf() failed %v", s)
+	} else if strings.Contains(s, "default-debug") == true {
+		t.Errorf("default Debugf() failed %v", s)
+	} else if strings.Contains(s, "component-warn") == true {
+		t.Errorf("component Warnf() failed %v", s)
+	} else if strings.Contains(s, "default-warn") == false {
+		t.Errorf("default Warnf() failed %v", s)
+	} else if strings.Contains(s, "reset-info") == false {
+		t.Errorf("ResetComponentLogLevel() failed %v", s)
+	}
+	SetLogWriter(os.Stdout)
+}
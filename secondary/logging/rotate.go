@@ -0,0 +1,315 @@
+package logging
+
+import "compress/gzip"
+import "errors"
+import "fmt"
+import "io"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+
+var ErrLogRotationDisabled = errors.New("log rotation is not enabled")
+
+const rotateTimeFormat = "2006-01-02T15-04-05.000"
+const compressSuffix = ".gz"
+
+// RotateConfig describes when a RotatingFile is rotated and how many
+// rotated files are retained.
+type RotateConfig struct {
+	// Rotate once the file grows beyond MaxSize bytes. 0 disables.
+	MaxSize int64
+	// Rotate once the file has been open for longer than Interval. 0 disables.
+	Interval time.Duration
+	// Number of rotated files to retain. 0 retains all of them.
+	MaxFiles int
+	// Gzip rotated files.
+	Compress bool
+}
+
+// RotatingFile is an io.Writer appending to a file, which is renamed with
+// a timestamp suffix and replaced by a new file whenever the size or age
+// limits in its RotateConfig are crossed. Rotated files are compressed
+// and pruned in the background.
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	cfg      RotateConfig
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	archiveMu sync.Mutex
+	archiveWg sync.WaitGroup
+}
+
+// NewRotatingFile opens (or creates) the file at path for appending.
+func NewRotatingFile(path string, cfg RotateConfig) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, cfg: cfg}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path of the active log file.
+func (r *RotatingFile) Path() string {
+	return r.path
+}
+
+// SetConfig updates the rotation limits, taking effect on next Write.
+func (r *RotatingFile) SetConfig(cfg RotateConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cfg = cfg
+}
+
+// Write implements io.Writer, rotating the file beforehand if required.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file, rotation is best effort.
+			fmt.Fprintf(os.Stderr, "logging: rotation of %v failed: %v\n", r.path, err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate forces a rotation irrespective of size and age.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	return r.rotate()
+}
+
+// Close closes the active file and waits for pending compression.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+
+	r.archiveWg.Wait()
+	return err
+}
+
+func (r *RotatingFile) shouldRotate(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.MaxSize > 0 && r.size+n > r.cfg.MaxSize {
+		return true
+	}
+	if r.cfg.Interval > 0 && time.Since(r.openedAt) >= r.cfg.Interval {
+		return true
+	}
+	return false
+}
+
+func (r *RotatingFile) open() error {
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.openedAt = time.Now()
+	return nil
+}
+
+// rotate must be called with r.mu held.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	rotated := r.rotatedName(time.Now())
+	renameErr := os.Rename(r.path, rotated)
+
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	cfg := r.cfg
+	r.archiveWg.Add(1)
+	go func() {
+		defer r.archiveWg.Done()
+		r.archive(rotated, cfg)
+	}()
+	return nil
+}
+
+func (r *RotatingFile) rotatedName(now time.Time) string {
+	base := r.path + "." + now.Format(rotateTimeFormat)
+	name := base
+	for i := 1; ; i++ {
+		if !fileExists(name) && !fileExists(name+compressSuffix) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// archive compresses a rotated file and enforces the retention limit.
+func (r *RotatingFile) archive(rotated string, cfg RotateConfig) {
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+
+	if cfg.Compress {
+		// rotated may already be pruned by an earlier archive pass.
+		if err := gzipFile(rotated); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "logging: compression of %v failed: %v\n", rotated, err)
+		}
+	}
+
+	if cfg.MaxFiles > 0 {
+		files := r.rotatedFiles()
+		for i := 0; i < len(files)-cfg.MaxFiles; i++ {
+			os.Remove(files[i])
+		}
+	}
+}
+
+// rotatedFiles returns rotated files of r, oldest first.
+func (r *RotatingFile) rotatedFiles() []string {
+	matches, _ := filepath.Glob(r.path + ".*")
+	files := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if strings.HasSuffix(m, compressSuffix+".tmp") {
+			continue
+		}
+		files = append(files, m)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.TrimSuffix(files[i], compressSuffix) <
+			strings.TrimSuffix(files[j], compressSuffix)
+	})
+	return files
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + compressSuffix + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+compressSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+var rotatorMu sync.Mutex
+var rotator *RotatingFile
+
+// SetLogFile directs the default logger to a rotating file at path. If the
+// default logger already writes to path, only the rotation limits are
+// updated. The current log level is retained.
+func SetLogFile(path string, cfg RotateConfig) error {
+	rotatorMu.Lock()
+	defer rotatorMu.Unlock()
+
+	if rotator != nil && rotator.Path() == path {
+		rotator.SetConfig(cfg)
+		return nil
+	}
+
+	rf, err := NewRotatingFile(path, cfg)
+	if err != nil {
+		return err
+	}
+
+	// the writer of the default logger is swapped under its mutex, pending
+	// writes to the old file are done once SetOutput returns.
+	old := rotator
+	rotator = rf
+	SystemLogger.target.SetOutput(rf)
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// ResetLogFile stops logging to the rotating file set by SetLogFile and
+// directs the default logger to w. The current log level is retained.
+func ResetLogFile(w io.Writer) {
+	rotatorMu.Lock()
+	defer rotatorMu.Unlock()
+
+	if rotator == nil {
+		return
+	}
+	SystemLogger.target.SetOutput(w)
+	rotator.Close()
+	rotator = nil
+}
+
+// RotateLog forces rotation of the file set by SetLogFile.
+func RotateLog() error {
+	rotatorMu.Lock()
+	defer rotatorMu.Unlock()
+
+	if rotator == nil {
+		return ErrLogRotationDisabled
+	}
+	return rotator.Rotate()
+}
@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.log")
+	rf, err := NewRotatingFile(path, RotateConfig{MaxSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := rf.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Close()
+
+	if files := rf.rotatedFiles(); len(files) != 2 {
+		t.Errorf("expected 2 rotated files, found %v", files)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "0123456789\n" {
+		t.Errorf("unexpected active file content %q", data)
+	}
+}
+
+func TestRotatingFileCompressRetain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.log")
+	cfg := RotateConfig{MaxFiles: 2, Compress: true}
+	rf, err := NewRotatingFile(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		rf.Write([]byte("line\n"))
+		if err := rf.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Close()
+
+	files := rf.rotatedFiles()
+	if len(files) != 2 {
+		t.Fatalf("expected 2 rotated files, found %v", files)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file, compressSuffix) {
+			t.Errorf("expected compressed file, found %v", file)
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		f.Close()
+		if err != nil || string(data) != "line\n" {
+			t.Errorf("unexpected content %q in %v: %v", data, file, err)
+		}
+	}
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.log")
+	rf, err := NewRotatingFile(path, RotateConfig{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("first\n"))
+	time.Sleep(20 * time.Millisecond)
+	rf.Write([]byte("second\n"))
+	rf.Close()
+
+	if files := rf.rotatedFiles(); len(files) != 1 {
+		t.Errorf("expected 1 rotated file, found %v", files)
+	}
+}
+
+func TestSetLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := RotateLog(); err != ErrLogRotationDisabled {
+		t.Errorf("expected ErrLogRotationDisabled, found %v", err)
+	}
+
+	SetLogLevel(Warn)
+	path := filepath.Join(dir, "test.log")
+	if err := SetLogFile(path, RotateConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetLogWriter(buffer)
+	defer ResetLogFile(buffer)
+
+	if !IsEnabled(Warn) || IsEnabled(Info) {
+		t.Errorf("log level not retained")
+	}
+
+	Warnf("before")
+	if err := RotateLog(); err != nil {
+		t.Fatal(err)
+	}
+	Warnf("after")
+
+	if data, _ := ioutil.ReadFile(path); !strings.Contains(string(data), "after") ||
+		strings.Contains(string(data), "before") {
+		t.Errorf("unexpected active file content %q", data)
+	}
+}
+
+func TestSetLogFileConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetLogWriter(buffer)
+
+	SetLogLevel(Info)
+	donech := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-donech:
+					return
+				default:
+					Infof("concurrent")
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("test%v.log", i))
+		if err := SetLogFile(path, RotateConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ResetLogFile(buffer)
+	close(donech)
+	wg.Wait()
+
+	if !IsEnabled(Info) || IsEnabled(Verbose) {
+		t.Errorf("log level not retained")
+	}
+}
@@ -51,6 +51,20 @@ const (
 	EVENTID_INDEX_SCHED_CREATE
 	// Logged when index background creation of index fails
 	EVENTID_INDEX_SCHED_CREATE_ERROR
+	// Logged when replicas of an index are found to have diverged
+	EVENTID_INDEX_REPLICA_DIVERGED
+	// Logged when a settings change is rejected as invalid
+	EVENTID_INDEXER_SETTINGS_REJECTED
+	// Logged when a document is updated more than a threshold in a window
+	EVENTID_INDEX_HOT_KEY
+	// Logged when diagnostics are captured on a resource threshold
+	EVENTID_INDEXER_DIAGNOSTICS_CAPTURED
+	// Logged when a failed index build is rescheduled with backoff
+	EVENTID_INDEX_BUILD_RETRY
+	// Logged when a failed index build has run out of retries
+	EVENTID_INDEX_BUILD_RETRY_EXHAUSTED
+	// Logged when the files of an index slice fail verification on startup
+	EVENTID_INDEX_SLICE_CORRUPTED
 
 	// *****
 	// Note: Add events here. Don't add events above in between the Events.
@@ -77,6 +91,13 @@ var eventIDToDescriptionMap = map[SystemEventID]string{
 	EVENTID_INDEX_PARTITION_ERROR:        "Index Instance or Partition Error State Change",
 	EVENTID_INDEX_SCHED_CREATE:           "Index Scheduled for Creation",
 	EVENTID_INDEX_SCHED_CREATE_ERROR:     "Index Scheduled Creation Error",
+	EVENTID_INDEX_REPLICA_DIVERGED:       "Index Replica Divergence Detected",
+	EVENTID_INDEXER_SETTINGS_REJECTED:    "Indexer Settings Rejected",
+	EVENTID_INDEX_HOT_KEY:                "Index Hot Key Detected",
+	EVENTID_INDEXER_DIAGNOSTICS_CAPTURED: "Indexer Diagnostics Captured",
+	EVENTID_INDEX_BUILD_RETRY:            "Index Build Scheduled for Retry",
+	EVENTID_INDEX_BUILD_RETRY_EXHAUSTED:  "Index Build Retries Exhausted",
+	EVENTID_INDEX_SLICE_CORRUPTED:        "Index Slice Corrupted",
 }
 
 // Configuration values for SystemEventLogger
@@ -158,6 +179,10 @@ func LogSystemEvent(subComponent string, eventId SystemEventID,
 	se := sel.NewSystemEvent(subComponent, seInfo, severity,
 		extraAttributes)
 
+	// The logger is not started until InitSystemEventLogger succeeds
+	if systemEventLogger == nil {
+		return
+	}
 	systemEventLogger.Log(se)
 }
 
@@ -218,6 +243,155 @@ func NewDDLSystemEvent(mod string, defnId common.IndexDefnId,
 	return e
 }
 
+type replicaDivergenceEvent struct {
+	Group          string             `json:"group"`
+	Module         string             `json:"module"`
+	DefinitionID   common.IndexDefnId `json:"definition_id"`
+	InstanceID     common.IndexInstId `json:"instance_id"`
+	ReplicaID      uint64             `json:"replica_id"`
+	PartitionID    uint64             `json:"partition_id,omitempty"`
+	PeerInstanceID common.IndexInstId `json:"peer_instance_id"`
+	PeerReplicaID  uint64             `json:"peer_replica_id"`
+	PeerNode       string             `json:"peer_node"`
+	NumItems       uint64             `json:"num_items"`
+	PeerNumItems   uint64             `json:"peer_num_items"`
+	NumDiffSamples int                `json:"num_diff_samples"`
+}
+
+func NewReplicaDivergenceEvent(mod string, defnId common.IndexDefnId,
+	instId common.IndexInstId, replicaId uint64, partnId uint64,
+	peerInstId common.IndexInstId, peerReplicaId uint64, peerNode string,
+	numItems, peerNumItems uint64, numDiffSamples int) replicaDivergenceEvent {
+	e := replicaDivergenceEvent{
+		Group:          "Consistency",
+		Module:         mod,
+		DefinitionID:   defnId,
+		InstanceID:     instId,
+		ReplicaID:      replicaId,
+		PartitionID:    partnId,
+		PeerInstanceID: peerInstId,
+		PeerReplicaID:  peerReplicaId,
+		PeerNode:       peerNode,
+		NumItems:       numItems,
+		PeerNumItems:   peerNumItems,
+		NumDiffSamples: numDiffSamples,
+	}
+	return e
+}
+
+type hotKeyEvent struct {
+	Group        string             `json:"group"`
+	Module       string             `json:"module"`
+	DefinitionID common.IndexDefnId `json:"definition_id"`
+	InstanceID   common.IndexInstId `json:"instance_id"`
+	ReplicaID    uint64             `json:"replica_id"`
+	PartitionID  uint64             `json:"partition_id,omitempty"`
+	DocID        string             `json:"docid"`
+	NumUpdates   uint64             `json:"num_updates"`
+	Window       string             `json:"window"`
+}
+
+func NewHotKeyEvent(mod string, defnId common.IndexDefnId,
+	instId common.IndexInstId, replicaId uint64, partnId uint64,
+	docId string, numUpdates uint64, window string) hotKeyEvent {
+	e := hotKeyEvent{
+		Group:        "WriteAmplification",
+		Module:       mod,
+		DefinitionID: defnId,
+		InstanceID:   instId,
+		ReplicaID:    replicaId,
+		PartitionID:  partnId,
+		DocID:        docId,
+		NumUpdates:   numUpdates,
+		Window:       window,
+	}
+	return e
+}
+
+type buildRetryEvent struct {
+	Group        string             `json:"group"`
+	Module       string             `json:"module"`
+	DefinitionID common.IndexDefnId `json:"definition_id"`
+	Bucket       string             `json:"bucket"`
+	Scope        string             `json:"scope"`
+	Collection   string             `json:"collection"`
+	Name         string             `json:"name"`
+	Attempt      int                `json:"attempt"`
+	MaxAttempts  int                `json:"max_attempts"`
+	RetryIn      string             `json:"retry_in,omitempty"`
+	ErrorString  string             `json:"error_string"`
+}
+
+func NewBuildRetryEvent(mod string, defnId common.IndexDefnId,
+	bucket, scope, collection, name string, attempt, maxAttempts int,
+	retryIn string, errorStr string) buildRetryEvent {
+	e := buildRetryEvent{
+		Group:        "DDL",
+		Module:       mod,
+		DefinitionID: defnId,
+		Bucket:       bucket,
+		Scope:        scope,
+		Collection:   collection,
+		Name:         name,
+		Attempt:      attempt,
+		MaxAttempts:  maxAttempts,
+		RetryIn:      retryIn,
+		ErrorString:  errorStr,
+	}
+	return e
+}
+
+type sliceCorruptedEvent struct {
+	Group          string             `json:"group"`
+	Module         string             `json:"module"`
+	DefinitionID   common.IndexDefnId `json:"definition_id"`
+	InstanceID     common.IndexInstId `json:"instance_id"`
+	ReplicaID      uint64             `json:"replica_id"`
+	PartitionID    uint64             `json:"partition_id"`
+	Path           string             `json:"path"`
+	QuarantinePath string             `json:"quarantine_path,omitempty"`
+	ErrorString    string             `json:"error_string"`
+}
+
+func NewSliceCorruptedEvent(mod string, defnId common.IndexDefnId,
+	instId common.IndexInstId, replicaId uint64, partnId uint64,
+	path string, quarantinePath string, errorStr string) sliceCorruptedEvent {
+	e := sliceCorruptedEvent{
+		Group:          "Storage",
+		Module:         mod,
+		DefinitionID:   defnId,
+		InstanceID:     instId,
+		ReplicaID:      replicaId,
+		PartitionID:    partnId,
+		Path:           path,
+		QuarantinePath: quarantinePath,
+		ErrorString:    errorStr,
+	}
+	return e
+}
+
+type diagnosticsEvent struct {
+	Group     string `json:"group"`
+	Module    string `json:"module"`
+	Trigger   string `json:"trigger"`
+	Value     int64  `json:"value"`
+	Threshold int64  `json:"threshold"`
+	Path      string `json:"path"`
+}
+
+func NewDiagnosticsEvent(mod string, trigger string, value int64,
+	threshold int64, path string) diagnosticsEvent {
+	e := diagnosticsEvent{
+		Group:     "Diagnostics",
+		Module:    mod,
+		Trigger:   trigger,
+		Value:     value,
+		Threshold: threshold,
+		Path:      path,
+	}
+	return e
+}
+
 type settingsChangeEvent struct {
 	Group       string                 `json:"group"`
 	Module      string                 `json:"module"`
@@ -235,3 +409,21 @@ func NewSettingsChangeEvent(mod string, oldSetting,
 	}
 	return e
 }
+
+type settingsRejectedEvent struct {
+	Group    string                 `json:"group"`
+	Module   string                 `json:"module"`
+	Settings map[string]interface{} `json:"rejected_setting"`
+	Reason   string                 `json:"reason"`
+}
+
+func NewSettingsRejectedEvent(mod string, settings map[string]interface{},
+	reason string) settingsRejectedEvent {
+	e := settingsRejectedEvent{
+		Group:    "SettingsChange",
+		Module:   mod,
+		Settings: settings,
+		Reason:   reason,
+	}
+	return e
+}
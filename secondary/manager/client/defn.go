@@ -60,6 +60,7 @@ const (
 	OPCODE_CLIENT_STATS                             = OPCODE_DELETE_COLLECTION + 1
 	OPCODE_INVALID_COLLECTION                       = OPCODE_CLIENT_STATS + 1
 	OPCODE_BOOTSTRAP_STATS_UPDATE                   = OPCODE_INVALID_COLLECTION + 1
+	OPCODE_CANCEL_PENDING_BUILD                     = OPCODE_BOOTSTRAP_STATS_UPDATE + 1
 )
 
 func Op2String(op common.OpCode) string {
@@ -130,6 +131,8 @@ func Op2String(op common.OpCode) string {
 		return "OPCODE_INVALID_COLLECTION"
 	case OPCODE_BOOTSTRAP_STATS_UPDATE:
 		return "OPCODE_BOOTSTRAP_STATS_UPDATE"
+	case OPCODE_CANCEL_PENDING_BUILD:
+		return "OPCODE_CANCEL_PENDING_BUILD"
 	}
 	return fmt.Sprintf("%v", op)
 }
@@ -141,6 +144,7 @@ var RespAnotherIndexCreation = "Another index creation is in progress"
 var RespRebalanceRunning = "Rebalance is running"
 var RespDuplicateIndex = "Duplicate index exists"
 var RespUnexpectedError = "Unexpected error"
+var RespBuildNotPending = "Index build is not pending"
 
 /////////////////////////////////////////////////////////////////////////
 // Index List
@@ -3688,6 +3688,77 @@ func (o *MetadataProvider) removeReplica(idxDefn *c.IndexDefn, watcherMap map[c.
 	return nil
 }
 
+//
+// RepairReplica rebuilds the replicas of an index which have been lost, e.g. after the
+// nodes hosting them were failed over, without changing the replica count of the index.
+// It returns the number of replicas being rebuilt.
+//
+func (o *MetadataProvider) RepairReplica(defnId c.IndexDefnId) (int, error) {
+
+	clusterVersion := o.GetClusterVersion()
+	if clusterVersion < c.INDEXER_65_VERSION {
+		return 0, errors.New("Repair replica requires version 6.5 or higher")
+	}
+
+	// Verify if the cluster is in a healthy state.  Retrieve the node list from healthy cluster.
+	nodeList, err := o.getNodesInHealthyCluster()
+	if err != nil {
+		return 0, fmt.Errorf("Fail to repair replica: %v", err)
+	}
+
+	idxMeta := o.findIndex(defnId)
+	if idxMeta == nil {
+		return 0, fmt.Errorf("Index %s does not exist.", defnId)
+	}
+
+	//
+	// Prepare phase.  Acquire the locks from all the indexers, as for alter index.
+	//
+	defn := *idxMeta.Definition
+	watcherMap, err, _, _ := o.makePrepareIndexRequest(defn.DefnId, defn.Name, defn.Bucket,
+		defn.Scope, defn.Collection, nil, defn.PartitionScheme, -1, false, 0)
+	if err != nil {
+		o.cancelPrepareIndexRequest(defn.DefnId, watcherMap)
+		return 0, fmt.Errorf("Fail to repair replica: %v", err)
+	}
+
+	valid, err := o.verifyNodeList(nodeList, watcherMap)
+	if err != nil {
+		o.cancelPrepareIndexRequest(defn.DefnId, watcherMap)
+		return 0, fmt.Errorf("Fail to repair replica: %v", err)
+	}
+	if !valid {
+		o.cancelPrepareIndexRequest(defn.DefnId, watcherMap)
+		return 0, fmt.Errorf("Cluster has failed nodes, undergo network partition, or unable to determine indexer node status.")
+	}
+
+	numReplica, err := o.getNumReplica(defn.DefnId, defn.Name, defn.Bucket, defn.Scope, defn.Collection, watcherMap)
+	if err != nil {
+		o.cancelPrepareIndexRequest(defn.DefnId, watcherMap)
+		return 0, fmt.Errorf("Fail to repair replica: %v", err)
+	}
+	curCount, _ := numReplica.Value()
+
+	// The index may have been repaired since it was looked up.
+	missing := 0
+	if idxMeta = o.findIndex(defnId); idxMeta != nil {
+		missing = int(curCount) + 1 - idxMeta.NumLiveReplicas()
+	}
+	if missing <= 0 {
+		o.cancelPrepareIndexRequest(defn.DefnId, watcherMap)
+		return 0, nil
+	}
+
+	logging.Infof("repair replica.  Num replica %v missing replicas %v", curCount, missing)
+
+	// The planner places the missing replicas when asked to add none.
+	if err := o.addReplica(&defn, watcherMap, *numReplica, 0, nil); err != nil {
+		return 0, fmt.Errorf("Fail to repair replica: %v", err)
+	}
+
+	return missing, nil
+}
+
 func (o *MetadataProvider) Close() {
 	o.mutex.Lock()
 	defer o.mutex.Unlock()
@@ -5509,6 +5580,31 @@ func (m *IndexMetadata) numInstances() int {
 	return len(m.Instances)
 }
 
+//
+// NumLiveReplicas returns the number of replicas of the index which have an instance
+// with all its partitions.
+//
+func (m *IndexMetadata) NumLiveReplicas() int {
+
+	replicas := make(map[uint64]bool)
+	for _, inst := range m.Instances {
+		if inst.State == c.INDEX_STATE_NIL || inst.State == c.INDEX_STATE_DELETED ||
+			inst.State == c.INDEX_STATE_ERROR {
+			continue
+		}
+
+		numPartitions := int(inst.NumPartitions)
+		if numPartitions == 0 {
+			numPartitions = 1
+		}
+		if len(inst.IndexerId) >= numPartitions {
+			replicas[inst.ReplicaId] = true
+		}
+	}
+
+	return len(replicas)
+}
+
 ///////////////////////////////////////////////////////
 // private function : Watcher
 ///////////////////////////////////////////////////////
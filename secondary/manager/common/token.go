@@ -613,6 +613,15 @@ func BuildCommandTokenExist(defnId c.IndexDefnId) (bool, error) {
 	return c.MetakvGet(BuildDDLCommandTokenPath+id, commandToken)
 }
 
+//
+// Delete the token of an index whose build is no longer requested
+//
+func DeleteBuildCommandToken(defnId c.IndexDefnId) error {
+
+	id := fmt.Sprintf("%v", defnId)
+	return c.MetakvDel(BuildDDLCommandTokenPath + id)
+}
+
 //
 // Unmarshall
 //
@@ -1475,6 +1484,18 @@ func (m *CommandListener) AddNewBuildToken(path string, token *BuildCommandToken
 	m.buildTokens[path] = token
 }
 
+// RemoveNewBuildToken removes the build tokens of defnId not yet processed.
+func (m *CommandListener) RemoveNewBuildToken(defnId c.IndexDefnId) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for path, token := range m.buildTokens {
+		if token.DefnId == defnId {
+			delete(m.buildTokens, path)
+		}
+	}
+}
+
 func (m *CommandListener) GetNewScheduleCreateTokens() map[string]*ScheduleCreateToken {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
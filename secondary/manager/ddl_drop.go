@@ -0,0 +1,448 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	u "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+//
+// Bulk drop. All the indexes of a scope, or of a collection, are dropped by
+//
+//	POST /api/v1/ddl/dropIndexes?bucket=<bucket>&scope=<scope>[&collection=<collection>]
+//
+// which returns the id of the drop right away, its progress being reported by
+//
+//	GET  /api/v1/ddl/dropIndexes[/<id>]
+//
+// The node receiving the request lists the indexes of the keyspace on every
+// index node, and posts their delete tokens upfront, so that they are
+// dropped by the janitor if the drop fails or this node goes down midway.
+// Each index node then drops its indexes one at a time, rather than
+// concurrently as hundreds of individual drops would, the indexer removing
+// each index from the DCP stream of its keyspace before it purges its
+// queued mutations and snapshots, and then its slice files.
+//
+
+// state of bulk drops
+const (
+	BULK_DROP_LISTING  string = "listing"
+	BULK_DROP_DROPPING string = "dropping"
+	BULK_DROP_DONE     string = "done"
+	BULK_DROP_FAILED   string = "failed"
+)
+
+// number of finished bulk drops whose progress is kept
+const MAX_FINISHED_BULK_DROPS = 16
+
+type BulkDropProgress struct {
+	Id         string   `json:"id"`
+	Bucket     string   `json:"bucket"`
+	Scope      string   `json:"scope"`
+	Collection string   `json:"collection,omitempty"` // empty for the whole scope
+	State      string   `json:"state"`
+	Total      int      `json:"total"`   // indexes to drop
+	Dropped    int      `json:"dropped"` // indexes dropped on all their nodes
+	Failed     int      `json:"failed"`  // indexes left to the janitor
+	Errors     []string `json:"errors,omitempty"`
+	StartTime  int64    `json:"startTime"`
+	EndTime    int64    `json:"endTime,omitempty"`
+}
+
+type BulkDropResponse struct {
+	Code   string             `json:"code,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Result []BulkDropProgress `json:"result,omitempty"`
+}
+
+func (p *BulkDropProgress) running() bool {
+	return p.State == BULK_DROP_LISTING || p.State == BULK_DROP_DROPPING
+}
+
+// overlaps tells if the keyspaces of the drops share indexes.
+func (p *BulkDropProgress) overlaps(bucket, scope, collection string) bool {
+	return p.Bucket == bucket && p.Scope == scope &&
+		(p.Collection == "" || collection == "" || p.Collection == collection)
+}
+
+// bulkDropTracker keeps the progress of the running bulk drops, and of the
+// last finished ones.
+type bulkDropTracker struct {
+	mu    sync.Mutex
+	drops []*BulkDropProgress // by start time
+}
+
+var bulkDrops bulkDropTracker
+
+// start adds a bulk drop of the keyspace, unless one of an overlapping
+// keyspace is running.
+func (t *bulkDropTracker) start(bucket, scope, collection string) (*BulkDropProgress, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, p := range t.drops {
+		if p.running() && p.overlaps(bucket, scope, collection) {
+			return nil, fmt.Errorf("Drop %v of the indexes of %v is in progress", p.Id, keyspaceName(p.Bucket, p.Scope, p.Collection))
+		}
+	}
+
+	uuid, err := common.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &BulkDropProgress{
+		Id:         uuid.Str(),
+		Bucket:     bucket,
+		Scope:      scope,
+		Collection: collection,
+		State:      BULK_DROP_LISTING,
+		StartTime:  time.Now().UnixNano(),
+	}
+	t.drops = append(t.drops, p)
+	return p, nil
+}
+
+// update applies fn to the progress of the drop.
+func (t *bulkDropTracker) update(p *BulkDropProgress, fn func(p *BulkDropProgress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fn(p)
+	if p.running() {
+		return
+	}
+
+	p.EndTime = time.Now().UnixNano()
+
+	finished := 0
+	for i := len(t.drops) - 1; i >= 0; i-- {
+		if !t.drops[i].running() {
+			if finished++; finished > MAX_FINISHED_BULK_DROPS {
+				t.drops = append(t.drops[:i], t.drops[i+1:]...)
+			}
+		}
+	}
+}
+
+// list returns the progress of the drops, or of the drop with id if not
+// empty.
+func (t *bulkDropTracker) list(id string) []BulkDropProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var result []BulkDropProgress
+	for _, p := range t.drops {
+		if id == "" || p.Id == id {
+			p1 := *p
+			p1.Errors = append([]string(nil), p.Errors...)
+			result = append(result, p1)
+		}
+	}
+	return result
+}
+
+func keyspaceName(bucket, scope, collection string) string {
+	if collection == "" {
+		return bucket + "." + scope
+	}
+	return bucket + "." + scope + "." + collection
+}
+
+func (m *requestHandlerContext) bulkDropReqHandler(w http.ResponseWriter, r *http.Request,
+	creds cbauth.Creds, segs []string) {
+	const method string = "RequestHandler::bulkDropReqHandler" // for logging
+
+	switch {
+	case len(segs) == 5 && r.Method == "POST":
+		bucket, scope, collection := m.getBucket(r), m.getScope(r), m.getCollection(r)
+		if bucket == "" || scope == "" {
+			send(http.StatusBadRequest, w, &BulkDropResponse{Code: RESP_ERROR,
+				Error: "Missing bucket or scope of the indexes to drop"})
+			return
+		}
+
+		permission := fmt.Sprintf("cluster.scope[%s:%s].n1ql.index!drop", bucket, scope)
+		if collection != "" {
+			permission = fmt.Sprintf("cluster.collection[%s:%s:%s].n1ql.index!drop", bucket, scope, collection)
+		}
+		if !isAllowed(creds, []string{permission}, r, w, method) {
+			return
+		}
+
+		p, err := bulkDrops.start(bucket, scope, collection)
+		if err != nil {
+			send(http.StatusConflict, w, &BulkDropResponse{Code: RESP_ERROR, Error: err.Error()})
+			return
+		}
+
+		logging.Infof("%v: drop %v of the indexes of %v", method, p.Id, keyspaceName(bucket, scope, collection))
+		go m.bulkDrop(p)
+		send(http.StatusAccepted, w, &BulkDropResponse{Code: RESP_SUCCESS, Result: bulkDrops.list(p.Id)})
+
+	case (len(segs) == 5 || len(segs) == 6) && r.Method == "GET":
+		id := ""
+		if len(segs) == 6 {
+			id = segs[5]
+		}
+
+		permissionsCache := common.NewSessionPermissionsCache(creds)
+		var result []BulkDropProgress
+		for _, p := range bulkDrops.list(id) {
+			if permissionsCache.IsAllowed(p.Bucket, p.Scope, p.Collection, "list") {
+				result = append(result, p)
+			}
+		}
+		if id != "" && len(result) == 0 {
+			send(http.StatusNotFound, w, &BulkDropResponse{Code: RESP_ERROR,
+				Error: fmt.Sprintf("Drop %v not found", id)})
+			return
+		}
+		send(http.StatusOK, w, &BulkDropResponse{Code: RESP_SUCCESS, Result: result})
+
+	default:
+		send(http.StatusBadRequest, w, fmt.Sprintf("Malformed URL %v or unsupported method %v", r.URL.Path, r.Method))
+	}
+}
+
+// bulkDrop drops the indexes of the keyspace of p on every index node.
+func (m *requestHandlerContext) bulkDrop(p *BulkDropProgress) {
+
+	nodes, err := m.getKeyspaceIndexes(p.Bucket, p.Scope, p.Collection)
+	if err != nil {
+		logging.Errorf("RequestHandler::bulkDrop: drop %v failed: %v", p.Id, err)
+		bulkDrops.update(p, func(p *BulkDropProgress) {
+			p.State = BULK_DROP_FAILED
+			p.Errors = append(p.Errors, err.Error())
+		})
+		return
+	}
+
+	bulkDrops.drop(p, nodes, postDeleteToken, dropIndexOnNode)
+}
+
+// postDeleteToken posts the delete token of the index dropped by a bulk drop.
+func postDeleteToken(defnId common.IndexDefnId) error {
+	return mc.PostDeleteCommandToken(defnId, false)
+}
+
+// drop drops the indexes listed in nodes by the index node they are on,
+// after posting their delete tokens with postToken, each node dropping its
+// indexes one at a time with dropIndex. An index failing to drop on a node
+// is not dropped on the other nodes, and is left to the janitor.
+func (t *bulkDropTracker) drop(p *BulkDropProgress, nodes map[string][]common.IndexDefn,
+	postToken func(defnId common.IndexDefnId) error, dropIndex func(addr string, defn common.IndexDefn) error) {
+
+	// nodes left to drop each index on, and indexes which failed to drop,
+	// protected by mu
+	var mu sync.Mutex
+	pending := make(map[common.IndexDefnId]int)
+	failed := make(map[common.IndexDefnId]bool)
+	for _, defns := range nodes {
+		for _, defn := range defns {
+			pending[defn.DefnId]++
+		}
+	}
+
+	// With the delete tokens, the janitor of every node drops the indexes
+	// this drop does not
+	var errs []string
+	for defnId := range pending {
+		if err := postToken(defnId); err != nil {
+			errs = append(errs, fmt.Sprintf("index %v: %v", defnId, err))
+			failed[defnId] = true
+		}
+	}
+
+	t.update(p, func(p *BulkDropProgress) {
+		p.State = BULK_DROP_DROPPING
+		p.Total = len(pending)
+		p.Failed = len(failed)
+		p.Errors = append(p.Errors, errs...)
+	})
+
+	isFailed := func(defnId common.IndexDefnId) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed[defnId]
+	}
+
+	done := func(defnId common.IndexDefnId, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		t.update(p, func(p *BulkDropProgress) {
+			if err != nil {
+				p.Errors = append(p.Errors, err.Error())
+			}
+			if failed[defnId] {
+				return
+			}
+			if err != nil {
+				failed[defnId] = true
+				p.Failed++
+				return
+			}
+			if pending[defnId]--; pending[defnId] == 0 {
+				p.Dropped++
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	for addr, defns := range nodes {
+		wg.Add(1)
+		go func(addr string, defns []common.IndexDefn) {
+			defer wg.Done()
+
+			for _, defn := range defns {
+				if isFailed(defn.DefnId) {
+					continue
+				}
+				done(defn.DefnId, dropIndex(addr, defn))
+			}
+		}(addr, defns)
+	}
+	wg.Wait()
+
+	var result BulkDropProgress
+	t.update(p, func(p *BulkDropProgress) {
+		p.State = BULK_DROP_DONE
+		if p.Failed > 0 {
+			p.State = BULK_DROP_FAILED
+		}
+		result = *p
+	})
+
+	logging.Infof("RequestHandler::bulkDrop: drop %v of the indexes of %v %v, %v of %v indexes dropped", result.Id,
+		keyspaceName(result.Bucket, result.Scope, result.Collection), result.State, result.Dropped, result.Total)
+}
+
+// getKeyspaceIndexes returns the indexes of the keyspace by the index node
+// they are on.
+func (m *requestHandlerContext) getKeyspaceIndexes(bucket, scope, collection string) (
+	map[string][]common.IndexDefn, error) {
+
+	cinfo, err := m.mgr.FetchNewClusterInfoCache()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string][]common.IndexDefn)
+	for _, nid := range cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE) {
+
+		addr, err := cinfo.GetServiceAddress(nid, common.INDEX_HTTP_SERVICE, true)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Fail to retrieve http endpoint for index node"))
+		}
+
+		url := "/getLocalIndexMetadata?useETag=false&bucket=" + u.QueryEscape(bucket) +
+			"&scope=" + u.QueryEscape(scope)
+		if collection != "" {
+			url += "&collection=" + u.QueryEscape(collection)
+		}
+
+		resp, err := getWithAuth(addr + url)
+		if err != nil {
+			logging.Debugf("RequestHandler::getKeyspaceIndexes: Error while retrieving %v with auth %v", addr+url, err)
+			return nil, errors.New(fmt.Sprintf("Fail to retrieve index definition from url %s", addr))
+		}
+		defer resp.Body.Close()
+
+		localMeta := new(LocalIndexMetadata)
+		if resp.StatusCode != http.StatusOK || convertResponse(resp, localMeta) == RESP_ERROR {
+			return nil, errors.New(fmt.Sprintf("Fail to retrieve local metadata from url %s.", addr))
+		}
+
+		for _, defn := range localMeta.IndexDefinitions {
+			defn.SetCollectionDefaults()
+			if defn.Bucket == bucket && defn.Scope == scope && (collection == "" || defn.Collection == collection) {
+				nodes[addr] = append(nodes[addr], defn)
+			}
+		}
+	}
+
+	return nodes, nil
+}
+
+// dropIndexOnNode drops the instances of the index on the node at addr.
+func dropIndexOnNode(addr string, defn common.IndexDefn) error {
+
+	url := fmt.Sprintf("%v/dropLocalIndex?defnId=%v", addr, defn.DefnId)
+	resp, err := postWithAuth(url, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("%v: drop index %v: %v", addr, defn.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var msg string
+		convertResponse(resp, &msg)
+		return fmt.Errorf("%v: drop index %v: status %v %v", addr, defn.Name, resp.Status,
+			strings.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Handler for /dropLocalIndex, dropping the instances of an index on this
+// node.
+func (m *requestHandlerContext) handleLocalDropIndexRequest(w http.ResponseWriter, r *http.Request) {
+	const method string = "RequestHandler::handleLocalDropIndexRequest" // for logging
+
+	creds, ok := doAuth(r, w, method)
+	if !ok {
+		return
+	}
+
+	if r.Method != "POST" {
+		send(http.StatusBadRequest, w, fmt.Sprintf("Unsupported method %v", r.Method))
+		return
+	}
+
+	defnId, err := indexDefnId(r.FormValue("defnId"))
+	if err != nil {
+		send(http.StatusBadRequest, w, fmt.Sprintf("Invalid defnId %v", r.FormValue("defnId")))
+		return
+	}
+
+	defn, err := m.mgr.getMetadataRepo().GetIndexDefnById(defnId)
+	if err != nil {
+		logging.Errorf("%v: err %v", method, err)
+		send(http.StatusInternalServerError, w, err.Error())
+		return
+	}
+	if defn == nil {
+		// already dropped
+		send(http.StatusOK, w, "OK")
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.collection[%s:%s:%s].n1ql.index!drop", defn.Bucket, defn.Scope, defn.Collection)
+	if !isAllowed(creds, []string{permission}, r, w, method) {
+		return
+	}
+
+	if err := m.mgr.DropLocalIndex(defnId); err != nil {
+		logging.Errorf("%v: err %v", method, err)
+		send(http.StatusInternalServerError, w, err.Error())
+		return
+	}
+	send(http.StatusOK, w, "OK")
+}
@@ -0,0 +1,122 @@
+// Copyright 2024-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// newTestBulkDropNodes returns the indexes of keyspace b.s1 by node: 1 on n1, 4 on n2, and 2 and 3
+// on both.
+func newTestBulkDropNodes() map[string][]common.IndexDefn {
+	defn := func(defnId common.IndexDefnId) common.IndexDefn {
+		return common.IndexDefn{DefnId: defnId, Name: "idx", Bucket: "b", Scope: "s1", Collection: "c1"}
+	}
+	return map[string][]common.IndexDefn{
+		"n1": {defn(1), defn(2), defn(3)},
+		"n2": {defn(2), defn(3), defn(4)},
+	}
+}
+
+func TestBulkDrop(t *testing.T) {
+
+	tracker := &bulkDropTracker{}
+	p, err := tracker.start("b", "s1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.start("b", "s1", "c1"); err == nil {
+		t.Fatalf("expected drop of an overlapping keyspace rejected while running")
+	}
+
+	var tokens []common.IndexDefnId
+	postToken := func(defnId common.IndexDefnId) error {
+		tokens = append(tokens, defnId)
+		return nil
+	}
+	dropIndex := func(addr string, defn common.IndexDefn) error {
+		return nil
+	}
+	tracker.drop(p, newTestBulkDropNodes(), postToken, dropIndex)
+
+	if len(tokens) != 4 {
+		t.Fatalf("expected delete tokens of 4 indexes, got %v", tokens)
+	}
+	result := tracker.list(p.Id)
+	if len(result) != 1 {
+		t.Fatalf("expected progress of drop %v, got %v", p.Id, result)
+	}
+	if r := result[0]; r.State != BULK_DROP_DONE || r.Total != 4 || r.Dropped != 4 || r.Failed != 0 ||
+		len(r.Errors) != 0 || r.EndTime == 0 {
+		t.Fatalf("expected 4 indexes dropped, got %+v", r)
+	}
+
+	if _, err := tracker.start("b", "s1", "c1"); err != nil {
+		t.Fatalf("expected drop of an overlapping keyspace started once finished, got %v", err)
+	}
+}
+
+func TestBulkDropFailure(t *testing.T) {
+
+	tracker := &bulkDropTracker{}
+	p, err := tracker.start("b", "s1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The delete token of 4 fails to post, and 3 fails to drop on n1 after the drop of 2 on n1
+	// is held until the progress is checked.
+	postToken := func(defnId common.IndexDefnId) error {
+		if defnId == 4 {
+			return errors.New("metakv unavailable")
+		}
+		return nil
+	}
+	holdch := make(chan bool)
+	dropped := make(chan common.IndexDefnId, 10)
+	dropIndex := func(addr string, defn common.IndexDefn) error {
+		if addr == "n1" && defn.DefnId == 2 {
+			holdch <- true
+			<-holdch
+		}
+		if addr == "n1" && defn.DefnId == 3 {
+			return errors.New("drop failed")
+		}
+		dropped <- defn.DefnId
+		return nil
+	}
+
+	donech := make(chan bool)
+	go func() {
+		tracker.drop(p, newTestBulkDropNodes(), postToken, dropIndex)
+		close(donech)
+	}()
+
+	// Midway, 1 is dropped on its only node, while 2 and 3 are not dropped on n1 yet
+	<-holdch
+	if r := tracker.list(p.Id)[0]; r.State != BULK_DROP_DROPPING || r.Total != 4 || r.Dropped != 1 || r.Failed != 1 {
+		t.Fatalf("expected 1 of 4 indexes dropped and 1 failed midway, got %+v", r)
+	}
+	holdch <- true
+	<-donech
+
+	if r := tracker.list(p.Id)[0]; r.State != BULK_DROP_FAILED || r.Total != 4 || r.Dropped != 2 || r.Failed != 2 ||
+		len(r.Errors) != 2 {
+		t.Fatalf("expected 2 of 4 indexes dropped and 2 failed, got %+v", r)
+	}
+	close(dropped)
+	for defnId := range dropped {
+		if defnId == 4 {
+			t.Fatalf("expected index 4 without delete token not dropped")
+		}
+	}
+}
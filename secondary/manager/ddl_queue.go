@@ -0,0 +1,424 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+//
+// DDL queue. The DDL operations pending in the cluster are listed by
+//
+//	GET  /api/v1/ddl
+//
+// which gathers from every index node its queued and running builds, and
+// the creates and drops in flight on it, and adds the creates scheduled in
+// the background. Operations that cannot proceed while a rebalance is
+// running are flagged as blocked by rebalance.
+//
+// A queued build, not yet submitted to the indexer, is cancelled by
+//
+//	POST /api/v1/ddl/cancelBuild?defnId=<defnId>
+//
+// which leaves the index deferred, to be built again with BUILD INDEX.
+//
+
+// DDL operations
+const (
+	DDL_OP_CREATE string = "create"
+	DDL_OP_BUILD  string = "build"
+	DDL_OP_DROP   string = "drop"
+)
+
+// state of DDL operations
+const (
+	DDL_STATE_QUEUED  string = "queued"
+	DDL_STATE_RUNNING string = "running"
+)
+
+type PendingDDL struct {
+	Op                 string             `json:"op"`
+	State              string             `json:"state"`
+	DefnId             common.IndexDefnId `json:"defnId"`
+	Bucket             string             `json:"bucket"`
+	Scope              string             `json:"scope"`
+	Collection         string             `json:"collection"`
+	Name               string             `json:"name"`
+	Node               string             `json:"node,omitempty"`
+	BlockedByRebalance bool               `json:"blockedByRebalance,omitempty"`
+}
+
+type LocalPendingDDL struct {
+	IndexerId        string       `json:"indexerId,omitempty"`
+	RebalanceRunning bool         `json:"rebalanceRunning,omitempty"`
+	Operations       []PendingDDL `json:"operations,omitempty"`
+}
+
+type PendingDDLResponse struct {
+	Code             string       `json:"code,omitempty"`
+	Error            string       `json:"error,omitempty"`
+	RebalanceRunning bool         `json:"rebalanceRunning,omitempty"`
+	Result           []PendingDDL `json:"result,omitempty"`
+}
+
+type CancelBuildResponse struct {
+	Code  string   `json:"code,omitempty"`
+	Error string   `json:"error,omitempty"`
+	Nodes []string `json:"nodes,omitempty"` // nodes the build is cancelled on
+}
+
+// Handler for /api/v1/ddl
+func DDLRequestHandler(w http.ResponseWriter, r *http.Request, creds cbauth.Creds) {
+	handlerContext.ddlReqHandler(w, r, creds)
+}
+
+func (m *requestHandlerContext) ddlReqHandler(w http.ResponseWriter, r *http.Request, creds cbauth.Creds) {
+	const method string = "RequestHandler::ddlReqHandler" // for logging
+
+	url := strings.TrimSuffix(strings.TrimSpace(r.URL.Path), "/")
+	segs := strings.Split(url, "/")
+
+	switch {
+	case len(segs) == 4 && r.Method == "GET":
+		ddls, rebalanceRunning, err := m.getPendingDDL(creds)
+		if err != nil {
+			logging.Errorf("%v: err %v", method, err)
+			send(http.StatusInternalServerError, w, &PendingDDLResponse{Code: RESP_ERROR, Error: err.Error()})
+			return
+		}
+		send(http.StatusOK, w, &PendingDDLResponse{Code: RESP_SUCCESS, RebalanceRunning: rebalanceRunning, Result: ddls})
+
+	case len(segs) == 5 && segs[4] == "cancelBuild" && r.Method == "POST":
+		defnId, err := indexDefnId(r.FormValue("defnId"))
+		if err != nil {
+			send(http.StatusBadRequest, w, &CancelBuildResponse{Code: RESP_ERROR,
+				Error: fmt.Sprintf("Invalid defnId %v", r.FormValue("defnId"))})
+			return
+		}
+		m.cancelPendingBuild(w, r, creds, defnId)
+
+	case len(segs) >= 5 && segs[4] == "dropIndexes":
+		m.bulkDropReqHandler(w, r, creds, segs)
+
+	default:
+		send(http.StatusBadRequest, w, fmt.Sprintf("Malformed URL %v or unsupported method %v", r.URL.Path, r.Method))
+	}
+}
+
+// getPendingDDL gathers the DDL operations pending on every index node, and
+// the creates scheduled in the background. It also returns whether a
+// rebalance is running.
+func (m *requestHandlerContext) getPendingDDL(creds cbauth.Creds) ([]PendingDDL, bool, error) {
+
+	cinfo, err := m.mgr.FetchNewClusterInfoCache()
+	if err != nil {
+		return nil, false, err
+	}
+
+	permissionsCache := common.NewSessionPermissionsCache(creds)
+
+	var ddls []PendingDDL
+	rebalanceRunning := false
+
+	for _, nid := range cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE) {
+
+		addr, err := cinfo.GetServiceAddress(nid, common.INDEX_HTTP_SERVICE, true)
+		if err != nil {
+			return nil, false, errors.New(fmt.Sprintf("Fail to retrieve http endpoint for index node"))
+		}
+
+		resp, err := getWithAuth(addr + "/listLocalPendingDDL")
+		if err != nil {
+			logging.Debugf("RequestHandler::getPendingDDL: Error while retrieving %v with auth %v", addr+"/listLocalPendingDDL", err)
+			return nil, false, errors.New(fmt.Sprintf("Fail to retrieve pending DDL from url %s", addr))
+		}
+		defer resp.Body.Close()
+
+		localDDL := new(LocalPendingDDL)
+		if resp.StatusCode != http.StatusOK || convertResponse(resp, localDDL) == RESP_ERROR {
+			return nil, false, errors.New(fmt.Sprintf("Fail to retrieve pending DDL from url %s.", addr))
+		}
+
+		rebalanceRunning = rebalanceRunning || localDDL.RebalanceRunning
+		for _, ddl := range localDDL.Operations {
+			if permissionsCache.IsAllowed(ddl.Bucket, ddl.Scope, ddl.Collection, "list") {
+				ddl.Node = addr
+				ddls = append(ddls, ddl)
+			}
+		}
+	}
+
+	// Scheduled creates are processed by the indexer they are scheduled on,
+	// but are pending on the cluster rather than on a node.
+	schedTokens, err := getSchedCreateTokens("", nil, "")
+	if err != nil {
+		return nil, false, err
+	}
+	for _, token := range schedTokens {
+		defn := &token.Definition
+		if permissionsCache.IsAllowed(defn.Bucket, defn.Scope, defn.Collection, "list") {
+			ddls = append(ddls, newPendingDDL(DDL_OP_CREATE, DDL_STATE_QUEUED, defn))
+		}
+	}
+
+	for i := range ddls {
+		ddls[i].BlockedByRebalance = rebalanceRunning && ddls[i].State == DDL_STATE_QUEUED
+	}
+
+	sort.SliceStable(ddls, func(i, j int) bool {
+		if ddls[i].Op != ddls[j].Op {
+			return ddls[i].Op < ddls[j].Op
+		}
+		return ddls[i].DefnId < ddls[j].DefnId
+	})
+
+	return ddls, rebalanceRunning, nil
+}
+
+func newPendingDDL(op, state string, defn *common.IndexDefn) PendingDDL {
+	return PendingDDL{
+		Op:         op,
+		State:      state,
+		DefnId:     defn.DefnId,
+		Bucket:     defn.Bucket,
+		Scope:      defn.Scope,
+		Collection: defn.Collection,
+		Name:       defn.Name,
+	}
+}
+
+// cancelPendingBuild cancels the build of an index on every node it is
+// queued on.
+func (m *requestHandlerContext) cancelPendingBuild(w http.ResponseWriter, r *http.Request,
+	creds cbauth.Creds, defnId common.IndexDefnId) {
+	const method string = "RequestHandler::cancelPendingBuild" // for logging
+
+	ddls, _, err := m.getPendingDDL(creds)
+	if err != nil {
+		logging.Errorf("%v: err %v", method, err)
+		send(http.StatusInternalServerError, w, &CancelBuildResponse{Code: RESP_ERROR, Error: err.Error()})
+		return
+	}
+
+	var queued []PendingDDL
+	for _, ddl := range ddls {
+		if ddl.Op == DDL_OP_BUILD && ddl.State == DDL_STATE_QUEUED && ddl.DefnId == defnId {
+			queued = append(queued, ddl)
+		}
+	}
+	if len(queued) == 0 {
+		send(http.StatusNotFound, w, &CancelBuildResponse{Code: RESP_ERROR,
+			Error: fmt.Sprintf("Build of index %v is not queued", defnId)})
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.collection[%s:%s:%s].n1ql.index!build",
+		queued[0].Bucket, queued[0].Scope, queued[0].Collection)
+	if !isAllowed(creds, []string{permission}, r, w, method) {
+		return
+	}
+
+	resp := &CancelBuildResponse{Code: RESP_SUCCESS}
+	var errs []string
+	for _, ddl := range queued {
+		url := fmt.Sprintf("%v/cancelLocalPendingBuild?defnId=%v", ddl.Node, defnId)
+		res, err := postWithAuth(url, "application/json", nil)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", ddl.Node, err))
+			continue
+		}
+		res.Body.Close()
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			resp.Nodes = append(resp.Nodes, ddl.Node)
+		case http.StatusConflict:
+			// The build got submitted meanwhile
+			errs = append(errs, fmt.Sprintf("%v: %v", ddl.Node, client.RespBuildNotPending))
+		default:
+			errs = append(errs, fmt.Sprintf("%v: status %v", ddl.Node, res.Status))
+		}
+	}
+
+	logging.Infof("%v: cancelled build of index %v on nodes %v, errors %v", method, defnId, resp.Nodes, errs)
+
+	if len(errs) != 0 {
+		resp.Code = RESP_ERROR
+		resp.Error = fmt.Sprintf("Fail to cancel build of index %v on %v", defnId, strings.Join(errs, ", "))
+		send(http.StatusInternalServerError, w, resp)
+		return
+	}
+	send(http.StatusOK, w, resp)
+}
+
+// Handler for /listLocalPendingDDL, the DDL operations pending on this node.
+func (m *requestHandlerContext) handleLocalPendingDDLRequest(w http.ResponseWriter, r *http.Request) {
+	const method string = "RequestHandler::handleLocalPendingDDLRequest" // for logging
+
+	creds, ok := doAuth(r, w, method)
+	if !ok {
+		return
+	}
+
+	localDDL, err := m.getLocalPendingDDL(creds)
+	if err != nil {
+		logging.Errorf("%v: err %v", method, err)
+		send(http.StatusInternalServerError, w, &PendingDDLResponse{Code: RESP_ERROR, Error: err.Error()})
+		return
+	}
+	send(http.StatusOK, w, localDDL)
+}
+
+func (m *requestHandlerContext) getLocalPendingDDL(creds cbauth.Creds) (*LocalPendingDDL, error) {
+
+	repo := m.mgr.getMetadataRepo()
+	indexerId, err := repo.GetLocalIndexerId()
+	if err != nil {
+		return nil, err
+	}
+
+	localDDL := &LocalPendingDDL{IndexerId: string(indexerId)}
+	if _, err := repo.GetLocalValue("RebalanceRunning"); err == nil {
+		localDDL.RebalanceRunning = true
+	}
+
+	permissionsCache := common.NewSessionPermissionsCache(creds)
+	add := func(op, state string, defn *common.IndexDefn) {
+		if permissionsCache.IsAllowed(defn.Bucket, defn.Scope, defn.Collection, "list") {
+			localDDL.Operations = append(localDDL.Operations, newPendingDDL(op, state, defn))
+		}
+	}
+
+	// Builds queued in the builder
+	for _, defnId := range m.mgr.PendingBuilds() {
+		if defn, err := repo.GetIndexDefnById(defnId); err == nil && defn != nil {
+			add(DDL_OP_BUILD, DDL_STATE_QUEUED, defn)
+		}
+	}
+
+	// Builds running
+	metaIter, err := repo.NewIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer metaIter.Close()
+
+	for _, defn, err := metaIter.Next(); err == nil; _, defn, err = metaIter.Next() {
+		for _, inst := range m.getLocalIndexInsts(defn) {
+			if inst.State == uint32(common.INDEX_STATE_INITIAL) || inst.State == uint32(common.INDEX_STATE_CATCHUP) {
+				add(DDL_OP_BUILD, DDL_STATE_RUNNING, defn)
+				break
+			}
+		}
+	}
+
+	// Creates in flight, whose definition is not yet in the local repo
+	createTokens, err := mc.FetchIndexDefnToCreateCommandTokensMap()
+	if err != nil {
+		return nil, err
+	}
+	for defnId, tokens := range createTokens {
+		if defn, err := repo.GetIndexDefnById(defnId); err != nil || defn != nil {
+			continue
+		}
+		for _, token := range tokens {
+			if defns := token.Definitions[indexerId]; len(defns) != 0 {
+				add(DDL_OP_CREATE, DDL_STATE_RUNNING, &defns[0])
+				break
+			}
+		}
+	}
+
+	// Drops in flight, whose index or instance is still in the local repo
+	deleteTokens, err := mc.ListDeleteCommandToken()
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range deleteTokens {
+		if defn, err := repo.GetIndexDefnById(token.DefnId); err == nil && defn != nil {
+			add(DDL_OP_DROP, DDL_STATE_RUNNING, defn)
+		}
+	}
+
+	dropInstTokens, err := mc.ListAndFetchAllDropInstanceCommandToken(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range dropInstTokens {
+		for _, inst := range m.getLocalIndexInsts(&token.Defn) {
+			if inst.InstId == uint64(token.InstId) && inst.State != uint32(common.INDEX_STATE_DELETED) {
+				add(DDL_OP_DROP, DDL_STATE_RUNNING, &token.Defn)
+				break
+			}
+		}
+	}
+
+	return localDDL, nil
+}
+
+func (m *requestHandlerContext) getLocalIndexInsts(defn *common.IndexDefn) []IndexInstDistribution {
+	topology, err := m.mgr.getMetadataRepo().GetTopologyByCollection(defn.Bucket, defn.Scope, defn.Collection)
+	if err != nil || topology == nil {
+		return nil
+	}
+	return topology.GetIndexInstancesByDefn(defn.DefnId)
+}
+
+// Handler for /cancelLocalPendingBuild, cancelling a build queued on this node.
+func (m *requestHandlerContext) handleLocalCancelBuildRequest(w http.ResponseWriter, r *http.Request) {
+	const method string = "RequestHandler::handleLocalCancelBuildRequest" // for logging
+
+	creds, ok := doAuth(r, w, method)
+	if !ok {
+		return
+	}
+
+	if r.Method != "POST" {
+		send(http.StatusBadRequest, w, fmt.Sprintf("Unsupported method %v", r.Method))
+		return
+	}
+
+	defnId, err := indexDefnId(r.FormValue("defnId"))
+	if err != nil {
+		send(http.StatusBadRequest, w, fmt.Sprintf("Invalid defnId %v", r.FormValue("defnId")))
+		return
+	}
+
+	defn, err := m.mgr.getMetadataRepo().GetIndexDefnById(defnId)
+	if err != nil || defn == nil {
+		send(http.StatusNotFound, w, fmt.Sprintf("Index %v not found", defnId))
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.collection[%s:%s:%s].n1ql.index!build", defn.Bucket, defn.Scope, defn.Collection)
+	if !isAllowed(creds, []string{permission}, r, w, method) {
+		return
+	}
+
+	if err := m.mgr.CancelPendingBuild(defnId); err != nil {
+		if err.Error() == client.RespBuildNotPending {
+			send(http.StatusConflict, w, err.Error())
+		} else {
+			logging.Errorf("%v: err %v", method, err)
+			send(http.StatusInternalServerError, w, err.Error())
+		}
+		return
+	}
+	send(http.StatusOK, w, "OK")
+}
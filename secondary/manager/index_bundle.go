@@ -0,0 +1,393 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+//
+// Index definition bundles move the indexes of a scope to another cluster,
+// e.g. for blue/green migrations:
+//
+//	GET  /api/v1/bucket/<bucket>/export?scope=<scope>
+//	POST /api/v1/bucket/<bucket>/import?scope=<scope>[&dryRun=true]
+//
+// Export returns the definitions of the indexes of the scope, scheduled ones
+// included, without the state of the cluster they were created on: ids,
+// nodes, partition placement and sizing. Partitioning, replica count and the
+// where clause are kept.
+//
+// Import schedules the creation of the definitions of a bundle in the scope
+// of the request, or else of the bundle, always as deferred indexes so that
+// they are built when the migration is cut over. A definition whose name is
+// taken in its collection is skipped if the existing index is equivalent,
+// and is a conflict otherwise. An import with any conflict creates nothing.
+//
+
+const INDEX_BUNDLE_VERSION uint64 = 1
+
+// status of the definitions of an import
+const (
+	IMPORT_PENDING   string = "pending" // dry run only
+	IMPORT_SCHEDULED string = "scheduled"
+	IMPORT_SKIPPED   string = "skipped"
+	IMPORT_CONFLICT  string = "conflict"
+	IMPORT_FAILED    string = "failed"
+)
+
+type IndexBundle struct {
+	Version     uint64             `json:"version"`
+	Bucket      string             `json:"bucket"`
+	Scope       string             `json:"scope"`
+	Definitions []common.IndexDefn `json:"definitions"`
+}
+
+type ExportResponse struct {
+	Code   string       `json:"code,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Result *IndexBundle `json:"result,omitempty"`
+}
+
+type ImportResult struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	Code   string         `json:"code,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	Result []ImportResult `json:"result,omitempty"`
+}
+
+func (m *requestHandlerContext) indexBundleHandler(w http.ResponseWriter, r *http.Request,
+	creds cbauth.Creds, bucket, function string) {
+	const method string = "RequestHandler::indexBundleHandler" // for logging
+
+	scope := r.FormValue("scope")
+
+	switch {
+	case function == "export" && r.Method == "GET":
+		if len(scope) == 0 {
+			send(http.StatusBadRequest, w, &ExportResponse{Code: RESP_ERROR, Error: "Missing scope"})
+			return
+		}
+
+		permission := fmt.Sprintf("cluster.scope[%s:%s].n1ql.index!list", bucket, scope)
+		if !isAllowed(creds, []string{permission}, r, w, method) {
+			return
+		}
+
+		bundle, err := m.exportIndexBundle(creds, bucket, scope)
+		if err != nil {
+			logging.Errorf("%v: export of %v:%v err %v", method, bucket, scope, err)
+			send(http.StatusInternalServerError, w, &ExportResponse{Code: RESP_ERROR, Error: err.Error()})
+			return
+		}
+		send(http.StatusOK, w, &ExportResponse{Code: RESP_SUCCESS, Result: bundle})
+
+	case function == "import" && r.Method == "POST":
+		bundle := new(IndexBundle)
+		if err := json.NewDecoder(r.Body).Decode(bundle); err != nil {
+			send(http.StatusBadRequest, w, &ImportResponse{Code: RESP_ERROR,
+				Error: fmt.Sprintf("Unable to parse index bundle: %v", err)})
+			return
+		}
+		if len(scope) == 0 {
+			scope = bundle.Scope
+		}
+		if err := validateIndexBundle(bundle, scope); err != nil {
+			send(http.StatusBadRequest, w, &ImportResponse{Code: RESP_ERROR, Error: err.Error()})
+			return
+		}
+
+		// Conflict detection needs to see the existing indexes of the scope
+		for _, op := range []string{"create", "list"} {
+			permission := fmt.Sprintf("cluster.scope[%s:%s].n1ql.index!%s", bucket, scope, op)
+			if !isAllowed(creds, []string{permission}, r, w, method) {
+				return
+			}
+		}
+
+		dryRun := r.FormValue("dryRun") == "true"
+		results, status, err := m.importIndexBundle(creds, bucket, scope, bundle, dryRun)
+		if err != nil {
+			logging.Errorf("%v: import to %v:%v err %v", method, bucket, scope, err)
+			send(status, w, &ImportResponse{Code: RESP_ERROR, Error: err.Error(), Result: results})
+			return
+		}
+		send(http.StatusOK, w, &ImportResponse{Code: RESP_SUCCESS, Result: results})
+
+	default:
+		send(http.StatusBadRequest, w, fmt.Sprintf("Unsupported method %v", r.Method))
+	}
+}
+
+func (m *requestHandlerContext) exportIndexBundle(creds cbauth.Creds, bucket, scope string) (*IndexBundle, error) {
+
+	meta, err := m.getIndexMetadata(creds, &target{bucket: bucket, scope: scope})
+	if err != nil {
+		return nil, err
+	}
+
+	schedTokens, err := getSchedCreateTokens(bucket, map[string]bool{scope: true}, "include")
+	if err != nil {
+		return nil, err
+	}
+
+	return newIndexBundle(bucket, scope, meta.Metadata, schedTokens), nil
+}
+
+// newIndexBundle returns the bundle of the indexes of a scope in the metadata
+// of the index nodes, each index once however many nodes it is placed on.
+// Indexes being dropped are left out.
+func newIndexBundle(bucket, scope string, metadata []LocalIndexMetadata,
+	schedTokens map[common.IndexDefnId]*mc.ScheduleCreateToken) *IndexBundle {
+
+	bundle := &IndexBundle{
+		Version:     INDEX_BUNDLE_VERSION,
+		Bucket:      bucket,
+		Scope:       scope,
+		Definitions: []common.IndexDefn{},
+	}
+	exported := make(map[common.IndexDefnId]bool)
+
+	add := func(defn *common.IndexDefn, numPartitions uint32) {
+		portable := portableIndexDefn(defn)
+		if portable.Bucket != bucket || portable.Scope != scope || exported[defn.DefnId] {
+			return
+		}
+		if portable.NumPartitions == 0 {
+			portable.NumPartitions = numPartitions
+		}
+		exported[defn.DefnId] = true
+		bundle.Definitions = append(bundle.Definitions, portable)
+	}
+
+	for _, localMeta := range metadata {
+		for i := range localMeta.IndexDefinitions {
+			defn := &localMeta.IndexDefinitions[i]
+			if numPartitions, ok := liveIndexPartitions(localMeta.IndexTopologies, defn); ok {
+				add(defn, numPartitions)
+			}
+		}
+	}
+
+	for _, token := range schedTokens {
+		add(&token.Definition, token.Definition.NumPartitions)
+	}
+
+	sort.Slice(bundle.Definitions, func(i, j int) bool {
+		di, dj := &bundle.Definitions[i], &bundle.Definitions[j]
+		if di.Collection != dj.Collection {
+			return di.Collection < dj.Collection
+		}
+		return di.Name < dj.Name
+	})
+
+	return bundle
+}
+
+// liveIndexPartitions returns the number of partitions of an index that has
+// an instance not being dropped.
+func liveIndexPartitions(topologies []IndexTopology, defn *common.IndexDefn) (uint32, bool) {
+	for i := range topologies {
+		dist := topologies[i].FindIndexDefinitionById(defn.DefnId)
+		if dist == nil {
+			continue
+		}
+		for _, inst := range dist.Instances {
+			if common.IndexState(inst.State) != common.INDEX_STATE_DELETED {
+				return inst.NumPartitions, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// portableIndexDefn returns the definition of an index without the state of
+// the cluster it is created on.
+func portableIndexDefn(defn *common.IndexDefn) common.IndexDefn {
+	portable := common.IndexDefn{
+		Name:               defn.Name,
+		Using:              defn.Using,
+		Bucket:             defn.Bucket,
+		Scope:              defn.Scope,
+		Collection:         defn.Collection,
+		IsPrimary:          defn.IsPrimary,
+		SecExprs:           defn.SecExprs,
+		Desc:               defn.Desc,
+		ExprType:           defn.ExprType,
+		PartitionScheme:    defn.PartitionScheme,
+		PartitionKeys:      defn.PartitionKeys,
+		HashScheme:         defn.HashScheme,
+		WhereExpr:          defn.WhereExpr,
+		Immutable:          defn.Immutable,
+		IsArrayIndex:       defn.IsArrayIndex,
+		IsArrayFlattened:   defn.IsArrayFlattened,
+		NumReplica:         uint32(defn.GetNumReplica()),
+		RetainDeletedXATTR: defn.RetainDeletedXATTR,
+		HasArrItemsCount:   defn.HasArrItemsCount,
+		NumPartitions:      defn.NumPartitions,
+	}
+	if !common.IsPartitioned(portable.PartitionScheme) {
+		portable.NumPartitions = 0
+	}
+	portable.SetCollectionDefaults()
+	return portable
+}
+
+func validateIndexBundle(bundle *IndexBundle, scope string) error {
+	if bundle.Version == 0 || bundle.Version > INDEX_BUNDLE_VERSION {
+		return fmt.Errorf("Unsupported index bundle version %v", bundle.Version)
+	}
+	if len(scope) == 0 {
+		return errors.New("Missing scope")
+	}
+	for _, defn := range bundle.Definitions {
+		if len(defn.Name) == 0 || len(defn.Using) == 0 || (!defn.IsPrimary && len(defn.SecExprs) == 0) {
+			return fmt.Errorf("Invalid definition of index %v in index bundle", defn.Name)
+		}
+	}
+	return nil
+}
+
+// importIndexBundle schedules the creation of the definitions of a bundle in
+// bucket and scope. It returns the http status of the response on error.
+func (m *requestHandlerContext) importIndexBundle(creds cbauth.Creds, bucket, scope string,
+	bundle *IndexBundle, dryRun bool) ([]ImportResult, int, error) {
+
+	meta, err := m.getIndexMetadata(creds, &target{bucket: bucket, scope: scope})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	schedTokens, err := getSchedCreateTokens(bucket, map[string]bool{scope: true}, "include")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	existing := newIndexBundle(bucket, scope, meta.Metadata, schedTokens).Definitions
+
+	defns := remapIndexBundle(bundle, bucket, scope)
+	results, conflicts := planIndexImport(defns, existing)
+	if conflicts != 0 {
+		return results, http.StatusConflict,
+			fmt.Errorf("%v index definitions conflict with existing indexes, no index is created", conflicts)
+	}
+	if dryRun {
+		return results, http.StatusOK, nil
+	}
+
+	indexerId, err := m.mgr.getMetadataRepo().GetLocalIndexerId()
+	if err != nil {
+		return results, http.StatusInternalServerError, err
+	}
+
+	var failed int
+	for i := range defns {
+		if results[i].Status != IMPORT_PENDING {
+			continue
+		}
+
+		defn := defns[i]
+		if defn.DefnId, err = common.NewIndexDefnId(); err == nil {
+			req := &client.ScheduleCreateRequest{
+				Definition: defn,
+				Plan:       map[string]interface{}{"defer_build": true},
+				IndexerId:  indexerId,
+			}
+			err = m.processScheduleCreateRequest(req)
+		}
+
+		if err != nil {
+			results[i].Status, results[i].Error = IMPORT_FAILED, err.Error()
+			failed++
+			continue
+		}
+
+		logging.Infof("RequestHandler::importIndexBundle: scheduled creation of index %v:%v:%v:%v defnId %v",
+			bucket, scope, defn.Collection, defn.Name, defn.DefnId)
+		results[i].Status = IMPORT_SCHEDULED
+	}
+
+	if failed != 0 {
+		return results, http.StatusInternalServerError,
+			fmt.Errorf("Failed to schedule creation of %v of %v indexes", failed, len(defns))
+	}
+	return results, http.StatusOK, nil
+}
+
+// remapIndexBundle returns the definitions of a bundle to create in bucket and
+// scope, as deferred indexes.
+func remapIndexBundle(bundle *IndexBundle, bucket, scope string) []common.IndexDefn {
+	defns := make([]common.IndexDefn, 0, len(bundle.Definitions))
+	for i := range bundle.Definitions {
+		defn := portableIndexDefn(&bundle.Definitions[i])
+		defn.Bucket = bucket
+		defn.Scope = scope
+		defn.Deferred = true
+		defns = append(defns, defn)
+	}
+	return defns
+}
+
+// planIndexImport returns the status of importing each of defns, pending
+// unless the name of the index is taken in its collection, and the number of
+// conflicts. A name repeated in defns is a conflict.
+func planIndexImport(defns []common.IndexDefn, existing []common.IndexDefn) ([]ImportResult, int) {
+
+	key := func(defn *common.IndexDefn) string {
+		return strings.Join([]string{defn.Collection, defn.Name}, ":")
+	}
+	taken := make(map[string]*common.IndexDefn)
+	for i := range existing {
+		taken[key(&existing[i])] = &existing[i]
+	}
+	imported := make(map[string]bool)
+
+	results := make([]ImportResult, len(defns))
+	var conflicts int
+	for i := range defns {
+		defn := &defns[i]
+		results[i] = ImportResult{Collection: defn.Collection, Name: defn.Name, Status: IMPORT_PENDING}
+
+		k := key(defn)
+		other, ok := taken[k]
+		switch {
+		case imported[k]:
+			results[i].Status = IMPORT_CONFLICT
+			results[i].Error = "Index defined more than once in index bundle"
+			conflicts++
+
+		case !ok:
+
+		case common.IsEquivalentIndex(defn, other):
+			results[i].Status = IMPORT_SKIPPED
+
+		default:
+			results[i].Status = IMPORT_CONFLICT
+			results[i].Error = "Index of the same name with a different definition exists"
+			conflicts++
+		}
+		imported[k] = true
+	}
+	return results, conflicts
+}
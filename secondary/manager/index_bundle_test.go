@@ -0,0 +1,199 @@
+// Copyright 2024-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+// newTestBundleMetadata returns the metadata of an index node holding defns of b/s1, each with
+// an instance in state.
+func newTestBundleMetadata(indexerId string, state common.IndexState,
+	defns ...common.IndexDefn) LocalIndexMetadata {
+
+	meta := LocalIndexMetadata{IndexerId: indexerId, IndexDefinitions: defns}
+	topologies := make(map[string]*IndexTopology)
+	for _, defn := range defns {
+		topology, ok := topologies[defn.Collection]
+		if !ok {
+			topology = &IndexTopology{Bucket: defn.Bucket, Scope: defn.Scope, Collection: defn.Collection}
+			topologies[defn.Collection] = topology
+		}
+		numPartitions := defn.NumPartitions
+		if numPartitions == 0 {
+			numPartitions = 1
+		}
+		topology.AddIndexDefinition(defn.Bucket, defn.Scope, defn.Collection, defn.Name,
+			uint64(defn.DefnId), uint64(defn.DefnId)+1, uint32(state), indexerId, 0,
+			uint32(common.REBAL_ACTIVE), 0, []common.PartitionId{0}, []int{0}, numPartitions,
+			false, string(defn.Using), 0)
+	}
+	for _, topology := range topologies {
+		meta.IndexTopologies = append(meta.IndexTopologies, *topology)
+	}
+	return meta
+}
+
+func TestIndexBundleRoundTrip(t *testing.T) {
+
+	partitioned := common.IndexDefn{
+		DefnId: 101, Name: "idx_city", Using: common.PlasmaDB, Bucket: "b", Scope: "s1",
+		Collection: "c1", SecExprs: []string{"city", "age"}, Desc: []bool{false, true},
+		ExprType: common.N1QL, PartitionScheme: common.KEY, PartitionKeys: []string{"meta().id"},
+		HashScheme: common.CRC32, NumPartitions: 8, WhereExpr: "age > 18", NumReplica: 1,
+	}
+	primary := common.IndexDefn{
+		DefnId: 102, Name: "#primary", Using: common.PlasmaDB, Bucket: "b", Scope: "s1",
+		Collection: "c2", IsPrimary: true, ExprType: common.N1QL, PartitionScheme: common.SINGLE,
+	}
+	dropped := common.IndexDefn{
+		DefnId: 103, Name: "idx_dropped", Using: common.PlasmaDB, Bucket: "b", Scope: "s1",
+		Collection: "c1", SecExprs: []string{"name"}, ExprType: common.N1QL,
+		PartitionScheme: common.SINGLE,
+	}
+	otherScope := common.IndexDefn{
+		DefnId: 104, Name: "idx_other", Using: common.PlasmaDB, Bucket: "b", Scope: "s2",
+		Collection: "c1", SecExprs: []string{"name"}, ExprType: common.N1QL,
+		PartitionScheme: common.SINGLE,
+	}
+	scheduled := common.IndexDefn{
+		DefnId: 105, Name: "idx_scheduled", Using: common.PlasmaDB, Bucket: "b", Scope: "s1",
+		Collection: "c1", SecExprs: []string{"zip"}, ExprType: common.N1QL,
+		PartitionScheme: common.SINGLE,
+	}
+
+	// idx_city has a replica on each node, it is exported once.
+	metadata := []LocalIndexMetadata{
+		newTestBundleMetadata("indexer1", common.INDEX_STATE_ACTIVE, partitioned, primary, otherScope),
+		newTestBundleMetadata("indexer2", common.INDEX_STATE_ACTIVE, partitioned),
+		newTestBundleMetadata("indexer2", common.INDEX_STATE_DELETED, dropped),
+	}
+	schedTokens := map[common.IndexDefnId]*mc.ScheduleCreateToken{
+		scheduled.DefnId: {Definition: scheduled},
+	}
+
+	bundle := newIndexBundle("b", "s1", metadata, schedTokens)
+	var names []string
+	for _, defn := range bundle.Definitions {
+		names = append(names, defn.Collection+":"+defn.Name)
+		if defn.DefnId != 0 {
+			t.Errorf("index %v exported with the defnId of the cluster", defn.Name)
+		}
+	}
+	if expected := []string{"c1:idx_city", "c1:idx_scheduled", "c2:#primary"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected indexes %v exported, got %v", expected, names)
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := new(IndexBundle)
+	if err := json.Unmarshal(data, imported); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateIndexBundle(imported, imported.Scope); err != nil {
+		t.Fatalf("exported bundle not valid: %v", err)
+	}
+	if !reflect.DeepEqual(imported, bundle) {
+		t.Fatalf("bundle changed by a round trip:\n%+v\n%+v", bundle, imported)
+	}
+
+	// Imported to another bucket and scope, as deferred indexes.
+	defns := remapIndexBundle(imported, "b2", "s3")
+	for i, defn := range defns {
+		if defn.Bucket != "b2" || defn.Scope != "s3" || !defn.Deferred {
+			t.Errorf("index %v not remapped to b2:s3 as deferred: %+v", defn.Name, defn)
+		}
+		defn.Bucket, defn.Scope, defn.Deferred = "b", "s1", false
+		if !reflect.DeepEqual(defn, bundle.Definitions[i]) {
+			t.Errorf("index %v changed by import:\n%+v\n%+v", defn.Name, bundle.Definitions[i], defn)
+		}
+	}
+	if city := defns[0]; city.NumPartitions != 8 || city.GetNumReplica() != 1 || city.WhereExpr != "age > 18" {
+		t.Errorf("partitions, replicas or where clause of idx_city lost: %+v", city)
+	}
+
+	results, conflicts := planIndexImport(defns, nil)
+	for _, result := range results {
+		if result.Status != IMPORT_PENDING {
+			t.Errorf("expected %v pending, got %+v", result.Name, result)
+		}
+	}
+	if conflicts != 0 {
+		t.Errorf("expected no conflicts, got %v", conflicts)
+	}
+
+	// Importing the same bundle again skips all the indexes.
+	results, conflicts = planIndexImport(defns, remapIndexBundle(imported, "b2", "s3"))
+	for _, result := range results {
+		if result.Status != IMPORT_SKIPPED {
+			t.Errorf("expected %v skipped, got %+v", result.Name, result)
+		}
+	}
+	if conflicts != 0 {
+		t.Errorf("expected no conflicts, got %v", conflicts)
+	}
+}
+
+func TestIndexBundleImportConflicts(t *testing.T) {
+
+	defn := func(name string, exprs ...string) common.IndexDefn {
+		return common.IndexDefn{Name: name, Using: common.PlasmaDB, Bucket: "b", Scope: "s",
+			Collection: "c", SecExprs: exprs, ExprType: common.N1QL, PartitionScheme: common.SINGLE}
+	}
+	existing := []common.IndexDefn{defn("idx1", "a"), defn("idx2", "b")}
+	defns := []common.IndexDefn{defn("idx1", "a"), defn("idx2", "c"), defn("idx3", "d"), defn("idx3", "d")}
+
+	results, conflicts := planIndexImport(defns, existing)
+	var statuses []string
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	expected := []string{IMPORT_SKIPPED, IMPORT_CONFLICT, IMPORT_PENDING, IMPORT_CONFLICT}
+	if !reflect.DeepEqual(statuses, expected) || conflicts != 2 {
+		t.Errorf("expected %v with 2 conflicts, got %v with %v", expected, statuses, conflicts)
+	}
+}
+
+func TestIndexBundleMalformed(t *testing.T) {
+
+	for body, expected := range map[string]string{
+		`{"version": 1, "scope": "s", "definitions": [`:                                   "Unable to parse index bundle",
+		`{"version": 1, "scope": "s", "definitions": {}}`:                                 "Unable to parse index bundle",
+		`{"scope": "s", "definitions": []}`:                                               "Unsupported index bundle version 0",
+		`{"version": 2, "scope": "s", "definitions": []}`:                                 "Unsupported index bundle version 2",
+		`{"version": 1, "definitions": []}`:                                               "Missing scope",
+		`{"version": 1, "scope": "s", "definitions": [{"using": "plasma"}]}`:              "Invalid definition of index",
+		`{"version": 1, "scope": "s", "definitions": [{"name": "i", "using": ""}]}`:       "Invalid definition of index i",
+		`{"version": 1, "scope": "s", "definitions": [{"name": "i", "using": "plasma"}]}`: "Invalid definition of index i",
+	} {
+		m := &requestHandlerContext{}
+		r := httptest.NewRequest("POST", "/api/v1/bucket/b/import", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		m.indexBundleHandler(w, r, nil, "b", "import")
+
+		var resp ImportResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%v: unexpected response %s", body, w.Body.Bytes())
+		}
+		if w.Code != http.StatusBadRequest || resp.Code != RESP_ERROR ||
+			!strings.HasPrefix(resp.Error, expected) {
+			t.Errorf("%v: expected %v %q, got %v %+v", body, http.StatusBadRequest, expected, w.Code, resp)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/couchbase/indexing/secondary/common/collections"
 	fdb "github.com/couchbase/indexing/secondary/fdb"
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/logging/systemevent"
 	"github.com/couchbase/indexing/secondary/manager/client"
 	mc "github.com/couchbase/indexing/secondary/manager/common"
 	//"runtime/debug"
@@ -139,14 +140,28 @@ type mergePartition struct {
 }
 
 type builder struct {
-	manager   *LifecycleMgr
-	pendings  map[string][]uint64    // map of "bucket/scope/collection" to list of index defnIds pending build
-	notifych  chan *common.IndexDefn // incoming requests to build indexes
-	batchSize int32                  // max number of indexes to build per iteration of builder.run; <= 0 means unlimited
-	disable   int32
+	manager     *LifecycleMgr
+	pendingLock sync.Mutex                         // protects pendings and retries, also read and cancelled by the DDL queue endpoints
+	pendings    map[string][]uint64                // map of "bucket/scope/collection" to list of index defnIds pending build
+	retries     map[common.IndexDefnId]*buildRetry // failed index builds by defnId, waiting for or recently retried
+	notifych    chan *common.IndexDefn             // incoming requests to build indexes
+	batchSize   int32                              // max number of indexes to build per iteration of builder.run; <= 0 means unlimited
+	disable     int32
+
+	maxRetries     int32 // max retries of a failed index build; <= 0 means unlimited
+	initialBackoff int64 // nanoseconds to wait before the first retry of a failed index build
+	maxBackoff     int64 // max nanoseconds to wait between retries of a failed index build
 
-	commandListener *mc.CommandListener
-	listenerDonech  chan bool
+	commandListener  *mc.CommandListener
+	listenerDonech   chan bool
+	deleteBuildToken func(common.IndexDefnId) error // mc.DeleteBuildCommandToken
+}
+
+// buildRetry tracks the retries of an index build failing with transient errors.
+type buildRetry struct {
+	attempts int               // failed attempts since the index last built without failing for maxBackoff
+	retryAt  time.Time         // time of the next (or last) retry
+	defn     *common.IndexDefn // set while waiting for retryAt
 }
 
 type janitor struct {
@@ -545,6 +560,8 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 		result, err = m.handleCheckTokenExist(content)
 	case client.OPCODE_CLIENT_STATS:
 		result, err = m.handleClientStats(content)
+	case client.OPCODE_CANCEL_PENDING_BUILD:
+		err = m.handleCancelPendingBuild(key)
 	}
 
 	logging.Debugf("LifecycleMgr.dispatchRequest () : send response for requestId %d, op %d, len(result) %d", reqId, op, len(result))
@@ -2001,7 +2018,6 @@ func (m *LifecycleMgr) buildIndexesLifecycleMgr(defnIds []common.IndexDefnId,
 	retryErrList []error, skipList []common.IndexDefnId, errList []error, errMap map[common.IndexInstId]string) {
 
 	errMap = make(map[common.IndexInstId]string)
-	retryList := ([]*common.IndexDefn)(nil) // retryable builds that failed; will go into notifych
 	instIdList := []common.IndexInstId(nil)
 	defnIdMap := make(map[common.IndexDefnId]bool)
 	buckets := []string(nil)
@@ -2118,9 +2134,24 @@ func (m *LifecycleMgr) buildIndexesLifecycleMgr(defnIds []common.IndexDefnId,
 				}
 
 				inst, err := m.FindLocalIndexInst(defn.Bucket, defn.Scope, defn.Collection, defnId, instId)
+
+				// Retriable errors are retried by the builder with backoff, until the retries are exhausted
+				retry := m.canRetryBuildError(inst, build_err, isRebal)
+				exhausted := false
+				var backoff time.Duration
+				if retry {
+					if backoff, retry = m.builder.scheduleRetry(defn, build_err); !retry {
+						exhausted = true
+					}
+				}
+
 				if inst != nil && err == nil {
-					if m.canRetryBuildError(inst, build_err, isRebal) {
-						build_err = errors.New(fmt.Sprintf("Index %v will retry building in the background for reason: %v.", defn.Name, build_err.Error()))
+					if retry {
+						build_err = errors.New(fmt.Sprintf("Index %v will retry building in the background in %v for reason: %v.",
+							defn.Name, backoff, build_err.Error()))
+					} else if exhausted {
+						build_err = errors.New(fmt.Sprintf("Index %v fails to build after exhausting its retries for reason: %v.",
+							defn.Name, build_err.Error()))
 					}
 					m.UpdateIndexInstance(defn.Bucket, defn.Scope, defn.Collection, defnId, common.IndexInstId(inst.InstId), common.INDEX_STATE_NIL,
 						common.NIL_STREAM, build_err.Error(), nil, inst.RState, nil, nil, -1)
@@ -2129,9 +2160,9 @@ func (m *LifecycleMgr) buildIndexesLifecycleMgr(defnIds []common.IndexDefnId,
 						defn.Bucket, defn.Scope, defn.Collection, defn.Name, inst.ReplicaId)
 				}
 
-				if m.canRetryBuildError(inst, build_err, isRebal) {
-					logging.Infof("LifecycleMgr::handleBuildIndexes: Encountered build error.  Retry building index (%v, %v, %v, %v, %v) at later time.",
-						defn.Bucket, defn.Scope, defn.Collection, defn.Name, inst.ReplicaId)
+				if retry {
+					logging.Infof("LifecycleMgr::handleBuildIndexes: Encountered build error.  Retry building index (%v, %v, %v, %v, %v) in %v.",
+						defn.Bucket, defn.Scope, defn.Collection, defn.Name, inst.ReplicaId, backoff)
 
 					if inst != nil && !inst.Scheduled {
 						if err := m.SetScheduledFlag(defn.Bucket, defn.Scope, defn.Collection, defnId, common.IndexInstId(inst.InstId), true); err != nil {
@@ -2141,17 +2172,18 @@ func (m *LifecycleMgr) buildIndexesLifecycleMgr(defnIds []common.IndexDefnId,
 						}
 					}
 
-					retryList = append(retryList, defn)
 					retryErrList = append(retryErrList, build_err)
 				} else {
+					// Do not rebuild the index upon server restart once its retries are exhausted
+					if exhausted && inst != nil && inst.Scheduled {
+						if err := m.SetScheduledFlag(defn.Bucket, defn.Scope, defn.Collection, defnId, common.IndexInstId(inst.InstId), false); err != nil {
+							logging.Warnf("LifecycleMgr.handleBuildIndexes: Unable to reset scheduled flag in index instance (%v, %v, %v, %v, %v).",
+								defn.Bucket, defn.Scope, defn.Collection, defn.Name, inst.ReplicaId)
+						}
+					}
 					errList = append(errList, errors.New(fmt.Sprintf("Index %v fails to build for reason: %v", defn.Name, build_err)))
 				}
 			}
-
-			// Schedule retriable failed index builds for retry
-			for _, defn := range retryList {
-				m.builder.notifych <- defn
-			}
 		}
 	}
 	logging.Debugf("LifecycleMgr.buildIndexesLifecycleMgr() : buildIndexRebalance completes")
@@ -2341,6 +2373,59 @@ func (m *LifecycleMgr) handleTopologyChange(content []byte) error {
 	return nil
 }
 
+//-----------------------------------------------------------
+// Cancel Pending Build
+//-----------------------------------------------------------
+
+// handleCancelPendingBuild cancels the build of an index that is pending in the builder, and not yet
+// submitted. Its build token is deleted and the scheduled flag of its instances is cleared, so that
+// the index stays deferred, also upon restart, until it is built again.
+func (m *LifecycleMgr) handleCancelPendingBuild(key string) error {
+
+	id, err := indexDefnId(key)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleCancelPendingBuild() : cancel fails. Reason = %v", err)
+		return err
+	}
+
+	if !m.builder.cancelPending(id) {
+		return errors.New(client.RespBuildNotPending)
+	}
+
+	if err := m.builder.cancelBuildToken(id); err != nil {
+		logging.Errorf("LifecycleMgr.handleCancelPendingBuild() : fail to delete build token of %v. Reason = %v", id, err)
+		return err
+	}
+
+	defn, err := m.repo.GetIndexDefnById(id)
+	if err != nil || defn == nil {
+		return err
+	}
+
+	insts, err := m.findAllLocalIndexInst(defn.Bucket, defn.Scope, defn.Collection, id)
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range insts {
+		if inst.Scheduled {
+			if err := m.SetScheduledFlag(defn.Bucket, defn.Scope, defn.Collection, id,
+				common.IndexInstId(inst.InstId), false); err != nil {
+				return err
+			}
+		}
+	}
+
+	logging.Infof("LifecycleMgr.handleCancelPendingBuild() : cancelled build of index (%v, %v, %v, %v)",
+		defn.Bucket, defn.Scope, defn.Collection, defn.Name)
+	return nil
+}
+
+// pendingBuilds returns the indexes pending build in the builder.
+func (m *LifecycleMgr) pendingBuilds() []common.IndexDefnId {
+	return m.builder.listPending()
+}
+
 //-----------------------------------------------------------
 // Delete Bucket
 //-----------------------------------------------------------
@@ -2978,10 +3063,18 @@ func (m *LifecycleMgr) handleResetIndexOnRollback(content []byte) error {
 
 	//reset only for active state index. If index gets deleted, it doesn't need to be reset.
 	if common.IndexState(rinst.State) == common.INDEX_STATE_ACTIVE {
-		topology.UpdateScheduledFlagForIndexInst(defn.DefnId, inst.InstId, true)
+		// The builder rebuilds the index after a backoff, so that repeated rollbacks
+		// count against the retry budget of the index build.
+		cause := errors.New("Index rolled back to 0")
+		errStr := ""
+		_, retry := m.builder.scheduleRetry(defn, cause)
+		if !retry {
+			errStr = fmt.Sprintf("Index %v fails to build after exhausting its retries for reason: %v.", defn.Name, cause)
+		}
+		topology.UpdateScheduledFlagForIndexInst(defn.DefnId, inst.InstId, retry)
 
 		topology.UpdateStateForIndexInst(defn.DefnId, inst.InstId, common.INDEX_STATE_READY)
-		topology.SetErrorForIndexInst(defn.DefnId, inst.InstId, "")
+		topology.SetErrorForIndexInst(defn.DefnId, inst.InstId, errStr)
 		topology.UpdateStreamForIndexInst(defn.DefnId, inst.InstId, common.NIL_STREAM)
 
 		if err := m.repo.SetTopologyByCollection(defn.Bucket, defn.Scope, defn.Collection, topology); err != nil {
@@ -2990,9 +3083,6 @@ func (m *LifecycleMgr) handleResetIndexOnRollback(content []byte) error {
 				defn.Bucket, defn.Scope, defn.Collection, defn.Name, err)
 			return err
 		}
-
-		//notify builder
-		m.builder.notifych <- defn
 	}
 	return nil
 }
@@ -4571,6 +4661,8 @@ func (s *builder) run() {
 		case <-ticker.C:
 			// This case submits index builds for as many defnIds in the pendings map as allowed
 			// (batchSize minus number already building).
+			s.addDueRetries(time.Now())
+
 			processed := s.processBuildToken(false)
 
 			//when building from build tokens, sleep for 30 seconds,
@@ -4592,7 +4684,7 @@ func (s *builder) run() {
 				}()
 			}
 
-			if len(s.pendings) > 0 {
+			if s.hasPending() {
 				buildList, quota := s.getBuildList()
 				for _, key := range buildList {
 					quota = s.tryBuildIndex(key, quota) // submits defnId builds and reduces quota by number submitted
@@ -4622,6 +4714,9 @@ func (s *builder) getBuildList() ([]string, int32) {
 	// quota is max index builds to start; skipList is keyspaces that have at least one instance already building
 	quota, skipList := s.getQuota()
 
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
 	// Initialize buildList with all pending keyspaces that do not already have builds ongoing
 	buildList := ([]string)(nil)
 	for key, _ := range s.pendings {
@@ -4664,6 +4759,14 @@ func (s *builder) getBuildList() ([]string, int32) {
 // addPending adds the defnId to the list of pending index builds for the given b/s/c
 // if it was not already there. Returns true if it added id, else false (duplicate).
 func (s *builder) addPending(bucket, scope, collection string, defnId uint64) bool {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	return s.addPendingLocked(bucket, scope, collection, defnId)
+}
+
+// addPendingLocked is addPending for callers holding pendingLock.
+func (s *builder) addPendingLocked(bucket, scope, collection string, defnId uint64) bool {
 	key := getPendingKey(bucket, scope, collection)
 	for _, defnId2 := range s.pendings[key] {
 		if defnId2 == defnId {
@@ -4679,19 +4782,56 @@ func (s *builder) addPending(bucket, scope, collection string, defnId uint64) bo
 // (which is per index, not per instance). It returns the remaining quota (original minus number of builds started).
 func (s *builder) tryBuildIndex(pendingKey string, quota int32) int32 {
 
+	bucket, scope, collection := getCollectionFromKey(pendingKey)
+
+	buildList, pendingList, quotaRemaining := s.takeBuildList(pendingKey, quota)
+
+	// Submit the defnIds to be built, if any
+	if len(buildList) != 0 {
+		idList := &client.IndexIdList{DefnIds: buildList}
+		key := fmt.Sprintf("%d", idList.DefnIds[0])
+		content, err := client.MarshallIndexIdList(idList)
+		if err != nil {
+			logging.Warnf("builder: Failed to marshall index defnIds during index build.  Error = %v. Retry later.", err)
+			return quota
+		}
+		logging.Infof("builder: Try build index for bucket: %v, scope: %v, collection: %v. Index %v",
+			bucket, scope, collection, idList)
+
+		// If any of the index cannot be built, those index will be skipped by lifecycle manager, so it
+		// will send the rest of the indexes to the indexer.  An index cannot be built if it does not have
+		// an index instance or the index instance is not in READY state.
+		if err := s.manager.requestServer.MakeRequest(client.OPCODE_BUILD_INDEX_RETRY, key, content); err != nil {
+			logging.Warnf("builder: Failed to build index.  Error = %v.", err)
+		}
+		logging.Infof("builder: defnIds still pending to be built: %v.", pendingList)
+	}
+	return quotaRemaining
+}
+
+// takeBuildList removes from the pendings of keyspace pendingKey the defnIds to build now w.r.t. the quota
+// argument. It returns them, the defnIds still pending, and the remaining quota. The builds are submitted
+// without holding pendingLock.
+func (s *builder) takeBuildList(pendingKey string, quota int32) ([]uint64, []uint64, int32) {
+
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
 	bucket, scope, collection := getCollectionFromKey(pendingKey)
 	quotaRemaining := quota // original quota minus number of index builds started here
 
+	buildList := ([]uint64)(nil) // defnIds to start building
+	pendingList := ([]uint64)(nil)
+
 	defnIds := s.pendings[pendingKey] // all indexes needing builds for keyspace pendingKey
 	if len(defnIds) != 0 {
 		// This is a pre-cautionary check if there is any index being
 		// built for the collection. The authortative check is done by indexer.
 		if s.manager.canBuildIndex(bucket, scope, collection) {
 
-			buildList := ([]uint64)(nil)       // defnIds to start building
 			isDisableBuild := s.disableBuild() // is background index building disabled?
 
-			pendingList := make([]uint64, len(defnIds)) // defnIds not built here
+			pendingList = make([]uint64, len(defnIds)) // defnIds not built here
 			copy(pendingList, defnIds)
 
 			for _, defnId := range defnIds {
@@ -4750,30 +4890,150 @@ func (s *builder) tryBuildIndex(pendingKey string, quota int32) int32 {
 				pendingList = nil
 			}
 			s.pendings[pendingKey] = pendingList
+		}
+	}
+	return buildList, pendingList, quotaRemaining
+}
 
-			// Submit the defnIds to be built, if any
-			if len(buildList) != 0 {
-				idList := &client.IndexIdList{DefnIds: buildList}
-				key := fmt.Sprintf("%d", idList.DefnIds[0])
-				content, err := client.MarshallIndexIdList(idList)
-				if err != nil {
-					logging.Warnf("builder: Failed to marshall index defnIds during index build.  Error = %v. Retry later.", err)
-					return quota
-				}
-				logging.Infof("builder: Try build index for bucket: %v, scope: %v, collection: %v. Index %v",
-					bucket, scope, collection, idList)
-
-				// If any of the index cannot be built, those index will be skipped by lifecycle manager, so it
-				// will send the rest of the indexes to the indexer.  An index cannot be built if it does not have
-				// an index instance or the index instance is not in READY state.
-				if err := s.manager.requestServer.MakeRequest(client.OPCODE_BUILD_INDEX_RETRY, key, content); err != nil {
-					logging.Warnf("builder: Failed to build index.  Error = %v.", err)
+// scheduleRetry records a failed build of defn, and schedules its retry after a backoff doubling with
+// each failed attempt, up to maxBackoff. It returns the backoff, or false if the retries of the build
+// are exhausted. A build failing again while its retry is scheduled is not counted as another attempt.
+// Attempts and exhaustion are recorded in the event log.
+func (s *builder) scheduleRetry(defn *common.IndexDefn, cause error) (time.Duration, bool) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	now := time.Now()
+	maxRetries := int(atomic.LoadInt32(&s.maxRetries))
+	maxBackoff := time.Duration(atomic.LoadInt64(&s.maxBackoff))
+
+	retry := s.retries[defn.DefnId]
+	if retry == nil || (retry.defn == nil && now.Sub(retry.retryAt) > maxBackoff) {
+		retry = &buildRetry{}
+		s.retries[defn.DefnId] = retry
+	}
+	if retry.defn != nil {
+		return retry.retryAt.Sub(now), true
+	}
+
+	retry.attempts++
+	if maxRetries > 0 && retry.attempts > maxRetries {
+		delete(s.retries, defn.DefnId)
+
+		logging.Errorf("builder: Index (%v, %v, %v, %v) fails to build after %v attempts. Error = %v.",
+			defn.Bucket, defn.Scope, defn.Collection, defn.Name, retry.attempts, cause)
+		event := systemevent.NewBuildRetryEvent("IndexBuildRetryExhausted", defn.DefnId, defn.Bucket, defn.Scope,
+			defn.Collection, defn.Name, retry.attempts, maxRetries, "", cause.Error())
+		systemevent.ErrorEvent("Indexer", systemevent.EVENTID_INDEX_BUILD_RETRY_EXHAUSTED, event)
+		return 0, false
+	}
+
+	backoff := buildRetryBackoff(retry.attempts, time.Duration(atomic.LoadInt64(&s.initialBackoff)), maxBackoff)
+	retry.retryAt = now.Add(backoff)
+	retry.defn = defn
+
+	logging.Infof("builder: Retry building index (%v, %v, %v, %v) in %v, attempt %v. Error = %v.",
+		defn.Bucket, defn.Scope, defn.Collection, defn.Name, backoff, retry.attempts, cause)
+	event := systemevent.NewBuildRetryEvent("IndexBuildRetry", defn.DefnId, defn.Bucket, defn.Scope,
+		defn.Collection, defn.Name, retry.attempts, maxRetries, backoff.String(), cause.Error())
+	systemevent.WarnEvent("Indexer", systemevent.EVENTID_INDEX_BUILD_RETRY, event)
+	return backoff, true
+}
+
+// buildRetryBackoff returns the backoff before the retry of a build failed attempts times: initial,
+// doubled on each attempt after the first, up to max.
+func buildRetryBackoff(attempts int, initial, max time.Duration) time.Duration {
+	backoff := initial
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// addDueRetries adds to the pendings the failed index builds whose backoff has elapsed by now, and
+// forgets the retries of indexes that have not failed again for maxBackoff.
+func (s *builder) addDueRetries(now time.Time) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	maxBackoff := time.Duration(atomic.LoadInt64(&s.maxBackoff))
+	for defnId, retry := range s.retries {
+		if retry.defn == nil {
+			if now.Sub(retry.retryAt) > maxBackoff {
+				delete(s.retries, defnId)
+			}
+		} else if !now.Before(retry.retryAt) {
+			logging.Infof("builder:  Schedule retry of index build %v for bucket: %v, scope: %v, collection: %v",
+				defnId, retry.defn.Bucket, retry.defn.Scope, retry.defn.Collection)
+			s.addPendingLocked(retry.defn.Bucket, retry.defn.Scope, retry.defn.Collection, uint64(defnId))
+			retry.defn = nil
+		}
+	}
+}
+
+// hasPending returns true if there is any index pending build.
+func (s *builder) hasPending() bool {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	return len(s.pendings) > 0
+}
+
+// listPending returns the defnIds of the indexes pending build, that is, queued or waiting to retry,
+// and not yet submitted.
+func (s *builder) listPending() []common.IndexDefnId {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	var defnIds []common.IndexDefnId
+	for _, ids := range s.pendings {
+		for _, defnId := range ids {
+			defnIds = append(defnIds, common.IndexDefnId(defnId))
+		}
+	}
+	for defnId, retry := range s.retries {
+		if retry.defn != nil {
+			defnIds = append(defnIds, defnId)
+		}
+	}
+	return defnIds
+}
+
+// cancelPending removes defnId from the indexes pending build. It returns false if the index was
+// not pending, e.g. because its build has already been submitted.
+func (s *builder) cancelPending(defnId common.IndexDefnId) bool {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+
+	if retry, ok := s.retries[defnId]; ok && retry.defn != nil {
+		delete(s.retries, defnId)
+		return true
+	}
+
+	for key, ids := range s.pendings {
+		for i, id := range ids {
+			if id == uint64(defnId) {
+				ids = append(ids[:i:i], ids[i+1:]...)
+				if len(ids) == 0 {
+					delete(s.pendings, key)
+				} else {
+					s.pendings[key] = ids
 				}
-				logging.Infof("builder: defnIds still pending to be built: %v.", pendingList)
+				return true
 			}
 		}
 	}
-	return quotaRemaining
+	return false
+}
+
+// cancelBuildToken deletes the build token of defnId, so that its build is not requested again from
+// the token, e.g. upon restart.
+func (s *builder) cancelBuildToken(defnId common.IndexDefnId) error {
+	s.commandListener.RemoveNewBuildToken(defnId)
+	return s.deleteBuildToken(defnId)
 }
 
 // getQuota returns the number of new index builds that can be started now (quota) as the batchSize minus
@@ -4918,6 +5178,10 @@ func (s *builder) configUpdate(config *common.Config) {
 	} else {
 		atomic.StoreInt32(&s.disable, int32(0))
 	}
+
+	atomic.StoreInt32(&s.maxRetries, int32((*config)["build.retry.maxAttempts"].Int()))
+	atomic.StoreInt64(&s.initialBackoff, int64((*config)["build.retry.initialBackoff"].Int())*int64(time.Second))
+	atomic.StoreInt64(&s.maxBackoff, int64((*config)["build.retry.maxBackoff"].Int())*int64(time.Second))
 }
 
 func (s *builder) disableBuild() bool {
@@ -4935,12 +5199,17 @@ func newBuilder(mgr *LifecycleMgr) *builder {
 	donech := make(chan bool)
 
 	builder := &builder{
-		manager:         mgr,
-		pendings:        make(map[string][]uint64),
-		notifych:        make(chan *common.IndexDefn, 50000),
-		batchSize:       int32(common.SystemConfig["indexer.settings.build.batch_size"].Int()),
-		commandListener: mc.NewCommandListener(donech, false, true, false, false, false, false),
-		listenerDonech:  donech,
+		manager:          mgr,
+		pendings:         make(map[string][]uint64),
+		retries:          make(map[common.IndexDefnId]*buildRetry),
+		notifych:         make(chan *common.IndexDefn, 50000),
+		batchSize:        int32(common.SystemConfig["indexer.settings.build.batch_size"].Int()),
+		maxRetries:       int32(common.SystemConfig["indexer.build.retry.maxAttempts"].Int()),
+		initialBackoff:   int64(common.SystemConfig["indexer.build.retry.initialBackoff"].Int()) * int64(time.Second),
+		maxBackoff:       int64(common.SystemConfig["indexer.build.retry.maxBackoff"].Int()) * int64(time.Second),
+		commandListener:  mc.NewCommandListener(donech, false, true, false, false, false, false),
+		listenerDonech:   donech,
+		deleteBuildToken: mc.DeleteBuildCommandToken,
 	}
 
 	disable := common.SystemConfig["indexer.build.background.disable"].Bool()
@@ -0,0 +1,232 @@
+// Copyright 2024-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager/client"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+// newTestLifecycleMgr returns a LifecycleMgr on a local repo in a temporary directory, holding
+// deferred indexes defnIds of keyspace b/_default/_default in state READY and scheduled for build.
+func newTestLifecycleMgr(t *testing.T, port int, defnIds ...common.IndexDefnId) *LifecycleMgr {
+
+	config := common.SystemConfig
+	repo, _, err := NewLocalMetadataRepo(fmt.Sprintf("localhost:%v", port), nil, nil,
+		filepath.Join(t.TempDir(), "MetadataStore"), 1024*1024,
+		uint64(config["indexer.metadata.compaction.sleepDuration"].Int()),
+		uint8(config["indexer.metadata.compaction.threshold"].Int()),
+		uint64(config["indexer.metadata.compaction.minFileSize"].Int()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(repo.Close)
+
+	topology := &IndexTopology{Bucket: "b", Scope: "_default", Collection: "_default"}
+	for _, defnId := range defnIds {
+		defn := &common.IndexDefn{
+			DefnId:     defnId,
+			Name:       fmt.Sprintf("idx%v", defnId),
+			Using:      common.PlasmaDB,
+			Bucket:     "b",
+			Scope:      "_default",
+			Collection: "_default",
+			SecExprs:   []string{"a"},
+			ExprType:   common.N1QL,
+			Deferred:   true,
+		}
+		if err := repo.CreateIndex(defn); err != nil {
+			t.Fatal(err)
+		}
+		topology.AddIndexDefinition("b", "_default", "_default", defn.Name, uint64(defnId),
+			uint64(defnId)+1, uint32(common.INDEX_STATE_READY), "indexer1", 0, uint32(common.REBAL_ACTIVE),
+			0, []common.PartitionId{0}, []int{0}, 1, true, string(defn.Using), 0)
+	}
+	if err := repo.SetTopologyByCollection("b", "_default", "_default", topology); err != nil {
+		t.Fatal(err)
+	}
+
+	return &LifecycleMgr{repo: repo}
+}
+
+// newTestBuilder returns a builder of mgr whose build tokens are kept in the map metakv rather than
+// in metakv. The command listener is loaded with the tokens in metakv, as it is upon restart.
+func newTestBuilder(mgr *LifecycleMgr, metakv map[common.IndexDefnId]bool) *builder {
+
+	s := newBuilder(mgr)
+	s.deleteBuildToken = func(defnId common.IndexDefnId) error {
+		delete(metakv, defnId)
+		return nil
+	}
+	for defnId := range metakv {
+		s.commandListener.AddNewBuildToken(fmt.Sprintf("%v%v", mc.BuildDDLCommandTokenPath, defnId),
+			&mc.BuildCommandToken{DefnId: defnId})
+	}
+	return s
+}
+
+func TestCancelPendingBuild(t *testing.T) {
+
+	mgr := newTestLifecycleMgr(t, 9120, 1001, 1002)
+	metakv := map[common.IndexDefnId]bool{1001: true, 1002: true}
+
+	mgr.builder = newTestBuilder(mgr, metakv)
+	if !mgr.builder.processBuildToken(false) {
+		t.Fatal("build tokens not processed")
+	}
+	if pending := mgr.pendingBuilds(); len(pending) != 2 {
+		t.Fatalf("expected 2 pending builds, got %v", pending)
+	}
+
+	if err := mgr.handleCancelPendingBuild("1001"); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := mgr.pendingBuilds(); len(pending) != 1 || pending[0] != 1002 {
+		t.Errorf("expected pending build of 1002 only, got %v", pending)
+	}
+	if metakv[1001] || !metakv[1002] {
+		t.Errorf("expected build token of 1002 only, got %v", metakv)
+	}
+
+	insts, err := mgr.findAllLocalIndexInst("b", "_default", "_default", 1001)
+	if err != nil || len(insts) != 1 {
+		t.Fatalf("instance of 1001 not found: %v, %v", insts, err)
+	}
+	if insts[0].Scheduled {
+		t.Errorf("instance of cancelled build of 1001 still scheduled")
+	}
+
+	err = mgr.handleCancelPendingBuild("1001")
+	if err == nil || err.Error() != client.RespBuildNotPending {
+		t.Errorf("expected %v upon cancel of a build not pending, got %v", client.RespBuildNotPending, err)
+	}
+}
+
+func TestCancelPendingBuildRecover(t *testing.T) {
+
+	mgr := newTestLifecycleMgr(t, 9121, 1001, 1002)
+	metakv := map[common.IndexDefnId]bool{1001: true, 1002: true}
+
+	mgr.builder = newTestBuilder(mgr, metakv)
+	mgr.builder.processBuildToken(false)
+	if err := mgr.handleCancelPendingBuild("1001"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upon restart, the builder recovers the builds from the build tokens left in metakv and the
+	// scheduled flag of the instances.
+	mgr.builder = newTestBuilder(mgr, metakv)
+	mgr.builder.recover()
+
+	if pending := mgr.pendingBuilds(); len(pending) != 1 || pending[0] != 1002 {
+		t.Errorf("expected recovered pending build of 1002 only, got %v", pending)
+	}
+}
+
+func TestBuildRetryBackoff(t *testing.T) {
+
+	for attempts, expected := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		4:  40 * time.Second,
+		5:  time.Minute,
+		40: time.Minute,
+	} {
+		if backoff := buildRetryBackoff(attempts, 5*time.Second, time.Minute); backoff != expected {
+			t.Errorf("expected backoff %v after %v attempts, got %v", expected, attempts, backoff)
+		}
+	}
+}
+
+func TestBuildRetry(t *testing.T) {
+
+	s := newBuilder(&LifecycleMgr{})
+	s.maxRetries = 2
+	s.initialBackoff = int64(time.Minute)
+	s.maxBackoff = int64(time.Hour)
+
+	defn := &common.IndexDefn{DefnId: 1001, Name: "idx1001", Bucket: "b", Scope: "_default", Collection: "_default"}
+	cause := errors.New("build failed")
+
+	backoff, retry := s.scheduleRetry(defn, cause)
+	if !retry || backoff != time.Minute {
+		t.Fatalf("expected retry in %v, got %v, %v", time.Minute, backoff, retry)
+	}
+	if pending := s.listPending(); len(pending) != 1 || pending[0] != 1001 {
+		t.Fatalf("expected retry of 1001 pending, got %v", pending)
+	}
+
+	// A build failing again while waiting to retry is not counted as another attempt
+	if _, retry = s.scheduleRetry(defn, cause); !retry || s.retries[1001].attempts != 1 {
+		t.Fatalf("expected 1 attempt while waiting to retry, got %v, %v", s.retries[1001].attempts, retry)
+	}
+
+	s.addDueRetries(time.Now())
+	if s.hasPending() {
+		t.Fatalf("expected no build pending before the backoff elapses, got %v", s.pendings)
+	}
+	s.addDueRetries(time.Now().Add(time.Minute))
+	if pending := s.listPending(); len(pending) != 1 || pending[0] != 1001 || !s.hasPending() {
+		t.Fatalf("expected build of 1001 pending once the backoff elapses, got %v", pending)
+	}
+
+	// The backoff doubles on the next failure of the retried build
+	s.pendings = make(map[string][]uint64)
+	if backoff, retry = s.scheduleRetry(defn, cause); !retry || backoff != 2*time.Minute {
+		t.Fatalf("expected retry in %v, got %v, %v", 2*time.Minute, backoff, retry)
+	}
+	s.addDueRetries(time.Now().Add(2 * time.Minute))
+	s.pendings = make(map[string][]uint64)
+
+	// The retries are exhausted on the next failure
+	if _, retry = s.scheduleRetry(defn, cause); retry {
+		t.Fatalf("expected retries exhausted after %v attempts", s.maxRetries)
+	}
+	if pending := s.listPending(); len(pending) != 0 || len(s.retries) != 0 {
+		t.Fatalf("expected no retry pending once exhausted, got %v, %v", pending, s.retries)
+	}
+}
+
+func TestBuildRetryForgetAndCancel(t *testing.T) {
+
+	s := newBuilder(&LifecycleMgr{})
+	s.maxRetries = 2
+	s.initialBackoff = int64(time.Minute)
+	s.maxBackoff = int64(time.Hour)
+
+	defn := &common.IndexDefn{DefnId: 1001, Name: "idx1001", Bucket: "b", Scope: "_default", Collection: "_default"}
+	cause := errors.New("build failed")
+
+	// A retried build not failing again for maxBackoff is forgotten, with its attempts
+	s.scheduleRetry(defn, cause)
+	s.addDueRetries(time.Now().Add(time.Minute))
+	s.pendings = make(map[string][]uint64)
+	s.addDueRetries(time.Now().Add(2 * time.Hour))
+	if len(s.retries) != 0 {
+		t.Fatalf("expected retry of 1001 forgotten, got %v", s.retries)
+	}
+
+	// A build waiting to retry can be cancelled
+	s.scheduleRetry(defn, cause)
+	if !s.cancelPending(1001) {
+		t.Fatalf("expected retry of 1001 cancelled")
+	}
+	s.addDueRetries(time.Now().Add(time.Minute))
+	if pending := s.listPending(); len(pending) != 0 || s.hasPending() {
+		t.Fatalf("expected no build pending after cancel, got %v", pending)
+	}
+}
@@ -572,6 +572,26 @@ func (m *IndexManager) DropOrPruneInstance(defn common.IndexDefn, notify bool) e
 	return m.requestServer.MakeRequest(client.OPCODE_DROP_OR_PRUNE_INSTANCE, fmt.Sprintf("%v", defn.DefnId), buf)
 }
 
+// CancelPendingBuild cancels the build of an index that is pending in the builder of this node, and
+// not yet submitted. It fails with client.RespBuildNotPending if the build is not pending.
+func (m *IndexManager) CancelPendingBuild(defnId common.IndexDefnId) error {
+
+	logging.Debugf("IndexManager.CancelPendingBuild(): making request for cancel pending build")
+	return m.requestServer.MakeRequest(client.OPCODE_CANCEL_PENDING_BUILD, fmt.Sprintf("%v", defnId), []byte(""))
+}
+
+// DropLocalIndex drops the instances of an index on this node, as a drop from a user.
+func (m *IndexManager) DropLocalIndex(defnId common.IndexDefnId) error {
+
+	logging.Debugf("IndexManager.DropLocalIndex(): making request for drop index")
+	return m.requestServer.MakeRequest(client.OPCODE_DROP_INDEX, fmt.Sprintf("%v", defnId), []byte(""))
+}
+
+// PendingBuilds returns the indexes pending build in the builder of this node.
+func (m *IndexManager) PendingBuilds() []common.IndexDefnId {
+	return m.lifecycleMgr.pendingBuilds()
+}
+
 func (m *IndexManager) CleanupPartition(defn common.IndexDefn, updateStatusOnly bool) error {
 
 	inst := &dropInstance{
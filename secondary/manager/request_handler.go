@@ -62,10 +62,24 @@ type IndexRequest struct {
 }
 
 type IndexResponse struct {
-	Version uint64 `json:"version,omitempty"`
-	Code    string `json:"code,omitempty"`
-	Error   string `json:"error,omitempty"`
-	Message string `json:"message,omitempty"`
+	Version      uint64 `json:"version,omitempty"`
+	Code         string `json:"code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Retriable    bool   `json:"retriable,omitempty"`    // the request can be retried as is
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"` // suggested backoff before retrying
+}
+
+// NewIndexErrorResponse returns the response of a request failed with the
+// error msg, with its retry hint.
+func NewIndexErrorResponse(msg string) *IndexResponse {
+	hint := common.GetDDLRetryHint(msg)
+	return &IndexResponse{
+		Code:         RESP_ERROR,
+		Error:        msg,
+		Retriable:    hint.Retriable,
+		RetryAfterMs: int64(hint.RetryAfter / time.Millisecond),
+	}
 }
 
 //
@@ -290,6 +304,9 @@ func RegisterRequestHandler(mgr *IndexManager, mux *http.ServeMux, config common
 		mux.HandleFunc("/getCachedStats", handlerContext.handleCachedStats)
 		mux.HandleFunc("/postScheduleCreateRequest", handlerContext.handleScheduleCreateRequest)
 		mux.HandleFunc("/getInternalVersion", handlerContext.handleInternalVersionRequest)
+		mux.HandleFunc("/listLocalPendingDDL", handlerContext.handleLocalPendingDDLRequest)
+		mux.HandleFunc("/cancelLocalPendingBuild", handlerContext.handleLocalCancelBuildRequest)
+		mux.HandleFunc("/dropLocalIndex", handlerContext.handleLocalDropIndexRequest)
 
 		cacheDir := path.Join(config["storage_dir"].String(), "cache")
 		handlerContext.rhc = NewRequestHandlerCache(cacheDir)
@@ -2390,8 +2407,7 @@ func (m *requestHandlerContext) getLocalReplicaCount(creds cbauth.Creds) (map[co
 ///////////////////////////////////////////////////////
 
 func sendIndexResponseWithError(status int, w http.ResponseWriter, msg string) {
-	res := &IndexResponse{Code: RESP_ERROR, Error: msg}
-	send(status, w, res)
+	send(status, w, NewIndexErrorResponse(msg))
 }
 
 func sendIndexResponse(w http.ResponseWriter) {
@@ -3450,6 +3466,9 @@ func (m *requestHandlerContext) bucketReqHandler(w http.ResponseWriter, r *http.
 			send(http.StatusBadRequest, w, fmt.Sprintf("Unsupported method %v", r.Method))
 		}
 
+	case "export", "import":
+		m.indexBundleHandler(w, r, creds, bucket, function)
+
 	default:
 		send(http.StatusBadRequest, w, fmt.Sprintf("Malformed URL %v", r.URL.Path))
 	}
@@ -16,6 +16,7 @@ var (
 var (
 	ErrNoBlockSpace = errors.New("Not enough space in buffer")
 	ErrNoMoreItem   = errors.New("No more item to be read from buffer")
+	ErrNotBlock     = errors.New("Items are not in a block buffer")
 )
 
 func SetupBlockPool(sz int) {
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Items read from a block point into the block. A reader that passes them
+// on without copying, to the next stage with WriteItemRef or to the network
+// writer, retains the block with BlockRef. The block goes back to the pool
+// when the last reference is released.
+
+// number of items after which a batch of item references is sent
+var refBatchSize = 256
+
+type BlockRef struct {
+	buf  *[]byte
+	refs int32
+}
+
+// NewBlockRef returns a reference to buf, a block from GetBlock.
+func NewBlockRef(buf *[]byte) *BlockRef {
+	return &BlockRef{buf: buf, refs: 1}
+}
+
+func (b *BlockRef) Retain() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+func (b *BlockRef) Release() {
+	if atomic.AddInt32(&b.refs, -1) == 0 {
+		PutBlock(b.buf)
+	}
+}
+
+// refBatch carries items pointing into blocks of an upstream stage. Items
+// of a block are usually written one after the other, so the batch holds
+// one reference for each run of items from the same block.
+type refBatch struct {
+	items [][]byte
+	refOf []int // index in refs of the block of each item
+	refs  []*BlockRef
+	next  int
+}
+
+var refBatchPool = sync.Pool{
+	New: func() interface{} {
+		return &refBatch{
+			items: make([][]byte, 0, refBatchSize),
+			refOf: make([]int, 0, refBatchSize),
+		}
+	},
+}
+
+func newRefBatch() *refBatch {
+	return refBatchPool.Get().(*refBatch)
+}
+
+func (b *refBatch) put(ref *BlockRef, itm []byte) {
+	if itm == nil {
+		itm = []byte{}
+	}
+	if n := len(b.refs); n == 0 || b.refs[n-1] != ref {
+		ref.Retain()
+		b.refs = append(b.refs, ref)
+	}
+	b.items = append(b.items, itm)
+	b.refOf = append(b.refOf, len(b.refs)-1)
+}
+
+func (b *refBatch) len() int {
+	return len(b.items)
+}
+
+func (b *refBatch) get() ([]byte, *BlockRef, error) {
+	if b.next == len(b.items) {
+		return nil, nil, ErrNoMoreItem
+	}
+	b.next++
+	return b.items[b.next-1], b.refs[b.refOf[b.next-1]], nil
+}
+
+// release drops the references to the blocks and recycles the batch. Like
+// items of a block, items of a batch are valid until the reader moves to
+// the next block or batch.
+func (b *refBatch) release() {
+	for i, ref := range b.refs {
+		ref.Release()
+		b.refs[i] = nil
+	}
+	for i := range b.items {
+		b.items[i] = nil
+	}
+	b.items, b.refOf, b.refs, b.next = b.items[:0], b.refOf[:0], b.refs[:0], 0
+	refBatchPool.Put(b)
+}
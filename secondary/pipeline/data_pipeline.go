@@ -7,6 +7,7 @@ type ItemWriter struct {
 	err     error
 
 	wblock *[]byte
+	wbatch *refBatch
 	wchan  chan interface{}
 	wr     BlockBufferWriter
 	closed bool
@@ -16,6 +17,7 @@ type ItemWriter struct {
 
 func (w *ItemWriter) InitWriter() {
 	w.wblock = nil
+	w.wbatch = nil
 	w.closed = false
 	w.killch = make(chan struct{})
 	w.wchan = make(chan interface{}, 1)
@@ -51,6 +53,17 @@ func (w *ItemWriter) sendBlock() error {
 	return nil
 }
 
+func (w *ItemWriter) sendBatch() error {
+	select {
+	case w.wchan <- w.wbatch:
+	case <-w.killch:
+		return ErrSupervisorKill
+	}
+
+	w.wbatch = nil
+	return nil
+}
+
 func (w *ItemWriter) ResizeBlockBuffer(itmLen int) {
 	if w.wr.IsEmpty() && (itmLen > w.wr.cap-w.wr.len) {
 		newBuf := make([]byte, itmLen+4, itmLen+4)
@@ -61,6 +74,12 @@ func (w *ItemWriter) ResizeBlockBuffer(itmLen int) {
 
 func (w *ItemWriter) WriteItem(itm ...[]byte) error {
 	var err error
+	if w.wbatch != nil {
+		if err = w.sendBatch(); err != nil {
+			return err
+		}
+	}
+
 	if w.wblock == nil {
 		w.grabBlock()
 	}
@@ -90,6 +109,34 @@ func (w *ItemWriter) WriteItem(itm ...[]byte) error {
 	return nil
 }
 
+// WriteItemRef writes items read from the block of ref, without copying
+// them. The block is retained until the next stage is done with them.
+func (w *ItemWriter) WriteItemRef(ref *BlockRef, itm ...[]byte) error {
+	if w.wblock != nil && !w.wr.IsEmpty() {
+		// Items written so far go first
+		if err := w.sendBlock(); err != nil {
+			return err
+		}
+		w.wblock = nil
+	}
+
+	if w.wbatch == nil {
+		w.wbatch = newRefBatch()
+	}
+	for _, it := range itm {
+		w.wbatch.put(ref, it)
+	}
+
+	if w.wbatch.len() >= refBatchSize {
+		if err := w.HasShutdown(); err != nil {
+			return err
+		}
+		return w.sendBatch()
+	}
+
+	return nil
+}
+
 func (w *ItemWriter) Channel() chan interface{} {
 	return w.wchan
 }
@@ -105,6 +152,10 @@ func (w *ItemWriter) CloseWrite() error {
 		return err
 	}
 
+	if w.wbatch != nil {
+		w.sendBatch()
+	}
+
 	if w.wblock == nil {
 	} else if w.wr.IsEmpty() {
 		PutBlock(w.wblock)
@@ -137,6 +188,11 @@ func (w *ItemWriter) CloseWithError(err error) {
 		w.wblock = nil
 	}
 
+	if w.wbatch != nil {
+		w.wbatch.release()
+		w.wbatch = nil
+	}
+
 	select {
 	case w.wchan <- err:
 		close(w.wchan)
@@ -146,6 +202,8 @@ func (w *ItemWriter) CloseWithError(err error) {
 
 type ItemReader struct {
 	rblock *[]byte
+	rref   *BlockRef
+	rbatch *refBatch
 	rchan  chan interface{}
 	rr     BlockBufferReader
 
@@ -158,6 +216,7 @@ func (r *ItemReader) SetSource(w Writer) {
 
 func (r *ItemReader) InitReader() {
 	r.rblock = nil
+	r.rbatch = nil
 	r.killch = make(chan struct{})
 }
 
@@ -174,7 +233,10 @@ func (r *ItemReader) grabBlock() error {
 		switch v := x.(type) {
 		case *[]byte:
 			r.rblock = v
+			r.rref = NewBlockRef(v)
 			r.rr.Init(r.rblock)
+		case *refBatch:
+			r.rbatch = v
 		case error:
 			return v
 		}
@@ -185,52 +247,78 @@ func (r *ItemReader) grabBlock() error {
 	return nil
 }
 
+func (r *ItemReader) releaseBlock() {
+	if r.rblock != nil {
+		r.rref.Release()
+		r.rblock = nil
+		r.rref = nil
+	}
+
+	if r.rbatch != nil {
+		r.rbatch.release()
+		r.rbatch = nil
+	}
+}
+
 func (r *ItemReader) PeekBlock() ([]byte, error) {
-	if r.rblock == nil {
+	if r.rblock == nil && r.rbatch == nil {
 		if err := r.grabBlock(); err != nil {
 			return nil, err
 		}
 	}
 
+	if r.rblock == nil {
+		return nil, ErrNotBlock
+	}
+
 	return (*r.rblock)[4 : 4+r.rr.Len()-4], nil
 }
 
 func (r *ItemReader) FlushBlock() {
-	if r.rblock != nil {
-		PutBlock(r.rblock)
-		r.rblock = nil
-	}
+	r.releaseBlock()
 }
 
-func (r *ItemReader) ReadItem() ([]byte, error) {
-	if r.rblock == nil {
-		if err := r.grabBlock(); err != nil {
-			return nil, err
+func (r *ItemReader) readItem() ([]byte, *BlockRef, error) {
+	for {
+		if r.rblock == nil && r.rbatch == nil {
+			if err := r.grabBlock(); err != nil {
+				return nil, nil, err
+			}
 		}
-	}
 
-	itm, err := r.rr.Get()
-	if err == ErrNoMoreItem {
-		PutBlock(r.rblock)
-		r.rblock = nil
-		if err := r.grabBlock(); err != nil {
-			return nil, err
-		}
-		itm, err = r.rr.Get()
-		if err != nil {
-			return nil, err
+		if r.rbatch != nil {
+			if itm, ref, err := r.rbatch.get(); err == nil {
+				return itm, ref, nil
+			}
+		} else if r.rblock != nil {
+			if itm, err := r.rr.Get(); err == nil {
+				return itm, r.rref, nil
+			}
 		}
+
+		r.releaseBlock()
 	}
+}
 
-	return itm, nil
+func (r *ItemReader) ReadItem() ([]byte, error) {
+	itm, _, err := r.readItem()
+	return itm, err
 }
 
-func (r *ItemReader) CloseRead() error {
-	if r.rblock != nil {
-		PutBlock(r.rblock)
-		r.rblock = nil
+// ReadItemRef returns the next item along with a reference to its block,
+// which keeps the item valid until the caller releases it.
+func (r *ItemReader) ReadItemRef() ([]byte, *BlockRef, error) {
+	itm, ref, err := r.readItem()
+	if err != nil {
+		return nil, nil, err
 	}
 
+	ref.Retain()
+	return itm, ref, nil
+}
+
+func (r *ItemReader) CloseRead() error {
+	r.releaseBlock()
 	return nil
 }
 
@@ -241,6 +329,7 @@ type ItemReadWriter struct {
 
 func (rw *ItemReadWriter) InitReadWriter() {
 	rw.rblock = nil
+	rw.rbatch = nil
 	rw.closed = false
 	rw.InitWriter()
 	rw.ItemReader.killch = rw.ItemWriter.killch
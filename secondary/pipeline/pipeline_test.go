@@ -234,3 +234,216 @@ func TestErrorPipeline(t *testing.T) {
 	testFn("filter")
 	testFn("sink")
 }
+
+// refFilter passes items on without copying them
+type refFilter struct {
+	ItemReadWriter
+}
+
+func newRefFilter() *refFilter {
+	f := &refFilter{}
+	f.InitReadWriter()
+	return f
+}
+
+func (f *refFilter) Routine() error {
+	defer f.CloseRead()
+
+	for i := 0; ; i++ {
+		itm, ref, err := f.ReadItemRef()
+		switch err {
+		case nil:
+		case ErrNoMoreItem:
+			f.CloseWrite()
+			return nil
+		case ErrSupervisorKill:
+			return nil
+		default:
+			f.CloseWithError(err)
+			return err
+		}
+
+		// Mix copied and referenced items
+		if i%1000 < 500 {
+			err = f.WriteItemRef(ref, itm)
+		} else {
+			err = f.WriteItem(itm)
+		}
+		ref.Release()
+		if err != nil {
+			f.CloseWithError(err)
+			return err
+		}
+	}
+}
+
+type refSink struct {
+	ItemReader
+	t     *testing.T
+	count int
+}
+
+func (s *refSink) Routine() error {
+	defer s.CloseRead()
+
+	var prev []byte
+	var prevRef *BlockRef
+	i := 0
+	for {
+		itm, ref, err := s.ReadItemRef()
+		if err == ErrNoMoreItem {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// Previous item is valid while its block is retained
+		expected := []byte(fmt.Sprintf("item-%d", i-1))
+		if prevRef != nil && !bytes.Equal(prev, expected) {
+			s.t.Fatalf("got %s, expected %s", prev, expected)
+		}
+		if prevRef != nil {
+			prevRef.Release()
+		}
+
+		prev, prevRef = itm, ref
+		i++
+	}
+
+	if prevRef != nil {
+		prevRef.Release()
+	}
+	if i != s.count {
+		s.t.Errorf("Count: got %v, expected %d", i, s.count)
+	}
+	return nil
+}
+
+func TestRefPipeline(t *testing.T) {
+	SetupBlockPool(512)
+	srcSleep = 0
+
+	for _, i := range []int{0, 1, 255, 256, 10000} {
+		var p Pipeline
+		s := newSrc(i)
+		f := newRefFilter()
+		si := &refSink{t: t, count: i}
+		si.InitReader()
+
+		f.SetSource(s)
+		si.SetSource(f)
+
+		p.AddSource("src", s)
+		p.AddFilter("filter", f)
+		p.AddSink("sink", si)
+		if err := p.Execute(); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	}
+}
+
+type benchSrc struct {
+	ItemWriter
+	items [][]byte
+}
+
+func (s *benchSrc) Routine() error {
+	for _, itm := range s.items {
+		if err := s.WriteItem(itm); err != nil {
+			s.CloseWithError(err)
+			return err
+		}
+	}
+	s.CloseWrite()
+	return nil
+}
+
+type benchFilter struct {
+	ItemReadWriter
+	useRef bool
+}
+
+func (f *benchFilter) Routine() error {
+	defer f.CloseRead()
+
+	for {
+		var err error
+		if f.useRef {
+			var itm []byte
+			var ref *BlockRef
+			if itm, ref, err = f.ReadItemRef(); err == nil {
+				err = f.WriteItemRef(ref, itm)
+				ref.Release()
+			}
+		} else {
+			var itm []byte
+			if itm, err = f.ReadItem(); err == nil {
+				err = f.WriteItem(itm)
+			}
+		}
+
+		if err == ErrNoMoreItem {
+			f.CloseWrite()
+			return nil
+		} else if err != nil {
+			f.CloseWithError(err)
+			return err
+		}
+	}
+}
+
+type benchSink struct {
+	ItemReader
+}
+
+func (s *benchSink) Routine() error {
+	defer s.CloseRead()
+
+	for {
+		if _, err := s.ReadItem(); err == ErrNoMoreItem {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func benchmarkPipeline(b *testing.B, useRef bool) {
+	SetupBlockPool(16 * 1024)
+
+	items := make([][]byte, 10000)
+	for i := range items {
+		items[i] = bytes.Repeat([]byte{'x'}, 100)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(items) * 100))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var p Pipeline
+		s := &benchSrc{items: items}
+		s.InitWriter()
+		f := &benchFilter{useRef: useRef}
+		f.InitReadWriter()
+		si := &benchSink{}
+		si.InitReader()
+
+		f.SetSource(s)
+		si.SetSource(f)
+
+		p.AddSource("src", s)
+		p.AddFilter("filter", f)
+		p.AddSink("sink", si)
+		if err := p.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPipelineCopy(b *testing.B) {
+	benchmarkPipeline(b, false)
+}
+
+func BenchmarkPipelineRef(b *testing.B) {
+	benchmarkPipeline(b, true)
+}
@@ -56,8 +56,9 @@ type BucketFeeder interface {
 
 // concrete type implementing BucketFeeder
 type bucketDcp struct {
-	dcpFeed *couchbase.DcpFeed
-	bucket  *couchbase.Bucket
+	dcpFeed  *couchbase.DcpFeed
+	bucket   *couchbase.Bucket
+	streamId uint16 // non-zero when streams are multiplexed by stream-id
 }
 
 // OpenBucketFeed opens feed for bucket.
@@ -112,9 +113,9 @@ func (bdcp *bucketDcp) StartVbStreams(
 			mid = manifestUIDs[i]
 		}
 
-		e := bdcp.dcpFeed.DcpRequestStream(
-			vbno, opaque, flags, vbuuid, start, end, snapStart, snapEnd,
-			mid, scopeId, collectionIds)
+		e := bdcp.dcpFeed.DcpRequestStreamWithId(
+			bdcp.streamId, vbno, opaque, flags, vbuuid, start, end,
+			snapStart, snapEnd, mid, scopeId, collectionIds)
 		if e != nil {
 			err = e
 			// In case of an error, a clean-up will be triggerred after all the
@@ -141,7 +142,7 @@ func (bdcp *bucketDcp) EndVbStreams(
 	}
 	vbnos := c.Vbno32to16(ts.GetVbnos())
 	for _, vbno := range vbnos {
-		if e := bdcp.dcpFeed.DcpCloseStream(vbno, opaque); e != nil {
+		if e := bdcp.dcpFeed.DcpCloseStreamWithId(bdcp.streamId, vbno, opaque); e != nil {
 			err = e
 		}
 	}
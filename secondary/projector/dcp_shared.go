@@ -0,0 +1,382 @@
+// Multiplexing of keyspace streams over shared DCP connections.
+//
+// By default every keyspace on a feed opens its own set of DCP connections
+// with KV. When many collections of the same bucket are indexed, this
+// multiplies connections, and the buffers associated with them, by the
+// number of collections. With "dcp.multiplexStreams" enabled, keyspaces of
+// a bucket share one set of DCP connections and their vbucket streams are
+// told apart by DCP stream-id:
+//
+//   keyspace-1 (sid 1) <--- stream buffer <---*
+//                                             |
+//   keyspace-2 (sid 2) <--- stream buffer <---*--- runDemux <--- DCP conns
+//                                             |
+//   keyspace-3 (sid 3) <--- stream buffer <---*
+//
+// runDemux does not block on a stream: events are appended to the backlog
+// of the stream and a routine per stream forwards them to the stream
+// buffer, so that a slow keyspace does not hold up the others. DCP
+// buffer-acks are per connection, so back-pressure on KV is applied per
+// stream only once its backlog exceeds "dcp.streamBacklogSize" bytes: the
+// demux then waits for that stream to drain, which in turn stops DCP
+// buffer-acks on the shared connections, without unbounded buffering in
+// projector.
+
+package projector
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/logging"
+
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+// ErrorTooManyStreams
+var ErrorTooManyStreams = errors.New("projector.tooManyStreams")
+
+// sharedBucketDcp is a set of DCP connections for a bucket shared by
+// the streams of several keyspaces.
+type sharedBucketDcp struct {
+	dcpFeed    *couchbase.DcpFeed
+	bucket     *couchbase.Bucket
+	bufsize    int
+	maxBacklog int
+
+	mu        sync.Mutex
+	streams   map[uint16]*bucketDcpStream // stream-id -> stream
+	nextId    uint16
+	closed    bool
+	finch     chan bool
+	logPrefix string
+}
+
+// OpenSharedBucketFeed opens DCP connections for bucket, to be shared by
+// streams created with NewStream. `bufsize` is the number of events
+// buffered for each stream, `maxBacklog` the number of bytes a stream can
+// fall behind before holding up the shared connections.
+func OpenSharedBucketFeed(
+	feedname couchbase.DcpFeedName,
+	b *couchbase.Bucket,
+	opaque uint16,
+	kvaddrs []string,
+	config map[string]interface{},
+	bufsize, maxBacklog int) (*sharedBucketDcp, error) {
+
+	config["streamIds"] = true
+	dcpFeed, err :=
+		b.StartDcpFeedOver(feedname, uint32(0), uint32(0x0), kvaddrs, opaque, config)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := &sharedBucketDcp{
+		dcpFeed:    dcpFeed,
+		bucket:     b,
+		bufsize:    bufsize,
+		maxBacklog: maxBacklog,
+		streams:    make(map[uint16]*bucketDcpStream),
+		finch:      make(chan bool),
+		logPrefix:  "SHAREDDCP[" + string(feedname) + "]",
+	}
+	go shared.runDemux()
+	return shared, nil
+}
+
+// NewStream returns a BucketFeeder for keyspaceId, whose vbucket streams
+// are multiplexed over the shared DCP connections.
+func (shared *sharedBucketDcp) NewStream(keyspaceId string) (BucketFeeder, error) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+
+	if shared.closed {
+		return nil, mc.ErrorInvalidFeed
+	}
+
+	streamId, ok := shared.allocStreamId()
+	if !ok {
+		return nil, ErrorTooManyStreams
+	}
+
+	stream := &bucketDcpStream{
+		bucketDcp: bucketDcp{
+			dcpFeed:  shared.dcpFeed,
+			bucket:   shared.bucket,
+			streamId: streamId,
+		},
+		shared:     shared,
+		keyspaceId: keyspaceId,
+		mutch:      make(chan *mc.DcpEvent, shared.bufsize),
+		kickch:     make(chan bool, 1),
+		drainch:    make(chan bool, 1),
+		finch:      make(chan bool),
+		vbs:        make(map[uint16]uint16),
+	}
+	shared.streams[streamId] = stream
+	go stream.runForward()
+
+	fmsg := "%v stream-id %v opened for keyspace %v"
+	logging.Infof(fmsg, shared.logPrefix, streamId, keyspaceId)
+	return stream, nil
+}
+
+// IsClosed returns true once all streams are closed, or the DCP
+// connections are closed.
+func (shared *sharedBucketDcp) IsClosed() bool {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	return shared.closed
+}
+
+// allocStreamId returns an unused, non-zero, stream-id.
+func (shared *sharedBucketDcp) allocStreamId() (uint16, bool) {
+	for i := 0; i < 0xFFFF; i++ {
+		shared.nextId++
+		if shared.nextId == 0 {
+			shared.nextId++
+		}
+		if _, ok := shared.streams[shared.nextId]; !ok {
+			return shared.nextId, true
+		}
+	}
+	return 0, false
+}
+
+// release removes the stream and closes the DCP connections with the last
+// stream.
+func (shared *sharedBucketDcp) release(streamId uint16) {
+	shared.mu.Lock()
+	delete(shared.streams, streamId)
+	last := len(shared.streams) == 0 && !shared.closed
+	if last {
+		shared.closed = true
+	}
+	shared.mu.Unlock()
+
+	if last {
+		shared.dcpFeed.Close()
+		shared.bucket.Close()
+	}
+}
+
+func (shared *sharedBucketDcp) getStream(streamId uint16) *bucketDcpStream {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	return shared.streams[streamId]
+}
+
+// runDemux routes events from the shared DCP connections to the backlog of
+// the stream they belong to.
+func (shared *sharedBucketDcp) runDemux() {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Errorf("%v runDemux crashed: %v\n", shared.logPrefix, r)
+			logging.Errorf("%s", logging.StackTrace())
+		}
+
+		shared.mu.Lock()
+		shared.closed = true
+		streams := make([]*bucketDcpStream, 0, len(shared.streams))
+		for _, stream := range shared.streams {
+			streams = append(streams, stream)
+		}
+		shared.mu.Unlock()
+
+		// connections are closed, so should the streams.
+		for _, stream := range streams {
+			stream.closeChannel()
+		}
+		close(shared.finch)
+	}()
+
+	for event := range shared.dcpFeed.C {
+		stream := shared.getStream(event.StreamId)
+		if stream == nil {
+			fmsg := "%v dropping %v for vb %v, stream-id %v is closed"
+			logging.Debugf(fmsg, shared.logPrefix, event.Opcode, event.VBucket, event.StreamId)
+			continue
+		}
+		stream.send(event)
+	}
+	logging.Infof("%v DCP connections closed", shared.logPrefix)
+}
+
+// bucketDcpStream implements BucketFeeder for a keyspace over shared DCP
+// connections.
+type bucketDcpStream struct {
+	bucketDcp
+	shared     *sharedBucketDcp
+	keyspaceId string
+
+	mu  sync.Mutex
+	vbs map[uint16]uint16 // vbno -> opaque, for open vbucket streams
+
+	sendMu      sync.Mutex // protects backlog, backlogSize and closed
+	backlog     []*mc.DcpEvent
+	backlogSize int       // bytes of events in backlog
+	kickch      chan bool // signals runForward of a new backlog
+	drainch     chan bool // signals send of a drained backlog
+	mutch       chan *mc.DcpEvent
+	finch       chan bool
+	closeOnce   sync.Once
+	closed      bool
+}
+
+// GetChannel implements Feeder{} interface.
+func (stream *bucketDcpStream) GetChannel() (mutch <-chan *mc.DcpEvent) {
+	return stream.mutch
+}
+
+// StartVbStreams implements Feeder{} interface.
+func (stream *bucketDcpStream) StartVbStreams(
+	opaque uint16, reqTs *protobuf.TsVbuuid) error {
+
+	stream.mu.Lock()
+	for _, vbno := range reqTs.GetVbnos() {
+		stream.vbs[uint16(vbno)] = opaque
+	}
+	stream.mu.Unlock()
+
+	return stream.bucketDcp.StartVbStreams(opaque, reqTs)
+}
+
+// EndVbStreams implements Feeder{} interface.
+func (stream *bucketDcpStream) EndVbStreams(
+	opaque uint16, ts *protobuf.TsVbuuid) (err error, cleanup bool) {
+
+	stream.mu.Lock()
+	for _, vbno := range ts.GetVbnos() {
+		delete(stream.vbs, uint16(vbno))
+	}
+	stream.mu.Unlock()
+
+	return stream.bucketDcp.EndVbStreams(opaque, ts)
+}
+
+// CloseFeed implements Feeder{} interface. Only the vbucket streams of this
+// keyspace are closed, DCP connections are closed with the last stream.
+func (stream *bucketDcpStream) CloseFeed() error {
+	stream.mu.Lock()
+	vbs := stream.vbs
+	stream.vbs = make(map[uint16]uint16)
+	stream.mu.Unlock()
+
+	streamId := stream.streamId
+	for vbno, opaque := range vbs {
+		if err := stream.dcpFeed.DcpCloseStreamWithId(streamId, vbno, opaque); err != nil {
+			fmsg := "%v ##%x DcpCloseStreamWithId(%v, %v): %v"
+			logging.Errorf(fmsg, stream.shared.logPrefix, opaque, streamId, vbno, err)
+		}
+	}
+
+	stream.shared.release(streamId)
+	stream.closeChannel()
+
+	fmsg := "%v stream-id %v closed for keyspace %v"
+	logging.Infof(fmsg, stream.shared.logPrefix, streamId, stream.keyspaceId)
+	return nil
+}
+
+// GetStats implements Feeder{} interface. Stats are for the shared DCP
+// connections.
+func (stream *bucketDcpStream) GetStats() map[string]interface{} {
+	return stream.dcpFeed.GetStats()
+}
+
+// send event to the stream backlog, blocks only while the backlog exceeds
+// the maximum backlog of the shared connections.
+func (stream *bucketDcpStream) send(event *mc.DcpEvent) {
+	switch event.Opcode {
+	case mcd.DCP_STREAMEND:
+		stream.forgetVb(event.VBucket)
+	case mcd.DCP_STREAMREQ:
+		if event.Status != mcd.SUCCESS {
+			stream.forgetVb(event.VBucket)
+		}
+	}
+
+	size := len(event.Key) + len(event.Value)
+	for {
+		stream.sendMu.Lock()
+		if stream.closed {
+			stream.sendMu.Unlock()
+			return
+		}
+		if len(stream.backlog) == 0 || stream.backlogSize+size <= stream.shared.maxBacklog {
+			stream.backlog = append(stream.backlog, event)
+			stream.backlogSize += size
+			stream.sendMu.Unlock()
+
+			select {
+			case stream.kickch <- true:
+			default:
+			}
+			return
+		}
+		stream.sendMu.Unlock()
+
+		// back-pressure, till the stream catches up with its backlog.
+		select {
+		case <-stream.drainch:
+		case <-stream.finch:
+			return
+		}
+	}
+}
+
+// runForward moves events from the stream backlog to the stream buffer,
+// and closes the stream buffer once the stream is closed.
+func (stream *bucketDcpStream) runForward() {
+	defer close(stream.mutch)
+
+	for {
+		stream.sendMu.Lock()
+		events := stream.backlog
+		stream.backlog, stream.backlogSize = nil, 0
+		stream.sendMu.Unlock()
+
+		if len(events) == 0 {
+			select {
+			case <-stream.kickch:
+				continue
+			case <-stream.finch:
+				return
+			}
+		}
+
+		select {
+		case stream.drainch <- true:
+		default:
+		}
+		for _, event := range events {
+			select {
+			case stream.mutch <- event:
+			case <-stream.finch:
+				return
+			}
+		}
+	}
+}
+
+func (stream *bucketDcpStream) forgetVb(vbno uint16) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	delete(stream.vbs, vbno)
+}
+
+// closeChannel unblocks a pending send and drops the backlog, the stream
+// buffer is closed by runForward.
+func (stream *bucketDcpStream) closeChannel() {
+	stream.closeOnce.Do(func() {
+		stream.sendMu.Lock()
+		stream.closed = true
+		stream.backlog, stream.backlogSize = nil, 0
+		stream.sendMu.Unlock()
+
+		close(stream.finch)
+	})
+}
@@ -64,6 +64,8 @@ type Feed struct {
 	rollTss map[string]*protobuf.TsVbuuid // keyspaceId -> TsVbuuid
 
 	feeders map[string]BucketFeeder // keyspaceId -> BucketFeeder{}
+	// DCP connections shared by keyspaces of a bucket, if multiplexed.
+	sharedFeeds map[string]*sharedBucketDcp // bucket -> sharedBucketDcp
 	// downstream
 	kvdata    map[string]*KVData            // keyspaceId -> kvdata
 	engines   map[string]map[uint64]*Engine // keyspaceId -> uuid -> engine
@@ -123,6 +125,8 @@ func NewFeed(
 		actTss:  make(map[string]*protobuf.TsVbuuid),
 		rollTss: make(map[string]*protobuf.TsVbuuid),
 		feeders: make(map[string]BucketFeeder),
+
+		sharedFeeds: make(map[string]*sharedBucketDcp),
 		// downstream
 		kvdata:    make(map[string]*KVData),
 		engines:   make(map[string]map[uint64]*Engine),
@@ -1660,6 +1664,7 @@ func (feed *Feed) cleanupKeyspace(keyspaceId string, enginesOk bool) {
 		feeder.CloseFeed()
 	}
 	delete(feed.feeders, keyspaceId) // :SideEffect:
+	feed.releaseSharedFeeds()
 	// cleanup data structures.
 	if kvdata, ok := feed.kvdata[keyspaceId]; ok {
 		kvdata.Close()
@@ -1678,6 +1683,13 @@ func (feed *Feed) openFeeder(
 	if ok {
 		return feeder, nil
 	}
+	// Streams with OSO snapshots are not multiplexed as OSO is enabled
+	// for the whole connection.
+	if feed.collectionsAware && !feed.osoSnapshot[keyspaceId] &&
+		feed.config["dcp.multiplexStreams"].Bool() {
+		return feed.openSharedFeeder(opaque, pooln, bucketn, keyspaceId)
+	}
+
 	bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn, opaque)
 	if err != nil {
 		return nil, projC.ErrorFeeder
@@ -1690,15 +1702,7 @@ func (feed *Feed) openFeeder(
 		return nil, err
 	}
 	name := newDCPConnectionName(keyspaceId, feed.topic, uuid.Uint64())
-	dcpConfig := map[string]interface{}{
-		"genChanSize":      feed.config["dcp.genChanSize"].Int(),
-		"dataChanSize":     feed.config["dcp.dataChanSize"].Int(),
-		"numConnections":   feed.config["dcp.numConnections"].Int(),
-		"latencyTick":      feed.config["dcp.latencyTick"].Int(),
-		"activeVbOnly":     feed.config["dcp.activeVbOnly"].Bool(),
-		"collectionsAware": feed.collectionsAware,
-		"osoSnapshot":      feed.osoSnapshot[keyspaceId],
-	}
+	dcpConfig := feed.dcpConfig(keyspaceId)
 
 	kvaddr, err := feed.getLocalKVAddrs(pooln, bucketn, opaque)
 	if err != nil {
@@ -1717,6 +1721,79 @@ func (feed *Feed) openFeeder(
 	return feeder, nil
 }
 
+// openSharedFeeder returns a feeder for keyspaceId multiplexed, by
+// stream-id, over the DCP connections shared by all keyspaces of bucketn.
+func (feed *Feed) openSharedFeeder(
+	opaque uint16, pooln, bucketn, keyspaceId string) (BucketFeeder, error) {
+
+	shared, ok := feed.sharedFeeds[bucketn]
+	if !ok || shared.IsClosed() {
+		bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn, opaque)
+		if err != nil {
+			return nil, projC.ErrorFeeder
+		}
+
+		uuid, err := c.NewUUID()
+		if err != nil {
+			fmsg := "%v ##%x c.NewUUID(): %v"
+			logging.Errorf(fmsg, feed.logPrefix, opaque, err)
+			bucket.Close()
+			return nil, err
+		}
+		name := newDCPConnectionName(bucketn, feed.topic, uuid.Uint64())
+
+		kvaddr, err := feed.getLocalKVAddrs(pooln, bucketn, opaque)
+		if err != nil {
+			bucket.Close()
+			return nil, err
+		}
+		feed.kvaddr = kvaddr
+
+		bufsize := feed.config["dcp.streamBufferSize"].Int()
+		maxBacklog := feed.config["dcp.streamBacklogSize"].Int()
+		shared, err = OpenSharedBucketFeed(
+			name, bucket, opaque, []string{kvaddr}, feed.dcpConfig(keyspaceId),
+			bufsize, maxBacklog)
+		if err != nil {
+			fmsg := "%v ##%x OpenSharedBucketFeed(%q): %v"
+			logging.Errorf(fmsg, feed.logPrefix, opaque, bucketn, err)
+			bucket.Close()
+			return nil, projC.ErrorFeeder
+		}
+		feed.sharedFeeds[bucketn] = shared
+	}
+
+	feeder, err := shared.NewStream(keyspaceId)
+	if err != nil {
+		fmsg := "%v ##%x NewStream(%q): %v"
+		logging.Errorf(fmsg, feed.logPrefix, opaque, keyspaceId, err)
+		return nil, projC.ErrorFeeder
+	}
+	return feeder, nil
+}
+
+// releaseSharedFeeds forgets the shared DCP connections of a bucket, once
+// closed with their last stream.
+func (feed *Feed) releaseSharedFeeds() {
+	for bucketn, shared := range feed.sharedFeeds {
+		if shared.IsClosed() {
+			delete(feed.sharedFeeds, bucketn) // :SideEffect:
+		}
+	}
+}
+
+func (feed *Feed) dcpConfig(keyspaceId string) map[string]interface{} {
+	return map[string]interface{}{
+		"genChanSize":      feed.config["dcp.genChanSize"].Int(),
+		"dataChanSize":     feed.config["dcp.dataChanSize"].Int(),
+		"numConnections":   feed.config["dcp.numConnections"].Int(),
+		"latencyTick":      feed.config["dcp.latencyTick"].Int(),
+		"activeVbOnly":     feed.config["dcp.activeVbOnly"].Bool(),
+		"collectionsAware": feed.collectionsAware,
+		"osoSnapshot":      feed.osoSnapshot[keyspaceId],
+	}
+}
+
 // start a feed for a bucket with a set of kvfeeder,
 // based on vbmap and failover-logs.
 func (feed *Feed) bucketFeed(
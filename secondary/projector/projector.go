@@ -267,6 +267,16 @@ func (p *Projector) ResetConfig(config c.Config) {
 	if cv, ok := config["projector.settings.log_level"]; ok {
 		logging.SetLogLevel(logging.Level(cv.String()))
 	}
+	// DCP feeds of the indexes are run by the projector
+	if cv, ok := config["indexer.settings.log_level."+logging.DcpComponent]; ok {
+		if level := cv.String(); level == "" {
+			logging.ResetComponentLogLevel(logging.DcpComponent)
+		} else {
+			logging.Infof("Projector setting log level of %v to %v",
+				logging.DcpComponent, logging.Level(level))
+			logging.SetComponentLogLevel(logging.DcpComponent, logging.Level(level))
+		}
+	}
 	if cv, ok := config["projector.maxCpuPercent"]; ok {
 		val := cv.Int()
 		cpuLimit := atomic.LoadInt32(&p.cpuLimit)
@@ -303,6 +313,16 @@ func (p *Projector) ResetConfig(config c.Config) {
 		p.statsCmdCh <- []interface{}{EVAL_STAT_LOGGING_THRESHOLD, value}
 	}
 
+	if cv, ok := config["projector.evalWallTimeLimit"]; ok {
+		protobuf.SetEvalWallTimeLimit(time.Duration(cv.Int()) * time.Millisecond)
+	}
+	if cv, ok := config["projector.evalBreakerThreshold"]; ok {
+		protobuf.SetEvalBreakerThreshold(cv.Int())
+	}
+	if cv, ok := config["projector.evalBreakerCooldown"]; ok {
+		protobuf.SetEvalBreakerCooldown(time.Duration(cv.Int()) * time.Second)
+	}
+
 	if cv, ok := config["projector.systemStatsCollectionInterval"]; ok {
 		memmanager.SetStatsCollectionInterval(int64(cv.Int()))
 	}
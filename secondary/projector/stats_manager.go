@@ -368,6 +368,19 @@ func (sm *statsManager) logger() {
 									evalStats += fmt.Sprintf("\"%v\":%v,", keyStr+":avgLatency", avg)
 								}
 
+								maxDur := value.(*protobuf.IndexEvaluatorStats).GetAndResetDocMaxDur()
+								if maxDur > evalStatLoggingThreshold {
+									evalStats += fmt.Sprintf("\"%v\":%v,", keyStr+":maxDocLatency", maxDur)
+								}
+								if slow := value.(*protobuf.IndexEvaluatorStats).SlowCount.Value(); slow > 0 {
+									evalStats += fmt.Sprintf("\"%v\":%v,", keyStr+":slowCount", slow)
+								}
+								if trips := value.(*protobuf.IndexEvaluatorStats).BreakerTrips.Value(); trips > 0 {
+									evalStats += fmt.Sprintf("\"%v\":%v,", keyStr+":breakerTrips", trips)
+									evalStats += fmt.Sprintf("\"%v\":%v,", keyStr+":breakerSkipCount",
+										value.(*protobuf.IndexEvaluatorStats).BreakerSkip.Value())
+								}
+
 								errSkip := value.(*protobuf.IndexEvaluatorStats).GetAndResetErrorSkip()
 								errSkipAll := value.(*protobuf.IndexEvaluatorStats).GetErrorSkipAll()
 								if errSkipAll > 0 {
@@ -0,0 +1,85 @@
+package protoProjector
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Limits on the wall time spent evaluating index expressions for a
+// document. Wall time includes the time the worker is not scheduled, so a
+// busy node can exceed the limit with cheap expressions too.
+//
+// Evaluation of an expression cannot be preempted once started. Instead,
+// each IndexEvaluator has a circuit breaker which trips after
+// evalBreakerThreshold consecutive documents exceed evalWallTimeLimit.
+// While tripped, mutations are neither evaluated nor published for that
+// index, so that a pathological expression does not hold up mutation
+// processing for the other indexes on the node. The index keeps its
+// previous entry for a skipped document, which is stale until the document
+// is mutated again; skipped documents are counted in BreakerSkip so that
+// they show up in the evaluator stats. Deletions are always processed.
+// After evalBreakerCooldown, the next document is evaluated again and
+// closes the breaker if it completes within the limit.
+
+var evalWallTimeLimit int64 = 0 // in nanoseconds, zero disables the limit
+var evalBreakerThreshold int64 = 10
+var evalBreakerCooldown int64 = int64(60 * time.Second)
+
+// SetEvalWallTimeLimit sets the wall time limit for evaluating a document
+// for an index. Zero disables the limit, and closes all breakers.
+func SetEvalWallTimeLimit(limit time.Duration) {
+	atomic.StoreInt64(&evalWallTimeLimit, int64(limit))
+}
+
+// SetEvalBreakerThreshold sets the number of consecutive documents over the
+// time limit after which an evaluator is tripped.
+func SetEvalBreakerThreshold(threshold int) {
+	if threshold < 1 {
+		threshold = 1
+	}
+	atomic.StoreInt64(&evalBreakerThreshold, int64(threshold))
+}
+
+// SetEvalBreakerCooldown sets the time for which a tripped evaluator skips
+// evaluation.
+func SetEvalBreakerCooldown(cooldown time.Duration) {
+	atomic.StoreInt64(&evalBreakerCooldown, int64(cooldown))
+}
+
+// evalBreaker is the circuit breaker of an IndexEvaluator. It is safe for
+// concurrent use by multiple workers.
+type evalBreaker struct {
+	slow      int64 // consecutive documents over the limit
+	openUntil int64 // UnixNano, non-zero when tripped
+}
+
+// allow returns false while the breaker is tripped.
+func (b *evalBreaker) allow(now int64) bool {
+	if atomic.LoadInt64(&evalWallTimeLimit) == 0 {
+		return true
+	}
+	openUntil := atomic.LoadInt64(&b.openUntil)
+	return openUntil == 0 || now >= openUntil
+}
+
+// record the wall time taken to evaluate a document. Returns true for the
+// evaluation that trips the breaker, and whether it was over the limit.
+func (b *evalBreaker) record(elapsed time.Duration, now int64) (tripped, slow bool) {
+	limit := atomic.LoadInt64(&evalWallTimeLimit)
+	if limit == 0 || int64(elapsed) <= limit {
+		atomic.StoreInt64(&b.slow, 0)
+		atomic.StoreInt64(&b.openUntil, 0)
+		return false, false
+	}
+
+	// A breaker past its cooldown trips again on the first slow document.
+	halfOpen := atomic.LoadInt64(&b.openUntil) != 0
+	count := atomic.AddInt64(&b.slow, 1)
+	if halfOpen || count >= atomic.LoadInt64(&evalBreakerThreshold) {
+		atomic.StoreInt64(&b.slow, 0)
+		cooldown := atomic.LoadInt64(&evalBreakerCooldown)
+		atomic.StoreInt64(&b.openUntil, now+cooldown)
+		return true, true
+	}
+	return false, true
+}
@@ -0,0 +1,115 @@
+package protoProjector
+
+import (
+	"testing"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+)
+
+func TestEvalBreaker(t *testing.T) {
+	defer SetEvalWallTimeLimit(0)
+	defer SetEvalBreakerThreshold(10)
+	defer SetEvalBreakerCooldown(60 * time.Second)
+
+	SetEvalWallTimeLimit(10 * time.Millisecond)
+	SetEvalBreakerThreshold(3)
+	SetEvalBreakerCooldown(time.Second)
+
+	var b evalBreaker
+	now := time.Now().UnixNano()
+	slowDur, fastDur := 20*time.Millisecond, time.Millisecond
+
+	// fast evaluation resets the count of slow documents
+	b.record(slowDur, now)
+	b.record(slowDur, now)
+	if tripped, slow := b.record(fastDur, now); tripped || slow {
+		t.Fatalf("unexpected tripped %v slow %v", tripped, slow)
+	}
+
+	b.record(slowDur, now)
+	b.record(slowDur, now)
+	if tripped, _ := b.record(slowDur, now); !tripped {
+		t.Fatalf("expected breaker to trip")
+	}
+	if b.allow(now) {
+		t.Fatalf("expected tripped breaker to skip evaluation")
+	}
+
+	// after cooldown, a single slow document trips again
+	now += int64(time.Second)
+	if !b.allow(now) {
+		t.Fatalf("expected breaker to allow after cooldown")
+	}
+	if tripped, _ := b.record(slowDur, now); !tripped {
+		t.Fatalf("expected half-open breaker to trip")
+	}
+
+	// and a fast document closes it
+	now += int64(time.Second)
+	b.record(fastDur, now)
+	if !b.allow(now) || b.openUntil != 0 {
+		t.Fatalf("expected breaker to close")
+	}
+
+	// no limit, no breaker
+	SetEvalWallTimeLimit(0)
+	for i := 0; i < 5; i++ {
+		if tripped, slow := b.record(time.Hour, now); tripped || slow {
+			t.Fatalf("unexpected tripped %v slow %v without limit", tripped, slow)
+		}
+	}
+}
+
+func TestEvalBreakerSkipsMutations(t *testing.T) {
+	defer SetEvalWallTimeLimit(0)
+	SetEvalWallTimeLimit(10 * time.Millisecond)
+
+	endpoints := []string{"indexer1:9104", "indexer2:9104"}
+	inst := MakeInstance(0x7, defn1, "default", "", endpoints).GetIndexInstance()
+	ie := &IndexEvaluator{instance: inst, keyspaceId: "default", stats: &IndexEvaluatorStats{}}
+	ie.stats.Init()
+	ie.breaker.openUntil = time.Now().Add(time.Hour).UnixNano()
+
+	// A tripped evaluator publishes no key for the mutation, rather than
+	// an UpsertDeletion which would drop the document from the index, but
+	// tells the indexers of the index that it is stale.
+	m := &mc.DcpEvent{Opcode: mcd.DCP_MUTATION, Key: []byte("doc1"), Seqno: 10}
+	data := make(map[string]interface{})
+	if _, _, err := ie.TransformRoute(1, m, data, nil, nil, nil, 1, 0, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(data) != len(endpoints) {
+		t.Fatalf("expected skip published to %v, got %v", endpoints, data)
+	}
+	for _, raddr := range endpoints {
+		dkv, ok := data[raddr].(*c.DataportKeyVersions)
+		if !ok {
+			t.Fatalf("expected skip published to %v", raddr)
+		}
+		kv := dkv.Kv
+		if len(kv.Commands) != 1 || kv.Commands[0] != c.EvalSkip ||
+			kv.Uuids[0] != 0x7 || kv.Seqno != 10 {
+			t.Fatalf("expected EvalSkip of instance 7 at seqno 10, got %v", kv)
+		}
+	}
+
+	// Further skips of the same trip are not published again
+	data = make(map[string]interface{})
+	m.Seqno = 11
+	if _, _, err := ie.TransformRoute(1, m, data, nil, nil, nil, 1, 0, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("expected skip of the same trip not published, got %v", data)
+	}
+
+	if skip := ie.stats.BreakerSkip.Value(); skip != 2 {
+		t.Fatalf("expected 2 skipped mutations, got %v", skip)
+	}
+	if errSkip := ie.stats.ErrSkipAll.Value(); errSkip != 0 {
+		t.Fatalf("expected skipped mutation not counted as error, got %v", errSkip)
+	}
+}
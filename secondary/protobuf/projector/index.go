@@ -3,6 +3,7 @@ package protoProjector
 import (
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/indexing/secondary/stats"
@@ -110,6 +111,9 @@ type IndexEvaluator struct {
 	version    FeedVersion
 	xattrs     []string
 	stats      *IndexEvaluatorStats
+	breaker    evalBreaker
+	// openUntil of the trip whose skipped mutations were reported
+	skipReported int64
 
 	// For flattened array index, this variable represents
 	// the number of keys in the flatten_keys expression
@@ -371,12 +375,40 @@ func (ie *IndexEvaluator) TransformRoute(
 	var opcode mcd.CommandCode
 
 	forceUpsertDeletion := false
+	start := time.Now()
+	if m.Opcode == mcd.DCP_MUTATION && !ie.breaker.allow(start.UnixNano()) {
+		// Evaluator is tripped, skip this mutation for the index. Publishing
+		// an UpsertDeletion instead would drop a valid document silently.
+		// The indexer is told of the first skip of the trip, as the index is
+		// stale from then on.
+		ie.stats.BreakerSkip.Add(1)
+		openUntil := atomic.LoadInt64(&ie.breaker.openUntil)
+		if atomic.SwapInt64(&ie.skipReported, openUntil) != openUntil {
+			ie.populateEvalSkip(vbuuid, m, data, numIndexes, opaque2, oso)
+		}
+		return nil, 0, nil
+	}
+
 	npkey, opkey, nkey, okey, newBuf, where, opcode, err = ie.processEvent(m,
 		encodeBuf, docval, context)
 	if err != nil {
 		forceUpsertDeletion = true
 	}
 
+	elapsed := time.Since(start)
+	ie.stats.addDoc(elapsed)
+	tripped, slow := ie.breaker.record(elapsed, start.Add(elapsed).UnixNano())
+	if slow {
+		ie.stats.SlowCount.Add(1)
+	}
+	if tripped {
+		ie.stats.BreakerTrips.Add(1)
+		fmsg := "IndexEvaluator %v:%v evaluation took %v for docid %v, " +
+			"skipping the mutations of this index until cooldown"
+		arg1 := logging.TagStrUD(m.Key)
+		logging.Warnf(fmsg, ie.keyspaceId, ie.GetIndexName(), elapsed, arg1)
+	}
+
 	err1 := ie.populateData(vbuuid, m, data, numIndexes, npkey, opkey, nkey, okey,
 		where, opcode, opaque2, forceUpsertDeletion, oso)
 
@@ -497,6 +529,26 @@ func (ie *IndexEvaluator) populateData(vbuuid uint64, m *mc.DcpEvent,
 	return nil
 }
 
+// populateEvalSkip publishes an EvalSkip for the mutation m to all the
+// endpoints of the index.
+func (ie *IndexEvaluator) populateEvalSkip(vbuuid uint64, m *mc.DcpEvent,
+	data map[string]interface{}, numIndexes int, opaque2 uint64, oso bool) {
+
+	uuid := ie.instance.GetInstId()
+	for _, raddr := range ie.instance.Endpoints() {
+		dkv, ok := data[raddr].(*c.DataportKeyVersions)
+		if !ok {
+			kv := c.NewKeyVersions(m.Seqno, m.Key, numIndexes, m.Ctime)
+			kv.AddEvalSkip(uuid)
+			dkv = &c.DataportKeyVersions{ie.GetKeyspaceId(), m.VBucket, vbuuid,
+				kv, opaque2, oso}
+		} else {
+			dkv.Kv.AddEvalSkip(uuid)
+		}
+		data[raddr] = dkv
+	}
+}
+
 func (ie *IndexEvaluator) Stats() interface{} {
 	return ie.stats
 }
@@ -621,6 +673,23 @@ type IndexEvaluatorStats struct {
 
 	// Total number of mutations skipped since this stat object was initialized.
 	ErrSkipAll stats.Int64Val
+
+	// Per document evaluation time, across all expressions of the index.
+	DocCount    stats.Int64Val
+	DocTotalDur stats.Int64Val
+	// DocMaxDur is the max since the last call to GetAndResetDocMaxDur
+	DocMaxDur stats.Int64Val
+
+	// SlowCount is the number of documents which exceeded the evaluation
+	// time limit.
+	SlowCount stats.Int64Val
+	// BreakerTrips is the number of times evaluation was stopped for
+	// exceeding the evaluation time limit.
+	BreakerTrips stats.Int64Val
+	// BreakerSkip is the number of mutations skipped while stopped, whose
+	// documents the index has stale entries for. The indexer stops serving
+	// scans of the index on the first skip.
+	BreakerSkip stats.Int64Val
 }
 
 func (ie *IndexEvaluatorStats) Init() {
@@ -631,6 +700,12 @@ func (ie *IndexEvaluatorStats) Init() {
 	ie.SMA.Init()
 	ie.ErrSkip.Init()
 	ie.ErrSkipAll.Init()
+	ie.DocCount.Init()
+	ie.DocTotalDur.Init()
+	ie.DocMaxDur.Init()
+	ie.SlowCount.Init()
+	ie.BreakerTrips.Init()
+	ie.BreakerSkip.Init()
 }
 
 func (ies *IndexEvaluatorStats) add(duration time.Duration) {
@@ -638,6 +713,17 @@ func (ies *IndexEvaluatorStats) add(duration time.Duration) {
 	ies.TotalDur.Add(duration.Nanoseconds())
 }
 
+func (ies *IndexEvaluatorStats) addDoc(duration time.Duration) {
+	ies.DocCount.Add(1)
+	ies.DocTotalDur.Add(duration.Nanoseconds())
+	for {
+		max := ies.DocMaxDur.Value()
+		if duration.Nanoseconds() <= max || ies.DocMaxDur.CAS(max, duration.Nanoseconds()) {
+			return
+		}
+	}
+}
+
 // Implements simple moving average. Returns the moving average value
 func (ies *IndexEvaluatorStats) MovingAvg() int64 {
 	count := ies.Count.Value()
@@ -668,3 +754,17 @@ func (ies *IndexEvaluatorStats) GetAndResetErrorSkip() int64 {
 func (ies *IndexEvaluatorStats) GetErrorSkipAll() int64 {
 	return ies.ErrSkipAll.Value()
 }
+
+func (ies *IndexEvaluatorStats) GetAndResetDocMaxDur() int64 {
+	val := ies.DocMaxDur.Value()
+	ies.DocMaxDur.CAS(val, 0)
+	return val
+}
+
+// GetDocAvgDur returns the average evaluation time per document.
+func (ies *IndexEvaluatorStats) GetDocAvgDur() int64 {
+	if count := ies.DocCount.Value(); count > 0 {
+		return ies.DocTotalDur.Value() / count
+	}
+	return 0
+}
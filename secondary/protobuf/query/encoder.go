@@ -21,3 +21,32 @@ func EncodeAndWrite(conn net.Conn, buf []byte, r interface{}) (err error) {
 	err = transport.Send(conn, buf, flags, data, false)
 	return
 }
+
+// EncodeCompressAndWrite is EncodeAndWrite with the encoded response
+// compressed as per `compression`, into zbuf if large enough. A response
+// that does not shrink is written uncompressed. It returns the size of the
+// response before and after compression, and zbuf grown as needed.
+func EncodeCompressAndWrite(conn net.Conn, buf, zbuf []byte, r interface{},
+	compression byte) (size, zsize int, zbufOut []byte, err error) {
+
+	var data, zdata []byte
+	data, err = ProtobufEncodeInBuf(r, buf[transport.MaxSendBufSize:][:0])
+	if err != nil {
+		return 0, 0, zbuf, err
+	}
+	if zdata, err = transport.Compress(compression, zbuf, data); err != nil {
+		return 0, 0, zbuf, err
+	}
+	if compression != transport.CompressionNone && cap(zdata) > cap(zbuf) {
+		zbuf = zdata[:0]
+	}
+
+	flags := transport.TransportFlag(0).SetProtobuf()
+	if len(zdata) < len(data) {
+		flags = flags.SetCompression(compression)
+	} else {
+		zdata = data
+	}
+	err = transport.Send(conn, buf, flags, zdata, false)
+	return len(data), len(zdata), zbuf, err
+}
@@ -0,0 +1,113 @@
+package protoQuery
+
+import "strings"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/golang/protobuf/proto"
+
+// ScanError is an error sent back by the indexer, with its ErrorCode.
+// Its text is the text of the error in the indexer.
+type ScanError struct {
+	msg  string
+	code ErrorCode
+	hint c.RetryHint
+}
+
+func (e *ScanError) Error() string {
+	return e.msg
+}
+
+// Code returns the ErrorCode of the error.
+func (e *ScanError) Code() ErrorCode {
+	return e.code
+}
+
+// RetryHint returns whether the scan can be retried, and when.
+func (e *ScanError) RetryHint() c.RetryHint {
+	return e.hint
+}
+
+// NewError returns the Error message of err with code, and the suggested
+// backoff retryAfter if it is retriable.
+func NewError(err error, code ErrorCode, retryAfter time.Duration) *Error {
+	e := &Error{
+		Error:     proto.String(err.Error()),
+		Code:      code.Enum(),
+		Retriable: proto.Bool(IsRetriableCode(code)),
+	}
+	if retryAfter > 0 && e.GetRetriable() {
+		e.RetryAfterMs = proto.Uint32(uint32(retryAfter / time.Millisecond))
+	}
+	return e
+}
+
+// IsRetriableCode returns true if scans failed with code can be retried as
+// is, on the same indexer or a replica.
+func IsRetriableCode(code ErrorCode) bool {
+	switch code {
+	case ErrorCode_RollbackInProgress, ErrorCode_IndexNotReady,
+		ErrorCode_QuotaExceeded, ErrorCode_Timeout, ErrorCode_IndexerBootstrap:
+		return true
+	}
+	return false
+}
+
+// Err returns the error of the message, or nil on success. The code of
+// errors from indexers not sending codes is guessed from their text, and
+// their retry hint from their code.
+func (e *Error) Err() error {
+	msg := e.GetError()
+	if msg == "" {
+		return nil
+	}
+
+	code := e.GetCode()
+	if e.Code == nil {
+		code = errorCodeFromText(msg)
+	}
+
+	hint := c.RetryHint{Retriable: IsRetriableCode(code)}
+	if e.Retriable != nil {
+		hint.Retriable = e.GetRetriable()
+	}
+	if hint.Retriable {
+		hint.RetryAfter = time.Duration(e.GetRetryAfterMs()) * time.Millisecond
+	}
+	return &ScanError{msg: msg, code: code, hint: hint}
+}
+
+// GetErrorCode returns the ErrorCode of err, or ErrorCode_Unknown if it was
+// not sent back by the indexer.
+func GetErrorCode(err error) ErrorCode {
+	if e, ok := err.(*ScanError); ok {
+		return e.code
+	}
+	return ErrorCode_Unknown
+}
+
+// Error text of older indexers, by prefix. The rollback errors are defined
+// by the indexer package.
+var errorTextCodes = []struct {
+	prefix string
+	code   ErrorCode
+}{
+	{"Indexer rollback", ErrorCode_RollbackInProgress},
+	{c.ErrIndexNotReady.Error(), ErrorCode_IndexNotReady},
+	{c.ErrIndexNotFound.Error(), ErrorCode_IndexNotFound},
+	{c.ErrScanThrottled.Error(), ErrorCode_QuotaExceeded},
+	{c.ErrScanTimedOut.Error(), ErrorCode_Timeout},
+	{c.ErrAuthMissing.Error(), ErrorCode_Auth},
+	{c.ErrScanNotAuthorized.Error(), ErrorCode_Auth},
+	{c.ErrClientCancel.Error(), ErrorCode_ClientCancel},
+	{c.ErrIndexerInBootstrap.Error(), ErrorCode_IndexerBootstrap},
+}
+
+func errorCodeFromText(msg string) ErrorCode {
+	for _, tc := range errorTextCodes {
+		if strings.HasPrefix(msg, tc.prefix) {
+			return tc.code
+		}
+	}
+	return ErrorCode_Unknown
+}
@@ -1,10 +1,12 @@
 package protoQuery
 
+import "encoding/binary"
 import "errors"
 import json "github.com/couchbase/indexing/secondary/common/json"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/golang/protobuf/proto"
+import "github.com/golang/snappy"
 
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) GetEntries(dataEncFmt c.DataEncodingFormat) (*c.ScanResultEntries, [][]byte, error) {
@@ -40,12 +42,38 @@ func (r *ResponseStream) GetEntries(dataEncFmt c.DataEncodingFormat) (*c.ScanRes
 	return result, pkeys, nil
 }
 
+// SnapshotTs returns the timestamp of the scanned snapshot, if sent with
+// the response, or nil.
+func (r *ResponseStream) SnapshotTs() (*c.TsVbuuid, error) {
+	if data := r.GetSnapshotSeqnos(); len(data) > 0 {
+		return DecodeSnapshotSeqnos(data)
+	}
+	return nil, nil
+}
+
+// SnapshotIdentity returns the id of the scanned snapshot, if sent with the
+// response. See common.TsVbuuid.SnapshotId.
+func (r *ResponseStream) SnapshotIdentity() (uint64, bool) {
+	if r.SnapshotId != nil {
+		return *r.SnapshotId, true
+	}
+	return 0, false
+}
+
+// Groups returns whether the groups of the rows of a scan with partial
+// aggregates may repeat in later responses, and how many of the leading
+// rows of the response are of groups which are final.
+func (r *ResponseStream) Groups() (groupsRepeat bool, closedRows int, ok bool) {
+	if h := r.GetGroupHints(); h != nil {
+		return h.GetGroupsRepeat(), int(h.GetClosedRows()), true
+	}
+	return true, 0, false
+}
+
 // Error implements queryport.client.ResponseReader{} method.
 func (r *ResponseStream) Error() error {
 	if e := r.GetErr(); e != nil {
-		if ee := e.GetError(); ee != "" {
-			return errors.New(ee)
-		}
+		return e.Err()
 	}
 	return nil
 }
@@ -59,9 +87,7 @@ func (r *StreamEndResponse) GetEntries(dataEncFmt c.DataEncodingFormat) (*c.Scan
 // Error implements queryport.client.ResponseReader{} method.
 func (r *StreamEndResponse) Error() error {
 	if e := r.GetErr(); e != nil {
-		if ee := e.GetError(); ee != "" {
-			return errors.New(ee)
-		}
+		return e.Err()
 	}
 	return nil
 }
@@ -112,3 +138,64 @@ func NewTsConsistency(
 		Crc64: proto.Uint64(crc64),
 	}
 }
+
+const snapshotSeqnosVersion = 1
+
+// ErrSnapshotSeqnos is returned when snapshot seqnos cannot be decoded.
+var ErrSnapshotSeqnos = errors.New("invalid snapshot seqnos")
+
+// EncodeSnapshotSeqnos encodes the seqno, vbuuid and snapshot markers of
+// each vbucket of ts, from which DCP streams can be resumed. They are
+// encoded with c.EncodeTsVbuuid and compressed with snappy.
+func EncodeSnapshotSeqnos(ts *c.TsVbuuid) []byte {
+	return snappy.Encode(nil, c.EncodeTsVbuuid(ts))
+}
+
+// DecodeSnapshotSeqnos decodes data encoded with EncodeSnapshotSeqnos, or
+// with its earlier version, varint seqnos after the version and number of
+// vbuckets, in which the bucket of the returned timestamp is not set.
+func DecodeSnapshotSeqnos(data []byte) (*c.TsVbuuid, error) {
+	buf, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.IsCompactTsVbuuid(buf) {
+		ts, err := c.DecodeTsVbuuid(buf)
+		if err != nil {
+			return nil, ErrSnapshotSeqnos
+		}
+		return ts, nil
+	}
+
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, ErrSnapshotSeqnos
+		}
+		buf = buf[n:]
+		return v, nil
+	}
+
+	if version, err := next(); err != nil || version != snapshotSeqnosVersion {
+		return nil, ErrSnapshotSeqnos
+	}
+	numVbs, err := next()
+	if err != nil || numVbs > uint64(len(buf)) {
+		return nil, ErrSnapshotSeqnos
+	}
+
+	ts := c.NewTsVbuuid("", int(numVbs))
+	for vb := 0; vb < int(numVbs); vb++ {
+		var vals [4]uint64
+		for i := range vals {
+			if vals[i], err = next(); err != nil {
+				return nil, err
+			}
+		}
+		ts.Seqnos[vb] = vals[0]
+		ts.Vbuuids[vb] = vals[1]
+		ts.Snapshots[vb] = [2]uint64{vals[2], vals[3]}
+	}
+	return ts, nil
+}
@@ -0,0 +1,305 @@
+package queryport
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	c "github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+// Connections authenticate with an AuthRequest before their first request,
+// by default with the user and password of the request, against cbauth.
+//
+// Services outside the topology managed by ns_server, like test harnesses
+// and tooling, can instead authenticate with:
+//
+//   - a JWT in the token of the AuthRequest, signed with HS256 by the secret
+//     in the file queryport.auth.jwt.secret_file, whose subject is the
+//     principal. The secret is kept out of the config, which is logged.
+//   - the client certificate of a TLS connection, verified against the
+//     cluster CA, whose common name is the principal.
+//
+// The roles of principals are set in queryport.auth.principals, a JSON
+// object from "jwt:<subject>" or "cert:<common name>" to a list of roles:
+//
+//	admin                              any permission
+//	scan[bucket[:scope[:collection]]]  list and scan indexes of the keyspace,
+//	                                   including the n1ql select scans are
+//	                                   authorized with, "*" matching any name
+//
+// A connection whose certificate is not of a configured principal, and that
+// does not send a token, authenticates with cbauth.
+
+// Authenticator authenticates the connections of a Server, returning the
+// credentials their requests are authorized with.
+type Authenticator interface {
+	Authenticate(conn net.Conn, req *protobuf.AuthRequest) (cbauth.Creds, error)
+}
+
+// ErrInvalidToken is returned for a JWT that fails verification.
+var ErrInvalidToken = errors.New("invalid token")
+
+type cbauthAuthenticator struct{}
+
+func (cbauthAuthenticator) Authenticate(conn net.Conn, req *protobuf.AuthRequest) (cbauth.Creds, error) {
+	return cbauth.Auth(req.GetUser(), req.GetPass())
+}
+
+// principalAuthenticator authenticates JWTs and client certificates of
+// configured principals, and falls back to cbauth.
+type principalAuthenticator struct {
+	cbauthAuthenticator
+
+	jwtSecret  []byte
+	jwtIssuer  string
+	principals map[string][]principalRole
+	now        func() time.Time
+}
+
+// NewAuthenticator returns the Authenticator for the queryport config.
+func NewAuthenticator(config c.Config) (Authenticator, error) {
+	var secret []byte
+	if file := config["auth.jwt.secret_file"].String(); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if secret = bytes.TrimSpace(data); len(secret) == 0 {
+			return nil, fmt.Errorf("empty JWT secret in %v", file)
+		}
+	}
+	principals, err := parsePrincipals(config["auth.principals"].String())
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 && len(principals) == 0 {
+		return cbauthAuthenticator{}, nil
+	}
+
+	return &principalAuthenticator{
+		jwtSecret:  secret,
+		jwtIssuer:  config["auth.jwt.issuer"].String(),
+		principals: principals,
+		now:        time.Now,
+	}, nil
+}
+
+func (a *principalAuthenticator) Authenticate(conn net.Conn, req *protobuf.AuthRequest) (cbauth.Creds, error) {
+	if token := req.GetToken(); token != "" {
+		if len(a.jwtSecret) == 0 {
+			return nil, errors.New("JWT authentication is not enabled")
+		}
+		subject, err := verifyJWT(token, a.jwtSecret, a.jwtIssuer, a.now())
+		if err != nil {
+			return nil, err
+		}
+		return a.creds("jwt:" + subject)
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		if len(state.VerifiedChains) > 0 && len(state.PeerCertificates) > 0 {
+			name := "cert:" + state.PeerCertificates[0].Subject.CommonName
+			if _, ok := a.principals[name]; ok {
+				return a.creds(name)
+			}
+		}
+	}
+
+	return a.cbauthAuthenticator.Authenticate(conn, req)
+}
+
+func (a *principalAuthenticator) creds(name string) (cbauth.Creds, error) {
+	roles, ok := a.principals[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown principal %v", name)
+	}
+	return &principalCreds{name: name, roles: roles}, nil
+}
+
+// verifyJWT verifies an HS256 JWT signed with secret, its expiry, and its
+// issuer if not empty. It returns the subject of the token.
+func verifyJWT(token string, secret []byte, issuer string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", ErrInvalidToken
+	}
+
+	var claims struct {
+		Sub string `json:"sub"`
+		Iss string `json:"iss"`
+		Exp int64  `json:"exp"`
+		Nbf int64  `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil || claims.Sub == "" {
+		return "", ErrInvalidToken
+	}
+	if claims.Exp == 0 || now.Unix() >= claims.Exp {
+		return "", errors.New("token expired")
+	}
+	if claims.Nbf != 0 && now.Unix() < claims.Nbf {
+		return "", errors.New("token not yet valid")
+	}
+	if issuer != "" && claims.Iss != issuer {
+		return "", fmt.Errorf("token issuer %v not accepted", claims.Iss)
+	}
+	return claims.Sub, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// principalRole is admin, or scan of the indexes of a keyspace.
+type principalRole struct {
+	admin    bool
+	keyspace []string // bucket, scope, collection, shorter for wider ones
+}
+
+var scanRoleRe = regexp.MustCompile(`^scan\[([^\[\]:]+(:[^\[\]:]+){0,2})\]$`)
+
+func parsePrincipalRole(role string) (principalRole, error) {
+	if role == "admin" {
+		return principalRole{admin: true}, nil
+	}
+	if m := scanRoleRe.FindStringSubmatch(role); m != nil {
+		return principalRole{keyspace: strings.Split(m[1], ":")}, nil
+	}
+	return principalRole{}, fmt.Errorf("invalid role %v", role)
+}
+
+// parsePrincipals parses queryport.auth.principals.
+func parsePrincipals(value string) (map[string][]principalRole, error) {
+	principals := make(map[string][]principalRole)
+	if value == "" {
+		return principals, nil
+	}
+
+	var roles map[string][]string
+	if err := json.Unmarshal([]byte(value), &roles); err != nil {
+		return nil, err
+	}
+	for name, names := range roles {
+		if !strings.HasPrefix(name, "jwt:") && !strings.HasPrefix(name, "cert:") {
+			return nil, fmt.Errorf("invalid principal %v", name)
+		}
+		for _, role := range names {
+			r, err := parsePrincipalRole(role)
+			if err != nil {
+				return nil, fmt.Errorf("%v of principal %v", err, name)
+			}
+			principals[name] = append(principals[name], r)
+		}
+	}
+	return principals, nil
+}
+
+// keyspace permissions, like cluster.collection[bucket:scope:collection].n1ql.index!scan
+var keyspacePermissionRe = regexp.MustCompile(`^cluster\.(bucket|scope|collection)\[([^\]]+)\]\.n1ql\.(\w+!\w+)$`)
+
+// scanPermissions are the n1ql permissions granted by scan roles.
+var scanPermissions = map[string]bool{
+	"index!scan":     true,
+	"index!list":     true,
+	"select!execute": true,
+}
+
+// principalCreds are the credentials of a principal authenticated by the
+// queryport.
+type principalCreds struct {
+	name  string
+	roles []principalRole
+}
+
+var _ cbauth.Creds = (*principalCreds)(nil)
+
+var errPrincipalUnsupported = errors.New("not supported for principals authenticated by the queryport")
+
+func (p *principalCreds) Name() string {
+	return p.name
+}
+
+func (p *principalCreds) Domain() string {
+	return "external"
+}
+
+func (p *principalCreds) User() (string, string) {
+	return p.Name(), p.Domain()
+}
+
+func (p *principalCreds) IsAllowed(permission string) (bool, error) {
+	var keyspace []string
+	var op string
+	if m := keyspacePermissionRe.FindStringSubmatch(permission); m != nil {
+		keyspace, op = strings.Split(m[2], ":"), m[3]
+	}
+
+	for _, role := range p.roles {
+		if role.admin {
+			return true, nil
+		}
+		if scanPermissions[op] && role.allows(keyspace) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsAllowedInternal is not supported, principals are never granted the
+// internal permissions of the cluster.
+func (p *principalCreds) IsAllowedInternal(permission string) (bool, error) {
+	return false, errPrincipalUnsupported
+}
+
+// Expiry returns the zero time, the credentials are checked once per
+// connection.
+func (p *principalCreds) Expiry() time.Time {
+	return time.Time{}
+}
+
+// allows returns whether the keyspace of the role includes keyspace.
+func (r principalRole) allows(keyspace []string) bool {
+	if len(keyspace) < len(r.keyspace) {
+		return false
+	}
+	for i, name := range r.keyspace {
+		if name != "*" && name != keyspace[i] {
+			return false
+		}
+	}
+	return true
+}
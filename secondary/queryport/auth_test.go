@@ -0,0 +1,119 @@
+package queryport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func makeJWT(header, claims string, secret []byte) string {
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1000, 0)
+	header := `{"alg":"HS256","typ":"JWT"}`
+
+	token := makeJWT(header, `{"sub":"tool","iss":"harness","exp":2000}`, secret)
+	if sub, err := verifyJWT(token, secret, "harness", now); err != nil || sub != "tool" {
+		t.Fatalf("expected token of tool verified, got %v %v", sub, err)
+	}
+
+	if _, err := verifyJWT(token, []byte("other"), "", now); err != ErrInvalidToken {
+		t.Fatalf("expected token signed with another secret rejected, got %v", err)
+	}
+	if _, err := verifyJWT(token, secret, "", time.Unix(2000, 0)); err == nil {
+		t.Fatalf("expected expired token rejected")
+	}
+	if _, err := verifyJWT(token, secret, "other", now); err == nil {
+		t.Fatalf("expected token of another issuer rejected")
+	}
+
+	for _, token := range []string{
+		makeJWT(`{"alg":"none"}`, `{"sub":"tool","exp":2000}`, secret),
+		makeJWT(header, `{"sub":"tool"}`, secret),
+		makeJWT(header, `{"exp":2000}`, secret),
+		makeJWT(header, `{"sub":"tool","exp":2000,"nbf":1500}`, secret),
+		"a.b",
+	} {
+		if _, err := verifyJWT(token, secret, "", now); err == nil {
+			t.Fatalf("expected token %v rejected", token)
+		}
+	}
+}
+
+func TestPrincipalCreds(t *testing.T) {
+	principals, err := parsePrincipals(`{
+		"jwt:tool": ["scan[b1:s1]", "scan[*:_default:_default]"],
+		"cert:admin": ["admin"]}`)
+	if err != nil {
+		t.Fatalf("expected principals parsed, got %v", err)
+	}
+
+	a := &principalAuthenticator{principals: principals}
+	tool, err := a.creds("jwt:tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for permission, allowed := range map[string]bool{
+		"cluster.collection[b1:s1:c1].n1ql.index!scan":             true,
+		"cluster.scope[b1:s1].n1ql.index!list":                     true,
+		"cluster.collection[b2:_default:_default].n1ql.index!scan": true,
+		"cluster.bucket[b1].n1ql.index!scan":                       false,
+		"cluster.collection[b1:s2:c1].n1ql.index!scan":             false,
+		"cluster.collection[b1:s1:c1].n1ql.index!drop":             false,
+		"cluster.collection[b1:s1:c1].n1ql.update!execute":         false,
+		"cluster.settings!write":                                   false,
+	} {
+		if ok, _ := tool.IsAllowed(permission); ok != allowed {
+			t.Fatalf("expected %v allowed %v, got %v", permission, allowed, ok)
+		}
+	}
+
+	// Scans are authorized with the permission of the index defn
+	for _, tc := range []struct {
+		defn    c.IndexDefn
+		allowed bool
+	}{
+		{c.IndexDefn{Bucket: "b1", Scope: "s1", Collection: "c1"}, true},
+		{c.IndexDefn{Bucket: "b2"}, true},
+		{c.IndexDefn{Bucket: "b1", Scope: "s2", Collection: "c1"}, false},
+		{c.IndexDefn{Bucket: "b2", Scope: "s1", Collection: "c1"}, false},
+	} {
+		permission := c.GetScanPermission(&tc.defn)
+		if ok, _ := tool.IsAllowed(permission); ok != tc.allowed {
+			t.Fatalf("expected %v allowed %v, got %v", permission, tc.allowed, ok)
+		}
+	}
+
+	admin, _ := a.creds("cert:admin")
+	if ok, _ := admin.IsAllowed("cluster.settings!write"); !ok {
+		t.Fatalf("expected admin allowed any permission")
+	}
+	if ok, err := admin.IsAllowedInternal("cluster.admin.internal!all"); ok || err == nil {
+		t.Fatalf("expected internal permissions not supported, got %v, %v", ok, err)
+	}
+	if _, err := a.creds("jwt:unknown"); err == nil {
+		t.Fatalf("expected unknown principal rejected")
+	}
+
+	for _, value := range []string{
+		`{"tool": ["admin"]}`,
+		`{"jwt:tool": ["scan"]}`,
+		`{"jwt:tool": ["scan[b:s:c:x]"]}`,
+		`["jwt:tool"]`,
+	} {
+		if _, err := parsePrincipals(value); err == nil {
+			t.Fatalf("expected principals %v rejected", value)
+		}
+	}
+}
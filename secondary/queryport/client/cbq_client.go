@@ -235,7 +235,8 @@ func (b *cbqClient) GetScanports() (queryports []string) {
 func (b *cbqClient) GetScanport(
 	defnID uint64,
 	excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
-	skips map[common.IndexDefnId]bool) (queryport []string,
+	skips map[common.IndexDefnId]bool,
+	sel *ReplicaSelection) (queryport []string,
 	targetDefnID uint64, targetIndstID []uint64, rollbackTime []int64,
 	partition [][]common.PartitionId, numPartition uint32, ok bool) {
 
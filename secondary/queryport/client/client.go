@@ -29,6 +29,7 @@ import (
 	"github.com/couchbase/indexing/secondary/logging"
 	mclient "github.com/couchbase/indexing/secondary/manager/client"
 	"github.com/couchbase/indexing/secondary/security"
+	"github.com/couchbase/indexing/secondary/transport"
 	"github.com/couchbase/query/value"
 )
 
@@ -103,6 +104,10 @@ type CompositeElementFilter struct {
 type IndexProjection struct {
 	EntryKeys  []int64
 	PrimaryKey bool
+
+	// If > 0, only the first entry per distinct value of the leading
+	// DistinctOnKeys index keys is returned (DISTINCT ON).
+	DistinctOnKeys int64
 }
 
 //Groupby/Aggregate
@@ -212,11 +217,13 @@ type BridgeAccessor interface {
 	// if `retry` is ZERO, pick the indexer under least
 	// load, else do a round-robin, based on the retry count,
 	// if more than one indexer is found hosing the index or an
-	// equivalent index.
+	// equivalent index. A non-nil `sel` overrides the random pick
+	// of replicas.
 	GetScanport(
 		defnID uint64,
 		excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
-		skips map[common.IndexDefnId]bool) (queryport []string, targetDefnID uint64, targetInstID []uint64,
+		skips map[common.IndexDefnId]bool,
+		sel *ReplicaSelection) (queryport []string, targetDefnID uint64, targetInstID []uint64,
 		rollbackTime []int64, partition [][]common.PartitionId, numPartitions uint32, ok bool)
 
 	// GetIndexDefn will return the index-definition structure for defnID.
@@ -341,6 +348,11 @@ type GsiAccessor interface {
 		scans Scans, distinct bool,
 		cons common.Consistency, vector *TsConsistency) (int64, error)
 
+	// EstimateScans returns the estimated number of rows of scans and
+	// the average entry size of the index, without scanning.
+	EstimateScans(
+		defnID uint64, requestId string, scans Scans) (int64, int64, error)
+
 	// Count using MultiScan
 	MultiScanCountInternal(
 		defnID uint64, requestId string,
@@ -1082,12 +1094,14 @@ func (c *GsiClient) MultiScanCountInternal(
 		}
 		if c.bridge.IsPrimary(uint64(index.DefnId)) {
 			count, err = qc.MultiScanCountPrimary(
-				uint64(index.DefnId), requestId, scans, distinct, cons, vector, rollbackTime, partitions, broker.DoRetry())
+				uint64(index.DefnId), requestId, scans, distinct, cons, vector, rollbackTime, partitions,
+				broker.GetSessionId(), broker.DoRetry())
 			return count, err, false
 		}
 
 		count, err = qc.MultiScanCount(
-			uint64(index.DefnId), requestId, scans, distinct, cons, vector, rollbackTime, partitions, broker.DoRetry())
+			uint64(index.DefnId), requestId, scans, distinct, cons, vector, rollbackTime, partitions,
+			broker.GetSessionId(), broker.DoRetry())
 		return count, err, false
 	}
 
@@ -1100,6 +1114,53 @@ func (c *GsiClient) MultiScanCountInternal(
 	return count, err
 }
 
+// EstimateScans returns the estimated number of rows of scans and the
+// average entry size of the index, so that the query planner can cost
+// alternative indexes without scanning them.
+func (c *GsiClient) EstimateScans(
+	defnID uint64, requestId string, scans Scans) (count int64, avgEntrySize int64, err error) {
+
+	if c.bridge == nil {
+		return 0, 0, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return 0, 0, err
+	}
+
+	begin := time.Now()
+
+	// Partitions may be estimated by several indexers, so the average
+	// entry size is weighed by their counts.
+	var mutex sync.Mutex
+	var totalCount, totalSize int64
+
+	broker := makeDefaultRequestBroker(nil, c.GetDataEncodingFormat())
+	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId) (int64, error, bool) {
+		count, avgSize, err := qc.EstimateScans(uint64(index.DefnId), requestId, scans,
+			c.bridge.IsPrimary(uint64(index.DefnId)), rollbackTime, partitions, broker.DoRetry())
+		if err == nil {
+			mutex.Lock()
+			totalCount += count
+			totalSize += count * avgSize
+			mutex.Unlock()
+		}
+		return count, err, false
+	}
+
+	broker.SetCountRequestHandler(handler)
+
+	count, err = c.doScan(defnID, requestId, broker)
+	if err == nil && totalCount > 0 {
+		avgEntrySize = totalSize / totalCount
+	}
+
+	fmsg := "EstimateScans {%v,%v} - elapsed(%v) err(%v)"
+	logging.Verbosef(fmsg, defnID, requestId, time.Since(begin), err)
+	return count, avgEntrySize, err
+}
+
 func (c *GsiClient) Scan3(
 	defnID uint64, requestId string, scans Scans, reverse,
 	distinct bool, projection *IndexProjection, offset, limit int64,
@@ -1147,14 +1208,14 @@ func (c *GsiClient) Scan3Internal(
 				uint64(index.DefnId), requestId, scans, reverse, distinct,
 				projection, broker.GetOffset(), broker.GetLimit(), groupAggr,
 				broker.GetSorted(), cons, vector, handler, rollbackTime,
-				partitions, dataEncFmt, broker.DoRetry())
+				partitions, dataEncFmt, broker.GetReplicaPreference(), broker.GetSessionId(), broker.DoRetry())
 		}
 
 		return qc.Scan3(
 			uint64(index.DefnId), requestId, scans, reverse, distinct,
 			projection, broker.GetOffset(), broker.GetLimit(), groupAggr,
 			broker.GetSorted(), cons, vector, handler, rollbackTime,
-			partitions, dataEncFmt, broker.DoRetry())
+			partitions, dataEncFmt, broker.GetReplicaPreference(), broker.GetSessionId(), broker.DoRetry())
 	}
 
 	broker.SetScanRequestHandler(handler)
@@ -1222,7 +1283,7 @@ func (c *GsiClient) StorageStatistics(defnID uint64, requestId string) ([]map[st
 		return nil, nil
 	}
 
-	if queryports, _, targetInstIds, _, partitions, _, ok := c.bridge.GetScanport(defnID, excludes, skips); ok {
+	if queryports, _, targetInstIds, _, partitions, _, ok := c.bridge.GetScanport(defnID, excludes, skips, nil); ok {
 
 		// urls is list of Stats REST endpoints for all indexer nodes
 		// hosting the requested index
@@ -1590,7 +1651,8 @@ func (c *GsiClient) doScan(defnID uint64, requestId string, broker *RequestBroke
 	for i := 0; true; {
 		foundScanport := false
 
-		queryports, targetDefnID, targetInstIds, rollbackTimes, partitions, numPartitions, ok := c.bridge.GetScanport(defnID, excludes, skips)
+		queryports, targetDefnID, targetInstIds, rollbackTimes, partitions, numPartitions, ok := c.bridge.GetScanport(defnID, excludes, skips,
+			broker.GetReplicaSelection())
 		var index *common.IndexDefn
 		if ok {
 			index = c.bridge.GetIndexDefn(targetDefnID)
@@ -1946,6 +2008,9 @@ func isgone(scan_err error) bool {
 	// if a scan is sent to a already crashed indexer, it will return connection refused
 	if scan_err == io.EOF {
 		return true
+	} else if scan_err == transport.ErrorMuxClosed || scan_err == transport.ErrorMuxStreamClosed {
+		// stream of a multiplexed connection closed by the server
+		return true
 	} else if err, ok := scan_err.(net.Error); ok && err.Timeout() {
 		return true
 	} else if strings.Contains(scan_err.Error(), syscall.ECONNRESET.Error()) || // connection reset
@@ -3,7 +3,9 @@ package client
 import "errors"
 import "fmt"
 import "net"
+import "strings"
 import "time"
+import "sync"
 import "sync/atomic"
 
 import "github.com/couchbase/indexing/secondary/common"
@@ -15,6 +17,7 @@ import "github.com/couchbase/cbauth"
 
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 import gometrics "github.com/rcrowley/go-metrics"
+import "github.com/golang/protobuf/proto"
 
 const (
 	CONN_RELEASE_INTERVAL      = 5  // Seconds. Don't change as long as go-metrics/ewma is being used.
@@ -50,6 +53,14 @@ type connectionPool struct {
 	kaInterval       time.Duration
 	authHost         string
 	cluster          string
+
+	// With multiplexing, the pooled connections are streams of mux
+	muxWindow int
+	muxMu     sync.Mutex
+	mux       *transport.MuxSession
+
+	// compressions of scan responses offered on each connection
+	compressions []uint32
 }
 
 type connection struct {
@@ -100,17 +111,33 @@ func newConnectionPool(
 // ConnPoolTimeout is notified whenever connections are acquired from a pool.
 var ConnPoolCallback func(host string, source string, start time.Time, err error)
 
+func (cp *connectionPool) newPacket() *transport.TransportPacket {
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	return pkt
+}
+
 func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
+	cn, _, err := cp.dial(host, 0)
+	if err != nil {
+		return nil, err
+	}
+	return cp.offerCompression(cn)
+}
+
+// dial opens an authenticated connection to host, asking the server to
+// multiplex it if muxWindow is not 0. It returns the window per stream if
+// the server agreed.
+func (cp *connectionPool) dial(host string, muxWindow uint32) (*connection, uint32, error) {
 	logging.Infof("%v open new connection ...\n", cp.logPrefix)
 	conn, err := security.MakeConn(host)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	flags := transport.TransportFlag(0).SetProtobuf()
-	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
-	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
-	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	pkt := cp.newPacket()
 
 	if cp.kaInterval > time.Duration(0) {
 		tcpconn, ok := conn.(*net.TCPConn)
@@ -127,28 +154,143 @@ func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 	}
 
 	// Do Auth
-	err = cp.doAuth(cn)
+	muxWindow, err = cp.doAuth(cn, muxWindow)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return cn, muxWindow, nil
+}
+
+// setMultiplex makes the pool multiplex its connections over a single
+// connection to the server, with a window of muxWindow bytes per stream.
+func (cp *connectionPool) setMultiplex(muxWindow int) {
+	cp.muxWindow = muxWindow
+	cp.mkConn = cp.mkStream
+}
+
+// mkStream opens a stream of the multiplexed connection to host, opening
+// the connection if needed. If the server does not multiplex connections,
+// the connection opened is returned instead.
+func (cp *connectionPool) mkStream(host string) (*connection, error) {
+	cn, err := cp.openStream(host)
 	if err != nil {
 		return nil, err
 	}
+	return cp.offerCompression(cn)
+}
+
+func (cp *connectionPool) openStream(host string) (*connection, error) {
+	cp.muxMu.Lock()
+	defer cp.muxMu.Unlock()
+
+	if cp.mux == nil || cp.mux.IsClosed() {
+		cn, muxWindow, err := cp.dial(host, uint32(cp.muxWindow))
+		if err != nil {
+			return nil, err
+		} else if muxWindow == 0 {
+			logging.Verbosef("%v server does not multiplex connection %q",
+				cp.logPrefix, cn.conn.LocalAddr())
+			return cn, nil
+		}
+
+		logging.Infof("%v multiplexing connection %q, window %v\n",
+			cp.logPrefix, cn.conn.LocalAddr(), muxWindow)
+		cp.mux = transport.NewMuxSession(cn.conn, true, int(muxWindow))
+	}
 
+	stream, err := cp.mux.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &connection{conn: stream, pkt: cp.newPacket(), authenticated: true}, nil
+}
+
+// setCompression makes the pool offer compressions of scan responses on
+// each connection it opens, in order of preference.
+func (cp *connectionPool) setCompression(compressions []uint32) {
+	cp.compressions = compressions
+}
+
+// parseCompressions returns the compressions named in a comma separated
+// list, skipping none and the ones not supported.
+func parseCompressions(names string) []uint32 {
+	var compressions []uint32
+	for _, name := range strings.Split(names, ",") {
+		compression, ok := transport.CompressionByName(name)
+		if !ok {
+			logging.Warnf("parseCompressions: unsupported compression %q", name)
+		} else if compression != transport.CompressionNone {
+			compressions = append(compressions, uint32(compression))
+		}
+	}
+	return compressions
+}
+
+// offerCompression offers the compressions of the pool on cn, with a
+// HeloRequest. The server compresses scan responses with the first one it
+// allows, if any; each response is flagged with its compression. cn is
+// closed on error.
+func (cp *connectionPool) offerCompression(cn *connection) (*connection, error) {
+	if len(cp.compressions) == 0 {
+		return cn, nil
+	}
+
+	req := &protobuf.HeloRequest{
+		Version:      proto.Uint32(uint32(protobuf.ProtobufVersion())),
+		Compressions: cp.compressions,
+	}
+	resp, err := cp.heloExchange(cn, req)
+	if err != nil {
+		logging.Errorf("%v offerCompression error %v for connection (%v,%v)",
+			cp.logPrefix, err, cn.conn.LocalAddr(), cn.conn.RemoteAddr())
+		cn.conn.Close()
+		return nil, err
+	}
+
+	logging.Verbosef("%v connection %q compression %v", cp.logPrefix, cn.conn.LocalAddr(),
+		transport.CompressionName(byte(resp.GetCompression())))
 	return cn, nil
 }
 
-func (cp *connectionPool) doAuth(conn *connection) error {
+func (cp *connectionPool) heloExchange(cn *connection, req *protobuf.HeloRequest) (*protobuf.HeloResponse, error) {
+	if err := cn.pkt.Send(cn.conn, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := cn.pkt.Receive(cn.conn)
+	if err != nil {
+		return nil, err
+	}
+	heloResp, ok := resp.(*protobuf.HeloResponse)
+	if !ok {
+		return nil, errors.New("Invalid helo response")
+	}
+
+	// End of response
+	if _, err := cn.pkt.Receive(cn.conn); err != nil {
+		return nil, err
+	}
+	return heloResp, nil
+}
+
+// doAuth authenticates conn, asking the server to multiplex it if
+// muxWindow is not 0. It returns the window per stream if the server
+// agreed.
+func (cp *connectionPool) doAuth(conn *connection, muxWindow uint32) (uint32, error) {
 
 	// Check if auth is supported / configured before doing auth
 	if common.GetClusterVersion() < common.INDEXER_71_VERSION {
 		logging.Verbosef("%v doAuth Auth is not needed for connection (%v,%v)",
 			cp.logPrefix, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
-		return nil
+		return 0, nil
 	}
 
 	user, pass, err := cp.getAuthInfo()
 	if err != nil {
 		logging.Errorf("%v doAuth error %v in getAuthInfo for connection (%v,%v)",
 			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
-		return err
+		return 0, err
 	}
 
 	// Send Auth packet.
@@ -156,12 +298,15 @@ func (cp *connectionPool) doAuth(conn *connection) error {
 		User: &user,
 		Pass: &pass,
 	}
+	if muxWindow > 0 {
+		authReq.MuxWindow = &muxWindow
+	}
 
 	err = conn.pkt.Send(conn.conn, authReq)
 	if err != nil {
 		logging.Errorf("%v doAuth pkt.Send returns error %v for connection (%v,%v)",
 			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
-		return err
+		return 0, err
 	}
 
 	// Receive Auth response.
@@ -170,27 +315,27 @@ func (cp *connectionPool) doAuth(conn *connection) error {
 	if err != nil {
 		logging.Errorf("%v doAuth pkt.Receive returns error %v for connection (%v,%v)",
 			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
-		return err
+		return 0, err
 	}
 
 	authResp, ok := resp.(*protobuf.AuthResponse)
 	if !ok {
 		logging.Errorf("%v doAuth invalid auth response from %v for connection (%v,%v)",
 			cp.logPrefix, cp.host, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
-		return errors.New("Invalid auth response")
+		return 0, errors.New("Invalid auth response")
 	}
 
 	if authResp.GetCode() != transport.AUTH_SUCCESS {
 		logging.Errorf("%v doAuth invalid auth credentials for connection (%v,%v)",
 			cp.logPrefix, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
-		return transport.ErrorAuthFailure
+		return 0, transport.ErrorAuthFailure
 	}
 
 	conn.authenticated = true
 	logging.Verbosef("%v doAuth auth successful for connection (%v,%v)",
 		cp.logPrefix, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
 
-	return nil
+	return authResp.GetMuxWindow(), nil
 }
 
 func (cp *connectionPool) getAuthInfo() (string, string, error) {
@@ -257,6 +402,12 @@ func (cp *connectionPool) Close() (err error) {
 	for connectn := range cp.connections {
 		connectn.conn.Close()
 	}
+
+	cp.muxMu.Lock()
+	if cp.mux != nil {
+		cp.mux.Close()
+	}
+	cp.muxMu.Unlock()
 	logging.Infof("%v ... stopped\n", cp.logPrefix)
 	return
 }
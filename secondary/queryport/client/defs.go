@@ -21,9 +21,11 @@ type IndexRequest struct {
 }
 
 type IndexResponse struct {
-	Version uint64 `json:"version,omitempty"`
-	Code    string `json:"code,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Version      uint64 `json:"version,omitempty"`
+	Code         string `json:"code,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Retriable    bool   `json:"retriable,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
 }
 
 type IndexIdList struct {
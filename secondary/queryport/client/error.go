@@ -2,6 +2,10 @@ package client
 
 import "errors"
 import "fmt"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 
 // ErrorProtocol
 var ErrorProtocol = errors.New("queryport.client.protocol")
@@ -27,10 +31,11 @@ var ErrorInvalidConsistency = errors.New("queryport.invalidConsistency")
 // ErrorExpectedTimestamp
 var ErrorExpectedTimestamp = errors.New("queryport.expectedTimestamp")
 
-// These error strings need to be in sync with common.ErrIndexNotFound
-// and common.ErrIndexNotReady.
+// These error strings need to be in sync with common.ErrIndexNotFound,
+// common.ErrIndexNotReady and common.ErrScanNotAuthorized.
 var ErrIndexNotFound = fmt.Errorf("Index not found")
 var ErrIndexNotReady = fmt.Errorf("Index not ready for serving queries")
+var ErrScanNotAuthorized = fmt.Errorf("Unauthorized access. User does not have permission to scan the index.")
 
 var errorDescriptions = map[string]string{
 	ErrorProtocol.Error():            "fatal protocol error with server",
@@ -43,4 +48,59 @@ var errorDescriptions = map[string]string{
 	ErrorExpectedTimestamp.Error():   "consistency timestamp is expected",
 	ErrIndexNotFound.Error():         "index is deleted or node hosting index is down",
 	ErrIndexNotReady.Error():         ErrIndexNotReady.Error(),
+	ErrScanNotAuthorized.Error():     "user does not have query select permission on the collection of the index",
+}
+
+// ddlError is the error of a DDL request failed on an indexer, with the
+// retry hint sent back by the indexer.
+type ddlError struct {
+	msg  string
+	hint common.RetryHint
+	err  error // error of the request, if not from a response
+}
+
+func newDDLError(response *IndexResponse) *ddlError {
+	if !response.Retriable {
+		// older indexers do not send retry hints
+		return &ddlError{msg: response.Error, hint: common.GetDDLRetryHint(response.Error)}
+	}
+
+	hint := common.RetryHint{
+		Retriable:  true,
+		RetryAfter: time.Duration(response.RetryAfterMs) * time.Millisecond,
+	}
+	return &ddlError{msg: response.Error, hint: hint}
+}
+
+// wrapDDLError returns err of a create, drop or build request with its
+// retry hint, matched by the text of err.
+func wrapDDLError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ddlError); ok {
+		return err
+	}
+	return &ddlError{msg: err.Error(), hint: common.GetDDLRetryHint(err.Error()), err: err}
+}
+
+func (e *ddlError) Error() string {
+	return e.msg
+}
+
+func (e *ddlError) Unwrap() error {
+	return e.err
+}
+
+// GetRetryHint returns whether a scan or DDL request failed with err can be
+// retried as is, and the suggested backoff before retrying. It is the hint
+// sent back by the indexer, if any.
+func GetRetryHint(err error) common.RetryHint {
+	switch e := err.(type) {
+	case *protobuf.ScanError:
+		return e.RetryHint()
+	case *ddlError:
+		return e.hint
+	}
+	return common.GetDDLRetryHint(err.Error())
 }
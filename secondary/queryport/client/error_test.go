@@ -0,0 +1,47 @@
+// Copyright 2024-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestWrapDDLError(t *testing.T) {
+	if err := wrapDDLError(nil); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	cause := fmt.Errorf("Fail to create index: %v", common.ErrRebalanceRunning)
+	err := wrapDDLError(cause)
+	if err.Error() != cause.Error() || !errors.Is(err, cause) {
+		t.Errorf("expected %v wrapped, got %v", cause, err)
+	}
+	hint := GetRetryHint(err)
+	if !hint.Retriable || hint.RetryAfter != 30*time.Second {
+		t.Errorf("expected retriable after 30s, got %+v", hint)
+	}
+	if wrapDDLError(err) != err {
+		t.Errorf("expected DDL error not wrapped again")
+	}
+
+	if hint := GetRetryHint(wrapDDLError(common.ErrIndexAlreadyExists)); hint.Retriable {
+		t.Errorf("expected %v not retriable, got %+v", common.ErrIndexAlreadyExists, hint)
+	}
+
+	// hints sent back by the indexer take precedence.
+	response := &IndexResponse{Code: RESP_ERROR, Error: "error", Retriable: true, RetryAfterMs: 100}
+	if hint := GetRetryHint(wrapDDLError(newDDLError(response))); hint.RetryAfter != 100*time.Millisecond {
+		t.Errorf("expected retry after 100ms, got %+v", hint)
+	}
+}
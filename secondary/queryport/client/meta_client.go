@@ -340,7 +340,7 @@ RETRY:
 		refreshCnt++
 		goto RETRY
 	}
-	return uint64(defnID), err
+	return uint64(defnID), wrapDDLError(err)
 }
 
 // BuildIndexes implements BridgeAccessor{} interface.
@@ -357,7 +357,7 @@ func (b *metadataClient) BuildIndexes(defnIDs []uint64) error {
 	for i, id := range defnIDs {
 		ids[i] = common.IndexDefnId(id)
 	}
-	return b.mdClient.BuildIndexes(ids)
+	return wrapDDLError(b.mdClient.BuildIndexes(ids))
 }
 
 // MoveIndex implements BridgeAccessor{} interface.
@@ -406,7 +406,7 @@ func (b *metadataClient) MoveIndex(defnID uint64, planJSON map[string]interface{
 		return err
 	}
 	if response.Code == RESP_ERROR {
-		return errors.New(response.Error)
+		return newDDLError(response)
 	}
 
 	return nil
@@ -423,7 +423,7 @@ func (b *metadataClient) DropIndex(defnID uint64) error {
 	if err == nil { // cleanup index local cache.
 		b.safeupdate(nil, false /*force*/)
 	}
-	return err
+	return wrapDDLError(err)
 }
 
 // GetScanports implements BridgeAccessor{} interface.
@@ -439,7 +439,7 @@ func (b *metadataClient) GetScanports() (queryports []string) {
 
 // GetScanport implements BridgeAccessor{} interface.
 func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
-	skips map[common.IndexDefnId]bool) (qp []string,
+	skips map[common.IndexDefnId]bool, sel *ReplicaSelection) (qp []string,
 	targetDefnID uint64, in []uint64, rt []int64, pid [][]common.PartitionId, numPartitions uint32, ok bool) {
 
 	var insts map[common.PartitionId]*mclient.InstanceDefn
@@ -459,7 +459,7 @@ func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDef
 		n++
 	}
 
-	insts, rollbackTimes, ok = b.pickRandom(replicas[:n], defnID, excludes[common.IndexDefnId(defnID)])
+	insts, rollbackTimes, ok = b.pickRandom(replicas[:n], defnID, excludes[common.IndexDefnId(defnID)], sel)
 	if !ok {
 		if len(currmeta.equivalents[common.IndexDefnId(defnID)]) > 1 || len(currmeta.replicas[common.IndexDefnId(defnID)]) > 1 {
 			// skip this index definition for retry only if there is equivalent index or replica
@@ -988,8 +988,10 @@ func (b *metadataClient) pickEquivalent(defnID uint64, skips map[common.IndexDef
 // 1) a map of partition Id and index instance
 // 2) a map of partition Id and rollback timestamp
 //
+// A non-nil sel orders the replicas by its preference rather than at random.
+//
 func (b *metadataClient) pickRandom(replicas []uint64, defnID uint64,
-	excludes map[common.PartitionId]map[uint64]bool) (map[common.PartitionId]*mclient.InstanceDefn, map[common.PartitionId]int64, bool) {
+	excludes map[common.PartitionId]map[uint64]bool, sel *ReplicaSelection) (map[common.PartitionId]*mclient.InstanceDefn, map[common.PartitionId]int64, bool) {
 
 	//
 	// Determine number of partitions and its range
@@ -1054,14 +1056,22 @@ func (b *metadataClient) pickRandom(replicas []uint64, defnID uint64,
 		return result
 	}
 	replicas = shuffle(replicas)
+	replicas = b.orderReplicas(currmeta, replicas, sel)
+	if sel != nil && len(replicas) == 0 {
+		logging.Errorf("PickRandom: No replica of index %v for replica preference %v", defnID, sel.Preference)
+		return nil, nil, false
+	}
 
 	//
 	// Filter out inst based on pending item stats.
 	//
 	rollbackTimesList := b.pruneStaleReplica(replicas, excludes)
 
-	// Filter based on timing of scan responses
-	b.filterByTiming(currmeta, replicas, rollbackTimesList, startPartnId, endPartnId)
+	// Filter based on timing of scan responses, unless the replicas are
+	// picked by preference
+	if sel == nil {
+		b.filterByTiming(currmeta, replicas, rollbackTimesList, startPartnId, endPartnId)
+	}
 
 	//
 	// Randomly select an inst after filtering
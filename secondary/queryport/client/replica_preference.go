@@ -0,0 +1,150 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package client
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync"
+
+	common "github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+// The replica of each partition of an index a scan goes to is picked at
+// random among the replicas which are not stale, spreading the load. A
+// request can rather set a preference on its RequestBroker:
+//
+//   ReplicaLocalOnly     replicas on the node of the client only, to debug
+//                        the replicas of a node. The indexer rejects such
+//                        scans from clients on other nodes.
+//   ReplicaPreferPrimary replica 0 of each partition, if it is usable.
+//   ReplicaSticky        the same replicas for the same sticky key, for
+//                        repeated requests to find the same cached pages.
+//
+// Stale replicas are skipped whatever the preference, as with random
+// picks, but replicas which are slow to respond are not.
+
+// ReplicaSelection is the replica preference of a scan request.
+type ReplicaSelection struct {
+	Preference protobuf.ReplicaPreference
+	StickyHash uint64 // of the sticky key, for ReplicaSticky
+}
+
+// NewReplicaSelection returns the replica selection of preference. The
+// replicas of ReplicaSticky are picked by the hash of stickyKey.
+func NewReplicaSelection(preference protobuf.ReplicaPreference, stickyKey string) *ReplicaSelection {
+	sel := &ReplicaSelection{Preference: preference}
+	if preference == protobuf.ReplicaPreference_ReplicaSticky {
+		h := fnv.New64a()
+		h.Write([]byte(stickyKey))
+		sel.StickyHash = h.Sum64()
+	}
+	return sel
+}
+
+// orderReplicas orders the shuffled replicas as sel prefers them picked,
+// dropping those sel does not allow.
+func (b *metadataClient) orderReplicas(currmeta *indexTopology, replicas []uint64,
+	sel *ReplicaSelection) []uint64 {
+
+	if sel == nil {
+		return replicas
+	}
+
+	switch sel.Preference {
+	case protobuf.ReplicaPreference_ReplicaLocalOnly:
+		local := make([]uint64, 0, len(replicas))
+		for _, replica := range replicas {
+			if b.isLocalReplica(currmeta, replica) {
+				local = append(local, replica)
+			}
+		}
+		return local
+
+	case protobuf.ReplicaPreference_ReplicaPreferPrimary:
+		sort.SliceStable(replicas, func(i, j int) bool {
+			return replicaId(currmeta, replicas[i]) < replicaId(currmeta, replicas[j])
+		})
+
+	case protobuf.ReplicaPreference_ReplicaSticky:
+		sort.Slice(replicas, func(i, j int) bool { return replicas[i] < replicas[j] })
+		if n := len(replicas); n > 1 {
+			k := int(sel.StickyHash % uint64(n))
+			replicas = append(replicas[k:], replicas[:k]...)
+		}
+	}
+
+	return replicas
+}
+
+func replicaId(currmeta *indexTopology, instId uint64) uint64 {
+	if inst, ok := currmeta.insts[common.IndexInstId(instId)]; ok {
+		return inst.ReplicaId
+	}
+	if inst, ok := currmeta.rebalInsts[common.IndexInstId(instId)]; ok {
+		return inst.ReplicaId
+	}
+	return 0
+}
+
+// isLocalReplica tells if all the partitions of the replica are on the
+// node of the client.
+func (b *metadataClient) isLocalReplica(currmeta *indexTopology, instId uint64) bool {
+	inst, ok := currmeta.insts[common.IndexInstId(instId)]
+	if !ok || len(inst.IndexerId) == 0 {
+		return false
+	}
+
+	for _, indexerId := range inst.IndexerId {
+		queryport, ok := currmeta.queryports[indexerId]
+		if !ok {
+			return false
+		}
+		host, _, err := net.SplitHostPort(queryport)
+		if err != nil || !isLocalHost(host) {
+			return false
+		}
+	}
+	return true
+}
+
+var localHosts sync.Map // host -> bool
+
+// isLocalHost tells if host is an address of this node.
+func isLocalHost(host string) bool {
+	if local, ok := localHosts.Load(host); ok {
+		return local.(bool)
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips = ips[:0]
+		if addrs, err := net.LookupIP(host); err == nil {
+			ips = addrs
+		}
+	}
+
+	local := false
+	addrs, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			local = true
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				local = true
+			}
+		}
+	}
+
+	localHosts.Store(host, local)
+	return local
+}
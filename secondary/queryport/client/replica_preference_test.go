@@ -0,0 +1,62 @@
+// Copyright 2022-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	common "github.com/couchbase/indexing/secondary/common"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+func TestOrderReplicas(t *testing.T) {
+	currmeta := &indexTopology{
+		queryports: map[common.IndexerId]string{
+			"local":  "127.0.0.1:9101",
+			"remote": "192.0.2.1:9101",
+		},
+		insts: map[common.IndexInstId]*mclient.InstanceDefn{
+			10: {ReplicaId: 2, IndexerId: map[common.PartitionId]common.IndexerId{0: "remote"}},
+			11: {ReplicaId: 0, IndexerId: map[common.PartitionId]common.IndexerId{0: "remote"}},
+			12: {ReplicaId: 1, IndexerId: map[common.PartitionId]common.IndexerId{0: "local"}},
+		},
+	}
+	b := &metadataClient{}
+
+	order := func(sel *ReplicaSelection, replicas ...uint64) string {
+		return fmt.Sprint(b.orderReplicas(currmeta, replicas, sel))
+	}
+
+	if g := order(nil, 12, 10, 11); g != "[12 10 11]" {
+		t.Errorf("expected the random order without a preference, got %v", g)
+	}
+
+	localOnly := NewReplicaSelection(protobuf.ReplicaPreference_ReplicaLocalOnly, "")
+	if g := order(localOnly, 10, 11, 12); g != "[12]" {
+		t.Errorf("expected the local replica only, got %v", g)
+	}
+	if g := order(localOnly, 10, 11); g != "[]" {
+		t.Errorf("expected no replica, got %v", g)
+	}
+
+	primary := NewReplicaSelection(protobuf.ReplicaPreference_ReplicaPreferPrimary, "")
+	if g := order(primary, 12, 10, 11); g != "[11 12 10]" {
+		t.Errorf("expected the replicas by replica id, got %v", g)
+	}
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		sticky := NewReplicaSelection(protobuf.ReplicaPreference_ReplicaSticky, key)
+		e := order(sticky, 10, 11, 12)
+		if g := order(sticky, 12, 11, 10); g != e {
+			t.Errorf("sticky key %v: expected the same order %v, got %v", key, e, g)
+		}
+	}
+}
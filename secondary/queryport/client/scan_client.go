@@ -10,7 +10,6 @@
 
 package client
 
-import "errors"
 import "fmt"
 import "io"
 import "net"
@@ -63,6 +62,12 @@ func NewGsiScanClient(queryport, cluster string, config common.Config) (*GsiScan
 		queryport, c.poolSize, c.poolOverflow, c.maxPayload, c.cpTimeout,
 		c.cpAvailWaitTimeout, c.minPoolSizeWM, c.relConnBatchSize, config["keepAliveInterval"].Int(),
 		cluster)
+	if config["settings.multiplex"].Bool() {
+		c.pool.setMultiplex(config["settings.muxWindow"].Int())
+	}
+	if compressions := parseCompressions(config["settings.compression"].String()); len(compressions) > 0 {
+		c.pool.setCompression(compressions)
+	}
 	logging.Infof("%v started ...\n", c.logPrefix)
 
 	if version, err := c.Helo(); err == nil || err == io.EOF {
@@ -121,7 +126,7 @@ func (c *GsiScanClient) LookupStatistics(
 	}
 	statResp := resp.(*protobuf.StatisticsResponse)
 	if statResp.GetErr() != nil {
-		err = errors.New(statResp.GetErr().GetError())
+		err = statResp.GetErr().Err()
 		return nil, err
 	}
 	return statResp.GetStats(), nil
@@ -156,7 +161,7 @@ func (c *GsiScanClient) RangeStatistics(
 	}
 	statResp := resp.(*protobuf.StatisticsResponse)
 	if statResp.GetErr() != nil {
-		err = errors.New(statResp.GetErr().GetError())
+		err = statResp.GetErr().Err()
 		return nil, err
 	}
 	return statResp.GetStats(), nil
@@ -463,6 +468,9 @@ func (c *GsiScanClient) MultiScan(
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
 		}
+		if projection.DistinctOnKeys > 0 {
+			protoProjection.DistinctOnKeys = proto.Int64(projection.DistinctOnKeys)
+		}
 	}
 
 	partnIds := make([]uint64, len(partitions))
@@ -568,6 +576,9 @@ func (c *GsiScanClient) MultiScanPrimary(
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
 		}
+		if projection.DistinctOnKeys > 0 {
+			protoProjection.DistinctOnKeys = proto.Int64(projection.DistinctOnKeys)
+		}
 	}
 
 	partnIds := make([]uint64, len(partitions))
@@ -639,7 +650,7 @@ func (c *GsiScanClient) CountLookup(
 	}
 	countResp := resp.(*protobuf.CountResponse)
 	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
+		err = countResp.GetErr().Err()
 		return 0, err
 	}
 	return countResp.GetCount(), nil
@@ -673,7 +684,7 @@ func (c *GsiScanClient) CountLookupPrimary(
 	}
 	countResp := resp.(*protobuf.CountResponse)
 	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
+		err = countResp.GetErr().Err()
 		return 0, err
 	}
 	return countResp.GetCount(), nil
@@ -722,7 +733,7 @@ func (c *GsiScanClient) CountRange(
 	}
 	countResp := resp.(*protobuf.CountResponse)
 	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
+		err = countResp.GetErr().Err()
 		return 0, err
 	}
 	return countResp.GetCount(), nil
@@ -761,7 +772,7 @@ func (c *GsiScanClient) CountRangePrimary(
 	}
 	countResp := resp.(*protobuf.CountResponse)
 	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
+		err = countResp.GetErr().Err()
 		return 0, err
 	}
 	return countResp.GetCount(), nil
@@ -769,9 +780,152 @@ func (c *GsiScanClient) CountRangePrimary(
 
 func (c *GsiScanClient) MultiScanCount(
 	defnID uint64, requestId string, scans Scans, distinct bool,
-	cons common.Consistency, vector *TsConsistency, rollbackTime int64, partitions []common.PartitionId, retry bool) (int64, error) {
+	cons common.Consistency, vector *TsConsistency, rollbackTime int64, partitions []common.PartitionId,
+	sessionId string, retry bool) (int64, error) {
 
-	// serialize scans
+	protoScans, err := marshalCountScans(scans)
+	if err != nil {
+		return 0, err
+	}
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.CountRequest{
+		DefnID:    proto.Uint64(defnID),
+		RequestId: proto.String(requestId),
+		Span: &protobuf.Span{
+			Range: nil,
+		},
+		Distinct:     proto.Bool(distinct),
+		Scans:        protoScans,
+		Cons:         proto.Uint32(uint32(cons)),
+		RollbackTime: proto.Int64(rollbackTime),
+		PartitionIds: partnIds,
+	}
+
+	if vector != nil {
+		req.Vector = protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+	}
+	if sessionId != "" {
+		req.SessionId = proto.String(sessionId)
+	}
+
+	resp, err := c.doRequestResponse(req, requestId, retry)
+	if err != nil {
+		return 0, err
+	}
+	countResp := resp.(*protobuf.CountResponse)
+	if countResp.GetErr() != nil {
+		err = countResp.GetErr().Err()
+		return 0, err
+	}
+	return countResp.GetCount(), nil
+}
+
+func (c *GsiScanClient) MultiScanCountPrimary(
+	defnID uint64, requestId string, scans Scans, distinct bool,
+	cons common.Consistency, vector *TsConsistency, rollbackTime int64, partitions []common.PartitionId,
+	sessionId string, retry bool) (int64, error) {
+
+	protoScans := marshalPrimaryCountScans(scans)
+	if len(protoScans) == 0 {
+		return 0, nil
+	}
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.CountRequest{
+		DefnID:    proto.Uint64(defnID),
+		RequestId: proto.String(requestId),
+		Span: &protobuf.Span{
+			Range: nil,
+		},
+		Distinct:     proto.Bool(distinct),
+		Scans:        protoScans,
+		Cons:         proto.Uint32(uint32(cons)),
+		RollbackTime: proto.Int64(rollbackTime),
+		PartitionIds: partnIds,
+	}
+
+	if vector != nil {
+		req.Vector = protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+	}
+	if sessionId != "" {
+		req.SessionId = proto.String(sessionId)
+	}
+
+	resp, err := c.doRequestResponse(req, requestId, retry)
+	if err != nil {
+		return 0, err
+	}
+	countResp := resp.(*protobuf.CountResponse)
+	if countResp.GetErr() != nil {
+		err = countResp.GetErr().Err()
+		return 0, err
+	}
+	return countResp.GetCount(), nil
+}
+
+// EstimateScans returns the estimated number of rows of scans and the
+// average entry size of the index, computed by the indexer from storage
+// metadata and key distribution sketches without scanning.
+func (c *GsiScanClient) EstimateScans(
+	defnID uint64, requestId string, scans Scans, isPrimary bool,
+	rollbackTime int64, partitions []common.PartitionId, retry bool) (int64, int64, error) {
+
+	var protoScans []*protobuf.Scan
+	if isPrimary {
+		if protoScans = marshalPrimaryCountScans(scans); len(protoScans) == 0 {
+			return 0, 0, nil
+		}
+	} else {
+		var err error
+		if protoScans, err = marshalCountScans(scans); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.CountRequest{
+		DefnID:    proto.Uint64(defnID),
+		RequestId: proto.String(requestId),
+		Span: &protobuf.Span{
+			Range: nil,
+		},
+		Scans:        protoScans,
+		Cons:         proto.Uint32(uint32(common.AnyConsistency)),
+		RollbackTime: proto.Int64(rollbackTime),
+		PartitionIds: partnIds,
+		Estimate:     proto.Bool(true),
+	}
+
+	resp, err := c.doRequestResponse(req, requestId, retry)
+	if err != nil {
+		return 0, 0, err
+	}
+	countResp := resp.(*protobuf.CountResponse)
+	if countResp.GetErr() != nil {
+		err = countResp.GetErr().Err()
+		return 0, 0, err
+	}
+	return countResp.GetCount(), countResp.GetAvgEntrySize(), nil
+}
+
+// marshalCountScans serializes the scans of a count request on a
+// secondary index.
+func marshalCountScans(scans Scans) ([]*protobuf.Scan, error) {
 	protoScans := make([]*protobuf.Scan, len(scans))
 	for i, scan := range scans {
 		if scan != nil {
@@ -784,7 +938,7 @@ func (c *GsiScanClient) MultiScanCount(
 				for i, seek := range scan.Seek {
 					s, err := json.Marshal(seek)
 					if err != nil {
-						return 0, err
+						return nil, err
 					}
 					equals[i] = s
 				}
@@ -797,13 +951,13 @@ func (c *GsiScanClient) MultiScanCount(
 						if f.Low != common.MinUnbounded { // Do not encode if unbounded
 							l, err = json.Marshal(f.Low)
 							if err != nil {
-								return 0, err
+								return nil, err
 							}
 						}
 						if f.High != common.MaxUnbounded { // Do not encode if unbounded
 							h, err = json.Marshal(f.High)
 							if err != nil {
-								return 0, err
+								return nil, err
 							}
 						}
 
@@ -822,48 +976,13 @@ func (c *GsiScanClient) MultiScanCount(
 			protoScans[i] = s
 		}
 	}
-
-	partnIds := make([]uint64, len(partitions))
-	for i, partnId := range partitions {
-		partnIds[i] = uint64(partnId)
-	}
-
-	req := &protobuf.CountRequest{
-		DefnID:    proto.Uint64(defnID),
-		RequestId: proto.String(requestId),
-		Span: &protobuf.Span{
-			Range: nil,
-		},
-		Distinct:     proto.Bool(distinct),
-		Scans:        protoScans,
-		Cons:         proto.Uint32(uint32(cons)),
-		RollbackTime: proto.Int64(rollbackTime),
-		PartitionIds: partnIds,
-	}
-
-	if vector != nil {
-		req.Vector = protobuf.NewTsConsistency(
-			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
-	}
-
-	resp, err := c.doRequestResponse(req, requestId, retry)
-	if err != nil {
-		return 0, err
-	}
-	countResp := resp.(*protobuf.CountResponse)
-	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
-		return 0, err
-	}
-	return countResp.GetCount(), nil
+	return protoScans, nil
 }
 
-func (c *GsiScanClient) MultiScanCountPrimary(
-	defnID uint64, requestId string, scans Scans, distinct bool,
-	cons common.Consistency, vector *TsConsistency, rollbackTime int64, partitions []common.PartitionId, retry bool) (int64, error) {
-
+// marshalPrimaryCountScans serializes the scans of a count request on a
+// primary index, skipping the scans outside the range of primary keys.
+func marshalPrimaryCountScans(scans Scans) []*protobuf.Scan {
 	var what string
-	// serialize scans
 	protoScans := make([]*protobuf.Scan, 0)
 	for _, scan := range scans {
 		if scan != nil {
@@ -916,44 +1035,7 @@ func (c *GsiScanClient) MultiScanCountPrimary(
 			protoScans = append(protoScans, s)
 		}
 	}
-
-	if len(protoScans) == 0 {
-		return 0, nil
-	}
-
-	partnIds := make([]uint64, len(partitions))
-	for i, partnId := range partitions {
-		partnIds[i] = uint64(partnId)
-	}
-
-	req := &protobuf.CountRequest{
-		DefnID:    proto.Uint64(defnID),
-		RequestId: proto.String(requestId),
-		Span: &protobuf.Span{
-			Range: nil,
-		},
-		Distinct:     proto.Bool(distinct),
-		Scans:        protoScans,
-		Cons:         proto.Uint32(uint32(cons)),
-		RollbackTime: proto.Int64(rollbackTime),
-		PartitionIds: partnIds,
-	}
-
-	if vector != nil {
-		req.Vector = protobuf.NewTsConsistency(
-			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
-	}
-
-	resp, err := c.doRequestResponse(req, requestId, retry)
-	if err != nil {
-		return 0, err
-	}
-	countResp := resp.(*protobuf.CountResponse)
-	if countResp.GetErr() != nil {
-		err = errors.New(countResp.GetErr().GetError())
-		return 0, err
-	}
-	return countResp.GetCount(), nil
+	return protoScans
 }
 
 func (c *GsiScanClient) Scan3(
@@ -962,7 +1044,8 @@ func (c *GsiScanClient) Scan3(
 	groupAggr *GroupAggr, sorted bool,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, replicaPref protobuf.ReplicaPreference,
+	sessionId string, retry bool) (error, bool) {
 
 	// serialize scans
 	protoScans := make([]*protobuf.Scan, len(scans))
@@ -1023,6 +1106,9 @@ func (c *GsiScanClient) Scan3(
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
 		}
+		if projection.DistinctOnKeys > 0 {
+			protoProjection.DistinctOnKeys = proto.Int64(projection.DistinctOnKeys)
+		}
 	}
 
 	// Groups and Aggregates
@@ -1093,6 +1179,12 @@ func (c *GsiScanClient) Scan3(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if replicaPref != protobuf.ReplicaPreference_ReplicaAny {
+		req.ReplicaPreference = replicaPref.Enum()
+	}
+	if sessionId != "" {
+		req.SessionId = proto.String(sessionId)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "Scan3", retry)
 }
@@ -1103,7 +1195,8 @@ func (c *GsiScanClient) Scan3Primary(
 	groupAggr *GroupAggr, sorted bool,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, replicaPref protobuf.ReplicaPreference,
+	sessionId string, retry bool) (error, bool) {
 
 	var what string
 	// serialize scans
@@ -1170,6 +1263,9 @@ func (c *GsiScanClient) Scan3Primary(
 			EntryKeys:  projection.EntryKeys,
 			PrimaryKey: proto.Bool(projection.PrimaryKey),
 		}
+		if projection.DistinctOnKeys > 0 {
+			protoProjection.DistinctOnKeys = proto.Int64(projection.DistinctOnKeys)
+		}
 	}
 
 	// Groups and Aggregates
@@ -1238,6 +1334,12 @@ func (c *GsiScanClient) Scan3Primary(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if replicaPref != protobuf.ReplicaPreference_ReplicaAny {
+		req.ReplicaPreference = replicaPref.Enum()
+	}
+	if sessionId != "" {
+		req.SessionId = proto.String(sessionId)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "Scan3Primary", retry)
 }
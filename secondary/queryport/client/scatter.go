@@ -17,6 +17,7 @@ import (
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/query/value"
 
 	//"runtime"
@@ -116,6 +117,8 @@ type RequestBroker struct {
 	indexOrder     *IndexKeyOrder // ordering of index key parts
 	projDesc       []bool         // which returned fields (in projection order) are indexed descending
 	distinct       bool
+	replicaSel     *ReplicaSelection // nil picks replicas at random
+	sessionId      string            // scan session pinning the snapshots to scan
 
 	// Additional key positions (not in projection list) added due to
 	// IndexKeyOrder for sorting purpose. These additions keys need to be
@@ -307,6 +310,61 @@ func (b *RequestBroker) SetIndexOrder(indexOrder *IndexKeyOrder) {
 	b.indexOrder = indexOrder
 }
 
+//
+// Set replica preference
+// Replicas of ReplicaSticky are picked by the hash of stickyKey, or of
+// the scans if stickyKey is empty.
+//
+func (b *RequestBroker) SetReplicaPreference(pref protobuf.ReplicaPreference, stickyKey string) {
+
+	if pref == protobuf.ReplicaPreference_ReplicaAny {
+		b.replicaSel = nil
+		return
+	}
+	if pref == protobuf.ReplicaPreference_ReplicaSticky && len(stickyKey) == 0 {
+		stickyKey = fmt.Sprint(b.scans)
+	}
+	b.replicaSel = NewReplicaSelection(pref, stickyKey)
+}
+
+//
+// Get replica selection
+//
+func (b *RequestBroker) GetReplicaSelection() *ReplicaSelection {
+
+	return b.replicaSel
+}
+
+//
+// Get replica preference
+//
+func (b *RequestBroker) GetReplicaPreference() protobuf.ReplicaPreference {
+
+	if b.replicaSel == nil {
+		return protobuf.ReplicaPreference_ReplicaAny
+	}
+	return b.replicaSel.Preference
+}
+
+//
+// Set scan session
+// Scans read the snapshots pinned by the scan session sessionId, opened on
+// the indexers with /scanSession, instead of snapshots satisfying their
+// consistency.
+//
+func (b *RequestBroker) SetSessionId(sessionId string) {
+
+	b.sessionId = sessionId
+}
+
+//
+// Get scan session
+//
+func (b *RequestBroker) GetSessionId() string {
+
+	return b.sessionId
+}
+
 //
 // Retry
 //
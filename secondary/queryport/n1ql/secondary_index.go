@@ -29,6 +29,7 @@ import (
 	c "github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/security"
 
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	qclient "github.com/couchbase/indexing/secondary/queryport/client"
 
 	mclient "github.com/couchbase/indexing/secondary/manager/client"
@@ -1421,6 +1422,7 @@ func (si *secondaryIndex3) Alter(requestId string, with value.Value) (
 	var ErrorUmmarshalWith = "GSI AlterIndex() Error unmarshalling WITH clause"
 	var ErrorActionMissing = "GSI AlterIndex() action key missing in WITH clause"
 	var ErrorUnsupportedAction = "GSI AlterIndex() Unsupported action value"
+	var ErrorNumPartition = "GSI AlterIndex() num_partition of an index cannot be changed, drop and recreate the index instead"
 
 	var withMap map[string]interface{}
 	var withJSON []byte
@@ -1432,6 +1434,10 @@ func (si *secondaryIndex3) Alter(requestId string, with value.Value) (
 		return nil, errors.NewError(err, ErrorUmmarshalWith)
 	}
 
+	if _, ok := withMap["num_partition"]; ok {
+		return nil, errors.NewError(fmt.Errorf(ErrorNumPartition), "")
+	}
+
 	action, ok := withMap["action"]
 	if !ok {
 		return nil, errors.NewError(fmt.Errorf(ErrorActionMissing), "")
@@ -1845,6 +1851,12 @@ func makeResponsehandler(
 }
 
 func isStaleMetaError(err error) bool {
+	switch protobuf.GetErrorCode(err) {
+	case protobuf.ErrorCode_IndexNotFound, protobuf.ErrorCode_IndexNotReady:
+		return true
+	}
+
+	// errors not sent back by the indexer
 	switch err.Error() {
 	case qclient.ErrIndexNotFound.Error():
 		fallthrough
@@ -1856,6 +1868,14 @@ func isStaleMetaError(err error) bool {
 }
 
 func n1qlError(client *qclient.GsiClient, err error) errors.Error {
+	switch protobuf.GetErrorCode(err) {
+	case protobuf.ErrorCode_Timeout:
+		return errors.NewCbIndexScanTimeoutError(err)
+	case protobuf.ErrorCode_IndexNotFound:
+		return errors.NewCbIndexNotFoundError(err)
+	}
+
+	// errors not sent back by the indexer
 	switch strings.TrimSpace(err.Error()) {
 	case c.ErrScanTimedOut.Error():
 		return errors.NewCbIndexScanTimeoutError(err)
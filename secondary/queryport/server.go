@@ -30,6 +30,19 @@ type RequestHandler func(
 
 type ConnectionHandler func() interface{}
 
+// CredsHolder is implemented by connection contexts, returned by the
+// ConnectionHandler, which authorize requests with the credentials the
+// connection authenticated with.
+type CredsHolder interface {
+	SetCreds(creds cbauth.Creds)
+}
+
+// ContextCloser is implemented by connection contexts, returned by the
+// ConnectionHandler, which are notified when their connection is closed.
+type ContextCloser interface {
+	Close()
+}
+
 type request struct {
 	r      interface{}
 	quitch chan bool
@@ -49,22 +62,48 @@ type Server struct {
 	callb RequestHandler // callback to application on incoming request.
 	conb  ConnectionHandler
 	// local fields
-	mu  sync.Mutex
-	lis net.Listener
+	mu    sync.Mutex
+	lis   net.Listener
+	authr Authenticator // protected by mu
 	// config params
 	maxPayload        int
 	readDeadline      time.Duration
 	writeDeadline     time.Duration
 	keepAliveInterval time.Duration
 	streamChanSize    int
+	multiplex         bool
+	maxMuxWindow      int
 	logPrefix         string
 	nConnections      int64
+	nStreams          int64
+	nEvictedIdle      int64
+	nEvictedAged      int64
+	nRejected         int64
+
+	// protected by mu
+	conns     map[string]*serverConn
+	perClient map[string]int // connections by client host
+	limits    connLimits
+}
 
-	conns map[string]net.Conn
+// serverConn tracks whether a connection is serving requests, so that
+// it can be drained without interrupting them. A multiplexed connection
+// serves a request per stream.
+type serverConn struct {
+	conn       net.Conn
+	host       string
+	busy       int  // requests in progress
+	stale      bool // close once the current requests are done
+	created    time.Time
+	lastActive time.Time // end of the last request
 }
 
 type ServerStats struct {
 	Connections int64
+	Streams     int64 // streams of multiplexed connections
+	EvictedIdle int64 // connections closed for being idle
+	EvictedAged int64 // connections closed for their age
+	Rejected    int64 // connections past the limit of their client
 }
 
 // NewServer creates a new queryport daemon.
@@ -80,25 +119,51 @@ func NewServer(
 		readDeadline:   time.Duration(config["readDeadline"].Int()),
 		writeDeadline:  time.Duration(config["writeDeadline"].Int()),
 		streamChanSize: config["streamChanSize"].Int(),
+		multiplex:      config["multiplex"].Bool(),
+		maxMuxWindow:   config["maxMuxWindow"].Int(),
 		logPrefix:      fmt.Sprintf("[Queryport %q]", laddr),
 		nConnections:   0,
-		conns:          make(map[string]net.Conn),
+		conns:          make(map[string]*serverConn),
+		perClient:      make(map[string]int),
+		limits:         newConnLimits(config),
 	}
 	keepAliveInterval := config["keepAliveInterval"].Int()
 	s.keepAliveInterval = time.Duration(keepAliveInterval) * time.Second
-	if s.lis, err = security.MakeListener(laddr); err != nil {
+	if s.authr, err = NewAuthenticator(config); err != nil {
+		logging.Errorf("%v invalid auth config %v, authenticating with cbauth only\n", s.logPrefix, err)
+		s.authr = cbauthAuthenticator{}
+	}
+	if s.lis, err = security.MakeReloadableListener(laddr); err != nil {
 		logging.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
 		return nil, err
 	}
 
 	go s.listener()
+	go s.reapConnections()
 	logging.Infof("%v started ...\n", s.logPrefix)
 	return s, nil
 }
 
+// SetAuthenticator sets the Authenticator of new connections.
+func (s *Server) SetAuthenticator(authr Authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authr = authr
+}
+
+func (s *Server) authenticator() Authenticator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authr
+}
+
 func (s *Server) Statistics() ServerStats {
 	return ServerStats{
 		Connections: atomic.LoadInt64(&s.nConnections),
+		Streams:     atomic.LoadInt64(&s.nStreams),
+		EvictedIdle: atomic.LoadInt64(&s.nEvictedIdle),
+		EvictedAged: atomic.LoadInt64(&s.nEvictedAged),
+		Rejected:    atomic.LoadInt64(&s.nRejected),
 	}
 }
 
@@ -136,23 +201,50 @@ func (s *Server) ResetConnections() error {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
-		for _, conn := range s.conns {
-			s.deregisterConnNoLock(conn)
-			conn.Close()
+		for _, sc := range s.conns {
+			s.deregisterConnNoLock(sc.conn)
+			sc.conn.Close()
 		}
 	}()
 
-	// Existing connection will continue to run.  They need to
-	// be closed by the clients by flushing their connection pools.
+	return s.restartListener()
+}
+
+// RefreshSecurity applies a security change without restarting the
+// server, which is required to pick up a refreshed certificate.
+// The listener already uses the latest certificate for new connections,
+// and is restarted only if encryption is turned on or off. Existing
+// connections, secured with the old certificate or encryption setting,
+// are drained: idle connections are closed right away, and busy ones
+// once their current request is done. Clients then reconnect, getting
+// a new ConnectionContext on the new connection.
+func (s *Server) RefreshSecurity(refreshCert, refreshEncrypt bool) error {
+
+	if refreshEncrypt {
+		if err := s.restartListener(); err != nil {
+			return err
+		}
+	}
+
+	if refreshCert || refreshEncrypt {
+		s.drainConnections()
+	}
+
+	return nil
+}
+
+func (s *Server) restartListener() error {
+
+	s.Close()
+
 	logging.Infof("%v ... restarting listener\n", s.logPrefix)
 
 	fn := func(r int, e error) error {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
-		// Restart listener.
 		var err error
-		if s.lis, err = security.MakeListener(s.laddr); err != nil {
+		if s.lis, err = security.MakeReloadableListener(s.laddr); err != nil {
 			logging.Errorf("%v failed starting listener %v %v !!\n", s.logPrefix, s.laddr, err)
 			return err
 		}
@@ -161,17 +253,72 @@ func (s *Server) ResetConnections() error {
 		return nil
 	}
 	helper := c.NewRetryHelper(10, time.Second, 1, fn)
-	if err := helper.Run(); err != nil {
-		return err
+	return helper.Run()
+}
+
+func (s *Server) drainConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var closed, draining int
+	for key, sc := range s.conns {
+		if sc.busy > 0 {
+			sc.stale = true
+			draining++
+		} else {
+			s.deleteConnNoLock(key, sc)
+			sc.conn.Close()
+			closed++
+		}
 	}
 
-	return nil
+	logging.Infof("%v closed %v idle connections, draining %v busy connections\n",
+		s.logPrefix, closed, draining)
 }
 
-func (s *Server) registerConn(conn net.Conn) {
+// registerConn registers conn. It returns false if the client of conn
+// has maxConnsPerClient connections already.
+func (s *Server) registerConn(conn net.Conn) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.conns[conn.RemoteAddr().String()] = conn
+
+	host := clientHost(conn)
+	if max := s.limits.maxConnsPerClient; max > 0 && s.perClient[host] >= max {
+		return false
+	}
+
+	now := time.Now()
+	s.conns[conn.RemoteAddr().String()] = &serverConn{
+		conn:       conn,
+		host:       host,
+		created:    now,
+		lastActive: now,
+	}
+	s.perClient[host]++
+	return true
+}
+
+// setBusy marks conn as starting or done serving a request. It returns
+// false once its requests are done if conn is to be closed because it was
+// drained. Requests which are not active, like pings of the server, do
+// not keep conn from being idle.
+func (s *Server) setBusy(conn net.Conn, busy, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, ok := s.conns[conn.RemoteAddr().String()]
+	if !ok {
+		return busy
+	}
+	if busy {
+		sc.busy++
+	} else {
+		sc.busy--
+		if active {
+			sc.lastActive = time.Now()
+		}
+	}
+	return busy || sc.busy > 0 || !sc.stale
 }
 
 func (s *Server) deregisterConn(conn net.Conn) bool {
@@ -181,13 +328,21 @@ func (s *Server) deregisterConn(conn net.Conn) bool {
 }
 
 func (s *Server) deregisterConnNoLock(conn net.Conn) bool {
-	_, ok := s.conns[conn.RemoteAddr().String()]
+	key := conn.RemoteAddr().String()
+	sc, ok := s.conns[key]
 	if ok {
-		delete(s.conns, conn.RemoteAddr().String())
+		s.deleteConnNoLock(key, sc)
 	}
 	return ok
 }
 
+func (s *Server) deleteConnNoLock(key string, sc *serverConn) {
+	delete(s.conns, key)
+	if s.perClient[sc.host]--; s.perClient[sc.host] <= 0 {
+		delete(s.perClient, sc.host)
+	}
+}
+
 // go-routine to listen for new connections, if this routine goes down -
 // listener is restarted
 func (s *Server) listener() {
@@ -205,7 +360,7 @@ func (s *Server) listener() {
 
 		if s.lis != nil && s.lis == lis { // if s.lis == nil, then Server.Close() was called
 			s.lis.Close()
-			if s.lis, err = security.MakeListener(s.laddr); err != nil {
+			if s.lis, err = security.MakeReloadableListener(s.laddr); err != nil {
 				logging.Errorf("%v failed starting %v !!\n", s.logPrefix, err)
 				panic(err)
 			}
@@ -237,7 +392,11 @@ func (s *Server) listener() {
 	}
 }
 
-func (s *Server) doAuth(conn net.Conn) (interface{}, error) {
+// doAuth authenticates conn. It returns the credentials of the connection,
+// or the first request if the client did not authenticate and the cluster
+// is not yet fully upgraded, and the window per stream if the client
+// multiplexes the connection.
+func (s *Server) doAuth(conn net.Conn) (interface{}, cbauth.Creds, int, error) {
 
 	// TODO: Some code deduplication with doReveive can be done.
 	raddr := conn.RemoteAddr()
@@ -257,7 +416,7 @@ func (s *Server) doAuth(conn net.Conn) (interface{}, error) {
 
 	reqMsg, err := rpkt.Receive(conn)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 
 	// Reset read deadline
@@ -267,6 +426,8 @@ func (s *Server) doAuth(conn net.Conn) (interface{}, error) {
 
 	var authErr error
 	var code uint32
+	var creds cbauth.Creds
+	var muxWindow int
 
 	req, ok := reqMsg.(*protobuf.AuthRequest)
 	if !ok {
@@ -283,7 +444,7 @@ func (s *Server) doAuth(conn net.Conn) (interface{}, error) {
 
 		if c.GetClusterVersion() < c.INDEXER_71_VERSION {
 			logging.Infof("%v connection %q continue without auth", s.logPrefix, raddr)
-			return reqMsg, nil
+			return reqMsg, nil, 0, nil
 		}
 
 		code = transport.AUTH_MISSING
@@ -291,39 +452,56 @@ func (s *Server) doAuth(conn net.Conn) (interface{}, error) {
 	} else {
 		// The upgraded server always responds to the AuthRequest.
 
-		_, err = cbauth.Auth(req.GetUser(), req.GetPass())
+		creds, err = s.authenticator().Authenticate(conn, req)
 		if err != nil {
 			logging.Errorf("%v connection %q doAuth() error %v", s.logPrefix, raddr, err)
 			code = transport.AUTH_FAILURE
 			authErr = errors.New("Unauthenticated access. Authentication failure.")
+		} else {
+			// Authorization is per request, against the index scanned
+			code = transport.AUTH_SUCCESS
+			muxWindow = s.muxWindow(int(req.GetMuxWindow()))
 		}
-
-		// TODO: RBAC
-
-		code = transport.AUTH_SUCCESS
 	}
 
 	resp := &protobuf.AuthResponse{
 		Code: &code,
 	}
+	if muxWindow > 0 {
+		w := uint32(muxWindow)
+		resp.MuxWindow = &w
+	}
 
 	err = rpkt.Send(conn, resp)
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
 	}
 
-	if authErr == nil {
-		logging.Verbosef("%v connection %q auth successful", s.logPrefix, raddr)
+	if authErr != nil {
+		return nil, nil, 0, authErr
 	}
 
-	return nil, authErr
+	logging.Verbosef("%v connection %q auth successful", s.logPrefix, raddr)
+	return nil, creds, muxWindow, nil
+}
+
+// muxWindow returns the window per stream of a connection the client
+// multiplexes with window requested, or 0 to not multiplex it.
+func (s *Server) muxWindow(requested int) int {
+	if !s.multiplex || requested <= 0 {
+		return 0
+	}
+	if requested > s.maxMuxWindow {
+		return s.maxMuxWindow
+	}
+	return requested
 }
 
 // handle connection request. connection might be kept open in client's
 // connection pool.
 func (s *Server) handleConnection(conn net.Conn) {
 
-	err, req := s.doAuth(conn)
+	req, creds, muxWindow, err := s.doAuth(conn)
 	if err != nil {
 		// On authentication error, just close the connection. Client
 		// will try with a new connection by sending AuthRequest.
@@ -332,7 +510,13 @@ func (s *Server) handleConnection(conn net.Conn) {
 		return
 	}
 
-	s.registerConn(conn)
+	if !s.registerConn(conn) {
+		logging.Warnf("%v connection %v rejected, too many connections from the client",
+			s.logPrefix, conn.RemoteAddr())
+		atomic.AddInt64(&s.nRejected, 1)
+		conn.Close()
+		return
+	}
 
 	atomic.AddInt64(&s.nConnections, 1)
 	defer func() {
@@ -353,6 +537,64 @@ func (s *Server) handleConnection(conn net.Conn) {
 		tcpconn.SetKeepAlivePeriod(s.keepAliveInterval)
 	}
 
+	if muxWindow > 0 {
+		s.serveStreams(conn, creds, muxWindow)
+		return
+	}
+	s.serveRequests(conn, req, s.newContext(creds))
+}
+
+// newContext returns the context of a connection or stream authenticated
+// with creds.
+func (s *Server) newContext(creds cbauth.Creds) interface{} {
+	var ctx interface{}
+	if s.conb != nil {
+		ctx = s.conb()
+		if holder, ok := ctx.(CredsHolder); ok && creds != nil {
+			holder.SetCreds(creds)
+		}
+	}
+	return ctx
+}
+
+// serveStreams serves the requests of each stream of a multiplexed
+// connection, concurrently, until the connection is closed.
+func (s *Server) serveStreams(conn net.Conn, creds cbauth.Creds, muxWindow int) {
+
+	logging.Infof("%v connection %q multiplexed, window %v\n", s.logPrefix, conn.RemoteAddr(), muxWindow)
+
+	session := transport.NewMuxSession(conn, false, muxWindow)
+	defer session.Close()
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+
+		atomic.AddInt64(&s.nStreams, 1)
+		go func() {
+			defer atomic.AddInt64(&s.nStreams, -1)
+			defer stream.Close()
+
+			// A drained connection is closed with all its streams
+			if !s.serveRequests(stream, nil, s.newContext(creds)) {
+				session.Close()
+			}
+		}()
+	}
+}
+
+// serveRequests serves the requests received on conn, one at a time, until
+// conn is closed. It returns false if conn is to be closed because it was
+// drained.
+func (s *Server) serveRequests(conn net.Conn, req interface{}, ctx interface{}) bool {
+
+	raddr := conn.RemoteAddr()
+	if closer, ok := ctx.(ContextCloser); ok {
+		defer closer.Close()
+	}
+
 	// start a receive routine.
 	killch := make(chan bool)
 	rcvch := make(chan request, s.streamChanSize)
@@ -360,17 +602,24 @@ func (s *Server) handleConnection(conn net.Conn) {
 	go s.doReceive(conn, rcvch, killch, req)
 	go s.doPing(rcvch, killch)
 
-	var ctx interface{}
-	if s.conb != nil {
-		ctx = s.conb()
-	}
-
 	for req := range rcvch {
+		active := req.r != Ping
+		s.setBusy(conn, true, active)
 		s.callb(req.r, ctx, conn, req.quitch) // blocking call
-		if req.r != Ping {
+		if active {
 			transport.SendResponseEnd(conn)
 		}
+		if !s.setBusy(conn, false, active) {
+			logging.Infof("%v connection %v drained\n", s.logPrefix, raddr)
+			// Unblock doReceive and doPing until they see the connection closed
+			go func() {
+				for range rcvch {
+				}
+			}()
+			return false
+		}
 	}
+	return true
 }
 
 // receive requests from remote, when this function returns
@@ -0,0 +1,112 @@
+package queryport
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+// Connections that clients leak are closed by the server:
+//
+//   idleTimeout       closes connections which served no request for
+//                     that long. Pings of the server are not requests.
+//   maxConnAge        closes connections older than that, draining busy
+//                     ones like on a security change.
+//   maxConnsPerClient rejects the connections of a client, by IP, past
+//                     that many.
+//
+// All of them are disabled with 0.
+
+const connReapInterval = 10 * time.Second
+
+// connLimits are the limits on the connections of a Server.
+type connLimits struct {
+	idleTimeout       time.Duration
+	maxConnAge        time.Duration
+	maxConnsPerClient int
+}
+
+func newConnLimits(config c.Config) connLimits {
+	return connLimits{
+		idleTimeout:       time.Duration(config["idleTimeout"].Int()) * time.Second,
+		maxConnAge:        time.Duration(config["maxConnAge"].Int()) * time.Second,
+		maxConnsPerClient: config["maxConnsPerClient"].Int(),
+	}
+}
+
+// SetConnLimits applies the connection limits of config.
+func (s *Server) SetConnLimits(config c.Config) {
+	limits := newConnLimits(config)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limits != s.limits {
+		logging.Infof("%v connection limits %+v\n", s.logPrefix, limits)
+		s.limits = limits
+	}
+}
+
+func clientHost(conn net.Conn) string {
+	raddr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(raddr); err == nil {
+		return host
+	}
+	return raddr
+}
+
+// reapConnections closes, every connReapInterval, the connections past
+// their limits.
+func (s *Server) reapConnections() {
+	ticker := time.NewTicker(connReapInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.evictConnections(now)
+	}
+}
+
+// evictConnections closes the connections idle for more than idleTimeout
+// and those older than maxConnAge, once their requests are done.
+func (s *Server) evictConnections(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limits := s.limits
+	if limits.idleTimeout <= 0 && limits.maxConnAge <= 0 {
+		return
+	}
+
+	var idle, aged, draining int64
+	for key, sc := range s.conns {
+		if sc.stale {
+			continue
+		}
+
+		if limits.maxConnAge > 0 && now.Sub(sc.created) > limits.maxConnAge {
+			if sc.busy > 0 {
+				sc.stale = true
+				draining++
+			} else {
+				s.deleteConnNoLock(key, sc)
+				sc.conn.Close()
+			}
+			aged++
+
+		} else if limits.idleTimeout > 0 && sc.busy == 0 && now.Sub(sc.lastActive) > limits.idleTimeout {
+			s.deleteConnNoLock(key, sc)
+			sc.conn.Close()
+			idle++
+		}
+	}
+
+	if idle > 0 || aged > 0 {
+		atomic.AddInt64(&s.nEvictedIdle, idle)
+		atomic.AddInt64(&s.nEvictedAged, aged)
+		logging.Infof("%v closed %v idle connections and %v connections past their max age, "+
+			"draining %v busy ones\n", s.logPrefix, idle, aged-draining, draining)
+	}
+}
@@ -0,0 +1,86 @@
+package queryport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type testConn struct {
+	net.Conn
+	raddr  *net.TCPAddr
+	closed bool
+}
+
+func (c *testConn) RemoteAddr() net.Addr { return c.raddr }
+func (c *testConn) Close() error         { c.closed = true; return nil }
+
+func newTestConn(ip string, port int) *testConn {
+	return &testConn{raddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+}
+
+func newTestServer(limits connLimits) *Server {
+	return &Server{
+		conns:     make(map[string]*serverConn),
+		perClient: make(map[string]int),
+		limits:    limits,
+	}
+}
+
+func TestMaxConnsPerClient(t *testing.T) {
+	s := newTestServer(connLimits{maxConnsPerClient: 2})
+
+	c1, c2, c3 := newTestConn("10.0.0.1", 1), newTestConn("10.0.0.1", 2), newTestConn("10.0.0.1", 3)
+	if !s.registerConn(c1) || !s.registerConn(c2) {
+		t.Fatalf("expected the first connections of the client registered")
+	}
+	if s.registerConn(c3) {
+		t.Fatalf("expected connections past the limit rejected")
+	}
+	if !s.registerConn(newTestConn("10.0.0.2", 1)) {
+		t.Fatalf("expected connections of other clients registered")
+	}
+
+	s.deregisterConn(c1)
+	if !s.registerConn(c3) {
+		t.Fatalf("expected a connection registered once another one is closed")
+	}
+}
+
+func TestEvictConnections(t *testing.T) {
+	s := newTestServer(connLimits{idleTimeout: time.Minute, maxConnAge: time.Hour})
+
+	idle, pinged, busy, old := newTestConn("10.0.0.1", 1), newTestConn("10.0.0.1", 2),
+		newTestConn("10.0.0.1", 3), newTestConn("10.0.0.1", 4)
+	for _, conn := range []net.Conn{idle, pinged, busy, old} {
+		s.registerConn(conn)
+	}
+
+	now := time.Now()
+	for _, sc := range s.conns {
+		sc.lastActive = now.Add(-2 * time.Minute)
+	}
+	s.conns[old.RemoteAddr().String()].created = now.Add(-2 * time.Hour)
+	s.setBusy(pinged, true, false)
+	s.setBusy(pinged, false, false)
+	s.setBusy(busy, true, true)
+	s.setBusy(old, true, true)
+
+	s.evictConnections(now.Add(time.Second))
+	if !idle.closed || !pinged.closed || busy.closed || old.closed {
+		t.Fatalf("expected the idle connections closed only")
+	}
+	if st := s.Statistics(); st.EvictedIdle != 2 || st.EvictedAged != 1 {
+		t.Fatalf("expected 2 idle and 1 aged connections evicted, got %+v", st)
+	}
+
+	if s.setBusy(old, false, true) {
+		t.Fatalf("expected the old connection drained once its request is done")
+	}
+	if !s.setBusy(busy, false, true) {
+		t.Fatalf("expected the busy connection kept open")
+	}
+	if len(s.perClient) != 1 || s.perClient["10.0.0.1"] != 2 {
+		t.Fatalf("expected 2 connections of the client, got %v", s.perClient)
+	}
+}
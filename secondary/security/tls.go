@@ -307,6 +307,33 @@ func MakeTLSListener(tcpListener net.Listener) (net.Listener, error) {
 	return tcpListener, nil
 }
 
+//
+// Set up a TLS listener which looks up the certificate and TLS preferences
+// in the current security setting on every handshake, so that refreshed
+// certificates are used without restarting the listener.
+//
+func MakeReloadableTLSListener(tcpListener net.Listener) (net.Listener, error) {
+
+	// Fail early if the current setting cannot be used
+	if _, err := setupServerTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			setting := GetSecuritySetting()
+			if setting == nil {
+				return nil, fmt.Errorf("Security setting is nil")
+			}
+			return getTLSConfigFromSetting(setting)
+		},
+	}
+
+	listener := tls.NewListener(tcpListener, config)
+	logging.Infof("Reloadable TLS listener created for %v", listener.Addr().String())
+	return listener, nil
+}
+
 //
 // Make a new tcp listener for given address.
 // Always make it secure, even if the security is not enabled.
@@ -409,6 +436,36 @@ func MakeListener(addr string) (net.Listener, error) {
 	return listener2, nil
 }
 
+//
+// Same as MakeListener, but the TLS listener picks up certificate refresh
+// without being restarted. It must still be restarted when encryption is
+// enabled or disabled.
+//
+func MakeReloadableListener(addr string) (net.Listener, error) {
+
+	addr, _, _, err := EncryptPortFromAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := MakeProtocolAwareTCPListener(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !EncryptionEnabled() {
+		return listener, nil
+	}
+
+	listener2, err := MakeReloadableTLSListener(listener)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener2, nil
+}
+
 /////////////////////////////////////////////
 // HTTP / HTTPS Client
 /////////////////////////////////////////////
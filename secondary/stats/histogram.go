@@ -69,6 +69,16 @@ func (h *Histogram) findBucket(val int64) int {
 	return 0
 }
 
+// Counts returns the number of values added to each bucket, along with the
+// upper bound of each bucket.
+func (h *Histogram) Counts() (counts []int64, bounds []int64) {
+	counts = make([]int64, len(h.vals))
+	for i := range h.vals {
+		counts[i] = atomic.LoadInt64(&h.vals[i])
+	}
+	return counts, h.buckets[1:]
+}
+
 func (h *Histogram) String() string {
 	s := "\""
 	l := len(h.vals)
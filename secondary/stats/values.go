@@ -17,6 +17,11 @@ const (
 	N1QLStorageStatsFilter = 0x20 // only used for storage stats
 	SummaryFilter          = 0x40
 	SmartBatchingFilter    = 0x80
+
+	// Named sets of storage stats, only used for storage stats
+	StorageUIFilter         = 0x100
+	StorageAutotuneFilter   = 0x200
+	StorageCompactionFilter = 0x400
 )
 
 // END FILTERS =====================================================================================
@@ -1,21 +1,22 @@
 package clusterutility
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
-
-	couchbase "github.com/couchbase/indexing/secondary/dcp"
 )
 
-var ErrRebalanceTimedout = errors.New("Rebalance did not finish after 30 minutes")
+var ErrRebalanceTimedout = errors.New("Rebalance did not finish within the timeout")
 var ErrRebalanceFailed = errors.New("Rebalance failed")
 
 func getInitServicesUrl(serverAddr string) string {
@@ -54,20 +55,41 @@ func getFailoverUrl(serverAddr string) string {
 	return prependHttp(serverAddr) + "/controller/failOver"
 }
 
+func getGracefulFailoverUrl(serverAddr string) string {
+	return prependHttp(serverAddr) + "/controller/startGracefulFailover"
+}
+
 func failoverFromRest(serverAddr, username, password string, nodesToRemove []string) ([]byte, error) {
 	log.Printf("Failing over: %v\n", nodesToRemove)
 
-	_, removeNodes := otpNodes(serverAddr, username, password, nodesToRemove)
+	_, removeNodes, err := otpNodes(serverAddr, username, password, nodesToRemove)
+	if err != nil {
+		return nil, err
+	}
+	payload := strings.NewReader(fmt.Sprintf("otpNode=%s", url.QueryEscape(removeNodes)))
+	return makeCheckedRequest(username, password, "POST", payload, getFailoverUrl(serverAddr))
+}
+
+func gracefulFailoverFromRest(serverAddr, username, password string, nodesToRemove []string) ([]byte, error) {
+	log.Printf("Gracefully failing over: %v\n", nodesToRemove)
+
+	_, removeNodes, err := otpNodes(serverAddr, username, password, nodesToRemove)
+	if err != nil {
+		return nil, err
+	}
 	payload := strings.NewReader(fmt.Sprintf("otpNode=%s", url.QueryEscape(removeNodes)))
-	return makeRequest(username, password, "POST", payload, getFailoverUrl(serverAddr))
+	return makeCheckedRequest(username, password, "POST", payload, getGracefulFailoverUrl(serverAddr))
 }
 
 func recoveryFromRest(serverAddr, username, password, hostname, recoveryType string) ([]byte, error) {
 	log.Printf("Kicking off failover recovery, type: %s\n", recoveryType)
 
-	_, recoveryNodes := otpNodes(serverAddr, username, password, []string{hostname})
+	_, recoveryNodes, err := otpNodes(serverAddr, username, password, []string{hostname})
+	if err != nil {
+		return nil, err
+	}
 	payload := strings.NewReader(fmt.Sprintf("otpNode=%s&recoveryType=%s", url.QueryEscape(recoveryNodes), recoveryType))
-	return makeRequest(username, password, "POST", payload, getRecoveryUrl(serverAddr))
+	return makeCheckedRequest(username, password, "POST", payload, getRecoveryUrl(serverAddr))
 }
 
 func initServicesFromRest(serverAddr, username, password, roles string) ([]byte, error) {
@@ -84,10 +106,10 @@ func initWebCredsFromRest(serverAddr, username, password string) ([]byte, error)
 	return makeRequest("", "", "POST", payload, getWebCredsUrl(serverAddr))
 }
 
-func setQuotaUsingRest(serverAddr, username, password string) ([]byte, error) {
-	log.Printf("Setting data quota of 1500M and Index quota of 1500M\n")
+func setQuotaUsingRest(serverAddr, username, password string, quotas url.Values) ([]byte, error) {
+	log.Printf("Setting memory quotas (MB): %v\n", quotas.Encode())
 
-	payload := strings.NewReader(fmt.Sprintf("memoryQuota=1500&indexMemoryQuota=1500"))
+	payload := strings.NewReader(quotas.Encode())
 	return makeRequest(username, password, "POST", payload, getQuotaSetUrl(serverAddr))
 }
 
@@ -98,7 +120,7 @@ func addNodeFromRest(serverAddr, username, password, hostname, roles string) ([]
 
 	payload := strings.NewReader(fmt.Sprintf("hostname=%s&user=%s&password=%s&services=%s",
 		url.QueryEscape(hostname), username, password, url.QueryEscape(roles)))
-	return makeRequest(username, password, "POST", payload, getAddNodeUrl(serverAddr))
+	return makeCheckedRequest(username, password, "POST", payload, getAddNodeUrl(serverAddr))
 }
 
 func rebalanceFromRest(serverAddr, username, password string, nodesToRemove []string) ([]byte, error) {
@@ -106,38 +128,41 @@ func rebalanceFromRest(serverAddr, username, password string, nodesToRemove []st
 		log.Printf("Removing node(s): %v from the cluster\n", nodesToRemove)
 	}
 
-	knownNodes, removeNodes := otpNodes(serverAddr, username, password, nodesToRemove)
+	knownNodes, removeNodes, err := otpNodes(serverAddr, username, password, nodesToRemove)
+	if err != nil {
+		return nil, err
+	}
 	payload := strings.NewReader(fmt.Sprintf("knownNodes=%s&ejectedNodes=%s",
 		url.QueryEscape(knownNodes), url.QueryEscape(removeNodes)))
-	return makeRequest(username, password, "POST", payload, getRebalanceUrl(serverAddr))
+	return makeCheckedRequest(username, password, "POST", payload, getRebalanceUrl(serverAddr))
 }
 
-func otpNodes(serverAddr, username, password string, removeNodes []string) (string, string) {
-	defer func() {
-		recover()
-	}()
-
-	r, err := makeRequest(username, password, "GET", strings.NewReader(""), getPoolsUrl(serverAddr))
-
-	var res map[string]interface{}
-	err = json.Unmarshal(r, &res)
+func otpNodes(serverAddr, username, password string, removeNodes []string) (string, string, error) {
+	nodes, err := getPoolNodes(serverAddr, username, password)
 	if err != nil {
-		fmt.Println("otp node fetch error", err)
+		return "", "", err
 	}
 
-	nodes := res["nodes"].([]interface{})
 	var ejectNodes, knownNodes string
-
 	for i, n := range nodes {
-		node := n.(map[string]interface{})
-		knownNodes += node["otpNode"].(string)
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			return "", "", fmt.Errorf("otpNodes: unexpected node %v in pool", n)
+		}
+		otpNode, ok := node["otpNode"].(string)
+		if !ok {
+			return "", "", fmt.Errorf("otpNodes: missing otpNode in %v", node)
+		}
+		hostname, _ := node["hostname"].(string)
+
+		knownNodes += otpNode
 		if i < len(nodes)-1 {
 			knownNodes += ","
 		}
 
 		for j, en := range removeNodes {
-			if en == node["hostname"].(string) {
-				ejectNodes += node["otpNode"].(string)
+			if en == hostname {
+				ejectNodes += otpNode
 				if j < len(removeNodes)-1 {
 					ejectNodes += ","
 				}
@@ -145,18 +170,88 @@ func otpNodes(serverAddr, username, password string, removeNodes []string) (stri
 		}
 	}
 
-	return knownNodes, ejectNodes
+	return knownNodes, ejectNodes, nil
 }
 
-func waitForRebalanceFinish(serverAddr, username, password string) error {
-	timer := time.NewTicker(5 * time.Second)
-	timeout := time.After(30 * time.Minute)
+// getPoolNodes returns the raw nodes of the default pool, retrying while
+// the servicing node is not ready.
+func getPoolNodes(serverAddr, username, password string) ([]interface{}, error) {
+	var nodes []interface{}
+	err := withRetry("getPoolNodes", DefaultRetryOptions, func() error {
+		r, err := makeCheckedRequest(username, password, "GET", strings.NewReader(""), getPoolsUrl(serverAddr))
+		if err != nil {
+			return err
+		}
+
+		var res map[string]interface{}
+		if err := json.Unmarshal(r, &res); err != nil {
+			return fmt.Errorf("Error parsing pool %s, err: %w", r, err)
+		}
+		var ok bool
+		if nodes, ok = res["nodes"].([]interface{}); !ok {
+			return fmt.Errorf("Unexpected pool, missing nodes: %s", r)
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+// RebalanceOptions tunes how the completion of a rebalance is awaited.
+type RebalanceOptions struct {
+	// Interval between polls of the tasks API. Defaults to 5 seconds.
+	PollInterval time.Duration
+	// Time after which the wait fails with ErrRebalanceTimedout, in case
+	// rebalance is stuck. Defaults to 30 minutes. Negative disables it.
+	Timeout time.Duration
+	// If set, called with every sample of rebalance progress, so that
+	// tests can assert on progress, e.g. to detect a stuck rebalance.
+	// The last sample reports a status of "notRunning".
+	Progress func(RebalanceProgress)
+}
+
+func (opts *RebalanceOptions) pollInterval() time.Duration {
+	if opts == nil || opts.PollInterval <= 0 {
+		return 5 * time.Second
+	}
+	return opts.PollInterval
+}
+
+func (opts *RebalanceOptions) timeout() time.Duration {
+	if opts == nil || opts.Timeout == 0 {
+		return 30 * time.Minute
+	}
+	return opts.Timeout
+}
+
+func waitForRebalanceFinish(ctx context.Context, serverAddr, username, password string,
+	opts *RebalanceOptions) error {
+
+	timer := time.NewTicker(opts.pollInterval())
+	defer timer.Stop()
+
+	var timeout <-chan time.Time
+	if d := opts.timeout(); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
 
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
 		case <-timer.C:
 
-			r, err := makeRequest(username, password, "GET", strings.NewReader(""), getTaskUrl(serverAddr))
+			r, err := makeCheckedRequest(username, password, "GET", strings.NewReader(""), getTaskUrl(serverAddr))
+			if err != nil {
+				if IsRetriable(err) {
+					// The servicing node may be briefly unavailable during rebalance
+					log.Println("tasks fetch, err:", err)
+					continue
+				}
+				return err
+			}
 
 			var tasks []interface{}
 			err = json.Unmarshal(r, &tasks)
@@ -166,36 +261,74 @@ func waitForRebalanceFinish(serverAddr, username, password string) error {
 			}
 
 			for _, v := range tasks {
-				task := v.(map[string]interface{})
+				task, ok := v.(map[string]interface{})
+				if !ok {
+					continue
+				}
 				if task["errorMessage"] != nil {
-					log.Println(task["errorMessage"].(string))
+					log.Println(task["errorMessage"])
 					return ErrRebalanceFailed
 				}
-				if task["type"].(string) == "rebalance" && task["status"].(string) == "running" {
+				taskType, _ := task["type"].(string)
+				status, _ := task["status"].(string)
+				if taskType == "rebalance" && status == "running" {
 					log.Println("Rebalance progress:", task["progress"])
+					opts.reportProgress(parseRebalanceProgress(task))
 				}
 
-				if task["type"].(string) == "rebalance" && task["status"].(string) == "notRunning" {
-					timer.Stop()
+				if taskType == "rebalance" && status == "notRunning" {
 					log.Println("Rebalance progress: 100")
+					p := parseRebalanceProgress(task)
+					p.Progress = 100
+					opts.reportProgress(p)
 					return nil
 				}
 			}
-			// Incase rebalance is stuck, terminate the wait after 30 minutes
+			// Incase rebalance is stuck, terminate the wait after the timeout
 		case <-timeout:
 			return ErrRebalanceTimedout
 		}
 	}
 }
 
+// makeRequest makes a REST call. The body of a response with a non-2xx
+// status is returned without error, for the caller to check.
 func makeRequest(username, password, requestType string, payload *strings.Reader, url string) ([]byte, error) {
+	return MakeRequestWithTLS(nil, username, password, requestType, payload, url)
+}
+
+// makeCheckedRequest makes a REST call as makeRequest, but fails with a
+// RestError for a response with a non-2xx status.
+func makeCheckedRequest(username, password, requestType string, payload *strings.Reader, url string) ([]byte, error) {
+	return doRequest(nil, username, password, requestType,
+		"application/x-www-form-urlencoded", payload, url, true)
+}
+
+// MakeRequestWithTLS makes a REST call using tlsConfig for https urls. A nil
+// tlsConfig uses the config set by SetClusterTLS. The body of a response
+// with a non-2xx status is returned without error.
+func MakeRequestWithTLS(tlsConfig *tls.Config, username, password, requestType string,
+	payload *strings.Reader, url string) ([]byte, error) {
+	return doRequest(tlsConfig, username, password, requestType,
+		"application/x-www-form-urlencoded", payload, url, false)
+}
+
+func makeJSONRequest(username, password, requestType string, payload *strings.Reader, url string) ([]byte, error) {
+	return doRequest(nil, username, password, requestType, "application/json", payload, url, true)
+}
+
+// doRequest makes a REST call, failing with a RestError if no response is
+// received or, with checkStatus, if the response has a non-2xx status.
+func doRequest(tlsConfig *tls.Config, username, password, requestType, contentType string,
+	payload *strings.Reader, url string, checkStatus bool) ([]byte, error) {
+
 	req, err := http.NewRequest(requestType, url, payload)
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
 
-	req.Header.Add("content-type", "application/x-www-form-urlencoded")
+	req.Header.Add("content-type", contentType)
 	if username != "" && password != "" {
 		req.SetBasicAuth(username, password)
 	}
@@ -203,8 +336,11 @@ func makeRequest(username, password, requestType string, payload *strings.Reader
 	var client *http.Client
 
 	if len(url) > 8 && url[0:8] == "https://" {
+		if tlsConfig == nil {
+			tlsConfig = getClusterTLS()
+		}
 		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig: tlsConfig,
 		}
 
 		client = &http.Client{Transport: tr}
@@ -215,73 +351,162 @@ func makeRequest(username, password, requestType string, payload *strings.Reader
 	res, err := client.Do(req)
 	if err != nil {
 		fmt.Println(err)
-		return nil, err
+		return nil, &RestError{Err: ErrNodeNotReady, Method: requestType, Url: url, Cause: err}
 	}
 	defer res.Body.Close()
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		fmt.Println(err)
-		return nil, err
+		return nil, &RestError{Err: ErrNodeNotReady, Method: requestType, Url: url, Cause: err}
+	}
+
+	if checkStatus && (res.StatusCode < 200 || res.StatusCode >= 300) {
+		return data, &RestError{
+			Err:        classify(res.StatusCode, string(data)),
+			Method:     requestType,
+			Url:        url,
+			StatusCode: res.StatusCode,
+			Body:       string(data),
+		}
 	}
 	return data, nil
 }
 
-func GetClusterStatus(serverAddr, username, password string) map[string][]string {
-	defer func() {
-		recover()
-	}()
+// ClusterStatusOptions tunes how GetClusterStatusWithOptions identifies nodes.
+type ClusterStatusOptions struct {
+	// Key nodes by their alternate address on Network, where advertised,
+	// instead of their internal hostname.
+	PreferAlternateAddresses bool
+	// Alternate address network. Defaults to "external".
+	Network string
+}
+
+type alternateAddress struct {
+	Hostname string         `json:"hostname"`
+	Ports    map[string]int `json:"ports,omitempty"`
+}
+
+type poolNode struct {
+	Hostname           string                      `json:"hostname"`
+	ClusterMembership  string                      `json:"clusterMembership"`
+	Services           []string                    `json:"services,omitempty"`
+	AlternateAddresses map[string]alternateAddress `json:"alternateAddresses,omitempty"`
+}
 
-	r, err := makeRequest(username, password, "GET", strings.NewReader(""), getPoolsUrl(serverAddr))
+type poolBucket struct {
+	Name string `json:"bucketName"`
+}
 
-	var pool couchbase.Pool
-	err = json.Unmarshal(r, &pool)
+type poolDefault struct {
+	Nodes       []poolNode   `json:"nodes"`
+	BucketNames []poolBucket `json:"bucketNames"`
+}
+
+// getPool returns the default pool, retrying while the servicing node is
+// not ready.
+func getPool(serverAddr, username, password string) (*poolDefault, error) {
+	var pool poolDefault
+	err := withRetry("getPool", DefaultRetryOptions, func() error {
+		r, err := makeCheckedRequest(username, password, "GET", strings.NewReader(""), getPoolsUrl(serverAddr))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(r, &pool); err != nil {
+			return fmt.Errorf("Error parsing pool %s, err: %w", r, err)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("otp node fetch error: %v", err)
+		return nil, err
+	}
+	return &pool, nil
+}
+
+// address returns the host:port of the node, on the alternate network if
+// requested by opts and advertised by the node.
+func (n *poolNode) address(opts *ClusterStatusOptions) string {
+	if opts == nil || !opts.PreferAlternateAddresses {
+		return n.Hostname
+	}
+
+	network := opts.Network
+	if network == "" {
+		network = "external"
+	}
+	alt, ok := n.AlternateAddresses[network]
+	if !ok || alt.Hostname == "" {
+		return n.Hostname
+	}
+
+	if port, ok := alt.Ports["mgmt"]; ok {
+		return net.JoinHostPort(alt.Hostname, strconv.Itoa(port))
+	}
+	// Alternate address without alternate ports uses the internal ports
+	if _, port, err := net.SplitHostPort(n.Hostname); err == nil {
+		return net.JoinHostPort(alt.Hostname, port)
+	}
+	return alt.Hostname
+}
+
+// GetClusterStatus returns the services of each node in the cluster, keyed by
+// hostname.
+func GetClusterStatus(serverAddr, username, password string) (map[string][]string, error) {
+	return GetClusterStatusWithOptions(serverAddr, username, password, nil)
+}
+
+// GetClusterStatusWithOptions returns the services of each node in the cluster,
+// keyed by hostname or alternate address per opts (nil for hostname).
+func GetClusterStatusWithOptions(serverAddr, username, password string,
+	opts *ClusterStatusOptions) (map[string][]string, error) {
+
+	pool, err := getPool(serverAddr, username, password)
+	if err != nil {
+		return nil, err
 	}
 
 	status := make(map[string][]string)
-	for _, node := range pool.Nodes {
-		status[node.Hostname] = node.Services
+	for i := range pool.Nodes {
+		status[pool.Nodes[i].address(opts)] = pool.Nodes[i].Services
 	}
-	return status
+	return status, nil
 }
 
 // AddNode just adds a node to the cluster but does NOT perform rebalance.
 // It does this by calling the ns_server /controller/addNode documented REST endpoint.
-// It retries up to 30 times one second apart because both the servicing node and the
-// newly added node may take a long time (at least > 10 sec) to become ready to respond.
-func AddNode(serverAddr, username, password, hostname string, role string) (err error) {
+// It retries with exponential backoff per DefaultRetryOptions because both the servicing
+// node and the newly added node may take a long time (at least > 10 sec) to become ready
+// to respond. Failures unwrap to ErrNodeNotReady, ErrAuth or ErrRebalanceRunning.
+func AddNode(serverAddr, username, password, hostname string, role string) error {
 	method := "AddNode" // for logging
 	host := prependHttp(hostname)
-	var res []byte      // raw HTTP response
-	var response string // string form of res
-	for retries := 0; ; retries++ {
-		res, err = addNodeFromRest(serverAddr, username, password, host, role)
-		if err == nil {
-			response = fmt.Sprintf("%s", res)
-			if strings.Contains(response, "{\"otpNode\":") {
-				log.Printf("%v: Successfully added node: %v (role %v), response: %v",
-					method, hostname, role, response)
-				return nil
-			}
+	var response string // string form of the raw HTTP response
+	err := withRetry(method, DefaultRetryOptions, func() error {
+		res, err := addNodeFromRest(serverAddr, username, password, host, role)
+		if err != nil {
+			return err
 		}
-		if retries >= 30 {
-			break
+		response = fmt.Sprintf("%s", res)
+		if !strings.Contains(response, "{\"otpNode\":") {
+			return fmt.Errorf("Unexpected response body: %v", response)
 		}
-		time.Sleep(1 * time.Second)
-	}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("%v: Error from addNodeFromRest while adding node: %v (role: %v), err: %v",
+		return fmt.Errorf("%v: Error from addNodeFromRest while adding node: %v (role: %v), err: %w",
 			method, hostname, role, err)
 	}
-	return fmt.Errorf("%v: Unexpected response body from addNodeFromRest while adding node: %v (role: %v), response: %v",
+
+	log.Printf("%v: Successfully added node: %v (role %v), response: %v",
 		method, hostname, role, response)
+	return nil
 }
 
 // AddNodeAndRebalance adds a node to the cluster and then does a rebalance.
 // Adding the node is delegated to AddNode.
 // Rebalance is done by calling the ns_server /controller/rebalance documented REST endpoint.
-func AddNodeAndRebalance(serverAddr, username, password, hostname string, role string) error {
+// Waiting for rebalance to finish is tuned by opts (nil for defaults) and cancelled by ctx.
+func AddNodeAndRebalance(ctx context.Context, serverAddr, username, password, hostname string,
+	role string, opts *RebalanceOptions) error {
 	method := "AddNodeAndRebalance" // for logging
 	err := AddNode(serverAddr, username, password, hostname, role)
 	if err != nil {
@@ -289,13 +514,37 @@ func AddNodeAndRebalance(serverAddr, username, password, hostname string, role s
 	}
 
 	if res, err := rebalanceFromRest(serverAddr, username, password, []string{""}); err != nil {
-		return fmt.Errorf("%v: Error calling rebalanceFromRest, err: %v", method, err)
+		return fmt.Errorf("%v: Error calling rebalanceFromRest, err: %w", method, err)
 	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
 		return fmt.Errorf("%v: Error in rebalanceFromRest response: %s", method, res)
 	}
 
-	if err := waitForRebalanceFinish(serverAddr, username, password); err != nil {
-		return fmt.Errorf("%v: Error during rebalance, err: %v", method, err)
+	if err := waitForRebalanceFinish(ctx, serverAddr, username, password, opts); err != nil {
+		return fmt.Errorf("%v: Error during rebalance, err: %w", method, err)
+	}
+	return nil
+}
+
+// SwapRebalance adds addNode with the given role and ejects removeNode in a single
+// rebalance, so that the indexer can move indexes directly between the swapped nodes.
+// Adding the node is delegated to AddNode. Waiting for rebalance to finish is tuned
+// by opts (nil for defaults) and cancelled by ctx.
+func SwapRebalance(ctx context.Context, serverAddr, username, password, addNode, removeNode,
+	role string, opts *RebalanceOptions) error {
+	method := "SwapRebalance" // for logging
+	err := AddNode(serverAddr, username, password, addNode, role)
+	if err != nil {
+		return err
+	}
+
+	if res, err := rebalanceFromRest(serverAddr, username, password, []string{removeNode}); err != nil {
+		return fmt.Errorf("%v: Error calling rebalanceFromRest, err: %w", method, err)
+	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
+		return fmt.Errorf("%v: Error in rebalanceFromRest response: %s", method, res)
+	}
+
+	if err := waitForRebalanceFinish(ctx, serverAddr, username, password, opts); err != nil {
+		return fmt.Errorf("%v: Error during rebalance, err: %w", method, err)
 	}
 	return nil
 }
@@ -303,7 +552,7 @@ func AddNodeAndRebalance(serverAddr, username, password, hostname string, role s
 func InitClusterServices(serverAddr, username, password, role string) error {
 
 	if res, err := initServicesFromRest(serverAddr, username, password, role); err != nil {
-		return fmt.Errorf("Error while initialising services from REST, err: %v", err)
+		return fmt.Errorf("Error while initialising services from REST, err: %w", err)
 	} else {
 		response := fmt.Sprintf("%s", res)
 		if response != "" {
@@ -315,7 +564,7 @@ func InitClusterServices(serverAddr, username, password, role string) error {
 
 func InitWebCreds(serverAddr, username, password string) error {
 	if res, err := initWebCredsFromRest(serverAddr, username, password); err != nil {
-		return fmt.Errorf("Error while initialising web credentials node from REST, err: %v", err)
+		return fmt.Errorf("Error while initialising web credentials node from REST, err: %w", err)
 	} else {
 		response := fmt.Sprintf("%s", res)
 		log.Printf("InitWebCreds, response is: %v", response)
@@ -324,12 +573,34 @@ func InitWebCreds(serverAddr, username, password string) error {
 }
 
 func InitDataAndIndexQuota(serverAddr, username, password string) error {
-	if res, err := setQuotaUsingRest(serverAddr, username, password); err != nil {
-		return fmt.Errorf("Error while setting index and data quota using REST, err: %v", err)
+	return SetMemoryQuotas(serverAddr, username, password, 1500, 1500, 0, 0)
+}
+
+// SetMemoryQuotas sets the per-node memory quotas, in MB, of the data, index,
+// search (fts) and analytics (cbas) services by calling the ns_server
+// /pools/default documented REST endpoint. A quota of 0 is left unchanged.
+func SetMemoryQuotas(serverAddr, username, password string, data, index, fts, cbas int) error {
+	quotas := url.Values{}
+	for key, quota := range map[string]int{
+		"memoryQuota":      data,
+		"indexMemoryQuota": index,
+		"ftsMemoryQuota":   fts,
+		"cbasMemoryQuota":  cbas,
+	} {
+		if quota > 0 {
+			quotas.Set(key, strconv.Itoa(quota))
+		}
+	}
+	if len(quotas) == 0 {
+		return nil
+	}
+
+	if res, err := setQuotaUsingRest(serverAddr, username, password, quotas); err != nil {
+		return fmt.Errorf("Error while setting memory quotas using REST, err: %w", err)
 	} else {
 		response := fmt.Sprintf("%s", res)
 		if response != "" {
-			return fmt.Errorf("Received error response while initialising data and index quota from REST, err: %v", err)
+			return fmt.Errorf("Received error response while setting memory quotas from REST, response: %v", response)
 		}
 	}
 	return nil
@@ -337,35 +608,82 @@ func InitDataAndIndexQuota(serverAddr, username, password string) error {
 
 // RemoveNode performs a rebalance out (ejection) of the specified node.
 // This is done by calling the ns_server /controller/rebalance documented REST endpoint.
-func RemoveNode(serverAddr, username, password, hostname string) error {
+// Waiting for rebalance to finish is tuned by opts (nil for defaults) and cancelled by ctx.
+func RemoveNode(ctx context.Context, serverAddr, username, password, hostname string,
+	opts *RebalanceOptions) error {
 	if res, err := rebalanceFromRest(serverAddr, username, password, []string{hostname}); err != nil {
-		return fmt.Errorf("Error while removing node and rebalance, hostname: %v, err: %v", hostname, err)
+		return fmt.Errorf("Error while removing node and rebalance, hostname: %v, err: %w", hostname, err)
 	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
 		return fmt.Errorf("Error removing node and rebalancing, rebalanceFromRest response: %s", res)
 	}
-	if err := waitForRebalanceFinish(serverAddr, username, password); err != nil {
-		return fmt.Errorf("Error during rebalance, err: %v", err)
+	if err := waitForRebalanceFinish(ctx, serverAddr, username, password, opts); err != nil {
+		return fmt.Errorf("Error during rebalance, err: %w", err)
 	}
 	return nil
 }
 
 func FailoverNode(serverAddr, username, password, hostname string) error {
 	if res, err := failoverFromRest(serverAddr, username, password, []string{hostname}); err != nil {
-		return fmt.Errorf("Error while failing over, hostname: %v, err: %v", hostname, err)
+		return fmt.Errorf("Error while failing over, hostname: %v, err: %w", hostname, err)
 	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
 		return fmt.Errorf("Error removing node and rebalancing, rebalanceFromRest response: %s", res)
 	}
 	return nil
 }
 
-func Rebalance(serverAddr, username, password string) error {
+// GracefulFailoverNode gracefully fails over the specified node by calling the
+// ns_server /controller/startGracefulFailover documented REST endpoint, and waits
+// for the failover to complete. Graceful failover is tracked as a rebalance task,
+// so waiting is tuned by opts (nil for defaults) and cancelled by ctx.
+func GracefulFailoverNode(ctx context.Context, serverAddr, username, password, hostname string,
+	opts *RebalanceOptions) error {
+	if res, err := gracefulFailoverFromRest(serverAddr, username, password, []string{hostname}); err != nil {
+		return fmt.Errorf("Error while gracefully failing over, hostname: %v, err: %w", hostname, err)
+	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
+		return fmt.Errorf("Error during graceful failover, gracefulFailoverFromRest response: %s", res)
+	}
+	if err := waitForRebalanceFinish(ctx, serverAddr, username, password, opts); err != nil {
+		return fmt.Errorf("Error during graceful failover, err: %w", err)
+	}
+	return nil
+}
+
+// RecoverNodeDelta adds back a failed over node with delta recovery and rebalances.
+func RecoverNodeDelta(ctx context.Context, serverAddr, username, password, hostname string,
+	opts *RebalanceOptions) error {
+	return recoverNode(ctx, serverAddr, username, password, hostname, "delta", opts)
+}
+
+// RecoverNodeFull adds back a failed over node with full recovery and rebalances.
+func RecoverNodeFull(ctx context.Context, serverAddr, username, password, hostname string,
+	opts *RebalanceOptions) error {
+	return recoverNode(ctx, serverAddr, username, password, hostname, "full", opts)
+}
+
+// recoverNode sets the recovery type of a failed over node by calling the
+// ns_server /controller/setRecoveryType documented REST endpoint, and then
+// rebalances the node back in.
+func recoverNode(ctx context.Context, serverAddr, username, password, hostname, recoveryType string,
+	opts *RebalanceOptions) error {
+	if res, err := recoveryFromRest(serverAddr, username, password, hostname, recoveryType); err != nil {
+		return fmt.Errorf("Error while setting %v recovery, hostname: %v, err: %w", recoveryType, hostname, err)
+	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
+		return fmt.Errorf("Error setting %v recovery, recoveryFromRest response: %s", recoveryType, res)
+	}
+	return Rebalance(ctx, serverAddr, username, password, opts)
+}
+
+// Rebalance rebalances the cluster without adding or removing nodes.
+// Waiting for rebalance to finish is tuned by opts (nil for defaults) and cancelled by ctx.
+func Rebalance(ctx context.Context, serverAddr, username, password string,
+	opts *RebalanceOptions) error {
 	if res, err := rebalanceFromRest(serverAddr, username, password, []string{""}); err != nil {
-		return fmt.Errorf("Error while rebalancing, err: %v", err)
+		return fmt.Errorf("Error while rebalancing, err: %w", err)
 	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
 		return fmt.Errorf("Error while rebalancing, rebalanceFromRest response: %s", res)
 	}
-	if err := waitForRebalanceFinish(serverAddr, username, password); err != nil {
-		return fmt.Errorf("Error during rebalance, err: %v", err)
+	if err := waitForRebalanceFinish(ctx, serverAddr, username, password, opts); err != nil {
+		return fmt.Errorf("Error during rebalance, err: %w", err)
 	}
 	return nil
 }
@@ -373,18 +691,18 @@ func Rebalance(serverAddr, username, password string) error {
 func ResetCluster(serverAddr, username, password string, dropNodes []string, keepNodes map[string]string) error {
 
 	if res, err := rebalanceFromRest(serverAddr, username, password, dropNodes); err != nil {
-		return fmt.Errorf("Error while rebalancing-out nodes %v, err: %v", dropNodes, err)
+		return fmt.Errorf("Error while rebalancing-out nodes %v, err: %w", dropNodes, err)
 	} else if err == nil && res != nil && (fmt.Sprintf("%s", res) != "") {
 		return fmt.Errorf("Error resetCluster: rebalanceFromRest, response: %s", res)
 	}
-	if err := waitForRebalanceFinish(serverAddr, username, password); err != nil {
-		return fmt.Errorf("Error in resetCluster, err: %v", err)
+	if err := waitForRebalanceFinish(context.Background(), serverAddr, username, password, nil); err != nil {
+		return fmt.Errorf("Error in resetCluster, err: %w", err)
 	}
 
 	for node, role := range keepNodes {
-		err := AddNodeAndRebalance(serverAddr, username, password, node, role)
+		err := AddNodeAndRebalance(context.Background(), serverAddr, username, password, node, role, nil)
 		if err != nil {
-			return fmt.Errorf("Error while adding node: %v (role: %v) to cluster, err: %v", node, role, err)
+			return fmt.Errorf("Error while adding node: %v (role: %v) to cluster, err: %w", node, role, err)
 		}
 	}
 	return nil
@@ -443,7 +761,7 @@ func prependHttp(url string) string {
 }
 
 func prependHttps(url string) string {
-	if len(url) > 8 && url[0:7] == "https://" {
+	if len(url) > 8 && url[0:8] == "https://" {
 		return url
 	} else if len(url) > 7 && url[0:7] == "http://" {
 		newUrl := "https://" + url[7:]
@@ -463,14 +781,24 @@ var securePortMap = map[string]string{
 	"9102": "19102",
 }
 
+// useSecurePort replaces a well known port in hostname, which may have a
+// scheme and may be a bracketed IPv6 literal, by its secure counterpart.
 func useSecurePort(hostname string) string {
-	comps := strings.Split(hostname, ":")
-	n := len(comps)
-	if n > 0 {
-		if newPort, ok := securePortMap[comps[n-1]]; ok {
-			comps[n-1] = newPort
-			return strings.Join(comps, ":")
-		}
+	var scheme, path string
+	hostport := hostname
+	if i := strings.Index(hostport, "://"); i >= 0 {
+		scheme, hostport = hostport[:i+3], hostport[i+3:]
+	}
+	if i := strings.Index(hostport, "/"); i >= 0 {
+		hostport, path = hostport[:i], hostport[i:]
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostname
+	}
+	if newPort, ok := securePortMap[port]; ok {
+		return scheme + net.JoinHostPort(host, newPort) + path
 	}
 
 	return hostname
@@ -0,0 +1,135 @@
+package clusterutility
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrIndexerBootstrapTimedout = errors.New("Indexer did not finish bootstrap within the timeout")
+
+func getNodeServicesUrl(serverAddr string) string {
+	return prependHttp(serverAddr) + "/pools/default/nodeServices"
+}
+
+func getIndexerStatsUrl(nodeAddr string) string {
+	return prependHttp(nodeAddr) + "/api/v1/stats"
+}
+
+// GetIndexerHttpAddresses returns the host:port of the REST endpoint of
+// every indexer in the cluster.
+func GetIndexerHttpAddresses(serverAddr, username, password string) ([]string, error) {
+	var nodeServices struct {
+		NodesExt []struct {
+			Hostname string         `json:"hostname"`
+			Services map[string]int `json:"services"`
+		} `json:"nodesExt"`
+	}
+	err := withRetry("GetIndexerHttpAddresses", DefaultRetryOptions, func() error {
+		r, err := makeCheckedRequest(username, password, "GET", strings.NewReader(""), getNodeServicesUrl(serverAddr))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(r, &nodeServices); err != nil {
+			return fmt.Errorf("Error parsing node services %s, err: %w", r, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, node := range nodeServices.NodesExt {
+		port, ok := node.Services["indexHttp"]
+		if !ok {
+			continue
+		}
+		host := node.Hostname
+		if host == "" {
+			// ns_server omits the hostname of a single node cluster
+			if host, _, err = net.SplitHostPort(strings.TrimPrefix(prependHttp(serverAddr), "http://")); err != nil {
+				return nil, err
+			}
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return addrs, nil
+}
+
+// getIndexerState returns the indexer_state stat of the indexer at nodeAddr,
+// e.g. "Warmup" while bootstrapping and "Active" once done.
+func getIndexerState(nodeAddr, username, password string) (string, error) {
+	r, err := makeCheckedRequest(username, password, "GET", strings.NewReader(""), getIndexerStatsUrl(nodeAddr))
+	if err != nil {
+		return "", err
+	}
+
+	var stats map[string]interface{}
+	if err := json.Unmarshal(r, &stats); err != nil {
+		return "", fmt.Errorf("Error parsing indexer stats, err: %w", err)
+	}
+	indexerStats, _ := stats["indexer"].(map[string]interface{})
+	state, ok := indexerStats["indexer_state"].(string)
+	if !ok {
+		return "", fmt.Errorf("indexer_state not found in stats of %v", nodeAddr)
+	}
+	return state, nil
+}
+
+// WaitForIndexerBootstrap polls the stats of the indexer at nodeAddr, the
+// host:port of its REST endpoint, until it finishes warmup and becomes
+// Active. Errors while the indexer is (re)starting and cannot respond are
+// ignored. It fails with ErrIndexerBootstrapTimedout after timeout, and
+// can be cancelled by ctx.
+func WaitForIndexerBootstrap(ctx context.Context, nodeAddr, username, password string,
+	timeout time.Duration) error {
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	var state string
+	for {
+		var err error
+		state, err = getIndexerState(nodeAddr, username, password)
+		if err == nil && state == "Active" {
+			log.Printf("WaitForIndexerBootstrap: indexer %v is Active", nodeAddr)
+			return nil
+		} else if errors.Is(err, ErrAuth) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return fmt.Errorf("%w: %v, state: %v", ErrIndexerBootstrapTimedout, nodeAddr, state)
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForIndexersBootstrap waits for every indexer in the cluster to finish
+// bootstrap, as WaitForIndexerBootstrap.
+func WaitForIndexersBootstrap(ctx context.Context, serverAddr, username, password string,
+	timeout time.Duration) error {
+
+	addrs, err := GetIndexerHttpAddresses(serverAddr, username, password)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := WaitForIndexerBootstrap(ctx, addr, username, password, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package clusterutility
+
+import (
+	"time"
+)
+
+// RebalanceProgress is a sample of the rebalance task, as reported by the
+// ns_server /pools/default/tasks REST endpoint.
+type RebalanceProgress struct {
+	Time     time.Time
+	Status   string  // "running" or "notRunning"
+	Progress float64 // overall progress, 0 to 100
+	// Progress of each service being rebalanced, keyed by service name
+	// as reported by ns_server, e.g. "data" for kv and "index" for indexer.
+	// Empty when ns_server does not report per-service progress.
+	Services map[string]float64
+}
+
+// ServiceProgress returns the progress of service, and false when the
+// sample has no progress for it.
+func (p RebalanceProgress) ServiceProgress(service string) (float64, bool) {
+	if service == "kv" {
+		service = "data"
+	}
+	progress, ok := p.Services[service]
+	return progress, ok
+}
+
+// parseRebalanceProgress builds a progress sample from a rebalance task.
+func parseRebalanceProgress(task map[string]interface{}) RebalanceProgress {
+	p := RebalanceProgress{
+		Time:     time.Now(),
+		Services: make(map[string]float64),
+	}
+	p.Status, _ = task["status"].(string)
+	p.Progress, _ = task["progress"].(float64)
+
+	stages, _ := task["stageInfo"].(map[string]interface{})
+	for service, v := range stages {
+		stage, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if progress, ok := stage["totalProgress"].(float64); ok {
+			p.Services[service] = progress
+		}
+	}
+	return p
+}
+
+// reportProgress passes p to the progress callback in opts, if any.
+func (opts *RebalanceOptions) reportProgress(p RebalanceProgress) {
+	if opts != nil && opts.Progress != nil {
+		opts.Progress(p)
+	}
+}
@@ -0,0 +1,114 @@
+package clusterutility
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMakeRequestStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+	}))
+	defer server.Close()
+
+	// the body of a failed request is for the caller to check.
+	data, err := makeRequest("", "", "GET", strings.NewReader(""), server.URL)
+	if err != nil || string(data) != "bad request" {
+		t.Errorf("expected body without error, got %q, %v", data, err)
+	}
+
+	data, err = makeCheckedRequest("", "", "GET", strings.NewReader(""), server.URL)
+	var restErr *RestError
+	if !errors.As(err, &restErr) || restErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected RestError with status 400, got %v", err)
+	}
+	if string(data) != "bad request" || restErr.Err != nil {
+		t.Errorf("unexpected body %q or classified error %v", data, restErr.Err)
+	}
+}
+
+func TestMakeCheckedRequestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		body   string
+		err    error
+	}{
+		{http.StatusServiceUnavailable, "", ErrNodeNotReady},
+		{http.StatusUnauthorized, "", ErrAuth},
+		{http.StatusBadRequest, "Rebalance running.", ErrRebalanceRunning},
+		{http.StatusOK, "", nil},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+
+		_, err := makeCheckedRequest("", "", "GET", strings.NewReader(""), server.URL)
+		if (tc.err == nil && err != nil) || !errors.Is(err, tc.err) {
+			t.Errorf("status %v %q: expected %v, got %v", tc.status, tc.body, tc.err, err)
+		}
+		server.Close()
+	}
+
+	if _, err := makeCheckedRequest("", "", "GET", strings.NewReader(""), "http://127.0.0.1:1"); !errors.Is(err, ErrNodeNotReady) {
+		t.Errorf("expected %v without response, got %v", ErrNodeNotReady, err)
+	}
+}
+
+func TestGetClusterStatusRetries(t *testing.T) {
+	defer func(opts RetryOptions) { DefaultRetryOptions = opts }(DefaultRetryOptions)
+	DefaultRetryOptions = RetryOptions{MaxRetries: 3, InitialBackoff: time.Millisecond}
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"nodes": [{"hostname": "127.0.0.1:9000", "services": ["kv", "index"]}]}`))
+	}))
+	defer server.Close()
+
+	status, err := GetClusterStatus(server.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsNodeIndex(status, "127.0.0.1:9000") || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("unexpected status %v after %v calls", status, calls)
+	}
+
+	atomic.StoreInt32(&calls, -10)
+	if _, err := GetClusterStatus(server.URL, "", ""); !errors.Is(err, ErrNodeNotReady) {
+		t.Errorf("expected %v once retries are exhausted, got %v", ErrNodeNotReady, err)
+	}
+}
+
+func TestRebalanceRunningErrors(t *testing.T) {
+	defer func(opts RetryOptions) { DefaultRetryOptions = opts }(DefaultRetryOptions)
+	DefaultRetryOptions = RetryOptions{MaxRetries: 1, InitialBackoff: time.Millisecond}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pools/default" {
+			w.Write([]byte(`{"nodes": [{"hostname": "127.0.0.1:9000", "otpNode": "ns_1@127.0.0.1"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Rebalance running."))
+	}))
+	defer server.Close()
+
+	// ns_server rejecting the POST while a rebalance runs is returned typed
+	if err := FailoverNode(server.URL, "", "", "127.0.0.1:9000"); !errors.Is(err, ErrRebalanceRunning) {
+		t.Errorf("expected %v from failover, got %v", ErrRebalanceRunning, err)
+	}
+	if err := RemoveNode(context.Background(), server.URL, "", "", "127.0.0.1:9000", nil); !errors.Is(err, ErrRebalanceRunning) {
+		t.Errorf("expected %v from rebalance, got %v", ErrRebalanceRunning, err)
+	}
+}
@@ -0,0 +1,104 @@
+package clusterutility
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrNodeNotReady = errors.New("Node is not ready")
+var ErrAuth = errors.New("Authentication failed")
+var ErrRebalanceRunning = errors.New("Rebalance is running")
+
+// RestError is returned by REST calls which failed. Err is one of the typed
+// errors ErrNodeNotReady, ErrAuth, ErrRebalanceRunning, or nil if the
+// failure is not classified, and can be checked with errors.Is.
+type RestError struct {
+	Err        error
+	Method     string
+	Url        string
+	StatusCode int
+	Body       string
+	Cause      error // transport error, if no response was received
+}
+
+func (e *RestError) Error() string {
+	var msg string
+	if e.Cause != nil {
+		msg = fmt.Sprintf("%v %v failed: %v", e.Method, e.Url, e.Cause)
+	} else {
+		msg = fmt.Sprintf("%v %v failed with status %v: %v", e.Method, e.Url,
+			e.StatusCode, strings.TrimSpace(e.Body))
+	}
+	if e.Err != nil {
+		return e.Err.Error() + ": " + msg
+	}
+	return msg
+}
+
+func (e *RestError) Unwrap() error {
+	return e.Err
+}
+
+// classify maps a REST response to one of the typed errors. Returns nil for
+// successful responses.
+func classify(statusCode int, body string) error {
+	lower := strings.ToLower(body)
+	switch {
+	case strings.Contains(lower, "rebalance running"),
+		strings.Contains(lower, "rebalance is running"):
+		return ErrRebalanceRunning
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		return ErrAuth
+	case statusCode >= 500,
+		strings.Contains(lower, "prepare join failed"),
+		strings.Contains(lower, "connection refused"),
+		strings.Contains(lower, "econnrefused"),
+		strings.Contains(lower, "not ready"):
+		return ErrNodeNotReady
+	}
+	return nil
+}
+
+// RetryOptions controls retries of REST calls with exponential backoff.
+type RetryOptions struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryOptions retry for about a minute, because both the servicing
+// node and a newly added node may take a long time to become ready.
+var DefaultRetryOptions = RetryOptions{
+	MaxRetries:     10,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// IsRetriable returns true for errors which are expected to clear up
+// when the request is retried.
+func IsRetriable(err error) bool {
+	return errors.Is(err, ErrNodeNotReady) || errors.Is(err, ErrRebalanceRunning)
+}
+
+// withRetry calls fn until it succeeds, fails with an error which is not
+// retriable, or the retries in opts are exhausted. The last error is returned.
+func withRetry(method string, opts RetryOptions, fn func() error) error {
+	backoff := opts.InitialBackoff
+	for retries := 0; ; retries++ {
+		err := fn()
+		if err == nil || !IsRetriable(err) || retries >= opts.MaxRetries {
+			return err
+		}
+
+		log.Printf("%v: retrying in %v after error: %v", method, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
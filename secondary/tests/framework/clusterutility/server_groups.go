@@ -0,0 +1,164 @@
+package clusterutility
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+var ErrServerGroupNotFound = errors.New("Server group not found")
+
+func getServerGroupsUrl(serverAddr string) string {
+	return prependHttp(serverAddr) + "/pools/default/serverGroups"
+}
+
+type serverGroupNode struct {
+	Hostname string `json:"hostname,omitempty"`
+	OtpNode  string `json:"otpNode"`
+}
+
+type serverGroup struct {
+	Name  string            `json:"name,omitempty"`
+	Uri   string            `json:"uri"`
+	Nodes []serverGroupNode `json:"nodes"`
+}
+
+type serverGroups struct {
+	Groups []serverGroup `json:"groups"`
+	Uri    string        `json:"uri,omitempty"` // includes the revision
+}
+
+func getServerGroupsFromRest(serverAddr, username, password string) (*serverGroups, error) {
+	var groups serverGroups
+	err := withRetry("getServerGroups", DefaultRetryOptions, func() error {
+		r, err := makeCheckedRequest(username, password, "GET", strings.NewReader(""), getServerGroupsUrl(serverAddr))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(r, &groups); err != nil {
+			return fmt.Errorf("Error parsing server groups %s, err: %w", r, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &groups, nil
+}
+
+// GetServerGroups returns the hostnames of the nodes in each server group,
+// keyed by group name.
+func GetServerGroups(serverAddr, username, password string) (map[string][]string, error) {
+	groups, err := getServerGroupsFromRest(serverAddr, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string][]string)
+	for _, group := range groups.Groups {
+		hosts := make([]string, 0, len(group.Nodes))
+		for _, node := range group.Nodes {
+			hosts = append(hosts, node.Hostname)
+		}
+		res[group.Name] = hosts
+	}
+	return res, nil
+}
+
+// CreateServerGroup creates an empty server group by calling the ns_server
+// /pools/default/serverGroups documented REST endpoint.
+func CreateServerGroup(serverAddr, username, password, name string) error {
+	log.Printf("Creating server group: %v\n", name)
+
+	payload := strings.NewReader(fmt.Sprintf("name=%s", url.QueryEscape(name)))
+	_, err := makeCheckedRequest(username, password, "POST", payload, getServerGroupsUrl(serverAddr))
+	if err != nil {
+		return fmt.Errorf("Error while creating server group %v, err: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteServerGroup deletes an empty server group.
+func DeleteServerGroup(serverAddr, username, password, name string) error {
+	log.Printf("Deleting server group: %v\n", name)
+
+	groups, err := getServerGroupsFromRest(serverAddr, username, password)
+	if err != nil {
+		return err
+	}
+	group := groups.find(name)
+	if group == nil {
+		return fmt.Errorf("%w: %v", ErrServerGroupNotFound, name)
+	}
+
+	_, err = makeCheckedRequest(username, password, "DELETE", strings.NewReader(""),
+		prependHttp(serverAddr)+group.Uri)
+	if err != nil {
+		return fmt.Errorf("Error while deleting server group %v, err: %w", name, err)
+	}
+	return nil
+}
+
+// AssignServerGroup moves the nodes with the given hostnames to the named
+// server group. The assignment takes effect on the next rebalance.
+func AssignServerGroup(serverAddr, username, password, name string, hostnames []string) error {
+	log.Printf("Assigning node(s): %v to server group: %v\n", hostnames, name)
+
+	groups, err := getServerGroupsFromRest(serverAddr, username, password)
+	if err != nil {
+		return err
+	}
+	target := groups.find(name)
+	if target == nil {
+		return fmt.Errorf("%w: %v", ErrServerGroupNotFound, name)
+	}
+
+	move := make(map[string]bool)
+	for _, host := range hostnames {
+		move[host] = true
+	}
+
+	// ns_server expects the complete membership of every group
+	var moved []serverGroupNode
+	for i := range groups.Groups {
+		group := &groups.Groups[i]
+		var nodes []serverGroupNode
+		for _, node := range group.Nodes {
+			if move[node.Hostname] {
+				moved = append(moved, serverGroupNode{OtpNode: node.OtpNode})
+				delete(move, node.Hostname)
+			} else {
+				nodes = append(nodes, serverGroupNode{OtpNode: node.OtpNode})
+			}
+		}
+		group.Nodes = nodes
+		group.Name = ""
+	}
+	if len(move) > 0 {
+		return fmt.Errorf("Unknown node(s) %v while assigning server group %v", move, name)
+	}
+	target.Nodes = append(target.Nodes, moved...)
+
+	body, err := json.Marshal(serverGroups{Groups: groups.Groups})
+	if err != nil {
+		return err
+	}
+	_, err = makeJSONRequest(username, password, "PUT", strings.NewReader(string(body)),
+		prependHttp(serverAddr)+groups.Uri)
+	if err != nil {
+		return fmt.Errorf("Error while assigning server group %v, err: %w", name, err)
+	}
+	return nil
+}
+
+func (g *serverGroups) find(name string) *serverGroup {
+	for i := range g.Groups {
+		if g.Groups[i].Name == name {
+			return &g.Groups[i]
+		}
+	}
+	return nil
+}
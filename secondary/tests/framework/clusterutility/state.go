@@ -0,0 +1,149 @@
+package clusterutility
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NodeState is the membership and services of a node in the cluster.
+type NodeState struct {
+	Membership string   // e.g. "active", "inactiveAdded", "inactiveFailed"
+	Services   []string // sorted
+}
+
+// ClusterState is a snapshot of the cluster topology, captured before a
+// test so that it can be verified, or restored, after the test.
+type ClusterState struct {
+	Nodes   map[string]NodeState // keyed by hostname
+	Buckets []string             // sorted
+}
+
+// CaptureClusterState records the node membership, services and buckets
+// of the cluster.
+func CaptureClusterState(serverAddr, username, password string) (*ClusterState, error) {
+	pool, err := getPool(serverAddr, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &ClusterState{Nodes: make(map[string]NodeState)}
+	for _, node := range pool.Nodes {
+		services := append([]string(nil), node.Services...)
+		sort.Strings(services)
+		state.Nodes[node.Hostname] = NodeState{
+			Membership: node.ClusterMembership,
+			Services:   services,
+		}
+	}
+	for _, bucket := range pool.BucketNames {
+		state.Buckets = append(state.Buckets, bucket.Name)
+	}
+	sort.Strings(state.Buckets)
+	return state, nil
+}
+
+// Diff returns the differences of other from s, one per line. An empty
+// result means both states are the same.
+func (s *ClusterState) Diff(other *ClusterState) []string {
+	var diffs []string
+
+	for _, host := range sortedHosts(s.Nodes) {
+		want := s.Nodes[host]
+		got, ok := other.Nodes[host]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("node %v is missing", host))
+			continue
+		}
+		if got.Membership != want.Membership {
+			diffs = append(diffs, fmt.Sprintf("node %v membership is %v, expected %v",
+				host, got.Membership, want.Membership))
+		}
+		if !equalStrings(got.Services, want.Services) {
+			diffs = append(diffs, fmt.Sprintf("node %v services are %v, expected %v",
+				host, got.Services, want.Services))
+		}
+	}
+	for _, host := range sortedHosts(other.Nodes) {
+		if _, ok := s.Nodes[host]; !ok {
+			diffs = append(diffs, fmt.Sprintf("node %v is unexpected", host))
+		}
+	}
+
+	if !equalStrings(other.Buckets, s.Buckets) {
+		diffs = append(diffs, fmt.Sprintf("buckets are %v, expected %v", other.Buckets, s.Buckets))
+	}
+	return diffs
+}
+
+// VerifyClusterState returns an error describing how the cluster differs
+// from state, or nil if it does not.
+func VerifyClusterState(serverAddr, username, password string, state *ClusterState) error {
+	current, err := CaptureClusterState(serverAddr, username, password)
+	if err != nil {
+		return err
+	}
+	if diffs := state.Diff(current); len(diffs) > 0 {
+		return fmt.Errorf("Cluster state changed: %v", strings.Join(diffs, "; "))
+	}
+	return nil
+}
+
+// RestoreClusterState brings the nodes of the cluster back to state by way
+// of ResetCluster: unexpected nodes, and nodes which changed membership or
+// services, are rebalanced out, and missing nodes are added back with their
+// recorded services. Buckets are not restored, but are verified along with
+// the nodes once the cluster is reset.
+func RestoreClusterState(serverAddr, username, password string, state *ClusterState) error {
+	current, err := CaptureClusterState(serverAddr, username, password)
+	if err != nil {
+		return err
+	}
+	if len(state.Diff(current)) == 0 {
+		return nil
+	}
+
+	var dropNodes []string
+	keepNodes := make(map[string]string)
+	for _, host := range sortedHosts(current.Nodes) {
+		got := current.Nodes[host]
+		want, ok := state.Nodes[host]
+		if !ok {
+			dropNodes = append(dropNodes, host)
+		} else if got.Membership != want.Membership || !equalStrings(got.Services, want.Services) {
+			dropNodes = append(dropNodes, host)
+			keepNodes[host] = strings.Join(want.Services, ",")
+		}
+	}
+	for host, want := range state.Nodes {
+		if _, ok := current.Nodes[host]; !ok {
+			keepNodes[host] = strings.Join(want.Services, ",")
+		}
+	}
+
+	if err := ResetCluster(serverAddr, username, password, dropNodes, keepNodes); err != nil {
+		return err
+	}
+	return VerifyClusterState(serverAddr, username, password, state)
+}
+
+func sortedHosts(nodes map[string]NodeState) []string {
+	hosts := make([]string, 0, len(nodes))
+	for host := range nodes {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
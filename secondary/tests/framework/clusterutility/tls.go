@@ -0,0 +1,85 @@
+package clusterutility
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+var ErrInvalidCACert = errors.New("No valid certificates found in CA file")
+
+// TLSOptions describes how https requests to the cluster are secured.
+// With an empty CAFile, server certificates are not verified.
+type TLSOptions struct {
+	CAFile     string // PEM encoded cluster CA used to verify servers
+	CertFile   string // PEM encoded client certificate, for n2n encryption
+	KeyFile    string // PEM encoded key of the client certificate
+	ServerName string // overrides the server name used for verification
+}
+
+// NewTLSConfig builds a tls.Config from opts.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: opts.ServerName}
+
+	if opts.CAFile == "" {
+		cfg.InsecureSkipVerify = true
+	} else {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA file %v, err: %v", opts.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrInvalidCACert
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate %v, err: %v",
+				opts.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+var tlsMu sync.RWMutex
+var clusterTLS *tls.Config
+
+// SetClusterTLS secures all subsequent https requests made by this package
+// with opts. Requests made with an explicit tls.Config are unaffected.
+func SetClusterTLS(opts TLSOptions) error {
+	cfg, err := NewTLSConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	clusterTLS = cfg
+	return nil
+}
+
+// ResetClusterTLS reverts https requests to skip certificate verification.
+func ResetClusterTLS() {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	clusterTLS = nil
+}
+
+func getClusterTLS() *tls.Config {
+	tlsMu.RLock()
+	defer tlsMu.RUnlock()
+
+	if clusterTLS == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	return clusterTLS.Clone()
+}
@@ -1,6 +1,7 @@
 package functionaltests
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"testing"
@@ -27,7 +28,7 @@ func addNodeAndRebalance(hostname, role string, t *testing.T) {
 	username := clusterconfig.Username
 	password := clusterconfig.Password
 
-	if err := cluster.AddNodeAndRebalance(serverAddr, username, password, hostname, role); err != nil {
+	if err := cluster.AddNodeAndRebalance(context.Background(), serverAddr, username, password, hostname, role, nil); err != nil {
 		t.Fatalf(err.Error())
 	}
 }
@@ -38,7 +39,7 @@ func removeNode(hostname string, t *testing.T) {
 	username := clusterconfig.Username
 	password := clusterconfig.Password
 
-	if err := cluster.RemoveNode(serverAddr, username, password, hostname); err != nil {
+	if err := cluster.RemoveNode(context.Background(), serverAddr, username, password, hostname, nil); err != nil {
 		t.Fatalf(err.Error())
 	}
 }
@@ -58,7 +59,7 @@ func rebalance(t *testing.T) {
 	username := clusterconfig.Username
 	password := clusterconfig.Password
 
-	if err := cluster.Rebalance(serverAddr, username, password); err != nil {
+	if err := cluster.Rebalance(context.Background(), serverAddr, username, password, nil); err != nil {
 		t.Fatalf(err.Error())
 	}
 }
@@ -91,7 +92,22 @@ func getClusterStatus() map[string][]string {
 	username := clusterconfig.Username
 	password := clusterconfig.Password
 
-	return cluster.GetClusterStatus(serverAddr, username, password)
+	status, err := cluster.GetClusterStatus(serverAddr, username, password)
+	if err != nil {
+		log.Printf("Error fetching cluster status, err: %v", err)
+	}
+	return status
+}
+
+// waitForIndexerBootstrap waits for all indexers in the cluster to become
+// Active after a node add or an indexer restart.
+func waitForIndexerBootstrap() error {
+	serverAddr := clusterconfig.KVAddress
+	username := clusterconfig.Username
+	password := clusterconfig.Password
+
+	return cluster.WaitForIndexersBootstrap(context.Background(), serverAddr, username, password,
+		5*time.Minute)
 }
 
 func isNodeIndex(status map[string][]string, hostname string) bool {
@@ -150,10 +166,12 @@ func initClusterFromREST() error {
 		}
 
 		time.Sleep(1 * time.Second)
-		if err := cluster.AddNodeAndRebalance(serverAddr, username, password, clusterconfig.Nodes[1], "kv,index"); err != nil {
+		if err := cluster.AddNodeAndRebalance(context.Background(), serverAddr, username, password, clusterconfig.Nodes[1], "kv,index", nil); err != nil {
+			return err
+		}
+		if err := waitForIndexerBootstrap(); err != nil {
 			return err
 		}
-		time.Sleep(5 * time.Second)
 		kvutility.CreateBucket("default", "sasl", "", username, password, serverAddr, "1500", "11213")
 		time.Sleep(5 * time.Second)
 		status = getClusterStatus()
@@ -447,7 +447,10 @@ func forceKillIndexer() {
 	// restart the indexer
 	fmt.Println("Restarting indexer process ...")
 	tc.KillIndexer()
-	time.Sleep(20 * time.Second)
+	// Give the killed indexer time to exit before polling for the new one
+	time.Sleep(2 * time.Second)
+	err := waitForIndexerBootstrap()
+	tc.HandleError(err, "Error waiting for indexer bootstrap after restart")
 }
 
 func restful_clonebody(src map[string]interface{}) map[string]interface{} {
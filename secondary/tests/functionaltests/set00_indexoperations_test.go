@@ -37,10 +37,8 @@ func TestRestartNilSnapshot(t *testing.T) {
 	err = secondaryindex.CreateSecondaryIndex("idx_age", "default", indexManagementAddress, "", []string{"age"}, false, nil, true, defaultIndexActiveTimeout, nil)
 	FailTestIfError(err, "Error in creating the index", t)
 
-	// Restart indexer process and wait for some time.
-	log.Printf("Restarting indexer process ...")
-	tc.KillIndexer()
-	time.Sleep(200 * time.Second)
+	// Restart indexer process and wait for it to be active.
+	forceKillIndexer()
 
 	docScanResults := datautility.ExpectedScanResponse_string(docs, "eyeColor", "b", "c", 3)
 	scanResults, err1 := secondaryindex.Range("index_eyeColor", "default", indexScanAddress, []interface{}{"b"}, []interface{}{"c"}, 3, false, defaultlimit, c.SessionConsistency, nil)
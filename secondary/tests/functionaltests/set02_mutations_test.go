@@ -16,8 +16,7 @@ import (
 func TestRestartIndexer(t *testing.T) {
 	log.Printf("In TestRestartIndexer()")
 
-	tc.KillIndexer()
-	time.Sleep(20 * time.Second)
+	forceKillIndexer()
 
 	var indexName = "index_age"
 	var bucketName = "default"
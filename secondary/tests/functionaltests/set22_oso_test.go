@@ -119,8 +119,7 @@ func TestOSOInitBuildIndexerRestart(t *testing.T) {
 	FailTestIfError(err, "Error from BuildIndexesAsync of index", t)
 	time.Sleep(100 * time.Millisecond)
 
-	tc.KillIndexer()
-	time.Sleep(5 * time.Second)
+	forceKillIndexer()
 
 	defnID, _ := secondaryindex.GetDefnID(client, bucketName, indexName)
 	err = secondaryindex.WaitTillIndexActive(defnID, client, defaultIndexActiveTimeout)
@@ -0,0 +1,542 @@
+// Multiplexed framing of a connection into streams.
+//
+//      { uint32(streamId), uint8(frameType), uint32(framelen), []byte(payload) }
+//
+// A stream is opened by the client with an empty data frame, and carries
+// the same packets, in the same order, as a connection that is not
+// multiplexed, so that a stream can be used in place of a connection.
+// Stream ids are allocated by the client, in increasing order.
+//
+// Flow control is per stream: the sender of data frames may not have more
+// than `window` bytes sent and not yet read by the receiver. The receiver
+// returns credit with window frames as the application reads data. Either
+// end may close a stream with a close frame, after which it does not send
+// data on the stream. Closing a stream cancels the request on it.
+
+package transport
+
+import "encoding/binary"
+import "errors"
+import "io"
+import "net"
+import "sync"
+import "time"
+
+// frame types
+const (
+	muxFrameData   byte = iota + 1
+	muxFrameWindow      // payload is uint32 bytes read by receiver
+	muxFrameClose
+)
+
+// frame field offset and size in bytes
+const (
+	muxIdOffset     int = 0
+	muxIdSize       int = 4
+	muxTypeOffset   int = muxIdOffset + muxIdSize
+	muxTypeSize     int = 1
+	muxLenOffset    int = muxTypeOffset + muxTypeSize
+	muxLenSize      int = 4
+	muxHeaderSize   int = muxLenOffset + muxLenSize
+	muxMaxFrameSize int = 32 * 1024
+)
+
+// DefaultMuxWindow is the default flow control window of a stream.
+const DefaultMuxWindow = 256 * 1024
+
+// muxAcceptBacklog is the number of opened streams not yet accepted,
+// beyond which frames are not received until streams are accepted.
+const muxAcceptBacklog = 64
+
+// ErrorMuxClosed is operation on a closed multiplexed connection.
+var ErrorMuxClosed = errors.New("transport.muxClosed")
+
+// ErrorMuxStreamClosed is operation on a closed stream.
+var ErrorMuxStreamClosed = errors.New("transport.muxStreamClosed")
+
+// ErrorMuxProtocol is invalid frame received.
+var ErrorMuxProtocol = errors.New("transport.muxProtocol")
+
+// muxTimeoutError is returned by stream reads and writes past the deadline.
+type muxTimeoutError struct{}
+
+func (muxTimeoutError) Error() string   { return "transport.muxTimeout: i/o timeout" }
+func (muxTimeoutError) Timeout() bool   { return true }
+func (muxTimeoutError) Temporary() bool { return true }
+
+// MuxSession multiplexes streams over a connection.
+type MuxSession struct {
+	conn   net.Conn
+	client bool
+	window int
+
+	wmu  sync.Mutex // serializes frames written to conn
+	wbuf []byte
+
+	mu       sync.Mutex
+	streams  map[uint32]*MuxStream
+	lastId   uint32 // last stream opened
+	acceptch chan *MuxStream
+	closech  chan struct{}
+	err      error
+}
+
+// NewMuxSession multiplexes conn, on the client or the server end, with
+// a flow control window of window bytes per stream. Both ends must use
+// the same window.
+func NewMuxSession(conn net.Conn, client bool, window int) *MuxSession {
+	s := &MuxSession{
+		conn:     conn,
+		client:   client,
+		window:   window,
+		wbuf:     make([]byte, muxHeaderSize+muxMaxFrameSize),
+		streams:  make(map[uint32]*MuxStream),
+		acceptch: make(chan *MuxStream, muxAcceptBacklog),
+		closech:  make(chan struct{}),
+	}
+	go s.doReceive()
+	return s
+}
+
+// Open a new stream, on the client end.
+func (s *MuxSession) Open() (*MuxStream, error) {
+	// Streams are opened in the order of their ids
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	st, err := func() (*MuxStream, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.err != nil {
+			return nil, s.err
+		}
+		s.lastId++
+		st := newMuxStream(s, s.lastId)
+		s.streams[st.id] = st
+		return st, nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.writeFrameLocked(st.id, muxFrameData, nil, time.Time{}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept the next stream opened by the client, on the server end.
+func (s *MuxSession) Accept() (*MuxStream, error) {
+	select {
+	case st := <-s.acceptch:
+		return st, nil
+	case <-s.closech:
+		return nil, s.closeErr()
+	}
+}
+
+// Close the connection and all its streams.
+func (s *MuxSession) Close() error {
+	s.closeWithErr(ErrorMuxClosed)
+	return nil
+}
+
+// IsClosed tells whether the connection is closed.
+func (s *MuxSession) IsClosed() bool {
+	select {
+	case <-s.closech:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumStreams returns the number of open streams.
+func (s *MuxSession) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *MuxSession) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *MuxSession) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *MuxSession) closeWithErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.closech)
+	s.conn.Close()
+}
+
+func (s *MuxSession) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// writeFrame writes a frame to the connection, by deadline if not zero.
+// A failed write closes the connection, as a partial frame cannot be
+// recovered from.
+func (s *MuxSession) writeFrame(id uint32, typ byte, payload []byte, deadline time.Time) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.writeFrameLocked(id, typ, payload, deadline)
+}
+
+func (s *MuxSession) writeFrameLocked(id uint32, typ byte, payload []byte, deadline time.Time) error {
+	if s.IsClosed() {
+		return s.closeErr()
+	}
+
+	buf := s.wbuf[:muxHeaderSize+len(payload)]
+	binary.BigEndian.PutUint32(buf[muxIdOffset:muxIdOffset+muxIdSize], id)
+	buf[muxTypeOffset] = typ
+	binary.BigEndian.PutUint32(buf[muxLenOffset:muxLenOffset+muxLenSize], uint32(len(payload)))
+	copy(buf[muxHeaderSize:], payload)
+
+	s.conn.SetWriteDeadline(deadline)
+	if err := connWrite(s.conn, buf); err != nil {
+		s.closeWithErr(err)
+		return err
+	}
+	return nil
+}
+
+// doReceive routes the frames received to their streams, until the
+// connection is closed.
+func (s *MuxSession) doReceive() {
+	hdr := make([]byte, muxHeaderSize)
+	for {
+		if err := fullRead(s.conn, hdr); err != nil {
+			s.closeWithErr(err)
+			return
+		}
+		id := binary.BigEndian.Uint32(hdr[muxIdOffset : muxIdOffset+muxIdSize])
+		typ := hdr[muxTypeOffset]
+		l := int(binary.BigEndian.Uint32(hdr[muxLenOffset : muxLenOffset+muxLenSize]))
+		if l > muxMaxFrameSize {
+			s.closeWithErr(ErrorMuxProtocol)
+			return
+		}
+
+		payload := make([]byte, l)
+		if err := fullRead(s.conn, payload); err != nil {
+			s.closeWithErr(err)
+			return
+		}
+
+		st, opened, err := s.receiveStream(id, typ)
+		if err != nil {
+			s.closeWithErr(err)
+			return
+		} else if st == nil {
+			continue // stream already closed
+		}
+		if opened {
+			select {
+			case s.acceptch <- st:
+			case <-s.closech:
+				return
+			}
+		}
+
+		switch typ {
+		case muxFrameData:
+			err = st.receiveData(payload)
+		case muxFrameWindow:
+			if len(payload) != 4 {
+				err = ErrorMuxProtocol
+			} else {
+				st.receiveWindow(int(binary.BigEndian.Uint32(payload)))
+			}
+		case muxFrameClose:
+			st.receiveClose()
+		default:
+			err = ErrorMuxProtocol
+		}
+		if err != nil {
+			s.closeWithErr(err)
+			return
+		}
+	}
+}
+
+// receiveStream returns the stream of a frame, opening it if the frame is
+// the first one of a stream opened by the client, or nil if the stream
+// was closed.
+func (s *MuxSession) receiveStream(id uint32, typ byte) (*MuxStream, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.streams[id]; ok {
+		return st, false, nil
+	}
+	if s.client || id <= s.lastId {
+		return nil, false, nil
+	}
+	if typ != muxFrameData || id == 0 {
+		return nil, false, ErrorMuxProtocol
+	}
+
+	s.lastId = id
+	st := newMuxStream(s, id)
+	s.streams[id] = st
+	return st, true, nil
+}
+
+func (s *MuxSession) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// MuxStream is a stream of a multiplexed connection. It implements
+// net.Conn.
+type MuxStream struct {
+	id   uint32
+	sess *MuxSession
+
+	mu           sync.Mutex
+	rbuf         []byte // data received and not yet read
+	unacked      int    // data read and not yet returned as credit
+	credit       int    // data that can be sent
+	localClosed  bool
+	remoteClosed bool
+	rdeadline    time.Time
+	wdeadline    time.Time
+	rnotify      chan struct{}
+	wnotify      chan struct{}
+}
+
+func newMuxStream(s *MuxSession, id uint32) *MuxStream {
+	return &MuxStream{
+		id:      id,
+		sess:    s,
+		credit:  s.window,
+		rnotify: make(chan struct{}, 1),
+		wnotify: make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (st *MuxStream) receiveData(data []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.remoteClosed {
+		return ErrorMuxProtocol
+	}
+	if st.localClosed {
+		return nil
+	}
+	if len(st.rbuf)+len(data) > st.sess.window {
+		return ErrorMuxProtocol // sender overflowed the window
+	}
+	st.rbuf = append(st.rbuf, data...)
+	notify(st.rnotify)
+	return nil
+}
+
+func (st *MuxStream) receiveWindow(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.credit += n
+	notify(st.wnotify)
+}
+
+func (st *MuxStream) receiveClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	closed := st.localClosed
+	st.mu.Unlock()
+
+	notify(st.rnotify)
+	notify(st.wnotify)
+	if closed {
+		st.sess.removeStream(st.id)
+	}
+}
+
+// wait for notify, the deadline or the connection to close.
+func (st *MuxStream) wait(notify chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return muxTimeoutError{}
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-notify:
+		return nil
+	case <-timeout:
+		return muxTimeoutError{}
+	case <-st.sess.closech:
+		return st.sess.closeErr()
+	}
+}
+
+// Read data of the stream. It returns io.EOF once the other end closed
+// the stream and all its data is read.
+func (st *MuxStream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if len(st.rbuf) > 0 {
+			n := copy(b, st.rbuf)
+			st.rbuf = st.rbuf[n:]
+			if len(st.rbuf) == 0 {
+				st.rbuf = nil
+			}
+
+			// Return credit by half windows, to save on frames
+			var credit int
+			st.unacked += n
+			if st.unacked >= st.sess.window/2 && !st.localClosed && !st.remoteClosed {
+				credit, st.unacked = st.unacked, 0
+			}
+			st.mu.Unlock()
+
+			if credit > 0 {
+				var payload [4]byte
+				binary.BigEndian.PutUint32(payload[:], uint32(credit))
+				if err := st.sess.writeFrame(st.id, muxFrameWindow, payload[:], time.Time{}); err != nil {
+					return n, err
+				}
+			}
+			return n, nil
+		}
+
+		localClosed, remoteClosed, deadline := st.localClosed, st.remoteClosed, st.rdeadline
+		st.mu.Unlock()
+
+		if localClosed {
+			return 0, ErrorMuxStreamClosed
+		} else if remoteClosed {
+			return 0, io.EOF
+		}
+		if err := st.wait(st.rnotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write data to the stream, blocking while the window of the stream is
+// full.
+func (st *MuxStream) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		st.mu.Lock()
+		if st.localClosed || st.remoteClosed {
+			st.mu.Unlock()
+			return written, ErrorMuxStreamClosed
+		}
+		deadline := st.wdeadline
+		if st.credit == 0 {
+			st.mu.Unlock()
+			if err := st.wait(st.wnotify, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := len(b)
+		if n > st.credit {
+			n = st.credit
+		}
+		if n > muxMaxFrameSize {
+			n = muxMaxFrameSize
+		}
+		st.credit -= n
+		st.mu.Unlock()
+
+		if err := st.sess.writeFrame(st.id, muxFrameData, b[:n], deadline); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Close the stream. Data not yet read is dropped.
+func (st *MuxStream) Close() error {
+	st.mu.Lock()
+	if st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.rbuf = nil
+	closed := st.remoteClosed
+	st.mu.Unlock()
+
+	notify(st.rnotify)
+	notify(st.wnotify)
+
+	// The other end may still send frames until it receives close, which
+	// are then dropped, unless it closed the stream already.
+	if closed {
+		st.sess.removeStream(st.id)
+	}
+	err := st.sess.writeFrame(st.id, muxFrameClose, nil, time.Time{})
+	if err == ErrorMuxClosed {
+		return nil
+	}
+	return err
+}
+
+// StreamId returns the id of the stream in its connection.
+func (st *MuxStream) StreamId() uint32 {
+	return st.id
+}
+
+func (st *MuxStream) LocalAddr() net.Addr {
+	return st.sess.LocalAddr()
+}
+
+func (st *MuxStream) RemoteAddr() net.Addr {
+	return st.sess.RemoteAddr()
+}
+
+func (st *MuxStream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *MuxStream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.rdeadline = t
+	st.mu.Unlock()
+	notify(st.rnotify) // wake up a read to apply the new deadline
+	return nil
+}
+
+func (st *MuxStream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.wdeadline = t
+	st.mu.Unlock()
+	notify(st.wnotify)
+	return nil
+}
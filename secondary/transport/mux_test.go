@@ -0,0 +1,134 @@
+package transport
+
+import "bytes"
+import "io"
+import "net"
+import "sync"
+import "testing"
+import "time"
+
+func newMuxPipe(window int) (client, server *MuxSession) {
+	c, s := net.Pipe()
+	return NewMuxSession(c, true, window), NewMuxSession(s, false, window)
+}
+
+func TestMuxStreams(t *testing.T) {
+	client, server := newMuxPipe(16 * 1024)
+	defer client.Close()
+	defer server.Close()
+
+	// Echo each stream back
+	go func() {
+		for {
+			st, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(st, st)
+				st.Close()
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errch := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Larger than the window, to block on flow control
+			data := bytes.Repeat([]byte{byte(i)}, 200*1024+i)
+			st, err := client.Open()
+			if err != nil {
+				errch <- err
+				return
+			}
+			go st.Write(data)
+
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(st, got); err != nil {
+				errch <- err
+			} else if !bytes.Equal(got, data) {
+				errch <- ErrorMuxProtocol
+			}
+			st.Close()
+		}(i)
+	}
+	wg.Wait()
+	close(errch)
+	for err := range errch {
+		t.Fatal(err)
+	}
+}
+
+func TestMuxStreamClose(t *testing.T) {
+	client, server := newMuxPipe(DefaultMuxWindow)
+	defer client.Close()
+	defer server.Close()
+
+	cst, _ := client.Open()
+	if _, err := cst.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	sst, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(sst, buf); err != nil || string(buf) != "request" {
+		t.Fatalf("expected request, got %q %v", buf, err)
+	}
+
+	// Closing the stream cancels it on the other end only
+	other, _ := client.Open()
+	cst.Close()
+	if _, err := sst.Read(buf); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if _, err := sst.Write(buf); err != ErrorMuxStreamClosed {
+		t.Fatalf("expected closed stream, got %v", err)
+	}
+	sst.Close()
+
+	if _, err := other.Write([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if sst, err = server.Accept(); err != nil || sst.StreamId() != other.StreamId() {
+		t.Fatalf("expected stream %v, got %v", other.StreamId(), err)
+	}
+
+	for client.NumStreams() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMuxDeadline(t *testing.T) {
+	client, server := newMuxPipe(DefaultMuxWindow)
+	defer server.Close()
+
+	st, _ := client.Open()
+	st.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := st.Read(make([]byte, 1))
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	// Closing the connection fails the streams on both ends
+	sst, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.SetReadDeadline(time.Time{})
+	client.Close()
+	if _, err := st.Read(make([]byte, 1)); err != ErrorMuxClosed {
+		t.Fatalf("expected closed connection, got %v", err)
+	}
+	if _, err := sst.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if _, err := server.Accept(); err == nil {
+		t.Fatalf("expected error on closed connection")
+	}
+}
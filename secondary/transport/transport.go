@@ -10,7 +10,9 @@ package transport
 
 import "errors"
 import "net"
+import "strings"
 import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/golang/snappy"
 
 // error codes
 
@@ -26,6 +28,9 @@ var ErrorEncoderUnknown = errors.New("transport.encoderUnknown")
 // ErrorDecoderUnknown for unknown decoder.
 var ErrorDecoderUnknown = errors.New("transport.decoderUnknown")
 
+// ErrorCompressionUnknown for unknown compression.
+var ErrorCompressionUnknown = errors.New("transport.compressionUnknown")
+
 //ErrorChecksumMismatch for mismatch in checksum
 var ErrorChecksumMismatch = errors.New("transport.checksumUnknown")
 
@@ -134,13 +139,15 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 		return nil, nil
 	}
 
-	pkt.flags = flags
+	// Compression is per packet, sends keep their own
+	compression := flags.GetCompression()
+	pkt.flags = flags.SetCompression(pkt.flags.GetCompression())
 
 	laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
 	logging.Tracef("read %v bytes on connection %v<-%v", len(data), laddr, raddr)
 
 	// de-compression
-	if data, err = pkt.decompress(data); err != nil {
+	if data, err = Decompress(compression, nil, data); err != nil {
 		return
 	}
 	// decoding
@@ -174,20 +181,57 @@ func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error)
 
 // compress array of bytes.
 func (pkt *TransportPacket) compress(big []byte) (small []byte, err error) {
-	switch pkt.flags.GetCompression() {
+	return Compress(pkt.flags.GetCompression(), nil, big)
+}
+
+// Compress data as per `compression`, into dst if it is large enough.
+func Compress(compression byte, dst, data []byte) ([]byte, error) {
+	switch compression {
 	case CompressionNone:
-		small = big
+		return data, nil
+	case CompressionSnappy:
+		return snappy.Encode(dst[:cap(dst)], data), nil
 	}
-	return
+	return nil, ErrorCompressionUnknown
 }
 
-// decompress array of bytes.
-func (pkt *TransportPacket) decompress(small []byte) (big []byte, err error) {
-	switch pkt.flags.GetCompression() {
+// Decompress data compressed as per `compression`, into dst if it is large
+// enough.
+func Decompress(compression byte, dst, data []byte) ([]byte, error) {
+	switch compression {
 	case CompressionNone:
-		big = small
+		return data, nil
+	case CompressionSnappy:
+		return snappy.Decode(dst[:cap(dst)], data)
 	}
-	return
+	return nil, ErrorCompressionUnknown
+}
+
+// CompressionByName returns the supported compression named `name`, as in
+// settings.
+func CompressionByName(name string) (byte, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return CompressionNone, true
+	case "snappy":
+		return CompressionSnappy, true
+	}
+	return CompressionNone, false
+}
+
+// CompressionName returns the name of `compression`, as in settings.
+func CompressionName(compression byte) string {
+	switch compression {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionBzip2:
+		return "bzip2"
+	}
+	return "unknown"
 }
 
 // read len(buf) bytes from `conn`.
@@ -0,0 +1,60 @@
+package transport
+
+import "bytes"
+import "net"
+import "testing"
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("compress"), 1024)
+
+	for _, name := range []string{"none", " Snappy"} {
+		compression, ok := CompressionByName(name)
+		if !ok {
+			t.Fatalf("expected compression %q to be supported", name)
+		}
+		small, err := Compress(compression, make([]byte, 0, 64), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compression != CompressionNone && len(small) >= len(data) {
+			t.Fatalf("expected compression %v to shrink %v bytes, got %v", compression, len(data), len(small))
+		}
+		big, err := Decompress(compression, nil, small)
+		if err != nil || !bytes.Equal(big, data) {
+			t.Fatalf("expected compression %v to round trip, got %v", compression, err)
+		}
+	}
+
+	if _, ok := CompressionByName("gzip"); ok {
+		t.Fatalf("expected gzip to be unsupported")
+	}
+	if _, err := Compress(CompressionGzip, nil, data); err != ErrorCompressionUnknown {
+		t.Fatalf("expected unknown compression, got %v", err)
+	}
+}
+
+func TestReceiveCompressed(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	data := bytes.Repeat([]byte("payload"), 1024)
+	go func() {
+		zdata, _ := Compress(CompressionSnappy, nil, data)
+		buf := make([]byte, MaxSendBufSize)
+		Send(s, buf, TransportFlag(0).SetProtobuf().SetSnappy(), zdata, false)
+	}()
+
+	// Sends after a compressed receive are not compressed
+	pkt := NewTransportPacket(1024*1024, TransportFlag(0).SetProtobuf())
+	pkt.SetDecoder(EncodingProtobuf, func(data []byte) (interface{}, error) {
+		return data, nil
+	})
+	payload, err := pkt.Receive(c)
+	if err != nil || !bytes.Equal(payload.([]byte), data) {
+		t.Fatalf("expected the payload decompressed, got %v", err)
+	}
+	if pkt.flags.GetCompression() != CompressionNone || pkt.flags.GetEncoding() != EncodingProtobuf {
+		t.Fatalf("unexpected flags %v after receive", pkt.flags)
+	}
+}